@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func (cfg *apiConfig) listUsageAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	status := database.NullUsageAlertStatus{}
+	switch statusStr := r.URL.Query().Get("status"); statusStr {
+	case "":
+	case string(database.UsageAlertStatusOpen), string(database.UsageAlertStatusAcknowledged):
+		status = database.NullUsageAlertStatus{UsageAlertStatus: database.UsageAlertStatus(statusStr), Valid: true}
+	default:
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: "status must be open or acknowledged",
+		})
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 200 {
+			respondWithError(w, http.StatusBadRequest, ApiError{
+				Code:    "VALIDATION_ERROR",
+				Message: "limit must be between 1 and 200",
+			})
+			return
+		}
+	}
+
+	alerts, err := cfg.db.ListOrganizationUsageAlerts(r.Context(), database.ListOrganizationUsageAlertsParams{
+		OrganizationID: user.OrganizationID,
+		Status:         status,
+		LimitCount:     int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve alerts",
+		})
+		return
+	}
+
+	alertData := make([]map[string]interface{}, 0, len(alerts))
+	for _, alert := range alerts {
+		alertData = append(alertData, usageAlertData(alert))
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"alerts": alertData,
+		},
+	})
+}
+
+func (cfg *apiConfig) acknowledgeUsageAlertHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	alertID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_ALERT_ID",
+			Message: "Invalid alert ID format",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner && user.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only owner and admin roles can acknowledge alerts",
+		})
+		return
+	}
+
+	alert, err := cfg.db.AcknowledgeUsageAlert(r.Context(), database.AcknowledgeUsageAlertParams{
+		ID:             alertID,
+		OrganizationID: user.OrganizationID,
+		AcknowledgedBy: pgtype.UUID{Bytes: user.ID, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "ALERT_NOT_FOUND",
+			Message: "Alert not found",
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to acknowledge alert",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Alert acknowledged",
+		Data:    usageAlertData(alert),
+	})
+}
+
+func usageAlertData(alert database.UsageAlert) map[string]interface{} {
+	data := map[string]interface{}{
+		"id":        alert.ID,
+		"metric":    alert.Metric,
+		"direction": alert.Direction,
+		"window": map[string]interface{}{
+			"start": alert.WindowStart.Time,
+			"end":   alert.WindowEnd.Time,
+		},
+		"observed":   alert.Observed,
+		"expected":   alert.Expected,
+		"score":      alert.Score,
+		"status":     alert.Status,
+		"created_at": alert.CreatedAt.Time,
+	}
+	if alert.AcknowledgedAt.Valid {
+		data["acknowledged_at"] = alert.AcknowledgedAt.Time
+	}
+	return data
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// analyticsIntervals are the preset windows accepted by the interval parameter
+var analyticsIntervals = map[string]time.Duration{
+	"1h":  time.Hour,
+	"6h":  6 * time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// maxAnalyticsRange caps how much raw usage a single analytics request scans
+const maxAnalyticsRange = 90 * 24 * time.Hour
+
+// parseAnalyticsRange reads either a preset interval (1h, 6h, 24h, 7d, 30d)
+// or explicit RFC 3339 start and end parameters. Times are returned in UTC.
+func parseAnalyticsRange(r *http.Request, defaultInterval string) (time.Time, time.Time, error) {
+	query := r.URL.Query()
+	now := time.Now().UTC()
+
+	if query.Get("start") == "" && query.Get("end") == "" {
+		interval := query.Get("interval")
+		if interval == "" {
+			interval = defaultInterval
+		}
+		window, ok := analyticsIntervals[interval]
+		if !ok {
+			return time.Time{}, time.Time{}, fmt.Errorf("interval must be one of 1h, 6h, 24h, 7d, 30d")
+		}
+		return now.Add(-window), now, nil
+	}
+
+	end := now
+	if endStr := query.Get("end"); endStr != "" {
+		parsed, err := time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("end must be an RFC 3339 timestamp")
+		}
+		end = parsed.UTC()
+	}
+
+	start := end.Add(-analyticsIntervals[defaultInterval])
+	if startStr := query.Get("start"); startStr != "" {
+		parsed, err := time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("start must be an RFC 3339 timestamp")
+		}
+		start = parsed.UTC()
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start must be before end")
+	}
+	if end.Sub(start) > maxAnalyticsRange {
+		return time.Time{}, time.Time{}, fmt.Errorf("range cannot exceed 90 days")
+	}
+
+	return start, end, nil
+}
+
+func (cfg *apiConfig) getLatencyAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	startDate, endDate, err := parseAnalyticsRange(r, "24h")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	startDatePg := pgtype.Timestamp{Time: startDate, Valid: true}
+	endDatePg := pgtype.Timestamp{Time: endDate, Valid: true}
+
+	overall, err := cfg.db.GetLatencyPercentiles(r.Context(), database.GetLatencyPercentilesParams{
+		OrganizationID: user.OrganizationID,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to calculate latency",
+		})
+		return
+	}
+
+	byEndpoint, err := cfg.db.GetLatencyPercentilesByEndpoint(r.Context(), database.GetLatencyPercentilesByEndpointParams{
+		OrganizationID: user.OrganizationID,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
+	})
+	if err != nil {
+		byEndpoint = []database.GetLatencyPercentilesByEndpointRow{}
+	}
+
+	byAPIKey, err := cfg.db.GetLatencyPercentilesByAPIKey(r.Context(), database.GetLatencyPercentilesByAPIKeyParams{
+		OrganizationID: user.OrganizationID,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
+	})
+	if err != nil {
+		byAPIKey = []database.GetLatencyPercentilesByAPIKeyRow{}
+	}
+
+	endpointData := make([]map[string]interface{}, 0, len(byEndpoint))
+	for _, row := range byEndpoint {
+		data := latencyData(row.RequestCount, row.AvgMs, row.P50Ms, row.P95Ms, row.P99Ms, row.MaxMs)
+		data["endpoint"] = row.Endpoint
+		endpointData = append(endpointData, data)
+	}
+
+	apiKeyData := make([]map[string]interface{}, 0, len(byAPIKey))
+	for _, row := range byAPIKey {
+		data := latencyData(row.RequestCount, row.AvgMs, row.P50Ms, row.P95Ms, row.P99Ms, row.MaxMs)
+		data["id"] = row.ID
+		data["name"] = row.Name
+		apiKeyData = append(apiKeyData, data)
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"period": map[string]interface{}{
+				"start": startDate,
+				"end":   endDate,
+			},
+			"overall":     latencyData(overall.RequestCount, overall.AvgMs, overall.P50Ms, overall.P95Ms, overall.P99Ms, overall.MaxMs),
+			"by_endpoint": endpointData,
+			"by_api_key":  apiKeyData,
+		},
+	})
+}
+
+func latencyData(requests int64, avg, p50, p95, p99 float64, maxMs int32) map[string]interface{} {
+	return map[string]interface{}{
+		"requests": requests,
+		"avg_ms":   avg,
+		"p50_ms":   p50,
+		"p95_ms":   p95,
+		"p99_ms":   p99,
+		"max_ms":   maxMs,
+	}
+}
+
+func (cfg *apiConfig) getErrorAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	startDate, endDate, err := parseAnalyticsRange(r, "24h")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	rows, err := cfg.db.GetStatusCodesByEndpoint(r.Context(), database.GetStatusCodesByEndpointParams{
+		OrganizationID: user.OrganizationID,
+		StartTime:      pgtype.Timestamp{Time: startDate, Valid: true},
+		EndTime:        pgtype.Timestamp{Time: endDate, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve error breakdown",
+		})
+		return
+	}
+
+	overall := newStatusBreakdown()
+	endpoints := make(map[string]*statusBreakdown)
+	endpointOrder := make([]string, 0)
+
+	for _, row := range rows {
+		overall.add(row.StatusCode, row.RequestCount)
+
+		breakdown, ok := endpoints[row.Endpoint]
+		if !ok {
+			breakdown = newStatusBreakdown()
+			endpoints[row.Endpoint] = breakdown
+			endpointOrder = append(endpointOrder, row.Endpoint)
+		}
+		breakdown.add(row.StatusCode, row.RequestCount)
+	}
+
+	endpointData := make([]map[string]interface{}, 0, len(endpointOrder))
+	for _, endpoint := range endpointOrder {
+		data := endpoints[endpoint].data()
+		data["endpoint"] = endpoint
+		endpointData = append(endpointData, data)
+	}
+	sort.SliceStable(endpointData, func(i, j int) bool {
+		return endpointData[i]["errors"].(int64) > endpointData[j]["errors"].(int64)
+	})
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"period": map[string]interface{}{
+				"start": startDate,
+				"end":   endDate,
+			},
+			"summary":     overall.data(),
+			"by_endpoint": endpointData,
+		},
+	})
+}
+
+// statusBreakdown totals requests by status class and by exact status code
+type statusBreakdown struct {
+	total   int64
+	errors  int64
+	classes map[string]int64
+	codes   map[int32]int64
+}
+
+func newStatusBreakdown() *statusBreakdown {
+	return &statusBreakdown{
+		classes: map[string]int64{"2xx": 0, "3xx": 0, "4xx": 0, "5xx": 0},
+		codes:   make(map[int32]int64),
+	}
+}
+
+func (b *statusBreakdown) add(statusCode int32, count int64) {
+	b.total += count
+	b.classes[fmt.Sprintf("%dxx", statusCode/100)] += count
+	b.codes[statusCode] += count
+	if statusCode >= 400 {
+		b.errors += count
+	}
+}
+
+func (b *statusBreakdown) data() map[string]interface{} {
+	codes := make([]int32, 0, len(b.codes))
+	for code := range b.codes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	byStatusCode := make([]map[string]interface{}, 0, len(codes))
+	for _, code := range codes {
+		byStatusCode = append(byStatusCode, map[string]interface{}{
+			"status_code": code,
+			"requests":    b.codes[code],
+		})
+	}
+
+	errorRate := 0.0
+	if b.total > 0 {
+		errorRate = (float64(b.errors) / float64(b.total)) * 100
+	}
+
+	return map[string]interface{}{
+		"requests":       b.total,
+		"errors":         b.errors,
+		"error_rate":     errorRate,
+		"by_class":       b.classes,
+		"by_status_code": byStatusCode,
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseAnalyticsRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantWindow time.Duration
+		wantStart  time.Time
+		wantErr    bool
+	}{
+		{
+			name:       "Default interval",
+			query:      "",
+			wantWindow: 24 * time.Hour,
+		},
+		{
+			name:       "Preset interval",
+			query:      "interval=7d",
+			wantWindow: 7 * 24 * time.Hour,
+		},
+		{
+			name:    "Unknown interval",
+			query:   "interval=2w",
+			wantErr: true,
+		},
+		{
+			name:       "Explicit range",
+			query:      "start=2026-10-01T00:00:00Z&end=2026-10-02T12:00:00Z",
+			wantWindow: 36 * time.Hour,
+			wantStart:  time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:       "Offset start is converted to UTC",
+			query:      "start=2026-10-01T01:00:00%2B01:00&end=2026-10-01T06:00:00Z",
+			wantWindow: 6 * time.Hour,
+			wantStart:  time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "Start after end",
+			query:   "start=2026-10-02T00:00:00Z&end=2026-10-01T00:00:00Z",
+			wantErr: true,
+		},
+		{
+			name:    "Range too long",
+			query:   "start=2026-01-01T00:00:00Z&end=2026-10-01T00:00:00Z",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid timestamp",
+			query:   "start=yesterday",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/analytics/latency?"+tt.query, nil)
+			start, end, err := parseAnalyticsRange(req, "24h")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAnalyticsRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := end.Sub(start); got != tt.wantWindow {
+				t.Errorf("window = %v, want %v", got, tt.wantWindow)
+			}
+			if !tt.wantStart.IsZero() && !start.Equal(tt.wantStart) {
+				t.Errorf("start = %v, want %v", start, tt.wantStart)
+			}
+			if start.Location() != time.UTC {
+				t.Errorf("start location = %v, want UTC", start.Location())
+			}
+		})
+	}
+}
+
+func TestStatusBreakdown(t *testing.T) {
+	breakdown := newStatusBreakdown()
+	breakdown.add(200, 90)
+	breakdown.add(404, 6)
+	breakdown.add(429, 2)
+	breakdown.add(503, 2)
+
+	data := breakdown.data()
+
+	if got := data["requests"].(int64); got != 100 {
+		t.Errorf("requests = %d, want 100", got)
+	}
+	if got := data["errors"].(int64); got != 10 {
+		t.Errorf("errors = %d, want 10", got)
+	}
+	if got := data["error_rate"].(float64); got != 10 {
+		t.Errorf("error_rate = %v, want 10", got)
+	}
+
+	classes := data["by_class"].(map[string]int64)
+	want := map[string]int64{"2xx": 90, "3xx": 0, "4xx": 8, "5xx": 2}
+	for class, count := range want {
+		if classes[class] != count {
+			t.Errorf("by_class[%s] = %d, want %d", class, classes[class], count)
+		}
+	}
+
+	codes := data["by_status_code"].([]map[string]interface{})
+	if len(codes) != 4 || codes[0]["status_code"].(int32) != 200 || codes[3]["status_code"].(int32) != 503 {
+		t.Errorf("by_status_code not sorted by code: %v", codes)
+	}
+}
@@ -1,118 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
-	"github.com/Mekazstan/multi-tenant-saas-api/internal/email"
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
 	"github.com/stripe/stripe-go/v76"
 )
 
-func (cfg *apiConfig) upgradePlanHandler(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Plan string `json:"plan"`
-	}
-
-	userID, ok := GetUserID(r.Context())
-	if !ok {
-		respondWithError(w, http.StatusUnauthorized, ApiError{
-			Code:    "UNAUTHORIZED",
-			Message: "User not authenticated",
-		})
-		return
-	}
-
-	var params parameters
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-		respondWithError(w, http.StatusBadRequest, ApiError{
-			Code:    "INVALID_REQUEST",
-			Message: "Invalid request body",
-		})
-		return
-	}
-
-	user, err := cfg.db.GetUser(r.Context(), userID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, ApiError{
-			Code:    "INTERNAL_ERROR",
-			Message: "Failed to retrieve user details",
-		})
-		return
-	}
-
-	if user.Role != database.UserRoleOwner {
-		respondWithError(w, http.StatusForbidden, ApiError{
-			Code:    "PERMISSION_DENIED",
-			Message: "Only organization owner can upgrade plan",
-		})
-		return
-	}
-
-	var newPlan database.PlanType
-	switch params.Plan {
-	case "starter":
-		newPlan = database.PlanTypeStarter
-	case "pro":
-		newPlan = database.PlanTypePro
-	case "free":
-		newPlan = database.PlanTypeFree
-	default:
-		respondWithError(w, http.StatusBadRequest, ApiError{
-			Code:    "INVALID_PLAN",
-			Message: "Plan must be 'free', 'starter', or 'pro'",
-		})
-		return
-	}
-
-	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, ApiError{
-			Code:    "INTERNAL_ERROR",
-			Message: "Failed to retrieve organization",
-		})
-		return
-	}
-
-	if newPlan == org.Plan {
-		respondWithError(w, http.StatusBadRequest, ApiError{
-			Code:    "SAME_PLAN",
-			Message: "Organization is already on this plan",
-		})
-		return
-	}
-
-	updatedOrg, err := cfg.db.UpdateOrganizationPlan(r.Context(), database.UpdateOrganizationPlanParams{
-		Plan: newPlan,
-		ID:   user.OrganizationID,
-	})
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, ApiError{
-			Code:    "INTERNAL_ERROR",
-			Message: "Failed to update plan",
-		})
-		return
-	}
-
-	respondWithJSON(w, http.StatusOK, ApiResponse{
-		Success: true,
-		Message: "Plan upgraded successfully",
-		Data: map[string]interface{}{
-			"organization": map[string]interface{}{
-				"id":         updatedOrg.ID,
-				"name":       updatedOrg.Name,
-				"plan":       updatedOrg.Plan,
-				"updated_at": updatedOrg.UpdatedAt,
-			},
-		},
-	})
-}
-
 func (cfg *apiConfig) initiatePaymentHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		BillingCycleID string `json:"billing_cycle_id"`
@@ -183,6 +90,13 @@ func (cfg *apiConfig) initiatePaymentHandler(w http.ResponseWriter, r *http.Requ
 		})
 		return
 	}
+	if cycle.Status == database.BillingStatusVoid {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVOICE_VOID",
+			Message: "This invoice has been voided and cannot be paid",
+		})
+		return
+	}
 
 	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
 	if err != nil {
@@ -193,88 +107,113 @@ func (cfg *apiConfig) initiatePaymentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	amountFloat, err := cycle.TotalAmount.Float64Value()
-	if err != nil || !amountFloat.Valid {
-		respondWithError(w, http.StatusInternalServerError, ApiError{
+	data, status, apiErr := cfg.invoicePayment(r.Context(), org, cycle, params.Provider)
+	if apiErr != nil {
+		respondWithError(w, status, *apiErr)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// invoicePayment opens a payment with provider for an invoice's own amount
+// and currency. On failure it returns the status and error to respond with.
+func (cfg *apiConfig) invoicePayment(ctx context.Context, org database.Organization, cycle database.BillingCycle, provider string) (map[string]interface{}, int, *ApiError) {
+	// Providers charge in the currency's minor unit
+	amount := currency.FromNumeric(cycle.TotalAmount)
+	amountMinor, err := currency.ToMinorUnits(amount, cycle.Currency)
+	if err != nil {
+		return nil, http.StatusInternalServerError, &ApiError{
 			Code:    "INTERNAL_ERROR",
 			Message: "Invalid amount",
-		})
-		return
+		}
 	}
 
 	var paymentURL string
 	var providerResponse interface{}
 
-	switch params.Provider {
+	switch provider {
 	case "stripe":
-		amountCents := int64(amountFloat.Float64 * 100)
-
 		session, err := cfg.paymentService.Stripe.CreateCheckoutSession(payment.CheckoutSessionParams{
 			OrganizationID: org.ID.String(),
 			BillingCycleID: cycle.ID.String(),
-			Amount:         amountCents,
-			Currency:       "usd",
+			InvoiceNumber:  cycle.InvoiceNumber,
+			Amount:         amountMinor,
+			Currency:       strings.ToLower(cycle.Currency),
 			SuccessURL:     cfg.config.AppURL + "/billing/success?session_id={CHECKOUT_SESSION_ID}",
 			CancelURL:      cfg.config.AppURL + "/billing/cancel",
 			CustomerEmail:  org.Email,
 		})
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, ApiError{
+			return nil, http.StatusInternalServerError, &ApiError{
 				Code:    "PAYMENT_ERROR",
 				Message: "Failed to create payment session",
 				Details: err.Error(),
-			})
-			return
+			}
 		}
 		paymentURL = session.URL
 		providerResponse = session
 
 	case "paystack":
-		amountKobo := int64(amountFloat.Float64 * 100)
+		if !cfg.paymentService.Paystack.SupportsCurrency(cycle.Currency) {
+			return nil, http.StatusBadRequest, &ApiError{
+				Code:    "UNSUPPORTED_CURRENCY",
+				Message: fmt.Sprintf("Paystack cannot charge invoices in %s", cycle.Currency),
+			}
+		}
 
-		reference := fmt.Sprintf("INV_%s_%s", org.ID.String()[:8], cycle.ID.String()[:8])
+		// Paystack refuses a reference it has seen, and a checkout may be
+		// abandoned and opened again
+		attempt, err := cfg.db.NextPaymentAttempt(ctx, cycle.ID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, &ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to create payment session",
+			}
+		}
 
 		response, err := cfg.paymentService.Paystack.InitializeTransaction(payment.PaystackInitializeParams{
 			Email:       org.Email,
-			Amount:      amountKobo,
-			Currency:    "NGN",
-			Reference:   reference,
+			Amount:      amountMinor,
+			Currency:    cycle.Currency,
+			Reference:   fmt.Sprintf("%s-%d", cycle.InvoiceNumber, attempt),
 			CallbackURL: cfg.config.AppURL + "/billing/paystack/callback",
 			Metadata: map[string]string{
 				"organization_id":  org.ID.String(),
 				"billing_cycle_id": cycle.ID.String(),
+				"invoice_number":   cycle.InvoiceNumber,
 			},
 		})
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, ApiError{
+			return nil, http.StatusInternalServerError, &ApiError{
 				Code:    "PAYMENT_ERROR",
 				Message: "Failed to create payment session",
 				Details: err.Error(),
-			})
-			return
+			}
 		}
 		paymentURL = response.Data.AuthorizationURL
 		providerResponse = response
 
 	default:
-		respondWithError(w, http.StatusBadRequest, ApiError{
+		return nil, http.StatusBadRequest, &ApiError{
 			Code:    "INVALID_PROVIDER",
 			Message: "Payment provider must be 'stripe' or 'paystack'",
-		})
-		return
+		}
 	}
 
-	respondWithJSON(w, http.StatusOK, ApiResponse{
-		Success: true,
-		Data: map[string]interface{}{
-			"payment_url":      paymentURL,
-			"amount":           amountFloat.Float64,
-			"billing_cycle_id": cycle.ID,
-			"provider":         params.Provider,
-			"status":           cycle.Status,
-			"provider_data":    providerResponse,
-		},
-	})
+	return map[string]interface{}{
+		"payment_url":      paymentURL,
+		"amount":           currency.Round(amount, cycle.Currency).StringFixed(currency.Exponent(cycle.Currency)),
+		"currency":         cycle.Currency,
+		"billing_cycle_id": cycle.ID,
+		"invoice_number":   cycle.InvoiceNumber,
+		"provider":         provider,
+		"status":           cycle.Status,
+		"provider_data":    providerResponse,
+	}, http.StatusOK, nil
 }
 
 func (cfg *apiConfig) stripeWebhookHandler(w http.ResponseWriter, r *http.Request) {
@@ -314,8 +253,24 @@ func (cfg *apiConfig) stripeWebhookHandler(w http.ResponseWriter, r *http.Reques
 			return
 		}
 
+		if topUpID := session.Metadata["top_up_id"]; topUpID != "" {
+			id, err := uuid.Parse(topUpID)
+			if err != nil {
+				log.Printf("Invalid top-up ID: %s", topUpID)
+			} else if err := cfg.completeTopUp(r.Context(), id, session.AmountTotal, string(session.Currency)); err != nil {
+				log.Printf("Failed to add credit from Stripe session %s: %v", session.ID, err)
+			}
+			break
+		}
+
+		if session.Mode == stripe.CheckoutSessionModeSetup {
+			if err := cfg.saveStripePaymentMethod(r.Context(), session); err != nil {
+				log.Printf("Failed to save payment method from Stripe session %s: %v", session.ID, err)
+			}
+			break
+		}
+
 		billingCycleID := session.Metadata["billing_cycle_id"]
-		organizationID := session.Metadata["organization_id"]
 
 		if billingCycleID == "" {
 			log.Printf("Missing billing_cycle_id in metadata")
@@ -337,6 +292,17 @@ func (cfg *apiConfig) stripeWebhookHandler(w http.ResponseWriter, r *http.Reques
 			return
 		}
 
+		// An invoice already paid some other way is left alone
+		if cycle.Status == database.BillingStatusPaid {
+			break
+		}
+
+		if err := checkPaidAmount(cycle, session.AmountTotal, string(session.Currency)); err != nil {
+			log.Printf("Not marking invoice %s paid from Stripe session %s: %v", cycle.InvoiceNumber, session.ID, err)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
 		_, err = cfg.db.UpdateBillingCycleStatus(r.Context(), database.UpdateBillingCycleStatusParams{
 			Status: database.BillingStatusPaid,
 			ID:     cycleID,
@@ -347,23 +313,62 @@ func (cfg *apiConfig) stripeWebhookHandler(w http.ResponseWriter, r *http.Reques
 			return
 		}
 
-		orgID, _ := uuid.Parse(organizationID)
-		org, err := cfg.db.GetOrganization(r.Context(), orgID)
+		go cfg.sendPaymentReceipt(cycleID)
+
+		log.Printf("Successfully processed Stripe payment for cycle %s", cycleID)
+
+	case "checkout.session.expired":
+		var session stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
+			log.Printf("Failed to parse checkout session: %v", err)
+			break
+		}
+
+		// Abandoned top-ups are closed; invoices can simply be paid again
+		if id, err := uuid.Parse(session.Metadata["top_up_id"]); err == nil {
+			if _, err := cfg.db.FailCreditTopUp(r.Context(), id); err != nil {
+				log.Printf("Failed to close expired top-up %s: %v", id, err)
+			}
+		}
+
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted",
+		"customer.subscription.paused", "customer.subscription.resumed":
+		var eventSub stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &eventSub); err != nil {
+			log.Printf("Failed to parse subscription: %v", err)
+			break
+		}
+
+		sub, err := cfg.paymentService.Stripe.GetSubscription(eventSub.ID)
 		if err != nil {
-			log.Printf("Failed to get organization: %v", err)
-		} else {
-			go func() {
-				amountFloat, _ := cycle.TotalAmount.Float64Value()
-				cfg.emailService.SendPaymentSuccess(org.Email, email.PaymentSuccessData{
-					OrganizationName: org.Name,
-					Amount:           amountFloat.Float64,
-					InvoiceNumber:    cycle.ID.String()[:8],
-					ReceiptURL:       cfg.config.AppURL + "/billing/receipt/" + cycle.ID.String(),
-				})
-			}()
+			// Stripe retries the event until it is acknowledged
+			log.Printf("Failed to fetch Stripe subscription %s: %v", eventSub.ID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if _, err := cfg.syncStripeSubscription(r.Context(), sub); err != nil {
+			log.Printf("Failed to sync Stripe subscription %s: %v", sub.ID, err)
+			break
 		}
 
-		log.Printf("Successfully processed Stripe payment for cycle %s", cycleID)
+		log.Printf("Synced Stripe subscription %s (%s) from %s", sub.ID, sub.Status, event.Type)
+
+	case "invoice.created", "invoice.finalized", "invoice.paid", "invoice.payment_failed",
+		"invoice.payment_action_required", "invoice.voided", "invoice.marked_uncollectible":
+		var invoice stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+			log.Printf("Failed to parse invoice: %v", err)
+			break
+		}
+
+		if err := cfg.recordStripeInvoice(r.Context(), invoice); err != nil {
+			log.Printf("Failed to record Stripe invoice %s: %v", invoice.ID, err)
+			break
+		}
+
+		if event.Type == "invoice.payment_failed" {
+			log.Printf("Stripe invoice %s failed to charge (attempt %d)", invoice.ID, invoice.AttemptCount)
+		}
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -413,6 +418,30 @@ func (cfg *apiConfig) paystackWebhookHandler(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
+		// Any reusable card an organization pays with is saved for charges
+		// made without it present
+		if auth, ok := event.Authorization(); ok && auth.Reusable {
+			if orgID, err := uuid.Parse(fmt.Sprint(metadata["organization_id"])); err == nil {
+				if err := cfg.savePaystackPaymentMethod(r.Context(), orgID, auth); err != nil {
+					log.Printf("Failed to save Paystack authorization for org %s: %v", orgID, err)
+				}
+			}
+		}
+
+		// Paystack sends amounts as JSON numbers, in the currency's subunit
+		paidAmount, _ := event.Data["amount"].(float64)
+		paidCurrency, _ := event.Data["currency"].(string)
+
+		if topUpID, _ := metadata["top_up_id"].(string); topUpID != "" {
+			id, err := uuid.Parse(topUpID)
+			if err != nil {
+				log.Printf("Invalid top-up ID: %s", topUpID)
+			} else if err := cfg.completeTopUp(r.Context(), id, int64(paidAmount), paidCurrency); err != nil {
+				log.Printf("Failed to add credit from Paystack charge: %v", err)
+			}
+			break
+		}
+
 		billingCycleID, ok := metadata["billing_cycle_id"].(string)
 		if !ok || billingCycleID == "" {
 			log.Printf("Missing billing_cycle_id in metadata")
@@ -420,8 +449,6 @@ func (cfg *apiConfig) paystackWebhookHandler(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
-		organizationID, _ := metadata["organization_id"].(string)
-
 		cycleID, err := uuid.Parse(billingCycleID)
 		if err != nil {
 			log.Printf("Invalid billing cycle ID: %s", billingCycleID)
@@ -436,6 +463,18 @@ func (cfg *apiConfig) paystackWebhookHandler(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
+		// Invoices charged to a saved authorization are marked paid as they
+		// are charged
+		if cycle.Status == database.BillingStatusPaid {
+			break
+		}
+
+		if err := checkPaidAmount(cycle, int64(paidAmount), paidCurrency); err != nil {
+			log.Printf("Not marking invoice %s paid from Paystack charge: %v", cycle.InvoiceNumber, err)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
 		_, err = cfg.db.UpdateBillingCycleStatus(r.Context(), database.UpdateBillingCycleStatusParams{
 			Status: database.BillingStatusPaid,
 			ID:     cycleID,
@@ -446,23 +485,7 @@ func (cfg *apiConfig) paystackWebhookHandler(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
-		if organizationID != "" {
-			orgID, _ := uuid.Parse(organizationID)
-			org, err := cfg.db.GetOrganization(r.Context(), orgID)
-			if err != nil {
-				log.Printf("Failed to get organization: %v", err)
-			} else {
-				go func() {
-					amountFloat, _ := cycle.TotalAmount.Float64Value()
-					cfg.emailService.SendPaymentSuccess(org.Email, email.PaymentSuccessData{
-						OrganizationName: org.Name,
-						Amount:           amountFloat.Float64,
-						InvoiceNumber:    cycle.ID.String()[:8],
-						ReceiptURL:       cfg.config.AppURL + "/billing/receipt/" + cycle.ID.String(),
-					})
-				}()
-			}
-		}
+		go cfg.sendPaymentReceipt(cycleID)
 
 		log.Printf("Successfully processed Paystack payment for cycle %s", cycleID)
 	}
@@ -470,3 +493,170 @@ func (cfg *apiConfig) paystackWebhookHandler(w http.ResponseWriter, r *http.Requ
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "received"})
 }
+
+// checkPaidAmount verifies a provider charged an invoice's full amount in its
+// currency. amountMinor is in the currency's minor unit.
+func checkPaidAmount(cycle database.BillingCycle, amountMinor int64, code string) error {
+	return checkChargedAmount(currency.FromNumeric(cycle.TotalAmount), cycle.Currency, amountMinor, code)
+}
+
+// checkChargedAmount verifies a provider charged at least due in dueCurrency.
+// amountMinor is in the minor unit of code, the currency charged.
+func checkChargedAmount(due decimal.Decimal, dueCurrency string, amountMinor int64, code string) error {
+	if !strings.EqualFold(code, dueCurrency) {
+		return fmt.Errorf("paid in %s, %s is due", strings.ToUpper(code), dueCurrency)
+	}
+	dueMinor, err := currency.ToMinorUnits(due, dueCurrency)
+	if err != nil {
+		return err
+	}
+	if amountMinor < dueMinor {
+		return fmt.Errorf("paid %d, %d due (minor units of %s)", amountMinor, dueMinor, dueCurrency)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) getQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization",
+		})
+		return
+	}
+
+	periodStart, periodEnd := quota.CurrentPeriod(time.Now())
+
+	used, err := cfg.quotaTracker.Usage(r.Context(), org.ID)
+	if err != nil {
+		used, err = cfg.db.CountOrganizationUsage(r.Context(), database.CountOrganizationUsageParams{
+			OrganizationID: org.ID,
+			StartTime:      pgtype.Timestamp{Time: periodStart, Valid: true},
+			EndTime:        pgtype.Timestamp{Time: periodEnd, Valid: true},
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to retrieve quota usage",
+			})
+			return
+		}
+	}
+
+	limit := quota.MonthlyLimit(org.Plan)
+	remaining := max(limit-used, 0)
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"plan":      org.Plan,
+			"policy":    org.QuotaPolicy,
+			"limit":     limit,
+			"used":      used,
+			"remaining": remaining,
+			"exceeded":  used > limit,
+			"period": map[string]interface{}{
+				"start": periodStart,
+				"end":   periodEnd,
+			},
+			"resets_at": periodEnd.Add(time.Second),
+		},
+	})
+}
+
+func (cfg *apiConfig) updateQuotaPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Policy string `json:"policy"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can change the quota policy",
+		})
+		return
+	}
+
+	var policy database.QuotaPolicy
+	switch params.Policy {
+	case "block":
+		policy = database.QuotaPolicyBlock
+	case "overage":
+		policy = database.QuotaPolicyOverage
+	case "notify":
+		policy = database.QuotaPolicyNotify
+	default:
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_POLICY",
+			Message: "Policy must be 'block', 'overage', or 'notify'",
+		})
+		return
+	}
+
+	org, err := cfg.db.UpdateOrganizationQuotaPolicy(r.Context(), database.UpdateOrganizationQuotaPolicyParams{
+		QuotaPolicy: policy,
+		ID:          user.OrganizationID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to update quota policy",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Quota policy updated successfully",
+		Data: map[string]interface{}{
+			"policy": org.QuotaPolicy,
+			"limit":  quota.MonthlyLimit(org.Plan),
+		},
+	})
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// defaultBudgetThresholds are used when a budget is saved without thresholds
+var defaultBudgetThresholds = []int32{50, 80, 100}
+
+// validateBudgetThresholds checks that thresholds are distinct percentages
+// between 1 and 1000 and returns them sorted
+func validateBudgetThresholds(thresholds []int32) ([]int32, error) {
+	if len(thresholds) == 0 {
+		return defaultBudgetThresholds, nil
+	}
+	if len(thresholds) > 10 {
+		return nil, fmt.Errorf("at most 10 thresholds are allowed")
+	}
+
+	sorted := slices.Clone(thresholds)
+	slices.Sort(sorted)
+	for i, threshold := range sorted {
+		if threshold < 1 || threshold > 1000 {
+			return nil, fmt.Errorf("thresholds must be percentages between 1 and 1000")
+		}
+		if i > 0 && sorted[i-1] == threshold {
+			return nil, fmt.Errorf("thresholds must be distinct")
+		}
+	}
+	return sorted, nil
+}
+
+func (cfg *apiConfig) getUsageBudgetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	budget, err := cfg.db.GetUsageBudget(r.Context(), user.OrganizationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "BUDGET_NOT_FOUND",
+			Message: "No usage budget is configured",
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve usage budget",
+		})
+		return
+	}
+
+	periodStart, periodEnd := quota.CurrentPeriod(time.Now())
+	notifications, err := cfg.db.ListUsageBudgetNotifications(r.Context(), database.ListUsageBudgetNotificationsParams{
+		OrganizationID: user.OrganizationID,
+		PeriodStart:    pgtype.Timestamp{Time: periodStart, Valid: true},
+	})
+	if err != nil {
+		notifications = []database.UsageBudgetNotification{}
+	}
+
+	reached := make([]map[string]interface{}, 0, len(notifications))
+	for _, notification := range notifications {
+		reached = append(reached, map[string]interface{}{
+			"threshold":  notification.Threshold,
+			"usage":      currency.FromNumeric(notification.Usage).StringFixed(2),
+			"reached_at": notification.CreatedAt.Time,
+		})
+	}
+
+	data := usageBudgetData(budget)
+	data["period"] = map[string]interface{}{
+		"start": periodStart,
+		"end":   periodEnd,
+	}
+	data["reached"] = reached
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+func (cfg *apiConfig) updateUsageBudgetHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Metric             string          `json:"metric"`
+		Amount             decimal.Decimal `json:"amount"`
+		Thresholds         []int32         `json:"thresholds"`
+		DisableKeysAtLimit bool            `json:"disable_keys_at_limit"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can change the usage budget",
+		})
+		return
+	}
+
+	var metric database.BudgetMetric
+	switch params.Metric {
+	case "", "requests":
+		metric = database.BudgetMetricRequests
+	case "spend":
+		metric = database.BudgetMetricSpend
+	default:
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: "Metric must be 'requests' or 'spend'",
+		})
+		return
+	}
+
+	if !params.Amount.IsPositive() {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: "Amount must be greater than zero",
+			Details: map[string]interface{}{
+				"field": "amount",
+			},
+		})
+		return
+	}
+
+	thresholds, err := validateBudgetThresholds(params.Thresholds)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+			Details: map[string]interface{}{
+				"field": "thresholds",
+			},
+		})
+		return
+	}
+
+	amountValue := params.Amount.Round(2)
+	amount := pgtype.Numeric{}
+	if err := amount.Scan(amountValue.String()); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: "Invalid amount",
+		})
+		return
+	}
+
+	previous, err := cfg.db.GetUsageBudget(r.Context(), user.OrganizationID)
+	hadBudget := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve usage budget",
+		})
+		return
+	}
+
+	budget, err := cfg.db.UpsertUsageBudget(r.Context(), database.UpsertUsageBudgetParams{
+		OrganizationID:     user.OrganizationID,
+		Metric:             metric,
+		Amount:             amount,
+		Thresholds:         thresholds,
+		DisableKeysAtLimit: params.DisableKeysAtLimit,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to save usage budget",
+		})
+		return
+	}
+
+	// Saving the budget unchanged keeps the thresholds already reached, so
+	// they are not notified again
+	switch {
+	case !hadBudget || budgetChanged(previous, metric, amountValue, thresholds):
+		cfg.resetUsageBudget(r, user.OrganizationID)
+	case previous.DisableKeysAtLimit && !params.DisableKeysAtLimit:
+		if _, err := cfg.db.ResumeSuspendedAPIKeys(r.Context(), user.OrganizationID); err != nil {
+			log.Printf("Failed to restore suspended API keys for org %s: %v", user.OrganizationID, err)
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Usage budget saved successfully",
+		Data:    usageBudgetData(budget),
+	})
+}
+
+func (cfg *apiConfig) deleteUsageBudgetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can change the usage budget",
+		})
+		return
+	}
+
+	if err := cfg.db.DeleteUsageBudget(r.Context(), user.OrganizationID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to delete usage budget",
+		})
+		return
+	}
+
+	cfg.resetUsageBudget(r, user.OrganizationID)
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Usage budget deleted successfully",
+	})
+}
+
+// resetUsageBudget forgets the thresholds reached this period and restores
+// keys the old budget suspended, so a changed budget is judged afresh
+func (cfg *apiConfig) resetUsageBudget(r *http.Request, orgID uuid.UUID) {
+	periodStart, _ := quota.CurrentPeriod(time.Now())
+
+	err := cfg.db.DeleteUsageBudgetNotifications(r.Context(), database.DeleteUsageBudgetNotificationsParams{
+		OrganizationID: orgID,
+		PeriodStart:    pgtype.Timestamp{Time: periodStart, Valid: true},
+	})
+	if err != nil {
+		log.Printf("Failed to reset budget notifications for org %s: %v", orgID, err)
+	}
+
+	if _, err := cfg.db.ResumeSuspendedAPIKeys(r.Context(), orgID); err != nil {
+		log.Printf("Failed to restore suspended API keys for org %s: %v", orgID, err)
+	}
+}
+
+// budgetChanged reports whether a budget is measured differently from the
+// one saved before: another metric, amount or set of thresholds
+func budgetChanged(previous database.UsageBudget, metric database.BudgetMetric, amount decimal.Decimal, thresholds []int32) bool {
+	return previous.Metric != metric ||
+		!currency.FromNumeric(previous.Amount).Equal(amount) ||
+		!slices.Equal(previous.Thresholds, thresholds)
+}
+
+func usageBudgetData(budget database.UsageBudget) map[string]interface{} {
+	return map[string]interface{}{
+		"metric":                budget.Metric,
+		"amount":                currency.FromNumeric(budget.Amount).StringFixed(2),
+		"thresholds":            budget.Thresholds,
+		"disable_keys_at_limit": budget.DisableKeysAtLimit,
+		"updated_at":            budget.UpdatedAt.Time,
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+func TestValidateBudgetThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds []int32
+		want       []int32
+		wantErr    bool
+	}{
+		{
+			name:       "Defaults when empty",
+			thresholds: nil,
+			want:       []int32{50, 80, 100},
+		},
+		{
+			name:       "Sorted",
+			thresholds: []int32{100, 25, 75},
+			want:       []int32{25, 75, 100},
+		},
+		{
+			name:       "Above 100 percent",
+			thresholds: []int32{100, 150},
+			want:       []int32{100, 150},
+		},
+		{
+			name:       "Duplicate",
+			thresholds: []int32{50, 50},
+			wantErr:    true,
+		},
+		{
+			name:       "Out of range",
+			thresholds: []int32{0, 50},
+			wantErr:    true,
+		},
+		{
+			name:       "Too many",
+			thresholds: []int32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateBudgetThresholds(tt.thresholds)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateBudgetThresholds() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("validateBudgetThresholds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBudgetChanged(t *testing.T) {
+	var amount pgtype.Numeric
+	if err := amount.Scan("250.00"); err != nil {
+		t.Fatal(err)
+	}
+	previous := database.UsageBudget{
+		Metric:     database.BudgetMetricSpend,
+		Amount:     amount,
+		Thresholds: []int32{50, 80, 100},
+	}
+
+	tests := []struct {
+		name       string
+		metric     database.BudgetMetric
+		amount     string
+		thresholds []int32
+		want       bool
+	}{
+		{
+			name:       "Saved again unchanged",
+			metric:     database.BudgetMetricSpend,
+			amount:     "250",
+			thresholds: []int32{50, 80, 100},
+			want:       false,
+		},
+		{
+			name:       "Amount raised",
+			metric:     database.BudgetMetricSpend,
+			amount:     "300",
+			thresholds: []int32{50, 80, 100},
+			want:       true,
+		},
+		{
+			name:       "Metric switched",
+			metric:     database.BudgetMetricRequests,
+			amount:     "250",
+			thresholds: []int32{50, 80, 100},
+			want:       true,
+		},
+		{
+			name:       "Threshold added",
+			metric:     database.BudgetMetricSpend,
+			amount:     "250",
+			thresholds: []int32{50, 80, 90, 100},
+			want:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := budgetChanged(previous, tt.metric, decimal.RequireFromString(tt.amount), tt.thresholds)
+			if got != tt.want {
+				t.Errorf("budgetChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
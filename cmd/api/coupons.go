@@ -0,0 +1,510 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/coupon"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// redeemCouponHandler gives the organization a coupon's discount on its
+// upcoming invoices. An organization has one discount at a time.
+func (cfg *apiConfig) redeemCouponHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can redeem coupons",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization",
+		})
+		return
+	}
+
+	row, err := cfg.db.GetCouponByCode(r.Context(), coupon.NormalizeCode(params.Code))
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "COUPON_NOT_FOUND",
+			Message: "No coupon has this code",
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve coupon",
+		})
+		return
+	}
+
+	c := coupon.FromRow(row)
+	if err := c.Redeemable(time.Now()); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "COUPON_NOT_REDEEMABLE",
+			Message: err.Error(),
+		})
+		return
+	}
+	if !c.AppliesTo(org.Plan) {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "COUPON_NOT_APPLICABLE",
+			Message: "This coupon does not apply to the " + string(org.Plan) + " plan",
+			Details: map[string]interface{}{
+				"plans": c.Plans,
+			},
+		})
+		return
+	}
+
+	tx, err := cfg.pool.Begin(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to redeem coupon",
+		})
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	qtx := cfg.db.WithTx(tx)
+
+	// Claiming counts the redemption against the coupon's limit; another
+	// redemption may have used up the last one since it was read
+	row, err = qtx.ClaimCoupon(r.Context(), row.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "COUPON_NOT_REDEEMABLE",
+			Message: coupon.ErrUsedUp.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to redeem coupon",
+		})
+		return
+	}
+
+	redemption, err := qtx.CreateCouponRedemption(r.Context(), database.CreateCouponRedemptionParams{
+		CouponID:         row.ID,
+		OrganizationID:   org.ID,
+		RedeemedBy:       pgtype.UUID{Bytes: user.ID, Valid: true},
+		PeriodsRemaining: c.Periods(),
+	})
+	if err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			if pgErr.ConstraintName == "idx_coupon_redemptions_active" {
+				respondWithError(w, http.StatusConflict, ApiError{
+					Code:    "DISCOUNT_ACTIVE",
+					Message: "The organization already has an active discount",
+				})
+				return
+			}
+			respondWithError(w, http.StatusConflict, ApiError{
+				Code:    "COUPON_ALREADY_REDEEMED",
+				Message: "The organization has already redeemed this coupon",
+			})
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to redeem coupon",
+		})
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to redeem coupon",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Coupon redeemed successfully",
+		Data:    discountData(redemption, row),
+	})
+}
+
+// getDiscountHandler shows the organization's active discount, or null
+func (cfg *apiConfig) getDiscountHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	redemption, err := cfg.db.GetActiveCouponRedemption(r.Context(), user.OrganizationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithJSON(w, http.StatusOK, ApiResponse{
+			Success: true,
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve discount",
+		})
+		return
+	}
+
+	row, err := cfg.db.GetCoupon(r.Context(), redemption.CouponID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve coupon",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    discountData(redemption, row),
+	})
+}
+
+func discountData(redemption database.CouponRedemption, row database.Coupon) map[string]interface{} {
+	c := coupon.FromRow(row)
+	data := map[string]interface{}{
+		"code":               c.Code,
+		"name":               c.Name,
+		"terms":              c.Terms(),
+		"redeemed_at":        redemption.RedeemedAt.Time,
+		"invoices_remaining": nil,
+		"ended_at":           nil,
+	}
+	if redemption.PeriodsRemaining.Valid {
+		data["invoices_remaining"] = redemption.PeriodsRemaining.Int32
+	}
+	if redemption.EndedAt.Valid {
+		data["ended_at"] = redemption.EndedAt.Time
+	}
+	return data
+}
+
+// couponParameters are the fields of a coupon an operator sends. Only the
+// name, plans, limits and active flag change after it is created.
+type couponParameters struct {
+	Code           string          `json:"code"`
+	Name           string          `json:"name"`
+	DiscountType   string          `json:"discount_type"`
+	PercentOff     decimal.Decimal `json:"percent_off"`
+	AmountOff      decimal.Decimal `json:"amount_off"`
+	Currency       string          `json:"currency"`
+	Duration       string          `json:"duration"`
+	DurationMonths int32           `json:"duration_months"`
+	Plans          []string        `json:"plans"`
+	MaxRedemptions int32           `json:"max_redemptions"`
+	RedeemBy       *time.Time      `json:"redeem_by"`
+	Active         *bool           `json:"active"`
+}
+
+func (p couponParameters) coupon() coupon.Coupon {
+	plans := make([]database.PlanType, 0, len(p.Plans))
+	for _, plan := range p.Plans {
+		plans = append(plans, database.PlanType(strings.ToLower(strings.TrimSpace(plan))))
+	}
+	c := coupon.Coupon{
+		Code:           coupon.NormalizeCode(p.Code),
+		Name:           p.Name,
+		Type:           database.CouponDiscountType(p.DiscountType),
+		PercentOff:     p.PercentOff,
+		AmountOff:      p.AmountOff,
+		Currency:       strings.ToUpper(strings.TrimSpace(p.Currency)),
+		Duration:       database.CouponDuration(p.Duration),
+		DurationMonths: p.DurationMonths,
+		Plans:          plans,
+		MaxRedemptions: p.MaxRedemptions,
+		Active:         p.Active == nil || *p.Active,
+	}
+	if p.RedeemBy != nil {
+		c.RedeemBy = p.RedeemBy.UTC()
+	}
+	return c
+}
+
+func (cfg *apiConfig) listCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := cfg.db.ListCoupons(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve coupons",
+		})
+		return
+	}
+
+	coupons := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		coupons = append(coupons, couponData(row))
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"coupons": coupons,
+		},
+	})
+}
+
+func (cfg *apiConfig) createCouponHandler(w http.ResponseWriter, r *http.Request) {
+	var params couponParameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	c := params.coupon()
+	if err := c.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	row, err := cfg.db.CreateCoupon(r.Context(), c.CreateParams())
+	if err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			respondWithError(w, http.StatusConflict, ApiError{
+				Code:    "COUPON_EXISTS",
+				Message: "A coupon with this code already exists",
+			})
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to create coupon",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, ApiResponse{
+		Success: true,
+		Message: "Coupon created successfully",
+		Data:    couponData(row),
+	})
+}
+
+func (cfg *apiConfig) getCouponHandler(w http.ResponseWriter, r *http.Request) {
+	row, ok := cfg.couponFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    couponData(row),
+	})
+}
+
+// updateCouponHandler changes a coupon's name, plans, limits and whether it
+// can be redeemed. Organizations that already redeemed it keep their
+// discount.
+func (cfg *apiConfig) updateCouponHandler(w http.ResponseWriter, r *http.Request) {
+	row, ok := cfg.couponFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var params couponParameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	c := params.coupon()
+	c.TimesRedeemed = row.TimesRedeemed
+	if strings.TrimSpace(c.Name) == "" {
+		c.Name = row.Name
+	}
+	if err := c.ValidateLimits(); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	row, err := cfg.db.UpdateCoupon(r.Context(), c.UpdateParams(row.ID))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to update coupon",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Coupon updated successfully",
+		Data:    couponData(row),
+	})
+}
+
+// deleteCouponHandler removes a coupon nobody has redeemed. Redeemed
+// coupons are deactivated instead, so their discounts keep applying.
+func (cfg *apiConfig) deleteCouponHandler(w http.ResponseWriter, r *http.Request) {
+	row, ok := cfg.couponFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := cfg.db.DeleteCoupon(r.Context(), row.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to delete coupon",
+		})
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusConflict, ApiError{
+			Code:    "COUPON_REDEEMED",
+			Message: "This coupon has been redeemed; deactivate it instead",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Coupon deleted successfully",
+	})
+}
+
+// couponFromPath loads the coupon named by the {id} path value, responding
+// with the error when there is none
+func (cfg *apiConfig) couponFromPath(w http.ResponseWriter, r *http.Request) (database.Coupon, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_ID",
+			Message: "Invalid coupon ID",
+		})
+		return database.Coupon{}, false
+	}
+
+	row, err := cfg.db.GetCoupon(r.Context(), id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "COUPON_NOT_FOUND",
+			Message: "Coupon not found",
+		})
+		return database.Coupon{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve coupon",
+		})
+		return database.Coupon{}, false
+	}
+	return row, true
+}
+
+func couponData(row database.Coupon) map[string]interface{} {
+	c := coupon.FromRow(row)
+	data := map[string]interface{}{
+		"id":              row.ID,
+		"code":            c.Code,
+		"name":            c.Name,
+		"discount_type":   c.Type,
+		"percent_off":     nil,
+		"amount_off":      nil,
+		"currency":        nil,
+		"duration":        c.Duration,
+		"duration_months": nil,
+		"terms":           c.Terms(),
+		"plans":           row.Plans,
+		"max_redemptions": nil,
+		"times_redeemed":  c.TimesRedeemed,
+		"redeem_by":       nil,
+		"active":          c.Active,
+		"created_at":      row.CreatedAt.Time,
+		"updated_at":      row.UpdatedAt.Time,
+	}
+	if c.Type == database.CouponDiscountTypeFixed {
+		data["amount_off"] = c.AmountOff.String()
+		data["currency"] = c.Currency
+	} else {
+		data["percent_off"] = c.PercentOff.String()
+	}
+	if row.DurationMonths.Valid {
+		data["duration_months"] = c.DurationMonths
+	}
+	if row.MaxRedemptions.Valid {
+		data["max_redemptions"] = c.MaxRedemptions
+	}
+	if row.RedeemBy.Valid {
+		data["redeem_by"] = c.RedeemBy
+	}
+	return data
+}
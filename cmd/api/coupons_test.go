@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+)
+
+func TestCouponParameters(t *testing.T) {
+	body := `{
+		"code": " launch20 ",
+		"name": "Launch offer",
+		"discount_type": "fixed",
+		"amount_off": "5000",
+		"currency": "ngn",
+		"duration": "repeating",
+		"duration_months": 3,
+		"plans": ["Starter", " pro"]
+	}`
+
+	var params couponParameters
+	if err := json.Unmarshal([]byte(body), &params); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	c := params.coupon()
+
+	if c.Code != "LAUNCH20" || c.Currency != "NGN" {
+		t.Errorf("code, currency = %q, %q, want LAUNCH20, NGN", c.Code, c.Currency)
+	}
+	if len(c.Plans) != 2 || c.Plans[0] != database.PlanTypeStarter || c.Plans[1] != database.PlanTypePro {
+		t.Errorf("plans = %v, want [starter pro]", c.Plans)
+	}
+	if !c.Active {
+		t.Error("coupons should be active unless created inactive")
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	params.Active = new(bool)
+	if params.coupon().Active {
+		t.Error("active: false was ignored")
+	}
+}
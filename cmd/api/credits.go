@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/credit"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// creditTransactionsShown is how many recent transactions the balance lists
+const creditTransactionsShown = 20
+
+func (cfg *apiConfig) getCreditBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization details",
+		})
+		return
+	}
+
+	data, err := cfg.creditBalanceData(r.Context(), org)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve credit balance",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// creditBalanceData describes the organization's credit in its billing
+// currency, what this period's usage will draw from it, and its settings
+func (cfg *apiConfig) creditBalanceData(ctx context.Context, org database.Organization) (map[string]interface{}, error) {
+	code := org.BillingCurrency
+	places := currency.Exponent(code)
+
+	balance, err := credit.Balance(ctx, cfg.db, org.ID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	balanceRows, err := cfg.db.GetCreditBalances(ctx, org.ID)
+	if err != nil {
+		return nil, err
+	}
+	balances := make([]map[string]interface{}, 0, len(balanceRows))
+	for _, row := range balanceRows {
+		balances = append(balances, map[string]interface{}{
+			"currency": row.Currency,
+			"balance":  currency.FromNumeric(row.Balance).StringFixed(currency.Exponent(row.Currency)),
+		})
+	}
+
+	transactionRows, err := cfg.db.ListCreditTransactions(ctx, database.ListCreditTransactionsParams{
+		OrganizationID: org.ID,
+		Limit:          creditTransactionsShown,
+	})
+	if err != nil {
+		return nil, err
+	}
+	transactions := make([]map[string]interface{}, 0, len(transactionRows))
+	for _, row := range transactionRows {
+		transactions = append(transactions, creditTransactionData(row))
+	}
+
+	data := map[string]interface{}{
+		"currency":              code,
+		"balance":               balance.StringFixed(places),
+		"accrued_usage":         nil,
+		"available":             nil,
+		"balances":              balances,
+		"low_balance_threshold": nil,
+		"block_at_zero":         false,
+		"blocked":               false,
+		"transactions":          transactions,
+	}
+
+	// Without an exchange rate this period's usage cannot be priced in the
+	// billing currency; the balance is still shown
+	rates, err := currency.Load(ctx, cfg.db, cfg.config.FXRatesFile)
+	if err != nil {
+		return nil, err
+	}
+	accrued, err := credit.Accrued(ctx, cfg.db, cfg.pricing, rates, org.ID, org.Plan, code, time.Now())
+	if err == nil {
+		data["accrued_usage"] = accrued.StringFixed(places)
+		data["available"] = balance.Sub(accrued).StringFixed(places)
+	} else {
+		log.Printf("Failed to price usage against credit for org %s: %v", org.ID, err)
+	}
+
+	wallet, err := cfg.db.GetCreditWallet(ctx, org.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if wallet.LowBalanceThreshold.Valid {
+		data["low_balance_threshold"] = currency.FromNumeric(wallet.LowBalanceThreshold).StringFixed(places)
+	}
+	data["block_at_zero"] = wallet.BlockAtZero
+	data["blocked"] = wallet.BlockedAt.Valid
+	return data, nil
+}
+
+func creditTransactionData(row database.CreditTransaction) map[string]interface{} {
+	amount := currency.FromNumeric(row.Amount)
+	// Spending credit takes from the balance
+	if row.Type == database.CreditTransactionTypeInvoicePayment {
+		amount = amount.Neg()
+	}
+	data := map[string]interface{}{
+		"id":          row.ID,
+		"type":        row.Type,
+		"currency":    row.Currency,
+		"amount":      amount.StringFixed(currency.Exponent(row.Currency)),
+		"description": row.Description,
+		"created_at":  row.CreatedAt.Time,
+	}
+	if row.BillingCycleID.Valid {
+		data["billing_cycle_id"] = uuid.UUID(row.BillingCycleID.Bytes)
+	}
+	if row.TopUpID.Valid {
+		data["top_up_id"] = uuid.UUID(row.TopUpID.Bytes)
+	}
+	return data
+}
+
+// updateCreditSettingsHandler sets when the organization is warned that its
+// credit is running low and whether its API keys stop at zero
+func (cfg *apiConfig) updateCreditSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		LowBalanceThreshold *decimal.Decimal `json:"low_balance_threshold"`
+		BlockAtZero         bool             `json:"block_at_zero"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner && user.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only owner and admin roles can change credit settings",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization details",
+		})
+		return
+	}
+
+	threshold := pgtype.Numeric{}
+	if params.LowBalanceThreshold != nil {
+		if params.LowBalanceThreshold.IsNegative() {
+			respondWithError(w, http.StatusBadRequest, ApiError{
+				Code:    "VALIDATION_ERROR",
+				Message: "low_balance_threshold cannot be negative",
+				Details: map[string]interface{}{
+					"field": "low_balance_threshold",
+				},
+			})
+			return
+		}
+		threshold = credit.Numeric(currency.Round(*params.LowBalanceThreshold, org.BillingCurrency))
+	}
+
+	_, err = cfg.db.UpsertCreditWalletSettings(r.Context(), database.UpsertCreditWalletSettingsParams{
+		OrganizationID:      org.ID,
+		LowBalanceThreshold: threshold,
+		BlockAtZero:         params.BlockAtZero,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to save credit settings",
+		})
+		return
+	}
+
+	data, err := cfg.creditBalanceData(r.Context(), org)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve credit balance",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Credit settings saved successfully",
+		Data:    data,
+	})
+}
+
+// topUpCreditHandler starts a payment for prepaid credit in the
+// organization's billing currency. The credit is added when the provider's
+// webhook confirms the payment.
+func (cfg *apiConfig) topUpCreditHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Amount   decimal.Decimal `json:"amount"`
+		Provider string          `json:"provider"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	if params.Provider == "" {
+		params.Provider = "stripe"
+	}
+	if params.Provider != "stripe" && params.Provider != "paystack" {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_PROVIDER",
+			Message: "Payment provider must be 'stripe' or 'paystack'",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner && user.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only owner and admin roles can top up credit",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization",
+		})
+		return
+	}
+
+	code := org.BillingCurrency
+	amount := params.Amount
+	if !amount.IsPositive() || !currency.Round(amount, code).Equal(amount) {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: fmt.Sprintf("amount must be a positive amount of %s with at most %d decimals", code, currency.Exponent(code)),
+			Details: map[string]interface{}{
+				"field": "amount",
+			},
+		})
+		return
+	}
+	amountMinor, err := currency.ToMinorUnits(amount, code)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+			Details: map[string]interface{}{
+				"field": "amount",
+			},
+		})
+		return
+	}
+
+	if params.Provider == "paystack" && !cfg.paymentService.Paystack.SupportsCurrency(code) {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "UNSUPPORTED_CURRENCY",
+			Message: fmt.Sprintf("Paystack cannot charge in %s", code),
+		})
+		return
+	}
+
+	if err := cfg.db.EnsureCreditWallet(r.Context(), org.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to create credit wallet",
+		})
+		return
+	}
+
+	topUp, err := cfg.db.CreateCreditTopUp(r.Context(), database.CreateCreditTopUpParams{
+		OrganizationID: org.ID,
+		Provider:       params.Provider,
+		Currency:       code,
+		Amount:         credit.Numeric(amount),
+		CreatedBy:      pgtype.UUID{Bytes: user.ID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to create top-up",
+		})
+		return
+	}
+
+	var paymentURL string
+	var providerResponse interface{}
+
+	switch params.Provider {
+	case "stripe":
+		session, err := cfg.paymentService.Stripe.CreateTopUpSession(payment.TopUpSessionParams{
+			OrganizationID: org.ID.String(),
+			TopUpID:        topUp.ID.String(),
+			Amount:         amountMinor,
+			Currency:       strings.ToLower(code),
+			SuccessURL:     cfg.config.AppURL + "/billing/credits?top_up=" + topUp.ID.String(),
+			CancelURL:      cfg.config.AppURL + "/billing/credits",
+			CustomerEmail:  org.Email,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "PAYMENT_ERROR",
+				Message: "Failed to create payment session",
+				Details: err.Error(),
+			})
+			return
+		}
+		paymentURL = session.URL
+		providerResponse = session
+
+	case "paystack":
+		response, err := cfg.paymentService.Paystack.InitializeTransaction(payment.PaystackInitializeParams{
+			Email:       org.Email,
+			Amount:      amountMinor,
+			Currency:    code,
+			Reference:   topUp.ID.String(),
+			CallbackURL: cfg.config.AppURL + "/billing/credits",
+			Metadata: map[string]string{
+				"organization_id": org.ID.String(),
+				"top_up_id":       topUp.ID.String(),
+			},
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "PAYMENT_ERROR",
+				Message: "Failed to create payment session",
+				Details: err.Error(),
+			})
+			return
+		}
+		paymentURL = response.Data.AuthorizationURL
+		providerResponse = response
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"payment_url":   paymentURL,
+			"top_up_id":     topUp.ID,
+			"amount":        amount.StringFixed(currency.Exponent(code)),
+			"currency":      code,
+			"provider":      params.Provider,
+			"status":        topUp.Status,
+			"provider_data": providerResponse,
+		},
+	})
+}
+
+// completeTopUp adds a paid top-up to the organization's credit once. The
+// block and low balance notice are lifted; the credit balance job sets them
+// again if the credit is still short.
+func (cfg *apiConfig) completeTopUp(ctx context.Context, topUpID uuid.UUID, amountMinor int64, code string) error {
+	pending, err := cfg.db.GetCreditTopUp(ctx, topUpID)
+	if err != nil {
+		return fmt.Errorf("top-up not found: %w", err)
+	}
+	if err := checkChargedAmount(currency.FromNumeric(pending.Amount), pending.Currency, amountMinor, code); err != nil {
+		return err
+	}
+
+	tx, err := cfg.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := cfg.db.WithTx(tx)
+
+	topUp, err := qtx.CompleteCreditTopUp(ctx, topUpID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Top-up %s was already processed", topUpID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to complete top-up: %w", err)
+	}
+
+	_, err = credit.Post(ctx, qtx, credit.Transaction{
+		OrganizationID: topUp.OrganizationID,
+		Type:           database.CreditTransactionTypeTopUp,
+		Currency:       topUp.Currency,
+		Amount:         currency.FromNumeric(topUp.Amount),
+		Description:    "Top-up via " + topUp.Provider,
+		TopUpID:        topUp.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := qtx.ClearCreditWalletLowBalanceNotice(ctx, topUp.OrganizationID); err != nil {
+		return fmt.Errorf("failed to clear low balance notice: %w", err)
+	}
+	_, err = qtx.SetCreditWalletBlocked(ctx, database.SetCreditWalletBlockedParams{
+		Blocked:        false,
+		OrganizationID: topUp.OrganizationID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to lift credit block: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit top-up: %w", err)
+	}
+
+	log.Printf("Added %s %s of prepaid credit for org %s (top-up %s)", currency.FromNumeric(topUp.Amount), topUp.Currency, topUp.OrganizationID, topUp.ID)
+	return nil
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+func (cfg *apiConfig) getBillingCurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization details",
+		})
+		return
+	}
+
+	data, err := cfg.billingCurrencyData(r, org.BillingCurrency)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve exchange rates",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// updateBillingCurrencyHandler changes the currency the organization's next
+// invoices are issued in. Invoices already issued keep their currency.
+func (cfg *apiConfig) updateBillingCurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Currency string `json:"currency"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner && user.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only owner and admin roles can change the billing currency",
+		})
+		return
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(params.Currency))
+	if err := currency.Validate(code); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+			Details: map[string]interface{}{
+				"field": "currency",
+			},
+		})
+		return
+	}
+
+	rates, err := currency.Load(r.Context(), cfg.db, cfg.config.FXRatesFile)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve exchange rates",
+		})
+		return
+	}
+
+	// Without a rate the next invoice could not be converted
+	book := cfg.pricing.BookAt(time.Now())
+	if _, ok := rates.RateAt(book.Currency, code, time.Now()); !ok {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "NO_EXCHANGE_RATE",
+			Message: "No exchange rate from " + book.Currency + " to " + code + " is published",
+			Details: map[string]interface{}{
+				"field": "currency",
+			},
+		})
+		return
+	}
+
+	org, err := cfg.db.UpdateOrganizationBillingCurrency(r.Context(), database.UpdateOrganizationBillingCurrencyParams{
+		BillingCurrency: code,
+		ID:              user.OrganizationID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to update billing currency",
+		})
+		return
+	}
+
+	data, err := cfg.billingCurrencyData(r, org.BillingCurrency)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve exchange rates",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Billing currency updated successfully",
+		Data:    data,
+	})
+}
+
+// billingCurrencyData describes the currency invoices are issued in and the
+// rate the next one would be converted at
+func (cfg *apiConfig) billingCurrencyData(r *http.Request, code string) (map[string]interface{}, error) {
+	rates, err := currency.Load(r.Context(), cfg.db, cfg.config.FXRatesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	book := cfg.pricing.BookAt(now)
+	data := map[string]interface{}{
+		"billing_currency":    code,
+		"price_book_currency": book.Currency,
+		"exchange_rate":       nil,
+		"supported":           currency.Supported(),
+	}
+	if book.Currency != code {
+		if rate, ok := rates.RateAt(book.Currency, code, now); ok {
+			data["exchange_rate"] = fxRateData(rate)
+		}
+	}
+	return data, nil
+}
+
+func (cfg *apiConfig) listFXRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rates, err := currency.Load(r.Context(), cfg.db, cfg.config.FXRatesFile)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve exchange rates",
+		})
+		return
+	}
+
+	list := make([]map[string]interface{}, 0)
+	for _, rate := range rates.All() {
+		list = append(list, fxRateData(rate))
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"rates": list,
+		},
+	})
+}
+
+// upsertFXRateHandler publishes an exchange rate from a time on, now by
+// default. A rate already published for that pair and time is replaced.
+func (cfg *apiConfig) upsertFXRateHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Base        string          `json:"base"`
+		Quote       string          `json:"quote"`
+		Rate        decimal.Decimal `json:"rate"`
+		EffectiveAt *time.Time      `json:"effective_at"`
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	effectiveAt := time.Now().UTC()
+	if params.EffectiveAt != nil {
+		effectiveAt = params.EffectiveAt.UTC()
+	}
+
+	rate := currency.Rate{
+		Base:        strings.ToUpper(strings.TrimSpace(params.Base)),
+		Quote:       strings.ToUpper(strings.TrimSpace(params.Quote)),
+		Rate:        params.Rate,
+		EffectiveAt: effectiveAt,
+		Source:      currency.SourceAdmin,
+	}
+	if err := rate.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	row, err := cfg.db.UpsertFXRate(r.Context(), database.UpsertFXRateParams{
+		BaseCurrency:  rate.Base,
+		QuoteCurrency: rate.Quote,
+		Rate:          rate.Numeric(),
+		EffectiveAt:   pgtype.Timestamp{Time: rate.EffectiveAt, Valid: true},
+		Source:        rate.Source,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to save exchange rate",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Exchange rate saved successfully",
+		Data:    fxRateData(currency.RateFromRow(row)),
+	})
+}
+
+func fxRateData(rate currency.Rate) map[string]interface{} {
+	return map[string]interface{}{
+		"base":         rate.Base,
+		"quote":        rate.Quote,
+		"rate":         rate.Rate.String(),
+		"effective_at": rate.EffectiveAt,
+		"source":       rate.Source,
+	}
+}
+
+// exchangeRateData is the rate an invoice was converted from its price
+// book's currency at, or nil when it was issued in that currency
+func exchangeRateData(cycle database.BillingCycle) map[string]interface{} {
+	if !cycle.FxRate.Valid {
+		return nil
+	}
+
+	from := ""
+	var quote pricing.Quote
+	if err := json.Unmarshal(cycle.Quote, &quote); err == nil {
+		from = quote.Currency
+	}
+
+	return map[string]interface{}{
+		"from":         from,
+		"to":           cycle.Currency,
+		"rate":         currency.FromNumeric(cycle.FxRate).String(),
+		"effective_at": cycle.FxRateAt.Time,
+	}
+}
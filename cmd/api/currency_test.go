@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+func TestCheckPaidAmount(t *testing.T) {
+	total := decimal.RequireFromString("60459.95")
+	cycle := database.BillingCycle{
+		Currency:    "NGN",
+		TotalAmount: pgtype.Numeric{Int: total.Coefficient(), Exp: total.Exponent(), Valid: true},
+	}
+
+	tests := []struct {
+		name    string
+		amount  int64
+		code    string
+		wantErr bool
+	}{
+		{"Exact amount", 6045995, "NGN", false},
+		{"Lowercase currency", 6045995, "ngn", false},
+		{"Short by a kobo", 6045994, "NGN", true},
+		{"Dollar amount", 6046, "USD", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPaidAmount(cycle, tt.amount, tt.code)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPaidAmount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/events"
+	"github.com/jackc/pgx/v5"
+)
+
+func (cfg *apiConfig) getWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	endpoint, err := cfg.db.GetWebhookEndpoint(r.Context(), user.OrganizationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "ENDPOINT_NOT_FOUND",
+			Message: "No webhook endpoint is configured",
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve webhook endpoint",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"url":        endpoint.Url,
+			"created_at": endpoint.CreatedAt.Time,
+			"updated_at": endpoint.UpdatedAt.Time,
+		},
+	})
+}
+
+func (cfg *apiConfig) updateWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL          string `json:"url"`
+		RotateSecret bool   `json:"rotate_secret"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	if err := events.ValidateURL(params.URL, cfg.config.IsDevelopment()); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: "URL must be a public https URL: " + err.Error(),
+			Details: map[string]interface{}{
+				"field": "url",
+			},
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner && user.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only owner and admin roles can manage the webhook endpoint",
+		})
+		return
+	}
+
+	existing, err := cfg.db.GetWebhookEndpoint(r.Context(), user.OrganizationID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve webhook endpoint",
+		})
+		return
+	}
+	created := errors.Is(err, pgx.ErrNoRows)
+
+	// The secret is only shown when it is generated
+	secret := existing.Secret
+	if created || params.RotateSecret {
+		secret, err = events.GenerateSecret()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to generate signing secret",
+			})
+			return
+		}
+	}
+
+	var endpoint database.WebhookEndpoint
+	if created {
+		endpoint, err = cfg.db.CreateWebhookEndpoint(r.Context(), database.CreateWebhookEndpointParams{
+			OrganizationID: user.OrganizationID,
+			Url:            params.URL,
+			Secret:         secret,
+		})
+	} else {
+		endpoint, err = cfg.db.UpdateWebhookEndpoint(r.Context(), database.UpdateWebhookEndpointParams{
+			OrganizationID: user.OrganizationID,
+			Url:            params.URL,
+			Secret:         secret,
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to save webhook endpoint",
+		})
+		return
+	}
+
+	data := map[string]interface{}{
+		"url":        endpoint.Url,
+		"created_at": endpoint.CreatedAt.Time,
+		"updated_at": endpoint.UpdatedAt.Time,
+	}
+	message := "Webhook endpoint updated successfully"
+	if created || params.RotateSecret {
+		data["secret"] = endpoint.Secret
+		message = "Webhook endpoint saved. ⚠️ Store the signing secret securely. It won't be shown again."
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: message,
+		Data:    data,
+	})
+}
+
+func (cfg *apiConfig) deleteWebhookEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner && user.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only owner and admin roles can manage the webhook endpoint",
+		})
+		return
+	}
+
+	if err := cfg.db.DeleteWebhookEndpoint(r.Context(), user.OrganizationID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to delete webhook endpoint",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Webhook endpoint deleted successfully",
+	})
+}
+
+func (cfg *apiConfig) listOutboundEventsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	outbound, err := cfg.db.ListOrganizationOutboundEvents(r.Context(), database.ListOrganizationOutboundEventsParams{
+		OrganizationID: user.OrganizationID,
+		Limit:          100,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve events",
+		})
+		return
+	}
+
+	eventData := make([]map[string]interface{}, 0, len(outbound))
+	for _, event := range outbound {
+		data := map[string]interface{}{
+			"id":         event.ID,
+			"type":       event.EventType,
+			"data":       json.RawMessage(event.Payload),
+			"status":     event.Status,
+			"attempts":   event.Attempts,
+			"created_at": event.CreatedAt.Time,
+		}
+		if event.LastError != nil {
+			data["last_error"] = *event.LastError
+		}
+		if event.DeliveredAt.Valid {
+			data["delivered_at"] = event.DeliveredAt.Time
+		}
+		eventData = append(eventData, data)
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"events": eventData,
+		},
+	})
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// exportDownloadTTL is how long a signed download URL stays valid
+	exportDownloadTTL = 15 * time.Minute
+	// maxExportRange bounds the date range of one export
+	maxExportRange = 366 * 24 * time.Hour
+	// maxQueuedExports is how many exports an organization can have waiting
+	maxQueuedExports = 3
+)
+
+// exportContentTypes maps export formats to their download content type
+var exportContentTypes = map[database.UsageExportFormat]string{
+	database.UsageExportFormatCsv:    "text/csv; charset=utf-8",
+	database.UsageExportFormatNdjson: "application/x-ndjson",
+}
+
+// signExportDownload returns the signature for downloading an export until
+// expires. It is keyed with the JWT secret, so only this API can issue it.
+func signExportDownload(secret string, exportID uuid.UUID, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "usage-export:%s:%d", exportID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyExportDownload checks a signature from signExportDownload and that
+// it has not expired
+func verifyExportDownload(secret string, exportID uuid.UUID, expires int64, signature string, now time.Time) bool {
+	if now.Unix() > expires {
+		return false
+	}
+	expected := signExportDownload(secret, exportID, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// exportDownloadURL builds a signed, time-limited download URL for an export
+func (cfg *apiConfig) exportDownloadURL(exportID uuid.UUID, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(exportDownloadTTL).Truncate(time.Second)
+	expires := expiresAt.Unix()
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", signExportDownload(cfg.jwtSecret, exportID, expires))
+
+	base := strings.TrimSuffix(cfg.config.APIURL, "/")
+	return fmt.Sprintf("%s/api/v1/usage/exports/%s/download?%s", base, exportID, query.Encode()), expiresAt
+}
+
+func (cfg *apiConfig) usageExportData(export database.UsageExport) map[string]interface{} {
+	data := map[string]interface{}{
+		"id":         export.ID,
+		"format":     export.Format,
+		"start":      export.StartTime.Time,
+		"end":        export.EndTime.Time,
+		"status":     export.Status,
+		"created_at": export.CreatedAt.Time,
+	}
+	if export.StartedAt.Valid {
+		data["started_at"] = export.StartedAt.Time
+	}
+	if export.CompletedAt.Valid {
+		data["completed_at"] = export.CompletedAt.Time
+	}
+	if export.Error != nil {
+		data["error"] = *export.Error
+	}
+
+	if export.Status == database.UsageExportStatusCompleted {
+		downloadURL, urlExpiresAt := cfg.exportDownloadURL(export.ID, time.Now())
+		data["row_count"] = export.RowCount
+		data["file_size"] = export.FileSize
+		data["expires_at"] = export.ExpiresAt.Time
+		data["download_url"] = downloadURL
+		data["download_url_expires_at"] = urlExpiresAt
+	}
+
+	return data
+}
+
+func (cfg *apiConfig) createUsageExportHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Format string    `json:"format"`
+		Start  time.Time `json:"start"`
+		End    time.Time `json:"end"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body. start and end must be RFC 3339 timestamps",
+		})
+		return
+	}
+
+	format := database.UsageExportFormat(params.Format)
+	if _, ok := exportContentTypes[format]; !ok {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: "Format must be 'csv' or 'ndjson'",
+			Details: map[string]interface{}{
+				"field":    "format",
+				"provided": params.Format,
+			},
+		})
+		return
+	}
+
+	start, end := params.Start.UTC(), params.End.UTC()
+	if start.IsZero() || end.IsZero() || !start.Before(end) {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: "start and end are required and start must be before end",
+		})
+		return
+	}
+	if end.Sub(start) > maxExportRange {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: "Export range cannot exceed 366 days",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	// Exports include client IPs and user agents
+	if user.Role != database.UserRoleOwner && user.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only owner and admin roles can export usage",
+		})
+		return
+	}
+
+	queued, err := cfg.db.CountQueuedUsageExports(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to check existing exports",
+		})
+		return
+	}
+	if queued >= maxQueuedExports {
+		respondWithError(w, http.StatusTooManyRequests, ApiError{
+			Code:    "TOO_MANY_EXPORTS",
+			Message: "Wait for running exports to finish before starting another",
+			Details: map[string]interface{}{
+				"queued": queued,
+				"limit":  maxQueuedExports,
+			},
+		})
+		return
+	}
+
+	export, err := cfg.db.CreateUsageExport(r.Context(), database.CreateUsageExportParams{
+		OrganizationID: user.OrganizationID,
+		RequestedBy:    pgtype.UUID{Bytes: user.ID, Valid: true},
+		Format:         format,
+		StartTime:      pgtype.Timestamp{Time: start, Valid: true},
+		EndTime:        pgtype.Timestamp{Time: end, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to create export",
+		})
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/usage/exports/"+export.ID.String())
+	respondWithJSON(w, http.StatusAccepted, ApiResponse{
+		Success: true,
+		Message: "Export started. Poll its status until it is completed",
+		Data:    cfg.usageExportData(export),
+	})
+}
+
+func (cfg *apiConfig) listUsageExportsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner && user.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only owner and admin roles can export usage",
+		})
+		return
+	}
+
+	exports, err := cfg.db.ListOrganizationUsageExports(r.Context(), database.ListOrganizationUsageExportsParams{
+		OrganizationID: user.OrganizationID,
+		Limit:          50,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve exports",
+		})
+		return
+	}
+
+	exportData := make([]map[string]interface{}, 0, len(exports))
+	for _, export := range exports {
+		exportData = append(exportData, cfg.usageExportData(export))
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"exports": exportData,
+		},
+	})
+}
+
+func (cfg *apiConfig) getUsageExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	exportID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_EXPORT_ID",
+			Message: "Invalid export ID format",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner && user.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only owner and admin roles can export usage",
+		})
+		return
+	}
+
+	export, err := cfg.db.GetUsageExport(r.Context(), exportID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && export.OrganizationID != user.OrganizationID) {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "EXPORT_NOT_FOUND",
+			Message: "Export not found",
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve export",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    cfg.usageExportData(export),
+	})
+}
+
+// downloadUsageExportHandler serves a finished export. It is not behind the
+// auth middleware: the signed URL is the credential, so it can be handed to
+// tools that cannot send a bearer token.
+func (cfg *apiConfig) downloadUsageExportHandler(w http.ResponseWriter, r *http.Request) {
+	exportID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_EXPORT_ID",
+			Message: "Invalid export ID format",
+		})
+		return
+	}
+
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || !verifyExportDownload(cfg.jwtSecret, exportID, expires, r.URL.Query().Get("signature"), time.Now()) {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "INVALID_SIGNATURE",
+			Message: "Download link is invalid or has expired",
+		})
+		return
+	}
+
+	export, err := cfg.db.GetUsageExport(r.Context(), exportID)
+	if err != nil || export.Status != database.UsageExportStatusCompleted || export.FilePath == nil {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "EXPORT_NOT_FOUND",
+			Message: "Export is not available for download",
+		})
+		return
+	}
+
+	file, err := os.Open(*export.FilePath)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "EXPORT_NOT_FOUND",
+			Message: "Export is not available for download",
+		})
+		return
+	}
+	defer file.Close()
+
+	// Large exports take longer than the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	filename := fmt.Sprintf("usage_%s_%s.%s",
+		export.StartTime.Time.Format("20060102"),
+		export.EndTime.Time.Format("20060102"),
+		export.Format,
+	)
+	w.Header().Set("Content-Type", exportContentTypes[export.Format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(w, r, filename, export.CompletedAt.Time, file)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/config"
+	"github.com/google/uuid"
+)
+
+func TestVerifyExportDownload(t *testing.T) {
+	secret := "test-secret"
+	exportID := uuid.New()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(exportDownloadTTL).Unix()
+	signature := signExportDownload(secret, exportID, expires)
+
+	tests := []struct {
+		name      string
+		secret    string
+		exportID  uuid.UUID
+		expires   int64
+		signature string
+		now       time.Time
+		want      bool
+	}{
+		{
+			name:      "Valid signature",
+			secret:    secret,
+			exportID:  exportID,
+			expires:   expires,
+			signature: signature,
+			now:       now,
+			want:      true,
+		},
+		{
+			name:      "Expired link",
+			secret:    secret,
+			exportID:  exportID,
+			expires:   expires,
+			signature: signature,
+			now:       now.Add(exportDownloadTTL + time.Second),
+			want:      false,
+		},
+		{
+			name:      "Extended expiry",
+			secret:    secret,
+			exportID:  exportID,
+			expires:   expires + 3600,
+			signature: signature,
+			now:       now,
+			want:      false,
+		},
+		{
+			name:      "Different export",
+			secret:    secret,
+			exportID:  uuid.New(),
+			expires:   expires,
+			signature: signature,
+			now:       now,
+			want:      false,
+		},
+		{
+			name:      "Different secret",
+			secret:    "other-secret",
+			exportID:  exportID,
+			expires:   expires,
+			signature: signature,
+			now:       now,
+			want:      false,
+		},
+		{
+			name:      "Missing signature",
+			secret:    secret,
+			exportID:  exportID,
+			expires:   expires,
+			signature: "",
+			now:       now,
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := verifyExportDownload(tt.secret, tt.exportID, tt.expires, tt.signature, tt.now)
+			if got != tt.want {
+				t.Errorf("verifyExportDownload() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportDownloadURL(t *testing.T) {
+	cfg := &apiConfig{
+		jwtSecret: "test-secret",
+		config:    &config.Config{APIURL: "https://api.example.com/"},
+	}
+	exportID := uuid.New()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	raw, expiresAt := cfg.exportDownloadURL(exportID, now)
+	if !expiresAt.Equal(now.Add(exportDownloadTTL)) {
+		t.Errorf("expiresAt = %v, want %v", expiresAt, now.Add(exportDownloadTTL))
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("invalid URL %q: %v", raw, err)
+	}
+	wantPath := "/api/v1/usage/exports/" + exportID.String() + "/download"
+	if parsed.Host != "api.example.com" || parsed.Path != wantPath {
+		t.Errorf("URL = %q, want host api.example.com and path %s", raw, wantPath)
+	}
+	if strings.Contains(parsed.Path, "//") {
+		t.Errorf("URL path has a doubled slash: %q", parsed.Path)
+	}
+
+	expires, _ := strconv.ParseInt(parsed.Query().Get("expires"), 10, 64)
+	if !verifyExportDownload(cfg.jwtSecret, exportID, expires, parsed.Query().Get("signature"), now) {
+		t.Error("generated URL does not verify")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/plan"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// forecastHistoryDays is how many completed days the trend is fitted to.
+	// It reaches into the previous period early in the month.
+	forecastHistoryDays = 14
+	// forecastZ is the normal quantile for the 95% confidence range
+	forecastZ = 1.96
+)
+
+// usageForecast is the projected number of requests still to come this period
+type usageForecast struct {
+	expected   float64
+	low        float64
+	high       float64
+	dailyRate  float64
+	dailyTrend float64
+}
+
+// forecastUsage fits a least-squares line to daily request counts (oldest
+// first, ending yesterday) and sums it over the rest of today and fullDays
+// more days. The range assumes independent daily errors, with the spread
+// floored at Poisson noise so a perfectly steady history still has a range.
+func forecastUsage(history []float64, todayFraction float64, fullDays int) usageForecast {
+	n := len(history)
+	if n == 0 {
+		return usageForecast{}
+	}
+
+	var sumX, sumY float64
+	for i, y := range history {
+		sumX += float64(i)
+		sumY += y
+	}
+	meanX := sumX / float64(n)
+	meanY := sumY / float64(n)
+
+	slope := 0.0
+	if n >= 3 {
+		var sxy, sxx float64
+		for i, y := range history {
+			dx := float64(i) - meanX
+			sxy += dx * (y - meanY)
+			sxx += dx * dx
+		}
+		slope = sxy / sxx
+	}
+	intercept := meanY - slope*meanX
+
+	predict := func(x float64) float64 {
+		return math.Max(intercept+slope*x, 0)
+	}
+
+	var sse float64
+	for i, y := range history {
+		residual := y - (intercept + slope*float64(i))
+		sse += residual * residual
+	}
+	dof := float64(n - 2)
+	if n < 3 {
+		dof = float64(n)
+	}
+	sigma := math.Max(math.Sqrt(sse/dof), math.Sqrt(meanY))
+
+	today := float64(n)
+	expected := todayFraction * predict(today)
+	for day := 1; day <= fullDays; day++ {
+		expected += predict(today + float64(day))
+	}
+
+	spread := forecastZ * sigma * math.Sqrt(todayFraction*todayFraction+float64(fullDays))
+
+	return usageForecast{
+		expected:   expected,
+		low:        math.Max(expected-spread, 0),
+		high:       expected + spread,
+		dailyRate:  predict(today),
+		dailyTrend: slope,
+	}
+}
+
+func (cfg *apiConfig) getBillingForecastHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization",
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	periodStart, periodEnd := quota.CurrentPeriod(now)
+	today := now.Truncate(24 * time.Hour)
+	tomorrow := today.Add(24 * time.Hour)
+	historyStart := today.AddDate(0, 0, -forecastHistoryDays)
+
+	periodUsage, err := plan.LoadUsage(r.Context(), cfg.db, org.ID, org.Plan, periodStart, now)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve usage",
+		})
+		return
+	}
+
+	dailyStats, err := cfg.db.GetDailyUsageStats(r.Context(), database.GetDailyUsageStatsParams{
+		OrganizationID: org.ID,
+		StartTime:      pgtype.Timestamp{Time: historyStart, Valid: true},
+		EndTime:        pgtype.Timestamp{Time: today.Add(-time.Second), Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve daily usage",
+		})
+		return
+	}
+
+	// Days without traffic are part of the trend, so fill them with zero
+	history := make([]float64, forecastHistoryDays)
+	for _, day := range dailyStats {
+		index := int(day.Date.Time.Sub(historyStart) / (24 * time.Hour))
+		if index >= 0 && index < len(history) {
+			history[index] = float64(day.RequestCount)
+		}
+	}
+
+	todayFraction := tomorrow.Sub(now).Hours() / 24
+	fullDays := 0
+	if periodEnd.After(tomorrow) {
+		fullDays = int(periodEnd.Add(time.Second).Sub(tomorrow) / (24 * time.Hour))
+	}
+
+	forecast := forecastUsage(history, todayFraction, fullDays)
+
+	// The period is priced like its invoice, for the time spent on each
+	// plan. Projected usage lands on the current plan and keeps this
+	// period's mix of message types.
+	usage, monthToDate := periodUsage.Total()
+	monthToDateQuote := periodUsage.Quote(cfg.pricing, nil)
+	projection := func(remaining float64) map[string]interface{} {
+		projected := int64(math.Round(remaining))
+		quote := periodUsage.Quote(cfg.pricing, projectUsage(usage, monthToDate, projected))
+		return map[string]interface{}{
+			"requests": monthToDate + projected,
+			"amount":   quote.Total.InexactFloat64(),
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"period": map[string]interface{}{
+				"start": periodStart,
+				"end":   periodEnd,
+			},
+			"plan":               org.Plan,
+			"currency":           monthToDateQuote.Currency,
+			"price_book_version": monthToDateQuote.Version,
+			"month_to_date":      projection(0),
+			"forecast":           projection(forecast.expected),
+			"confidence_range": map[string]interface{}{
+				"level": 0.95,
+				"low":   projection(forecast.low),
+				"high":  projection(forecast.high),
+			},
+			"model": map[string]interface{}{
+				"type":           "linear_trend",
+				"history_days":   forecastHistoryDays,
+				"daily_rate":     math.Round(forecast.dailyRate),
+				"daily_trend":    math.Round(forecast.dailyTrend*100) / 100,
+				"days_remaining": math.Round((todayFraction+float64(fullDays))*100) / 100,
+			},
+		},
+	})
+}
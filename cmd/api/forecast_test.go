@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestForecastUsage(t *testing.T) {
+	flat := make([]float64, 14)
+	growing := make([]float64, 14)
+	for i := range flat {
+		flat[i] = 100
+		growing[i] = float64(100 + 10*i)
+	}
+
+	tests := []struct {
+		name          string
+		history       []float64
+		todayFraction float64
+		fullDays      int
+		wantExpected  float64
+	}{
+		{
+			name:          "Flat usage",
+			history:       flat,
+			todayFraction: 0.5,
+			fullDays:      10,
+			wantExpected:  1050,
+		},
+		{
+			name:          "Growing usage follows the trend",
+			history:       growing,
+			todayFraction: 0,
+			fullDays:      2,
+			// Days 15 and 16 of a line starting at 100 with slope 10
+			wantExpected: 250 + 260,
+		},
+		{
+			name:          "Declining usage never goes negative",
+			history:       []float64{300, 200, 100, 0},
+			todayFraction: 1,
+			fullDays:      5,
+			wantExpected:  0,
+		},
+		{
+			name:          "No history",
+			history:       nil,
+			todayFraction: 1,
+			fullDays:      20,
+			wantExpected:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := forecastUsage(tt.history, tt.todayFraction, tt.fullDays)
+			if math.Abs(got.expected-tt.wantExpected) > 0.001 {
+				t.Errorf("expected = %v, want %v", got.expected, tt.wantExpected)
+			}
+			if got.low > got.expected || got.high < got.expected {
+				t.Errorf("range [%v, %v] does not contain %v", got.low, got.high, got.expected)
+			}
+			if got.low < 0 {
+				t.Errorf("low = %v, want >= 0", got.low)
+			}
+		})
+	}
+}
+
+func TestForecastUsageRange(t *testing.T) {
+	steady := []float64{100, 100, 100, 100, 100, 100, 100}
+	noisy := []float64{40, 160, 60, 140, 50, 150, 100}
+
+	steadyForecast := forecastUsage(steady, 0, 10)
+	noisyForecast := forecastUsage(noisy, 0, 10)
+
+	if steadyForecast.high == steadyForecast.expected {
+		t.Error("steady usage should still have a non-empty range")
+	}
+	if noisyForecast.high-noisyForecast.low <= steadyForecast.high-steadyForecast.low {
+		t.Errorf("noisy range %v should be wider than steady range %v",
+			noisyForecast.high-noisyForecast.low, steadyForecast.high-steadyForecast.low)
+	}
+}
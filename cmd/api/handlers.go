@@ -8,10 +8,16 @@ import (
 	"net/http"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/invoice"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/plan"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
 )
 
 func (cfg *apiConfig) getCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -65,7 +71,8 @@ func (cfg *apiConfig) getCurrentUserHandler(w http.ResponseWriter, r *http.Reque
 
 func (cfg *apiConfig) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Name string `json:"name"`
+		Name      string `json:"name"`
+		Essential bool   `json:"essential"`
 	}
 
 	userID, ok := GetUserID(r.Context())
@@ -122,6 +129,7 @@ func (cfg *apiConfig) createAPIKeyHandler(w http.ResponseWriter, r *http.Request
 		Key:            keyString,
 		Name:           params.Name,
 		IsActive:       true,
+		Essential:      params.Essential,
 	})
 
 	if err != nil {
@@ -140,6 +148,7 @@ func (cfg *apiConfig) createAPIKeyHandler(w http.ResponseWriter, r *http.Request
 			"name":         apiKey.Name,
 			"key":          apiKey.Key,
 			"is_active":    apiKey.IsActive,
+			"essential":    apiKey.Essential,
 			"created_at":   apiKey.CreatedAt,
 			"last_used_at": apiKey.LastUsedAt,
 		},
@@ -181,6 +190,8 @@ func (cfg *apiConfig) listAPIKeysHandler(w http.ResponseWriter, r *http.Request)
 			"name":         key.Name,
 			"key":          maskAPIKey(key.Key),
 			"is_active":    key.IsActive,
+			"essential":    key.Essential,
+			"suspended_at": key.SuspendedAt,
 			"created_at":   key.CreatedAt,
 			"last_used_at": key.LastUsedAt,
 		})
@@ -321,10 +332,16 @@ func (cfg *apiConfig) sendMessageHandler(w http.ResponseWriter, r *http.Request)
 
 	orgID, _ := GetOrgID(r.Context())
 
-	cost := 0.01
+	units := smsSegments(params.Message)
 	if params.Type == "email" {
-		cost = 0.001
+		units = 1
 	}
+	SetUsageDetails(r.Context(), params.Type, units)
+
+	// The list price of the units; included units are only applied when the
+	// period is invoiced
+	plan, _ := r.Context().Value(orgPlanKey).(database.PlanType)
+	cost := cfg.pricing.UnitRate(plan, time.Now().UTC(), params.Type).Mul(decimal.NewFromInt(int64(units)))
 
 	messageID := fmt.Sprintf("msg_%s", generateRandomString(32))
 
@@ -335,7 +352,8 @@ func (cfg *apiConfig) sendMessageHandler(w http.ResponseWriter, r *http.Request)
 			"to":              params.To,
 			"status":          "queued",
 			"type":            params.Type,
-			"cost":            cost,
+			"cost":            cost.InexactFloat64(),
+			"units":           units,
 			"usage_recorded":  true,
 			"organization_id": orgID,
 			"created_at":      time.Now(),
@@ -343,6 +361,25 @@ func (cfg *apiConfig) sendMessageHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// smsSegments returns how many SMS parts a message is split into. ASCII
+// messages are treated as GSM 7-bit with 160 characters (153 when
+// concatenated); anything else is sent as UCS-2 with 70 (67).
+func smsSegments(message string) int {
+	single, multi := 160, 153
+	for _, r := range message {
+		if r > unicode.MaxASCII {
+			single, multi = 70, 67
+			break
+		}
+	}
+
+	length := utf8.RuneCountInString(message)
+	if length <= single {
+		return 1
+	}
+	return (length + multi - 1) / multi
+}
+
 func (cfg *apiConfig) getMessageStatusHandler(w http.ResponseWriter, r *http.Request) {
 	messageID := r.PathValue("id")
 
@@ -429,19 +466,41 @@ func (cfg *apiConfig) getBillingUsageHandler(w http.ResponseWriter, r *http.Requ
 	startDatePg := pgtype.Timestamp{Time: startDate, Valid: true}
 	endDatePg := pgtype.Timestamp{Time: endDate, Valid: true}
 
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization details",
+		})
+		return
+	}
+
+	// What the range adds to the invoices, shared out by request
+	usageCost, quote, err := cfg.usageCharges(r.Context(), org, startDate, endDate)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve usage",
+		})
+		return
+	}
 	totalRequests, err := cfg.db.CountOrganizationUsage(r.Context(), database.CountOrganizationUsageParams{
 		OrganizationID: user.OrganizationID,
-		CreatedAt:      startDatePg,
-		CreatedAt_2:    endDatePg,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
 	})
 	if err != nil {
-		totalRequests = 0
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve usage",
+		})
+		return
 	}
 
 	usageByEndpoint, err := cfg.db.GetUsageByEndpoint(r.Context(), database.GetUsageByEndpointParams{
 		OrganizationID: user.OrganizationID,
-		CreatedAt:      startDatePg,
-		CreatedAt_2:    endDatePg,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
 	})
 	if err != nil {
 		usageByEndpoint = []database.GetUsageByEndpointRow{}
@@ -449,8 +508,8 @@ func (cfg *apiConfig) getBillingUsageHandler(w http.ResponseWriter, r *http.Requ
 
 	usageByAPIKey, err := cfg.db.GetUsageByAPIKey(r.Context(), database.GetUsageByAPIKeyParams{
 		OrganizationID: user.OrganizationID,
-		CreatedAt:      startDatePg,
-		CreatedAt_2:    endDatePg,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
 	})
 	if err != nil {
 		usageByAPIKey = []database.GetUsageByAPIKeyRow{}
@@ -458,20 +517,18 @@ func (cfg *apiConfig) getBillingUsageHandler(w http.ResponseWriter, r *http.Requ
 
 	dailyStats, err := cfg.db.GetDailyUsageStats(r.Context(), database.GetDailyUsageStatsParams{
 		OrganizationID: user.OrganizationID,
-		CreatedAt:      startDatePg,
-		CreatedAt_2:    endDatePg,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
 	})
 	if err != nil {
 		dailyStats = []database.GetDailyUsageStatsRow{}
 	}
 
 	var successCount, errorCount int64
-	totalCost := 0.0
 	endpointData := make([]map[string]interface{}, 0)
 
 	for _, endpoint := range usageByEndpoint {
-		cost := float64(endpoint.RequestCount) * 0.01 // $0.01 per request
-		totalCost += cost
+		cost := costShare(usageCost, totalRequests, endpoint.RequestCount)
 		successCount += endpoint.SuccessCount
 		errorCount += endpoint.ErrorCount
 
@@ -496,7 +553,7 @@ func (cfg *apiConfig) getBillingUsageHandler(w http.ResponseWriter, r *http.Requ
 			"name":     key.Name,
 			"key":      maskAPIKey(key.Key),
 			"requests": key.RequestCount,
-			"cost":     float64(key.RequestCount) * 0.01,
+			"cost":     costShare(usageCost, totalRequests, key.RequestCount),
 		})
 	}
 
@@ -507,7 +564,7 @@ func (cfg *apiConfig) getBillingUsageHandler(w http.ResponseWriter, r *http.Requ
 			"requests":      day.RequestCount,
 			"success_count": day.SuccessCount,
 			"error_count":   day.ErrorCount,
-			"cost":          float64(day.RequestCount) * 0.01,
+			"cost":          costShare(usageCost, totalRequests, day.RequestCount),
 		})
 	}
 
@@ -519,7 +576,9 @@ func (cfg *apiConfig) getBillingUsageHandler(w http.ResponseWriter, r *http.Requ
 				"successful_requests": successCount,
 				"failed_requests":     errorCount,
 				"success_rate":        successRate,
-				"total_cost":          totalCost,
+				"total_cost":          usageCost.InexactFloat64(),
+				"currency":            quote.Currency,
+				"price_book_version":  quote.Version,
 				"period": map[string]interface{}{
 					"start": startDate,
 					"end":   endDate,
@@ -563,40 +622,95 @@ func (cfg *apiConfig) getBillingHistoryHandler(w http.ResponseWriter, r *http.Re
 		cycles = []database.BillingCycle{}
 	}
 
+	cycleIDs := make([]uuid.UUID, 0, len(cycles))
+	for _, cycle := range cycles {
+		cycleIDs = append(cycleIDs, cycle.ID)
+	}
+
+	lineItems, err := cfg.db.ListInvoiceLineItems(r.Context(), cycleIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve invoice line items",
+		})
+		return
+	}
+
+	itemsByCycle := make(map[uuid.UUID][]map[string]interface{})
+	for _, item := range lineItems {
+		itemsByCycle[item.BillingCycleID] = append(itemsByCycle[item.BillingCycleID], map[string]interface{}{
+			"type":        item.Type,
+			"description": item.Description,
+			"quantity":    item.Quantity,
+			"unit_price":  numericToFloat64(item.UnitPrice),
+			"amount":      numericToFloat64(item.Amount),
+		})
+	}
+
 	invoices := make([]map[string]interface{}, 0)
 	var totalBilled, totalPaid, outstanding float64
+	byCurrency := make(map[string]map[string]float64)
 
 	for _, cycle := range cycles {
+		// Void invoices are listed but count towards nothing
 		amount := numericToFloat64(cycle.TotalAmount)
+		if cycle.Status == database.BillingStatusVoid {
+			amount = 0
+		}
 		totalBilled += amount
 
+		totals, ok := byCurrency[cycle.Currency]
+		if !ok {
+			totals = map[string]float64{"total_billed": 0, "total_paid": 0, "outstanding": 0}
+			byCurrency[cycle.Currency] = totals
+		}
+		totals["total_billed"] += amount
+
 		switch cycle.Status {
 		case database.BillingStatusPaid:
 			totalPaid += amount
+			totals["total_paid"] += amount
 		case database.BillingStatusPending, database.BillingStatusOverdue:
 			outstanding += amount
+			totals["outstanding"] += amount
+		}
+
+		items := itemsByCycle[cycle.ID]
+		if items == nil {
+			items = []map[string]interface{}{}
 		}
 
-		invoice := map[string]interface{}{
-			"id": cycle.ID,
+		entry := map[string]interface{}{
+			"id":             cycle.ID,
+			"invoice_number": cycle.InvoiceNumber,
+			"kind":           cycle.Kind,
 			"period": map[string]interface{}{
 				"start": cycle.PeriodStart,
 				"end":   cycle.PeriodEnd,
 			},
-			"total_requests": cycle.TotalRequests,
-			"total_amount":   amount,
-			"status":         cycle.Status,
-			"created_at":     cycle.CreatedAt,
+			"total_requests":     cycle.TotalRequests,
+			"total_amount":       amount,
+			"currency":           cycle.Currency,
+			"exchange_rate":      exchangeRateData(cycle),
+			"price_book_version": cycle.PriceBookVersion,
+			"breakdown":          invoiceBreakdown(cycle.Quote),
+			"line_items":         items,
+			"status":             cycle.Status,
+			"created_at":         cycle.CreatedAt,
+			"pdf_url":            invoiceDocumentPath(invoice.KindInvoice, cycle.ID),
 		}
 
 		if cycle.Status == database.BillingStatusPaid {
-			invoice["paid_at"] = cycle.CreatedAt
+			entry["receipt_url"] = invoiceDocumentPath(invoice.KindReceipt, cycle.ID)
+			if cycle.PaidAt.Valid {
+				entry["paid_at"] = cycle.PaidAt
+			}
 		}
 
-		dueDate := cycle.PeriodEnd.Time.Add(7 * 24 * time.Hour)
-		invoice["due_date"] = dueDate
+		dueDate := cycle.PeriodEnd.Time.Add(invoice.PaymentTerms)
+		entry["due_date"] = dueDate
 
-		invoices = append(invoices, invoice)
+		invoices = append(invoices, entry)
 	}
 
 	respondWithJSON(w, http.StatusOK, ApiResponse{
@@ -609,10 +723,13 @@ func (cfg *apiConfig) getBillingHistoryHandler(w http.ResponseWriter, r *http.Re
 				"total":       len(invoices),
 				"total_pages": 1,
 			},
+			// The totals add up invoices in every currency; by_currency
+			// splits them when an organization changed currency
 			"summary": map[string]interface{}{
 				"total_billed": totalBilled,
 				"total_paid":   totalPaid,
 				"outstanding":  outstanding,
+				"by_currency":  byCurrency,
 			},
 		},
 	})
@@ -643,16 +760,24 @@ func (cfg *apiConfig) calculateCurrentBillHandler(w http.ResponseWriter, r *http
 		periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
 		periodEnd := periodStart.AddDate(0, 1, 0).Add(-time.Second)
 
-		startPeriodPg := pgtype.Timestamp{Time: periodStart, Valid: true}
-		endPeriodPg := pgtype.Timestamp{Time: periodEnd, Valid: true}
-
-		totalRequests, _ := cfg.db.CountOrganizationUsage(r.Context(), database.CountOrganizationUsageParams{
-			OrganizationID: user.OrganizationID,
-			CreatedAt:      startPeriodPg,
-			CreatedAt_2:    endPeriodPg,
-		})
+		org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to retrieve organization details",
+			})
+			return
+		}
 
-		totalAmount := float64(totalRequests) * 0.01
+		// Priced like the invoice will be, for the time on each plan
+		quote, _, totalRequests, err := plan.Quote(r.Context(), cfg.db, cfg.pricing, org.ID, org.Plan, periodStart, periodEnd)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to retrieve usage",
+			})
+			return
+		}
 
 		respondWithJSON(w, http.StatusOK, ApiResponse{
 			Success: true,
@@ -662,7 +787,9 @@ func (cfg *apiConfig) calculateCurrentBillHandler(w http.ResponseWriter, r *http
 					"end":   periodEnd,
 				},
 				"total_requests": totalRequests,
-				"total_amount":   totalAmount,
+				"total_amount":   quote.Total.InexactFloat64(),
+				"currency":       quote.Currency,
+				"quote":          quoteData(quote),
 				"status":         "calculating",
 				"note":           "Current billing period - invoice not yet generated",
 			},
@@ -678,10 +805,14 @@ func (cfg *apiConfig) calculateCurrentBillHandler(w http.ResponseWriter, r *http
 				"start": currentCycle.PeriodStart,
 				"end":   currentCycle.PeriodEnd,
 			},
-			"total_requests": currentCycle.TotalRequests,
-			"total_amount":   numericToFloat64(currentCycle.TotalAmount),
-			"status":         currentCycle.Status,
-			"created_at":     currentCycle.CreatedAt,
+			"total_requests":     currentCycle.TotalRequests,
+			"total_amount":       numericToFloat64(currentCycle.TotalAmount),
+			"currency":           currentCycle.Currency,
+			"exchange_rate":      exchangeRateData(currentCycle),
+			"price_book_version": currentCycle.PriceBookVersion,
+			"breakdown":          invoiceBreakdown(currentCycle.Quote),
+			"status":             currentCycle.Status,
+			"created_at":         currentCycle.CreatedAt,
 		},
 	})
 }
@@ -722,8 +853,8 @@ func (cfg *apiConfig) getDashboardStatsHandler(w http.ResponseWriter, r *http.Re
 
 	totalRequests, _ := cfg.db.CountOrganizationUsage(r.Context(), database.CountOrganizationUsageParams{
 		OrganizationID: user.OrganizationID,
-		CreatedAt:      startDatePg,
-		CreatedAt_2:    endDatePg,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
 	})
 
 	apiKeys, _ := cfg.db.ListOrganizationAPIKeys(r.Context(), user.OrganizationID)
@@ -734,25 +865,21 @@ func (cfg *apiConfig) getDashboardStatsHandler(w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	now := time.Now()
-	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
-	monthEnd := now
-
-	startMonth := pgtype.Timestamp{Time: monthStart, Valid: true}
-	endMonth := pgtype.Timestamp{Time: monthEnd, Valid: true}
+	now := time.Now().UTC()
+	monthStart, _ := quota.CurrentPeriod(now)
 
+	// Priced like the invoice will be, for the time on each plan
+	monthQuote, _, _, _ := plan.Quote(r.Context(), cfg.db, cfg.pricing, org.ID, org.Plan, monthStart, now)
 	monthRequests, _ := cfg.db.CountOrganizationUsage(r.Context(), database.CountOrganizationUsageParams{
 		OrganizationID: user.OrganizationID,
-		CreatedAt:      startMonth,
-		CreatedAt_2:    endMonth,
+		StartTime:      pgtype.Timestamp{Time: monthStart, Valid: true},
+		EndTime:        pgtype.Timestamp{Time: now, Valid: true},
 	})
 
-	currentMonthCost := float64(monthRequests) * 0.01
-
 	usageByEndpoint, _ := cfg.db.GetUsageByEndpoint(r.Context(), database.GetUsageByEndpointParams{
 		OrganizationID: user.OrganizationID,
-		CreatedAt:      startDatePg,
-		CreatedAt_2:    endDatePg,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
 	})
 
 	var successCount, errorCount int64
@@ -776,7 +903,7 @@ func (cfg *apiConfig) getDashboardStatsHandler(w http.ResponseWriter, r *http.Re
 			"stats": map[string]interface{}{
 				"total_requests_30d":     totalRequests,
 				"current_month_requests": monthRequests,
-				"current_month_cost":     currentMonthCost,
+				"current_month_cost":     monthQuote.Total.InexactFloat64(),
 				"success_rate":           successRate,
 				"active_api_keys":        activeKeys,
 				"total_api_keys":         len(apiKeys),
@@ -812,8 +939,8 @@ func (cfg *apiConfig) getDashboardUsageGraphHandler(w http.ResponseWriter, r *ht
 
 	dailyStats, err := cfg.db.GetDailyUsageStats(r.Context(), database.GetDailyUsageStatsParams{
 		OrganizationID: user.OrganizationID,
-		CreatedAt:      startDatePg,
-		CreatedAt_2:    endDatePg,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
 	})
 	if err != nil {
 		dailyStats = []database.GetDailyUsageStatsRow{}
@@ -860,6 +987,15 @@ func (cfg *apiConfig) getDashboardAPIKeysHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization details",
+		})
+		return
+	}
+
 	startDate := time.Now().AddDate(0, 0, -30)
 	endDate := time.Now()
 
@@ -868,13 +1004,20 @@ func (cfg *apiConfig) getDashboardAPIKeysHandler(w http.ResponseWriter, r *http.
 
 	usageByAPIKey, err := cfg.db.GetUsageByAPIKey(r.Context(), database.GetUsageByAPIKeyParams{
 		OrganizationID: user.OrganizationID,
-		CreatedAt:      startDatePg,
-		CreatedAt_2:    endDatePg,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
 	})
 	if err != nil {
 		usageByAPIKey = []database.GetUsageByAPIKeyRow{}
 	}
 
+	usageCost, _, _ := cfg.usageCharges(r.Context(), org, startDate, endDate)
+	totalRequests, _ := cfg.db.CountOrganizationUsage(r.Context(), database.CountOrganizationUsageParams{
+		OrganizationID: user.OrganizationID,
+		StartTime:      startDatePg,
+		EndTime:        endDatePg,
+	})
+
 	keysData := make([]map[string]interface{}, 0)
 	for _, key := range usageByAPIKey {
 		keysData = append(keysData, map[string]interface{}{
@@ -882,7 +1025,7 @@ func (cfg *apiConfig) getDashboardAPIKeysHandler(w http.ResponseWriter, r *http.
 			"name":         key.Name,
 			"key":          maskAPIKey(key.Key),
 			"requests_30d": key.RequestCount,
-			"cost_30d":     float64(key.RequestCount) * 0.01,
+			"cost_30d":     costShare(usageCost, totalRequests, key.RequestCount),
 		})
 	}
 
@@ -898,10 +1041,12 @@ func (cfg *apiConfig) getDashboardAPIKeysHandler(w http.ResponseWriter, r *http.
 	})
 }
 
+// numericToFloat64 converts a DECIMAL column, keeping the cents and the
+// fractional unit prices of invoice line items
 func numericToFloat64(n pgtype.Numeric) float64 {
 	if !n.Valid {
 		return 0.0
 	}
-	val, _ := n.Int64Value()
-	return float64(val.Int64)
+	val, _ := n.Float64Value()
+	return val.Float64
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSMSSegments(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    int
+	}{
+		{
+			name:    "Short message",
+			message: "Your code is 123456",
+			want:    1,
+		},
+		{
+			name:    "Exactly one GSM segment",
+			message: strings.Repeat("a", 160),
+			want:    1,
+		},
+		{
+			name:    "Two GSM segments",
+			message: strings.Repeat("a", 161),
+			want:    2,
+		},
+		{
+			name:    "Unicode uses shorter segments",
+			message: strings.Repeat("é", 71),
+			want:    2,
+		},
+		{
+			name:    "Empty message",
+			message: "",
+			want:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := smsSegments(tt.message); got != tt.want {
+				t.Errorf("smsSegments() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stripe/stripe-go/v76"
 )
 
 // TestDatabaseIntegration tests database operations
@@ -109,4 +116,170 @@ func TestDatabaseIntegration(t *testing.T) {
 			t.Error("Retrieved API key doesn't match created key")
 		}
 	})
+
+	// Test a Stripe subscription against the local Stripe stub
+	t.Run("StripeSubscription", func(t *testing.T) {
+		cfg, stub := newStripeStubConfig(t)
+		cfg.db, cfg.pool = db, pool
+
+		org, _ := db.CreateOrganization(ctx, database.CreateOrganizationParams{
+			Name:  "Subscription Test Org",
+			Email: "subscriptiontest@example.com",
+			Plan:  database.PlanTypeFree,
+		})
+		defer db.DeleteOrganization(ctx, org.ID)
+		owner, _ := db.CreateUser(ctx, database.CreateUserParams{
+			OrganizationID: org.ID,
+			Email:          "subscriptionowner@example.com",
+			PasswordHash:   "hashedpassword",
+			Role:           database.UserRoleOwner,
+		})
+
+		call := func(handler http.HandlerFunc, method, body string) (int, map[string]interface{}) {
+			req := httptest.NewRequest(method, "/api/v1/billing/subscription", strings.NewReader(body))
+			req = req.WithContext(context.WithValue(req.Context(), userIDKey, owner.ID))
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			var resp map[string]interface{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+			return rec.Code, resp
+		}
+		webhook := func(eventType string, obj map[string]interface{}) {
+			payload, signature := stub.Event(eventType, obj)
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/stripe", strings.NewReader(string(payload)))
+			req.Header.Set("Stripe-Signature", signature)
+			rec := httptest.NewRecorder()
+			cfg.stripeWebhookHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s webhook = %d", eventType, rec.Code)
+			}
+		}
+
+		code, resp := call(cfg.createSubscriptionHandler, http.MethodPost, `{"plan":"starter"}`)
+		if code != http.StatusCreated {
+			t.Fatalf("create subscription = %d: %v", code, resp)
+		}
+		data := resp["data"].(map[string]interface{})["subscription"].(map[string]interface{})
+		subID := data["subscription_id"].(string)
+		if data["status"] != "incomplete" || data["payment_url"] == nil {
+			t.Errorf("subscription = %v, want incomplete with a payment URL", data)
+		}
+		if org, _ := db.GetOrganization(ctx, org.ID); org.Plan != database.PlanTypeFree {
+			t.Errorf("plan before the first invoice is paid = %s, want free", org.Plan)
+		}
+
+		// Paying the first invoice starts the subscription
+		stub.SetSubscriptionStatus(subID, stripe.SubscriptionStatusActive)
+		webhook("invoice.paid", map[string]interface{}{"id": "in_first", "object": "invoice", "status": "paid", "subscription": subID})
+		webhook("customer.subscription.updated", map[string]interface{}{"id": subID, "object": "subscription"})
+
+		if org, _ := db.GetOrganization(ctx, org.ID); org.Plan != database.PlanTypeStarter {
+			t.Errorf("plan once the subscription is active = %s, want starter", org.Plan)
+		}
+		if entry, err := db.GetCurrentPlanHistoryForUpdate(ctx, org.ID); err != nil || !entry.BilledByStripe {
+			t.Errorf("current plan = %+v, %v, want it billed by Stripe", entry, err)
+		}
+		stored, err := db.GetStripeSubscription(ctx, org.ID)
+		if err != nil || !stored.BillingStartedAt.Valid || !stored.MeteredItemID.Valid || stored.LatestInvoiceStatus.String != "paid" {
+			t.Errorf("stored subscription = %+v, %v", stored, err)
+		}
+
+		if code, _ := call(cfg.upgradePlanHandler, http.MethodPost, `{"plan":"pro"}`); code != http.StatusConflict {
+			t.Errorf("plan change outside the subscription = %d, want 409", code)
+		}
+
+		code, resp = call(cfg.changeSubscriptionHandler, http.MethodPut, `{"plan":"pro"}`)
+		if code != http.StatusOK {
+			t.Fatalf("change subscription = %d: %v", code, resp)
+		}
+		if org, _ := db.GetOrganization(ctx, org.ID); org.Plan != database.PlanTypePro {
+			t.Errorf("plan after the change = %s, want pro", org.Plan)
+		}
+
+		if code, _ := call(cfg.cancelSubscriptionHandler, http.MethodDelete, ""); code != http.StatusOK {
+			t.Errorf("cancel subscription = %d", code)
+		}
+		if stub.Subscription(subID)["cancel_at_period_end"] != true {
+			t.Error("subscription is not cancelled at the end of the period")
+		}
+
+		// Stripe ends the subscription when the period is over
+		stub.SetSubscriptionStatus(subID, stripe.SubscriptionStatusCanceled)
+		webhook("customer.subscription.deleted", map[string]interface{}{"id": subID, "object": "subscription"})
+
+		if org, _ := db.GetOrganization(ctx, org.ID); org.Plan != database.PlanTypeFree {
+			t.Errorf("plan once the subscription ended = %s, want free", org.Plan)
+		}
+	})
+
+	// Test charging a plan upgrade to a saved card against the Stripe stub
+	t.Run("PlanUpgradeCharge", func(t *testing.T) {
+		cfg, stub := newStripeStubConfig(t)
+		cfg.db, cfg.pool, cfg.pricing = db, pool, pricing.Default()
+
+		org, _ := db.CreateOrganization(ctx, database.CreateOrganizationParams{
+			Name:  "Upgrade Test Org",
+			Email: "upgradetest@example.com",
+			Plan:  database.PlanTypeFree,
+		})
+		defer db.DeleteOrganization(ctx, org.ID)
+		owner, _ := db.CreateUser(ctx, database.CreateUserParams{
+			OrganizationID: org.ID,
+			Email:          "upgradeowner@example.com",
+			PasswordHash:   "hashedpassword",
+			Role:           database.UserRoleOwner,
+		})
+		if _, err := db.UpsertPaymentMethod(ctx, database.UpsertPaymentMethodParams{
+			OrganizationID: org.ID,
+			Provider:       "stripe",
+			CustomerID:     pgtype.Text{String: "cus_upgrade", Valid: true},
+			Reference:      "pm_upgrade",
+			Email:          "upgradeowner@example.com",
+		}); err != nil {
+			t.Fatalf("UpsertPaymentMethod() error = %v", err)
+		}
+
+		upgrade := func() (int, map[string]interface{}) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/billing/upgrade", strings.NewReader(`{"plan":"starter"}`))
+			req = req.WithContext(context.WithValue(req.Context(), userIDKey, owner.ID))
+			rec := httptest.NewRecorder()
+			cfg.upgradePlanHandler(rec, req)
+			var resp map[string]interface{}
+			json.NewDecoder(rec.Body).Decode(&resp)
+			return rec.Code, resp
+		}
+
+		// A declined charge voids the invoice and leaves the plan alone
+		stub.DeclineCharges = true
+		if code, resp := upgrade(); code != http.StatusPaymentRequired {
+			t.Fatalf("declined upgrade = %d: %v", code, resp)
+		}
+		if org, _ := db.GetOrganization(ctx, org.ID); org.Plan != database.PlanTypeFree {
+			t.Errorf("plan after a declined upgrade = %s, want free", org.Plan)
+		}
+
+		// Retrying is charged under a new key and applies the upgrade
+		stub.DeclineCharges = false
+		code, resp := upgrade()
+		if code != http.StatusOK {
+			t.Fatalf("upgrade = %d: %v", code, resp)
+		}
+		invoice := resp["data"].(map[string]interface{})["proration_invoice"].(map[string]interface{})
+		if invoice["status"] != string(database.BillingStatusPaid) || len(stub.Charges()) != 1 {
+			t.Errorf("upgrade invoice = %v with %d charges, want it paid by one charge", invoice, len(stub.Charges()))
+		}
+		if org, _ := db.GetOrganization(ctx, org.ID); org.Plan != database.PlanTypeStarter {
+			t.Errorf("plan after the upgrade = %s, want starter", org.Plan)
+		}
+		cycles, _ := db.ListOrganizationBillingCycles(ctx, database.ListOrganizationBillingCyclesParams{OrganizationID: org.ID, Limit: 10})
+		var void int
+		for _, cycle := range cycles {
+			if cycle.Status == database.BillingStatusVoid {
+				void++
+			}
+		}
+		if void != 1 {
+			t.Errorf("%d void invoices, want the declined one", void)
+		}
+	})
 }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/email"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/invoice"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// invoiceLinkTTL is how long an emailed invoice or receipt link stays valid
+const invoiceLinkTTL = 30 * 24 * time.Hour
+
+// signInvoiceDocument returns the signature for fetching a billing cycle's
+// invoice or receipt until expires
+func signInvoiceDocument(secret string, kind invoice.Kind, cycleID uuid.UUID, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "invoice-document:%s:%s:%d", kind, cycleID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyInvoiceDocument checks a signature from signInvoiceDocument and that
+// it has not expired
+func verifyInvoiceDocument(secret string, kind invoice.Kind, cycleID uuid.UUID, expires int64, signature string, now time.Time) bool {
+	if now.Unix() > expires {
+		return false
+	}
+	expected := signInvoiceDocument(secret, kind, cycleID, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// invoiceDocumentPath is where a billing cycle's invoice or receipt is served
+func invoiceDocumentPath(kind invoice.Kind, cycleID uuid.UUID) string {
+	if kind == invoice.KindReceipt {
+		return fmt.Sprintf("/api/v1/billing/invoices/%s/receipt.pdf", cycleID)
+	}
+	return fmt.Sprintf("/api/v1/billing/invoices/%s/pdf", cycleID)
+}
+
+// invoiceDocumentURL builds a signed link to an invoice or receipt that
+// works without signing in, for emails
+func (cfg *apiConfig) invoiceDocumentURL(kind invoice.Kind, cycleID uuid.UUID, now time.Time) string {
+	expires := now.Add(invoiceLinkTTL).Unix()
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", signInvoiceDocument(cfg.jwtSecret, kind, cycleID, expires))
+
+	base := strings.TrimSuffix(cfg.config.APIURL, "/")
+	return base + invoiceDocumentPath(kind, cycleID) + "?" + query.Encode()
+}
+
+// invoiceSeller is who invoices and receipts are issued by
+func (cfg *apiConfig) invoiceSeller() invoice.Seller {
+	return invoice.Seller{
+		Name:    cfg.config.FromName,
+		Email:   cfg.config.FromEmail,
+		Website: cfg.config.AppURL,
+		Country: cfg.config.SellerCountry,
+		TaxID:   cfg.config.SellerTaxID,
+	}
+}
+
+// signedOrAuthenticated sends requests that carry a URL signature straight
+// to next, which verifies it, and the rest through the auth middleware
+func signedOrAuthenticated(authMiddleware func(http.Handler) http.Handler, next http.Handler) http.Handler {
+	authenticated := authMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("signature") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+func (cfg *apiConfig) getInvoicePDFHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.serveInvoiceDocument(w, r, invoice.KindInvoice)
+}
+
+func (cfg *apiConfig) getReceiptPDFHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.serveInvoiceDocument(w, r, invoice.KindReceipt)
+}
+
+// serveInvoiceDocument renders a billing cycle's invoice or receipt. Members
+// of the organization fetch it with their token; emailed links are signed.
+func (cfg *apiConfig) serveInvoiceDocument(w http.ResponseWriter, r *http.Request, kind invoice.Kind) {
+	cycleID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_INVOICE_ID",
+			Message: "Invalid invoice ID format",
+		})
+		return
+	}
+
+	var organizationID uuid.UUID
+	if r.URL.Query().Has("signature") {
+		expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+		if err != nil || !verifyInvoiceDocument(cfg.jwtSecret, kind, cycleID, expires, r.URL.Query().Get("signature"), time.Now()) {
+			respondWithError(w, http.StatusForbidden, ApiError{
+				Code:    "INVALID_SIGNATURE",
+				Message: "Document link is invalid or has expired",
+			})
+			return
+		}
+	} else {
+		userID, ok := GetUserID(r.Context())
+		if !ok {
+			respondWithError(w, http.StatusUnauthorized, ApiError{
+				Code:    "UNAUTHORIZED",
+				Message: "User not authenticated",
+			})
+			return
+		}
+
+		user, err := cfg.db.GetUser(r.Context(), userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to retrieve user details",
+			})
+			return
+		}
+		organizationID = user.OrganizationID
+	}
+
+	doc, err := invoice.Load(r.Context(), cfg.db, cycleID, cfg.invoiceSeller())
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && organizationID != uuid.Nil && doc.OrganizationID != organizationID) {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "INVOICE_NOT_FOUND",
+			Message: "Invoice not found",
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve invoice",
+		})
+		return
+	}
+
+	pdf, err := invoice.Render(doc, kind)
+	if errors.Is(err, invoice.ErrNotPaid) {
+		respondWithError(w, http.StatusConflict, ApiError{
+			Code:    "INVOICE_NOT_PAID",
+			Message: "A receipt is available once the invoice is paid",
+			Details: map[string]interface{}{
+				"status": doc.Status,
+			},
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to render document",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", doc.Filename(kind)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(pdf)))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(pdf)
+}
+
+// sendPaymentReceipt emails the organization that an invoice was paid, with
+// the receipt attached. It runs after the payment is recorded so the receipt
+// shows it.
+func (cfg *apiConfig) sendPaymentReceipt(cycleID uuid.UUID) {
+	doc, err := invoice.Load(context.Background(), cfg.db, cycleID, cfg.invoiceSeller())
+	if err != nil {
+		log.Printf("Failed to load receipt for billing cycle %s: %v", cycleID, err)
+		return
+	}
+
+	receipt, err := invoice.Render(doc, invoice.KindReceipt)
+	if err != nil {
+		// The confirmation still goes out, with the link instead
+		log.Printf("Failed to render receipt for billing cycle %s: %v", cycleID, err)
+	}
+
+	err = cfg.emailService.SendPaymentSuccess(doc.Customer.Email, email.PaymentSuccessData{
+		OrganizationName: doc.Customer.Name,
+		Amount:           doc.Total.InexactFloat64(),
+		Currency:         doc.Currency,
+		InvoiceNumber:    doc.Number,
+		ReceiptURL:       cfg.invoiceDocumentURL(invoice.KindReceipt, cycleID, time.Now()),
+	}, receipt)
+	if err != nil {
+		log.Printf("Failed to send payment receipt for billing cycle %s: %v", cycleID, err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/config"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/invoice"
+	"github.com/google/uuid"
+)
+
+func TestInvoiceDocumentURL(t *testing.T) {
+	cfg := &apiConfig{
+		jwtSecret: "test-secret",
+		config:    &config.Config{APIURL: "https://api.example.com/"},
+	}
+	cycleID := uuid.New()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	parsed, err := url.Parse(cfg.invoiceDocumentURL(invoice.KindReceipt, cycleID, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPath := "/api/v1/billing/invoices/" + cycleID.String() + "/receipt.pdf"
+	if parsed.Host != "api.example.com" || parsed.Path != wantPath {
+		t.Errorf("URL = %q, want host api.example.com and path %s", parsed, wantPath)
+	}
+
+	expires, _ := strconv.ParseInt(parsed.Query().Get("expires"), 10, 64)
+	signature := parsed.Query().Get("signature")
+	if !verifyInvoiceDocument(cfg.jwtSecret, invoice.KindReceipt, cycleID, expires, signature, now.Add(invoiceLinkTTL-time.Minute)) {
+		t.Error("receipt link does not verify before it expires")
+	}
+	if verifyInvoiceDocument(cfg.jwtSecret, invoice.KindReceipt, cycleID, expires, signature, now.Add(invoiceLinkTTL+time.Second)) {
+		t.Error("receipt link verifies after it expires")
+	}
+	if verifyInvoiceDocument(cfg.jwtSecret, invoice.KindInvoice, cycleID, expires, signature, now) {
+		t.Error("receipt signature also opens the invoice")
+	}
+	if verifyInvoiceDocument(cfg.jwtSecret, invoice.KindReceipt, uuid.New(), expires, signature, now) {
+		t.Error("signature opens another billing cycle")
+	}
+}
+
+func TestSignedOrAuthenticated(t *testing.T) {
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+	handler := signedOrAuthenticated(auth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := map[string]int{
+		"/api/v1/billing/invoices/x/pdf":                        http.StatusUnauthorized,
+		"/api/v1/billing/invoices/x/pdf?expires=1":              http.StatusUnauthorized,
+		"/api/v1/billing/invoices/x/pdf?expires=1&signature=ab": http.StatusOK,
+	}
+	for target, want := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", target, rec.Code, want)
+		}
+	}
+}
@@ -2,25 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/config"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/email"
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/usage"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
 
 type apiConfig struct {
-	db             *database.Queries
+	db *database.Queries
+	// pool runs the few handlers that must write in one transaction
+	pool           *pgxpool.Pool
 	jwtSecret      string
 	redisClient    *redis.Client
 	emailService   *email.EmailService
 	paymentService *payment.PaymentService
+	quotaTracker   *quota.Tracker
+	pricing        *pricing.Catalog
 	config         *config.Config
+	// shutdown is closed when the server stops so open streams end
+	shutdown chan struct{}
 }
 
 func main() {
@@ -62,6 +78,7 @@ func main() {
 	paymentService := payment.NewPaymentService(
 		cfg.StripeSecretKey,
 		cfg.StripeWebhookSecret,
+		cfg.StripeAPIURL,
 		cfg.PaystackSecretKey,
 		cfg.PaystackWebhookSecret,
 	)
@@ -69,13 +86,41 @@ func main() {
 
 	dbQueries := database.New(pool)
 
+	// Price books are read once; restart after publishing a new one
+	catalog, err := pricing.Load(ctx, dbQueries, cfg.PriceBookFile)
+	if err != nil {
+		log.Fatalf("Failed to load price books: %v", err)
+	}
+	log.Printf("Loaded %d price books", len(catalog.Books()))
+
+	// Exchange rates are read per request so published rates apply at once;
+	// loading them here only checks the file is valid
+	if _, err := currency.Load(ctx, dbQueries, cfg.FXRatesFile); err != nil {
+		log.Fatalf("Failed to load exchange rates: %v", err)
+	}
+
+	usagePipeline := usage.NewPipeline(
+		dbQueries,
+		cfg.UsageBufferSize,
+		cfg.UsageBatchSize,
+		time.Duration(cfg.UsageFlushIntervalMs)*time.Millisecond,
+	)
+	usagePipeline.Publish("usage_pipeline")
+
+	liveFeed := usage.NewLiveFeed(redisClient, time.Second)
+	usagePipeline.SetLiveFeed(liveFeed)
+
 	apiCfg := apiConfig{
 		db:             dbQueries,
+		pool:           pool,
 		jwtSecret:      cfg.JWTSecret,
 		redisClient:    redisClient,
 		emailService:   emailService,
 		paymentService: paymentService,
+		quotaTracker:   quota.NewTracker(redisClient, dbQueries),
+		pricing:        catalog,
 		config:         cfg,
+		shutdown:       make(chan struct{}),
 	}
 
 	mux := http.NewServeMux()
@@ -111,13 +156,61 @@ func main() {
 	mux.Handle("GET /api/v1/billing/usage", authMiddleware(http.HandlerFunc(apiCfg.getBillingUsageHandler)))
 	mux.Handle("GET /api/v1/billing/history", authMiddleware(http.HandlerFunc(apiCfg.getBillingHistoryHandler)))
 	mux.Handle("GET /api/v1/billing/calculate", authMiddleware(http.HandlerFunc(apiCfg.calculateCurrentBillHandler)))
+	mux.Handle("GET /api/v1/billing/forecast", authMiddleware(http.HandlerFunc(apiCfg.getBillingForecastHandler)))
 	mux.Handle("POST /api/v1/billing/upgrade", authMiddleware(http.HandlerFunc(apiCfg.upgradePlanHandler)))
+	mux.Handle("GET /api/v1/billing/plan-change", authMiddleware(http.HandlerFunc(apiCfg.getPlanChangeHandler)))
+	mux.Handle("DELETE /api/v1/billing/plan-change", authMiddleware(http.HandlerFunc(apiCfg.cancelPlanChangeHandler)))
+	mux.Handle("GET /api/v1/billing/trial", authMiddleware(http.HandlerFunc(apiCfg.getTrialHandler)))
+	mux.Handle("POST /api/v1/billing/trial", authMiddleware(http.HandlerFunc(apiCfg.startTrialHandler)))
+	mux.Handle("GET /api/v1/billing/payment-method", authMiddleware(http.HandlerFunc(apiCfg.getPaymentMethodHandler)))
+	mux.Handle("POST /api/v1/billing/payment-method", authMiddleware(http.HandlerFunc(apiCfg.setupPaymentMethodHandler)))
+	mux.Handle("GET /api/v1/billing/subscription", authMiddleware(http.HandlerFunc(apiCfg.getSubscriptionHandler)))
+	mux.Handle("POST /api/v1/billing/subscription", authMiddleware(http.HandlerFunc(apiCfg.createSubscriptionHandler)))
+	mux.Handle("PUT /api/v1/billing/subscription", authMiddleware(http.HandlerFunc(apiCfg.changeSubscriptionHandler)))
+	mux.Handle("DELETE /api/v1/billing/subscription", authMiddleware(http.HandlerFunc(apiCfg.cancelSubscriptionHandler)))
 	mux.Handle("POST /api/v1/billing/initiate-payment", authMiddleware(http.HandlerFunc(apiCfg.initiatePaymentHandler)))
+	mux.Handle("GET /api/v1/billing/quota", authMiddleware(http.HandlerFunc(apiCfg.getQuotaHandler)))
+	mux.Handle("PUT /api/v1/billing/quota-policy", authMiddleware(http.HandlerFunc(apiCfg.updateQuotaPolicyHandler)))
+	mux.Handle("GET /api/v1/billing/budget", authMiddleware(http.HandlerFunc(apiCfg.getUsageBudgetHandler)))
+	mux.Handle("PUT /api/v1/billing/budget", authMiddleware(http.HandlerFunc(apiCfg.updateUsageBudgetHandler)))
+	mux.Handle("DELETE /api/v1/billing/budget", authMiddleware(http.HandlerFunc(apiCfg.deleteUsageBudgetHandler)))
+	mux.Handle("GET /api/v1/billing/invoices/{id}/pdf", signedOrAuthenticated(authMiddleware, http.HandlerFunc(apiCfg.getInvoicePDFHandler)))
+	mux.Handle("GET /api/v1/billing/invoices/{id}/receipt.pdf", signedOrAuthenticated(authMiddleware, http.HandlerFunc(apiCfg.getReceiptPDFHandler)))
+	mux.Handle("GET /api/v1/billing/tax-profile", authMiddleware(http.HandlerFunc(apiCfg.getTaxProfileHandler)))
+	mux.Handle("PUT /api/v1/billing/tax-profile", authMiddleware(http.HandlerFunc(apiCfg.updateTaxProfileHandler)))
+	mux.Handle("GET /api/v1/billing/currency", authMiddleware(http.HandlerFunc(apiCfg.getBillingCurrencyHandler)))
+	mux.Handle("PUT /api/v1/billing/currency", authMiddleware(http.HandlerFunc(apiCfg.updateBillingCurrencyHandler)))
+	mux.Handle("GET /api/v1/billing/credits", authMiddleware(http.HandlerFunc(apiCfg.getCreditBalanceHandler)))
+	mux.Handle("PUT /api/v1/billing/credits/settings", authMiddleware(http.HandlerFunc(apiCfg.updateCreditSettingsHandler)))
+	mux.Handle("POST /api/v1/billing/credits/top-up", authMiddleware(http.HandlerFunc(apiCfg.topUpCreditHandler)))
+	mux.Handle("GET /api/v1/billing/discount", authMiddleware(http.HandlerFunc(apiCfg.getDiscountHandler)))
+	mux.Handle("POST /api/v1/billing/coupons/redeem", authMiddleware(http.HandlerFunc(apiCfg.redeemCouponHandler)))
 
 	// Dashboard
 	mux.Handle("GET /api/v1/dashboard/stats", authMiddleware(http.HandlerFunc(apiCfg.getDashboardStatsHandler)))
 	mux.Handle("GET /api/v1/dashboard/usage-graph", authMiddleware(http.HandlerFunc(apiCfg.getDashboardUsageGraphHandler)))
 	mux.Handle("GET /api/v1/dashboard/api-keys", authMiddleware(http.HandlerFunc(apiCfg.getDashboardAPIKeysHandler)))
+	mux.Handle("GET /api/v1/dashboard/stream", authMiddleware(http.HandlerFunc(apiCfg.getDashboardStreamHandler)))
+
+	// Analytics
+	mux.Handle("GET /api/v1/analytics/latency", authMiddleware(http.HandlerFunc(apiCfg.getLatencyAnalyticsHandler)))
+	mux.Handle("GET /api/v1/analytics/errors", authMiddleware(http.HandlerFunc(apiCfg.getErrorAnalyticsHandler)))
+	mux.Handle("GET /api/v1/analytics/timeseries", authMiddleware(http.HandlerFunc(apiCfg.getTimeseriesAnalyticsHandler)))
+
+	// Usage alerts
+	mux.Handle("GET /api/v1/alerts", authMiddleware(http.HandlerFunc(apiCfg.listUsageAlertsHandler)))
+	mux.Handle("POST /api/v1/alerts/{id}/acknowledge", authMiddleware(http.HandlerFunc(apiCfg.acknowledgeUsageAlertHandler)))
+
+	// Outbound events
+	mux.Handle("GET /api/v1/events", authMiddleware(http.HandlerFunc(apiCfg.listOutboundEventsHandler)))
+	mux.Handle("GET /api/v1/events/endpoint", authMiddleware(http.HandlerFunc(apiCfg.getWebhookEndpointHandler)))
+	mux.Handle("PUT /api/v1/events/endpoint", authMiddleware(http.HandlerFunc(apiCfg.updateWebhookEndpointHandler)))
+	mux.Handle("DELETE /api/v1/events/endpoint", authMiddleware(http.HandlerFunc(apiCfg.deleteWebhookEndpointHandler)))
+
+	// Usage exports
+	mux.Handle("POST /api/v1/usage/exports", authMiddleware(http.HandlerFunc(apiCfg.createUsageExportHandler)))
+	mux.Handle("GET /api/v1/usage/exports", authMiddleware(http.HandlerFunc(apiCfg.listUsageExportsHandler)))
+	mux.Handle("GET /api/v1/usage/exports/{id}", authMiddleware(http.HandlerFunc(apiCfg.getUsageExportHandler)))
 
 	// Team Management
 	mux.Handle("POST /api/v1/team/invite", authMiddleware(http.HandlerFunc(apiCfg.inviteTeamMemberHandler)))
@@ -126,22 +219,60 @@ func main() {
 	mux.Handle("PUT /api/v1/team/members/{id}/role", authMiddleware(http.HandlerFunc(apiCfg.updateUserRoleHandler)))
 	mux.Handle("DELETE /api/v1/team/invitations/{id}", authMiddleware(http.HandlerFunc(apiCfg.cancelInvitationHandler)))
 
+	// ============================================
+	// Admin Routes (operator token)
+	// ============================================
+	adminMiddleware := AdminMiddleware(cfg.AdminAPIToken)
+
+	mux.Handle("PUT /api/v1/admin/organizations/{id}/tax-exemption", adminMiddleware(http.HandlerFunc(apiCfg.updateTaxExemptionHandler)))
+	mux.Handle("GET /api/v1/admin/tax-rates", adminMiddleware(http.HandlerFunc(apiCfg.listTaxRatesHandler)))
+	mux.Handle("PUT /api/v1/admin/tax-rates", adminMiddleware(http.HandlerFunc(apiCfg.upsertTaxRateHandler)))
+	mux.Handle("GET /api/v1/admin/fx-rates", adminMiddleware(http.HandlerFunc(apiCfg.listFXRatesHandler)))
+	mux.Handle("PUT /api/v1/admin/fx-rates", adminMiddleware(http.HandlerFunc(apiCfg.upsertFXRateHandler)))
+	mux.Handle("GET /api/v1/admin/coupons", adminMiddleware(http.HandlerFunc(apiCfg.listCouponsHandler)))
+	mux.Handle("POST /api/v1/admin/coupons", adminMiddleware(http.HandlerFunc(apiCfg.createCouponHandler)))
+	mux.Handle("GET /api/v1/admin/coupons/{id}", adminMiddleware(http.HandlerFunc(apiCfg.getCouponHandler)))
+	mux.Handle("PUT /api/v1/admin/coupons/{id}", adminMiddleware(http.HandlerFunc(apiCfg.updateCouponHandler)))
+	mux.Handle("DELETE /api/v1/admin/coupons/{id}", adminMiddleware(http.HandlerFunc(apiCfg.deleteCouponHandler)))
+
 	// ============================================
 	// Webhook Routes (No auth - verified by signature)
 	// ============================================
 	mux.HandleFunc("POST /api/v1/webhooks/stripe", apiCfg.stripeWebhookHandler)
 	mux.HandleFunc("POST /api/v1/webhooks/paystack", apiCfg.paystackWebhookHandler)
 
+	// ============================================
+	// Signed Download Routes (No auth - verified by URL signature)
+	// ============================================
+	mux.HandleFunc("GET /api/v1/usage/exports/{id}/download", apiCfg.downloadUsageExportHandler)
+
 	// ============================================
 	// API Key Protected Routes (with rate limiting)
 	// ============================================
 	apiKeyMiddleware := APIKeyMiddleware(apiCfg.db)
 	rateLimitMiddleware := RateLimitMiddleware(apiCfg.redisClient, cfg.RateLimit)
-	usageTrackingMiddleware := UsageTrackingMiddleware(apiCfg.db)
+	concurrencyLimitMiddleware := ConcurrencyLimitMiddleware(
+		apiCfg.redisClient,
+		cfg.ConcurrencyLimit,
+		time.Duration(cfg.ConcurrencyLeaseSeconds)*time.Second,
+	)
+	quotaMiddleware := QuotaMiddleware(apiCfg.quotaTracker, apiCfg.db, apiCfg.emailService, cfg.AppURL)
+	usageTrackingMiddleware := UsageTrackingMiddleware(usagePipeline)
 
-	messageHandler := usageTrackingMiddleware(
-		rateLimitMiddleware(
-			apiKeyMiddleware(http.HandlerFunc(apiCfg.sendMessageHandler)),
+	// The API key middleware must run first: everything after it relies on
+	// the organization it puts in the request context. The quota is counted
+	// only once the rate and concurrency limits have let a request through,
+	// so rejected requests do not use it up. Requests rejected by any of
+	// these checks are not recorded as usage.
+	messageHandler := apiKeyMiddleware(
+		PrepaidCreditMiddleware(
+			rateLimitMiddleware(
+				concurrencyLimitMiddleware(
+					quotaMiddleware(
+						usageTrackingMiddleware(http.HandlerFunc(apiCfg.sendMessageHandler)),
+					),
+				),
+			),
 		),
 	)
 	mux.Handle("POST /api/v1/messages/send", messageHandler)
@@ -149,10 +280,16 @@ func main() {
 	messageStatusHandler := apiKeyMiddleware(http.HandlerFunc(apiCfg.getMessageStatusHandler))
 	mux.Handle("GET /api/v1/messages/{id}", messageStatusHandler)
 
+	// ============================================
+	// Metrics (operator token: exposes the command line and memory stats)
+	// ============================================
+	mux.Handle("GET /debug/vars", adminMiddleware(expvar.Handler()))
+
 	// Apply global middleware
 	handler := middlewareCors(mux)
 	handler = LoggingMiddleware(handler)
 	handler = SecurityHeadersMiddleware(handler)
+	handler = RequestIDMiddleware(handler)
 
 	server := &http.Server{
 		Addr:              ":" + cfg.Port,
@@ -162,10 +299,36 @@ func main() {
 		WriteTimeout:      30 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
+	// Shutdown waits for requests to finish, which streams never do on their own
+	server.RegisterOnShutdown(func() { close(apiCfg.shutdown) })
 
-	log.Printf("Server starting on port %s", cfg.Port)
-	log.Printf("Environment: %s", cfg.Environment)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	go func() {
+		log.Printf("Server starting on port %s", cfg.Port)
+		log.Printf("Environment: %s", cfg.Environment)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	<-quit
+	log.Println("Shutting down server...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
 	}
+
+	// Handlers have returned, so nothing else will be enqueued. Flush what is
+	// buffered before the database pool closes.
+	if err := usagePipeline.Close(shutdownCtx); err != nil {
+		log.Printf("Usage pipeline did not drain: %v (%+v)", err, usagePipeline.Stats())
+	}
+	liveFeed.Close()
+
+	log.Println("Server stopped successfully")
 }
@@ -2,26 +2,38 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/auth"
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/email"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/usage"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/redis/go-redis/v9"
 )
 
 type contextKey string
 
 const (
-	userIDKey   contextKey = "user_id"
-	orgIDKey    contextKey = "org_id"
-	apiKeyIDKey contextKey = "api_key_id"
-	userRoleKey contextKey = "user_role"
+	userIDKey        contextKey = "user_id"
+	orgIDKey         contextKey = "org_id"
+	orgPlanKey       contextKey = "org_plan"
+	quotaPolicyKey   contextKey = "quota_policy"
+	creditBlockedKey contextKey = "credit_blocked"
+	apiKeyIDKey      contextKey = "api_key_id"
+	userRoleKey      contextKey = "user_role"
+	requestIDKey     contextKey = "request_id"
+	usageKey         contextKey = "usage_details"
 )
 
 func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
@@ -62,6 +74,33 @@ func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 	}
 }
 
+// AdminMiddleware guards the operator endpoints with a shared bearer token.
+// With no token configured they are disabled.
+func AdminMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				respondWithError(w, http.StatusForbidden, ApiError{
+					Code:    "ADMIN_API_DISABLED",
+					Message: "The admin API is not enabled",
+				})
+				return
+			}
+
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				respondWithError(w, http.StatusUnauthorized, ApiError{
+					Code:    "UNAUTHORIZED",
+					Message: "A valid admin token is required",
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func APIKeyMiddleware(db *database.Queries) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -90,6 +129,9 @@ func APIKeyMiddleware(db *database.Queries) func(http.Handler) http.Handler {
 
 			ctx := context.WithValue(r.Context(), apiKeyIDKey, keyData.ID)
 			ctx = context.WithValue(ctx, orgIDKey, keyData.OrgID)
+			ctx = context.WithValue(ctx, orgPlanKey, keyData.OrgPlan)
+			ctx = context.WithValue(ctx, quotaPolicyKey, keyData.OrgQuotaPolicy)
+			ctx = context.WithValue(ctx, creditBlockedKey, keyData.OrgCreditBlocked)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -147,40 +189,278 @@ func RateLimitMiddleware(redisClient *redis.Client, limit int) func(http.Handler
 	}
 }
 
-func UsageTrackingMiddleware(db *database.Queries) func(http.Handler) http.Handler {
+// acquireConcurrencySlot drops leases older than the lease TTL, then adds a new
+// lease to the organization's sorted set if it still has a free slot.
+var acquireConcurrencySlot = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - ttl)
+if redis.call('ZCARD', key) >= limit then
+	return 0
+end
+
+redis.call('ZADD', key, now, ARGV[4])
+redis.call('PEXPIRE', key, ttl)
+return 1
+`)
+
+func ConcurrencyLimitMiddleware(redisClient *redis.Client, limit int, leaseTTL time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			orgID, ok := r.Context().Value(orgIDKey).(uuid.UUID)
+			if !ok {
+				respondWithError(w, http.StatusInternalServerError, ApiError{
+					Code:    "INTERNAL_ERROR",
+					Message: "Failed to identify organization",
+				})
+				return
+			}
+
+			key := fmt.Sprintf("concurrency:%s", orgID.String())
+			leaseID := uuid.New().String()
+
+			acquired, err := acquireConcurrencySlot.Run(r.Context(), redisClient, []string{key},
+				time.Now().UnixMilli(),
+				leaseTTL.Milliseconds(),
+				limit,
+				leaseID,
+			).Int()
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-Concurrency-Limit", fmt.Sprintf("%d", limit))
+
+			if acquired == 0 {
+				w.Header().Set("Retry-After", "1")
+
+				respondWithError(w, http.StatusTooManyRequests, ApiError{
+					Code:    "CONCURRENCY_LIMIT_EXCEEDED",
+					Message: "Too many concurrent requests for your organization",
+					Details: map[string]interface{}{
+						"limit":       limit,
+						"retry_after": 1,
+					},
+				})
+				return
+			}
+
+			defer func() {
+				// The request context may already be cancelled, so release with
+				// a fresh one. If this fails the lease simply expires.
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				redisClient.ZRem(ctx, key, leaseID)
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// PrepaidCreditMiddleware turns away API key traffic of organizations whose
+// prepaid credit has run out, when they asked to be blocked at zero. The
+// credit balance job sets and clears the block.
+func PrepaidCreditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if blocked, _ := r.Context().Value(creditBlockedKey).(bool); blocked {
+			respondWithError(w, http.StatusPaymentRequired, ApiError{
+				Code:    "CREDIT_EXHAUSTED",
+				Message: "Prepaid credit has run out. Top up to resume sending.",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func QuotaMiddleware(tracker *quota.Tracker, db *database.Queries, emailService *email.EmailService, appURL string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			orgID, ok := r.Context().Value(orgIDKey).(uuid.UUID)
+			if !ok {
+				respondWithError(w, http.StatusInternalServerError, ApiError{
+					Code:    "INTERNAL_ERROR",
+					Message: "Failed to identify organization",
+				})
+				return
+			}
+
+			plan, _ := r.Context().Value(orgPlanKey).(database.PlanType)
+			policy, _ := r.Context().Value(quotaPolicyKey).(database.QuotaPolicy)
+
+			limit := quota.MonthlyLimit(plan)
+			if limit == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+
+			used, err := tracker.Increment(ctx, orgID)
+			if err != nil {
+				log.Printf("Quota check failed for org %s: %v", orgID, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			_, periodEnd := quota.CurrentPeriod(time.Now())
+			resetsAt := periodEnd.Add(time.Second)
+
+			w.Header().Set("X-Quota-Limit", fmt.Sprintf("%d", limit))
+			w.Header().Set("X-Quota-Remaining", fmt.Sprintf("%d", max(limit-used, 0)))
+			w.Header().Set("X-Quota-Reset", fmt.Sprintf("%d", resetsAt.Unix()))
+
+			if used > limit {
+				switch policy {
+				case database.QuotaPolicyOverage:
+					// Requests above the quota are billed as overage
+
+				case database.QuotaPolicyNotify:
+					first, err := tracker.MarkNotified(ctx, orgID)
+					if err == nil && first {
+						go func() {
+							org, err := db.GetOrganization(context.Background(), orgID)
+							if err != nil {
+								log.Printf("Failed to get organization for quota notice: %v", err)
+								return
+							}
+							err = emailService.SendQuotaExceeded(org.Email, email.QuotaExceededData{
+								OrganizationName: org.Name,
+								Plan:             string(org.Plan),
+								Limit:            limit,
+								Used:             used,
+								ResetsAt:         resetsAt.Format("January 2, 2006"),
+								UpgradeURL:       appURL + "/billing",
+							})
+							if err != nil {
+								log.Printf("Failed to send quota notice to org %s: %v", orgID, err)
+							}
+						}()
+					}
+
+				default:
+					// The rejected request is taken back off the count
+					tracker.Decrement(ctx, orgID)
+
+					respondWithError(w, http.StatusTooManyRequests, ApiError{
+						Code:    "QUOTA_EXCEEDED",
+						Message: "Monthly request quota exceeded for your plan",
+						Details: map[string]interface{}{
+							"limit":     limit,
+							"used":      used - 1,
+							"resets_at": resetsAt,
+						},
+					})
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// usageDetails is filled in by handlers that know what a request consumed,
+// such as the message type and how many billable units it used.
+type usageDetails struct {
+	messageType string
+	units       int
+}
+
+// UsageTrackingMiddleware records each request in the usage pipeline, which
+// writes them to usage_records in batches off the request path.
+func UsageTrackingMiddleware(pipeline *usage.Pipeline) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			orgID, _ := r.Context().Value(orgIDKey).(uuid.UUID)
 			apiKeyID, _ := r.Context().Value(apiKeyIDKey).(uuid.UUID)
+			receivedAt := time.Now().UTC()
 
 			recorder := &statusRecorder{
 				ResponseWriter: w,
 				statusCode:     http.StatusOK,
 			}
+			body := &countingReader{ReadCloser: r.Body}
+			r.Body = body
 
-			next.ServeHTTP(recorder, r)
+			details := &usageDetails{units: 1}
+			ctx := context.WithValue(r.Context(), usageKey, details)
+
+			next.ServeHTTP(recorder, r.WithContext(ctx))
 
 			if recorder.statusCode > math.MaxInt32 {
 				recorder.statusCode = math.MaxInt32
 			}
 
-			go func() {
-				ctx := context.Background()
-				_, err := db.CreateUsageRecord(ctx, database.CreateUsageRecordParams{
-					OrganizationID: orgID,
-					ApiKeyID:       apiKeyID,
-					Endpoint:       r.URL.Path,
-					Method:         r.Method,
-					StatusCode:     int32(recorder.statusCode),
-				})
-				if err != nil {
-					fmt.Printf("Failed to record usage: %v\n", err)
-				}
-			}()
+			requestBytes := body.bytesRead
+			if r.ContentLength > requestBytes {
+				requestBytes = r.ContentLength
+			}
+
+			// Drops are counted by the pipeline rather than logged, since a
+			// full buffer means logging once per request would flood the output.
+			pipeline.Enqueue(database.CreateUsageRecordsParams{
+				OrganizationID: orgID,
+				ApiKeyID:       apiKeyID,
+				Endpoint:       r.URL.Path,
+				Method:         r.Method,
+				StatusCode:     int32(recorder.statusCode),
+				CreatedAt:      pgtype.Timestamp{Time: receivedAt, Valid: true},
+				DurationMs:     int32(min(time.Since(receivedAt).Milliseconds(), math.MaxInt32)),
+				RequestBytes:   requestBytes,
+				ResponseBytes:  recorder.bytesWritten,
+				ClientIp:       optionalString(clientIP(r), 45),
+				UserAgent:      optionalString(r.UserAgent(), 512),
+				RequestID:      optionalString(GetRequestID(r.Context()), 64),
+				MessageType:    optionalString(details.messageType, 32),
+				Units:          int32(min(details.units, math.MaxInt32)),
+			})
 		})
 	}
 }
 
+// SetUsageDetails records the message type and unit count for the current
+// request. It does nothing outside UsageTrackingMiddleware.
+func SetUsageDetails(ctx context.Context, messageType string, units int) {
+	details, ok := ctx.Value(usageKey).(*usageDetails)
+	if !ok {
+		return
+	}
+	details.messageType = messageType
+	details.units = max(units, 0)
+}
+
+// clientIP prefers the first X-Forwarded-For entry set by the load balancer.
+// It is recorded for analytics only and must not be used for access control.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// optionalString maps empty values to NULL and truncates to the column size
+func optionalString(value string, maxLen int) *string {
+	if value == "" {
+		return nil
+	}
+	if len(value) > maxLen {
+		value = value[:maxLen]
+	}
+	return &value
+}
+
 func middlewareCors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -242,7 +522,7 @@ func RequestIDMiddleware(next http.Handler) http.Handler {
 		requestID := generateSecureToken(16)
 		w.Header().Set("X-Request-ID", requestID)
 
-		ctx := context.WithValue(r.Context(), contextKey("request_id"), requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -267,7 +547,8 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 
 type statusRecorder struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode   int
+	bytesWritten int64
 }
 
 func (rec *statusRecorder) WriteHeader(code int) {
@@ -275,6 +556,29 @@ func (rec *statusRecorder) WriteHeader(code int) {
 	rec.ResponseWriter.WriteHeader(code)
 }
 
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytesWritten += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// countingReader counts the request body bytes a handler reads
+type countingReader struct {
+	io.ReadCloser
+	bytesRead int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.bytesRead += int64(n)
+	return n, err
+}
+
 func GetUserID(ctx context.Context) (uuid.UUID, bool) {
 	userID, ok := ctx.Value(userIDKey).(uuid.UUID)
 	return userID, ok
@@ -289,3 +593,8 @@ func GetAPIKeyID(ctx context.Context) (uuid.UUID, bool) {
 	apiKeyID, ok := ctx.Value(apiKeyIDKey).(uuid.UUID)
 	return apiKeyID, ok
 }
+
+func GetRequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
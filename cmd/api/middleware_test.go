@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/auth"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/usage"
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 )
 
 func TestAuthMiddleware(t *testing.T) {
@@ -66,6 +73,72 @@ func TestAuthMiddleware(t *testing.T) {
 	}
 }
 
+func TestAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		token          string
+		authHeader     string
+		expectedStatus int
+	}{
+		{"Valid token", "admin-token", "Bearer admin-token", http.StatusOK},
+		{"Wrong token", "admin-token", "Bearer other-token", http.StatusUnauthorized},
+		{"Missing auth header", "admin-token", "", http.StatusUnauthorized},
+		{"Disabled", "", "Bearer ", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/admin/tax-rates", nil)
+			if tt.authHeader != "" {
+				req.Header.Set("Authorization", tt.authHeader)
+			}
+			rr := httptest.NewRecorder()
+
+			handler := AdminMiddleware(tt.token)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestPrepaidCreditMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		blocked        interface{}
+		expectedStatus int
+	}{
+		{"Credit available", false, http.StatusOK},
+		{"Blocked at zero", true, http.StatusPaymentRequired},
+		{"No API key context", nil, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/messages/send", nil)
+			if tt.blocked != nil {
+				req = req.WithContext(context.WithValue(req.Context(), creditBlockedKey, tt.blocked))
+			}
+			rr := httptest.NewRecorder()
+
+			handler := PrepaidCreditMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
 func TestCORSMiddleware(t *testing.T) {
 	req := httptest.NewRequest("OPTIONS", "/test", nil)
 	rr := httptest.NewRecorder()
@@ -84,3 +157,141 @@ func TestCORSMiddleware(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", rr.Code)
 	}
 }
+
+// newTestRedis connects to the Redis instance in TEST_REDIS_URL
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+
+	redisURL := os.Getenv("TEST_REDIS_URL")
+	if redisURL == "" {
+		t.Skip("TEST_REDIS_URL not set")
+	}
+
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		t.Fatalf("Failed to parse Redis URL: %v", err)
+	}
+
+	client := redis.NewClient(opt)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	redisClient := newTestRedis(t)
+	orgID := uuid.New()
+	defer redisClient.Del(context.Background(), "concurrency:"+orgID.String())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	middleware := ConcurrencyLimitMiddleware(redisClient, 1, time.Minute)
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") == "true" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	newRequest := func(target string) *http.Request {
+		req := httptest.NewRequest("POST", target, nil)
+		return req.WithContext(context.WithValue(req.Context(), orgIDKey, orgID))
+	}
+
+	firstDone := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newRequest("/test?block=true"))
+		firstDone <- rr.Code
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest("/test"))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d while slot is held, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	close(release)
+	if code := <-firstDone; code != http.StatusOK {
+		t.Errorf("Expected first request status 200, got %d", code)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequest("/test"))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after slot was released, got %d", rr.Code)
+	}
+}
+
+type capturingUsageWriter struct {
+	records []database.CreateUsageRecordsParams
+}
+
+func (c *capturingUsageWriter) CreateUsageRecords(ctx context.Context, arg []database.CreateUsageRecordsParams) (int64, error) {
+	c.records = append(c.records, arg...)
+	return int64(len(arg)), nil
+}
+
+func TestUsageTrackingMiddleware(t *testing.T) {
+	writer := &capturingUsageWriter{}
+	pipeline := usage.NewPipeline(writer, 10, 10, time.Hour)
+	orgID := uuid.New()
+
+	handler := RequestIDMiddleware(UsageTrackingMiddleware(pipeline)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		SetUsageDetails(r.Context(), "sms", 3)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"ok":true}`))
+	})))
+
+	req := httptest.NewRequest("POST", "/api/v1/messages/send", strings.NewReader(`{"to":"+2348000000000"}`))
+	req = req.WithContext(context.WithValue(req.Context(), orgIDKey, orgID))
+	req.RemoteAddr = "10.0.0.5:51234"
+	req.Header.Set("User-Agent", "sdk-go/1.2.0")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if err := pipeline.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to drain usage pipeline: %v", err)
+	}
+	if len(writer.records) != 1 {
+		t.Fatalf("Expected 1 usage record, got %d", len(writer.records))
+	}
+
+	record := writer.records[0]
+	if record.OrganizationID != orgID {
+		t.Errorf("Expected organization %s, got %s", orgID, record.OrganizationID)
+	}
+	if record.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d", http.StatusCreated, record.StatusCode)
+	}
+	if record.RequestBytes != int64(len(`{"to":"+2348000000000"}`)) {
+		t.Errorf("Unexpected request bytes: %d", record.RequestBytes)
+	}
+	if record.ResponseBytes != int64(len(`{"ok":true}`)) {
+		t.Errorf("Unexpected response bytes: %d", record.ResponseBytes)
+	}
+	if record.ClientIp == nil || *record.ClientIp != "203.0.113.7" {
+		t.Errorf("Expected client IP 203.0.113.7, got %v", record.ClientIp)
+	}
+	if record.UserAgent == nil || *record.UserAgent != "sdk-go/1.2.0" {
+		t.Errorf("Expected user agent sdk-go/1.2.0, got %v", record.UserAgent)
+	}
+	if record.RequestID == nil || *record.RequestID != rr.Header().Get("X-Request-ID") {
+		t.Errorf("Expected request ID %q, got %v", rr.Header().Get("X-Request-ID"), record.RequestID)
+	}
+	if record.MessageType == nil || *record.MessageType != "sms" {
+		t.Errorf("Expected message type sms, got %v", record.MessageType)
+	}
+	if record.Units != 3 {
+		t.Errorf("Expected 3 units, got %d", record.Units)
+	}
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/jobs"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/plan"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/tax"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stripe/stripe-go/v76"
+)
+
+// upgradePlanHandler moves the organization to another plan. Upgrades take
+// effect at once and the new plan's base fee for the rest of the period is
+// invoiced straight away, less what is left of a prepaid base fee, and
+// charged to the saved payment method before the upgrade is applied. An
+// upgrade that cannot be paid for is not made. Downgrades are scheduled for
+// the end of the period; the organization keeps what it paid for until
+// then.
+func (cfg *apiConfig) upgradePlanHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Plan string `json:"plan"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can upgrade plan",
+		})
+		return
+	}
+
+	var newPlan database.PlanType
+	switch params.Plan {
+	case "starter":
+		newPlan = database.PlanTypeStarter
+	case "pro":
+		newPlan = database.PlanTypePro
+	case "free":
+		newPlan = database.PlanTypeFree
+	default:
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_PLAN",
+			Message: "Plan must be 'free', 'starter', or 'pro'",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization",
+		})
+		return
+	}
+
+	if newPlan == org.Plan {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "SAME_PLAN",
+			Message: "Organization is already on this plan",
+		})
+		return
+	}
+
+	// A trial ends by converting or going back to free; the plan cannot be
+	// changed in the meantime
+	if org.TrialStatus.TrialStatus == database.TrialStatusActive {
+		respondWithError(w, http.StatusConflict, ApiError{
+			Code:    "TRIAL_ACTIVE",
+			Message: "Plan cannot be changed during a trial",
+		})
+		return
+	}
+
+	// Stripe bills a subscribed organization, so its plan changes with the
+	// subscription
+	sub, err := cfg.db.GetStripeSubscription(r.Context(), org.ID)
+	if err == nil && payment.Billing(stripe.SubscriptionStatus(sub.Status)) {
+		respondWithError(w, http.StatusConflict, ApiError{
+			Code:    "SUBSCRIPTION_ACTIVE",
+			Message: "Plan is billed by a subscription, change the subscription instead",
+		})
+		return
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve subscription",
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	periodStart, _ := quota.CurrentPeriod(now)
+	periodEnd := periodStart.AddDate(0, 1, 0)
+
+	if !plan.IsUpgrade(cfg.pricing, org.Plan, newPlan, periodStart) {
+		cfg.scheduleDowngrade(w, r, org, newPlan, userID, periodEnd)
+		return
+	}
+
+	method, err := cfg.db.GetPaymentMethod(r.Context(), org.ID)
+	hasMethod := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve payment method",
+		})
+		return
+	}
+
+	rates, err := currency.Load(r.Context(), cfg.db, cfg.config.FXRatesFile)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve exchange rates",
+		})
+		return
+	}
+	taxes, err := tax.Load(r.Context(), cfg.db)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve tax rates",
+		})
+		return
+	}
+
+	// The upgrade's invoice is committed before it is charged, and the
+	// upgrade applied after, so no lock is held while the provider is
+	// called. A charge whose outcome is lost leaves the invoice pending;
+	// retrying the upgrade charges that invoice under the same key, which
+	// finds the first charge rather than making another.
+	tx, err := cfg.pool.Begin(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to update plan",
+		})
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	qtx := cfg.db.WithTx(tx)
+
+	current, org, err := cfg.lockPlanForUpgrade(r.Context(), qtx, org.ID, newPlan, periodStart)
+	if err != nil {
+		respondWithUpgradeError(w, err)
+		return
+	}
+
+	charge := plan.UpgradeCharge(cfg.pricing, current, newPlan, now, periodStart, periodEnd)
+	change := plan.Change{
+		Plan:         newPlan,
+		At:           now,
+		PrepaidUntil: periodEnd,
+		ChangedBy:    userID,
+	}
+
+	var cycle database.BillingCycle
+	invoiced := charge.Total.IsPositive()
+	if invoiced && !hasMethod {
+		respondWithError(w, http.StatusPaymentRequired, ApiError{
+			Code:    "PAYMENT_METHOD_REQUIRED",
+			Message: "Save a payment method to upgrade; the prorated base fee is charged to it",
+		})
+		return
+	}
+	if invoiced {
+		cycle, err = cfg.upgradeInvoice(r.Context(), qtx, rates, taxes, org, charge, periodEnd, now)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to invoice plan upgrade",
+			})
+			return
+		}
+		declined, err := qtx.CountVoidUpgradeInvoices(r.Context(), database.CountVoidUpgradeInvoicesParams{
+			OrganizationID: org.ID,
+			Plan:           string(newPlan),
+			PeriodEnd:      cycle.PeriodEnd,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to invoice plan upgrade",
+			})
+			return
+		}
+		if err := tx.Commit(r.Context()); err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to invoice plan upgrade",
+			})
+			return
+		}
+
+		err = jobs.ChargePaymentMethod(cfg.paymentService, method, cycle, upgradeChargeKey(org.ID, newPlan, periodStart, declined), map[string]string{
+			"organization_id":  org.ID.String(),
+			"billing_cycle_id": cycle.ID.String(),
+			"invoice_number":   cycle.InvoiceNumber,
+		})
+		if err != nil {
+			cfg.settleFailedUpgradeCharge(r.Context(), cycle, err)
+			respondWithError(w, http.StatusPaymentRequired, ApiError{
+				Code:    "PAYMENT_FAILED",
+				Message: "The upgrade could not be charged to the saved payment method",
+				Details: err.Error(),
+			})
+			return
+		}
+
+		tx, err = cfg.pool.Begin(r.Context())
+		if err != nil {
+			log.Printf("ERROR: charged invoice %s of org %s but could not apply the upgrade: %v", cycle.InvoiceNumber, org.ID, err)
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to update plan",
+			})
+			return
+		}
+		defer tx.Rollback(r.Context())
+		qtx = cfg.db.WithTx(tx)
+
+		_, org, err = cfg.lockPlanForUpgrade(r.Context(), qtx, org.ID, newPlan, periodStart)
+		if err != nil {
+			// The same upgrade retried concurrently was applied first
+			if org.Plan == newPlan {
+				cfg.respondUpgraded(w, org, cycle)
+				return
+			}
+			log.Printf("ERROR: charged invoice %s of org %s but its plan changed before the upgrade: %v", cycle.InvoiceNumber, org.ID, err)
+			respondWithUpgradeError(w, err)
+			return
+		}
+
+		cycle, err = qtx.UpdateBillingCycleStatus(r.Context(), database.UpdateBillingCycleStatusParams{
+			Status: database.BillingStatusPaid,
+			ID:     cycle.ID,
+		})
+		if err != nil {
+			log.Printf("ERROR: charged invoice %s of org %s but could not mark it paid: %v", cycle.InvoiceNumber, org.ID, err)
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to update plan",
+			})
+			return
+		}
+		change.ProrationCycleID = cycle.ID
+	}
+
+	updatedOrg, err := plan.Apply(r.Context(), qtx, org, change)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to update plan",
+		})
+		return
+	}
+
+	// Upgrading replaces any downgrade waiting for the end of the period
+	if _, err := qtx.CancelPendingPlanChange(r.Context(), org.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to update plan",
+		})
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		if invoiced {
+			log.Printf("ERROR: charged %s %s for invoice %s of org %s but could not apply the upgrade, it stays pending until the upgrade is retried: %v",
+				currency.FromNumeric(cycle.TotalAmount), cycle.Currency, cycle.InvoiceNumber, org.ID, err)
+		}
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to update plan",
+		})
+		return
+	}
+
+	cfg.respondUpgraded(w, updatedOrg, cycle)
+}
+
+// errPlanChanged means the organization's plan changed while it was being
+// upgraded
+var errPlanChanged = errors.New("organization plan changed while upgrading")
+
+// lockPlanForUpgrade locks the organization's current plan until db's
+// transaction ends and checks it can still be upgraded to newPlan. It
+// returns errPlanChanged, with the organization as it now is, if not.
+func (cfg *apiConfig) lockPlanForUpgrade(ctx context.Context, db *database.Queries, orgID uuid.UUID, newPlan database.PlanType, periodStart time.Time) (plan.Segment, database.Organization, error) {
+	org, err := db.GetOrganization(ctx, orgID)
+	if err != nil {
+		return plan.Segment{}, org, err
+	}
+	// The plan read after the lock is the one being upgraded from
+	current, err := plan.Current(ctx, db, org, periodStart)
+	if err != nil {
+		return plan.Segment{}, org, err
+	}
+	org, err = db.GetOrganization(ctx, orgID)
+	if err != nil {
+		return plan.Segment{}, org, err
+	}
+	if newPlan == org.Plan || org.TrialStatus.TrialStatus == database.TrialStatusActive || current.BilledByStripe || !plan.IsUpgrade(cfg.pricing, org.Plan, newPlan, periodStart) {
+		return plan.Segment{}, org, errPlanChanged
+	}
+	current.Plan = org.Plan
+	return current, org, nil
+}
+
+func respondWithUpgradeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPlanChanged) {
+		respondWithError(w, http.StatusConflict, ApiError{
+			Code:    "PLAN_CHANGED",
+			Message: "Organization plan changed while upgrading, try again",
+		})
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, ApiError{
+		Code:    "INTERNAL_ERROR",
+		Message: "Failed to update plan",
+	})
+}
+
+// upgradeInvoice returns the pending invoice of an earlier attempt at the
+// same upgrade this period, or invoices charge
+func (cfg *apiConfig) upgradeInvoice(ctx context.Context, db *database.Queries, rates *currency.Rates, taxes *tax.Table, org database.Organization, charge pricing.Quote, periodEnd time.Time, now time.Time) (database.BillingCycle, error) {
+	cycle, err := db.GetPendingUpgradeInvoice(ctx, database.GetPendingUpgradeInvoiceParams{
+		OrganizationID: org.ID,
+		Plan:           string(charge.Plan),
+		PeriodEnd:      pgtype.Timestamp{Time: periodEnd.Add(-time.Second), Valid: true},
+	})
+	if err == nil {
+		return cycle, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return database.BillingCycle{}, err
+	}
+	return jobs.CreateProrationInvoice(ctx, db, rates, taxes, cfg.invoiceSeller(), org, charge, now)
+}
+
+// upgradeChargeKey identifies the charge for upgrading to a plan in the
+// period starting at periodStart. Providers remember a declined charge
+// under its key, so each declined attempt moves the key on.
+func upgradeChargeKey(orgID uuid.UUID, to database.PlanType, periodStart time.Time, declined int64) string {
+	return fmt.Sprintf("upgrade-%s-%s-%d-%d", orgID, to, periodStart.Unix(), declined)
+}
+
+// settleFailedUpgradeCharge voids the invoice of an upgrade whose charge
+// was declined. Any other failure may have charged the customer, so the
+// invoice is left pending for a retry to charge again under the same key.
+func (cfg *apiConfig) settleFailedUpgradeCharge(ctx context.Context, cycle database.BillingCycle, chargeErr error) {
+	if !errors.Is(chargeErr, payment.ErrChargeDeclined) {
+		log.Printf("WARNING: charge for upgrade invoice %s of org %s failed, leaving it pending: %v", cycle.InvoiceNumber, cycle.OrganizationID, chargeErr)
+		return
+	}
+	if _, err := cfg.db.UpdateBillingCycleStatus(ctx, database.UpdateBillingCycleStatusParams{
+		Status: database.BillingStatusVoid,
+		ID:     cycle.ID,
+	}); err != nil {
+		log.Printf("Failed to void declined upgrade invoice %s: %v", cycle.InvoiceNumber, err)
+	}
+}
+
+// respondUpgraded responds with the upgraded organization and the invoice
+// it was charged, if any
+func (cfg *apiConfig) respondUpgraded(w http.ResponseWriter, org database.Organization, cycle database.BillingCycle) {
+	data := map[string]interface{}{
+		"organization": map[string]interface{}{
+			"id":         org.ID,
+			"name":       org.Name,
+			"plan":       org.Plan,
+			"updated_at": org.UpdatedAt,
+		},
+	}
+
+	if cycle.ID != uuid.Nil {
+		data["proration_invoice"] = map[string]interface{}{
+			"id":             cycle.ID,
+			"invoice_number": cycle.InvoiceNumber,
+			"period_start":   cycle.PeriodStart.Time,
+			"period_end":     cycle.PeriodEnd.Time,
+			"amount":         currency.Round(currency.FromNumeric(cycle.TotalAmount), cycle.Currency).StringFixed(currency.Exponent(cycle.Currency)),
+			"currency":       cycle.Currency,
+			"status":         cycle.Status,
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Plan upgraded successfully",
+		Data:    data,
+	})
+}
+
+// scheduleDowngrade schedules the move to a cheaper plan for effectiveAt,
+// the end of the period, replacing any change already scheduled
+func (cfg *apiConfig) scheduleDowngrade(w http.ResponseWriter, r *http.Request, org database.Organization, newPlan database.PlanType, userID uuid.UUID, effectiveAt time.Time) {
+	tx, err := cfg.pool.Begin(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to schedule plan change",
+		})
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	qtx := cfg.db.WithTx(tx)
+	if _, err := qtx.CancelPendingPlanChange(r.Context(), org.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to schedule plan change",
+		})
+		return
+	}
+	change, err := qtx.CreateScheduledPlanChange(r.Context(), database.CreateScheduledPlanChangeParams{
+		OrganizationID: org.ID,
+		FromPlan:       org.Plan,
+		ToPlan:         newPlan,
+		EffectiveAt:    pgtype.Timestamp{Time: effectiveAt, Valid: true},
+		RequestedBy:    pgtype.UUID{Bytes: userID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to schedule plan change",
+		})
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to schedule plan change",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, ApiResponse{
+		Success: true,
+		Message: "Plan downgrade scheduled for the end of the billing period",
+		Data: map[string]interface{}{
+			"plan_change": planChangeData(change),
+		},
+	})
+}
+
+// getPlanChangeHandler returns the organization's scheduled plan change, if
+// it has one
+func (cfg *apiConfig) getPlanChangeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	var pending interface{}
+	change, err := cfg.db.GetPendingPlanChange(r.Context(), user.OrganizationID)
+	if err == nil {
+		pending = planChangeData(change)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve plan change",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"plan_change": pending,
+		},
+	})
+}
+
+// cancelPlanChangeHandler cancels the organization's scheduled plan change,
+// keeping it on its current plan
+func (cfg *apiConfig) cancelPlanChangeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can cancel plan changes",
+		})
+		return
+	}
+
+	cancelled, err := cfg.db.CancelPendingPlanChange(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to cancel plan change",
+		})
+		return
+	}
+	if cancelled == 0 {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "NO_PLAN_CHANGE",
+			Message: "No plan change is scheduled",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Scheduled plan change cancelled",
+	})
+}
+
+func planChangeData(change database.ScheduledPlanChange) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           change.ID,
+		"from_plan":    change.FromPlan,
+		"to_plan":      change.ToPlan,
+		"effective_at": change.EffectiveAt.Time,
+		"status":       change.Status,
+		"created_at":   change.CreatedAt.Time,
+	}
+}
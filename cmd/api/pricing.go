@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/plan"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
+	"github.com/shopspring/decimal"
+)
+
+// usageCharges is what an organization's usage between start and end adds
+// to its invoices. Each period the range touches is priced as its invoice
+// is, with plan.Quote, up to the end of the range and up to its start; the
+// difference in usage charges is the range's. It also returns the quote of
+// the last period, whose currency the charges are in.
+func (cfg *apiConfig) usageCharges(ctx context.Context, org database.Organization, start, end time.Time) (decimal.Decimal, pricing.Quote, error) {
+	charges := decimal.Zero
+	var latest pricing.Quote
+	for periodStart, periodEnd := quota.CurrentPeriod(start); !periodStart.After(end); periodStart, periodEnd = quota.CurrentPeriod(periodEnd.Add(time.Second)) {
+		quote, _, _, err := plan.Quote(ctx, cfg.db, cfg.pricing, org.ID, org.Plan, periodStart, earliest(end, periodEnd))
+		if err != nil {
+			return decimal.Zero, pricing.Quote{}, err
+		}
+		charges = charges.Add(quote.UsageAmount())
+		latest = quote
+
+		if start.After(periodStart) {
+			before, _, _, err := plan.Quote(ctx, cfg.db, cfg.pricing, org.ID, org.Plan, periodStart, start.Add(-time.Microsecond))
+			if err != nil {
+				return decimal.Zero, pricing.Quote{}, err
+			}
+			charges = charges.Sub(before.UsageAmount())
+		}
+	}
+	return charges, latest, nil
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// projectUsage scales usage so it covers projected requests instead of
+// requests, keeping the mix of message types. Without any usage to go on
+// the projection is untyped.
+func projectUsage(usage pricing.Usage, requests, projected int64) pricing.Usage {
+	if requests <= 0 {
+		return pricing.Usage{"": projected}
+	}
+
+	scale := float64(projected) / float64(requests)
+	scaled := make(pricing.Usage, len(usage))
+	for messageType, units := range usage {
+		scaled[messageType] = int64(math.Round(float64(units) * scale))
+	}
+	return scaled
+}
+
+// costShare splits an amount across a breakdown in proportion to requests,
+// so the endpoint, key and day costs add up to the quoted usage charges
+func costShare(amount decimal.Decimal, totalRequests, requests int64) float64 {
+	if totalRequests <= 0 {
+		return 0
+	}
+	return amount.Mul(decimal.NewFromInt(requests)).Div(decimal.NewFromInt(totalRequests)).Round(2).InexactFloat64()
+}
+
+// quoteData is the API representation of a quote. Invoices keep the quote
+// they were generated from, so it is the same shape as their breakdown.
+func quoteData(quote pricing.Quote) map[string]interface{} {
+	lines := make([]map[string]interface{}, 0, len(quote.Lines))
+	for _, line := range quote.Lines {
+		lines = append(lines, map[string]interface{}{
+			"message_type":   line.MessageType,
+			"units":          line.Units,
+			"included_units": line.IncludedUnits,
+			"billable_units": line.BillableUnits,
+			"unit_rate":      line.UnitRate.InexactFloat64(),
+			"amount":         line.Amount.InexactFloat64(),
+		})
+	}
+
+	data := map[string]interface{}{
+		"price_book_version": quote.Version,
+		"currency":           quote.Currency,
+		"pricing_model":      quote.Model,
+		"base_fee":           quote.BaseFee.InexactFloat64(),
+		"usage":              lines,
+		"total":              quote.Total.InexactFloat64(),
+	}
+
+	if len(quote.Tiers) > 0 {
+		tiers := make([]map[string]interface{}, 0, len(quote.Tiers))
+		for _, tier := range quote.Tiers {
+			tiers = append(tiers, map[string]interface{}{
+				"tier":      tier.Tier,
+				"from":      tier.From,
+				"up_to":     tier.UpTo,
+				"units":     tier.Units,
+				"unit_rate": tier.UnitRate.InexactFloat64(),
+				"amount":    tier.Amount.InexactFloat64(),
+			})
+		}
+		data["tiers"] = tiers
+	}
+
+	// A period spent on more than one plan is quoted for each
+	if len(quote.Segments) > 0 {
+		segments := make([]map[string]interface{}, 0, len(quote.Segments))
+		for _, segment := range quote.Segments {
+			segmentData := quoteData(segment)
+			segmentData["plan"] = segment.Plan
+			segmentData["from"] = segment.From
+			segmentData["until"] = segment.Until
+			if segment.Trial {
+				segmentData["trial"] = true
+			}
+			if segment.BilledByStripe {
+				segmentData["billed_by_stripe"] = true
+			}
+			segments = append(segments, segmentData)
+		}
+		data["segments"] = segments
+	}
+
+	return data
+}
+
+// invoiceBreakdown returns the stored quote of a billing cycle in the shape
+// quoteData uses, or nil for cycles generated before quotes were kept
+func invoiceBreakdown(stored []byte) map[string]interface{} {
+	if len(stored) == 0 {
+		return nil
+	}
+	var quote pricing.Quote
+	if err := json.Unmarshal(stored, &quote); err != nil {
+		return nil
+	}
+	return quoteData(quote)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/shopspring/decimal"
+)
+
+func TestProjectUsage(t *testing.T) {
+	got := projectUsage(pricing.Usage{"sms": 300, "email": 100}, 200, 500)
+	if got["sms"] != 750 || got["email"] != 250 {
+		t.Errorf("projectUsage() = %v, want sms 750 and email 250", got)
+	}
+
+	got = projectUsage(pricing.Usage{}, 0, 40)
+	if len(got) != 1 || got[""] != 40 {
+		t.Errorf("projectUsage() without history = %v, want 40 untyped units", got)
+	}
+}
+
+func TestCostShare(t *testing.T) {
+	amount := decimal.RequireFromString("10")
+
+	var sum float64
+	for _, requests := range []int64{500, 300, 200} {
+		sum += costShare(amount, 1000, requests)
+	}
+	if sum != 10 {
+		t.Errorf("shares add up to %v, want 10", sum)
+	}
+
+	if got := costShare(amount, 0, 0); got != 0 {
+		t.Errorf("costShare() with no requests = %v, want 0", got)
+	}
+}
+
+func TestInvoiceBreakdownRoundTrip(t *testing.T) {
+	book := pricing.DefaultBook()
+	book.Plans[database.PlanTypePro] = pricing.PlanPrice{
+		BaseFee: decimal.NewFromInt(99),
+		Model:   pricing.ModelGraduated,
+		Tiers: []pricing.Tier{
+			{UpTo: 100, UnitRate: decimal.RequireFromString("0.01")},
+			{UnitRate: decimal.RequireFromString("0.005")},
+		},
+	}
+	catalog, err := pricing.NewCatalog(book)
+	if err != nil {
+		t.Fatalf("NewCatalog() error = %v", err)
+	}
+
+	quote := catalog.Quote(database.PlanTypePro, time.Now(), pricing.Usage{"sms": 300})
+	stored, err := json.Marshal(quote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	breakdown := invoiceBreakdown(stored)
+	if breakdown["total"] != 101.0 || breakdown["pricing_model"] != pricing.ModelGraduated {
+		t.Errorf("breakdown = %v", breakdown)
+	}
+	tiers, _ := breakdown["tiers"].([]map[string]interface{})
+	if len(tiers) != 2 || tiers[1]["units"] != int64(200) || tiers[1]["amount"] != 1.0 {
+		t.Errorf("tiers = %v", tiers)
+	}
+
+	if invoiceBreakdown(nil) != nil {
+		t.Error("invoiceBreakdown(nil) should be nil for older invoices")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/usage"
+)
+
+// streamDelay holds each second back so counts published by every API
+// instance have arrived before it is sent
+const streamDelay = 2 * time.Second
+
+// liveWindow adds up the counts received from every API instance and hands
+// them out one second at a time, with zeros for quiet seconds
+type liveWindow struct {
+	counts map[time.Time]*usage.LiveCount
+	// next is the first second not yet sent
+	next time.Time
+}
+
+func newLiveWindow(start time.Time) *liveWindow {
+	return &liveWindow{
+		counts: make(map[time.Time]*usage.LiveCount),
+		next:   start.UTC().Truncate(time.Second),
+	}
+}
+
+// add records counts. Counts for seconds already sent arrive too late to be
+// shown on their own and are added to the next second instead.
+func (lw *liveWindow) add(counts []usage.LiveCount) {
+	for _, count := range counts {
+		second := count.Second.UTC().Truncate(time.Second)
+		if second.Before(lw.next) {
+			second = lw.next
+		}
+		existing, ok := lw.counts[second]
+		if !ok {
+			existing = &usage.LiveCount{Second: second}
+			lw.counts[second] = existing
+		}
+		existing.Requests += count.Requests
+		existing.Errors += count.Errors
+	}
+}
+
+// due returns every unsent second up to and including through, in order
+func (lw *liveWindow) due(through time.Time) []usage.LiveCount {
+	through = through.UTC().Truncate(time.Second)
+
+	var due []usage.LiveCount
+	for ; !lw.next.After(through); lw.next = lw.next.Add(time.Second) {
+		count := usage.LiveCount{Second: lw.next}
+		if existing, ok := lw.counts[lw.next]; ok {
+			count = *existing
+			delete(lw.counts, lw.next)
+		}
+		due = append(due, count)
+	}
+	return due
+}
+
+// writeSSE writes one server-sent event
+func writeSSE(w http.ResponseWriter, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+func (cfg *apiConfig) getDashboardStreamHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	pubsub := cfg.redisClient.Subscribe(r.Context(), usage.LiveChannel(user.OrganizationID))
+	defer pubsub.Close()
+
+	// Wait for the subscription to be confirmed so nothing published after
+	// the response starts is missed
+	if _, err := pubsub.Receive(r.Context()); err != nil {
+		respondWithError(w, http.StatusServiceUnavailable, ApiError{
+			Code:    "STREAM_UNAVAILABLE",
+			Message: "Live usage is temporarily unavailable",
+		})
+		return
+	}
+
+	// The stream outlives the server's write timeout
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline for dashboard stream: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	window := newLiveWindow(time.Now().Add(-streamDelay))
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-cfg.shutdown:
+			return
+
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var update usage.LiveUpdate
+			if err := json.Unmarshal([]byte(msg.Payload), &update); err != nil {
+				continue
+			}
+			window.add(update.Counts)
+			for _, status := range update.Messages {
+				if err := writeSSE(w, "message", status); err != nil {
+					return
+				}
+			}
+			if len(update.Messages) > 0 {
+				if err := controller.Flush(); err != nil {
+					return
+				}
+			}
+
+		case now := <-ticker.C:
+			for _, count := range window.due(now.Add(-streamDelay)) {
+				if err := writeSSE(w, "usage", count); err != nil {
+					return
+				}
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/usage"
+)
+
+func TestLiveWindow(t *testing.T) {
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	window := newLiveWindow(start)
+
+	// Two instances report the same second; the next one is quiet
+	window.add([]usage.LiveCount{{Second: start, Requests: 3, Errors: 1}})
+	window.add([]usage.LiveCount{{Second: start, Requests: 2}, {Second: start.Add(2 * time.Second), Requests: 4}})
+
+	due := window.due(start.Add(2500 * time.Millisecond))
+	want := []usage.LiveCount{
+		{Second: start, Requests: 5, Errors: 1},
+		{Second: start.Add(time.Second)},
+		{Second: start.Add(2 * time.Second), Requests: 4},
+	}
+	if len(due) != len(want) {
+		t.Fatalf("due = %+v, want %+v", due, want)
+	}
+	for i := range want {
+		if !due[i].Second.Equal(want[i].Second) || due[i].Requests != want[i].Requests || due[i].Errors != want[i].Errors {
+			t.Errorf("due[%d] = %+v, want %+v", i, due[i], want[i])
+		}
+	}
+
+	if again := window.due(start.Add(2 * time.Second)); len(again) != 0 {
+		t.Errorf("seconds sent twice: %+v", again)
+	}
+
+	// A late count for a second already sent goes into the next one
+	window.add([]usage.LiveCount{{Second: start, Requests: 7}})
+	late := window.due(start.Add(3 * time.Second))
+	if len(late) != 1 || late[0].Requests != 7 || !late[0].Second.Equal(start.Add(3*time.Second)) {
+		t.Errorf("late = %+v, want 7 requests at %v", late, start.Add(3*time.Second))
+	}
+}
+
+func TestWriteSSE(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := writeSSE(rec, "usage", map[string]int{"requests": 3}); err != nil {
+		t.Fatalf("writeSSE() error = %v", err)
+	}
+
+	want := "event: usage\ndata: {\"requests\":3}\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
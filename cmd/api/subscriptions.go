@@ -0,0 +1,616 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/plan"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stripe/stripe-go/v76"
+)
+
+// createSubscriptionHandler subscribes the organization to a paid plan on
+// Stripe. The saved card is charged for the first invoice if it is a Stripe
+// card; otherwise the invoice is paid on its hosted page. Stripe invoices the
+// plan from when the first invoice is paid, and usage is reported to it
+// hourly from then on.
+func (cfg *apiConfig) createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Plan string `json:"plan"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	subPlan, priceID, ok := cfg.subscriptionPlan(w, params.Plan)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can subscribe to a plan",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization",
+		})
+		return
+	}
+
+	if org.TrialStatus.TrialStatus == database.TrialStatusActive {
+		respondWithError(w, http.StatusConflict, ApiError{
+			Code:    "TRIAL_ACTIVE",
+			Message: "Plan cannot be changed during a trial",
+		})
+		return
+	}
+
+	existing, err := cfg.db.GetStripeSubscription(r.Context(), org.ID)
+	if err == nil && (payment.Billing(stripe.SubscriptionStatus(existing.Status)) || existing.Status == string(stripe.SubscriptionStatusIncomplete)) {
+		respondWithError(w, http.StatusConflict, ApiError{
+			Code:    "SUBSCRIPTION_EXISTS",
+			Message: "Organization already has a subscription, change its plan instead",
+		})
+		return
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve subscription",
+		})
+		return
+	}
+
+	org, err = cfg.stripeCustomer(r.Context(), org)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "PAYMENT_ERROR",
+			Message: "Failed to create payment customer",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	subParams := payment.SubscriptionParams{
+		OrganizationID: org.ID.String(),
+		CustomerID:     org.StripeCustomerID.String,
+		PriceID:        priceID,
+		MeteredPriceID: cfg.config.StripeMeteredPrice,
+	}
+	method, err := cfg.db.GetPaymentMethod(r.Context(), org.ID)
+	if err == nil && method.Provider == "stripe" && method.CustomerID.String == org.StripeCustomerID.String {
+		subParams.PaymentMethodID = method.Reference
+	} else if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve payment method",
+		})
+		return
+	}
+
+	sub, err := cfg.paymentService.Stripe.CreateSubscription(subParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "PAYMENT_ERROR",
+			Message: "Failed to create subscription",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	stored, err := cfg.syncStripeSubscription(r.Context(), sub)
+	if err != nil {
+		log.Printf("Failed to record Stripe subscription %s for organization %s: %v", sub.ID, org.ID, err)
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to record subscription",
+		})
+		return
+	}
+
+	data := subscriptionData(stored)
+	message := fmt.Sprintf("Subscribed to the %s plan", subPlan)
+	if !payment.Billing(sub.Status) && sub.LatestInvoice != nil {
+		data["payment_url"] = sub.LatestInvoice.HostedInvoiceURL
+		message = "Subscription created, pay the first invoice to start it"
+	}
+
+	respondWithJSON(w, http.StatusCreated, ApiResponse{
+		Success: true,
+		Message: message,
+		Data: map[string]interface{}{
+			"subscription": data,
+		},
+	})
+}
+
+// getSubscriptionHandler returns the organization's Stripe subscription, or
+// null if it has never had one
+func (cfg *apiConfig) getSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	var subscription interface{}
+	sub, err := cfg.db.GetStripeSubscription(r.Context(), user.OrganizationID)
+	if err == nil {
+		subscription = subscriptionData(sub)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve subscription",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"subscription": subscription,
+		},
+	})
+}
+
+// changeSubscriptionHandler moves the organization's subscription to another
+// plan. Stripe prorates the base fee onto the next invoice, so the plan
+// changes at once either way.
+func (cfg *apiConfig) changeSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Plan string `json:"plan"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	subPlan, priceID, ok := cfg.subscriptionPlan(w, params.Plan)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can change the subscription",
+		})
+		return
+	}
+
+	existing, ok := cfg.billingSubscription(w, r.Context(), user.OrganizationID)
+	if !ok {
+		return
+	}
+
+	if existing.Plan == subPlan {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "SAME_PLAN",
+			Message: "Subscription is already on this plan",
+		})
+		return
+	}
+
+	sub, err := cfg.paymentService.Stripe.ChangeSubscriptionPrice(existing.SubscriptionID, existing.BaseItemID.String, priceID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "PAYMENT_ERROR",
+			Message: "Failed to change subscription",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	stored, err := cfg.syncStripeSubscription(r.Context(), sub)
+	if err != nil {
+		log.Printf("Failed to record Stripe subscription %s change: %v", sub.ID, err)
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to record subscription",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: fmt.Sprintf("Subscription moved to the %s plan", subPlan),
+		Data: map[string]interface{}{
+			"subscription": subscriptionData(stored),
+		},
+	})
+}
+
+// cancelSubscriptionHandler cancels the organization's subscription at the
+// end of the period Stripe has invoiced. The organization returns to free
+// when Stripe ends it.
+func (cfg *apiConfig) cancelSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can cancel the subscription",
+		})
+		return
+	}
+
+	existing, ok := cfg.billingSubscription(w, r.Context(), user.OrganizationID)
+	if !ok {
+		return
+	}
+
+	sub, err := cfg.paymentService.Stripe.CancelSubscription(existing.SubscriptionID, true)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "PAYMENT_ERROR",
+			Message: "Failed to cancel subscription",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	stored, err := cfg.syncStripeSubscription(r.Context(), sub)
+	if err != nil {
+		log.Printf("Failed to record Stripe subscription %s cancellation: %v", sub.ID, err)
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to record subscription",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Subscription will end at the end of the current period",
+		Data: map[string]interface{}{
+			"subscription": subscriptionData(stored),
+		},
+	})
+}
+
+// subscriptionPlan resolves a plan name to the plan and its Stripe Price,
+// responding with an error if it cannot be subscribed to
+func (cfg *apiConfig) subscriptionPlan(w http.ResponseWriter, name string) (database.PlanType, string, bool) {
+	var subPlan database.PlanType
+	switch name {
+	case "starter":
+		subPlan = database.PlanTypeStarter
+	case "pro":
+		subPlan = database.PlanTypePro
+	default:
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_PLAN",
+			Message: "Subscription plan must be 'starter' or 'pro'",
+		})
+		return "", "", false
+	}
+
+	priceID := cfg.stripePrice(subPlan)
+	if priceID == "" {
+		respondWithError(w, http.StatusServiceUnavailable, ApiError{
+			Code:    "SUBSCRIPTIONS_UNAVAILABLE",
+			Message: "Subscriptions to this plan are not configured",
+		})
+		return "", "", false
+	}
+	return subPlan, priceID, true
+}
+
+// billingSubscription returns the subscription Stripe is billing the
+// organization on, responding with an error if there is none
+func (cfg *apiConfig) billingSubscription(w http.ResponseWriter, ctx context.Context, orgID uuid.UUID) (database.StripeSubscription, bool) {
+	sub, err := cfg.db.GetStripeSubscription(ctx, orgID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !payment.Billing(stripe.SubscriptionStatus(sub.Status))) {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "NO_SUBSCRIPTION",
+			Message: "Organization has no active subscription",
+		})
+		return database.StripeSubscription{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve subscription",
+		})
+		return database.StripeSubscription{}, false
+	}
+	return sub, true
+}
+
+// stripePrice returns the Stripe Price of a plan's base fee, empty if the
+// plan cannot be subscribed to
+func (cfg *apiConfig) stripePrice(p database.PlanType) string {
+	switch p {
+	case database.PlanTypeStarter:
+		return cfg.config.StripePriceStarter
+	case database.PlanTypePro:
+		return cfg.config.StripePricePro
+	}
+	return ""
+}
+
+func (cfg *apiConfig) planForStripePrice(priceID string) (database.PlanType, bool) {
+	for _, p := range []database.PlanType{database.PlanTypeStarter, database.PlanTypePro} {
+		if priceID != "" && cfg.stripePrice(p) == priceID {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// stripeCustomer creates the organization's Stripe customer unless it has
+// one already
+func (cfg *apiConfig) stripeCustomer(ctx context.Context, org database.Organization) (database.Organization, error) {
+	if org.StripeCustomerID.Valid {
+		return org, nil
+	}
+	customerID, err := cfg.paymentService.Stripe.CreateCustomer(org.ID.String(), org.Name, org.Email)
+	if err != nil {
+		return database.Organization{}, err
+	}
+	org, err = cfg.db.SetStripeCustomerID(ctx, database.SetStripeCustomerIDParams{
+		ID:               org.ID,
+		StripeCustomerID: pgtype.Text{String: customerID, Valid: true},
+	})
+	if err != nil {
+		return database.Organization{}, fmt.Errorf("failed to save payment customer: %w", err)
+	}
+	return org, nil
+}
+
+// syncStripeSubscription records a subscription as Stripe reports it and
+// moves the organization's plan along with it: onto the subscribed plan,
+// billed by Stripe, while Stripe is billing it, and back to free once it
+// ends.
+func (cfg *apiConfig) syncStripeSubscription(ctx context.Context, sub *stripe.Subscription) (database.StripeSubscription, error) {
+	var orgID uuid.UUID
+	stored, err := cfg.db.GetStripeSubscriptionByID(ctx, sub.ID)
+	switch {
+	case err == nil:
+		orgID = stored.OrganizationID
+	case errors.Is(err, pgx.ErrNoRows):
+		orgID, err = uuid.Parse(sub.Metadata["organization_id"])
+		if err != nil {
+			return database.StripeSubscription{}, fmt.Errorf("subscription %s has no organization", sub.ID)
+		}
+	default:
+		return database.StripeSubscription{}, fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	items := payment.Items(sub)
+	subPlan, ok := cfg.planForStripePrice(items.PriceID)
+	if !ok {
+		return database.StripeSubscription{}, fmt.Errorf("subscription %s is on unknown price %q", sub.ID, items.PriceID)
+	}
+
+	tx, err := cfg.pool.Begin(ctx)
+	if err != nil {
+		return database.StripeSubscription{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := cfg.db.WithTx(tx)
+	now := time.Now().UTC()
+	org, err := qtx.GetOrganization(ctx, orgID)
+	if err != nil {
+		return database.StripeSubscription{}, fmt.Errorf("failed to get organization: %w", err)
+	}
+	// The current plan stays locked until the change commits, so events
+	// for the same organization are applied one after another
+	current, err := plan.Current(ctx, qtx, org, now)
+	if err != nil {
+		return database.StripeSubscription{}, err
+	}
+
+	billing := payment.Billing(sub.Status)
+	existing, err := qtx.GetStripeSubscription(ctx, orgID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return database.StripeSubscription{}, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	// A subscription the organization has replaced no longer affects it
+	if err == nil && existing.SubscriptionID != sub.ID && payment.Billing(stripe.SubscriptionStatus(existing.Status)) && !billing {
+		return existing, nil
+	}
+
+	params := database.UpsertStripeSubscriptionParams{
+		OrganizationID:     orgID,
+		SubscriptionID:     sub.ID,
+		Plan:               subPlan,
+		Status:             string(sub.Status),
+		BaseItemID:         pgtype.Text{String: items.BaseItemID, Valid: items.BaseItemID != ""},
+		MeteredItemID:      pgtype.Text{String: items.MeteredItemID, Valid: items.MeteredItemID != ""},
+		CurrentPeriodStart: unixTimestamp(sub.CurrentPeriodStart),
+		CurrentPeriodEnd:   unixTimestamp(sub.CurrentPeriodEnd),
+		CancelAtPeriodEnd:  sub.CancelAtPeriodEnd,
+		EndedAt:            unixTimestamp(sub.EndedAt),
+	}
+	if sub.Customer != nil {
+		params.CustomerID = sub.Customer.ID
+	}
+	if billing {
+		params.BillingStartedAt = pgtype.Timestamp{Time: now, Valid: true}
+	}
+	stored, err = qtx.UpsertStripeSubscription(ctx, params)
+	if err != nil {
+		return database.StripeSubscription{}, fmt.Errorf("failed to save subscription: %w", err)
+	}
+	if invoice := sub.LatestInvoice; invoice != nil && invoice.Status != "" {
+		stored.LatestInvoiceID = pgtype.Text{String: invoice.ID, Valid: true}
+		stored.LatestInvoiceStatus = pgtype.Text{String: string(invoice.Status), Valid: true}
+		if _, err := qtx.UpdateStripeSubscriptionInvoice(ctx, database.UpdateStripeSubscriptionInvoiceParams{
+			SubscriptionID:      sub.ID,
+			LatestInvoiceID:     stored.LatestInvoiceID,
+			LatestInvoiceStatus: stored.LatestInvoiceStatus,
+		}); err != nil {
+			return database.StripeSubscription{}, fmt.Errorf("failed to save invoice: %w", err)
+		}
+	}
+
+	switch {
+	case billing && (!current.BilledByStripe || org.Plan != subPlan):
+		// Paying for a plan ends a trial of it
+		if org.TrialStatus.TrialStatus == database.TrialStatusActive {
+			if org, err = qtx.EndTrial(ctx, database.EndTrialParams{
+				ID:          org.ID,
+				TrialStatus: database.NullTrialStatus{TrialStatus: database.TrialStatusConverted, Valid: true},
+			}); err != nil {
+				return database.StripeSubscription{}, fmt.Errorf("failed to end trial: %w", err)
+			}
+		}
+		if _, err := qtx.CancelPendingPlanChange(ctx, org.ID); err != nil {
+			return database.StripeSubscription{}, fmt.Errorf("failed to cancel pending plan change: %w", err)
+		}
+		if _, err := plan.Apply(ctx, qtx, org, plan.Change{Plan: subPlan, At: now, BilledByStripe: true}); err != nil {
+			return database.StripeSubscription{}, err
+		}
+	case !billing && current.BilledByStripe:
+		if _, err := plan.Apply(ctx, qtx, org, plan.Change{Plan: database.PlanTypeFree, At: now}); err != nil {
+			return database.StripeSubscription{}, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return database.StripeSubscription{}, err
+	}
+	return stored, nil
+}
+
+// recordStripeInvoice notes the latest invoice of a subscription. Whether the
+// subscription is billing follows from its own events.
+func (cfg *apiConfig) recordStripeInvoice(ctx context.Context, invoice stripe.Invoice) error {
+	if invoice.Subscription == nil {
+		return nil
+	}
+	_, err := cfg.db.UpdateStripeSubscriptionInvoice(ctx, database.UpdateStripeSubscriptionInvoiceParams{
+		SubscriptionID:      invoice.Subscription.ID,
+		LatestInvoiceID:     pgtype.Text{String: invoice.ID, Valid: true},
+		LatestInvoiceStatus: pgtype.Text{String: string(invoice.Status), Valid: invoice.Status != ""},
+	})
+	return err
+}
+
+func subscriptionData(sub database.StripeSubscription) map[string]interface{} {
+	data := map[string]interface{}{
+		"subscription_id":      sub.SubscriptionID,
+		"plan":                 sub.Plan,
+		"status":               sub.Status,
+		"billing":              payment.Billing(stripe.SubscriptionStatus(sub.Status)),
+		"cancel_at_period_end": sub.CancelAtPeriodEnd,
+		"metered":              sub.MeteredItemID.Valid,
+	}
+	if sub.CurrentPeriodStart.Valid {
+		data["current_period_start"] = sub.CurrentPeriodStart.Time
+		data["current_period_end"] = sub.CurrentPeriodEnd.Time
+	}
+	if sub.UsageReportedThrough.Valid {
+		data["usage_reported_through"] = sub.UsageReportedThrough.Time
+	}
+	if sub.EndedAt.Valid {
+		data["ended_at"] = sub.EndedAt.Time
+	}
+	if sub.LatestInvoiceID.Valid {
+		data["latest_invoice"] = map[string]interface{}{
+			"id":     sub.LatestInvoiceID.String,
+			"status": sub.LatestInvoiceStatus.String,
+		}
+	}
+	return data
+}
+
+func unixTimestamp(seconds int64) pgtype.Timestamp {
+	if seconds == 0 {
+		return pgtype.Timestamp{}
+	}
+	return pgtype.Timestamp{Time: time.Unix(seconds, 0).UTC(), Valid: true}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/config"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment/stripestub"
+)
+
+func newStripeStubConfig(t *testing.T) (*apiConfig, *stripestub.Server) {
+	t.Helper()
+	stub := stripestub.New("whsec_test")
+	t.Cleanup(stub.Close)
+	stub.AddPrice("price_starter", false)
+	stub.AddPrice("price_pro", false)
+	stub.AddPrice("price_units", true)
+
+	return &apiConfig{
+		paymentService: payment.NewPaymentService("sk_test_stub", stub.WebhookSecret, stub.URL, "", ""),
+		config: &config.Config{
+			StripePriceStarter: "price_starter",
+			StripePricePro:     "price_pro",
+			StripeMeteredPrice: "price_units",
+		},
+	}, stub
+}
+
+func TestStripeWebhookEvents(t *testing.T) {
+	cfg, stub := newStripeStubConfig(t)
+
+	post := func(payload []byte, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/stripe", bytes.NewReader(payload))
+		req.Header.Set("Stripe-Signature", signature)
+		rec := httptest.NewRecorder()
+		cfg.stripeWebhookHandler(rec, req)
+		return rec.Code
+	}
+
+	payload, signature := stub.Event("customer.created", map[string]interface{}{"id": "cus_1", "object": "customer"})
+	if code := post(payload, signature); code != http.StatusOK {
+		t.Errorf("unhandled event = %d, want 200", code)
+	}
+
+	_, other := stub.Event("customer.created", map[string]interface{}{"id": "cus_2", "object": "customer"})
+	if code := post(payload, other); code != http.StatusUnauthorized {
+		t.Errorf("event with another event's signature = %d, want 401", code)
+	}
+
+	// A subscription Stripe cannot return is retried rather than acknowledged
+	payload, signature = stub.Event("customer.subscription.updated", map[string]interface{}{"id": "sub_missing", "object": "subscription"})
+	if code := post(payload, signature); code != http.StatusInternalServerError {
+		t.Errorf("event for an unknown subscription = %d, want 500", code)
+	}
+}
+
+func TestPlanForStripePrice(t *testing.T) {
+	cfg, _ := newStripeStubConfig(t)
+
+	tests := map[string]database.PlanType{
+		"price_starter": database.PlanTypeStarter,
+		"price_pro":     database.PlanTypePro,
+	}
+	for priceID, want := range tests {
+		if got, ok := cfg.planForStripePrice(priceID); !ok || got != want {
+			t.Errorf("planForStripePrice(%q) = %s, %v, want %s", priceID, got, ok, want)
+		}
+	}
+	for _, priceID := range []string{"price_units", ""} {
+		if got, ok := cfg.planForStripePrice(priceID); ok {
+			t.Errorf("planForStripePrice(%q) = %s, want no plan", priceID, got)
+		}
+	}
+
+	cfg.config.StripePricePro = ""
+	if cfg.stripePrice(database.PlanTypePro) != "" {
+		t.Error("unconfigured plan has a price")
+	}
+}
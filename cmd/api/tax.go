@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/tax"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+func (cfg *apiConfig) getTaxProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	profile, err := cfg.db.GetOrganizationTaxProfile(r.Context(), user.OrganizationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "TAX_PROFILE_NOT_FOUND",
+			Message: "No tax profile is configured",
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve tax profile",
+		})
+		return
+	}
+
+	data, err := cfg.taxProfileData(r, profile)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve tax rates",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+func (cfg *apiConfig) updateTaxProfileHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Country string `json:"country"`
+		VATID   string `json:"vat_id"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner && user.Role != database.UserRoleAdmin {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only owner and admin roles can change the tax profile",
+		})
+		return
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(params.Country))
+	if err := tax.ValidateCountry(country); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+			Details: map[string]interface{}{
+				"field": "country",
+			},
+		})
+		return
+	}
+
+	vatID := pgtype.Text{}
+	if strings.TrimSpace(params.VATID) != "" {
+		normalized := tax.NormalizeVATID(country, params.VATID)
+		if err := tax.ValidateVATID(country, normalized); err != nil {
+			respondWithError(w, http.StatusBadRequest, ApiError{
+				Code:    "INVALID_VAT_ID",
+				Message: err.Error(),
+				Details: map[string]interface{}{
+					"field": "vat_id",
+				},
+			})
+			return
+		}
+		vatID = pgtype.Text{String: normalized, Valid: true}
+	}
+
+	profile, err := cfg.db.UpsertOrganizationTaxProfile(r.Context(), database.UpsertOrganizationTaxProfileParams{
+		OrganizationID: user.OrganizationID,
+		Country:        country,
+		VatID:          vatID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to save tax profile",
+		})
+		return
+	}
+
+	data, err := cfg.taxProfileData(r, profile)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve tax rates",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Tax profile saved successfully",
+		Data:    data,
+	})
+}
+
+// taxProfileData describes a profile with how the next invoice will be taxed
+func (cfg *apiConfig) taxProfileData(r *http.Request, profile database.OrganizationTaxProfile) (map[string]interface{}, error) {
+	taxes, err := tax.Load(r.Context(), cfg.db)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"country":          profile.Country,
+		"vat_id":           nil,
+		"tax_exempt":       profile.TaxExempt,
+		"exemption_reason": nil,
+		"updated_at":       profile.UpdatedAt.Time,
+		"treatment":        nil,
+	}
+	if profile.VatID.Valid {
+		data["vat_id"] = profile.VatID.String
+	}
+	if profile.ExemptionReason.Valid {
+		data["exemption_reason"] = profile.ExemptionReason.String
+	}
+
+	if charge, ok := tax.Treatment(tax.ProfileFromRow(profile), taxes, cfg.config.SellerCountry, time.Now()); ok {
+		treatment := map[string]interface{}{
+			"description":    charge.Description(),
+			"reverse_charge": charge.ReverseCharge,
+			"exempt":         charge.Exempt,
+		}
+		if !charge.Exempt {
+			treatment["name"] = charge.Name
+			treatment["rate"] = charge.Percent.String()
+		}
+		data["treatment"] = treatment
+	}
+	return data, nil
+}
+
+// updateTaxExemptionHandler grants or withdraws an organization's tax
+// exemption. The organization must have set up its tax profile first.
+func (cfg *apiConfig) updateTaxExemptionHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		TaxExempt       bool   `json:"tax_exempt"`
+		ExemptionReason string `json:"exemption_reason"`
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_ID",
+			Message: "Invalid organization ID",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	reason := pgtype.Text{}
+	if params.TaxExempt {
+		if strings.TrimSpace(params.ExemptionReason) == "" {
+			respondWithError(w, http.StatusBadRequest, ApiError{
+				Code:    "VALIDATION_ERROR",
+				Message: "An exemption reason is required, such as the exemption certificate number",
+				Details: map[string]interface{}{
+					"field": "exemption_reason",
+				},
+			})
+			return
+		}
+		reason = pgtype.Text{String: strings.TrimSpace(params.ExemptionReason), Valid: true}
+	}
+
+	profile, err := cfg.db.SetOrganizationTaxExemption(r.Context(), database.SetOrganizationTaxExemptionParams{
+		OrganizationID:  orgID,
+		TaxExempt:       params.TaxExempt,
+		ExemptionReason: reason,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, ApiError{
+			Code:    "TAX_PROFILE_NOT_FOUND",
+			Message: "The organization has no tax profile",
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to update tax exemption",
+		})
+		return
+	}
+
+	data, err := cfg.taxProfileData(r, profile)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve tax rates",
+		})
+		return
+	}
+	data["organization_id"] = profile.OrganizationID
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Tax exemption updated successfully",
+		Data:    data,
+	})
+}
+
+func (cfg *apiConfig) listTaxRatesHandler(w http.ResponseWriter, r *http.Request) {
+	rows, err := cfg.db.ListTaxRates(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve tax rates",
+		})
+		return
+	}
+
+	rates := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		rates = append(rates, taxRateData(row))
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"rates": rates,
+		},
+	})
+}
+
+// upsertTaxRateHandler publishes a jurisdiction's rate from a date on. A
+// rate already published for that date is replaced.
+func (cfg *apiConfig) upsertTaxRateHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Country       string          `json:"country"`
+		EffectiveFrom string          `json:"effective_from"`
+		Name          string          `json:"name"`
+		Rate          decimal.Decimal `json:"rate"`
+		ReverseCharge bool            `json:"reverse_charge"`
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	effectiveFrom, err := time.Parse("2006-01-02", params.EffectiveFrom)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: "effective_from must be a date in YYYY-MM-DD format",
+			Details: map[string]interface{}{
+				"field": "effective_from",
+			},
+		})
+		return
+	}
+
+	rate := tax.Rate{
+		Country:       strings.ToUpper(strings.TrimSpace(params.Country)),
+		EffectiveFrom: effectiveFrom,
+		Name:          strings.TrimSpace(params.Name),
+		Percent:       params.Rate,
+		ReverseCharge: params.ReverseCharge,
+	}
+	if err := rate.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	row, err := cfg.db.UpsertTaxRate(r.Context(), database.UpsertTaxRateParams{
+		Country:       rate.Country,
+		EffectiveFrom: rate.Date(),
+		Name:          rate.Name,
+		Rate:          rate.Numeric(),
+		ReverseCharge: rate.ReverseCharge,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to save tax rate",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Message: "Tax rate saved successfully",
+		Data:    taxRateData(row),
+	})
+}
+
+func taxRateData(row database.TaxRate) map[string]interface{} {
+	rate := tax.RateFromRow(row)
+	return map[string]interface{}{
+		"country":        rate.Country,
+		"effective_from": rate.EffectiveFrom.Format("2006-01-02"),
+		"name":           rate.Name,
+		"rate":           rate.Percent.String(),
+		"reverse_charge": rate.ReverseCharge,
+	}
+}
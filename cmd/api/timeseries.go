@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// timeseriesDefaults is the window used for each granularity when no start is given
+var timeseriesDefaults = map[string]time.Duration{
+	"minute": time.Hour,
+	"hour":   24 * time.Hour,
+	"day":    30 * 24 * time.Hour,
+	"week":   12 * 7 * 24 * time.Hour,
+	"month":  365 * 24 * time.Hour,
+}
+
+// timeseriesGroups are the accepted values of the group_by parameter
+var timeseriesGroups = map[string]bool{
+	"endpoint":     true,
+	"api_key":      true,
+	"status_class": true,
+}
+
+const (
+	// maxTimeseriesRange matches the longest usage retention
+	maxTimeseriesRange = 366 * 24 * time.Hour
+	// maxTimeseriesBuckets keeps a single series small enough to chart
+	maxTimeseriesBuckets = 1500
+)
+
+// timeseriesQuery is a validated timeseries request
+type timeseriesQuery struct {
+	start       time.Time
+	end         time.Time
+	granularity string
+	location    *time.Location
+	groupBy     string
+	endpoint    *string
+	apiKeyID    pgtype.UUID
+	statusClass *int16
+}
+
+// parseTimeseriesQuery validates the timeseries parameters. start and end
+// are RFC 3339; tz is an IANA zone name that bucket boundaries are aligned to.
+func parseTimeseriesQuery(r *http.Request) (timeseriesQuery, error) {
+	query := r.URL.Query()
+	q := timeseriesQuery{
+		granularity: query.Get("granularity"),
+		groupBy:     query.Get("group_by"),
+		location:    time.UTC,
+	}
+
+	if q.granularity == "" {
+		q.granularity = "day"
+	}
+	window, ok := timeseriesDefaults[q.granularity]
+	if !ok {
+		return q, fmt.Errorf("granularity must be one of minute, hour, day, week, month")
+	}
+
+	if tz := query.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil || tz == "Local" {
+			return q, fmt.Errorf("tz must be an IANA time zone such as Europe/Berlin")
+		}
+		q.location = loc
+	}
+
+	if q.groupBy != "" && !timeseriesGroups[q.groupBy] {
+		return q, fmt.Errorf("group_by must be one of endpoint, api_key, status_class")
+	}
+
+	q.end = time.Now().UTC()
+	if endStr := query.Get("end"); endStr != "" {
+		parsed, err := time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			return q, fmt.Errorf("end must be an RFC 3339 timestamp")
+		}
+		q.end = parsed.UTC()
+	}
+
+	q.start = q.end.Add(-window)
+	if startStr := query.Get("start"); startStr != "" {
+		parsed, err := time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			return q, fmt.Errorf("start must be an RFC 3339 timestamp")
+		}
+		q.start = parsed.UTC()
+	}
+
+	if !q.start.Before(q.end) {
+		return q, fmt.Errorf("start must be before end")
+	}
+	if q.end.Sub(q.start) > maxTimeseriesRange {
+		return q, fmt.Errorf("range cannot exceed 366 days")
+	}
+
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		q.endpoint = &endpoint
+	}
+
+	if keyStr := query.Get("api_key_id"); keyStr != "" {
+		keyID, err := uuid.Parse(keyStr)
+		if err != nil {
+			return q, fmt.Errorf("api_key_id must be a valid UUID")
+		}
+		q.apiKeyID = pgtype.UUID{Bytes: keyID, Valid: true}
+	}
+
+	if classStr := query.Get("status_class"); classStr != "" {
+		class, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(classStr), "xx"))
+		if err != nil || class < 1 || class > 5 {
+			return q, fmt.Errorf("status_class must be one of 1xx, 2xx, 3xx, 4xx, 5xx")
+		}
+		statusClass := int16(class)
+		q.statusClass = &statusClass
+	}
+
+	return q, nil
+}
+
+// truncateBucket returns the start of the bucket containing t, using t's
+// location for day, week and month boundaries. Weeks start on Monday,
+// matching PostgreSQL's date_trunc.
+func truncateBucket(t time.Time, granularity string) time.Time {
+	loc := t.Location()
+	switch granularity {
+	case "minute":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	case "week":
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, loc)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+}
+
+// nextBucket returns the start of the bucket after the one starting at t.
+// Calendar arithmetic keeps day and larger buckets on local midnight across
+// daylight saving changes.
+func nextBucket(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case "minute":
+		return t.Add(time.Minute)
+	case "hour":
+		return t.Add(time.Hour)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// timeseriesBuckets lists every bucket start between start and end in loc,
+// so series can be zero-filled where there was no traffic.
+func timeseriesBuckets(start, end time.Time, granularity string, loc *time.Location) ([]time.Time, error) {
+	buckets := make([]time.Time, 0)
+	for t := truncateBucket(start.In(loc), granularity); !t.After(end); t = nextBucket(t, granularity) {
+		if len(buckets) == maxTimeseriesBuckets {
+			return nil, fmt.Errorf("range produces more than %d buckets, use a coarser granularity", maxTimeseriesBuckets)
+		}
+		buckets = append(buckets, t)
+	}
+	return buckets, nil
+}
+
+// bucketFromWallClock reads a bucket returned by the database, which holds
+// local wall-clock time without a zone, as a time in loc.
+func bucketFromWallClock(wall time.Time, loc *time.Location) time.Time {
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, loc)
+}
+
+// timeseriesPoint holds the totals for one bucket of a series
+type timeseriesPoint struct {
+	requests int64
+	errors   int64
+	units    int64
+}
+
+// fillTimeseries expands the sparse points of a series to one entry per
+// bucket, using zero for buckets without any traffic.
+func fillTimeseries(buckets []time.Time, points map[int64]timeseriesPoint) []map[string]interface{} {
+	filled := make([]map[string]interface{}, 0, len(buckets))
+	for _, bucket := range buckets {
+		point := points[bucket.Unix()]
+		filled = append(filled, map[string]interface{}{
+			"timestamp": bucket.Format(time.RFC3339),
+			"requests":  point.requests,
+			"errors":    point.errors,
+			"units":     point.units,
+		})
+	}
+	return filled
+}
+
+func (cfg *apiConfig) getTimeseriesAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	q, err := parseTimeseriesQuery(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	buckets, err := timeseriesBuckets(q.start, q.end, q.granularity, q.location)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "VALIDATION_ERROR",
+			Message: err.Error(),
+		})
+		return
+	}
+	queryStart := buckets[0].UTC()
+
+	// Hourly rollups are too coarse for minute buckets, so read raw records
+	// for the whole range in that case
+	rawSince := q.end
+	if q.granularity == "minute" {
+		rawSince = queryStart
+	}
+
+	rows, err := cfg.db.GetUsageTimeseries(r.Context(), database.GetUsageTimeseriesParams{
+		RawSince:       pgtype.Timestamp{Time: rawSince, Valid: true},
+		OrganizationID: user.OrganizationID,
+		StartTime:      pgtype.Timestamp{Time: queryStart, Valid: true},
+		EndTime:        pgtype.Timestamp{Time: q.end, Valid: true},
+		Granularity:    q.granularity,
+		Tz:             q.location.String(),
+		GroupBy:        q.groupBy,
+		Endpoint:       q.endpoint,
+		ApiKeyID:       q.apiKeyID,
+		StatusClass:    q.statusClass,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve usage timeseries",
+		})
+		return
+	}
+
+	series := make(map[string]map[int64]timeseriesPoint)
+	seriesOrder := make([]string, 0)
+	if q.groupBy == "" {
+		series[""] = make(map[int64]timeseriesPoint)
+		seriesOrder = append(seriesOrder, "")
+	}
+
+	for _, row := range rows {
+		points, ok := series[row.GroupKey]
+		if !ok {
+			points = make(map[int64]timeseriesPoint)
+			series[row.GroupKey] = points
+			seriesOrder = append(seriesOrder, row.GroupKey)
+		}
+		bucket := bucketFromWallClock(row.Bucket.Time, q.location)
+		points[bucket.Unix()] = timeseriesPoint{
+			requests: row.RequestCount,
+			errors:   row.ErrorCount,
+			units:    row.Units,
+		}
+	}
+
+	keyNames := make(map[string]string)
+	if q.groupBy == "api_key" {
+		keys, err := cfg.db.ListOrganizationAPIKeys(r.Context(), user.OrganizationID)
+		if err == nil {
+			for _, key := range keys {
+				keyNames[key.ID.String()] = key.Name
+			}
+		}
+	}
+
+	seriesData := make([]map[string]interface{}, 0, len(seriesOrder))
+	for _, key := range seriesOrder {
+		data := map[string]interface{}{
+			"key":    key,
+			"points": fillTimeseries(buckets, series[key]),
+		}
+		if q.groupBy == "" {
+			data["key"] = "total"
+		}
+		if name, ok := keyNames[key]; ok {
+			data["name"] = name
+		}
+		seriesData = append(seriesData, data)
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"period": map[string]interface{}{
+				"start": buckets[0].Format(time.RFC3339),
+				"end":   q.end.In(q.location).Format(time.RFC3339),
+			},
+			"granularity": q.granularity,
+			"timezone":    q.location.String(),
+			"group_by":    q.groupBy,
+			"series":      seriesData,
+		},
+	})
+}
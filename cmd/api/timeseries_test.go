@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTimeseriesQuery(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		wantGranularity string
+		wantLocation    string
+		wantErr         bool
+	}{
+		{
+			name:            "Defaults",
+			query:           "",
+			wantGranularity: "day",
+			wantLocation:    "UTC",
+		},
+		{
+			name:            "Time zone and grouping",
+			query:           "granularity=hour&tz=America/New_York&group_by=endpoint",
+			wantGranularity: "hour",
+			wantLocation:    "America/New_York",
+		},
+		{
+			name:            "Status class filter",
+			query:           "status_class=5xx&api_key_id=7f0c2a64-4a8e-4d53-9a3e-2f8f3c9c1b11",
+			wantGranularity: "day",
+			wantLocation:    "UTC",
+		},
+		{
+			name:    "Unknown granularity",
+			query:   "granularity=second",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown time zone",
+			query:   "tz=Mars/Olympus_Mons",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown group",
+			query:   "group_by=user",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid status class",
+			query:   "status_class=7xx",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid API key",
+			query:   "api_key_id=not-a-uuid",
+			wantErr: true,
+		},
+		{
+			name:    "Range too long",
+			query:   "start=2025-01-01T00:00:00Z&end=2026-10-01T00:00:00Z",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/analytics/timeseries?"+tt.query, nil)
+			got, err := parseTimeseriesQuery(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTimeseriesQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.granularity != tt.wantGranularity {
+				t.Errorf("granularity = %s, want %s", got.granularity, tt.wantGranularity)
+			}
+			if got.location.String() != tt.wantLocation {
+				t.Errorf("location = %s, want %s", got.location, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestTimeseriesBuckets(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		start       time.Time
+		end         time.Time
+		granularity string
+		loc         *time.Location
+		want        []string
+	}{
+		{
+			name:        "Hourly buckets align to the hour",
+			start:       time.Date(2026, 10, 1, 10, 30, 0, 0, time.UTC),
+			end:         time.Date(2026, 10, 1, 12, 15, 0, 0, time.UTC),
+			granularity: "hour",
+			loc:         time.UTC,
+			want:        []string{"2026-10-01T10:00:00Z", "2026-10-01T11:00:00Z", "2026-10-01T12:00:00Z"},
+		},
+		{
+			name:        "Daily buckets follow local midnight across DST",
+			start:       time.Date(2026, 11, 1, 2, 0, 0, 0, time.UTC),
+			end:         time.Date(2026, 11, 2, 12, 0, 0, 0, time.UTC),
+			granularity: "day",
+			loc:         newYork,
+			want:        []string{"2026-10-31T00:00:00-04:00", "2026-11-01T00:00:00-04:00", "2026-11-02T00:00:00-05:00"},
+		},
+		{
+			name:        "Weeks start on Monday",
+			start:       time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC),
+			end:         time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC),
+			granularity: "week",
+			loc:         time.UTC,
+			want:        []string{"2026-10-12T00:00:00Z", "2026-10-19T00:00:00Z"},
+		},
+		{
+			name:        "Monthly buckets",
+			start:       time.Date(2026, 8, 20, 0, 0, 0, 0, time.UTC),
+			end:         time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC),
+			granularity: "month",
+			loc:         time.UTC,
+			want:        []string{"2026-08-01T00:00:00Z", "2026-09-01T00:00:00Z", "2026-10-01T00:00:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets, err := timeseriesBuckets(tt.start, tt.end, tt.granularity, tt.loc)
+			if err != nil {
+				t.Fatalf("timeseriesBuckets() error = %v", err)
+			}
+			if len(buckets) != len(tt.want) {
+				t.Fatalf("timeseriesBuckets() returned %d buckets, want %d", len(buckets), len(tt.want))
+			}
+			for i, bucket := range buckets {
+				if got := bucket.Format(time.RFC3339); got != tt.want[i] {
+					t.Errorf("bucket %d = %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTimeseriesBucketsLimit(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := timeseriesBuckets(start, start.Add(48*time.Hour), "minute", time.UTC); err == nil {
+		t.Error("timeseriesBuckets() expected an error for too many buckets")
+	}
+}
+
+func TestFillTimeseries(t *testing.T) {
+	buckets := []time.Time{
+		time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 3, 0, 0, 0, 0, time.UTC),
+	}
+	points := map[int64]timeseriesPoint{
+		bucketFromWallClock(time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC), time.UTC).Unix(): {requests: 12, errors: 2, units: 15},
+	}
+
+	filled := fillTimeseries(buckets, points)
+	if len(filled) != len(buckets) {
+		t.Fatalf("fillTimeseries() returned %d points, want %d", len(filled), len(buckets))
+	}
+
+	wantRequests := []int64{0, 12, 0}
+	for i, point := range filled {
+		if got := point["requests"].(int64); got != wantRequests[i] {
+			t.Errorf("point %d requests = %d, want %d", i, got, wantRequests[i])
+		}
+	}
+	if got := filled[1]["errors"].(int64); got != 2 {
+		t.Errorf("point 1 errors = %d, want 2", got)
+	}
+}
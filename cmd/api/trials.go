@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/plan"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stripe/stripe-go/v76"
+)
+
+// startTrialHandler moves an organization on the free plan onto a paid plan
+// for a trial of TRIAL_DAYS days. Nothing used during it is invoiced. When it
+// ends the scheduler converts the organization to the plan, charging its
+// saved payment method, or returns it to free.
+func (cfg *apiConfig) startTrialHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Plan string `json:"plan"`
+	}
+
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	var params parameters
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_REQUEST",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	var trialPlan database.PlanType
+	switch params.Plan {
+	case "starter":
+		trialPlan = database.PlanTypeStarter
+	case "pro":
+		trialPlan = database.PlanTypePro
+	default:
+		respondWithError(w, http.StatusBadRequest, ApiError{
+			Code:    "INVALID_PLAN",
+			Message: "Trial plan must be 'starter' or 'pro'",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can start a trial",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization",
+		})
+		return
+	}
+
+	if org.TrialStatus.Valid {
+		respondWithError(w, http.StatusConflict, ApiError{
+			Code:    "TRIAL_USED",
+			Message: "Organization has already had a trial",
+		})
+		return
+	}
+	if org.Plan != database.PlanTypeFree {
+		respondWithError(w, http.StatusConflict, ApiError{
+			Code:    "NOT_ON_FREE_PLAN",
+			Message: "Trials can only be started from the free plan",
+		})
+		return
+	}
+
+	tx, err := cfg.pool.Begin(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to start trial",
+		})
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	qtx := cfg.db.WithTx(tx)
+	if _, err := qtx.GetCurrentPlanHistoryForUpdate(r.Context(), org.ID); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to start trial",
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	endsAt := now.AddDate(0, 0, cfg.config.TrialDays)
+
+	// Starting only succeeds for an organization still on free that has
+	// not had a trial, whatever changed since it was read
+	org, err = qtx.StartTrial(r.Context(), database.StartTrialParams{
+		ID:             org.ID,
+		TrialPlan:      database.NullPlanType{PlanType: trialPlan, Valid: true},
+		TrialStartedAt: pgtype.Timestamp{Time: now, Valid: true},
+		TrialEndsAt:    pgtype.Timestamp{Time: endsAt, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, ApiError{
+			Code:    "PLAN_CHANGED",
+			Message: "Organization plan changed while starting the trial, try again",
+		})
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to start trial",
+		})
+		return
+	}
+
+	org, err = plan.Apply(r.Context(), qtx, org, plan.Change{
+		Plan:      trialPlan,
+		At:        now,
+		ChangedBy: userID,
+		Trial:     true,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to start trial",
+		})
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to start trial",
+		})
+		return
+	}
+
+	hasMethod, err := cfg.hasPaymentMethod(r.Context(), org.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve payment method",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, ApiResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d-day trial started", cfg.config.TrialDays),
+		Data: map[string]interface{}{
+			"trial": trialData(org, hasMethod, now),
+		},
+	})
+}
+
+// getTrialHandler returns the organization's trial, or null if it has never
+// had one
+func (cfg *apiConfig) getTrialHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization",
+		})
+		return
+	}
+
+	var trial interface{}
+	if org.TrialStatus.Valid {
+		hasMethod, err := cfg.hasPaymentMethod(r.Context(), org.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, ApiError{
+				Code:    "INTERNAL_ERROR",
+				Message: "Failed to retrieve payment method",
+			})
+			return
+		}
+		trial = trialData(org, hasMethod, time.Now().UTC())
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"trial": trial,
+		},
+	})
+}
+
+func trialData(org database.Organization, hasPaymentMethod bool, now time.Time) map[string]interface{} {
+	data := map[string]interface{}{
+		"status":             org.TrialStatus.TrialStatus,
+		"plan":               org.TrialPlan.PlanType,
+		"started_at":         org.TrialStartedAt.Time,
+		"ends_at":            org.TrialEndsAt.Time,
+		"has_payment_method": hasPaymentMethod,
+	}
+	if org.TrialStatus.TrialStatus == database.TrialStatusActive {
+		data["days_left"] = max(int(math.Ceil(org.TrialEndsAt.Time.Sub(now).Hours()/24)), 0)
+	}
+	return data
+}
+
+func (cfg *apiConfig) hasPaymentMethod(ctx context.Context, orgID uuid.UUID) (bool, error) {
+	_, err := cfg.db.GetPaymentMethod(ctx, orgID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// getPaymentMethodHandler returns the card saved for the organization, or
+// null if it has none
+func (cfg *apiConfig) getPaymentMethodHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	var saved interface{}
+	method, err := cfg.db.GetPaymentMethod(r.Context(), user.OrganizationID)
+	if err == nil {
+		saved = paymentMethodData(method)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve payment method",
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"payment_method": saved,
+		},
+	})
+}
+
+// setupPaymentMethodHandler opens a Stripe checkout that saves a card
+// without charging it. The card is saved when Stripe reports the session
+// complete. Paystack cards are saved from any charge paid with a reusable
+// card, so there is nothing to set up for them.
+func (cfg *apiConfig) setupPaymentMethodHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, ApiError{
+			Code:    "UNAUTHORIZED",
+			Message: "User not authenticated",
+		})
+		return
+	}
+
+	user, err := cfg.db.GetUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve user details",
+		})
+		return
+	}
+
+	if user.Role != database.UserRoleOwner {
+		respondWithError(w, http.StatusForbidden, ApiError{
+			Code:    "PERMISSION_DENIED",
+			Message: "Only organization owner can change the payment method",
+		})
+		return
+	}
+
+	org, err := cfg.db.GetOrganization(r.Context(), user.OrganizationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "INTERNAL_ERROR",
+			Message: "Failed to retrieve organization",
+		})
+		return
+	}
+
+	org, err = cfg.stripeCustomer(r.Context(), org)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "PAYMENT_ERROR",
+			Message: "Failed to create payment customer",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	session, err := cfg.paymentService.Stripe.CreateSetupSession(payment.SetupSessionParams{
+		OrganizationID: org.ID.String(),
+		CustomerID:     org.StripeCustomerID.String,
+		SuccessURL:     cfg.config.AppURL + "/billing/payment-method/success?session_id={CHECKOUT_SESSION_ID}",
+		CancelURL:      cfg.config.AppURL + "/billing",
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, ApiError{
+			Code:    "PAYMENT_ERROR",
+			Message: "Failed to create setup session",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, ApiResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"setup_url":  session.URL,
+			"session_id": session.ID,
+			"provider":   "stripe",
+		},
+	})
+}
+
+func paymentMethodData(method database.PaymentMethod) map[string]interface{} {
+	return map[string]interface{}{
+		"provider":   method.Provider,
+		"brand":      method.Brand.String,
+		"last4":      method.Last4.String,
+		"email":      method.Email,
+		"updated_at": method.UpdatedAt.Time,
+	}
+}
+
+// saveStripePaymentMethod saves the card a completed setup session added to
+// the organization's Stripe customer
+func (cfg *apiConfig) saveStripePaymentMethod(ctx context.Context, session stripe.CheckoutSession) error {
+	orgID, err := uuid.Parse(session.Metadata["organization_id"])
+	if err != nil {
+		return fmt.Errorf("invalid organization ID %q", session.Metadata["organization_id"])
+	}
+	if session.SetupIntent == nil {
+		return fmt.Errorf("session has no setup intent")
+	}
+
+	card, err := cfg.paymentService.Stripe.SetupIntentCard(session.SetupIntent.ID)
+	if err != nil {
+		return err
+	}
+	org, err := cfg.db.GetOrganization(ctx, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	_, err = cfg.db.UpsertPaymentMethod(ctx, database.UpsertPaymentMethodParams{
+		OrganizationID: orgID,
+		Provider:       "stripe",
+		CustomerID:     pgtype.Text{String: card.CustomerID, Valid: true},
+		Reference:      card.PaymentMethodID,
+		Email:          org.Email,
+		Brand:          pgtype.Text{String: card.Brand, Valid: card.Brand != ""},
+		Last4:          pgtype.Text{String: card.Last4, Valid: card.Last4 != ""},
+	})
+	return err
+}
+
+// savePaystackPaymentMethod saves a reusable authorization an organization
+// paid with
+func (cfg *apiConfig) savePaystackPaymentMethod(ctx context.Context, orgID uuid.UUID, auth payment.PaystackAuthorization) error {
+	_, err := cfg.db.UpsertPaymentMethod(ctx, database.UpsertPaymentMethodParams{
+		OrganizationID: orgID,
+		Provider:       "paystack",
+		Reference:      auth.Code,
+		Email:          auth.Email,
+		Brand:          pgtype.Text{String: auth.Brand, Valid: auth.Brand != ""},
+		Last4:          pgtype.Text{String: auth.Last4, Valid: auth.Last4 != ""},
+	})
+	return err
+}
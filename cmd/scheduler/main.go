@@ -6,9 +6,16 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/email"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/events"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/invoice"
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/jobs"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 	"github.com/robfig/cron/v3"
@@ -46,6 +53,61 @@ func main() {
 
 	db := database.New(pool)
 
+	archiveDir := os.Getenv("USAGE_ARCHIVE_DIR")
+	if archiveDir == "" {
+		archiveDir = "archive/usage_records"
+	}
+	exportDir := os.Getenv("USAGE_EXPORT_DIR")
+	if exportDir == "" {
+		exportDir = "exports/usage"
+	}
+
+	emailService, err := email.NewEmailService()
+	if err != nil {
+		log.Printf("Warning: email service unavailable, alerts will not be emailed: %v", err)
+	}
+	appURL := os.Getenv("APP_URL")
+	seller := invoice.Seller{
+		Name:    os.Getenv("FROM_NAME"),
+		Email:   os.Getenv("FROM_EMAIL"),
+		Website: appURL,
+		Issuer:  os.Getenv("INVOICE_ISSUER"),
+		Country: os.Getenv("SELLER_COUNTRY"),
+		TaxID:   os.Getenv("SELLER_TAX_ID"),
+	}
+	if err := invoice.ValidateIssuer(seller.NumberIssuer()); err != nil {
+		log.Fatalf("Invalid INVOICE_ISSUER: %v", err)
+	}
+	// Webhook endpoints may use plain http in development only
+	environment := os.Getenv("ENVIRONMENT")
+	deliverer := events.NewDeliverer(10*time.Second, environment == "" || environment == "development")
+	payments := payment.NewPaymentService(
+		os.Getenv("STRIPE_SECRET_KEY"),
+		os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		os.Getenv("STRIPE_API_URL"),
+		os.Getenv("PAYSTACK_SECRET_KEY"),
+		os.Getenv("PAYSTACK_WEBHOOK_SECRET"),
+	)
+
+	// Price books are reloaded for every run so newly published books are
+	// picked up without a restart
+	priceBookFile := os.Getenv("PRICE_BOOK_FILE")
+	loadPricing := func() (*pricing.Catalog, error) {
+		return pricing.Load(context.Background(), db, priceBookFile)
+	}
+	if _, err := loadPricing(); err != nil {
+		log.Fatalf("Failed to load price books: %v", err)
+	}
+
+	// Exchange rates are reloaded for every run as well
+	fxRatesFile := os.Getenv("FX_RATES_FILE")
+	loadRates := func() (*currency.Rates, error) {
+		return currency.Load(context.Background(), db, fxRatesFile)
+	}
+	if _, err := loadRates(); err != nil {
+		log.Fatalf("Failed to load exchange rates: %v", err)
+	}
+
 	c := cron.New(cron.WithSeconds())
 
 	// ============================================
@@ -57,7 +119,19 @@ func main() {
 		log.Println("Starting monthly billing cycle generation...")
 		log.Println("========================================")
 
-		if err := jobs.GenerateMonthlyBillingCycles(db); err != nil {
+		catalog, err := loadPricing()
+		if err != nil {
+			log.Printf("ERROR: Failed to load price books: %v", err)
+			return
+		}
+
+		rates, err := loadRates()
+		if err != nil {
+			log.Printf("ERROR: Failed to load exchange rates: %v", err)
+			return
+		}
+
+		if err := jobs.GenerateMonthlyBillingCycles(pool, catalog, rates, emailService, appURL, seller); err != nil {
 			log.Printf("ERROR: Failed to generate billing cycles: %v", err)
 			return
 		}
@@ -94,6 +168,184 @@ func main() {
 		log.Fatalf("Failed to schedule overdue check job: %v", err)
 	}
 
+	// ============================================
+	// Job 3: Usage Rollups
+	// Runs every hour at minute 10
+	// ============================================
+	_, err = c.AddFunc("0 10 * * * *", func() {
+		log.Println("Starting usage rollup...")
+
+		if err := jobs.RollupUsage(pool); err != nil {
+			log.Printf("ERROR: Failed to roll up usage: %v", err)
+			return
+		}
+
+		log.Println("Usage rollup completed successfully")
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule usage rollup job: %v", err)
+	}
+
+	// ============================================
+	// Job 4: Usage Partition Maintenance
+	// Runs every day at 03:00:00 UTC
+	// ============================================
+	_, err = c.AddFunc("0 0 3 * * *", func() {
+		log.Println("Starting usage partition maintenance...")
+
+		if err := jobs.MaintainUsagePartitions(pool, archiveDir); err != nil {
+			log.Printf("ERROR: Failed to maintain usage partitions: %v", err)
+			return
+		}
+
+		log.Println("Usage partition maintenance completed successfully")
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule usage partition job: %v", err)
+	}
+
+	// ============================================
+	// Job 5: Usage Anomaly Detection
+	// Runs every hour at minute 15, after the rollups
+	// ============================================
+	_, err = c.AddFunc("0 15 * * * *", func() {
+		log.Println("Starting usage anomaly detection...")
+
+		if err := jobs.DetectUsageAnomalies(db, emailService, appURL); err != nil {
+			log.Printf("ERROR: Failed to detect usage anomalies: %v", err)
+			return
+		}
+
+		log.Println("Usage anomaly detection completed successfully")
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule usage anomaly job: %v", err)
+	}
+
+	// ============================================
+	// Job 6: Usage Budget Thresholds
+	// Runs every 5 minutes
+	// ============================================
+	_, err = c.AddFunc("0 */5 * * * *", func() {
+		catalog, err := loadPricing()
+		if err != nil {
+			log.Printf("ERROR: Failed to load price books: %v", err)
+			return
+		}
+		rates, err := loadRates()
+		if err != nil {
+			log.Printf("ERROR: Failed to load exchange rates: %v", err)
+			return
+		}
+
+		if err := jobs.CheckUsageBudgets(pool, emailService, appURL, catalog, rates); err != nil {
+			log.Printf("ERROR: Failed to check usage budgets: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule usage budget job: %v", err)
+	}
+
+	// ============================================
+	// Job 7: Outbound Event Delivery
+	// Runs every minute at second 30
+	// ============================================
+	_, err = c.AddFunc("30 * * * * *", func() {
+		if err := jobs.DeliverOutboundEvents(db, deliverer); err != nil {
+			log.Printf("ERROR: Failed to deliver outbound events: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule outbound event job: %v", err)
+	}
+
+	// ============================================
+	// Job 8: Usage Exports
+	// Runs every 15 seconds
+	// ============================================
+	_, err = c.AddFunc("*/15 * * * * *", func() {
+		if err := jobs.ProcessUsageExports(db, exportDir); err != nil {
+			log.Printf("ERROR: Failed to process usage exports: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule usage export job: %v", err)
+	}
+
+	// ============================================
+	// Job 9: Prepaid Credit Balances
+	// Runs every 5 minutes at second 15
+	// ============================================
+	_, err = c.AddFunc("15 */5 * * * *", func() {
+		catalog, err := loadPricing()
+		if err != nil {
+			log.Printf("ERROR: Failed to load price books: %v", err)
+			return
+		}
+		rates, err := loadRates()
+		if err != nil {
+			log.Printf("ERROR: Failed to load exchange rates: %v", err)
+			return
+		}
+
+		if err := jobs.CheckCreditBalances(pool, catalog, rates, emailService, appURL); err != nil {
+			log.Printf("ERROR: Failed to check credit balances: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule credit balance job: %v", err)
+	}
+
+	// ============================================
+	// Job 10: Scheduled Plan Changes
+	// Runs every minute at second 5
+	// ============================================
+	_, err = c.AddFunc("5 * * * * *", func() {
+		if err := jobs.ApplyScheduledPlanChanges(pool); err != nil {
+			log.Printf("ERROR: Failed to apply scheduled plan changes: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule plan change job: %v", err)
+	}
+
+	// ============================================
+	// Job 11: Trials
+	// Runs every minute at second 35
+	// ============================================
+	_, err = c.AddFunc("35 * * * * *", func() {
+		catalog, err := loadPricing()
+		if err != nil {
+			log.Printf("ERROR: Failed to load price books: %v", err)
+			return
+		}
+		rates, err := loadRates()
+		if err != nil {
+			log.Printf("ERROR: Failed to load exchange rates: %v", err)
+			return
+		}
+
+		if err := jobs.ProcessTrials(pool, catalog, rates, payments, emailService, appURL, seller); err != nil {
+			log.Printf("ERROR: Failed to process trials: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule trial job: %v", err)
+	}
+
+	// ============================================
+	// Job 12: Stripe Usage Reporting
+	// Runs every hour at minute 20, after the rollups
+	// ============================================
+	_, err = c.AddFunc("0 20 * * * *", func() {
+		if err := jobs.ReportStripeUsage(pool, payments); err != nil {
+			log.Printf("ERROR: Failed to report usage to Stripe: %v", err)
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed to schedule Stripe usage job: %v", err)
+	}
+
 	// ============================================
 	// Optional: Test Job (runs every minute)
 	// Comment out in production
@@ -109,6 +361,16 @@ func main() {
 	log.Println("Scheduled jobs:")
 	log.Println("1. Monthly Billing Generation: 1st of every month at 00:00 UTC")
 	log.Println("2. Overdue Check: Every day at 02:00 UTC")
+	log.Println("3. Usage Rollups: Every hour at minute 10")
+	log.Println("4. Usage Partitions & Retention: Every day at 03:00 UTC")
+	log.Println("5. Usage Anomaly Detection: Every hour at minute 15")
+	log.Println("6. Usage Budget Thresholds: Every 5 minutes")
+	log.Println("7. Outbound Event Delivery: Every minute")
+	log.Println("8. Usage Exports: Every 15 seconds")
+	log.Println("9. Prepaid Credit Balances: Every 5 minutes")
+	log.Println("10. Scheduled Plan Changes: Every minute")
+	log.Println("11. Trials: Every minute")
+	log.Println("12. Stripe Usage Reporting: Every hour at minute 20")
 	log.Println("========================================")
 
 	quit := make(chan os.Signal, 1)
@@ -20,6 +20,7 @@ type Config struct {
 	Environment             Environment
 	Port                    string
 	AppURL                  string
+	APIURL                  string
 	DatabaseURL             string
 	RedisURL                string
 	JWTSecret               string
@@ -30,8 +31,24 @@ type Config struct {
 	FromEmail               string
 	FromName                string
 	RateLimit               int
+	ConcurrencyLimit        int
+	ConcurrencyLeaseSeconds int
+	UsageBufferSize         int
+	UsageBatchSize          int
+	UsageFlushIntervalMs    int
+	UsageExportDir          string
+	PriceBookFile           string
+	FXRatesFile             string
+	SellerCountry           string
+	SellerTaxID             string
+	AdminAPIToken           string
+	TrialDays               int
 	StripeSecretKey         string
 	StripeWebhookSecret     string
+	StripeAPIURL            string
+	StripePriceStarter      string
+	StripePricePro          string
+	StripeMeteredPrice      string
 	PaystackSecretKey       string
 	PaystackWebhookSecret   string
 	EnableEmailVerification bool
@@ -58,6 +75,7 @@ func Load() (*Config, error) {
 		Environment: Environment(env),
 		Port:        getEnv("PORT", "8080"),
 		AppURL:      getEnv("APP_URL", "http://localhost:3000"),
+		APIURL:      getEnv("API_URL", "http://localhost:8080"),
 
 		DatabaseURL: getEnv("DATABASE_URL", ""),
 		RedisURL:    getEnv("REDIS_URL", "redis://localhost:6379"),
@@ -71,10 +89,31 @@ func Load() (*Config, error) {
 		FromEmail:    getEnv("FROM_EMAIL", "noreply@mts.com"),
 		FromName:     getEnv("FROM_NAME", "MTS"),
 
-		RateLimit: getEnvAsInt("RATE_LIMIT_PER_MINUTE", 60),
+		RateLimit:               getEnvAsInt("RATE_LIMIT_PER_MINUTE", 60),
+		ConcurrencyLimit:        getEnvAsInt("CONCURRENCY_LIMIT_PER_ORG", 10),
+		ConcurrencyLeaseSeconds: getEnvAsInt("CONCURRENCY_LEASE_SECONDS", 60),
+
+		UsageBufferSize:      getEnvAsInt("USAGE_BUFFER_SIZE", 10000),
+		UsageBatchSize:       getEnvAsInt("USAGE_BATCH_SIZE", 500),
+		UsageFlushIntervalMs: getEnvAsInt("USAGE_FLUSH_INTERVAL_MS", 1000),
+		UsageExportDir:       getEnv("USAGE_EXPORT_DIR", "exports/usage"),
+
+		PriceBookFile: getEnv("PRICE_BOOK_FILE", ""),
+		FXRatesFile:   getEnv("FX_RATES_FILE", ""),
+
+		SellerCountry: getEnv("SELLER_COUNTRY", ""),
+		SellerTaxID:   getEnv("SELLER_TAX_ID", ""),
+
+		AdminAPIToken: getEnv("ADMIN_API_TOKEN", ""),
+
+		TrialDays: getEnvAsInt("TRIAL_DAYS", 14),
 
 		StripeSecretKey:       getEnv("STRIPE_SECRET_KEY", ""),
 		StripeWebhookSecret:   getEnv("STRIPE_WEBHOOK_SECRET", ""),
+		StripeAPIURL:          getEnv("STRIPE_API_URL", ""),
+		StripePriceStarter:    getEnv("STRIPE_PRICE_STARTER", ""),
+		StripePricePro:        getEnv("STRIPE_PRICE_PRO", ""),
+		StripeMeteredPrice:    getEnv("STRIPE_METERED_PRICE", ""),
 		PaystackSecretKey:     getEnv("PAYSTACK_SECRET_KEY", ""),
 		PaystackWebhookSecret: getEnv("PAYSTACK_WEBHOOK_SECRET", ""),
 
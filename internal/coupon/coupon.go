@@ -0,0 +1,287 @@
+// Package coupon works out the discounts organizations redeem with coupon
+// codes
+package coupon
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// Why a coupon cannot be redeemed
+var (
+	ErrInactive = errors.New("coupon is no longer active")
+	ErrExpired  = errors.New("coupon has expired")
+	ErrUsedUp   = errors.New("coupon has reached its redemption limit")
+)
+
+// MaxDurationMonths bounds how long a repeating coupon lasts
+const MaxDurationMonths = 120
+
+var codePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,50}$`)
+
+// Coupon is a discount redeemable by its code
+type Coupon struct {
+	Code string
+	Name string
+	Type database.CouponDiscountType
+	// PercentOff is set on percentage coupons, e.g. 20 for 20% off
+	PercentOff decimal.Decimal
+	// AmountOff is set on fixed coupons, in Currency
+	AmountOff decimal.Decimal
+	Currency  string
+	Duration  database.CouponDuration
+	// DurationMonths is how many invoices a repeating coupon discounts
+	DurationMonths int32
+	// Plans lists the plans the coupon applies to, every plan when empty
+	Plans []database.PlanType
+	// MaxRedemptions of zero and a zero RedeemBy are unlimited
+	MaxRedemptions int32
+	RedeemBy       time.Time
+	TimesRedeemed  int32
+	Active         bool
+}
+
+// FromRow converts a stored coupon
+func FromRow(row database.Coupon) Coupon {
+	plans := make([]database.PlanType, 0, len(row.Plans))
+	for _, plan := range row.Plans {
+		plans = append(plans, database.PlanType(plan))
+	}
+	return Coupon{
+		Code:           row.Code,
+		Name:           row.Name,
+		Type:           row.DiscountType,
+		PercentOff:     currency.FromNumeric(row.PercentOff),
+		AmountOff:      currency.FromNumeric(row.AmountOff),
+		Currency:       row.Currency.String,
+		Duration:       row.Duration,
+		DurationMonths: row.DurationMonths.Int32,
+		Plans:          plans,
+		MaxRedemptions: row.MaxRedemptions.Int32,
+		RedeemBy:       row.RedeemBy.Time,
+		TimesRedeemed:  row.TimesRedeemed,
+		Active:         row.Active,
+	}
+}
+
+// NormalizeCode is how codes are stored and looked up: codes are not case
+// sensitive
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks a coupon can be stored
+func (c Coupon) Validate() error {
+	if !codePattern.MatchString(c.Code) {
+		return fmt.Errorf("code must be 3 to 50 letters, digits, dashes or underscores")
+	}
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("coupon has no name")
+	}
+
+	switch c.Type {
+	case database.CouponDiscountTypePercentage:
+		if !c.PercentOff.IsPositive() || c.PercentOff.GreaterThan(decimal.NewFromInt(100)) {
+			return fmt.Errorf("percent_off must be above 0 and at most 100")
+		}
+		if !c.PercentOff.Round(2).Equal(c.PercentOff) {
+			return fmt.Errorf("percent_off has at most 2 decimals")
+		}
+	case database.CouponDiscountTypeFixed:
+		if err := currency.Validate(c.Currency); err != nil {
+			return err
+		}
+		if !c.AmountOff.IsPositive() || !currency.Round(c.AmountOff, c.Currency).Equal(c.AmountOff) {
+			return fmt.Errorf("amount_off must be a positive amount of %s with at most %d decimals", c.Currency, currency.Exponent(c.Currency))
+		}
+	default:
+		return fmt.Errorf("discount_type must be 'percentage' or 'fixed'")
+	}
+
+	switch c.Duration {
+	case database.CouponDurationOnce, database.CouponDurationForever:
+		if c.DurationMonths != 0 {
+			return fmt.Errorf("duration_months is only set on repeating coupons")
+		}
+	case database.CouponDurationRepeating:
+		if c.DurationMonths < 1 || c.DurationMonths > MaxDurationMonths {
+			return fmt.Errorf("duration_months must be between 1 and %d", MaxDurationMonths)
+		}
+	default:
+		return fmt.Errorf("duration must be 'once', 'repeating' or 'forever'")
+	}
+
+	return c.ValidateLimits()
+}
+
+// ValidateLimits checks the parts of a coupon that can change after it is
+// created
+func (c Coupon) ValidateLimits() error {
+	for i, plan := range c.Plans {
+		switch plan {
+		case database.PlanTypeFree, database.PlanTypeStarter, database.PlanTypePro:
+		default:
+			return fmt.Errorf("unknown plan %q", plan)
+		}
+		if slices.Contains(c.Plans[:i], plan) {
+			return fmt.Errorf("plan %q is listed twice", plan)
+		}
+	}
+	if c.MaxRedemptions < 0 {
+		return fmt.Errorf("max_redemptions cannot be negative")
+	}
+	if c.MaxRedemptions > 0 && c.MaxRedemptions < c.TimesRedeemed {
+		return fmt.Errorf("max_redemptions cannot be below the %d redemptions made", c.TimesRedeemed)
+	}
+	return nil
+}
+
+// Redeemable reports why the coupon cannot be redeemed at now, or nil
+func (c Coupon) Redeemable(now time.Time) error {
+	switch {
+	case !c.Active:
+		return ErrInactive
+	case !c.RedeemBy.IsZero() && !now.Before(c.RedeemBy):
+		return ErrExpired
+	case c.MaxRedemptions > 0 && c.TimesRedeemed >= c.MaxRedemptions:
+		return ErrUsedUp
+	}
+	return nil
+}
+
+// AppliesTo reports whether the coupon discounts a plan
+func (c Coupon) AppliesTo(plan database.PlanType) bool {
+	return len(c.Plans) == 0 || slices.Contains(c.Plans, plan)
+}
+
+// Periods is how many invoices a redemption discounts, NULL for forever
+func (c Coupon) Periods() pgtype.Int4 {
+	switch c.Duration {
+	case database.CouponDurationOnce:
+		return pgtype.Int4{Int32: 1, Valid: true}
+	case database.CouponDurationRepeating:
+		return pgtype.Int4{Int32: c.DurationMonths, Valid: true}
+	default:
+		return pgtype.Int4{}
+	}
+}
+
+// Discount is the amount taken off an invoice's subtotal in code, the
+// invoice's currency, at at. Fixed amounts are converted at the rate in
+// effect then and never exceed the subtotal. It reports false when a fixed
+// amount has no rate to the invoice's currency.
+func (c Coupon) Discount(subtotal decimal.Decimal, code string, rates *currency.Rates, at time.Time) (decimal.Decimal, bool) {
+	if c.Type == database.CouponDiscountTypeFixed {
+		rate, ok := rates.RateAt(c.Currency, code, at)
+		if !ok {
+			return decimal.Zero, false
+		}
+		if !subtotal.IsPositive() {
+			return decimal.Zero, true
+		}
+		return decimal.Min(currency.Round(rate.Convert(c.AmountOff), code), subtotal), true
+	}
+
+	if !subtotal.IsPositive() {
+		return decimal.Zero, true
+	}
+	return currency.Round(subtotal.Mul(c.PercentOff).Div(decimal.NewFromInt(100)), code), true
+}
+
+// Terms describes the discount, e.g. "20% off for 3 months"
+func (c Coupon) Terms() string {
+	off := c.PercentOff.String() + "% off"
+	if c.Type == database.CouponDiscountTypeFixed {
+		off = currency.Format(c.AmountOff, c.Currency) + " off"
+	}
+	switch c.Duration {
+	case database.CouponDurationOnce:
+		return off + " once"
+	case database.CouponDurationRepeating:
+		if c.DurationMonths == 1 {
+			return off + " for 1 month"
+		}
+		return fmt.Sprintf("%s for %d months", off, c.DurationMonths)
+	default:
+		return off + " forever"
+	}
+}
+
+// Description is how the discount reads as an invoice line
+func (c Coupon) Description() string {
+	if c.Type == database.CouponDiscountTypePercentage {
+		return fmt.Sprintf("Discount %s (%s%% off)", c.Code, c.PercentOff.String())
+	}
+	return fmt.Sprintf("Discount %s (%s off)", c.Code, currency.Format(c.AmountOff, c.Currency))
+}
+
+// CreateParams are the coupon as stored
+func (c Coupon) CreateParams() database.CreateCouponParams {
+	params := database.CreateCouponParams{
+		Code:         c.Code,
+		Name:         strings.TrimSpace(c.Name),
+		DiscountType: c.Type,
+		Duration:     c.Duration,
+		Plans:        c.planNames(),
+	}
+	if c.Type == database.CouponDiscountTypeFixed {
+		params.AmountOff = numeric(c.AmountOff)
+		params.Currency = pgtype.Text{String: c.Currency, Valid: true}
+	} else {
+		params.PercentOff = numeric(c.PercentOff)
+	}
+	if c.Duration == database.CouponDurationRepeating {
+		params.DurationMonths = pgtype.Int4{Int32: c.DurationMonths, Valid: true}
+	}
+	params.MaxRedemptions = c.maxRedemptions()
+	params.RedeemBy = c.redeemBy()
+	return params
+}
+
+// UpdateParams are the changeable parts of the coupon stored as id
+func (c Coupon) UpdateParams(id uuid.UUID) database.UpdateCouponParams {
+	return database.UpdateCouponParams{
+		ID:             id,
+		Name:           strings.TrimSpace(c.Name),
+		Plans:          c.planNames(),
+		MaxRedemptions: c.maxRedemptions(),
+		RedeemBy:       c.redeemBy(),
+		Active:         c.Active,
+	}
+}
+
+func (c Coupon) planNames() []string {
+	plans := make([]string, 0, len(c.Plans))
+	for _, plan := range c.Plans {
+		plans = append(plans, string(plan))
+	}
+	return plans
+}
+
+func (c Coupon) maxRedemptions() pgtype.Int4 {
+	if c.MaxRedemptions == 0 {
+		return pgtype.Int4{}
+	}
+	return pgtype.Int4{Int32: c.MaxRedemptions, Valid: true}
+}
+
+func (c Coupon) redeemBy() pgtype.Timestamp {
+	if c.RedeemBy.IsZero() {
+		return pgtype.Timestamp{}
+	}
+	return pgtype.Timestamp{Time: c.RedeemBy, Valid: true}
+}
+
+func numeric(d decimal.Decimal) pgtype.Numeric {
+	return pgtype.Numeric{Int: d.Coefficient(), Exp: d.Exponent(), Valid: true}
+}
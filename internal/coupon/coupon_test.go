@@ -0,0 +1,171 @@
+package coupon
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/shopspring/decimal"
+)
+
+func percentOff(percent string) Coupon {
+	return Coupon{
+		Code:       "LAUNCH20",
+		Name:       "Launch offer",
+		Type:       database.CouponDiscountTypePercentage,
+		PercentOff: decimal.RequireFromString(percent),
+		Duration:   database.CouponDurationOnce,
+		Active:     true,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	fixed := Coupon{
+		Code:      "WELCOME",
+		Name:      "Welcome credit",
+		Type:      database.CouponDiscountTypeFixed,
+		AmountOff: decimal.RequireFromString("5000"),
+		Currency:  "NGN",
+		Duration:  database.CouponDurationForever,
+	}
+	repeating := percentOff("15")
+	repeating.Duration = database.CouponDurationRepeating
+	repeating.DurationMonths = 3
+
+	valid := []Coupon{percentOff("20"), percentOff("100"), percentOff("12.5"), fixed, repeating}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Errorf("Validate(%s %s) error = %v", c.Code, c.Terms(), err)
+		}
+	}
+
+	invalid := map[string]func(c *Coupon){
+		"lowercase code":      func(c *Coupon) { c.Code = "launch20" },
+		"short code":          func(c *Coupon) { c.Code = "AB" },
+		"no name":             func(c *Coupon) { c.Name = " " },
+		"zero percent":        func(c *Coupon) { c.PercentOff = decimal.Zero },
+		"over 100 percent":    func(c *Coupon) { c.PercentOff = decimal.NewFromInt(101) },
+		"fine percent":        func(c *Coupon) { c.PercentOff = decimal.RequireFromString("12.345") },
+		"unknown type":        func(c *Coupon) { c.Type = "bogo" },
+		"months on once":      func(c *Coupon) { c.DurationMonths = 2 },
+		"repeating no months": func(c *Coupon) { c.Duration = database.CouponDurationRepeating },
+		"unknown plan":        func(c *Coupon) { c.Plans = []database.PlanType{"enterprise"} },
+		"duplicate plan":      func(c *Coupon) { c.Plans = []database.PlanType{"pro", "pro"} },
+		"negative limit":      func(c *Coupon) { c.MaxRedemptions = -1 },
+		"fixed no currency": func(c *Coupon) {
+			c.Type = database.CouponDiscountTypeFixed
+			c.AmountOff = decimal.NewFromInt(10)
+		},
+		"fixed fine amount": func(c *Coupon) {
+			c.Type = database.CouponDiscountTypeFixed
+			c.Currency = "JPY"
+			c.AmountOff = decimal.RequireFromString("10.5")
+		},
+	}
+	for name, change := range invalid {
+		c := percentOff("20")
+		change(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("Validate() accepted a coupon with %s", name)
+		}
+	}
+}
+
+func TestRedeemable(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		change func(c *Coupon)
+		want   error
+	}{
+		{"active", func(c *Coupon) {}, nil},
+		{"inactive", func(c *Coupon) { c.Active = false }, ErrInactive},
+		{"before expiry", func(c *Coupon) { c.RedeemBy = now.Add(time.Hour) }, nil},
+		{"expired", func(c *Coupon) { c.RedeemBy = now }, ErrExpired},
+		{"limit left", func(c *Coupon) { c.MaxRedemptions, c.TimesRedeemed = 10, 9 }, nil},
+		{"used up", func(c *Coupon) { c.MaxRedemptions, c.TimesRedeemed = 10, 10 }, ErrUsedUp},
+	}
+
+	for _, tt := range tests {
+		c := percentOff("20")
+		tt.change(&c)
+		if err := c.Redeemable(now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Redeemable() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestAppliesTo(t *testing.T) {
+	c := percentOff("20")
+	if !c.AppliesTo(database.PlanTypeFree) {
+		t.Error("a coupon without plans should apply to every plan")
+	}
+	c.Plans = []database.PlanType{database.PlanTypePro}
+	if c.AppliesTo(database.PlanTypeStarter) || !c.AppliesTo(database.PlanTypePro) {
+		t.Errorf("AppliesTo() ignores the coupon's plans %v", c.Plans)
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	c := percentOff("20")
+	if got := c.Periods(); !got.Valid || got.Int32 != 1 {
+		t.Errorf("once: Periods() = %+v, want 1", got)
+	}
+	c.Duration, c.DurationMonths = database.CouponDurationRepeating, 6
+	if got := c.Periods(); !got.Valid || got.Int32 != 6 {
+		t.Errorf("repeating: Periods() = %+v, want 6", got)
+	}
+	c.Duration, c.DurationMonths = database.CouponDurationForever, 0
+	if got := c.Periods(); got.Valid {
+		t.Errorf("forever: Periods() = %+v, want NULL", got)
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	at := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	rates, err := currency.NewRates(currency.Rate{
+		Base:        "USD",
+		Quote:       "NGN",
+		Rate:        decimal.RequireFromString("1550.255"),
+		EffectiveAt: at.AddDate(0, -1, 0),
+	})
+	if err != nil {
+		t.Fatalf("NewRates() error = %v", err)
+	}
+
+	fixed := func(amount, code string) Coupon {
+		return Coupon{
+			Code:      "WELCOME",
+			Type:      database.CouponDiscountTypeFixed,
+			AmountOff: decimal.RequireFromString(amount),
+			Currency:  code,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		coupon   Coupon
+		subtotal string
+		code     string
+		want     string
+		ok       bool
+	}{
+		{"percentage", percentOff("20"), "29.00", "USD", "5.80", true},
+		{"percentage rounds", percentOff("12.5"), "44957.41", "NGN", "5619.68", true},
+		{"percentage of nothing", percentOff("20"), "0", "USD", "0", true},
+		{"fixed", fixed("10", "USD"), "29.00", "USD", "10", true},
+		{"fixed capped", fixed("50", "USD"), "29.00", "USD", "29.00", true},
+		{"fixed converted", fixed("10", "USD"), "44957.41", "NGN", "15502.55", true},
+		{"fixed without rate", fixed("10", "USD"), "29.00", "KES", "0", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := tt.coupon.Discount(decimal.RequireFromString(tt.subtotal), tt.code, rates, at)
+		if ok != tt.ok || !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("%s: Discount() = %s, %v, want %s, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
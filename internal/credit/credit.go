@@ -0,0 +1,175 @@
+// Package credit keeps organizations' prepaid credit in a double-entry
+// ledger. Every movement of credit is a transaction whose debits and credits
+// add up; an organization's balance is what its wallet account holds.
+package credit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// Entry is one side of a transaction
+type Entry struct {
+	Account   database.CreditAccount
+	Direction database.CreditDirection
+	Amount    decimal.Decimal
+}
+
+// Transaction is a movement of credit in one currency
+type Transaction struct {
+	OrganizationID uuid.UUID
+	Type           database.CreditTransactionType
+	Currency       string
+	Amount         decimal.Decimal
+	Description    string
+	// TopUpID is set on top-ups and BillingCycleID on invoice payments. Each
+	// is posted at most once.
+	TopUpID        uuid.UUID
+	BillingCycleID uuid.UUID
+}
+
+// Entries are the postings of a transaction. A top-up moves money received
+// into the wallet; paying an invoice moves it from the wallet to the
+// invoice.
+func (t Transaction) Entries() ([]Entry, error) {
+	switch t.Type {
+	case database.CreditTransactionTypeTopUp:
+		return []Entry{
+			{Account: database.CreditAccountPayments, Direction: database.CreditDirectionDebit, Amount: t.Amount},
+			{Account: database.CreditAccountWallet, Direction: database.CreditDirectionCredit, Amount: t.Amount},
+		}, nil
+	case database.CreditTransactionTypeInvoicePayment:
+		return []Entry{
+			{Account: database.CreditAccountWallet, Direction: database.CreditDirectionDebit, Amount: t.Amount},
+			{Account: database.CreditAccountReceivables, Direction: database.CreditDirectionCredit, Amount: t.Amount},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown credit transaction type %q", t.Type)
+	}
+}
+
+// Balanced reports whether entries' debits equal their credits
+func Balanced(entries []Entry) bool {
+	sum := decimal.Zero
+	for _, entry := range entries {
+		if entry.Direction == database.CreditDirectionDebit {
+			sum = sum.Add(entry.Amount)
+		} else {
+			sum = sum.Sub(entry.Amount)
+		}
+	}
+	return sum.IsZero()
+}
+
+// Post records t and its entries. Call it with a transaction-bound db: the
+// ledger refuses to commit a transaction whose entries do not balance.
+func Post(ctx context.Context, db *database.Queries, t Transaction) (database.CreditTransaction, error) {
+	if !t.Amount.IsPositive() {
+		return database.CreditTransaction{}, fmt.Errorf("credit transaction amount must be positive, got %s", t.Amount)
+	}
+	entries, err := t.Entries()
+	if err != nil {
+		return database.CreditTransaction{}, err
+	}
+
+	row, err := db.CreateCreditTransaction(ctx, database.CreateCreditTransactionParams{
+		OrganizationID: t.OrganizationID,
+		Type:           t.Type,
+		Currency:       t.Currency,
+		Amount:         Numeric(t.Amount),
+		Description:    t.Description,
+		TopUpID:        optionalUUID(t.TopUpID),
+		BillingCycleID: optionalUUID(t.BillingCycleID),
+	})
+	if err != nil {
+		return database.CreditTransaction{}, fmt.Errorf("failed to create credit transaction: %w", err)
+	}
+
+	for _, entry := range entries {
+		err := db.CreateCreditLedgerEntry(ctx, database.CreateCreditLedgerEntryParams{
+			TransactionID:  row.ID,
+			OrganizationID: t.OrganizationID,
+			Account:        entry.Account,
+			Direction:      entry.Direction,
+			Currency:       t.Currency,
+			Amount:         Numeric(entry.Amount),
+		})
+		if err != nil {
+			return database.CreditTransaction{}, fmt.Errorf("failed to post ledger entry: %w", err)
+		}
+	}
+	return row, nil
+}
+
+// Balance is what the organization's wallet holds in a currency
+func Balance(ctx context.Context, db *database.Queries, orgID uuid.UUID, code string) (decimal.Decimal, error) {
+	balance, err := db.GetCreditBalance(ctx, database.GetCreditBalanceParams{
+		OrganizationID: orgID,
+		Currency:       code,
+	})
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to read credit balance: %w", err)
+	}
+	return Decimal(balance), nil
+}
+
+// Accrued prices the organization's usage so far this period, with the base
+// fee of its plan, in its billing currency: what its next invoice will draw
+// from its credit, before tax
+func Accrued(ctx context.Context, db *database.Queries, catalog *pricing.Catalog, rates *currency.Rates, orgID uuid.UUID, plan database.PlanType, billingCurrency string, now time.Time) (decimal.Decimal, error) {
+	periodStart, periodEnd := quota.CurrentPeriod(now)
+	rows, err := db.GetUsageByMessageType(ctx, database.GetUsageByMessageTypeParams{
+		OrganizationID: orgID,
+		StartTime:      pgtype.Timestamp{Time: periodStart, Valid: true},
+		EndTime:        pgtype.Timestamp{Time: periodEnd, Valid: true},
+	})
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to count usage: %w", err)
+	}
+
+	usage, _ := pricing.UsageFromRows(rows)
+	quote := catalog.Quote(plan, periodStart, usage)
+	rate, ok := rates.RateAt(quote.Currency, billingCurrency, now)
+	if !ok {
+		return decimal.Zero, fmt.Errorf("no %s/%s exchange rate", quote.Currency, billingCurrency)
+	}
+	return currency.Round(rate.Convert(quote.Total), billingCurrency), nil
+}
+
+// Apply is how much of a balance goes towards an amount due: all of it, up
+// to the amount
+func Apply(balance, due decimal.Decimal) decimal.Decimal {
+	if !balance.IsPositive() || !due.IsPositive() {
+		return decimal.Zero
+	}
+	return decimal.Min(balance, due)
+}
+
+// Numeric is an amount as stored
+func Numeric(d decimal.Decimal) pgtype.Numeric {
+	return pgtype.Numeric{Int: d.Coefficient(), Exp: d.Exponent(), Valid: true}
+}
+
+// Decimal converts a stored amount. NULL is zero.
+func Decimal(n pgtype.Numeric) decimal.Decimal {
+	if !n.Valid || n.Int == nil {
+		return decimal.Zero
+	}
+	return decimal.NewFromBigInt(n.Int, n.Exp)
+}
+
+func optionalUUID(id uuid.UUID) pgtype.UUID {
+	if id == uuid.Nil {
+		return pgtype.UUID{}
+	}
+	return pgtype.UUID{Bytes: id, Valid: true}
+}
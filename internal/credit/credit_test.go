@@ -0,0 +1,73 @@
+package credit
+
+import (
+	"testing"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/shopspring/decimal"
+)
+
+func TestEntriesBalance(t *testing.T) {
+	amount := decimal.RequireFromString("5000.00")
+	for _, txType := range []database.CreditTransactionType{
+		database.CreditTransactionTypeTopUp,
+		database.CreditTransactionTypeInvoicePayment,
+	} {
+		entries, err := Transaction{Type: txType, Currency: "NGN", Amount: amount}.Entries()
+		if err != nil {
+			t.Fatalf("Entries(%s) error = %v", txType, err)
+		}
+		if !Balanced(entries) {
+			t.Errorf("Entries(%s) = %+v, do not balance", txType, entries)
+		}
+	}
+
+	if _, err := (Transaction{Type: "refund", Amount: amount}).Entries(); err == nil {
+		t.Error("Entries() accepted an unknown transaction type")
+	}
+}
+
+func TestEntriesMoveTheWallet(t *testing.T) {
+	amount := decimal.NewFromInt(10)
+	wallet := func(txType database.CreditTransactionType) decimal.Decimal {
+		entries, _ := Transaction{Type: txType, Amount: amount}.Entries()
+		balance := decimal.Zero
+		for _, entry := range entries {
+			if entry.Account != database.CreditAccountWallet {
+				continue
+			}
+			if entry.Direction == database.CreditDirectionCredit {
+				balance = balance.Add(entry.Amount)
+			} else {
+				balance = balance.Sub(entry.Amount)
+			}
+		}
+		return balance
+	}
+
+	if got := wallet(database.CreditTransactionTypeTopUp); !got.Equal(amount) {
+		t.Errorf("top-up moves the wallet by %s, want +%s", got, amount)
+	}
+	if got := wallet(database.CreditTransactionTypeInvoicePayment); !got.Equal(amount.Neg()) {
+		t.Errorf("invoice payment moves the wallet by %s, want -%s", got, amount)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		balance, due, want string
+	}{
+		{"100", "40", "40"},
+		{"25.50", "40", "25.5"},
+		{"0", "40", "0"},
+		{"-5", "40", "0"},
+		{"100", "0", "0"},
+	}
+
+	for _, tt := range tests {
+		got := Apply(decimal.RequireFromString(tt.balance), decimal.RequireFromString(tt.due))
+		if !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("Apply(%s, %s) = %s, want %s", tt.balance, tt.due, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package currency knows the currencies invoices are issued in and converts
+// prices between them at published exchange rates
+package currency
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// Default is the currency of organizations that have not chosen one
+const Default = "USD"
+
+// exponents are the number of minor units of each supported currency, from
+// ISO 4217: 2 means an amount is charged in cents, 0 in whole units
+var exponents = map[string]int32{
+	"AUD": 2,
+	"CAD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"GHS": 2,
+	"JPY": 0,
+	"KES": 2,
+	"NGN": 2,
+	"USD": 2,
+	"ZAR": 2,
+}
+
+// symbols are how amounts are written in emails
+var symbols = map[string]string{
+	"AUD": "A$",
+	"CAD": "CA$",
+	"EUR": "€",
+	"GBP": "£",
+	"GHS": "GH₵",
+	"JPY": "¥",
+	"KES": "KSh ",
+	"NGN": "₦",
+	"USD": "$",
+	"ZAR": "R",
+}
+
+// Supported lists the supported currency codes in order
+func Supported() []string {
+	codes := make([]string, 0, len(exponents))
+	for code := range exponents {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	return codes
+}
+
+// Validate checks code is a supported ISO 4217 currency code
+func Validate(code string) error {
+	if _, ok := exponents[code]; !ok {
+		return fmt.Errorf("unsupported currency %q, must be one of %s", code, strings.Join(Supported(), ", "))
+	}
+	return nil
+}
+
+// Exponent is the number of decimals of a currency's minor unit. Unknown
+// currencies are treated as having cents.
+func Exponent(code string) int32 {
+	if exponent, ok := exponents[code]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// Round rounds an amount to the currency's minor unit
+func Round(amount decimal.Decimal, code string) decimal.Decimal {
+	return amount.Round(Exponent(code))
+}
+
+// ToMinorUnits converts an amount to the integer number of minor units
+// payment providers charge in, such as cents or kobo
+func ToMinorUnits(amount decimal.Decimal, code string) (int64, error) {
+	minor := Round(amount, code).Shift(Exponent(code))
+	if minor.IsNegative() {
+		return 0, fmt.Errorf("negative amount %s %s", amount, code)
+	}
+	if minor.GreaterThan(decimal.NewFromInt(math.MaxInt64)) {
+		return 0, fmt.Errorf("amount %s %s is too large", amount, code)
+	}
+	return minor.IntPart(), nil
+}
+
+// FromNumeric reads an amount stored as NUMERIC, zero when it is NULL
+func FromNumeric(n pgtype.Numeric) decimal.Decimal {
+	if !n.Valid || n.Int == nil {
+		return decimal.Zero
+	}
+	return decimal.NewFromBigInt(n.Int, n.Exp)
+}
+
+// Format writes an amount with its currency's symbol and minor unit, e.g.
+// ₦44,957.25 or ¥1,200
+func Format(amount decimal.Decimal, code string) string {
+	if code == "" {
+		code = Default
+	}
+	symbol, ok := symbols[code]
+	if !ok {
+		symbol = code + " "
+	}
+
+	sign := ""
+	if amount.IsNegative() {
+		sign = "-"
+		amount = amount.Neg()
+	}
+
+	whole, fraction, _ := strings.Cut(amount.StringFixed(Exponent(code)), ".")
+	s := sign + symbol + groupThousands(whole)
+	if fraction != "" {
+		s += "." + fraction
+	}
+	return s
+}
+
+func groupThousands(digits string) string {
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return b.String()
+}
@@ -0,0 +1,162 @@
+package currency
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+func TestToMinorUnits(t *testing.T) {
+	tests := []struct {
+		amount string
+		code   string
+		want   int64
+	}{
+		{"104.00", "USD", 10400},
+		{"0.1", "USD", 10},
+		{"19.999", "EUR", 2000},
+		{"44957.25", "NGN", 4495725},
+		{"1200", "JPY", 1200},
+		{"1200.5", "JPY", 1201},
+		{"0", "GBP", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.amount+" "+tt.code, func(t *testing.T) {
+			got, err := ToMinorUnits(decimal.RequireFromString(tt.amount), tt.code)
+			if err != nil {
+				t.Fatalf("ToMinorUnits() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ToMinorUnits() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ToMinorUnits(decimal.NewFromInt(-1), "USD"); err == nil {
+		t.Error("ToMinorUnits() accepted a negative amount")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount string
+		code   string
+		want   string
+	}{
+		{"1234.5", "USD", "$1,234.50"},
+		{"44957.25", "NGN", "₦44,957.25"},
+		{"1200", "JPY", "¥1,200"},
+		{"-3", "EUR", "-€3.00"},
+		{"10", "", "$10.00"},
+		{"10", "CHF", "CHF 10.00"},
+	}
+
+	for _, tt := range tests {
+		if got := Format(decimal.RequireFromString(tt.amount), tt.code); got != tt.want {
+			t.Errorf("Format(%s, %q) = %q, want %q", tt.amount, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestFromNumeric(t *testing.T) {
+	var n pgtype.Numeric
+	if err := n.Scan("1234.56"); err != nil {
+		t.Fatal(err)
+	}
+	if got := FromNumeric(n); !got.Equal(decimal.RequireFromString("1234.56")) {
+		t.Errorf("FromNumeric(1234.56) = %s", got)
+	}
+	if got := FromNumeric(pgtype.Numeric{}); !got.IsZero() {
+		t.Errorf("FromNumeric(NULL) = %s, want 0", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, code := range []string{"USD", "EUR", "NGN", "JPY"} {
+		if err := Validate(code); err != nil {
+			t.Errorf("Validate(%q) = %v", code, err)
+		}
+	}
+	for _, code := range []string{"", "usd", "XXX"} {
+		if err := Validate(code); err == nil {
+			t.Errorf("Validate(%q) accepted an unsupported currency", code)
+		}
+	}
+}
+
+func TestRateAt(t *testing.T) {
+	october := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	november := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	rates, err := NewRates(
+		Rate{Base: "USD", Quote: "NGN", Rate: decimal.RequireFromString("1500"), EffectiveAt: october},
+		Rate{Base: "USD", Quote: "NGN", Rate: decimal.RequireFromString("1550.25"), EffectiveAt: november},
+		Rate{Base: "EUR", Quote: "USD", Rate: decimal.RequireFromString("1.25"), EffectiveAt: october},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		base, quote string
+		at          time.Time
+		want        string
+		ok          bool
+	}{
+		{"Same currency", "USD", "USD", october, "1", true},
+		{"Before any rate", "USD", "NGN", october.Add(-time.Hour), "", false},
+		{"Published rate", "USD", "NGN", october.Add(time.Hour), "1500", true},
+		{"Newer rate", "USD", "NGN", november, "1550.25", true},
+		{"Inverse", "USD", "EUR", october, "0.8", true},
+		{"Unknown pair", "USD", "GBP", october, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, ok := rates.RateAt(tt.base, tt.quote, tt.at)
+			if ok != tt.ok {
+				t.Fatalf("RateAt() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !rate.Rate.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("RateAt() = %s, want %s", rate.Rate, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRatesValidates(t *testing.T) {
+	now := time.Now()
+	invalid := []Rate{
+		{Base: "USD", Quote: "USD", Rate: decimal.NewFromInt(1), EffectiveAt: now},
+		{Base: "USD", Quote: "NGN", Rate: decimal.Zero, EffectiveAt: now},
+		{Base: "USD", Quote: "XXX", Rate: decimal.NewFromInt(1), EffectiveAt: now},
+		{Base: "USD", Quote: "NGN", Rate: decimal.NewFromInt(1)},
+	}
+	for _, rate := range invalid {
+		if _, err := NewRates(rate); err == nil {
+			t.Errorf("NewRates(%+v) accepted an invalid rate", rate)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fx.json")
+	data := `[{"base": "USD", "quote": "NGN", "rate": "1550.25", "effective_at": "2026-10-01T00:00:00Z"}]`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rates, err := Load(t.Context(), nil, path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	rate, ok := rates.RateAt("USD", "NGN", time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC))
+	if !ok || !rate.Rate.Equal(decimal.RequireFromString("1550.25")) || rate.Source != SourceFile {
+		t.Errorf("RateAt() = %+v, %v", rate, ok)
+	}
+}
@@ -0,0 +1,207 @@
+package currency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// Sources of published rates
+const (
+	SourceFile  = "file"
+	SourceAdmin = "admin"
+)
+
+// inversePlaces is how precisely a rate is inverted when only the opposite
+// pair is published, matching the precision rates are stored with
+const inversePlaces = 10
+
+// Rate is how many units of Quote one unit of Base buys from EffectiveAt on
+type Rate struct {
+	Base        string          `json:"base"`
+	Quote       string          `json:"quote"`
+	Rate        decimal.Decimal `json:"rate"`
+	EffectiveAt time.Time       `json:"effective_at"`
+	Source      string          `json:"source,omitempty"`
+}
+
+// Validate checks a rate can be published
+func (r Rate) Validate() error {
+	if err := Validate(r.Base); err != nil {
+		return err
+	}
+	if err := Validate(r.Quote); err != nil {
+		return err
+	}
+	if r.Base == r.Quote {
+		return fmt.Errorf("a rate needs two different currencies")
+	}
+	if !r.Rate.IsPositive() {
+		return fmt.Errorf("rate %s/%s must be greater than zero", r.Base, r.Quote)
+	}
+	if r.EffectiveAt.IsZero() {
+		return fmt.Errorf("rate %s/%s has no effective time", r.Base, r.Quote)
+	}
+	return nil
+}
+
+// Convert prices amount, in Base, in Quote. It is not rounded.
+func (r Rate) Convert(amount decimal.Decimal) decimal.Decimal {
+	return amount.Mul(r.Rate)
+}
+
+// Numeric is the rate as stored
+func (r Rate) Numeric() pgtype.Numeric {
+	return pgtype.Numeric{Int: r.Rate.Coefficient(), Exp: r.Rate.Exponent(), Valid: true}
+}
+
+// RateFromRow converts a stored rate
+func RateFromRow(row database.FxRate) Rate {
+	rate := decimal.Zero
+	if row.Rate.Valid && row.Rate.Int != nil {
+		rate = decimal.NewFromBigInt(row.Rate.Int, row.Rate.Exp)
+	}
+	return Rate{
+		Base:        row.BaseCurrency,
+		Quote:       row.QuoteCurrency,
+		Rate:        rate,
+		EffectiveAt: row.EffectiveAt.Time,
+		Source:      row.Source,
+	}
+}
+
+type pair struct {
+	base, quote string
+}
+
+// Rates holds every published rate by currency pair and effective time
+type Rates struct {
+	pairs map[pair][]Rate
+}
+
+// NewRates validates the rates and orders each pair's by effective time.
+// Times are normalised to UTC so they compare with billing periods.
+func NewRates(rates ...Rate) (*Rates, error) {
+	r := &Rates{pairs: map[pair][]Rate{}}
+	for _, rate := range rates {
+		if err := rate.Validate(); err != nil {
+			return nil, err
+		}
+		rate.EffectiveAt = rate.EffectiveAt.UTC()
+		key := pair{rate.Base, rate.Quote}
+		r.pairs[key] = append(r.pairs[key], rate)
+	}
+	for _, list := range r.pairs {
+		slices.SortFunc(list, func(a, b Rate) int { return a.EffectiveAt.Compare(b.EffectiveAt) })
+	}
+	return r, nil
+}
+
+// All lists every published rate ordered by pair and effective time
+func (r *Rates) All() []Rate {
+	all := []Rate{}
+	for _, list := range r.pairs {
+		all = append(all, list...)
+	}
+	slices.SortFunc(all, func(a, b Rate) int {
+		if c := strings.Compare(a.Base+a.Quote, b.Base+b.Quote); c != 0 {
+			return c
+		}
+		return a.EffectiveAt.Compare(b.EffectiveAt)
+	})
+	return all
+}
+
+// RateAt returns the rate converting base to quote at t. A pair is quoted
+// either way round: when only quote/base is published its inverse is used,
+// and when both are the more recent one wins. Converting a currency to
+// itself is always at 1.
+func (r *Rates) RateAt(base, quote string, at time.Time) (Rate, bool) {
+	if base == quote {
+		return Rate{Base: base, Quote: quote, Rate: decimal.NewFromInt(1)}, true
+	}
+
+	direct, hasDirect := latest(r.pairs[pair{base, quote}], at)
+	inverse, hasInverse := latest(r.pairs[pair{quote, base}], at)
+	switch {
+	case hasInverse && (!hasDirect || inverse.EffectiveAt.After(direct.EffectiveAt)):
+		return Rate{
+			Base:        base,
+			Quote:       quote,
+			Rate:        decimal.NewFromInt(1).DivRound(inverse.Rate, inversePlaces),
+			EffectiveAt: inverse.EffectiveAt,
+			Source:      inverse.Source,
+		}, true
+	case hasDirect:
+		return direct, true
+	default:
+		return Rate{}, false
+	}
+}
+
+func latest(list []Rate, at time.Time) (Rate, bool) {
+	for i := len(list) - 1; i >= 0; i-- {
+		if !list[i].EffectiveAt.After(at) {
+			return list[i], true
+		}
+	}
+	return Rate{}, false
+}
+
+// RateStore reads published rates. *database.Queries satisfies it.
+type RateStore interface {
+	ListFXRates(ctx context.Context) ([]database.FxRate, error)
+}
+
+// Load builds the rates from the JSON file at path (if any) and the rates
+// published in the database
+func Load(ctx context.Context, store RateStore, path string) (*Rates, error) {
+	rates := []Rate{}
+
+	if path != "" {
+		fromFile, err := LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, fromFile...)
+	}
+
+	if store != nil {
+		rows, err := store.ListFXRates(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list exchange rates: %w", err)
+		}
+		for _, row := range rows {
+			rates = append(rates, RateFromRow(row))
+		}
+	}
+
+	return NewRates(rates...)
+}
+
+// LoadFile reads a JSON array of rates
+func LoadFile(path string) ([]Rate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exchange rate file: %w", err)
+	}
+
+	var rates []Rate
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return nil, fmt.Errorf("invalid exchange rate file %s: %w", path, err)
+	}
+	for i := range rates {
+		if rates[i].Source == "" {
+			rates[i].Source = SourceFile
+		}
+	}
+	return rates, nil
+}
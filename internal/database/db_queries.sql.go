@@ -37,11 +37,45 @@ func (q *Queries) AcceptTeamInvitation(ctx context.Context, id uuid.UUID) (TeamI
 	return i, err
 }
 
+const acknowledgeUsageAlert = `-- name: AcknowledgeUsageAlert :one
+UPDATE usage_alerts
+SET status = 'acknowledged', acknowledged_at = NOW(), acknowledged_by = $3
+WHERE id = $1 AND organization_id = $2
+RETURNING id, organization_id, metric, direction, window_start, window_end, observed, expected, score, status, created_at, acknowledged_at, acknowledged_by
+`
+
+type AcknowledgeUsageAlertParams struct {
+	ID             uuid.UUID   `json:"id"`
+	OrganizationID uuid.UUID   `json:"organization_id"`
+	AcknowledgedBy pgtype.UUID `json:"acknowledged_by"`
+}
+
+func (q *Queries) AcknowledgeUsageAlert(ctx context.Context, arg AcknowledgeUsageAlertParams) (UsageAlert, error) {
+	row := q.db.QueryRow(ctx, acknowledgeUsageAlert, arg.ID, arg.OrganizationID, arg.AcknowledgedBy)
+	var i UsageAlert
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Metric,
+		&i.Direction,
+		&i.WindowStart,
+		&i.WindowEnd,
+		&i.Observed,
+		&i.Expected,
+		&i.Score,
+		&i.Status,
+		&i.CreatedAt,
+		&i.AcknowledgedAt,
+		&i.AcknowledgedBy,
+	)
+	return i, err
+}
+
 const activateAPIKey = `-- name: ActivateAPIKey :one
 UPDATE api_keys
 SET is_active = true
 WHERE id = $1
-RETURNING id, organization_id, key, name, is_active, created_at, last_used_at
+RETURNING id, organization_id, key, name, is_active, created_at, last_used_at, essential, suspended_at
 `
 
 func (q *Queries) ActivateAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error) {
@@ -55,6 +89,35 @@ func (q *Queries) ActivateAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, err
 		&i.IsActive,
 		&i.CreatedAt,
 		&i.LastUsedAt,
+		&i.Essential,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const applyPlanChange = `-- name: ApplyPlanChange :one
+
+UPDATE scheduled_plan_changes
+SET status = 'applied', applied_at = NOW()
+WHERE id = $1 AND status = 'pending'
+RETURNING id, organization_id, from_plan, to_plan, effective_at, status, requested_by, created_at, applied_at, cancelled_at
+`
+
+// Only a pending change is applied, so a change cancelled meanwhile is not
+func (q *Queries) ApplyPlanChange(ctx context.Context, id uuid.UUID) (ScheduledPlanChange, error) {
+	row := q.db.QueryRow(ctx, applyPlanChange, id)
+	var i ScheduledPlanChange
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.FromPlan,
+		&i.ToPlan,
+		&i.EffectiveAt,
+		&i.Status,
+		&i.RequestedBy,
+		&i.CreatedAt,
+		&i.AppliedAt,
+		&i.CancelledAt,
 	)
 	return i, err
 }
@@ -89,21 +152,241 @@ func (q *Queries) CancelInvitation(ctx context.Context, arg CancelInvitationPara
 	return i, err
 }
 
+const cancelPendingPlanChange = `-- name: CancelPendingPlanChange :execrows
+UPDATE scheduled_plan_changes
+SET status = 'cancelled', cancelled_at = NOW()
+WHERE organization_id = $1 AND status = 'pending'
+`
+
+func (q *Queries) CancelPendingPlanChange(ctx context.Context, organizationID uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, cancelPendingPlanChange, organizationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const claimCoupon = `-- name: ClaimCoupon :one
+
+UPDATE coupons
+SET times_redeemed = times_redeemed + 1,
+    updated_at = NOW()
+WHERE id = $1
+  AND active
+  AND (redeem_by IS NULL OR redeem_by > NOW())
+  AND (max_redemptions IS NULL OR times_redeemed < max_redemptions)
+RETURNING id, code, name, discount_type, percent_off, amount_off, currency, duration, duration_months, plans, max_redemptions, times_redeemed, redeem_by, active, created_at, updated_at
+`
+
+// Counts a redemption if the coupon can still be redeemed. Concurrent
+// redemptions queue on the row, so the limit is never exceeded.
+func (q *Queries) ClaimCoupon(ctx context.Context, id uuid.UUID) (Coupon, error) {
+	row := q.db.QueryRow(ctx, claimCoupon, id)
+	var i Coupon
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Name,
+		&i.DiscountType,
+		&i.PercentOff,
+		&i.AmountOff,
+		&i.Currency,
+		&i.Duration,
+		&i.DurationMonths,
+		&i.Plans,
+		&i.MaxRedemptions,
+		&i.TimesRedeemed,
+		&i.RedeemBy,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const claimUsageExport = `-- name: ClaimUsageExport :one
+UPDATE usage_exports
+SET status = 'running',
+    started_at = NOW()
+WHERE id = (
+    SELECT id FROM usage_exports
+    WHERE status = 'pending'
+       OR (status = 'running' AND started_at < $1)
+    ORDER BY created_at
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, organization_id, requested_by, format, start_time, end_time, status, row_count, file_size, file_path, error, created_at, started_at, completed_at, expires_at
+`
+
+func (q *Queries) ClaimUsageExport(ctx context.Context, staleBefore pgtype.Timestamp) (UsageExport, error) {
+	row := q.db.QueryRow(ctx, claimUsageExport, staleBefore)
+	var i UsageExport
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.RequestedBy,
+		&i.Format,
+		&i.StartTime,
+		&i.EndTime,
+		&i.Status,
+		&i.RowCount,
+		&i.FileSize,
+		&i.FilePath,
+		&i.Error,
+		&i.CreatedAt,
+		&i.StartedAt,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const clearCreditWalletLowBalanceNotice = `-- name: ClearCreditWalletLowBalanceNotice :exec
+UPDATE credit_wallets
+SET low_balance_notified_at = NULL
+WHERE organization_id = $1 AND low_balance_notified_at IS NOT NULL
+`
+
+func (q *Queries) ClearCreditWalletLowBalanceNotice(ctx context.Context, organizationID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, clearCreditWalletLowBalanceNotice, organizationID)
+	return err
+}
+
+const completeCreditTopUp = `-- name: CompleteCreditTopUp :one
+
+UPDATE credit_top_ups
+SET status = 'completed', completed_at = NOW()
+WHERE id = $1 AND status = 'pending'
+RETURNING id, organization_id, provider, currency, amount, status, created_by, created_at, completed_at
+`
+
+// Only a pending top-up completes, so a repeated webhook finds no row
+func (q *Queries) CompleteCreditTopUp(ctx context.Context, id uuid.UUID) (CreditTopUp, error) {
+	row := q.db.QueryRow(ctx, completeCreditTopUp, id)
+	var i CreditTopUp
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Provider,
+		&i.Currency,
+		&i.Amount,
+		&i.Status,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
+const completeUsageExport = `-- name: CompleteUsageExport :exec
+UPDATE usage_exports
+SET status = 'completed',
+    row_count = $2,
+    file_size = $3,
+    file_path = $4,
+    expires_at = $5,
+    completed_at = NOW()
+WHERE id = $1
+`
+
+type CompleteUsageExportParams struct {
+	ID        uuid.UUID        `json:"id"`
+	RowCount  int64            `json:"row_count"`
+	FileSize  int64            `json:"file_size"`
+	FilePath  *string          `json:"file_path"`
+	ExpiresAt pgtype.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) CompleteUsageExport(ctx context.Context, arg CompleteUsageExportParams) error {
+	_, err := q.db.Exec(ctx, completeUsageExport,
+		arg.ID,
+		arg.RowCount,
+		arg.FileSize,
+		arg.FilePath,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
 const countOrganizationUsage = `-- name: CountOrganizationUsage :one
-SELECT COUNT(*) FROM usage_records
-WHERE organization_id = $1
-    AND created_at >= $2
-    AND created_at <= $3
+
+WITH bounds AS (
+    SELECT
+        date_trunc('hour', $1::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', $2::timestamp + interval '1 microsecond'), rolled_up_to) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), raw_ranges AS (
+    SELECT $1::timestamp AS raw_from,
+        LEAST(hours_from, $2::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), $2::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT h.request_count
+    FROM usage_rollups_hourly h, bounds b
+    WHERE h.organization_id = $3
+        AND h.bucket >= b.hours_from
+        AND h.bucket < b.hours_until
+    UNION ALL
+    SELECT 1::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = $3
+)
+SELECT COALESCE(SUM(request_count), 0)::bigint AS count FROM usage
 `
 
 type CountOrganizationUsageParams struct {
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
 	OrganizationID uuid.UUID        `json:"organization_id"`
-	CreatedAt      pgtype.Timestamp `json:"created_at"`
-	CreatedAt_2    pgtype.Timestamp `json:"created_at_2"`
 }
 
+// Usage queries read the whole hours of the range that are rolled up from the
+// hourly rollups, and the partial hours at either end and the hours not yet
+// rolled up from usage_records
 func (q *Queries) CountOrganizationUsage(ctx context.Context, arg CountOrganizationUsageParams) (int64, error) {
-	row := q.db.QueryRow(ctx, countOrganizationUsage, arg.OrganizationID, arg.CreatedAt, arg.CreatedAt_2)
+	row := q.db.QueryRow(ctx, countOrganizationUsage, arg.StartTime, arg.EndTime, arg.OrganizationID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countQueuedUsageExports = `-- name: CountQueuedUsageExports :one
+SELECT COUNT(*) FROM usage_exports
+WHERE organization_id = $1 AND status IN ('pending', 'running')
+`
+
+func (q *Queries) CountQueuedUsageExports(ctx context.Context, organizationID uuid.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countQueuedUsageExports, organizationID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countVoidUpgradeInvoices = `-- name: CountVoidUpgradeInvoices :one
+
+SELECT COUNT(*) FROM billing_cycles
+WHERE organization_id = $1
+    AND kind = 'proration'
+    AND status = 'void'
+    AND quote->>'plan' = $2::text
+    AND period_end = $3
+`
+
+type CountVoidUpgradeInvoicesParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Plan           string           `json:"plan"`
+	PeriodEnd      pgtype.Timestamp `json:"period_end"`
+}
+
+// How many upgrades to plan in the period ending at period_end had their
+// charge declined
+func (q *Queries) CountVoidUpgradeInvoices(ctx context.Context, arg CountVoidUpgradeInvoicesParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countVoidUpgradeInvoices, arg.OrganizationID, arg.Plan, arg.PeriodEnd)
 	var count int64
 	err := row.Scan(&count)
 	return count, err
@@ -111,9 +394,9 @@ func (q *Queries) CountOrganizationUsage(ctx context.Context, arg CountOrganizat
 
 const createAPIKey = `-- name: CreateAPIKey :one
 
-INSERT INTO api_keys (organization_id, key, name, is_active)
-VALUES ($1, $2, $3, $4)
-RETURNING id, organization_id, key, name, is_active, created_at, last_used_at
+INSERT INTO api_keys (organization_id, key, name, is_active, essential)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, organization_id, key, name, is_active, created_at, last_used_at, essential, suspended_at
 `
 
 type CreateAPIKeyParams struct {
@@ -121,6 +404,7 @@ type CreateAPIKeyParams struct {
 	Key            string    `json:"key"`
 	Name           string    `json:"name"`
 	IsActive       bool      `json:"is_active"`
+	Essential      bool      `json:"essential"`
 }
 
 // ============================================
@@ -132,6 +416,7 @@ func (q *Queries) CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (Api
 		arg.Key,
 		arg.Name,
 		arg.IsActive,
+		arg.Essential,
 	)
 	var i ApiKey
 	err := row.Scan(
@@ -142,6 +427,8 @@ func (q *Queries) CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (Api
 		&i.IsActive,
 		&i.CreatedAt,
 		&i.LastUsedAt,
+		&i.Essential,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
@@ -187,24 +474,41 @@ INSERT INTO billing_cycles (
     period_end,
     total_requests,
     total_amount,
-    status
+    status,
+    price_book_version,
+    quote,
+    invoice_number,
+    currency,
+    fx_rate,
+    fx_rate_at,
+    kind
 )
-VALUES ($1, $2, $3, $4, $5, $6)
-RETURNING id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT (organization_id, period_start, kind) DO NOTHING
+RETURNING id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at, price_book_version, quote, paid_at, invoice_number, currency, fx_rate, fx_rate_at, kind
 `
 
 type CreateBillingCycleParams struct {
-	OrganizationID uuid.UUID        `json:"organization_id"`
-	PeriodStart    pgtype.Timestamp `json:"period_start"`
-	PeriodEnd      pgtype.Timestamp `json:"period_end"`
-	TotalRequests  int32            `json:"total_requests"`
-	TotalAmount    pgtype.Numeric   `json:"total_amount"`
-	Status         BillingStatus    `json:"status"`
+	OrganizationID   uuid.UUID        `json:"organization_id"`
+	PeriodStart      pgtype.Timestamp `json:"period_start"`
+	PeriodEnd        pgtype.Timestamp `json:"period_end"`
+	TotalRequests    int32            `json:"total_requests"`
+	TotalAmount      pgtype.Numeric   `json:"total_amount"`
+	Status           BillingStatus    `json:"status"`
+	PriceBookVersion *string          `json:"price_book_version"`
+	Quote            []byte           `json:"quote"`
+	InvoiceNumber    string           `json:"invoice_number"`
+	Currency         string           `json:"currency"`
+	FxRate           pgtype.Numeric   `json:"fx_rate"`
+	FxRateAt         pgtype.Timestamp `json:"fx_rate_at"`
+	Kind             BillingCycleKind `json:"kind"`
 }
 
 // ============================================
 // BILLING CYCLE QUERIES
 // ============================================
+// Returns no row if the organization already has a cycle of this kind for
+// the period
 func (q *Queries) CreateBillingCycle(ctx context.Context, arg CreateBillingCycleParams) (BillingCycle, error) {
 	row := q.db.QueryRow(ctx, createBillingCycle,
 		arg.OrganizationID,
@@ -213,6 +517,13 @@ func (q *Queries) CreateBillingCycle(ctx context.Context, arg CreateBillingCycle
 		arg.TotalRequests,
 		arg.TotalAmount,
 		arg.Status,
+		arg.PriceBookVersion,
+		arg.Quote,
+		arg.InvoiceNumber,
+		arg.Currency,
+		arg.FxRate,
+		arg.FxRateAt,
+		arg.Kind,
 	)
 	var i BillingCycle
 	err := row.Scan(
@@ -224,513 +535,3369 @@ func (q *Queries) CreateBillingCycle(ctx context.Context, arg CreateBillingCycle
 		&i.TotalAmount,
 		&i.Status,
 		&i.CreatedAt,
+		&i.PriceBookVersion,
+		&i.Quote,
+		&i.PaidAt,
+		&i.InvoiceNumber,
+		&i.Currency,
+		&i.FxRate,
+		&i.FxRateAt,
+		&i.Kind,
 	)
 	return i, err
 }
 
-const createOrganization = `-- name: CreateOrganization :one
-INSERT INTO organizations (name, email, plan)
-VALUES ($1, $2, $3)
-RETURNING id, name, email, plan, created_at, updated_at
+const createCoupon = `-- name: CreateCoupon :one
+
+INSERT INTO coupons (
+    code,
+    name,
+    discount_type,
+    percent_off,
+    amount_off,
+    currency,
+    duration,
+    duration_months,
+    plans,
+    max_redemptions,
+    redeem_by
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+RETURNING id, code, name, discount_type, percent_off, amount_off, currency, duration, duration_months, plans, max_redemptions, times_redeemed, redeem_by, active, created_at, updated_at
 `
 
-type CreateOrganizationParams struct {
-	Name  string   `json:"name"`
-	Email string   `json:"email"`
-	Plan  PlanType `json:"plan"`
+type CreateCouponParams struct {
+	Code           string             `json:"code"`
+	Name           string             `json:"name"`
+	DiscountType   CouponDiscountType `json:"discount_type"`
+	PercentOff     pgtype.Numeric     `json:"percent_off"`
+	AmountOff      pgtype.Numeric     `json:"amount_off"`
+	Currency       pgtype.Text        `json:"currency"`
+	Duration       CouponDuration     `json:"duration"`
+	DurationMonths pgtype.Int4        `json:"duration_months"`
+	Plans          []string           `json:"plans"`
+	MaxRedemptions pgtype.Int4        `json:"max_redemptions"`
+	RedeemBy       pgtype.Timestamp   `json:"redeem_by"`
 }
 
-func (q *Queries) CreateOrganization(ctx context.Context, arg CreateOrganizationParams) (Organization, error) {
-	row := q.db.QueryRow(ctx, createOrganization, arg.Name, arg.Email, arg.Plan)
-	var i Organization
+// ============================================
+// COUPON QUERIES
+// ============================================
+func (q *Queries) CreateCoupon(ctx context.Context, arg CreateCouponParams) (Coupon, error) {
+	row := q.db.QueryRow(ctx, createCoupon,
+		arg.Code,
+		arg.Name,
+		arg.DiscountType,
+		arg.PercentOff,
+		arg.AmountOff,
+		arg.Currency,
+		arg.Duration,
+		arg.DurationMonths,
+		arg.Plans,
+		arg.MaxRedemptions,
+		arg.RedeemBy,
+	)
+	var i Coupon
 	err := row.Scan(
 		&i.ID,
+		&i.Code,
 		&i.Name,
-		&i.Email,
-		&i.Plan,
+		&i.DiscountType,
+		&i.PercentOff,
+		&i.AmountOff,
+		&i.Currency,
+		&i.Duration,
+		&i.DurationMonths,
+		&i.Plans,
+		&i.MaxRedemptions,
+		&i.TimesRedeemed,
+		&i.RedeemBy,
+		&i.Active,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const createTeamInvitation = `-- name: CreateTeamInvitation :one
-INSERT INTO team_invitations (organization_id, email, role, invited_by, token, expires_at)
-VALUES ($1, $2, $3, $4, $5, $6)
-RETURNING id, organization_id, email, role, invited_by, token, expires_at, accepted_at, declined_at, created_at
+const createCouponRedemption = `-- name: CreateCouponRedemption :one
+INSERT INTO coupon_redemptions (coupon_id, organization_id, redeemed_by, periods_remaining)
+VALUES ($1, $2, $3, $4)
+RETURNING id, coupon_id, organization_id, redeemed_by, periods_remaining, redeemed_at, ended_at
 `
 
-type CreateTeamInvitationParams struct {
-	OrganizationID uuid.UUID        `json:"organization_id"`
-	Email          string           `json:"email"`
-	Role           UserRole         `json:"role"`
-	InvitedBy      uuid.UUID        `json:"invited_by"`
-	Token          string           `json:"token"`
-	ExpiresAt      pgtype.Timestamp `json:"expires_at"`
+type CreateCouponRedemptionParams struct {
+	CouponID         uuid.UUID   `json:"coupon_id"`
+	OrganizationID   uuid.UUID   `json:"organization_id"`
+	RedeemedBy       pgtype.UUID `json:"redeemed_by"`
+	PeriodsRemaining pgtype.Int4 `json:"periods_remaining"`
 }
 
-func (q *Queries) CreateTeamInvitation(ctx context.Context, arg CreateTeamInvitationParams) (TeamInvitation, error) {
-	row := q.db.QueryRow(ctx, createTeamInvitation,
+func (q *Queries) CreateCouponRedemption(ctx context.Context, arg CreateCouponRedemptionParams) (CouponRedemption, error) {
+	row := q.db.QueryRow(ctx, createCouponRedemption,
+		arg.CouponID,
 		arg.OrganizationID,
-		arg.Email,
-		arg.Role,
-		arg.InvitedBy,
-		arg.Token,
-		arg.ExpiresAt,
+		arg.RedeemedBy,
+		arg.PeriodsRemaining,
 	)
-	var i TeamInvitation
+	var i CouponRedemption
 	err := row.Scan(
 		&i.ID,
+		&i.CouponID,
 		&i.OrganizationID,
-		&i.Email,
-		&i.Role,
-		&i.InvitedBy,
-		&i.Token,
-		&i.ExpiresAt,
-		&i.AcceptedAt,
-		&i.DeclinedAt,
-		&i.CreatedAt,
+		&i.RedeemedBy,
+		&i.PeriodsRemaining,
+		&i.RedeemedAt,
+		&i.EndedAt,
 	)
 	return i, err
 }
 
-const createUsageRecord = `-- name: CreateUsageRecord :one
+const createCreditLedgerEntry = `-- name: CreateCreditLedgerEntry :exec
+INSERT INTO credit_ledger_entries (transaction_id, organization_id, account, direction, currency, amount)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
 
-INSERT INTO usage_records (organization_id, api_key_id, endpoint, method, status_code)
+type CreateCreditLedgerEntryParams struct {
+	TransactionID  uuid.UUID       `json:"transaction_id"`
+	OrganizationID uuid.UUID       `json:"organization_id"`
+	Account        CreditAccount   `json:"account"`
+	Direction      CreditDirection `json:"direction"`
+	Currency       string          `json:"currency"`
+	Amount         pgtype.Numeric  `json:"amount"`
+}
+
+func (q *Queries) CreateCreditLedgerEntry(ctx context.Context, arg CreateCreditLedgerEntryParams) error {
+	_, err := q.db.Exec(ctx, createCreditLedgerEntry,
+		arg.TransactionID,
+		arg.OrganizationID,
+		arg.Account,
+		arg.Direction,
+		arg.Currency,
+		arg.Amount,
+	)
+	return err
+}
+
+const createCreditTopUp = `-- name: CreateCreditTopUp :one
+INSERT INTO credit_top_ups (organization_id, provider, currency, amount, created_by)
 VALUES ($1, $2, $3, $4, $5)
-RETURNING id, organization_id, api_key_id, endpoint, method, status_code, created_at
+RETURNING id, organization_id, provider, currency, amount, status, created_by, created_at, completed_at
 `
 
-type CreateUsageRecordParams struct {
-	OrganizationID uuid.UUID `json:"organization_id"`
-	ApiKeyID       uuid.UUID `json:"api_key_id"`
-	Endpoint       string    `json:"endpoint"`
-	Method         string    `json:"method"`
-	StatusCode     int32     `json:"status_code"`
+type CreateCreditTopUpParams struct {
+	OrganizationID uuid.UUID      `json:"organization_id"`
+	Provider       string         `json:"provider"`
+	Currency       string         `json:"currency"`
+	Amount         pgtype.Numeric `json:"amount"`
+	CreatedBy      pgtype.UUID    `json:"created_by"`
 }
 
-// ============================================
-// USAGE RECORD QUERIES
-// ============================================
-func (q *Queries) CreateUsageRecord(ctx context.Context, arg CreateUsageRecordParams) (UsageRecord, error) {
-	row := q.db.QueryRow(ctx, createUsageRecord,
+func (q *Queries) CreateCreditTopUp(ctx context.Context, arg CreateCreditTopUpParams) (CreditTopUp, error) {
+	row := q.db.QueryRow(ctx, createCreditTopUp,
 		arg.OrganizationID,
-		arg.ApiKeyID,
-		arg.Endpoint,
-		arg.Method,
-		arg.StatusCode,
+		arg.Provider,
+		arg.Currency,
+		arg.Amount,
+		arg.CreatedBy,
 	)
-	var i UsageRecord
+	var i CreditTopUp
 	err := row.Scan(
 		&i.ID,
 		&i.OrganizationID,
-		&i.ApiKeyID,
-		&i.Endpoint,
-		&i.Method,
-		&i.StatusCode,
+		&i.Provider,
+		&i.Currency,
+		&i.Amount,
+		&i.Status,
+		&i.CreatedBy,
 		&i.CreatedAt,
+		&i.CompletedAt,
 	)
 	return i, err
 }
 
-const createUser = `-- name: CreateUser :one
-
-INSERT INTO users (organization_id, email, password_hash, role)
-VALUES ($1, $2, $3, $4)
-RETURNING id, organization_id, email, password_hash, role, created_at, email_verified, email_verified_at
+const createCreditTransaction = `-- name: CreateCreditTransaction :one
+INSERT INTO credit_transactions (
+    organization_id,
+    type,
+    currency,
+    amount,
+    description,
+    top_up_id,
+    billing_cycle_id
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, organization_id, type, currency, amount, description, top_up_id, billing_cycle_id, created_at
 `
 
-type CreateUserParams struct {
-	OrganizationID uuid.UUID `json:"organization_id"`
-	Email          string    `json:"email"`
-	PasswordHash   string    `json:"password_hash"`
-	Role           UserRole  `json:"role"`
+type CreateCreditTransactionParams struct {
+	OrganizationID uuid.UUID             `json:"organization_id"`
+	Type           CreditTransactionType `json:"type"`
+	Currency       string                `json:"currency"`
+	Amount         pgtype.Numeric        `json:"amount"`
+	Description    string                `json:"description"`
+	TopUpID        pgtype.UUID           `json:"top_up_id"`
+	BillingCycleID pgtype.UUID           `json:"billing_cycle_id"`
 }
 
-// ============================================
-// USER QUERIES
-// ============================================
-func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRow(ctx, createUser,
+func (q *Queries) CreateCreditTransaction(ctx context.Context, arg CreateCreditTransactionParams) (CreditTransaction, error) {
+	row := q.db.QueryRow(ctx, createCreditTransaction,
 		arg.OrganizationID,
-		arg.Email,
-		arg.PasswordHash,
-		arg.Role,
+		arg.Type,
+		arg.Currency,
+		arg.Amount,
+		arg.Description,
+		arg.TopUpID,
+		arg.BillingCycleID,
 	)
-	var i User
+	var i CreditTransaction
 	err := row.Scan(
 		&i.ID,
 		&i.OrganizationID,
-		&i.Email,
-		&i.PasswordHash,
-		&i.Role,
+		&i.Type,
+		&i.Currency,
+		&i.Amount,
+		&i.Description,
+		&i.TopUpID,
+		&i.BillingCycleID,
 		&i.CreatedAt,
-		&i.EmailVerified,
-		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
-const deactivateAPIKey = `-- name: DeactivateAPIKey :one
-UPDATE api_keys
-SET is_active = false
-WHERE id = $1
-RETURNING id, organization_id, key, name, is_active, created_at, last_used_at
+const createInvoiceLineItem = `-- name: CreateInvoiceLineItem :one
+INSERT INTO invoice_line_items (
+    billing_cycle_id,
+    position,
+    type,
+    description,
+    quantity,
+    unit_price,
+    amount
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, billing_cycle_id, position, type, description, quantity, unit_price, amount, created_at
 `
 
-func (q *Queries) DeactivateAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error) {
-	row := q.db.QueryRow(ctx, deactivateAPIKey, id)
-	var i ApiKey
+type CreateInvoiceLineItemParams struct {
+	BillingCycleID uuid.UUID           `json:"billing_cycle_id"`
+	Position       int32               `json:"position"`
+	Type           InvoiceLineItemType `json:"type"`
+	Description    string              `json:"description"`
+	Quantity       int64               `json:"quantity"`
+	UnitPrice      pgtype.Numeric      `json:"unit_price"`
+	Amount         pgtype.Numeric      `json:"amount"`
+}
+
+func (q *Queries) CreateInvoiceLineItem(ctx context.Context, arg CreateInvoiceLineItemParams) (InvoiceLineItem, error) {
+	row := q.db.QueryRow(ctx, createInvoiceLineItem,
+		arg.BillingCycleID,
+		arg.Position,
+		arg.Type,
+		arg.Description,
+		arg.Quantity,
+		arg.UnitPrice,
+		arg.Amount,
+	)
+	var i InvoiceLineItem
 	err := row.Scan(
 		&i.ID,
-		&i.OrganizationID,
-		&i.Key,
-		&i.Name,
-		&i.IsActive,
+		&i.BillingCycleID,
+		&i.Position,
+		&i.Type,
+		&i.Description,
+		&i.Quantity,
+		&i.UnitPrice,
+		&i.Amount,
 		&i.CreatedAt,
-		&i.LastUsedAt,
 	)
 	return i, err
 }
 
-const declineTeamInvitation = `-- name: DeclineTeamInvitation :one
-UPDATE team_invitations
-SET declined_at = NOW()
-WHERE id = $1
-RETURNING id, organization_id, email, role, invited_by, token, expires_at, accepted_at, declined_at, created_at
+const createOrganization = `-- name: CreateOrganization :one
+INSERT INTO organizations (name, email, plan)
+VALUES ($1, $2, $3)
+RETURNING id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id
 `
 
-func (q *Queries) DeclineTeamInvitation(ctx context.Context, id uuid.UUID) (TeamInvitation, error) {
-	row := q.db.QueryRow(ctx, declineTeamInvitation, id)
-	var i TeamInvitation
-	err := row.Scan(
-		&i.ID,
-		&i.OrganizationID,
+type CreateOrganizationParams struct {
+	Name  string   `json:"name"`
+	Email string   `json:"email"`
+	Plan  PlanType `json:"plan"`
+}
+
+func (q *Queries) CreateOrganization(ctx context.Context, arg CreateOrganizationParams) (Organization, error) {
+	row := q.db.QueryRow(ctx, createOrganization, arg.Name, arg.Email, arg.Plan)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
 		&i.Email,
-		&i.Role,
-		&i.InvitedBy,
-		&i.Token,
-		&i.ExpiresAt,
-		&i.AcceptedAt,
-		&i.DeclinedAt,
+		&i.Plan,
 		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.QuotaPolicy,
+		&i.BillingCurrency,
+		&i.TrialStatus,
+		&i.TrialPlan,
+		&i.TrialStartedAt,
+		&i.TrialEndsAt,
+		&i.TrialRemindedAt,
+		&i.StripeCustomerID,
 	)
 	return i, err
 }
 
-const deleteAPIKey = `-- name: DeleteAPIKey :exec
-DELETE FROM api_keys
-WHERE id = $1
-`
-
-func (q *Queries) DeleteAPIKey(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.Exec(ctx, deleteAPIKey, id)
-	return err
-}
-
-const deleteExpiredInvitations = `-- name: DeleteExpiredInvitations :exec
-DELETE FROM team_invitations
-WHERE expires_at < NOW()
-`
-
-func (q *Queries) DeleteExpiredInvitations(ctx context.Context) error {
-	_, err := q.db.Exec(ctx, deleteExpiredInvitations)
-	return err
-}
-
-const deleteExpiredTokens = `-- name: DeleteExpiredTokens :exec
-DELETE FROM auth_tokens
-WHERE expires_at < NOW()
+const createOutboundEvent = `-- name: CreateOutboundEvent :execrows
+INSERT INTO outbound_events (organization_id, event_type, payload)
+SELECT organization_id, $1::varchar, $2::jsonb
+FROM webhook_endpoints
+WHERE organization_id = $3
 `
 
-func (q *Queries) DeleteExpiredTokens(ctx context.Context) error {
-	_, err := q.db.Exec(ctx, deleteExpiredTokens)
-	return err
+type CreateOutboundEventParams struct {
+	EventType      string    `json:"event_type"`
+	Payload        []byte    `json:"payload"`
+	OrganizationID uuid.UUID `json:"organization_id"`
 }
 
-const deleteOrganization = `-- name: DeleteOrganization :exec
-DELETE FROM organizations
-WHERE id = $1
-`
-
-func (q *Queries) DeleteOrganization(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.Exec(ctx, deleteOrganization, id)
-	return err
+func (q *Queries) CreateOutboundEvent(ctx context.Context, arg CreateOutboundEventParams) (int64, error) {
+	result, err := q.db.Exec(ctx, createOutboundEvent, arg.EventType, arg.Payload, arg.OrganizationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-const deleteUser = `-- name: DeleteUser :exec
-DELETE FROM users
-WHERE id = $1
+const createPlanHistory = `-- name: CreatePlanHistory :one
+INSERT INTO organization_plan_history (
+    organization_id,
+    plan,
+    started_at,
+    prepaid_until,
+    proration_cycle_id,
+    changed_by,
+    trial,
+    billed_by_stripe
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, organization_id, plan, started_at, ended_at, prepaid_until, proration_cycle_id, changed_by, created_at, trial, billed_by_stripe
 `
 
-func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.Exec(ctx, deleteUser, id)
-	return err
+type CreatePlanHistoryParams struct {
+	OrganizationID   uuid.UUID        `json:"organization_id"`
+	Plan             PlanType         `json:"plan"`
+	StartedAt        pgtype.Timestamp `json:"started_at"`
+	PrepaidUntil     pgtype.Timestamp `json:"prepaid_until"`
+	ProrationCycleID pgtype.UUID      `json:"proration_cycle_id"`
+	ChangedBy        pgtype.UUID      `json:"changed_by"`
+	Trial            bool             `json:"trial"`
+	BilledByStripe   bool             `json:"billed_by_stripe"`
 }
 
-const getAPIKey = `-- name: GetAPIKey :one
-SELECT id, organization_id, key, name, is_active, created_at, last_used_at FROM api_keys
-WHERE id = $1
-`
-
-func (q *Queries) GetAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error) {
-	row := q.db.QueryRow(ctx, getAPIKey, id)
-	var i ApiKey
+func (q *Queries) CreatePlanHistory(ctx context.Context, arg CreatePlanHistoryParams) (OrganizationPlanHistory, error) {
+	row := q.db.QueryRow(ctx, createPlanHistory,
+		arg.OrganizationID,
+		arg.Plan,
+		arg.StartedAt,
+		arg.PrepaidUntil,
+		arg.ProrationCycleID,
+		arg.ChangedBy,
+		arg.Trial,
+		arg.BilledByStripe,
+	)
+	var i OrganizationPlanHistory
 	err := row.Scan(
 		&i.ID,
 		&i.OrganizationID,
-		&i.Key,
-		&i.Name,
-		&i.IsActive,
+		&i.Plan,
+		&i.StartedAt,
+		&i.EndedAt,
+		&i.PrepaidUntil,
+		&i.ProrationCycleID,
+		&i.ChangedBy,
 		&i.CreatedAt,
-		&i.LastUsedAt,
+		&i.Trial,
+		&i.BilledByStripe,
 	)
 	return i, err
 }
 
-const getAPIKeyByKey = `-- name: GetAPIKeyByKey :one
-SELECT 
-    ak.id, ak.organization_id, ak.key, ak.name, ak.is_active, ak.created_at, ak.last_used_at,
-    o.id as org_id,
-    o.name as org_name,
-    o.plan as org_plan
-FROM api_keys ak
-JOIN organizations o ON ak.organization_id = o.id
-WHERE ak.key = $1 AND ak.is_active = true
+const createScheduledPlanChange = `-- name: CreateScheduledPlanChange :one
+INSERT INTO scheduled_plan_changes (organization_id, from_plan, to_plan, effective_at, requested_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, organization_id, from_plan, to_plan, effective_at, status, requested_by, created_at, applied_at, cancelled_at
 `
 
-type GetAPIKeyByKeyRow struct {
-	ID             uuid.UUID        `json:"id"`
+type CreateScheduledPlanChangeParams struct {
 	OrganizationID uuid.UUID        `json:"organization_id"`
-	Key            string           `json:"key"`
-	Name           string           `json:"name"`
-	IsActive       bool             `json:"is_active"`
-	CreatedAt      pgtype.Timestamp `json:"created_at"`
-	LastUsedAt     pgtype.Timestamp `json:"last_used_at"`
-	OrgID          uuid.UUID        `json:"org_id"`
-	OrgName        string           `json:"org_name"`
-	OrgPlan        PlanType         `json:"org_plan"`
+	FromPlan       PlanType         `json:"from_plan"`
+	ToPlan         PlanType         `json:"to_plan"`
+	EffectiveAt    pgtype.Timestamp `json:"effective_at"`
+	RequestedBy    pgtype.UUID      `json:"requested_by"`
 }
 
-func (q *Queries) GetAPIKeyByKey(ctx context.Context, key string) (GetAPIKeyByKeyRow, error) {
-	row := q.db.QueryRow(ctx, getAPIKeyByKey, key)
-	var i GetAPIKeyByKeyRow
+func (q *Queries) CreateScheduledPlanChange(ctx context.Context, arg CreateScheduledPlanChangeParams) (ScheduledPlanChange, error) {
+	row := q.db.QueryRow(ctx, createScheduledPlanChange,
+		arg.OrganizationID,
+		arg.FromPlan,
+		arg.ToPlan,
+		arg.EffectiveAt,
+		arg.RequestedBy,
+	)
+	var i ScheduledPlanChange
 	err := row.Scan(
 		&i.ID,
 		&i.OrganizationID,
-		&i.Key,
-		&i.Name,
-		&i.IsActive,
+		&i.FromPlan,
+		&i.ToPlan,
+		&i.EffectiveAt,
+		&i.Status,
+		&i.RequestedBy,
 		&i.CreatedAt,
-		&i.LastUsedAt,
-		&i.OrgID,
-		&i.OrgName,
-		&i.OrgPlan,
+		&i.AppliedAt,
+		&i.CancelledAt,
 	)
 	return i, err
 }
 
-const getAuthToken = `-- name: GetAuthToken :one
-SELECT id, user_id, token, type, expires_at, used_at, created_at FROM auth_tokens
-WHERE token = $1 AND used_at IS NULL AND expires_at > NOW()
-LIMIT 1
+const createTeamInvitation = `-- name: CreateTeamInvitation :one
+INSERT INTO team_invitations (organization_id, email, role, invited_by, token, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, organization_id, email, role, invited_by, token, expires_at, accepted_at, declined_at, created_at
 `
 
-func (q *Queries) GetAuthToken(ctx context.Context, token string) (AuthToken, error) {
-	row := q.db.QueryRow(ctx, getAuthToken, token)
-	var i AuthToken
+type CreateTeamInvitationParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Email          string           `json:"email"`
+	Role           UserRole         `json:"role"`
+	InvitedBy      uuid.UUID        `json:"invited_by"`
+	Token          string           `json:"token"`
+	ExpiresAt      pgtype.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) CreateTeamInvitation(ctx context.Context, arg CreateTeamInvitationParams) (TeamInvitation, error) {
+	row := q.db.QueryRow(ctx, createTeamInvitation,
+		arg.OrganizationID,
+		arg.Email,
+		arg.Role,
+		arg.InvitedBy,
+		arg.Token,
+		arg.ExpiresAt,
+	)
+	var i TeamInvitation
 	err := row.Scan(
 		&i.ID,
-		&i.UserID,
+		&i.OrganizationID,
+		&i.Email,
+		&i.Role,
+		&i.InvitedBy,
 		&i.Token,
-		&i.Type,
 		&i.ExpiresAt,
-		&i.UsedAt,
+		&i.AcceptedAt,
+		&i.DeclinedAt,
 		&i.CreatedAt,
 	)
 	return i, err
 }
 
-const getBillingCycle = `-- name: GetBillingCycle :one
-SELECT id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at FROM billing_cycles
-WHERE id = $1
+const createUsageAlert = `-- name: CreateUsageAlert :one
+INSERT INTO usage_alerts (organization_id, metric, direction, window_start, window_end, observed, expected, score)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (organization_id, metric, window_start) DO NOTHING
+RETURNING id, organization_id, metric, direction, window_start, window_end, observed, expected, score, status, created_at, acknowledged_at, acknowledged_by
 `
 
-func (q *Queries) GetBillingCycle(ctx context.Context, id uuid.UUID) (BillingCycle, error) {
-	row := q.db.QueryRow(ctx, getBillingCycle, id)
-	var i BillingCycle
-	err := row.Scan(
-		&i.ID,
-		&i.OrganizationID,
-		&i.PeriodStart,
-		&i.PeriodEnd,
-		&i.TotalRequests,
-		&i.TotalAmount,
-		&i.Status,
-		&i.CreatedAt,
-	)
-	return i, err
+type CreateUsageAlertParams struct {
+	OrganizationID uuid.UUID           `json:"organization_id"`
+	Metric         UsageAlertMetric    `json:"metric"`
+	Direction      UsageAlertDirection `json:"direction"`
+	WindowStart    pgtype.Timestamp    `json:"window_start"`
+	WindowEnd      pgtype.Timestamp    `json:"window_end"`
+	Observed       float64             `json:"observed"`
+	Expected       float64             `json:"expected"`
+	Score          float64             `json:"score"`
 }
 
-const getCurrentBillingCycle = `-- name: GetCurrentBillingCycle :one
-SELECT id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at FROM billing_cycles
-WHERE organization_id = $1
-    AND period_start <= NOW()
-    AND period_end >= NOW()
-ORDER BY period_start DESC
-LIMIT 1
-`
-
-func (q *Queries) GetCurrentBillingCycle(ctx context.Context, organizationID uuid.UUID) (BillingCycle, error) {
-	row := q.db.QueryRow(ctx, getCurrentBillingCycle, organizationID)
-	var i BillingCycle
+func (q *Queries) CreateUsageAlert(ctx context.Context, arg CreateUsageAlertParams) (UsageAlert, error) {
+	row := q.db.QueryRow(ctx, createUsageAlert,
+		arg.OrganizationID,
+		arg.Metric,
+		arg.Direction,
+		arg.WindowStart,
+		arg.WindowEnd,
+		arg.Observed,
+		arg.Expected,
+		arg.Score,
+	)
+	var i UsageAlert
 	err := row.Scan(
 		&i.ID,
 		&i.OrganizationID,
-		&i.PeriodStart,
-		&i.PeriodEnd,
-		&i.TotalRequests,
-		&i.TotalAmount,
+		&i.Metric,
+		&i.Direction,
+		&i.WindowStart,
+		&i.WindowEnd,
+		&i.Observed,
+		&i.Expected,
+		&i.Score,
 		&i.Status,
 		&i.CreatedAt,
+		&i.AcknowledgedAt,
+		&i.AcknowledgedBy,
 	)
 	return i, err
 }
 
-const getDailyUsageStats = `-- name: GetDailyUsageStats :many
-SELECT 
-    DATE(created_at) as date,
-    COUNT(*) as request_count,
-    COUNT(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 END) as success_count,
-    COUNT(CASE WHEN status_code >= 400 THEN 1 END) as error_count
-FROM usage_records
-WHERE organization_id = $1
-    AND created_at >= $2
-    AND created_at <= $3
-GROUP BY DATE(created_at)
-ORDER BY date DESC
+const createUsageBudgetNotification = `-- name: CreateUsageBudgetNotification :execrows
+INSERT INTO usage_budget_notifications (organization_id, period_start, threshold, usage)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (organization_id, period_start, threshold) DO NOTHING
 `
 
-type GetDailyUsageStatsParams struct {
+type CreateUsageBudgetNotificationParams struct {
 	OrganizationID uuid.UUID        `json:"organization_id"`
-	CreatedAt      pgtype.Timestamp `json:"created_at"`
-	CreatedAt_2    pgtype.Timestamp `json:"created_at_2"`
-}
-
-type GetDailyUsageStatsRow struct {
-	Date         pgtype.Date `json:"date"`
-	RequestCount int64       `json:"request_count"`
-	SuccessCount int64       `json:"success_count"`
-	ErrorCount   int64       `json:"error_count"`
+	PeriodStart    pgtype.Timestamp `json:"period_start"`
+	Threshold      int32            `json:"threshold"`
+	Usage          pgtype.Numeric   `json:"usage"`
 }
 
-func (q *Queries) GetDailyUsageStats(ctx context.Context, arg GetDailyUsageStatsParams) ([]GetDailyUsageStatsRow, error) {
-	rows, err := q.db.Query(ctx, getDailyUsageStats, arg.OrganizationID, arg.CreatedAt, arg.CreatedAt_2)
+func (q *Queries) CreateUsageBudgetNotification(ctx context.Context, arg CreateUsageBudgetNotificationParams) (int64, error) {
+	result, err := q.db.Exec(ctx, createUsageBudgetNotification,
+		arg.OrganizationID,
+		arg.PeriodStart,
+		arg.Threshold,
+		arg.Usage,
+	)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	items := []GetDailyUsageStatsRow{}
-	for rows.Next() {
-		var i GetDailyUsageStatsRow
-		if err := rows.Scan(
-			&i.Date,
-			&i.RequestCount,
-			&i.SuccessCount,
-			&i.ErrorCount,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	return items, nil
+	return result.RowsAffected(), nil
 }
 
-const getOrganization = `-- name: GetOrganization :one
-SELECT id, name, email, plan, created_at, updated_at FROM organizations
-WHERE id = $1
+const createUsageExport = `-- name: CreateUsageExport :one
+
+INSERT INTO usage_exports (organization_id, requested_by, format, start_time, end_time)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, organization_id, requested_by, format, start_time, end_time, status, row_count, file_size, file_path, error, created_at, started_at, completed_at, expires_at
 `
 
-func (q *Queries) GetOrganization(ctx context.Context, id uuid.UUID) (Organization, error) {
-	row := q.db.QueryRow(ctx, getOrganization, id)
-	var i Organization
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Email,
-		&i.Plan,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-	)
-	return i, err
+type CreateUsageExportParams struct {
+	OrganizationID uuid.UUID         `json:"organization_id"`
+	RequestedBy    pgtype.UUID       `json:"requested_by"`
+	Format         UsageExportFormat `json:"format"`
+	StartTime      pgtype.Timestamp  `json:"start_time"`
+	EndTime        pgtype.Timestamp  `json:"end_time"`
 }
 
-const getOrganizationByEmail = `-- name: GetOrganizationByEmail :one
-SELECT id, name, email, plan, created_at, updated_at FROM organizations
-WHERE email = $1
-`
-
-func (q *Queries) GetOrganizationByEmail(ctx context.Context, email string) (Organization, error) {
-	row := q.db.QueryRow(ctx, getOrganizationByEmail, email)
-	var i Organization
+// ============================================
+// USAGE EXPORT QUERIES
+// ============================================
+func (q *Queries) CreateUsageExport(ctx context.Context, arg CreateUsageExportParams) (UsageExport, error) {
+	row := q.db.QueryRow(ctx, createUsageExport,
+		arg.OrganizationID,
+		arg.RequestedBy,
+		arg.Format,
+		arg.StartTime,
+		arg.EndTime,
+	)
+	var i UsageExport
 	err := row.Scan(
 		&i.ID,
-		&i.Name,
-		&i.Email,
-		&i.Plan,
+		&i.OrganizationID,
+		&i.RequestedBy,
+		&i.Format,
+		&i.StartTime,
+		&i.EndTime,
+		&i.Status,
+		&i.RowCount,
+		&i.FileSize,
+		&i.FilePath,
+		&i.Error,
 		&i.CreatedAt,
-		&i.UpdatedAt,
+		&i.StartedAt,
+		&i.CompletedAt,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
-const getOverdueBillingCycles = `-- name: GetOverdueBillingCycles :many
+const createUsageRecord = `-- name: CreateUsageRecord :one
+
+INSERT INTO usage_records (organization_id, api_key_id, endpoint, method, status_code)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, organization_id, api_key_id, endpoint, method, status_code, created_at, duration_ms, request_bytes, response_bytes, client_ip, user_agent, request_id, message_type, units
+`
+
+type CreateUsageRecordParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	ApiKeyID       uuid.UUID `json:"api_key_id"`
+	Endpoint       string    `json:"endpoint"`
+	Method         string    `json:"method"`
+	StatusCode     int32     `json:"status_code"`
+}
+
+// ============================================
+// USAGE RECORD QUERIES
+// ============================================
+func (q *Queries) CreateUsageRecord(ctx context.Context, arg CreateUsageRecordParams) (UsageRecord, error) {
+	row := q.db.QueryRow(ctx, createUsageRecord,
+		arg.OrganizationID,
+		arg.ApiKeyID,
+		arg.Endpoint,
+		arg.Method,
+		arg.StatusCode,
+	)
+	var i UsageRecord
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.ApiKeyID,
+		&i.Endpoint,
+		&i.Method,
+		&i.StatusCode,
+		&i.CreatedAt,
+		&i.DurationMs,
+		&i.RequestBytes,
+		&i.ResponseBytes,
+		&i.ClientIp,
+		&i.UserAgent,
+		&i.RequestID,
+		&i.MessageType,
+		&i.Units,
+	)
+	return i, err
+}
+
+type CreateUsageRecordsParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	ApiKeyID       uuid.UUID        `json:"api_key_id"`
+	Endpoint       string           `json:"endpoint"`
+	Method         string           `json:"method"`
+	StatusCode     int32            `json:"status_code"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	DurationMs     int32            `json:"duration_ms"`
+	RequestBytes   int64            `json:"request_bytes"`
+	ResponseBytes  int64            `json:"response_bytes"`
+	ClientIp       *string          `json:"client_ip"`
+	UserAgent      *string          `json:"user_agent"`
+	RequestID      *string          `json:"request_id"`
+	MessageType    *string          `json:"message_type"`
+	Units          int32            `json:"units"`
+}
+
+const createUser = `-- name: CreateUser :one
+
+INSERT INTO users (organization_id, email, password_hash, role)
+VALUES ($1, $2, $3, $4)
+RETURNING id, organization_id, email, password_hash, role, created_at, email_verified, email_verified_at
+`
+
+type CreateUserParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Email          string    `json:"email"`
+	PasswordHash   string    `json:"password_hash"`
+	Role           UserRole  `json:"role"`
+}
+
+// ============================================
+// USER QUERIES
+// ============================================
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+	row := q.db.QueryRow(ctx, createUser,
+		arg.OrganizationID,
+		arg.Email,
+		arg.PasswordHash,
+		arg.Role,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Role,
+		&i.CreatedAt,
+		&i.EmailVerified,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
+
+const createWebhookEndpoint = `-- name: CreateWebhookEndpoint :one
+
+INSERT INTO webhook_endpoints (organization_id, url, secret)
+VALUES ($1, $2, $3)
+RETURNING organization_id, url, secret, created_at, updated_at
+`
+
+type CreateWebhookEndpointParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Url            string    `json:"url"`
+	Secret         string    `json:"secret"`
+}
+
+// ============================================
+// OUTBOUND EVENT QUERIES
+// ============================================
+func (q *Queries) CreateWebhookEndpoint(ctx context.Context, arg CreateWebhookEndpointParams) (WebhookEndpoint, error) {
+	row := q.db.QueryRow(ctx, createWebhookEndpoint, arg.OrganizationID, arg.Url, arg.Secret)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Url,
+		&i.Secret,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const deactivateAPIKey = `-- name: DeactivateAPIKey :one
+UPDATE api_keys
+SET is_active = false, suspended_at = NULL
+WHERE id = $1
+RETURNING id, organization_id, key, name, is_active, created_at, last_used_at, essential, suspended_at
+`
+
+func (q *Queries) DeactivateAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, deactivateAPIKey, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Key,
+		&i.Name,
+		&i.IsActive,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.Essential,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const declineTeamInvitation = `-- name: DeclineTeamInvitation :one
+UPDATE team_invitations
+SET declined_at = NOW()
+WHERE id = $1
+RETURNING id, organization_id, email, role, invited_by, token, expires_at, accepted_at, declined_at, created_at
+`
+
+func (q *Queries) DeclineTeamInvitation(ctx context.Context, id uuid.UUID) (TeamInvitation, error) {
+	row := q.db.QueryRow(ctx, declineTeamInvitation, id)
+	var i TeamInvitation
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Email,
+		&i.Role,
+		&i.InvitedBy,
+		&i.Token,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.DeclinedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteAPIKey = `-- name: DeleteAPIKey :exec
+DELETE FROM api_keys
+WHERE id = $1
+`
+
+func (q *Queries) DeleteAPIKey(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteAPIKey, id)
+	return err
+}
+
+const deleteCoupon = `-- name: DeleteCoupon :execrows
+DELETE FROM coupons
+WHERE id = $1 AND times_redeemed = 0
+`
+
+func (q *Queries) DeleteCoupon(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteCoupon, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredInvitations = `-- name: DeleteExpiredInvitations :exec
+DELETE FROM team_invitations
+WHERE expires_at < NOW()
+`
+
+func (q *Queries) DeleteExpiredInvitations(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredInvitations)
+	return err
+}
+
+const deleteExpiredTokens = `-- name: DeleteExpiredTokens :exec
+DELETE FROM auth_tokens
+WHERE expires_at < NOW()
+`
+
+func (q *Queries) DeleteExpiredTokens(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredTokens)
+	return err
+}
+
+const deleteOrganization = `-- name: DeleteOrganization :exec
+DELETE FROM organizations
+WHERE id = $1
+`
+
+func (q *Queries) DeleteOrganization(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteOrganization, id)
+	return err
+}
+
+const deleteUsageBudget = `-- name: DeleteUsageBudget :exec
+DELETE FROM usage_budgets
+WHERE organization_id = $1
+`
+
+func (q *Queries) DeleteUsageBudget(ctx context.Context, organizationID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteUsageBudget, organizationID)
+	return err
+}
+
+const deleteUsageBudgetNotifications = `-- name: DeleteUsageBudgetNotifications :exec
+DELETE FROM usage_budget_notifications
+WHERE organization_id = $1 AND period_start = $2
+`
+
+type DeleteUsageBudgetNotificationsParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	PeriodStart    pgtype.Timestamp `json:"period_start"`
+}
+
+func (q *Queries) DeleteUsageBudgetNotifications(ctx context.Context, arg DeleteUsageBudgetNotificationsParams) error {
+	_, err := q.db.Exec(ctx, deleteUsageBudgetNotifications, arg.OrganizationID, arg.PeriodStart)
+	return err
+}
+
+const deleteUser = `-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteUser, id)
+	return err
+}
+
+const deleteWebhookEndpoint = `-- name: DeleteWebhookEndpoint :exec
+DELETE FROM webhook_endpoints
+WHERE organization_id = $1
+`
+
+func (q *Queries) DeleteWebhookEndpoint(ctx context.Context, organizationID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, deleteWebhookEndpoint, organizationID)
+	return err
+}
+
+const endCurrentPlanHistory = `-- name: EndCurrentPlanHistory :execrows
+UPDATE organization_plan_history
+SET ended_at = $2
+WHERE organization_id = $1 AND ended_at IS NULL
+`
+
+type EndCurrentPlanHistoryParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	EndedAt        pgtype.Timestamp `json:"ended_at"`
+}
+
+func (q *Queries) EndCurrentPlanHistory(ctx context.Context, arg EndCurrentPlanHistoryParams) (int64, error) {
+	result, err := q.db.Exec(ctx, endCurrentPlanHistory, arg.OrganizationID, arg.EndedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const endTrial = `-- name: EndTrial :one
+
+UPDATE organizations
+SET trial_status = $2, updated_at = NOW()
+WHERE id = $1 AND trial_status = 'active'
+RETURNING id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id
+`
+
+type EndTrialParams struct {
+	ID          uuid.UUID       `json:"id"`
+	TrialStatus NullTrialStatus `json:"trial_status"`
+}
+
+// Only an active trial ends, so a trial is converted or expired once
+func (q *Queries) EndTrial(ctx context.Context, arg EndTrialParams) (Organization, error) {
+	row := q.db.QueryRow(ctx, endTrial, arg.ID, arg.TrialStatus)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Plan,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.QuotaPolicy,
+		&i.BillingCurrency,
+		&i.TrialStatus,
+		&i.TrialPlan,
+		&i.TrialStartedAt,
+		&i.TrialEndsAt,
+		&i.TrialRemindedAt,
+		&i.StripeCustomerID,
+	)
+	return i, err
+}
+
+const ensureCreditWallet = `-- name: EnsureCreditWallet :exec
+INSERT INTO credit_wallets (organization_id)
+VALUES ($1)
+ON CONFLICT (organization_id) DO NOTHING
+`
+
+func (q *Queries) EnsureCreditWallet(ctx context.Context, organizationID uuid.UUID) error {
+	_, err := q.db.Exec(ctx, ensureCreditWallet, organizationID)
+	return err
+}
+
+const failCreditTopUp = `-- name: FailCreditTopUp :execrows
+UPDATE credit_top_ups
+SET status = 'failed', completed_at = NOW()
+WHERE id = $1 AND status = 'pending'
+`
+
+func (q *Queries) FailCreditTopUp(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, failCreditTopUp, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const failUsageExport = `-- name: FailUsageExport :exec
+UPDATE usage_exports
+SET status = 'failed',
+    error = $2,
+    completed_at = NOW()
+WHERE id = $1
+`
+
+type FailUsageExportParams struct {
+	ID    uuid.UUID `json:"id"`
+	Error *string   `json:"error"`
+}
+
+func (q *Queries) FailUsageExport(ctx context.Context, arg FailUsageExportParams) error {
+	_, err := q.db.Exec(ctx, failUsageExport, arg.ID, arg.Error)
+	return err
+}
+
+const getAPIKey = `-- name: GetAPIKey :one
+SELECT id, organization_id, key, name, is_active, created_at, last_used_at, essential, suspended_at FROM api_keys
+WHERE id = $1
+`
+
+func (q *Queries) GetAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, getAPIKey, id)
+	var i ApiKey
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Key,
+		&i.Name,
+		&i.IsActive,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.Essential,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const getAPIKeyByKey = `-- name: GetAPIKeyByKey :one
+SELECT 
+    ak.id, ak.organization_id, ak.key, ak.name, ak.is_active, ak.created_at, ak.last_used_at, ak.essential, ak.suspended_at,
+    o.id as org_id,
+    o.name as org_name,
+    o.plan as org_plan,
+    o.quota_policy as org_quota_policy,
+    (cw.blocked_at IS NOT NULL)::boolean as org_credit_blocked
+FROM api_keys ak
+JOIN organizations o ON ak.organization_id = o.id
+LEFT JOIN credit_wallets cw ON cw.organization_id = o.id
+WHERE ak.key = $1 AND ak.is_active = true
+`
+
+type GetAPIKeyByKeyRow struct {
+	ID               uuid.UUID        `json:"id"`
+	OrganizationID   uuid.UUID        `json:"organization_id"`
+	Key              string           `json:"key"`
+	Name             string           `json:"name"`
+	IsActive         bool             `json:"is_active"`
+	CreatedAt        pgtype.Timestamp `json:"created_at"`
+	LastUsedAt       pgtype.Timestamp `json:"last_used_at"`
+	Essential        bool             `json:"essential"`
+	SuspendedAt      pgtype.Timestamp `json:"suspended_at"`
+	OrgID            uuid.UUID        `json:"org_id"`
+	OrgName          string           `json:"org_name"`
+	OrgPlan          PlanType         `json:"org_plan"`
+	OrgQuotaPolicy   QuotaPolicy      `json:"org_quota_policy"`
+	OrgCreditBlocked bool             `json:"org_credit_blocked"`
+}
+
+func (q *Queries) GetAPIKeyByKey(ctx context.Context, key string) (GetAPIKeyByKeyRow, error) {
+	row := q.db.QueryRow(ctx, getAPIKeyByKey, key)
+	var i GetAPIKeyByKeyRow
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Key,
+		&i.Name,
+		&i.IsActive,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.Essential,
+		&i.SuspendedAt,
+		&i.OrgID,
+		&i.OrgName,
+		&i.OrgPlan,
+		&i.OrgQuotaPolicy,
+		&i.OrgCreditBlocked,
+	)
+	return i, err
+}
+
+const getActiveCouponRedemption = `-- name: GetActiveCouponRedemption :one
+SELECT id, coupon_id, organization_id, redeemed_by, periods_remaining, redeemed_at, ended_at FROM coupon_redemptions
+WHERE organization_id = $1 AND ended_at IS NULL
+`
+
+func (q *Queries) GetActiveCouponRedemption(ctx context.Context, organizationID uuid.UUID) (CouponRedemption, error) {
+	row := q.db.QueryRow(ctx, getActiveCouponRedemption, organizationID)
+	var i CouponRedemption
+	err := row.Scan(
+		&i.ID,
+		&i.CouponID,
+		&i.OrganizationID,
+		&i.RedeemedBy,
+		&i.PeriodsRemaining,
+		&i.RedeemedAt,
+		&i.EndedAt,
+	)
+	return i, err
+}
+
+const getActiveCouponRedemptionForUpdate = `-- name: GetActiveCouponRedemptionForUpdate :one
+SELECT id, coupon_id, organization_id, redeemed_by, periods_remaining, redeemed_at, ended_at FROM coupon_redemptions
+WHERE organization_id = $1 AND ended_at IS NULL
+FOR UPDATE
+`
+
+func (q *Queries) GetActiveCouponRedemptionForUpdate(ctx context.Context, organizationID uuid.UUID) (CouponRedemption, error) {
+	row := q.db.QueryRow(ctx, getActiveCouponRedemptionForUpdate, organizationID)
+	var i CouponRedemption
+	err := row.Scan(
+		&i.ID,
+		&i.CouponID,
+		&i.OrganizationID,
+		&i.RedeemedBy,
+		&i.PeriodsRemaining,
+		&i.RedeemedAt,
+		&i.EndedAt,
+	)
+	return i, err
+}
+
+const getAuthToken = `-- name: GetAuthToken :one
+SELECT id, user_id, token, type, expires_at, used_at, created_at FROM auth_tokens
+WHERE token = $1 AND used_at IS NULL AND expires_at > NOW()
+LIMIT 1
+`
+
+func (q *Queries) GetAuthToken(ctx context.Context, token string) (AuthToken, error) {
+	row := q.db.QueryRow(ctx, getAuthToken, token)
+	var i AuthToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Token,
+		&i.Type,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getBillingCycle = `-- name: GetBillingCycle :one
+SELECT id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at, price_book_version, quote, paid_at, invoice_number, currency, fx_rate, fx_rate_at, kind FROM billing_cycles
+WHERE id = $1
+`
+
+func (q *Queries) GetBillingCycle(ctx context.Context, id uuid.UUID) (BillingCycle, error) {
+	row := q.db.QueryRow(ctx, getBillingCycle, id)
+	var i BillingCycle
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.PeriodStart,
+		&i.PeriodEnd,
+		&i.TotalRequests,
+		&i.TotalAmount,
+		&i.Status,
+		&i.CreatedAt,
+		&i.PriceBookVersion,
+		&i.Quote,
+		&i.PaidAt,
+		&i.InvoiceNumber,
+		&i.Currency,
+		&i.FxRate,
+		&i.FxRateAt,
+		&i.Kind,
+	)
+	return i, err
+}
+
+const getCoupon = `-- name: GetCoupon :one
+SELECT id, code, name, discount_type, percent_off, amount_off, currency, duration, duration_months, plans, max_redemptions, times_redeemed, redeem_by, active, created_at, updated_at FROM coupons
+WHERE id = $1
+`
+
+func (q *Queries) GetCoupon(ctx context.Context, id uuid.UUID) (Coupon, error) {
+	row := q.db.QueryRow(ctx, getCoupon, id)
+	var i Coupon
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Name,
+		&i.DiscountType,
+		&i.PercentOff,
+		&i.AmountOff,
+		&i.Currency,
+		&i.Duration,
+		&i.DurationMonths,
+		&i.Plans,
+		&i.MaxRedemptions,
+		&i.TimesRedeemed,
+		&i.RedeemBy,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCouponByCode = `-- name: GetCouponByCode :one
+SELECT id, code, name, discount_type, percent_off, amount_off, currency, duration, duration_months, plans, max_redemptions, times_redeemed, redeem_by, active, created_at, updated_at FROM coupons
+WHERE code = $1
+`
+
+func (q *Queries) GetCouponByCode(ctx context.Context, code string) (Coupon, error) {
+	row := q.db.QueryRow(ctx, getCouponByCode, code)
+	var i Coupon
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Name,
+		&i.DiscountType,
+		&i.PercentOff,
+		&i.AmountOff,
+		&i.Currency,
+		&i.Duration,
+		&i.DurationMonths,
+		&i.Plans,
+		&i.MaxRedemptions,
+		&i.TimesRedeemed,
+		&i.RedeemBy,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCreditBalance = `-- name: GetCreditBalance :one
+SELECT COALESCE(SUM(CASE direction WHEN 'credit' THEN amount ELSE -amount END), 0)::DECIMAL(16, 2) as balance
+FROM credit_ledger_entries
+WHERE organization_id = $1 AND account = 'wallet' AND currency = $2
+`
+
+type GetCreditBalanceParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Currency       string    `json:"currency"`
+}
+
+func (q *Queries) GetCreditBalance(ctx context.Context, arg GetCreditBalanceParams) (pgtype.Numeric, error) {
+	row := q.db.QueryRow(ctx, getCreditBalance, arg.OrganizationID, arg.Currency)
+	var balance pgtype.Numeric
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const getCreditBalances = `-- name: GetCreditBalances :many
+
+SELECT
+    currency,
+    SUM(CASE direction WHEN 'credit' THEN amount ELSE -amount END)::DECIMAL(16, 2) as balance
+FROM credit_ledger_entries
+WHERE organization_id = $1 AND account = 'wallet'
+GROUP BY currency
+ORDER BY currency
+`
+
+type GetCreditBalancesRow struct {
+	Currency string         `json:"currency"`
+	Balance  pgtype.Numeric `json:"balance"`
+}
+
+// The wallet is a liability: credits add to the balance, debits spend it
+func (q *Queries) GetCreditBalances(ctx context.Context, organizationID uuid.UUID) ([]GetCreditBalancesRow, error) {
+	rows, err := q.db.Query(ctx, getCreditBalances, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetCreditBalancesRow{}
+	for rows.Next() {
+		var i GetCreditBalancesRow
+		if err := rows.Scan(&i.Currency, &i.Balance); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCreditTopUp = `-- name: GetCreditTopUp :one
+SELECT id, organization_id, provider, currency, amount, status, created_by, created_at, completed_at FROM credit_top_ups
+WHERE id = $1
+`
+
+func (q *Queries) GetCreditTopUp(ctx context.Context, id uuid.UUID) (CreditTopUp, error) {
+	row := q.db.QueryRow(ctx, getCreditTopUp, id)
+	var i CreditTopUp
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Provider,
+		&i.Currency,
+		&i.Amount,
+		&i.Status,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.CompletedAt,
+	)
+	return i, err
+}
+
+const getCreditWallet = `-- name: GetCreditWallet :one
+
+SELECT organization_id, low_balance_threshold, block_at_zero, low_balance_notified_at, blocked_at, created_at, updated_at FROM credit_wallets
+WHERE organization_id = $1
+`
+
+// ============================================
+// CREDIT WALLET QUERIES
+// ============================================
+func (q *Queries) GetCreditWallet(ctx context.Context, organizationID uuid.UUID) (CreditWallet, error) {
+	row := q.db.QueryRow(ctx, getCreditWallet, organizationID)
+	var i CreditWallet
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.LowBalanceThreshold,
+		&i.BlockAtZero,
+		&i.LowBalanceNotifiedAt,
+		&i.BlockedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCreditWalletForUpdate = `-- name: GetCreditWalletForUpdate :one
+SELECT organization_id, low_balance_threshold, block_at_zero, low_balance_notified_at, blocked_at, created_at, updated_at FROM credit_wallets
+WHERE organization_id = $1
+FOR UPDATE
+`
+
+func (q *Queries) GetCreditWalletForUpdate(ctx context.Context, organizationID uuid.UUID) (CreditWallet, error) {
+	row := q.db.QueryRow(ctx, getCreditWalletForUpdate, organizationID)
+	var i CreditWallet
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.LowBalanceThreshold,
+		&i.BlockAtZero,
+		&i.LowBalanceNotifiedAt,
+		&i.BlockedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCurrentBillingCycle = `-- name: GetCurrentBillingCycle :one
+SELECT id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at, price_book_version, quote, paid_at, invoice_number, currency, fx_rate, fx_rate_at, kind FROM billing_cycles
+WHERE organization_id = $1
+    AND kind = 'period'
+    AND period_start <= NOW()
+    AND period_end >= NOW()
+ORDER BY period_start DESC
+LIMIT 1
+`
+
+func (q *Queries) GetCurrentBillingCycle(ctx context.Context, organizationID uuid.UUID) (BillingCycle, error) {
+	row := q.db.QueryRow(ctx, getCurrentBillingCycle, organizationID)
+	var i BillingCycle
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.PeriodStart,
+		&i.PeriodEnd,
+		&i.TotalRequests,
+		&i.TotalAmount,
+		&i.Status,
+		&i.CreatedAt,
+		&i.PriceBookVersion,
+		&i.Quote,
+		&i.PaidAt,
+		&i.InvoiceNumber,
+		&i.Currency,
+		&i.FxRate,
+		&i.FxRateAt,
+		&i.Kind,
+	)
+	return i, err
+}
+
+const getCurrentPlanHistoryForUpdate = `-- name: GetCurrentPlanHistoryForUpdate :one
+SELECT id, organization_id, plan, started_at, ended_at, prepaid_until, proration_cycle_id, changed_by, created_at, trial, billed_by_stripe FROM organization_plan_history
+WHERE organization_id = $1 AND ended_at IS NULL
+FOR UPDATE
+`
+
+func (q *Queries) GetCurrentPlanHistoryForUpdate(ctx context.Context, organizationID uuid.UUID) (OrganizationPlanHistory, error) {
+	row := q.db.QueryRow(ctx, getCurrentPlanHistoryForUpdate, organizationID)
+	var i OrganizationPlanHistory
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Plan,
+		&i.StartedAt,
+		&i.EndedAt,
+		&i.PrepaidUntil,
+		&i.ProrationCycleID,
+		&i.ChangedBy,
+		&i.CreatedAt,
+		&i.Trial,
+		&i.BilledByStripe,
+	)
+	return i, err
+}
+
+const getDailyUsageStats = `-- name: GetDailyUsageStats :many
+
+WITH bounds AS (
+    SELECT
+        date_trunc('day', $1::timestamp + interval '1 day' - interval '1 microsecond') AS days_from,
+        LEAST(date_trunc('day', $2::timestamp + interval '1 microsecond'), date_trunc('day', rolled_up_to)) AS days_until,
+        date_trunc('hour', $1::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', $2::timestamp + interval '1 microsecond'), rolled_up_to) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), hour_ranges AS (
+    SELECT hours_from, LEAST(days_from, hours_until) AS hours_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, days_from, days_until), hours_until
+    FROM bounds
+), raw_ranges AS (
+    SELECT $1::timestamp AS raw_from,
+        LEAST(hours_from, $2::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), $2::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT d.day, d.status_class, d.request_count
+    FROM usage_rollups_daily d, bounds b
+    WHERE d.organization_id = $3
+        AND d.day >= b.days_from
+        AND d.day < b.days_until
+    UNION ALL
+    SELECT DATE(h.bucket), h.status_class, h.request_count
+    FROM usage_rollups_hourly h
+    JOIN hour_ranges hr ON h.bucket >= hr.hours_from AND h.bucket < hr.hours_until
+    WHERE h.organization_id = $3
+    UNION ALL
+    SELECT DATE(ur.created_at), (ur.status_code / 100)::smallint, 1::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = $3
+)
+SELECT
+    day as date,
+    SUM(request_count)::bigint as request_count,
+    COALESCE(SUM(request_count) FILTER (WHERE status_class = 2), 0)::bigint as success_count,
+    COALESCE(SUM(request_count) FILTER (WHERE status_class >= 4), 0)::bigint as error_count
+FROM usage
+GROUP BY day
+ORDER BY date DESC
+`
+
+type GetDailyUsageStatsParams struct {
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+	OrganizationID uuid.UUID        `json:"organization_id"`
+}
+
+type GetDailyUsageStatsRow struct {
+	Date         pgtype.Date `json:"date"`
+	RequestCount int64       `json:"request_count"`
+	SuccessCount int64       `json:"success_count"`
+	ErrorCount   int64       `json:"error_count"`
+}
+
+// Whole days come from the daily rollups, the rest as in CountOrganizationUsage
+func (q *Queries) GetDailyUsageStats(ctx context.Context, arg GetDailyUsageStatsParams) ([]GetDailyUsageStatsRow, error) {
+	rows, err := q.db.Query(ctx, getDailyUsageStats, arg.StartTime, arg.EndTime, arg.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetDailyUsageStatsRow{}
+	for rows.Next() {
+		var i GetDailyUsageStatsRow
+		if err := rows.Scan(
+			&i.Date,
+			&i.RequestCount,
+			&i.SuccessCount,
+			&i.ErrorCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getHourlyUsageTotals = `-- name: GetHourlyUsageTotals :many
+
+WITH mark AS (
+    SELECT rolled_up_to FROM usage_rollup_state WHERE id = 1
+), usage AS (
+    SELECT h.organization_id, h.bucket, h.request_count,
+        CASE WHEN h.status_class >= 4 THEN h.request_count ELSE 0 END as error_count
+    FROM usage_rollups_hourly h, mark
+    WHERE h.bucket >= $1::timestamp
+        AND h.bucket < $2::timestamp
+        AND h.bucket < mark.rolled_up_to
+    UNION ALL
+    SELECT ur.organization_id, date_trunc('hour', ur.created_at), 1,
+        CASE WHEN ur.status_code >= 400 THEN 1 ELSE 0 END
+    FROM usage_records ur, mark
+    WHERE ur.created_at >= GREATEST($1::timestamp, mark.rolled_up_to)
+        AND ur.created_at < $2::timestamp
+)
+SELECT
+    organization_id,
+    bucket::timestamp as bucket,
+    SUM(request_count)::bigint as request_count,
+    SUM(error_count)::bigint as error_count
+FROM usage
+GROUP BY organization_id, bucket
+ORDER BY organization_id, bucket
+`
+
+type GetHourlyUsageTotalsParams struct {
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+type GetHourlyUsageTotalsRow struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Bucket         pgtype.Timestamp `json:"bucket"`
+	RequestCount   int64            `json:"request_count"`
+	ErrorCount     int64            `json:"error_count"`
+}
+
+// ============================================
+// USAGE ALERT QUERIES
+// ============================================
+func (q *Queries) GetHourlyUsageTotals(ctx context.Context, arg GetHourlyUsageTotalsParams) ([]GetHourlyUsageTotalsRow, error) {
+	rows, err := q.db.Query(ctx, getHourlyUsageTotals, arg.StartTime, arg.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetHourlyUsageTotalsRow{}
+	for rows.Next() {
+		var i GetHourlyUsageTotalsRow
+		if err := rows.Scan(
+			&i.OrganizationID,
+			&i.Bucket,
+			&i.RequestCount,
+			&i.ErrorCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatencyPercentiles = `-- name: GetLatencyPercentiles :one
+
+SELECT
+    COUNT(*) as request_count,
+    COALESCE(AVG(duration_ms), 0)::float8 as avg_ms,
+    COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p50_ms,
+    COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p95_ms,
+    COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p99_ms,
+    COALESCE(MAX(duration_ms), 0)::integer as max_ms
+FROM usage_records
+WHERE organization_id = $1
+    AND created_at >= $2::timestamp
+    AND created_at <= $3::timestamp
+`
+
+type GetLatencyPercentilesParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+}
+
+type GetLatencyPercentilesRow struct {
+	RequestCount int64   `json:"request_count"`
+	AvgMs        float64 `json:"avg_ms"`
+	P50Ms        float64 `json:"p50_ms"`
+	P95Ms        float64 `json:"p95_ms"`
+	P99Ms        float64 `json:"p99_ms"`
+	MaxMs        int32   `json:"max_ms"`
+}
+
+// ============================================
+// ANALYTICS QUERIES
+// ============================================
+func (q *Queries) GetLatencyPercentiles(ctx context.Context, arg GetLatencyPercentilesParams) (GetLatencyPercentilesRow, error) {
+	row := q.db.QueryRow(ctx, getLatencyPercentiles, arg.OrganizationID, arg.StartTime, arg.EndTime)
+	var i GetLatencyPercentilesRow
+	err := row.Scan(
+		&i.RequestCount,
+		&i.AvgMs,
+		&i.P50Ms,
+		&i.P95Ms,
+		&i.P99Ms,
+		&i.MaxMs,
+	)
+	return i, err
+}
+
+const getLatencyPercentilesByAPIKey = `-- name: GetLatencyPercentilesByAPIKey :many
+SELECT
+    ak.id,
+    ak.name,
+    COUNT(*) as request_count,
+    COALESCE(AVG(ur.duration_ms), 0)::float8 as avg_ms,
+    COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY ur.duration_ms), 0)::float8 as p50_ms,
+    COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY ur.duration_ms), 0)::float8 as p95_ms,
+    COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY ur.duration_ms), 0)::float8 as p99_ms,
+    COALESCE(MAX(ur.duration_ms), 0)::integer as max_ms
+FROM usage_records ur
+JOIN api_keys ak ON ak.id = ur.api_key_id
+WHERE ur.organization_id = $1
+    AND ur.created_at >= $2::timestamp
+    AND ur.created_at <= $3::timestamp
+GROUP BY ak.id, ak.name
+ORDER BY request_count DESC
+`
+
+type GetLatencyPercentilesByAPIKeyParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+}
+
+type GetLatencyPercentilesByAPIKeyRow struct {
+	ID           uuid.UUID `json:"id"`
+	Name         string    `json:"name"`
+	RequestCount int64     `json:"request_count"`
+	AvgMs        float64   `json:"avg_ms"`
+	P50Ms        float64   `json:"p50_ms"`
+	P95Ms        float64   `json:"p95_ms"`
+	P99Ms        float64   `json:"p99_ms"`
+	MaxMs        int32     `json:"max_ms"`
+}
+
+func (q *Queries) GetLatencyPercentilesByAPIKey(ctx context.Context, arg GetLatencyPercentilesByAPIKeyParams) ([]GetLatencyPercentilesByAPIKeyRow, error) {
+	rows, err := q.db.Query(ctx, getLatencyPercentilesByAPIKey, arg.OrganizationID, arg.StartTime, arg.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetLatencyPercentilesByAPIKeyRow{}
+	for rows.Next() {
+		var i GetLatencyPercentilesByAPIKeyRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.RequestCount,
+			&i.AvgMs,
+			&i.P50Ms,
+			&i.P95Ms,
+			&i.P99Ms,
+			&i.MaxMs,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatencyPercentilesByEndpoint = `-- name: GetLatencyPercentilesByEndpoint :many
+SELECT
+    endpoint,
+    COUNT(*) as request_count,
+    COALESCE(AVG(duration_ms), 0)::float8 as avg_ms,
+    COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p50_ms,
+    COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p95_ms,
+    COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p99_ms,
+    COALESCE(MAX(duration_ms), 0)::integer as max_ms
+FROM usage_records
+WHERE organization_id = $1
+    AND created_at >= $2::timestamp
+    AND created_at <= $3::timestamp
+GROUP BY endpoint
+ORDER BY request_count DESC
+`
+
+type GetLatencyPercentilesByEndpointParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+}
+
+type GetLatencyPercentilesByEndpointRow struct {
+	Endpoint     string  `json:"endpoint"`
+	RequestCount int64   `json:"request_count"`
+	AvgMs        float64 `json:"avg_ms"`
+	P50Ms        float64 `json:"p50_ms"`
+	P95Ms        float64 `json:"p95_ms"`
+	P99Ms        float64 `json:"p99_ms"`
+	MaxMs        int32   `json:"max_ms"`
+}
+
+func (q *Queries) GetLatencyPercentilesByEndpoint(ctx context.Context, arg GetLatencyPercentilesByEndpointParams) ([]GetLatencyPercentilesByEndpointRow, error) {
+	rows, err := q.db.Query(ctx, getLatencyPercentilesByEndpoint, arg.OrganizationID, arg.StartTime, arg.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetLatencyPercentilesByEndpointRow{}
+	for rows.Next() {
+		var i GetLatencyPercentilesByEndpointRow
+		if err := rows.Scan(
+			&i.Endpoint,
+			&i.RequestCount,
+			&i.AvgMs,
+			&i.P50Ms,
+			&i.P95Ms,
+			&i.P99Ms,
+			&i.MaxMs,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOrganization = `-- name: GetOrganization :one
+SELECT id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id FROM organizations
+WHERE id = $1
+`
+
+func (q *Queries) GetOrganization(ctx context.Context, id uuid.UUID) (Organization, error) {
+	row := q.db.QueryRow(ctx, getOrganization, id)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Plan,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.QuotaPolicy,
+		&i.BillingCurrency,
+		&i.TrialStatus,
+		&i.TrialPlan,
+		&i.TrialStartedAt,
+		&i.TrialEndsAt,
+		&i.TrialRemindedAt,
+		&i.StripeCustomerID,
+	)
+	return i, err
+}
+
+const getOrganizationByEmail = `-- name: GetOrganizationByEmail :one
+SELECT id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id FROM organizations
+WHERE email = $1
+`
+
+func (q *Queries) GetOrganizationByEmail(ctx context.Context, email string) (Organization, error) {
+	row := q.db.QueryRow(ctx, getOrganizationByEmail, email)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Plan,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.QuotaPolicy,
+		&i.BillingCurrency,
+		&i.TrialStatus,
+		&i.TrialPlan,
+		&i.TrialStartedAt,
+		&i.TrialEndsAt,
+		&i.TrialRemindedAt,
+		&i.StripeCustomerID,
+	)
+	return i, err
+}
+
+const getOrganizationTaxProfile = `-- name: GetOrganizationTaxProfile :one
+
+SELECT organization_id, country, vat_id, tax_exempt, exemption_reason, created_at, updated_at FROM organization_tax_profiles
+WHERE organization_id = $1
+`
+
+// ============================================
+// TAX QUERIES
+// ============================================
+func (q *Queries) GetOrganizationTaxProfile(ctx context.Context, organizationID uuid.UUID) (OrganizationTaxProfile, error) {
+	row := q.db.QueryRow(ctx, getOrganizationTaxProfile, organizationID)
+	var i OrganizationTaxProfile
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Country,
+		&i.VatID,
+		&i.TaxExempt,
+		&i.ExemptionReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getOverdueBillingCycles = `-- name: GetOverdueBillingCycles :many
+SELECT 
+    bc.id, bc.organization_id, bc.period_start, bc.period_end, bc.total_requests, bc.total_amount, bc.status, bc.created_at, bc.price_book_version, bc.quote, bc.paid_at, bc.invoice_number, bc.currency, bc.fx_rate, bc.fx_rate_at, bc.kind,
+    o.name as organization_name,
+    o.email as organization_email
+FROM billing_cycles bc
+JOIN organizations o ON bc.organization_id = o.id
+WHERE bc.status = 'overdue'
+ORDER BY bc.period_end ASC
+`
+
+type GetOverdueBillingCyclesRow struct {
+	ID                uuid.UUID        `json:"id"`
+	OrganizationID    uuid.UUID        `json:"organization_id"`
+	PeriodStart       pgtype.Timestamp `json:"period_start"`
+	PeriodEnd         pgtype.Timestamp `json:"period_end"`
+	TotalRequests     int32            `json:"total_requests"`
+	TotalAmount       pgtype.Numeric   `json:"total_amount"`
+	Status            BillingStatus    `json:"status"`
+	CreatedAt         pgtype.Timestamp `json:"created_at"`
+	PriceBookVersion  *string          `json:"price_book_version"`
+	Quote             []byte           `json:"quote"`
+	PaidAt            pgtype.Timestamp `json:"paid_at"`
+	InvoiceNumber     string           `json:"invoice_number"`
+	Currency          string           `json:"currency"`
+	FxRate            pgtype.Numeric   `json:"fx_rate"`
+	FxRateAt          pgtype.Timestamp `json:"fx_rate_at"`
+	Kind              BillingCycleKind `json:"kind"`
+	OrganizationName  string           `json:"organization_name"`
+	OrganizationEmail string           `json:"organization_email"`
+}
+
+func (q *Queries) GetOverdueBillingCycles(ctx context.Context) ([]GetOverdueBillingCyclesRow, error) {
+	rows, err := q.db.Query(ctx, getOverdueBillingCycles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetOverdueBillingCyclesRow{}
+	for rows.Next() {
+		var i GetOverdueBillingCyclesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.PeriodStart,
+			&i.PeriodEnd,
+			&i.TotalRequests,
+			&i.TotalAmount,
+			&i.Status,
+			&i.CreatedAt,
+			&i.PriceBookVersion,
+			&i.Quote,
+			&i.PaidAt,
+			&i.InvoiceNumber,
+			&i.Currency,
+			&i.FxRate,
+			&i.FxRateAt,
+			&i.Kind,
+			&i.OrganizationName,
+			&i.OrganizationEmail,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPaymentMethod = `-- name: GetPaymentMethod :one
+SELECT organization_id, provider, customer_id, reference, email, brand, last4, created_at, updated_at FROM payment_methods
+WHERE organization_id = $1
+`
+
+func (q *Queries) GetPaymentMethod(ctx context.Context, organizationID uuid.UUID) (PaymentMethod, error) {
+	row := q.db.QueryRow(ctx, getPaymentMethod, organizationID)
+	var i PaymentMethod
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Provider,
+		&i.CustomerID,
+		&i.Reference,
+		&i.Email,
+		&i.Brand,
+		&i.Last4,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getPendingBillingCycles = `-- name: GetPendingBillingCycles :many
+SELECT 
+    bc.id, bc.organization_id, bc.period_start, bc.period_end, bc.total_requests, bc.total_amount, bc.status, bc.created_at, bc.price_book_version, bc.quote, bc.paid_at, bc.invoice_number, bc.currency, bc.fx_rate, bc.fx_rate_at, bc.kind,
+    o.name as organization_name,
+    o.email as organization_email
+FROM billing_cycles bc
+JOIN organizations o ON bc.organization_id = o.id
+WHERE bc.status = 'pending'
+    AND bc.period_end < NOW()
+ORDER BY bc.period_end ASC
+`
+
+type GetPendingBillingCyclesRow struct {
+	ID                uuid.UUID        `json:"id"`
+	OrganizationID    uuid.UUID        `json:"organization_id"`
+	PeriodStart       pgtype.Timestamp `json:"period_start"`
+	PeriodEnd         pgtype.Timestamp `json:"period_end"`
+	TotalRequests     int32            `json:"total_requests"`
+	TotalAmount       pgtype.Numeric   `json:"total_amount"`
+	Status            BillingStatus    `json:"status"`
+	CreatedAt         pgtype.Timestamp `json:"created_at"`
+	PriceBookVersion  *string          `json:"price_book_version"`
+	Quote             []byte           `json:"quote"`
+	PaidAt            pgtype.Timestamp `json:"paid_at"`
+	InvoiceNumber     string           `json:"invoice_number"`
+	Currency          string           `json:"currency"`
+	FxRate            pgtype.Numeric   `json:"fx_rate"`
+	FxRateAt          pgtype.Timestamp `json:"fx_rate_at"`
+	Kind              BillingCycleKind `json:"kind"`
+	OrganizationName  string           `json:"organization_name"`
+	OrganizationEmail string           `json:"organization_email"`
+}
+
+func (q *Queries) GetPendingBillingCycles(ctx context.Context) ([]GetPendingBillingCyclesRow, error) {
+	rows, err := q.db.Query(ctx, getPendingBillingCycles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetPendingBillingCyclesRow{}
+	for rows.Next() {
+		var i GetPendingBillingCyclesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.PeriodStart,
+			&i.PeriodEnd,
+			&i.TotalRequests,
+			&i.TotalAmount,
+			&i.Status,
+			&i.CreatedAt,
+			&i.PriceBookVersion,
+			&i.Quote,
+			&i.PaidAt,
+			&i.InvoiceNumber,
+			&i.Currency,
+			&i.FxRate,
+			&i.FxRateAt,
+			&i.Kind,
+			&i.OrganizationName,
+			&i.OrganizationEmail,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPendingInvitationByEmail = `-- name: GetPendingInvitationByEmail :one
+SELECT id, organization_id, email, role, invited_by, token, expires_at, accepted_at, declined_at, created_at FROM team_invitations
+WHERE organization_id = $1 
+  AND email = $2 
+  AND accepted_at IS NULL 
+  AND declined_at IS NULL
+LIMIT 1
+`
+
+type GetPendingInvitationByEmailParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Email          string    `json:"email"`
+}
+
+func (q *Queries) GetPendingInvitationByEmail(ctx context.Context, arg GetPendingInvitationByEmailParams) (TeamInvitation, error) {
+	row := q.db.QueryRow(ctx, getPendingInvitationByEmail, arg.OrganizationID, arg.Email)
+	var i TeamInvitation
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Email,
+		&i.Role,
+		&i.InvitedBy,
+		&i.Token,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.DeclinedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getPendingPlanChange = `-- name: GetPendingPlanChange :one
+SELECT id, organization_id, from_plan, to_plan, effective_at, status, requested_by, created_at, applied_at, cancelled_at FROM scheduled_plan_changes
+WHERE organization_id = $1 AND status = 'pending'
+`
+
+func (q *Queries) GetPendingPlanChange(ctx context.Context, organizationID uuid.UUID) (ScheduledPlanChange, error) {
+	row := q.db.QueryRow(ctx, getPendingPlanChange, organizationID)
+	var i ScheduledPlanChange
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.FromPlan,
+		&i.ToPlan,
+		&i.EffectiveAt,
+		&i.Status,
+		&i.RequestedBy,
+		&i.CreatedAt,
+		&i.AppliedAt,
+		&i.CancelledAt,
+	)
+	return i, err
+}
+
+const getPendingUpgradeInvoice = `-- name: GetPendingUpgradeInvoice :one
+
+SELECT bc.id, bc.organization_id, bc.period_start, bc.period_end, bc.total_requests, bc.total_amount, bc.status, bc.created_at, bc.price_book_version, bc.quote, bc.paid_at, bc.invoice_number, bc.currency, bc.fx_rate, bc.fx_rate_at, bc.kind FROM billing_cycles bc
+WHERE bc.organization_id = $1
+    AND bc.kind = 'proration'
+    AND bc.status = 'pending'
+    AND bc.quote->>'plan' = $2::text
+    AND bc.period_end = $3
+    AND NOT EXISTS (
+        SELECT 1 FROM organization_plan_history h
+        WHERE h.proration_cycle_id = bc.id
+    )
+ORDER BY bc.created_at DESC
+LIMIT 1
+`
+
+type GetPendingUpgradeInvoiceParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Plan           string           `json:"plan"`
+	PeriodEnd      pgtype.Timestamp `json:"period_end"`
+}
+
+// The invoice of an upgrade to plan in the period ending at period_end that
+// is not yet paid and applied. A retried upgrade charges it again rather
+// than issuing another.
+func (q *Queries) GetPendingUpgradeInvoice(ctx context.Context, arg GetPendingUpgradeInvoiceParams) (BillingCycle, error) {
+	row := q.db.QueryRow(ctx, getPendingUpgradeInvoice, arg.OrganizationID, arg.Plan, arg.PeriodEnd)
+	var i BillingCycle
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.PeriodStart,
+		&i.PeriodEnd,
+		&i.TotalRequests,
+		&i.TotalAmount,
+		&i.Status,
+		&i.CreatedAt,
+		&i.PriceBookVersion,
+		&i.Quote,
+		&i.PaidAt,
+		&i.InvoiceNumber,
+		&i.Currency,
+		&i.FxRate,
+		&i.FxRateAt,
+		&i.Kind,
+	)
+	return i, err
+}
+
+const getStatusCodesByEndpoint = `-- name: GetStatusCodesByEndpoint :many
+SELECT
+    endpoint,
+    status_code,
+    COUNT(*) as request_count
+FROM usage_records
+WHERE organization_id = $1
+    AND created_at >= $2::timestamp
+    AND created_at <= $3::timestamp
+GROUP BY endpoint, status_code
+ORDER BY endpoint, status_code
+`
+
+type GetStatusCodesByEndpointParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+}
+
+type GetStatusCodesByEndpointRow struct {
+	Endpoint     string `json:"endpoint"`
+	StatusCode   int32  `json:"status_code"`
+	RequestCount int64  `json:"request_count"`
+}
+
+func (q *Queries) GetStatusCodesByEndpoint(ctx context.Context, arg GetStatusCodesByEndpointParams) ([]GetStatusCodesByEndpointRow, error) {
+	rows, err := q.db.Query(ctx, getStatusCodesByEndpoint, arg.OrganizationID, arg.StartTime, arg.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetStatusCodesByEndpointRow{}
+	for rows.Next() {
+		var i GetStatusCodesByEndpointRow
+		if err := rows.Scan(&i.Endpoint, &i.StatusCode, &i.RequestCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getStripeSubscription = `-- name: GetStripeSubscription :one
+SELECT * FROM stripe_subscriptions
+WHERE organization_id = $1
+`
+
+func (q *Queries) GetStripeSubscription(ctx context.Context, organizationID uuid.UUID) (StripeSubscription, error) {
+	row := q.db.QueryRow(ctx, getStripeSubscription, organizationID)
+	var i StripeSubscription
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.SubscriptionID,
+		&i.CustomerID,
+		&i.Plan,
+		&i.Status,
+		&i.BaseItemID,
+		&i.MeteredItemID,
+		&i.CurrentPeriodStart,
+		&i.CurrentPeriodEnd,
+		&i.CancelAtPeriodEnd,
+		&i.BillingStartedAt,
+		&i.UsageReportedThrough,
+		&i.EndedAt,
+		&i.LatestInvoiceID,
+		&i.LatestInvoiceStatus,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getStripeSubscriptionByID = `-- name: GetStripeSubscriptionByID :one
+SELECT * FROM stripe_subscriptions
+WHERE subscription_id = $1
+`
+
+func (q *Queries) GetStripeSubscriptionByID(ctx context.Context, subscriptionID string) (StripeSubscription, error) {
+	row := q.db.QueryRow(ctx, getStripeSubscriptionByID, subscriptionID)
+	var i StripeSubscription
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.SubscriptionID,
+		&i.CustomerID,
+		&i.Plan,
+		&i.Status,
+		&i.BaseItemID,
+		&i.MeteredItemID,
+		&i.CurrentPeriodStart,
+		&i.CurrentPeriodEnd,
+		&i.CancelAtPeriodEnd,
+		&i.BillingStartedAt,
+		&i.UsageReportedThrough,
+		&i.EndedAt,
+		&i.LatestInvoiceID,
+		&i.LatestInvoiceStatus,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getTeamInvitationByToken = `-- name: GetTeamInvitationByToken :one
+SELECT 
+    ti.id, ti.organization_id, ti.email, ti.role, ti.invited_by, ti.token, ti.expires_at, ti.accepted_at, ti.declined_at, ti.created_at,
+    o.name as organization_name,
+    u.email as inviter_email
+FROM team_invitations ti
+JOIN organizations o ON ti.organization_id = o.id
+JOIN users u ON ti.invited_by = u.id
+WHERE ti.token = $1 
+  AND ti.accepted_at IS NULL 
+  AND ti.declined_at IS NULL 
+  AND ti.expires_at > NOW()
+LIMIT 1
+`
+
+type GetTeamInvitationByTokenRow struct {
+	ID               uuid.UUID        `json:"id"`
+	OrganizationID   uuid.UUID        `json:"organization_id"`
+	Email            string           `json:"email"`
+	Role             UserRole         `json:"role"`
+	InvitedBy        uuid.UUID        `json:"invited_by"`
+	Token            string           `json:"token"`
+	ExpiresAt        pgtype.Timestamp `json:"expires_at"`
+	AcceptedAt       pgtype.Timestamp `json:"accepted_at"`
+	DeclinedAt       pgtype.Timestamp `json:"declined_at"`
+	CreatedAt        pgtype.Timestamp `json:"created_at"`
+	OrganizationName string           `json:"organization_name"`
+	InviterEmail     string           `json:"inviter_email"`
+}
+
+func (q *Queries) GetTeamInvitationByToken(ctx context.Context, token string) (GetTeamInvitationByTokenRow, error) {
+	row := q.db.QueryRow(ctx, getTeamInvitationByToken, token)
+	var i GetTeamInvitationByTokenRow
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Email,
+		&i.Role,
+		&i.InvitedBy,
+		&i.Token,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.DeclinedAt,
+		&i.CreatedAt,
+		&i.OrganizationName,
+		&i.InviterEmail,
+	)
+	return i, err
+}
+
+const getUsageBudget = `-- name: GetUsageBudget :one
+SELECT organization_id, metric, amount, thresholds, disable_keys_at_limit, created_at, updated_at FROM usage_budgets
+WHERE organization_id = $1
+`
+
+func (q *Queries) GetUsageBudget(ctx context.Context, organizationID uuid.UUID) (UsageBudget, error) {
+	row := q.db.QueryRow(ctx, getUsageBudget, organizationID)
+	var i UsageBudget
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Metric,
+		&i.Amount,
+		&i.Thresholds,
+		&i.DisableKeysAtLimit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getUsageByAPIKey = `-- name: GetUsageByAPIKey :many
+WITH bounds AS (
+    SELECT
+        date_trunc('hour', $1::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', $2::timestamp + interval '1 microsecond'), rolled_up_to) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), raw_ranges AS (
+    SELECT $1::timestamp AS raw_from,
+        LEAST(hours_from, $2::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), $2::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT h.api_key_id, h.request_count
+    FROM usage_rollups_hourly h, bounds b
+    WHERE h.organization_id = $3
+        AND h.bucket >= b.hours_from
+        AND h.bucket < b.hours_until
+    UNION ALL
+    SELECT ur.api_key_id, 1::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = $3
+)
+SELECT
+    ak.id,
+    ak.name,
+    ak.key,
+    COALESCE(SUM(u.request_count), 0)::bigint as request_count
+FROM api_keys ak
+LEFT JOIN usage u ON ak.id = u.api_key_id
+WHERE ak.organization_id = $3
+GROUP BY ak.id, ak.name, ak.key
+ORDER BY request_count DESC
+`
+
+type GetUsageByAPIKeyParams struct {
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+	OrganizationID uuid.UUID        `json:"organization_id"`
+}
+
+type GetUsageByAPIKeyRow struct {
+	ID           uuid.UUID `json:"id"`
+	Name         string    `json:"name"`
+	Key          string    `json:"key"`
+	RequestCount int64     `json:"request_count"`
+}
+
+func (q *Queries) GetUsageByAPIKey(ctx context.Context, arg GetUsageByAPIKeyParams) ([]GetUsageByAPIKeyRow, error) {
+	rows, err := q.db.Query(ctx, getUsageByAPIKey, arg.StartTime, arg.EndTime, arg.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetUsageByAPIKeyRow{}
+	for rows.Next() {
+		var i GetUsageByAPIKeyRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Key,
+			&i.RequestCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUsageByEndpoint = `-- name: GetUsageByEndpoint :many
+WITH bounds AS (
+    SELECT
+        date_trunc('hour', $1::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', $2::timestamp + interval '1 microsecond'), rolled_up_to) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), raw_ranges AS (
+    SELECT $1::timestamp AS raw_from,
+        LEAST(hours_from, $2::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), $2::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT h.endpoint, h.status_class, h.request_count
+    FROM usage_rollups_hourly h, bounds b
+    WHERE h.organization_id = $3
+        AND h.bucket >= b.hours_from
+        AND h.bucket < b.hours_until
+    UNION ALL
+    SELECT ur.endpoint, (ur.status_code / 100)::smallint, 1::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = $3
+)
+SELECT
+    endpoint,
+    SUM(request_count)::bigint as request_count,
+    COALESCE(SUM(request_count) FILTER (WHERE status_class = 2), 0)::bigint as success_count,
+    COALESCE(SUM(request_count) FILTER (WHERE status_class >= 4), 0)::bigint as error_count
+FROM usage
+GROUP BY endpoint
+ORDER BY request_count DESC
+`
+
+type GetUsageByEndpointParams struct {
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+	OrganizationID uuid.UUID        `json:"organization_id"`
+}
+
+type GetUsageByEndpointRow struct {
+	Endpoint     string `json:"endpoint"`
+	RequestCount int64  `json:"request_count"`
+	SuccessCount int64  `json:"success_count"`
+	ErrorCount   int64  `json:"error_count"`
+}
+
+func (q *Queries) GetUsageByEndpoint(ctx context.Context, arg GetUsageByEndpointParams) ([]GetUsageByEndpointRow, error) {
+	rows, err := q.db.Query(ctx, getUsageByEndpoint, arg.StartTime, arg.EndTime, arg.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetUsageByEndpointRow{}
+	for rows.Next() {
+		var i GetUsageByEndpointRow
+		if err := rows.Scan(
+			&i.Endpoint,
+			&i.RequestCount,
+			&i.SuccessCount,
+			&i.ErrorCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUsageByMessageType = `-- name: GetUsageByMessageType :many
+WITH bounds AS (
+    SELECT
+        date_trunc('hour', $1::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', $2::timestamp + interval '1 microsecond'), rolled_up_to) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), raw_ranges AS (
+    SELECT $1::timestamp AS raw_from,
+        LEAST(hours_from, $2::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), $2::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT h.message_type, h.request_count, h.units
+    FROM usage_rollups_hourly h, bounds b
+    WHERE h.organization_id = $3
+        AND h.bucket >= b.hours_from
+        AND h.bucket < b.hours_until
+    UNION ALL
+    SELECT COALESCE(ur.message_type, ''), 1::bigint, ur.units::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = $3
+)
+SELECT
+    message_type::text as message_type,
+    SUM(request_count)::bigint as request_count,
+    SUM(units)::bigint as units
+FROM usage
+GROUP BY message_type
+ORDER BY message_type
+`
+
+type GetUsageByMessageTypeParams struct {
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+	OrganizationID uuid.UUID        `json:"organization_id"`
+}
+
+type GetUsageByMessageTypeRow struct {
+	MessageType  string `json:"message_type"`
+	RequestCount int64  `json:"request_count"`
+	Units        int64  `json:"units"`
+}
+
+func (q *Queries) GetUsageByMessageType(ctx context.Context, arg GetUsageByMessageTypeParams) ([]GetUsageByMessageTypeRow, error) {
+	rows, err := q.db.Query(ctx, getUsageByMessageType, arg.StartTime, arg.EndTime, arg.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetUsageByMessageTypeRow{}
+	for rows.Next() {
+		var i GetUsageByMessageTypeRow
+		if err := rows.Scan(&i.MessageType, &i.RequestCount, &i.Units); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUsageExport = `-- name: GetUsageExport :one
+SELECT id, organization_id, requested_by, format, start_time, end_time, status, row_count, file_size, file_path, error, created_at, started_at, completed_at, expires_at FROM usage_exports
+WHERE id = $1
+`
+
+func (q *Queries) GetUsageExport(ctx context.Context, id uuid.UUID) (UsageExport, error) {
+	row := q.db.QueryRow(ctx, getUsageExport, id)
+	var i UsageExport
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.RequestedBy,
+		&i.Format,
+		&i.StartTime,
+		&i.EndTime,
+		&i.Status,
+		&i.RowCount,
+		&i.FileSize,
+		&i.FilePath,
+		&i.Error,
+		&i.CreatedAt,
+		&i.StartedAt,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getUsageRecord = `-- name: GetUsageRecord :one
+SELECT id, organization_id, api_key_id, endpoint, method, status_code, created_at, duration_ms, request_bytes, response_bytes, client_ip, user_agent, request_id, message_type, units FROM usage_records
+WHERE id = $1
+`
+
+func (q *Queries) GetUsageRecord(ctx context.Context, id uuid.UUID) (UsageRecord, error) {
+	row := q.db.QueryRow(ctx, getUsageRecord, id)
+	var i UsageRecord
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.ApiKeyID,
+		&i.Endpoint,
+		&i.Method,
+		&i.StatusCode,
+		&i.CreatedAt,
+		&i.DurationMs,
+		&i.RequestBytes,
+		&i.ResponseBytes,
+		&i.ClientIp,
+		&i.UserAgent,
+		&i.RequestID,
+		&i.MessageType,
+		&i.Units,
+	)
+	return i, err
+}
+
+const getUsageRolledUpTo = `-- name: GetUsageRolledUpTo :one
+
+SELECT rolled_up_to FROM usage_rollup_state
+WHERE id = 1
+`
+
+// The hour up to which usage has been rolled up, read without waiting on a
+// rollup in progress
+func (q *Queries) GetUsageRolledUpTo(ctx context.Context) (pgtype.Timestamp, error) {
+	row := q.db.QueryRow(ctx, getUsageRolledUpTo)
+	var rolled_up_to pgtype.Timestamp
+	err := row.Scan(&rolled_up_to)
+	return rolled_up_to, err
+}
+
+const getUsageRollupWatermark = `-- name: GetUsageRollupWatermark :one
+
+SELECT rolled_up_to FROM usage_rollup_state
+WHERE id = 1
+FOR UPDATE
+`
+
+// ============================================
+// USAGE ROLLUP QUERIES
+// ============================================
+func (q *Queries) GetUsageRollupWatermark(ctx context.Context) (pgtype.Timestamp, error) {
+	row := q.db.QueryRow(ctx, getUsageRollupWatermark)
+	var rolled_up_to pgtype.Timestamp
+	err := row.Scan(&rolled_up_to)
+	return rolled_up_to, err
+}
+
+const getUsageTimeseries = `-- name: GetUsageTimeseries :many
+WITH bounds AS (
+    SELECT
+        date_trunc('hour', $1::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', $2::timestamp + interval '1 microsecond'), rolled_up_to, $3::timestamp) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), raw_ranges AS (
+    SELECT $1::timestamp AS raw_from,
+        LEAST(hours_from, $2::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), $2::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT h.bucket, h.api_key_id, h.endpoint, h.status_class, h.request_count, h.units
+    FROM usage_rollups_hourly h, bounds b
+    WHERE h.organization_id = $4
+        AND h.bucket >= b.hours_from
+        AND h.bucket < b.hours_until
+    UNION ALL
+    SELECT date_trunc('minute', ur.created_at), ur.api_key_id, ur.endpoint, (ur.status_code / 100)::smallint, 1::bigint, ur.units::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = $4
+)
+SELECT
+    date_trunc($5::text, (bucket AT TIME ZONE 'UTC') AT TIME ZONE $6::text)::timestamp as bucket,
+    (CASE $7::text
+        WHEN 'endpoint' THEN endpoint
+        WHEN 'api_key' THEN api_key_id::text
+        WHEN 'status_class' THEN status_class || 'xx'
+        ELSE ''
+    END)::text as group_key,
+    SUM(request_count)::bigint as request_count,
+    COALESCE(SUM(request_count) FILTER (WHERE status_class >= 4), 0)::bigint as error_count,
+    SUM(units)::bigint as units
+FROM usage
+WHERE ($8::text IS NULL OR endpoint = $8::text)
+    AND ($9::uuid IS NULL OR api_key_id = $9::uuid)
+    AND ($10::smallint IS NULL OR status_class = $10::smallint)
+GROUP BY 1, 2
+ORDER BY 1, 2
+`
+
+type GetUsageTimeseriesParams struct {
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+	RawSince       pgtype.Timestamp `json:"raw_since"`
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Granularity    string           `json:"granularity"`
+	Tz             string           `json:"tz"`
+	GroupBy        string           `json:"group_by"`
+	Endpoint       *string          `json:"endpoint"`
+	ApiKeyID       pgtype.UUID      `json:"api_key_id"`
+	StatusClass    *int16           `json:"status_class"`
+}
+
+type GetUsageTimeseriesRow struct {
+	Bucket       pgtype.Timestamp `json:"bucket"`
+	GroupKey     string           `json:"group_key"`
+	RequestCount int64            `json:"request_count"`
+	ErrorCount   int64            `json:"error_count"`
+	Units        int64            `json:"units"`
+}
+
+func (q *Queries) GetUsageTimeseries(ctx context.Context, arg GetUsageTimeseriesParams) ([]GetUsageTimeseriesRow, error) {
+	rows, err := q.db.Query(ctx, getUsageTimeseries,
+		arg.StartTime,
+		arg.EndTime,
+		arg.RawSince,
+		arg.OrganizationID,
+		arg.Granularity,
+		arg.Tz,
+		arg.GroupBy,
+		arg.Endpoint,
+		arg.ApiKeyID,
+		arg.StatusClass,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetUsageTimeseriesRow{}
+	for rows.Next() {
+		var i GetUsageTimeseriesRow
+		if err := rows.Scan(
+			&i.Bucket,
+			&i.GroupKey,
+			&i.RequestCount,
+			&i.ErrorCount,
+			&i.Units,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, organization_id, email, password_hash, role, created_at, email_verified, email_verified_at FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Role,
+		&i.CreatedAt,
+		&i.EmailVerified,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, organization_id, email, password_hash, role, created_at, email_verified, email_verified_at FROM users
+WHERE email = $1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Role,
+		&i.CreatedAt,
+		&i.EmailVerified,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
+
+const getUserWithOrganization = `-- name: GetUserWithOrganization :one
+SELECT 
+    u.id, u.organization_id, u.email, u.password_hash, u.role, u.created_at, u.email_verified, u.email_verified_at,
+    o.name as organization_name,
+    o.plan as organization_plan
+FROM users u
+JOIN organizations o ON u.organization_id = o.id
+WHERE u.id = $1
+`
+
+type GetUserWithOrganizationRow struct {
+	ID               uuid.UUID        `json:"id"`
+	OrganizationID   uuid.UUID        `json:"organization_id"`
+	Email            string           `json:"email"`
+	PasswordHash     string           `json:"password_hash"`
+	Role             UserRole         `json:"role"`
+	CreatedAt        pgtype.Timestamp `json:"created_at"`
+	EmailVerified    bool             `json:"email_verified"`
+	EmailVerifiedAt  pgtype.Timestamp `json:"email_verified_at"`
+	OrganizationName string           `json:"organization_name"`
+	OrganizationPlan PlanType         `json:"organization_plan"`
+}
+
+func (q *Queries) GetUserWithOrganization(ctx context.Context, id uuid.UUID) (GetUserWithOrganizationRow, error) {
+	row := q.db.QueryRow(ctx, getUserWithOrganization, id)
+	var i GetUserWithOrganizationRow
+	err := row.Scan(
+		&i.ID,
+		&i.OrganizationID,
+		&i.Email,
+		&i.PasswordHash,
+		&i.Role,
+		&i.CreatedAt,
+		&i.EmailVerified,
+		&i.EmailVerifiedAt,
+		&i.OrganizationName,
+		&i.OrganizationPlan,
+	)
+	return i, err
+}
+
+const getWebhookEndpoint = `-- name: GetWebhookEndpoint :one
+SELECT organization_id, url, secret, created_at, updated_at FROM webhook_endpoints
+WHERE organization_id = $1
+`
+
+func (q *Queries) GetWebhookEndpoint(ctx context.Context, organizationID uuid.UUID) (WebhookEndpoint, error) {
+	row := q.db.QueryRow(ctx, getWebhookEndpoint, organizationID)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Url,
+		&i.Secret,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const listCoupons = `-- name: ListCoupons :many
+SELECT id, code, name, discount_type, percent_off, amount_off, currency, duration, duration_months, plans, max_redemptions, times_redeemed, redeem_by, active, created_at, updated_at FROM coupons
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListCoupons(ctx context.Context) ([]Coupon, error) {
+	rows, err := q.db.Query(ctx, listCoupons)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Coupon{}
+	for rows.Next() {
+		var i Coupon
+		if err := rows.Scan(
+			&i.ID,
+			&i.Code,
+			&i.Name,
+			&i.DiscountType,
+			&i.PercentOff,
+			&i.AmountOff,
+			&i.Currency,
+			&i.Duration,
+			&i.DurationMonths,
+			&i.Plans,
+			&i.MaxRedemptions,
+			&i.TimesRedeemed,
+			&i.RedeemBy,
+			&i.Active,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCreditTransactions = `-- name: ListCreditTransactions :many
+SELECT id, organization_id, type, currency, amount, description, top_up_id, billing_cycle_id, created_at FROM credit_transactions
+WHERE organization_id = $1
+ORDER BY created_at DESC
+LIMIT $2
+`
+
+type ListCreditTransactionsParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Limit          int32     `json:"limit"`
+}
+
+func (q *Queries) ListCreditTransactions(ctx context.Context, arg ListCreditTransactionsParams) ([]CreditTransaction, error) {
+	rows, err := q.db.Query(ctx, listCreditTransactions, arg.OrganizationID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CreditTransaction{}
+	for rows.Next() {
+		var i CreditTransaction
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.Type,
+			&i.Currency,
+			&i.Amount,
+			&i.Description,
+			&i.TopUpID,
+			&i.BillingCycleID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCreditWallets = `-- name: ListCreditWallets :many
+SELECT
+    cw.organization_id, cw.low_balance_threshold, cw.block_at_zero, cw.low_balance_notified_at, cw.blocked_at, cw.created_at, cw.updated_at,
+    o.name as org_name,
+    o.plan as org_plan,
+    o.billing_currency as org_billing_currency
+FROM credit_wallets cw
+JOIN organizations o ON cw.organization_id = o.id
+ORDER BY cw.organization_id
+`
+
+type ListCreditWalletsRow struct {
+	OrganizationID       uuid.UUID        `json:"organization_id"`
+	LowBalanceThreshold  pgtype.Numeric   `json:"low_balance_threshold"`
+	BlockAtZero          bool             `json:"block_at_zero"`
+	LowBalanceNotifiedAt pgtype.Timestamp `json:"low_balance_notified_at"`
+	BlockedAt            pgtype.Timestamp `json:"blocked_at"`
+	CreatedAt            pgtype.Timestamp `json:"created_at"`
+	UpdatedAt            pgtype.Timestamp `json:"updated_at"`
+	OrgName              string           `json:"org_name"`
+	OrgPlan              PlanType         `json:"org_plan"`
+	OrgBillingCurrency   string           `json:"org_billing_currency"`
+}
+
+func (q *Queries) ListCreditWallets(ctx context.Context) ([]ListCreditWalletsRow, error) {
+	rows, err := q.db.Query(ctx, listCreditWallets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListCreditWalletsRow{}
+	for rows.Next() {
+		var i ListCreditWalletsRow
+		if err := rows.Scan(
+			&i.OrganizationID,
+			&i.LowBalanceThreshold,
+			&i.BlockAtZero,
+			&i.LowBalanceNotifiedAt,
+			&i.BlockedAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.OrgName,
+			&i.OrgPlan,
+			&i.OrgBillingCurrency,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDueOutboundEvents = `-- name: ListDueOutboundEvents :many
+SELECT
+    e.id,
+    e.organization_id,
+    e.event_type,
+    e.payload,
+    e.attempts,
+    e.created_at,
+    w.url,
+    w.secret
+FROM outbound_events e
+JOIN webhook_endpoints w ON e.organization_id = w.organization_id
+WHERE e.status = 'pending' AND e.next_attempt_at <= NOW()
+ORDER BY e.next_attempt_at
+LIMIT $1
+`
+
+type ListDueOutboundEventsRow struct {
+	ID             uuid.UUID        `json:"id"`
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	EventType      string           `json:"event_type"`
+	Payload        []byte           `json:"payload"`
+	Attempts       int32            `json:"attempts"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	Url            string           `json:"url"`
+	Secret         string           `json:"secret"`
+}
+
+func (q *Queries) ListDueOutboundEvents(ctx context.Context, limit int32) ([]ListDueOutboundEventsRow, error) {
+	rows, err := q.db.Query(ctx, listDueOutboundEvents, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListDueOutboundEventsRow{}
+	for rows.Next() {
+		var i ListDueOutboundEventsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.EventType,
+			&i.Payload,
+			&i.Attempts,
+			&i.CreatedAt,
+			&i.Url,
+			&i.Secret,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDuePlanChanges = `-- name: ListDuePlanChanges :many
+SELECT id, organization_id, from_plan, to_plan, effective_at, status, requested_by, created_at, applied_at, cancelled_at FROM scheduled_plan_changes
+WHERE status = 'pending' AND effective_at <= $1
+ORDER BY effective_at
+`
+
+func (q *Queries) ListDuePlanChanges(ctx context.Context, effectiveAt pgtype.Timestamp) ([]ScheduledPlanChange, error) {
+	rows, err := q.db.Query(ctx, listDuePlanChanges, effectiveAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ScheduledPlanChange{}
+	for rows.Next() {
+		var i ScheduledPlanChange
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.FromPlan,
+			&i.ToPlan,
+			&i.EffectiveAt,
+			&i.Status,
+			&i.RequestedBy,
+			&i.CreatedAt,
+			&i.AppliedAt,
+			&i.CancelledAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEndedTrials = `-- name: ListEndedTrials :many
+SELECT id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id FROM organizations
+WHERE trial_status = 'active' AND trial_ends_at <= $1
+ORDER BY trial_ends_at
+`
+
+func (q *Queries) ListEndedTrials(ctx context.Context, trialEndsAt pgtype.Timestamp) ([]Organization, error) {
+	rows, err := q.db.Query(ctx, listEndedTrials, trialEndsAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Organization{}
+	for rows.Next() {
+		var i Organization
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.Plan,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.QuotaPolicy,
+			&i.BillingCurrency,
+			&i.TrialStatus,
+			&i.TrialPlan,
+			&i.TrialStartedAt,
+			&i.TrialEndsAt,
+			&i.TrialRemindedAt,
+			&i.StripeCustomerID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExpiredUsageExports = `-- name: ListExpiredUsageExports :many
+SELECT id, organization_id, requested_by, format, start_time, end_time, status, row_count, file_size, file_path, error, created_at, started_at, completed_at, expires_at FROM usage_exports
+WHERE status = 'completed' AND expires_at <= NOW()
+`
+
+func (q *Queries) ListExpiredUsageExports(ctx context.Context) ([]UsageExport, error) {
+	rows, err := q.db.Query(ctx, listExpiredUsageExports)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []UsageExport{}
+	for rows.Next() {
+		var i UsageExport
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.RequestedBy,
+			&i.Format,
+			&i.StartTime,
+			&i.EndTime,
+			&i.Status,
+			&i.RowCount,
+			&i.FileSize,
+			&i.FilePath,
+			&i.Error,
+			&i.CreatedAt,
+			&i.StartedAt,
+			&i.CompletedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFXRates = `-- name: ListFXRates :many
+
+SELECT base_currency, quote_currency, rate, effective_at, source, created_at FROM fx_rates
+ORDER BY base_currency, quote_currency, effective_at
+`
+
+// ============================================
+// FX RATE QUERIES
+// ============================================
+func (q *Queries) ListFXRates(ctx context.Context) ([]FxRate, error) {
+	rows, err := q.db.Query(ctx, listFXRates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []FxRate{}
+	for rows.Next() {
+		var i FxRate
+		if err := rows.Scan(
+			&i.BaseCurrency,
+			&i.QuoteCurrency,
+			&i.Rate,
+			&i.EffectiveAt,
+			&i.Source,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listHourlyUnits = `-- name: ListHourlyUnits :many
+
+SELECT bucket, SUM(units)::BIGINT AS units
+FROM usage_rollups_hourly
+WHERE organization_id = $1
+    AND bucket >= $2
+    AND bucket < $3
+GROUP BY bucket
+ORDER BY bucket
+`
+
+type ListHourlyUnitsParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	StartTime      pgtype.Timestamp `json:"start_time"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+}
+
+type ListHourlyUnitsRow struct {
+	Bucket pgtype.Timestamp `json:"bucket"`
+	Units  int64            `json:"units"`
+}
+
+// Billable units an organization used in each hour between start and end
+func (q *Queries) ListHourlyUnits(ctx context.Context, arg ListHourlyUnitsParams) ([]ListHourlyUnitsRow, error) {
+	rows, err := q.db.Query(ctx, listHourlyUnits, arg.OrganizationID, arg.StartTime, arg.EndTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListHourlyUnitsRow{}
+	for rows.Next() {
+		var i ListHourlyUnitsRow
+		if err := rows.Scan(&i.Bucket, &i.Units); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listInvoiceLineItems = `-- name: ListInvoiceLineItems :many
+SELECT id, billing_cycle_id, position, type, description, quantity, unit_price, amount, created_at FROM invoice_line_items
+WHERE billing_cycle_id = ANY($1::uuid[])
+ORDER BY billing_cycle_id, position
+`
+
+func (q *Queries) ListInvoiceLineItems(ctx context.Context, billingCycleIds []uuid.UUID) ([]InvoiceLineItem, error) {
+	rows, err := q.db.Query(ctx, listInvoiceLineItems, billingCycleIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []InvoiceLineItem{}
+	for rows.Next() {
+		var i InvoiceLineItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.BillingCycleID,
+			&i.Position,
+			&i.Type,
+			&i.Description,
+			&i.Quantity,
+			&i.UnitPrice,
+			&i.Amount,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMeteredStripeSubscriptions = `-- name: ListMeteredStripeSubscriptions :many
+
+SELECT * FROM stripe_subscriptions
+WHERE metered_item_id IS NOT NULL
+    AND billing_started_at IS NOT NULL
+    AND status IN ('active', 'trialing', 'past_due', 'unpaid')
+ORDER BY organization_id
+`
+
+// Subscriptions Stripe is billing that have a metered item to report usage on
+func (q *Queries) ListMeteredStripeSubscriptions(ctx context.Context) ([]StripeSubscription, error) {
+	rows, err := q.db.Query(ctx, listMeteredStripeSubscriptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []StripeSubscription{}
+	for rows.Next() {
+		var i StripeSubscription
+		if err := rows.Scan(
+			&i.OrganizationID,
+			&i.SubscriptionID,
+			&i.CustomerID,
+			&i.Plan,
+			&i.Status,
+			&i.BaseItemID,
+			&i.MeteredItemID,
+			&i.CurrentPeriodStart,
+			&i.CurrentPeriodEnd,
+			&i.CancelAtPeriodEnd,
+			&i.BillingStartedAt,
+			&i.UsageReportedThrough,
+			&i.EndedAt,
+			&i.LatestInvoiceID,
+			&i.LatestInvoiceStatus,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrganizationAPIKeys = `-- name: ListOrganizationAPIKeys :many
+SELECT id, organization_id, key, name, is_active, created_at, last_used_at, essential, suspended_at FROM api_keys
+WHERE organization_id = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListOrganizationAPIKeys(ctx context.Context, organizationID uuid.UUID) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, listOrganizationAPIKeys, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ApiKey{}
+	for rows.Next() {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.Key,
+			&i.Name,
+			&i.IsActive,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.Essential,
+			&i.SuspendedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrganizationAdminEmails = `-- name: ListOrganizationAdminEmails :many
+SELECT email FROM users
+WHERE organization_id = $1 AND role IN ('owner', 'admin')
+ORDER BY created_at
+`
+
+func (q *Queries) ListOrganizationAdminEmails(ctx context.Context, organizationID uuid.UUID) ([]string, error) {
+	rows, err := q.db.Query(ctx, listOrganizationAdminEmails, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		items = append(items, email)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrganizationBillingCycles = `-- name: ListOrganizationBillingCycles :many
+SELECT id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at, price_book_version, quote, paid_at, invoice_number, currency, fx_rate, fx_rate_at, kind FROM billing_cycles
+WHERE organization_id = $1
+ORDER BY period_start DESC
+LIMIT $2 OFFSET $3
+`
+
+type ListOrganizationBillingCyclesParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Limit          int32     `json:"limit"`
+	Offset         int32     `json:"offset"`
+}
+
+func (q *Queries) ListOrganizationBillingCycles(ctx context.Context, arg ListOrganizationBillingCyclesParams) ([]BillingCycle, error) {
+	rows, err := q.db.Query(ctx, listOrganizationBillingCycles, arg.OrganizationID, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []BillingCycle{}
+	for rows.Next() {
+		var i BillingCycle
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.PeriodStart,
+			&i.PeriodEnd,
+			&i.TotalRequests,
+			&i.TotalAmount,
+			&i.Status,
+			&i.CreatedAt,
+			&i.PriceBookVersion,
+			&i.Quote,
+			&i.PaidAt,
+			&i.InvoiceNumber,
+			&i.Currency,
+			&i.FxRate,
+			&i.FxRateAt,
+			&i.Kind,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOrganizationInvitations = `-- name: ListOrganizationInvitations :many
 SELECT 
-    bc.id, bc.organization_id, bc.period_start, bc.period_end, bc.total_requests, bc.total_amount, bc.status, bc.created_at,
-    o.name as organization_name,
-    o.email as organization_email
-FROM billing_cycles bc
-JOIN organizations o ON bc.organization_id = o.id
-WHERE bc.status = 'overdue'
-ORDER BY bc.period_end ASC
+    ti.id, ti.organization_id, ti.email, ti.role, ti.invited_by, ti.token, ti.expires_at, ti.accepted_at, ti.declined_at, ti.created_at,
+    u.email as inviter_email
+FROM team_invitations ti
+JOIN users u ON ti.invited_by = u.id
+WHERE ti.organization_id = $1
+ORDER BY ti.created_at DESC
 `
 
-type GetOverdueBillingCyclesRow struct {
-	ID                uuid.UUID        `json:"id"`
-	OrganizationID    uuid.UUID        `json:"organization_id"`
-	PeriodStart       pgtype.Timestamp `json:"period_start"`
-	PeriodEnd         pgtype.Timestamp `json:"period_end"`
-	TotalRequests     int32            `json:"total_requests"`
-	TotalAmount       pgtype.Numeric   `json:"total_amount"`
-	Status            BillingStatus    `json:"status"`
-	CreatedAt         pgtype.Timestamp `json:"created_at"`
-	OrganizationName  string           `json:"organization_name"`
-	OrganizationEmail string           `json:"organization_email"`
+type ListOrganizationInvitationsRow struct {
+	ID             uuid.UUID        `json:"id"`
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Email          string           `json:"email"`
+	Role           UserRole         `json:"role"`
+	InvitedBy      uuid.UUID        `json:"invited_by"`
+	Token          string           `json:"token"`
+	ExpiresAt      pgtype.Timestamp `json:"expires_at"`
+	AcceptedAt     pgtype.Timestamp `json:"accepted_at"`
+	DeclinedAt     pgtype.Timestamp `json:"declined_at"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	InviterEmail   string           `json:"inviter_email"`
 }
 
-func (q *Queries) GetOverdueBillingCycles(ctx context.Context) ([]GetOverdueBillingCyclesRow, error) {
-	rows, err := q.db.Query(ctx, getOverdueBillingCycles)
+func (q *Queries) ListOrganizationInvitations(ctx context.Context, organizationID uuid.UUID) ([]ListOrganizationInvitationsRow, error) {
+	rows, err := q.db.Query(ctx, listOrganizationInvitations, organizationID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []GetOverdueBillingCyclesRow{}
+	items := []ListOrganizationInvitationsRow{}
 	for rows.Next() {
-		var i GetOverdueBillingCyclesRow
+		var i ListOrganizationInvitationsRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.OrganizationID,
-			&i.PeriodStart,
-			&i.PeriodEnd,
-			&i.TotalRequests,
-			&i.TotalAmount,
-			&i.Status,
+			&i.Email,
+			&i.Role,
+			&i.InvitedBy,
+			&i.Token,
+			&i.ExpiresAt,
+			&i.AcceptedAt,
+			&i.DeclinedAt,
 			&i.CreatedAt,
-			&i.OrganizationName,
-			&i.OrganizationEmail,
+			&i.InviterEmail,
 		); err != nil {
 			return nil, err
 		}
@@ -742,51 +3909,41 @@ func (q *Queries) GetOverdueBillingCycles(ctx context.Context) ([]GetOverdueBill
 	return items, nil
 }
 
-const getPendingBillingCycles = `-- name: GetPendingBillingCycles :many
+const listOrganizationMembers = `-- name: ListOrganizationMembers :many
 SELECT 
-    bc.id, bc.organization_id, bc.period_start, bc.period_end, bc.total_requests, bc.total_amount, bc.status, bc.created_at,
-    o.name as organization_name,
-    o.email as organization_email
-FROM billing_cycles bc
-JOIN organizations o ON bc.organization_id = o.id
-WHERE bc.status = 'pending'
-    AND bc.period_end < NOW()
-ORDER BY bc.period_end ASC
+    u.id,
+    u.email,
+    u.role,
+    u.email_verified,
+    u.created_at
+FROM users u
+WHERE u.organization_id = $1
+ORDER BY u.created_at DESC
 `
 
-type GetPendingBillingCyclesRow struct {
-	ID                uuid.UUID        `json:"id"`
-	OrganizationID    uuid.UUID        `json:"organization_id"`
-	PeriodStart       pgtype.Timestamp `json:"period_start"`
-	PeriodEnd         pgtype.Timestamp `json:"period_end"`
-	TotalRequests     int32            `json:"total_requests"`
-	TotalAmount       pgtype.Numeric   `json:"total_amount"`
-	Status            BillingStatus    `json:"status"`
-	CreatedAt         pgtype.Timestamp `json:"created_at"`
-	OrganizationName  string           `json:"organization_name"`
-	OrganizationEmail string           `json:"organization_email"`
+type ListOrganizationMembersRow struct {
+	ID            uuid.UUID        `json:"id"`
+	Email         string           `json:"email"`
+	Role          UserRole         `json:"role"`
+	EmailVerified bool             `json:"email_verified"`
+	CreatedAt     pgtype.Timestamp `json:"created_at"`
 }
 
-func (q *Queries) GetPendingBillingCycles(ctx context.Context) ([]GetPendingBillingCyclesRow, error) {
-	rows, err := q.db.Query(ctx, getPendingBillingCycles)
+func (q *Queries) ListOrganizationMembers(ctx context.Context, organizationID uuid.UUID) ([]ListOrganizationMembersRow, error) {
+	rows, err := q.db.Query(ctx, listOrganizationMembers, organizationID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []GetPendingBillingCyclesRow{}
+	items := []ListOrganizationMembersRow{}
 	for rows.Next() {
-		var i GetPendingBillingCyclesRow
+		var i ListOrganizationMembersRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.OrganizationID,
-			&i.PeriodStart,
-			&i.PeriodEnd,
-			&i.TotalRequests,
-			&i.TotalAmount,
-			&i.Status,
+			&i.Email,
+			&i.Role,
+			&i.EmailVerified,
 			&i.CreatedAt,
-			&i.OrganizationName,
-			&i.OrganizationEmail,
 		); err != nil {
 			return nil, err
 		}
@@ -798,130 +3955,196 @@ func (q *Queries) GetPendingBillingCycles(ctx context.Context) ([]GetPendingBill
 	return items, nil
 }
 
-const getPendingInvitationByEmail = `-- name: GetPendingInvitationByEmail :one
-SELECT id, organization_id, email, role, invited_by, token, expires_at, accepted_at, declined_at, created_at FROM team_invitations
-WHERE organization_id = $1 
-  AND email = $2 
-  AND accepted_at IS NULL 
-  AND declined_at IS NULL
-LIMIT 1
+const listOrganizationOutboundEvents = `-- name: ListOrganizationOutboundEvents :many
+SELECT id, organization_id, event_type, payload, status, attempts, next_attempt_at, last_error, created_at, delivered_at FROM outbound_events
+WHERE organization_id = $1
+ORDER BY created_at DESC
+LIMIT $2
 `
 
-type GetPendingInvitationByEmailParams struct {
+type ListOrganizationOutboundEventsParams struct {
 	OrganizationID uuid.UUID `json:"organization_id"`
-	Email          string    `json:"email"`
+	Limit          int32     `json:"limit"`
 }
 
-func (q *Queries) GetPendingInvitationByEmail(ctx context.Context, arg GetPendingInvitationByEmailParams) (TeamInvitation, error) {
-	row := q.db.QueryRow(ctx, getPendingInvitationByEmail, arg.OrganizationID, arg.Email)
-	var i TeamInvitation
-	err := row.Scan(
-		&i.ID,
-		&i.OrganizationID,
-		&i.Email,
-		&i.Role,
-		&i.InvitedBy,
-		&i.Token,
-		&i.ExpiresAt,
-		&i.AcceptedAt,
-		&i.DeclinedAt,
-		&i.CreatedAt,
-	)
-	return i, err
+func (q *Queries) ListOrganizationOutboundEvents(ctx context.Context, arg ListOrganizationOutboundEventsParams) ([]OutboundEvent, error) {
+	rows, err := q.db.Query(ctx, listOrganizationOutboundEvents, arg.OrganizationID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []OutboundEvent{}
+	for rows.Next() {
+		var i OutboundEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.DeliveredAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getTeamInvitationByToken = `-- name: GetTeamInvitationByToken :one
-SELECT 
-    ti.id, ti.organization_id, ti.email, ti.role, ti.invited_by, ti.token, ti.expires_at, ti.accepted_at, ti.declined_at, ti.created_at,
-    o.name as organization_name,
-    u.email as inviter_email
-FROM team_invitations ti
-JOIN organizations o ON ti.organization_id = o.id
-JOIN users u ON ti.invited_by = u.id
-WHERE ti.token = $1 
-  AND ti.accepted_at IS NULL 
-  AND ti.declined_at IS NULL 
-  AND ti.expires_at > NOW()
-LIMIT 1
+const listOrganizationPlanHistory = `-- name: ListOrganizationPlanHistory :many
+
+SELECT id, organization_id, plan, started_at, ended_at, prepaid_until, proration_cycle_id, changed_by, created_at, trial, billed_by_stripe FROM organization_plan_history
+WHERE organization_id = $1
+    AND started_at < $2
+    AND (ended_at IS NULL OR ended_at > $3)
+ORDER BY started_at
 `
 
-type GetTeamInvitationByTokenRow struct {
-	ID               uuid.UUID        `json:"id"`
-	OrganizationID   uuid.UUID        `json:"organization_id"`
-	Email            string           `json:"email"`
-	Role             UserRole         `json:"role"`
-	InvitedBy        uuid.UUID        `json:"invited_by"`
-	Token            string           `json:"token"`
-	ExpiresAt        pgtype.Timestamp `json:"expires_at"`
-	AcceptedAt       pgtype.Timestamp `json:"accepted_at"`
-	DeclinedAt       pgtype.Timestamp `json:"declined_at"`
-	CreatedAt        pgtype.Timestamp `json:"created_at"`
-	OrganizationName string           `json:"organization_name"`
-	InviterEmail     string           `json:"inviter_email"`
+type ListOrganizationPlanHistoryParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+	StartTime      pgtype.Timestamp `json:"start_time"`
 }
 
-func (q *Queries) GetTeamInvitationByToken(ctx context.Context, token string) (GetTeamInvitationByTokenRow, error) {
-	row := q.db.QueryRow(ctx, getTeamInvitationByToken, token)
-	var i GetTeamInvitationByTokenRow
-	err := row.Scan(
-		&i.ID,
-		&i.OrganizationID,
-		&i.Email,
-		&i.Role,
-		&i.InvitedBy,
-		&i.Token,
-		&i.ExpiresAt,
-		&i.AcceptedAt,
-		&i.DeclinedAt,
-		&i.CreatedAt,
-		&i.OrganizationName,
-		&i.InviterEmail,
-	)
-	return i, err
+// ============================================
+// PLAN CHANGE QUERIES
+// ============================================
+// The plans an organization was on at some point between start and end
+func (q *Queries) ListOrganizationPlanHistory(ctx context.Context, arg ListOrganizationPlanHistoryParams) ([]OrganizationPlanHistory, error) {
+	rows, err := q.db.Query(ctx, listOrganizationPlanHistory, arg.OrganizationID, arg.EndTime, arg.StartTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []OrganizationPlanHistory{}
+	for rows.Next() {
+		var i OrganizationPlanHistory
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.Plan,
+			&i.StartedAt,
+			&i.EndedAt,
+			&i.PrepaidUntil,
+			&i.ProrationCycleID,
+			&i.ChangedBy,
+			&i.CreatedAt,
+			&i.Trial,
+			&i.BilledByStripe,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getUsageByAPIKey = `-- name: GetUsageByAPIKey :many
-SELECT 
-    ak.id,
-    ak.name,
-    ak.key,
-    COUNT(ur.id) as request_count
-FROM api_keys ak
-LEFT JOIN usage_records ur ON ak.id = ur.api_key_id
-    AND ur.created_at >= $2
-    AND ur.created_at <= $3
-WHERE ak.organization_id = $1
-GROUP BY ak.id, ak.name, ak.key
-ORDER BY request_count DESC
+const listOrganizationUsage = `-- name: ListOrganizationUsage :many
+SELECT id, organization_id, api_key_id, endpoint, method, status_code, created_at, duration_ms, request_bytes, response_bytes, client_ip, user_agent, request_id, message_type, units FROM usage_records
+WHERE organization_id = $1
+    AND created_at >= $2
+    AND created_at <= $3
+ORDER BY created_at DESC
+LIMIT $4 OFFSET $5
 `
 
-type GetUsageByAPIKeyParams struct {
+type ListOrganizationUsageParams struct {
 	OrganizationID uuid.UUID        `json:"organization_id"`
 	CreatedAt      pgtype.Timestamp `json:"created_at"`
 	CreatedAt_2    pgtype.Timestamp `json:"created_at_2"`
+	Limit          int32            `json:"limit"`
+	Offset         int32            `json:"offset"`
 }
 
-type GetUsageByAPIKeyRow struct {
-	ID           uuid.UUID `json:"id"`
-	Name         string    `json:"name"`
-	Key          string    `json:"key"`
-	RequestCount int64     `json:"request_count"`
+func (q *Queries) ListOrganizationUsage(ctx context.Context, arg ListOrganizationUsageParams) ([]UsageRecord, error) {
+	rows, err := q.db.Query(ctx, listOrganizationUsage,
+		arg.OrganizationID,
+		arg.CreatedAt,
+		arg.CreatedAt_2,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []UsageRecord{}
+	for rows.Next() {
+		var i UsageRecord
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.ApiKeyID,
+			&i.Endpoint,
+			&i.Method,
+			&i.StatusCode,
+			&i.CreatedAt,
+			&i.DurationMs,
+			&i.RequestBytes,
+			&i.ResponseBytes,
+			&i.ClientIp,
+			&i.UserAgent,
+			&i.RequestID,
+			&i.MessageType,
+			&i.Units,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-func (q *Queries) GetUsageByAPIKey(ctx context.Context, arg GetUsageByAPIKeyParams) ([]GetUsageByAPIKeyRow, error) {
-	rows, err := q.db.Query(ctx, getUsageByAPIKey, arg.OrganizationID, arg.CreatedAt, arg.CreatedAt_2)
+const listOrganizationUsageAlerts = `-- name: ListOrganizationUsageAlerts :many
+SELECT id, organization_id, metric, direction, window_start, window_end, observed, expected, score, status, created_at, acknowledged_at, acknowledged_by FROM usage_alerts
+WHERE organization_id = $1
+    AND ($2::usage_alert_status IS NULL OR status = $2::usage_alert_status)
+ORDER BY created_at DESC
+LIMIT $3
+`
+
+type ListOrganizationUsageAlertsParams struct {
+	OrganizationID uuid.UUID            `json:"organization_id"`
+	Status         NullUsageAlertStatus `json:"status"`
+	LimitCount     int32                `json:"limit_count"`
+}
+
+func (q *Queries) ListOrganizationUsageAlerts(ctx context.Context, arg ListOrganizationUsageAlertsParams) ([]UsageAlert, error) {
+	rows, err := q.db.Query(ctx, listOrganizationUsageAlerts, arg.OrganizationID, arg.Status, arg.LimitCount)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []GetUsageByAPIKeyRow{}
+	items := []UsageAlert{}
 	for rows.Next() {
-		var i GetUsageByAPIKeyRow
+		var i UsageAlert
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
-			&i.Key,
-			&i.RequestCount,
+			&i.OrganizationID,
+			&i.Metric,
+			&i.Direction,
+			&i.WindowStart,
+			&i.WindowEnd,
+			&i.Observed,
+			&i.Expected,
+			&i.Score,
+			&i.Status,
+			&i.CreatedAt,
+			&i.AcknowledgedAt,
+			&i.AcknowledgedBy,
 		); err != nil {
 			return nil, err
 		}
@@ -933,47 +4156,43 @@ func (q *Queries) GetUsageByAPIKey(ctx context.Context, arg GetUsageByAPIKeyPara
 	return items, nil
 }
 
-const getUsageByEndpoint = `-- name: GetUsageByEndpoint :many
-SELECT 
-    endpoint,
-    COUNT(*) as request_count,
-    COUNT(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 END) as success_count,
-    COUNT(CASE WHEN status_code >= 400 THEN 1 END) as error_count
-FROM usage_records
+const listOrganizationUsageExports = `-- name: ListOrganizationUsageExports :many
+SELECT id, organization_id, requested_by, format, start_time, end_time, status, row_count, file_size, file_path, error, created_at, started_at, completed_at, expires_at FROM usage_exports
 WHERE organization_id = $1
-    AND created_at >= $2
-    AND created_at <= $3
-GROUP BY endpoint
-ORDER BY request_count DESC
+ORDER BY created_at DESC
+LIMIT $2
 `
 
-type GetUsageByEndpointParams struct {
-	OrganizationID uuid.UUID        `json:"organization_id"`
-	CreatedAt      pgtype.Timestamp `json:"created_at"`
-	CreatedAt_2    pgtype.Timestamp `json:"created_at_2"`
-}
-
-type GetUsageByEndpointRow struct {
-	Endpoint     string `json:"endpoint"`
-	RequestCount int64  `json:"request_count"`
-	SuccessCount int64  `json:"success_count"`
-	ErrorCount   int64  `json:"error_count"`
+type ListOrganizationUsageExportsParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Limit          int32     `json:"limit"`
 }
 
-func (q *Queries) GetUsageByEndpoint(ctx context.Context, arg GetUsageByEndpointParams) ([]GetUsageByEndpointRow, error) {
-	rows, err := q.db.Query(ctx, getUsageByEndpoint, arg.OrganizationID, arg.CreatedAt, arg.CreatedAt_2)
+func (q *Queries) ListOrganizationUsageExports(ctx context.Context, arg ListOrganizationUsageExportsParams) ([]UsageExport, error) {
+	rows, err := q.db.Query(ctx, listOrganizationUsageExports, arg.OrganizationID, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []GetUsageByEndpointRow{}
+	items := []UsageExport{}
 	for rows.Next() {
-		var i GetUsageByEndpointRow
+		var i UsageExport
 		if err := rows.Scan(
-			&i.Endpoint,
-			&i.RequestCount,
-			&i.SuccessCount,
-			&i.ErrorCount,
+			&i.ID,
+			&i.OrganizationID,
+			&i.RequestedBy,
+			&i.Format,
+			&i.StartTime,
+			&i.EndTime,
+			&i.Status,
+			&i.RowCount,
+			&i.FileSize,
+			&i.FilePath,
+			&i.Error,
+			&i.CreatedAt,
+			&i.StartedAt,
+			&i.CompletedAt,
+			&i.ExpiresAt,
 		); err != nil {
 			return nil, err
 		}
@@ -985,132 +4204,111 @@ func (q *Queries) GetUsageByEndpoint(ctx context.Context, arg GetUsageByEndpoint
 	return items, nil
 }
 
-const getUsageRecord = `-- name: GetUsageRecord :one
-SELECT id, organization_id, api_key_id, endpoint, method, status_code, created_at FROM usage_records
-WHERE id = $1
-`
-
-func (q *Queries) GetUsageRecord(ctx context.Context, id uuid.UUID) (UsageRecord, error) {
-	row := q.db.QueryRow(ctx, getUsageRecord, id)
-	var i UsageRecord
-	err := row.Scan(
-		&i.ID,
-		&i.OrganizationID,
-		&i.ApiKeyID,
-		&i.Endpoint,
-		&i.Method,
-		&i.StatusCode,
-		&i.CreatedAt,
-	)
-	return i, err
-}
-
-const getUser = `-- name: GetUser :one
+const listOrganizationUsers = `-- name: ListOrganizationUsers :many
 SELECT id, organization_id, email, password_hash, role, created_at, email_verified, email_verified_at FROM users
-WHERE id = $1
+WHERE organization_id = $1
+ORDER BY created_at DESC
 `
 
-func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRow(ctx, getUser, id)
-	var i User
-	err := row.Scan(
-		&i.ID,
-		&i.OrganizationID,
-		&i.Email,
-		&i.PasswordHash,
-		&i.Role,
-		&i.CreatedAt,
-		&i.EmailVerified,
-		&i.EmailVerifiedAt,
-	)
-	return i, err
+func (q *Queries) ListOrganizationUsers(ctx context.Context, organizationID uuid.UUID) ([]User, error) {
+	rows, err := q.db.Query(ctx, listOrganizationUsers, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []User{}
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.OrganizationID,
+			&i.Email,
+			&i.PasswordHash,
+			&i.Role,
+			&i.CreatedAt,
+			&i.EmailVerified,
+			&i.EmailVerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, organization_id, email, password_hash, role, created_at, email_verified, email_verified_at FROM users
-WHERE email = $1
+const listOrganizations = `-- name: ListOrganizations :many
+SELECT id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id FROM organizations
+ORDER BY created_at DESC
+LIMIT $1 OFFSET $2
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRow(ctx, getUserByEmail, email)
-	var i User
-	err := row.Scan(
-		&i.ID,
-		&i.OrganizationID,
-		&i.Email,
-		&i.PasswordHash,
-		&i.Role,
-		&i.CreatedAt,
-		&i.EmailVerified,
-		&i.EmailVerifiedAt,
-	)
-	return i, err
+type ListOrganizationsParams struct {
+	Limit  int32 `json:"limit"`
+	Offset int32 `json:"offset"`
 }
 
-const getUserWithOrganization = `-- name: GetUserWithOrganization :one
-SELECT 
-    u.id, u.organization_id, u.email, u.password_hash, u.role, u.created_at, u.email_verified, u.email_verified_at,
-    o.name as organization_name,
-    o.plan as organization_plan
-FROM users u
-JOIN organizations o ON u.organization_id = o.id
-WHERE u.id = $1
-`
-
-type GetUserWithOrganizationRow struct {
-	ID               uuid.UUID        `json:"id"`
-	OrganizationID   uuid.UUID        `json:"organization_id"`
-	Email            string           `json:"email"`
-	PasswordHash     string           `json:"password_hash"`
-	Role             UserRole         `json:"role"`
-	CreatedAt        pgtype.Timestamp `json:"created_at"`
-	EmailVerified    bool             `json:"email_verified"`
-	EmailVerifiedAt  pgtype.Timestamp `json:"email_verified_at"`
-	OrganizationName string           `json:"organization_name"`
-	OrganizationPlan PlanType         `json:"organization_plan"`
+func (q *Queries) ListOrganizations(ctx context.Context, arg ListOrganizationsParams) ([]Organization, error) {
+	rows, err := q.db.Query(ctx, listOrganizations, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Organization{}
+	for rows.Next() {
+		var i Organization
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.Plan,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.QuotaPolicy,
+			&i.BillingCurrency,
+			&i.TrialStatus,
+			&i.TrialPlan,
+			&i.TrialStartedAt,
+			&i.TrialEndsAt,
+			&i.TrialRemindedAt,
+			&i.StripeCustomerID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-func (q *Queries) GetUserWithOrganization(ctx context.Context, id uuid.UUID) (GetUserWithOrganizationRow, error) {
-	row := q.db.QueryRow(ctx, getUserWithOrganization, id)
-	var i GetUserWithOrganizationRow
-	err := row.Scan(
-		&i.ID,
-		&i.OrganizationID,
-		&i.Email,
-		&i.PasswordHash,
-		&i.Role,
-		&i.CreatedAt,
-		&i.EmailVerified,
-		&i.EmailVerifiedAt,
-		&i.OrganizationName,
-		&i.OrganizationPlan,
-	)
-	return i, err
-}
+const listPriceBooks = `-- name: ListPriceBooks :many
 
-const listOrganizationAPIKeys = `-- name: ListOrganizationAPIKeys :many
-SELECT id, organization_id, key, name, is_active, created_at, last_used_at FROM api_keys
-WHERE organization_id = $1
-ORDER BY created_at DESC
+SELECT version, effective_from, currency, plans, created_at FROM price_books
+ORDER BY effective_from
 `
 
-func (q *Queries) ListOrganizationAPIKeys(ctx context.Context, organizationID uuid.UUID) ([]ApiKey, error) {
-	rows, err := q.db.Query(ctx, listOrganizationAPIKeys, organizationID)
+// ============================================
+// PRICE BOOK QUERIES
+// ============================================
+func (q *Queries) ListPriceBooks(ctx context.Context) ([]PriceBook, error) {
+	rows, err := q.db.Query(ctx, listPriceBooks)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []ApiKey{}
+	items := []PriceBook{}
 	for rows.Next() {
-		var i ApiKey
+		var i PriceBook
 		if err := rows.Scan(
-			&i.ID,
-			&i.OrganizationID,
-			&i.Key,
-			&i.Name,
-			&i.IsActive,
+			&i.Version,
+			&i.EffectiveFrom,
+			&i.Currency,
+			&i.Plans,
 			&i.CreatedAt,
-			&i.LastUsedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -1122,36 +4320,26 @@ func (q *Queries) ListOrganizationAPIKeys(ctx context.Context, organizationID uu
 	return items, nil
 }
 
-const listOrganizationBillingCycles = `-- name: ListOrganizationBillingCycles :many
-SELECT id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at FROM billing_cycles
-WHERE organization_id = $1
-ORDER BY period_start DESC
-LIMIT $2 OFFSET $3
+const listTaxRates = `-- name: ListTaxRates :many
+SELECT country, effective_from, name, rate, reverse_charge, created_at FROM tax_rates
+ORDER BY country, effective_from
 `
 
-type ListOrganizationBillingCyclesParams struct {
-	OrganizationID uuid.UUID `json:"organization_id"`
-	Limit          int32     `json:"limit"`
-	Offset         int32     `json:"offset"`
-}
-
-func (q *Queries) ListOrganizationBillingCycles(ctx context.Context, arg ListOrganizationBillingCyclesParams) ([]BillingCycle, error) {
-	rows, err := q.db.Query(ctx, listOrganizationBillingCycles, arg.OrganizationID, arg.Limit, arg.Offset)
+func (q *Queries) ListTaxRates(ctx context.Context) ([]TaxRate, error) {
+	rows, err := q.db.Query(ctx, listTaxRates)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []BillingCycle{}
+	items := []TaxRate{}
 	for rows.Next() {
-		var i BillingCycle
+		var i TaxRate
 		if err := rows.Scan(
-			&i.ID,
-			&i.OrganizationID,
-			&i.PeriodStart,
-			&i.PeriodEnd,
-			&i.TotalRequests,
-			&i.TotalAmount,
-			&i.Status,
+			&i.Country,
+			&i.EffectiveFrom,
+			&i.Name,
+			&i.Rate,
+			&i.ReverseCharge,
 			&i.CreatedAt,
 		); err != nil {
 			return nil, err
@@ -1164,51 +4352,40 @@ func (q *Queries) ListOrganizationBillingCycles(ctx context.Context, arg ListOrg
 	return items, nil
 }
 
-const listOrganizationInvitations = `-- name: ListOrganizationInvitations :many
-SELECT 
-    ti.id, ti.organization_id, ti.email, ti.role, ti.invited_by, ti.token, ti.expires_at, ti.accepted_at, ti.declined_at, ti.created_at,
-    u.email as inviter_email
-FROM team_invitations ti
-JOIN users u ON ti.invited_by = u.id
-WHERE ti.organization_id = $1
-ORDER BY ti.created_at DESC
-`
+const listTrialsToRemind = `-- name: ListTrialsToRemind :many
 
-type ListOrganizationInvitationsRow struct {
-	ID             uuid.UUID        `json:"id"`
-	OrganizationID uuid.UUID        `json:"organization_id"`
-	Email          string           `json:"email"`
-	Role           UserRole         `json:"role"`
-	InvitedBy      uuid.UUID        `json:"invited_by"`
-	Token          string           `json:"token"`
-	ExpiresAt      pgtype.Timestamp `json:"expires_at"`
-	AcceptedAt     pgtype.Timestamp `json:"accepted_at"`
-	DeclinedAt     pgtype.Timestamp `json:"declined_at"`
-	CreatedAt      pgtype.Timestamp `json:"created_at"`
-	InviterEmail   string           `json:"inviter_email"`
-}
+SELECT id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id FROM organizations
+WHERE trial_status = 'active'
+    AND trial_reminded_at IS NULL
+    AND trial_ends_at <= $1
+ORDER BY trial_ends_at
+`
 
-func (q *Queries) ListOrganizationInvitations(ctx context.Context, organizationID uuid.UUID) ([]ListOrganizationInvitationsRow, error) {
-	rows, err := q.db.Query(ctx, listOrganizationInvitations, organizationID)
+// Active trials ending by the given time whose owners have not been reminded
+func (q *Queries) ListTrialsToRemind(ctx context.Context, trialEndsAt pgtype.Timestamp) ([]Organization, error) {
+	rows, err := q.db.Query(ctx, listTrialsToRemind, trialEndsAt)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []ListOrganizationInvitationsRow{}
+	items := []Organization{}
 	for rows.Next() {
-		var i ListOrganizationInvitationsRow
+		var i Organization
 		if err := rows.Scan(
 			&i.ID,
-			&i.OrganizationID,
+			&i.Name,
 			&i.Email,
-			&i.Role,
-			&i.InvitedBy,
-			&i.Token,
-			&i.ExpiresAt,
-			&i.AcceptedAt,
-			&i.DeclinedAt,
+			&i.Plan,
 			&i.CreatedAt,
-			&i.InviterEmail,
+			&i.UpdatedAt,
+			&i.QuotaPolicy,
+			&i.BillingCurrency,
+			&i.TrialStatus,
+			&i.TrialPlan,
+			&i.TrialStartedAt,
+			&i.TrialEndsAt,
+			&i.TrialRemindedAt,
+			&i.StripeCustomerID,
 		); err != nil {
 			return nil, err
 		}
@@ -1220,40 +4397,31 @@ func (q *Queries) ListOrganizationInvitations(ctx context.Context, organizationI
 	return items, nil
 }
 
-const listOrganizationMembers = `-- name: ListOrganizationMembers :many
-SELECT 
-    u.id,
-    u.email,
-    u.role,
-    u.email_verified,
-    u.created_at
-FROM users u
-WHERE u.organization_id = $1
-ORDER BY u.created_at DESC
+const listUsageBudgetNotifications = `-- name: ListUsageBudgetNotifications :many
+SELECT organization_id, period_start, threshold, usage, created_at FROM usage_budget_notifications
+WHERE organization_id = $1 AND period_start = $2
+ORDER BY threshold
 `
 
-type ListOrganizationMembersRow struct {
-	ID            uuid.UUID        `json:"id"`
-	Email         string           `json:"email"`
-	Role          UserRole         `json:"role"`
-	EmailVerified bool             `json:"email_verified"`
-	CreatedAt     pgtype.Timestamp `json:"created_at"`
+type ListUsageBudgetNotificationsParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	PeriodStart    pgtype.Timestamp `json:"period_start"`
 }
 
-func (q *Queries) ListOrganizationMembers(ctx context.Context, organizationID uuid.UUID) ([]ListOrganizationMembersRow, error) {
-	rows, err := q.db.Query(ctx, listOrganizationMembers, organizationID)
+func (q *Queries) ListUsageBudgetNotifications(ctx context.Context, arg ListUsageBudgetNotificationsParams) ([]UsageBudgetNotification, error) {
+	rows, err := q.db.Query(ctx, listUsageBudgetNotifications, arg.OrganizationID, arg.PeriodStart)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []ListOrganizationMembersRow{}
+	items := []UsageBudgetNotification{}
 	for rows.Next() {
-		var i ListOrganizationMembersRow
+		var i UsageBudgetNotification
 		if err := rows.Scan(
-			&i.ID,
-			&i.Email,
-			&i.Role,
-			&i.EmailVerified,
+			&i.OrganizationID,
+			&i.PeriodStart,
+			&i.Threshold,
+			&i.Usage,
 			&i.CreatedAt,
 		); err != nil {
 			return nil, err
@@ -1266,46 +4434,47 @@ func (q *Queries) ListOrganizationMembers(ctx context.Context, organizationID uu
 	return items, nil
 }
 
-const listOrganizationUsage = `-- name: ListOrganizationUsage :many
-SELECT id, organization_id, api_key_id, endpoint, method, status_code, created_at FROM usage_records
-WHERE organization_id = $1
-    AND created_at >= $2
-    AND created_at <= $3
-ORDER BY created_at DESC
-LIMIT $4 OFFSET $5
+const listUsageBudgets = `-- name: ListUsageBudgets :many
+SELECT
+    b.organization_id,
+    b.metric,
+    b.amount,
+    b.thresholds,
+    b.disable_keys_at_limit,
+    o.name as org_name,
+    o.plan as org_plan
+FROM usage_budgets b
+JOIN organizations o ON b.organization_id = o.id
+ORDER BY b.organization_id
 `
 
-type ListOrganizationUsageParams struct {
-	OrganizationID uuid.UUID        `json:"organization_id"`
-	CreatedAt      pgtype.Timestamp `json:"created_at"`
-	CreatedAt_2    pgtype.Timestamp `json:"created_at_2"`
-	Limit          int32            `json:"limit"`
-	Offset         int32            `json:"offset"`
+type ListUsageBudgetsRow struct {
+	OrganizationID     uuid.UUID      `json:"organization_id"`
+	Metric             BudgetMetric   `json:"metric"`
+	Amount             pgtype.Numeric `json:"amount"`
+	Thresholds         []int32        `json:"thresholds"`
+	DisableKeysAtLimit bool           `json:"disable_keys_at_limit"`
+	OrgName            string         `json:"org_name"`
+	OrgPlan            PlanType       `json:"org_plan"`
 }
 
-func (q *Queries) ListOrganizationUsage(ctx context.Context, arg ListOrganizationUsageParams) ([]UsageRecord, error) {
-	rows, err := q.db.Query(ctx, listOrganizationUsage,
-		arg.OrganizationID,
-		arg.CreatedAt,
-		arg.CreatedAt_2,
-		arg.Limit,
-		arg.Offset,
-	)
+func (q *Queries) ListUsageBudgets(ctx context.Context) ([]ListUsageBudgetsRow, error) {
+	rows, err := q.db.Query(ctx, listUsageBudgets)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []UsageRecord{}
+	items := []ListUsageBudgetsRow{}
 	for rows.Next() {
-		var i UsageRecord
+		var i ListUsageBudgetsRow
 		if err := rows.Scan(
-			&i.ID,
 			&i.OrganizationID,
-			&i.ApiKeyID,
-			&i.Endpoint,
-			&i.Method,
-			&i.StatusCode,
-			&i.CreatedAt,
+			&i.Metric,
+			&i.Amount,
+			&i.Thresholds,
+			&i.DisableKeysAtLimit,
+			&i.OrgName,
+			&i.OrgPlan,
 		); err != nil {
 			return nil, err
 		}
@@ -1317,31 +4486,37 @@ func (q *Queries) ListOrganizationUsage(ctx context.Context, arg ListOrganizatio
 	return items, nil
 }
 
-const listOrganizationUsers = `-- name: ListOrganizationUsers :many
-SELECT id, organization_id, email, password_hash, role, created_at, email_verified, email_verified_at FROM users
-WHERE organization_id = $1
-ORDER BY created_at DESC
+const listUsageRecordPartitions = `-- name: ListUsageRecordPartitions :many
+
+SELECT
+    c.relname::text as name,
+    c.relispartition as attached
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = current_schema()
+    AND c.relkind = 'r'
+    AND c.relname ~ '^usage_records_[0-9]{4}_[0-9]{2}$'
+ORDER BY c.relname
 `
 
-func (q *Queries) ListOrganizationUsers(ctx context.Context, organizationID uuid.UUID) ([]User, error) {
-	rows, err := q.db.Query(ctx, listOrganizationUsers, organizationID)
+type ListUsageRecordPartitionsRow struct {
+	Name     string `json:"name"`
+	Attached bool   `json:"attached"`
+}
+
+// ============================================
+// USAGE RETENTION QUERIES
+// ============================================
+func (q *Queries) ListUsageRecordPartitions(ctx context.Context) ([]ListUsageRecordPartitionsRow, error) {
+	rows, err := q.db.Query(ctx, listUsageRecordPartitions)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []User{}
+	items := []ListUsageRecordPartitionsRow{}
 	for rows.Next() {
-		var i User
-		if err := rows.Scan(
-			&i.ID,
-			&i.OrganizationID,
-			&i.Email,
-			&i.PasswordHash,
-			&i.Role,
-			&i.CreatedAt,
-			&i.EmailVerified,
-			&i.EmailVerifiedAt,
-		); err != nil {
+		var i ListUsageRecordPartitionsRow
+		if err := rows.Scan(&i.Name, &i.Attached); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -1352,33 +4527,85 @@ func (q *Queries) ListOrganizationUsers(ctx context.Context, organizationID uuid
 	return items, nil
 }
 
-const listOrganizations = `-- name: ListOrganizations :many
-SELECT id, name, email, plan, created_at, updated_at FROM organizations
-ORDER BY created_at DESC
-LIMIT $1 OFFSET $2
+const listUsageRecordsForExport = `-- name: ListUsageRecordsForExport :many
+SELECT
+    id,
+    api_key_id,
+    endpoint,
+    method,
+    status_code,
+    created_at,
+    duration_ms,
+    request_bytes,
+    response_bytes,
+    client_ip,
+    user_agent,
+    request_id,
+    message_type,
+    units
+FROM usage_records
+WHERE organization_id = $1
+  AND created_at < $2
+  AND (created_at, id) > ($3::timestamp, $4::uuid)
+ORDER BY created_at, id
+LIMIT $5
 `
 
-type ListOrganizationsParams struct {
-	Limit  int32 `json:"limit"`
-	Offset int32 `json:"offset"`
+type ListUsageRecordsForExportParams struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	EndTime        pgtype.Timestamp `json:"end_time"`
+	AfterCreatedAt pgtype.Timestamp `json:"after_created_at"`
+	AfterID        uuid.UUID        `json:"after_id"`
+	LimitCount     int32            `json:"limit_count"`
 }
 
-func (q *Queries) ListOrganizations(ctx context.Context, arg ListOrganizationsParams) ([]Organization, error) {
-	rows, err := q.db.Query(ctx, listOrganizations, arg.Limit, arg.Offset)
+type ListUsageRecordsForExportRow struct {
+	ID            uuid.UUID        `json:"id"`
+	ApiKeyID      uuid.UUID        `json:"api_key_id"`
+	Endpoint      string           `json:"endpoint"`
+	Method        string           `json:"method"`
+	StatusCode    int32            `json:"status_code"`
+	CreatedAt     pgtype.Timestamp `json:"created_at"`
+	DurationMs    int32            `json:"duration_ms"`
+	RequestBytes  int64            `json:"request_bytes"`
+	ResponseBytes int64            `json:"response_bytes"`
+	ClientIp      *string          `json:"client_ip"`
+	UserAgent     *string          `json:"user_agent"`
+	RequestID     *string          `json:"request_id"`
+	MessageType   *string          `json:"message_type"`
+	Units         int32            `json:"units"`
+}
+
+func (q *Queries) ListUsageRecordsForExport(ctx context.Context, arg ListUsageRecordsForExportParams) ([]ListUsageRecordsForExportRow, error) {
+	rows, err := q.db.Query(ctx, listUsageRecordsForExport,
+		arg.OrganizationID,
+		arg.EndTime,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.LimitCount,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Organization{}
+	items := []ListUsageRecordsForExportRow{}
 	for rows.Next() {
-		var i Organization
+		var i ListUsageRecordsForExportRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
-			&i.Email,
-			&i.Plan,
+			&i.ApiKeyID,
+			&i.Endpoint,
+			&i.Method,
+			&i.StatusCode,
 			&i.CreatedAt,
-			&i.UpdatedAt,
+			&i.DurationMs,
+			&i.RequestBytes,
+			&i.ResponseBytes,
+			&i.ClientIp,
+			&i.UserAgent,
+			&i.RequestID,
+			&i.MessageType,
+			&i.Units,
 		); err != nil {
 			return nil, err
 		}
@@ -1390,6 +4617,20 @@ func (q *Queries) ListOrganizations(ctx context.Context, arg ListOrganizationsPa
 	return items, nil
 }
 
+const markCreditWalletLowBalanceNotified = `-- name: MarkCreditWalletLowBalanceNotified :execrows
+UPDATE credit_wallets
+SET low_balance_notified_at = NOW()
+WHERE organization_id = $1 AND low_balance_notified_at IS NULL
+`
+
+func (q *Queries) MarkCreditWalletLowBalanceNotified(ctx context.Context, organizationID uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, markCreditWalletLowBalanceNotified, organizationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const markTokenAsUsed = `-- name: MarkTokenAsUsed :one
 UPDATE auth_tokens
 SET used_at = NOW()
@@ -1397,36 +4638,413 @@ WHERE id = $1
 RETURNING id, user_id, token, type, expires_at, used_at, created_at
 `
 
-func (q *Queries) MarkTokenAsUsed(ctx context.Context, id uuid.UUID) (AuthToken, error) {
-	row := q.db.QueryRow(ctx, markTokenAsUsed, id)
-	var i AuthToken
+func (q *Queries) MarkTokenAsUsed(ctx context.Context, id uuid.UUID) (AuthToken, error) {
+	row := q.db.QueryRow(ctx, markTokenAsUsed, id)
+	var i AuthToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Token,
+		&i.Type,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const markTrialReminded = `-- name: MarkTrialReminded :exec
+UPDATE organizations
+SET trial_reminded_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) MarkTrialReminded(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, markTrialReminded, id)
+	return err
+}
+
+const markUsageExportExpired = `-- name: MarkUsageExportExpired :exec
+UPDATE usage_exports
+SET status = 'expired',
+    file_path = NULL
+WHERE id = $1
+`
+
+func (q *Queries) MarkUsageExportExpired(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.Exec(ctx, markUsageExportExpired, id)
+	return err
+}
+
+const nextInvoiceNumber = `-- name: NextInvoiceNumber :one
+INSERT INTO invoice_number_sequences (issuer, year, last_number)
+VALUES ($1, $2, 1)
+ON CONFLICT (issuer, year) DO UPDATE
+SET last_number = invoice_number_sequences.last_number + 1
+RETURNING last_number
+`
+
+type NextInvoiceNumberParams struct {
+	Issuer string `json:"issuer"`
+	Year   int32  `json:"year"`
+}
+
+func (q *Queries) NextInvoiceNumber(ctx context.Context, arg NextInvoiceNumberParams) (int64, error) {
+	row := q.db.QueryRow(ctx, nextInvoiceNumber, arg.Issuer, arg.Year)
+	var last_number int64
+	err := row.Scan(&last_number)
+	return last_number, err
+}
+
+const nextPaymentAttempt = `-- name: NextPaymentAttempt :one
+INSERT INTO invoice_payment_attempts (billing_cycle_id, attempts)
+VALUES ($1, 1)
+ON CONFLICT (billing_cycle_id) DO UPDATE
+SET attempts = invoice_payment_attempts.attempts + 1
+RETURNING attempts
+`
+
+func (q *Queries) NextPaymentAttempt(ctx context.Context, billingCycleID uuid.UUID) (int32, error) {
+	row := q.db.QueryRow(ctx, nextPaymentAttempt, billingCycleID)
+	var attempts int32
+	err := row.Scan(&attempts)
+	return attempts, err
+}
+
+const recordOutboundEventAttempt = `-- name: RecordOutboundEventAttempt :exec
+UPDATE outbound_events
+SET status = $2,
+    attempts = attempts + 1,
+    next_attempt_at = $3,
+    last_error = $4,
+    delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() ELSE delivered_at END
+WHERE id = $1
+`
+
+type RecordOutboundEventAttemptParams struct {
+	ID            uuid.UUID           `json:"id"`
+	Status        OutboundEventStatus `json:"status"`
+	NextAttemptAt pgtype.Timestamp    `json:"next_attempt_at"`
+	LastError     *string             `json:"last_error"`
+}
+
+func (q *Queries) RecordOutboundEventAttempt(ctx context.Context, arg RecordOutboundEventAttemptParams) error {
+	_, err := q.db.Exec(ctx, recordOutboundEventAttempt,
+		arg.ID,
+		arg.Status,
+		arg.NextAttemptAt,
+		arg.LastError,
+	)
+	return err
+}
+
+const removeTeamMember = `-- name: RemoveTeamMember :exec
+DELETE FROM users
+WHERE id = $1 AND organization_id = $2 AND role != 'owner'
+`
+
+type RemoveTeamMemberParams struct {
+	ID             uuid.UUID `json:"id"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+}
+
+func (q *Queries) RemoveTeamMember(ctx context.Context, arg RemoveTeamMemberParams) error {
+	_, err := q.db.Exec(ctx, removeTeamMember, arg.ID, arg.OrganizationID)
+	return err
+}
+
+const resumeAPIKeysSuspendedBefore = `-- name: ResumeAPIKeysSuspendedBefore :execrows
+UPDATE api_keys
+SET is_active = true, suspended_at = NULL
+WHERE suspended_at < $1
+`
+
+func (q *Queries) ResumeAPIKeysSuspendedBefore(ctx context.Context, suspendedAt pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, resumeAPIKeysSuspendedBefore, suspendedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const resumeSuspendedAPIKeys = `-- name: ResumeSuspendedAPIKeys :execrows
+UPDATE api_keys
+SET is_active = true, suspended_at = NULL
+WHERE organization_id = $1 AND suspended_at IS NOT NULL
+`
+
+func (q *Queries) ResumeSuspendedAPIKeys(ctx context.Context, organizationID uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, resumeSuspendedAPIKeys, organizationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const rollupDailyUsage = `-- name: RollupDailyUsage :execrows
+INSERT INTO usage_rollups_daily (
+    organization_id, api_key_id, endpoint, status_class, message_type, day,
+    request_count, units, request_bytes, response_bytes, duration_ms
+)
+SELECT
+    organization_id,
+    api_key_id,
+    endpoint,
+    status_class,
+    message_type,
+    DATE(bucket),
+    SUM(request_count)::bigint,
+    SUM(units)::bigint,
+    SUM(request_bytes)::bigint,
+    SUM(response_bytes)::bigint,
+    SUM(duration_ms)::bigint
+FROM usage_rollups_hourly
+WHERE bucket >= date_trunc('day', $1::timestamp)
+    AND bucket < $2::timestamp
+GROUP BY 1, 2, 3, 4, 5, 6
+ON CONFLICT (organization_id, day, api_key_id, endpoint, status_class, message_type)
+DO UPDATE SET
+    request_count = EXCLUDED.request_count,
+    units = EXCLUDED.units,
+    request_bytes = EXCLUDED.request_bytes,
+    response_bytes = EXCLUDED.response_bytes,
+    duration_ms = EXCLUDED.duration_ms
+`
+
+type RollupDailyUsageParams struct {
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+func (q *Queries) RollupDailyUsage(ctx context.Context, arg RollupDailyUsageParams) (int64, error) {
+	result, err := q.db.Exec(ctx, rollupDailyUsage, arg.StartTime, arg.EndTime)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const rollupHourlyUsage = `-- name: RollupHourlyUsage :execrows
+INSERT INTO usage_rollups_hourly (
+    organization_id, api_key_id, endpoint, status_class, message_type, bucket,
+    request_count, units, request_bytes, response_bytes, duration_ms
+)
+SELECT
+    organization_id,
+    api_key_id,
+    endpoint,
+    (status_code / 100)::smallint,
+    COALESCE(message_type, ''),
+    date_trunc('hour', created_at),
+    COUNT(*),
+    SUM(units)::bigint,
+    SUM(request_bytes)::bigint,
+    SUM(response_bytes)::bigint,
+    SUM(duration_ms)::bigint
+FROM usage_records
+WHERE created_at >= $1::timestamp
+    AND created_at < $2::timestamp
+GROUP BY 1, 2, 3, 4, 5, 6
+ON CONFLICT (organization_id, bucket, api_key_id, endpoint, status_class, message_type)
+DO UPDATE SET
+    request_count = EXCLUDED.request_count,
+    units = EXCLUDED.units,
+    request_bytes = EXCLUDED.request_bytes,
+    response_bytes = EXCLUDED.response_bytes,
+    duration_ms = EXCLUDED.duration_ms
+`
+
+type RollupHourlyUsageParams struct {
+	StartTime pgtype.Timestamp `json:"start_time"`
+	EndTime   pgtype.Timestamp `json:"end_time"`
+}
+
+func (q *Queries) RollupHourlyUsage(ctx context.Context, arg RollupHourlyUsageParams) (int64, error) {
+	result, err := q.db.Exec(ctx, rollupHourlyUsage, arg.StartTime, arg.EndTime)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setCreditWalletBlocked = `-- name: SetCreditWalletBlocked :execrows
+
+UPDATE credit_wallets
+SET blocked_at = CASE WHEN $1::boolean THEN NOW() END
+WHERE organization_id = $2 AND (blocked_at IS NOT NULL) <> $1::boolean
+`
+
+type SetCreditWalletBlockedParams struct {
+	Blocked        bool      `json:"blocked"`
+	OrganizationID uuid.UUID `json:"organization_id"`
+}
+
+// Only changes, and so only counts, wallets whose blocked state flips
+func (q *Queries) SetCreditWalletBlocked(ctx context.Context, arg SetCreditWalletBlockedParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setCreditWalletBlocked, arg.Blocked, arg.OrganizationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setOrganizationTaxExemption = `-- name: SetOrganizationTaxExemption :one
+UPDATE organization_tax_profiles
+SET tax_exempt = $2,
+    exemption_reason = $3,
+    updated_at = NOW()
+WHERE organization_id = $1
+RETURNING organization_id, country, vat_id, tax_exempt, exemption_reason, created_at, updated_at
+`
+
+type SetOrganizationTaxExemptionParams struct {
+	OrganizationID  uuid.UUID   `json:"organization_id"`
+	TaxExempt       bool        `json:"tax_exempt"`
+	ExemptionReason pgtype.Text `json:"exemption_reason"`
+}
+
+func (q *Queries) SetOrganizationTaxExemption(ctx context.Context, arg SetOrganizationTaxExemptionParams) (OrganizationTaxProfile, error) {
+	row := q.db.QueryRow(ctx, setOrganizationTaxExemption, arg.OrganizationID, arg.TaxExempt, arg.ExemptionReason)
+	var i OrganizationTaxProfile
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Country,
+		&i.VatID,
+		&i.TaxExempt,
+		&i.ExemptionReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const setStripeCustomerID = `-- name: SetStripeCustomerID :one
+UPDATE organizations
+SET stripe_customer_id = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id
+`
+
+type SetStripeCustomerIDParams struct {
+	ID               uuid.UUID   `json:"id"`
+	StripeCustomerID pgtype.Text `json:"stripe_customer_id"`
+}
+
+func (q *Queries) SetStripeCustomerID(ctx context.Context, arg SetStripeCustomerIDParams) (Organization, error) {
+	row := q.db.QueryRow(ctx, setStripeCustomerID, arg.ID, arg.StripeCustomerID)
+	var i Organization
 	err := row.Scan(
 		&i.ID,
-		&i.UserID,
-		&i.Token,
-		&i.Type,
-		&i.ExpiresAt,
-		&i.UsedAt,
+		&i.Name,
+		&i.Email,
+		&i.Plan,
 		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.QuotaPolicy,
+		&i.BillingCurrency,
+		&i.TrialStatus,
+		&i.TrialPlan,
+		&i.TrialStartedAt,
+		&i.TrialEndsAt,
+		&i.TrialRemindedAt,
+		&i.StripeCustomerID,
 	)
 	return i, err
 }
 
-const removeTeamMember = `-- name: RemoveTeamMember :exec
-DELETE FROM users
-WHERE id = $1 AND organization_id = $2 AND role != 'owner'
+const setStripeUsageReported = `-- name: SetStripeUsageReported :exec
+UPDATE stripe_subscriptions
+SET usage_reported_through = $2,
+    updated_at = NOW()
+WHERE subscription_id = $1
 `
 
-type RemoveTeamMemberParams struct {
-	ID             uuid.UUID `json:"id"`
-	OrganizationID uuid.UUID `json:"organization_id"`
+type SetStripeUsageReportedParams struct {
+	SubscriptionID       string           `json:"subscription_id"`
+	UsageReportedThrough pgtype.Timestamp `json:"usage_reported_through"`
 }
 
-func (q *Queries) RemoveTeamMember(ctx context.Context, arg RemoveTeamMemberParams) error {
-	_, err := q.db.Exec(ctx, removeTeamMember, arg.ID, arg.OrganizationID)
+func (q *Queries) SetStripeUsageReported(ctx context.Context, arg SetStripeUsageReportedParams) error {
+	_, err := q.db.Exec(ctx, setStripeUsageReported, arg.SubscriptionID, arg.UsageReportedThrough)
+	return err
+}
+
+const setUsageRollupWatermark = `-- name: SetUsageRollupWatermark :exec
+UPDATE usage_rollup_state
+SET rolled_up_to = $1, updated_at = NOW()
+WHERE id = 1
+`
+
+func (q *Queries) SetUsageRollupWatermark(ctx context.Context, rolledUpTo pgtype.Timestamp) error {
+	_, err := q.db.Exec(ctx, setUsageRollupWatermark, rolledUpTo)
 	return err
 }
 
+const startTrial = `-- name: StartTrial :one
+
+UPDATE organizations
+SET
+    trial_status = 'active',
+    trial_plan = $2,
+    trial_started_at = $3,
+    trial_ends_at = $4,
+    updated_at = NOW()
+WHERE id = $1 AND plan = 'free' AND trial_status IS NULL
+RETURNING id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id
+`
+
+type StartTrialParams struct {
+	ID             uuid.UUID        `json:"id"`
+	TrialPlan      NullPlanType     `json:"trial_plan"`
+	TrialStartedAt pgtype.Timestamp `json:"trial_started_at"`
+	TrialEndsAt    pgtype.Timestamp `json:"trial_ends_at"`
+}
+
+// ============================================
+// TRIAL QUERIES
+// ============================================
+// An organization on free starts at most one trial
+func (q *Queries) StartTrial(ctx context.Context, arg StartTrialParams) (Organization, error) {
+	row := q.db.QueryRow(ctx, startTrial,
+		arg.ID,
+		arg.TrialPlan,
+		arg.TrialStartedAt,
+		arg.TrialEndsAt,
+	)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Plan,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.QuotaPolicy,
+		&i.BillingCurrency,
+		&i.TrialStatus,
+		&i.TrialPlan,
+		&i.TrialStartedAt,
+		&i.TrialEndsAt,
+		&i.TrialRemindedAt,
+		&i.StripeCustomerID,
+	)
+	return i, err
+}
+
+const suspendNonEssentialAPIKeys = `-- name: SuspendNonEssentialAPIKeys :execrows
+UPDATE api_keys
+SET is_active = false, suspended_at = NOW()
+WHERE organization_id = $1 AND is_active = true AND essential = false
+`
+
+func (q *Queries) SuspendNonEssentialAPIKeys(ctx context.Context, organizationID uuid.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, suspendNonEssentialAPIKeys, organizationID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateAPIKeyLastUsed = `-- name: UpdateAPIKeyLastUsed :exec
 UPDATE api_keys
 SET last_used_at = NOW()
@@ -1440,9 +5058,11 @@ func (q *Queries) UpdateAPIKeyLastUsed(ctx context.Context, id uuid.UUID) error
 
 const updateBillingCycleStatus = `-- name: UpdateBillingCycleStatus :one
 UPDATE billing_cycles
-SET status = $1
+SET
+    status = $1,
+    paid_at = CASE WHEN $1 = 'paid' THEN COALESCE(paid_at, NOW()) ELSE paid_at END
 WHERE id = $2
-RETURNING id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at
+RETURNING id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at, price_book_version, quote, paid_at, invoice_number, currency, fx_rate, fx_rate_at, kind
 `
 
 type UpdateBillingCycleStatusParams struct {
@@ -1462,6 +5082,14 @@ func (q *Queries) UpdateBillingCycleStatus(ctx context.Context, arg UpdateBillin
 		&i.TotalAmount,
 		&i.Status,
 		&i.CreatedAt,
+		&i.PriceBookVersion,
+		&i.Quote,
+		&i.PaidAt,
+		&i.InvoiceNumber,
+		&i.Currency,
+		&i.FxRate,
+		&i.FxRateAt,
+		&i.Kind,
 	)
 	return i, err
 }
@@ -1472,7 +5100,7 @@ SET
     total_requests = $1,
     total_amount = $2
 WHERE id = $3
-RETURNING id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at
+RETURNING id, organization_id, period_start, period_end, total_requests, total_amount, status, created_at, price_book_version, quote, paid_at, invoice_number, currency, fx_rate, fx_rate_at, kind
 `
 
 type UpdateBillingCycleTotalsParams struct {
@@ -1493,6 +5121,102 @@ func (q *Queries) UpdateBillingCycleTotals(ctx context.Context, arg UpdateBillin
 		&i.TotalAmount,
 		&i.Status,
 		&i.CreatedAt,
+		&i.PriceBookVersion,
+		&i.Quote,
+		&i.PaidAt,
+		&i.InvoiceNumber,
+		&i.Currency,
+		&i.FxRate,
+		&i.FxRateAt,
+		&i.Kind,
+	)
+	return i, err
+}
+
+const updateCoupon = `-- name: UpdateCoupon :one
+
+UPDATE coupons
+SET name = $2,
+    plans = $3,
+    max_redemptions = $4,
+    redeem_by = $5,
+    active = $6,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, code, name, discount_type, percent_off, amount_off, currency, duration, duration_months, plans, max_redemptions, times_redeemed, redeem_by, active, created_at, updated_at
+`
+
+type UpdateCouponParams struct {
+	ID             uuid.UUID        `json:"id"`
+	Name           string           `json:"name"`
+	Plans          []string         `json:"plans"`
+	MaxRedemptions pgtype.Int4      `json:"max_redemptions"`
+	RedeemBy       pgtype.Timestamp `json:"redeem_by"`
+	Active         bool             `json:"active"`
+}
+
+// The discount itself never changes once a coupon may have been redeemed
+func (q *Queries) UpdateCoupon(ctx context.Context, arg UpdateCouponParams) (Coupon, error) {
+	row := q.db.QueryRow(ctx, updateCoupon,
+		arg.ID,
+		arg.Name,
+		arg.Plans,
+		arg.MaxRedemptions,
+		arg.RedeemBy,
+		arg.Active,
+	)
+	var i Coupon
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Name,
+		&i.DiscountType,
+		&i.PercentOff,
+		&i.AmountOff,
+		&i.Currency,
+		&i.Duration,
+		&i.DurationMonths,
+		&i.Plans,
+		&i.MaxRedemptions,
+		&i.TimesRedeemed,
+		&i.RedeemBy,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const updateOrganizationBillingCurrency = `-- name: UpdateOrganizationBillingCurrency :one
+UPDATE organizations
+SET billing_currency = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id
+`
+
+type UpdateOrganizationBillingCurrencyParams struct {
+	BillingCurrency string    `json:"billing_currency"`
+	ID              uuid.UUID `json:"id"`
+}
+
+func (q *Queries) UpdateOrganizationBillingCurrency(ctx context.Context, arg UpdateOrganizationBillingCurrencyParams) (Organization, error) {
+	row := q.db.QueryRow(ctx, updateOrganizationBillingCurrency, arg.BillingCurrency, arg.ID)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Plan,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.QuotaPolicy,
+		&i.BillingCurrency,
+		&i.TrialStatus,
+		&i.TrialPlan,
+		&i.TrialStartedAt,
+		&i.TrialEndsAt,
+		&i.TrialRemindedAt,
+		&i.StripeCustomerID,
 	)
 	return i, err
 }
@@ -1501,7 +5225,7 @@ const updateOrganizationPlan = `-- name: UpdateOrganizationPlan :one
 UPDATE organizations
 SET plan = $1, updated_at = NOW()
 WHERE id = $2
-RETURNING id, name, email, plan, created_at, updated_at
+RETURNING id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id
 `
 
 type UpdateOrganizationPlanParams struct {
@@ -1519,10 +5243,74 @@ func (q *Queries) UpdateOrganizationPlan(ctx context.Context, arg UpdateOrganiza
 		&i.Plan,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.QuotaPolicy,
+		&i.BillingCurrency,
+		&i.TrialStatus,
+		&i.TrialPlan,
+		&i.TrialStartedAt,
+		&i.TrialEndsAt,
+		&i.TrialRemindedAt,
+		&i.StripeCustomerID,
+	)
+	return i, err
+}
+
+const updateOrganizationQuotaPolicy = `-- name: UpdateOrganizationQuotaPolicy :one
+UPDATE organizations
+SET quota_policy = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING id, name, email, plan, created_at, updated_at, quota_policy, billing_currency, trial_status, trial_plan, trial_started_at, trial_ends_at, trial_reminded_at, stripe_customer_id
+`
+
+type UpdateOrganizationQuotaPolicyParams struct {
+	QuotaPolicy QuotaPolicy `json:"quota_policy"`
+	ID          uuid.UUID   `json:"id"`
+}
+
+func (q *Queries) UpdateOrganizationQuotaPolicy(ctx context.Context, arg UpdateOrganizationQuotaPolicyParams) (Organization, error) {
+	row := q.db.QueryRow(ctx, updateOrganizationQuotaPolicy, arg.QuotaPolicy, arg.ID)
+	var i Organization
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.Plan,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.QuotaPolicy,
+		&i.BillingCurrency,
+		&i.TrialStatus,
+		&i.TrialPlan,
+		&i.TrialStartedAt,
+		&i.TrialEndsAt,
+		&i.TrialRemindedAt,
+		&i.StripeCustomerID,
 	)
 	return i, err
 }
 
+const updateStripeSubscriptionInvoice = `-- name: UpdateStripeSubscriptionInvoice :execrows
+UPDATE stripe_subscriptions
+SET latest_invoice_id = $2,
+    latest_invoice_status = $3,
+    updated_at = NOW()
+WHERE subscription_id = $1
+`
+
+type UpdateStripeSubscriptionInvoiceParams struct {
+	SubscriptionID      string      `json:"subscription_id"`
+	LatestInvoiceID     pgtype.Text `json:"latest_invoice_id"`
+	LatestInvoiceStatus pgtype.Text `json:"latest_invoice_status"`
+}
+
+func (q *Queries) UpdateStripeSubscriptionInvoice(ctx context.Context, arg UpdateStripeSubscriptionInvoiceParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateStripeSubscriptionInvoice, arg.SubscriptionID, arg.LatestInvoiceID, arg.LatestInvoiceStatus)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateUserPassword = `-- name: UpdateUserPassword :one
 UPDATE users
 SET password_hash = $1
@@ -1580,6 +5368,402 @@ func (q *Queries) UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams)
 	return i, err
 }
 
+const updateWebhookEndpoint = `-- name: UpdateWebhookEndpoint :one
+UPDATE webhook_endpoints
+SET url = $2, secret = $3, updated_at = NOW()
+WHERE organization_id = $1
+RETURNING organization_id, url, secret, created_at, updated_at
+`
+
+type UpdateWebhookEndpointParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Url            string    `json:"url"`
+	Secret         string    `json:"secret"`
+}
+
+func (q *Queries) UpdateWebhookEndpoint(ctx context.Context, arg UpdateWebhookEndpointParams) (WebhookEndpoint, error) {
+	row := q.db.QueryRow(ctx, updateWebhookEndpoint, arg.OrganizationID, arg.Url, arg.Secret)
+	var i WebhookEndpoint
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Url,
+		&i.Secret,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertCreditWalletSettings = `-- name: UpsertCreditWalletSettings :one
+
+INSERT INTO credit_wallets (organization_id, low_balance_threshold, block_at_zero)
+VALUES ($1, $2, $3)
+ON CONFLICT (organization_id) DO UPDATE
+SET low_balance_threshold = EXCLUDED.low_balance_threshold,
+    block_at_zero = EXCLUDED.block_at_zero,
+    low_balance_notified_at = NULL,
+    blocked_at = CASE WHEN EXCLUDED.block_at_zero THEN credit_wallets.blocked_at END,
+    updated_at = NOW()
+RETURNING organization_id, low_balance_threshold, block_at_zero, low_balance_notified_at, blocked_at, created_at, updated_at
+`
+
+type UpsertCreditWalletSettingsParams struct {
+	OrganizationID      uuid.UUID      `json:"organization_id"`
+	LowBalanceThreshold pgtype.Numeric `json:"low_balance_threshold"`
+	BlockAtZero         bool           `json:"block_at_zero"`
+}
+
+// A new threshold is checked afresh, so its notice is reset
+func (q *Queries) UpsertCreditWalletSettings(ctx context.Context, arg UpsertCreditWalletSettingsParams) (CreditWallet, error) {
+	row := q.db.QueryRow(ctx, upsertCreditWalletSettings, arg.OrganizationID, arg.LowBalanceThreshold, arg.BlockAtZero)
+	var i CreditWallet
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.LowBalanceThreshold,
+		&i.BlockAtZero,
+		&i.LowBalanceNotifiedAt,
+		&i.BlockedAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertFXRate = `-- name: UpsertFXRate :one
+INSERT INTO fx_rates (base_currency, quote_currency, rate, effective_at, source)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (base_currency, quote_currency, effective_at) DO UPDATE
+SET rate = EXCLUDED.rate,
+    source = EXCLUDED.source
+RETURNING base_currency, quote_currency, rate, effective_at, source, created_at
+`
+
+type UpsertFXRateParams struct {
+	BaseCurrency  string           `json:"base_currency"`
+	QuoteCurrency string           `json:"quote_currency"`
+	Rate          pgtype.Numeric   `json:"rate"`
+	EffectiveAt   pgtype.Timestamp `json:"effective_at"`
+	Source        string           `json:"source"`
+}
+
+func (q *Queries) UpsertFXRate(ctx context.Context, arg UpsertFXRateParams) (FxRate, error) {
+	row := q.db.QueryRow(ctx, upsertFXRate,
+		arg.BaseCurrency,
+		arg.QuoteCurrency,
+		arg.Rate,
+		arg.EffectiveAt,
+		arg.Source,
+	)
+	var i FxRate
+	err := row.Scan(
+		&i.BaseCurrency,
+		&i.QuoteCurrency,
+		&i.Rate,
+		&i.EffectiveAt,
+		&i.Source,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const upsertOrganizationTaxProfile = `-- name: UpsertOrganizationTaxProfile :one
+INSERT INTO organization_tax_profiles (organization_id, country, vat_id)
+VALUES ($1, $2, $3)
+ON CONFLICT (organization_id) DO UPDATE
+SET country = EXCLUDED.country,
+    vat_id = EXCLUDED.vat_id,
+    updated_at = NOW()
+RETURNING organization_id, country, vat_id, tax_exempt, exemption_reason, created_at, updated_at
+`
+
+type UpsertOrganizationTaxProfileParams struct {
+	OrganizationID uuid.UUID   `json:"organization_id"`
+	Country        string      `json:"country"`
+	VatID          pgtype.Text `json:"vat_id"`
+}
+
+func (q *Queries) UpsertOrganizationTaxProfile(ctx context.Context, arg UpsertOrganizationTaxProfileParams) (OrganizationTaxProfile, error) {
+	row := q.db.QueryRow(ctx, upsertOrganizationTaxProfile, arg.OrganizationID, arg.Country, arg.VatID)
+	var i OrganizationTaxProfile
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Country,
+		&i.VatID,
+		&i.TaxExempt,
+		&i.ExemptionReason,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertPaymentMethod = `-- name: UpsertPaymentMethod :one
+
+INSERT INTO payment_methods (organization_id, provider, customer_id, reference, email, brand, last4)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (organization_id) DO UPDATE SET
+    provider = EXCLUDED.provider,
+    customer_id = EXCLUDED.customer_id,
+    reference = EXCLUDED.reference,
+    email = EXCLUDED.email,
+    brand = EXCLUDED.brand,
+    last4 = EXCLUDED.last4,
+    updated_at = NOW()
+RETURNING organization_id, provider, customer_id, reference, email, brand, last4, created_at, updated_at
+`
+
+type UpsertPaymentMethodParams struct {
+	OrganizationID uuid.UUID   `json:"organization_id"`
+	Provider       string      `json:"provider"`
+	CustomerID     pgtype.Text `json:"customer_id"`
+	Reference      string      `json:"reference"`
+	Email          string      `json:"email"`
+	Brand          pgtype.Text `json:"brand"`
+	Last4          pgtype.Text `json:"last4"`
+}
+
+// ============================================
+// PAYMENT METHOD QUERIES
+// ============================================
+func (q *Queries) UpsertPaymentMethod(ctx context.Context, arg UpsertPaymentMethodParams) (PaymentMethod, error) {
+	row := q.db.QueryRow(ctx, upsertPaymentMethod,
+		arg.OrganizationID,
+		arg.Provider,
+		arg.CustomerID,
+		arg.Reference,
+		arg.Email,
+		arg.Brand,
+		arg.Last4,
+	)
+	var i PaymentMethod
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Provider,
+		&i.CustomerID,
+		&i.Reference,
+		&i.Email,
+		&i.Brand,
+		&i.Last4,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertStripeSubscription = `-- name: UpsertStripeSubscription :one
+
+INSERT INTO stripe_subscriptions (
+    organization_id,
+    subscription_id,
+    customer_id,
+    plan,
+    status,
+    base_item_id,
+    metered_item_id,
+    current_period_start,
+    current_period_end,
+    cancel_at_period_end,
+    ended_at,
+    billing_started_at
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+ON CONFLICT (organization_id) DO UPDATE SET
+    subscription_id = EXCLUDED.subscription_id,
+    customer_id = EXCLUDED.customer_id,
+    plan = EXCLUDED.plan,
+    status = EXCLUDED.status,
+    base_item_id = EXCLUDED.base_item_id,
+    metered_item_id = EXCLUDED.metered_item_id,
+    current_period_start = EXCLUDED.current_period_start,
+    current_period_end = EXCLUDED.current_period_end,
+    cancel_at_period_end = EXCLUDED.cancel_at_period_end,
+    ended_at = EXCLUDED.ended_at,
+    -- A new subscription starts billing and reporting usage afresh
+    billing_started_at = CASE
+        WHEN stripe_subscriptions.subscription_id = EXCLUDED.subscription_id
+            THEN COALESCE(stripe_subscriptions.billing_started_at, EXCLUDED.billing_started_at)
+        ELSE EXCLUDED.billing_started_at
+    END,
+    usage_reported_through = CASE
+        WHEN stripe_subscriptions.subscription_id = EXCLUDED.subscription_id
+            THEN stripe_subscriptions.usage_reported_through
+    END,
+    latest_invoice_id = CASE
+        WHEN stripe_subscriptions.subscription_id = EXCLUDED.subscription_id
+            THEN stripe_subscriptions.latest_invoice_id
+    END,
+    latest_invoice_status = CASE
+        WHEN stripe_subscriptions.subscription_id = EXCLUDED.subscription_id
+            THEN stripe_subscriptions.latest_invoice_status
+    END,
+    updated_at = NOW()
+RETURNING *
+`
+
+type UpsertStripeSubscriptionParams struct {
+	OrganizationID     uuid.UUID        `json:"organization_id"`
+	SubscriptionID     string           `json:"subscription_id"`
+	CustomerID         string           `json:"customer_id"`
+	Plan               PlanType         `json:"plan"`
+	Status             string           `json:"status"`
+	BaseItemID         pgtype.Text      `json:"base_item_id"`
+	MeteredItemID      pgtype.Text      `json:"metered_item_id"`
+	CurrentPeriodStart pgtype.Timestamp `json:"current_period_start"`
+	CurrentPeriodEnd   pgtype.Timestamp `json:"current_period_end"`
+	CancelAtPeriodEnd  bool             `json:"cancel_at_period_end"`
+	EndedAt            pgtype.Timestamp `json:"ended_at"`
+	BillingStartedAt   pgtype.Timestamp `json:"billing_started_at"`
+}
+
+// ============================================
+// STRIPE SUBSCRIPTION QUERIES
+// ============================================
+func (q *Queries) UpsertStripeSubscription(ctx context.Context, arg UpsertStripeSubscriptionParams) (StripeSubscription, error) {
+	row := q.db.QueryRow(ctx, upsertStripeSubscription,
+		arg.OrganizationID,
+		arg.SubscriptionID,
+		arg.CustomerID,
+		arg.Plan,
+		arg.Status,
+		arg.BaseItemID,
+		arg.MeteredItemID,
+		arg.CurrentPeriodStart,
+		arg.CurrentPeriodEnd,
+		arg.CancelAtPeriodEnd,
+		arg.EndedAt,
+		arg.BillingStartedAt,
+	)
+	var i StripeSubscription
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.SubscriptionID,
+		&i.CustomerID,
+		&i.Plan,
+		&i.Status,
+		&i.BaseItemID,
+		&i.MeteredItemID,
+		&i.CurrentPeriodStart,
+		&i.CurrentPeriodEnd,
+		&i.CancelAtPeriodEnd,
+		&i.BillingStartedAt,
+		&i.UsageReportedThrough,
+		&i.EndedAt,
+		&i.LatestInvoiceID,
+		&i.LatestInvoiceStatus,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const upsertTaxRate = `-- name: UpsertTaxRate :one
+INSERT INTO tax_rates (country, effective_from, name, rate, reverse_charge)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (country, effective_from) DO UPDATE
+SET name = EXCLUDED.name,
+    rate = EXCLUDED.rate,
+    reverse_charge = EXCLUDED.reverse_charge
+RETURNING country, effective_from, name, rate, reverse_charge, created_at
+`
+
+type UpsertTaxRateParams struct {
+	Country       string         `json:"country"`
+	EffectiveFrom pgtype.Date    `json:"effective_from"`
+	Name          string         `json:"name"`
+	Rate          pgtype.Numeric `json:"rate"`
+	ReverseCharge bool           `json:"reverse_charge"`
+}
+
+func (q *Queries) UpsertTaxRate(ctx context.Context, arg UpsertTaxRateParams) (TaxRate, error) {
+	row := q.db.QueryRow(ctx, upsertTaxRate,
+		arg.Country,
+		arg.EffectiveFrom,
+		arg.Name,
+		arg.Rate,
+		arg.ReverseCharge,
+	)
+	var i TaxRate
+	err := row.Scan(
+		&i.Country,
+		&i.EffectiveFrom,
+		&i.Name,
+		&i.Rate,
+		&i.ReverseCharge,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const upsertUsageBudget = `-- name: UpsertUsageBudget :one
+
+INSERT INTO usage_budgets (organization_id, metric, amount, thresholds, disable_keys_at_limit)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (organization_id) DO UPDATE
+SET metric = EXCLUDED.metric,
+    amount = EXCLUDED.amount,
+    thresholds = EXCLUDED.thresholds,
+    disable_keys_at_limit = EXCLUDED.disable_keys_at_limit,
+    updated_at = NOW()
+RETURNING organization_id, metric, amount, thresholds, disable_keys_at_limit, created_at, updated_at
+`
+
+type UpsertUsageBudgetParams struct {
+	OrganizationID     uuid.UUID      `json:"organization_id"`
+	Metric             BudgetMetric   `json:"metric"`
+	Amount             pgtype.Numeric `json:"amount"`
+	Thresholds         []int32        `json:"thresholds"`
+	DisableKeysAtLimit bool           `json:"disable_keys_at_limit"`
+}
+
+// ============================================
+// USAGE BUDGET QUERIES
+// ============================================
+func (q *Queries) UpsertUsageBudget(ctx context.Context, arg UpsertUsageBudgetParams) (UsageBudget, error) {
+	row := q.db.QueryRow(ctx, upsertUsageBudget,
+		arg.OrganizationID,
+		arg.Metric,
+		arg.Amount,
+		arg.Thresholds,
+		arg.DisableKeysAtLimit,
+	)
+	var i UsageBudget
+	err := row.Scan(
+		&i.OrganizationID,
+		&i.Metric,
+		&i.Amount,
+		&i.Thresholds,
+		&i.DisableKeysAtLimit,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const useCouponRedemption = `-- name: UseCouponRedemption :one
+
+UPDATE coupon_redemptions
+SET periods_remaining = periods_remaining - 1,
+    ended_at = CASE WHEN periods_remaining = 1 THEN NOW() END
+WHERE id = $1
+RETURNING id, coupon_id, organization_id, redeemed_by, periods_remaining, redeemed_at, ended_at
+`
+
+// Forever redemptions have no count and never end.
+func (q *Queries) UseCouponRedemption(ctx context.Context, id uuid.UUID) (CouponRedemption, error) {
+	row := q.db.QueryRow(ctx, useCouponRedemption, id)
+	var i CouponRedemption
+	err := row.Scan(
+		&i.ID,
+		&i.CouponID,
+		&i.OrganizationID,
+		&i.RedeemedBy,
+		&i.PeriodsRemaining,
+		&i.RedeemedAt,
+		&i.EndedAt,
+	)
+	return i, err
+}
+
 const verifyUserEmail = `-- name: VerifyUserEmail :one
 UPDATE users
 SET email_verified = true, email_verified_at = NOW()
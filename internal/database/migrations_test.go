@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// upMigration returns the statements a migration in sql/schema applies
+func upMigration(t *testing.T, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("..", "..", "sql", "schema", name))
+	if err != nil {
+		t.Fatalf("failed to read migration: %v", err)
+	}
+	up, _, ok := strings.Cut(string(content), "-- +goose Down")
+	if !ok {
+		t.Fatalf("migration %s has no down section", name)
+	}
+	return up
+}
+
+// TestBillingCyclePeriodKeyMigration applies the unique period key to
+// billing cycles with and without invoices issued twice. It runs against
+// TEST_DATABASE_URL, on a temporary table standing in for billing_cycles.
+func TestBillingCyclePeriodKeyMigration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+	dbURL := os.Getenv("TEST_DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	up := upMigration(t, "20261019060000_024_add_billing_cycle_period_key.sql")
+	org := uuid.New()
+	october := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	november := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	type cycle struct {
+		periodStart time.Time
+		kind        string
+		number      string
+	}
+	tests := []struct {
+		name    string
+		cycles  []cycle
+		wantErr string
+	}{
+		{
+			name: "One invoice per period and kind",
+			cycles: []cycle{
+				{october, "period", "INV-2026-000001"},
+				{october, "proration", "INV-2026-000002"},
+				{november, "period", "INV-2026-000003"},
+			},
+		},
+		{
+			name: "A period invoiced twice",
+			cycles: []cycle{
+				{october, "period", "INV-2026-000001"},
+				{october, "period", "INV-2026-000002"},
+				{november, "period", "INV-2026-000003"},
+			},
+			wantErr: "1 billing periods were invoiced more than once, e.g. INV-2026-000001 (pending), INV-2026-000002 (pending)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				t.Fatalf("Begin() error = %v", err)
+			}
+			defer tx.Rollback(ctx)
+
+			// Temporary tables come first on the search path
+			if _, err := tx.Exec(ctx, `CREATE TEMP TABLE billing_cycles (
+				organization_id UUID NOT NULL,
+				period_start TIMESTAMP NOT NULL,
+				kind TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending',
+				invoice_number TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			)`); err != nil {
+				t.Fatalf("failed to create billing_cycles: %v", err)
+			}
+			for i, c := range tt.cycles {
+				if _, err := tx.Exec(ctx,
+					`INSERT INTO billing_cycles (organization_id, period_start, kind, invoice_number, created_at) VALUES ($1, $2, $3, $4, $5)`,
+					org, c.periodStart, c.kind, c.number, october.Add(time.Duration(i)*time.Minute)); err != nil {
+					t.Fatalf("failed to insert billing cycle: %v", err)
+				}
+			}
+
+			_, err = tx.Exec(ctx, up)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("migration error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("migration error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
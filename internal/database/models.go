@@ -12,12 +12,55 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type BillingCycleKind string
+
+const (
+	BillingCycleKindPeriod    BillingCycleKind = "period"
+	BillingCycleKindProration BillingCycleKind = "proration"
+)
+
+func (e *BillingCycleKind) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = BillingCycleKind(s)
+	case string:
+		*e = BillingCycleKind(s)
+	default:
+		return fmt.Errorf("unsupported scan type for BillingCycleKind: %T", src)
+	}
+	return nil
+}
+
+type NullBillingCycleKind struct {
+	BillingCycleKind BillingCycleKind `json:"billing_cycle_kind"`
+	Valid            bool             `json:"valid"` // Valid is true if BillingCycleKind is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullBillingCycleKind) Scan(value interface{}) error {
+	if value == nil {
+		ns.BillingCycleKind, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.BillingCycleKind.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullBillingCycleKind) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.BillingCycleKind), nil
+}
+
 type BillingStatus string
 
 const (
 	BillingStatusPending BillingStatus = "pending"
 	BillingStatusPaid    BillingStatus = "paid"
 	BillingStatusOverdue BillingStatus = "overdue"
+	BillingStatusVoid    BillingStatus = "void"
 )
 
 func (e *BillingStatus) Scan(src interface{}) error {
@@ -44,58 +87,529 @@ func (ns *NullBillingStatus) Scan(value interface{}) error {
 		return nil
 	}
 	ns.Valid = true
-	return ns.BillingStatus.Scan(value)
+	return ns.BillingStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullBillingStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.BillingStatus), nil
+}
+
+type BudgetMetric string
+
+const (
+	BudgetMetricRequests BudgetMetric = "requests"
+	BudgetMetricSpend    BudgetMetric = "spend"
+)
+
+func (e *BudgetMetric) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = BudgetMetric(s)
+	case string:
+		*e = BudgetMetric(s)
+	default:
+		return fmt.Errorf("unsupported scan type for BudgetMetric: %T", src)
+	}
+	return nil
+}
+
+type NullBudgetMetric struct {
+	BudgetMetric BudgetMetric `json:"budget_metric"`
+	Valid        bool         `json:"valid"` // Valid is true if BudgetMetric is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullBudgetMetric) Scan(value interface{}) error {
+	if value == nil {
+		ns.BudgetMetric, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.BudgetMetric.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullBudgetMetric) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.BudgetMetric), nil
+}
+
+type CouponDiscountType string
+
+const (
+	CouponDiscountTypePercentage CouponDiscountType = "percentage"
+	CouponDiscountTypeFixed      CouponDiscountType = "fixed"
+)
+
+func (e *CouponDiscountType) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = CouponDiscountType(s)
+	case string:
+		*e = CouponDiscountType(s)
+	default:
+		return fmt.Errorf("unsupported scan type for CouponDiscountType: %T", src)
+	}
+	return nil
+}
+
+type NullCouponDiscountType struct {
+	CouponDiscountType CouponDiscountType `json:"coupon_discount_type"`
+	Valid              bool               `json:"valid"` // Valid is true if CouponDiscountType is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullCouponDiscountType) Scan(value interface{}) error {
+	if value == nil {
+		ns.CouponDiscountType, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.CouponDiscountType.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullCouponDiscountType) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.CouponDiscountType), nil
+}
+
+type CouponDuration string
+
+const (
+	CouponDurationOnce      CouponDuration = "once"
+	CouponDurationRepeating CouponDuration = "repeating"
+	CouponDurationForever   CouponDuration = "forever"
+)
+
+func (e *CouponDuration) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = CouponDuration(s)
+	case string:
+		*e = CouponDuration(s)
+	default:
+		return fmt.Errorf("unsupported scan type for CouponDuration: %T", src)
+	}
+	return nil
+}
+
+type NullCouponDuration struct {
+	CouponDuration CouponDuration `json:"coupon_duration"`
+	Valid          bool           `json:"valid"` // Valid is true if CouponDuration is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullCouponDuration) Scan(value interface{}) error {
+	if value == nil {
+		ns.CouponDuration, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.CouponDuration.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullCouponDuration) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.CouponDuration), nil
+}
+
+type CreditAccount string
+
+const (
+	CreditAccountWallet      CreditAccount = "wallet"
+	CreditAccountPayments    CreditAccount = "payments"
+	CreditAccountReceivables CreditAccount = "receivables"
+)
+
+func (e *CreditAccount) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = CreditAccount(s)
+	case string:
+		*e = CreditAccount(s)
+	default:
+		return fmt.Errorf("unsupported scan type for CreditAccount: %T", src)
+	}
+	return nil
+}
+
+type NullCreditAccount struct {
+	CreditAccount CreditAccount `json:"credit_account"`
+	Valid         bool          `json:"valid"` // Valid is true if CreditAccount is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullCreditAccount) Scan(value interface{}) error {
+	if value == nil {
+		ns.CreditAccount, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.CreditAccount.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullCreditAccount) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.CreditAccount), nil
+}
+
+type CreditDirection string
+
+const (
+	CreditDirectionDebit  CreditDirection = "debit"
+	CreditDirectionCredit CreditDirection = "credit"
+)
+
+func (e *CreditDirection) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = CreditDirection(s)
+	case string:
+		*e = CreditDirection(s)
+	default:
+		return fmt.Errorf("unsupported scan type for CreditDirection: %T", src)
+	}
+	return nil
+}
+
+type NullCreditDirection struct {
+	CreditDirection CreditDirection `json:"credit_direction"`
+	Valid           bool            `json:"valid"` // Valid is true if CreditDirection is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullCreditDirection) Scan(value interface{}) error {
+	if value == nil {
+		ns.CreditDirection, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.CreditDirection.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullCreditDirection) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.CreditDirection), nil
+}
+
+type CreditTopUpStatus string
+
+const (
+	CreditTopUpStatusPending   CreditTopUpStatus = "pending"
+	CreditTopUpStatusCompleted CreditTopUpStatus = "completed"
+	CreditTopUpStatusFailed    CreditTopUpStatus = "failed"
+)
+
+func (e *CreditTopUpStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = CreditTopUpStatus(s)
+	case string:
+		*e = CreditTopUpStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for CreditTopUpStatus: %T", src)
+	}
+	return nil
+}
+
+type NullCreditTopUpStatus struct {
+	CreditTopUpStatus CreditTopUpStatus `json:"credit_top_up_status"`
+	Valid             bool              `json:"valid"` // Valid is true if CreditTopUpStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullCreditTopUpStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.CreditTopUpStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.CreditTopUpStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullCreditTopUpStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.CreditTopUpStatus), nil
+}
+
+type CreditTransactionType string
+
+const (
+	CreditTransactionTypeTopUp          CreditTransactionType = "top_up"
+	CreditTransactionTypeInvoicePayment CreditTransactionType = "invoice_payment"
+)
+
+func (e *CreditTransactionType) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = CreditTransactionType(s)
+	case string:
+		*e = CreditTransactionType(s)
+	default:
+		return fmt.Errorf("unsupported scan type for CreditTransactionType: %T", src)
+	}
+	return nil
+}
+
+type NullCreditTransactionType struct {
+	CreditTransactionType CreditTransactionType `json:"credit_transaction_type"`
+	Valid                 bool                  `json:"valid"` // Valid is true if CreditTransactionType is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullCreditTransactionType) Scan(value interface{}) error {
+	if value == nil {
+		ns.CreditTransactionType, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.CreditTransactionType.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullCreditTransactionType) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.CreditTransactionType), nil
+}
+
+type InvoiceLineItemType string
+
+const (
+	InvoiceLineItemTypeBaseFee  InvoiceLineItemType = "base_fee"
+	InvoiceLineItemTypeUsage    InvoiceLineItemType = "usage"
+	InvoiceLineItemTypeCredit   InvoiceLineItemType = "credit"
+	InvoiceLineItemTypeTax      InvoiceLineItemType = "tax"
+	InvoiceLineItemTypeDiscount InvoiceLineItemType = "discount"
+)
+
+func (e *InvoiceLineItemType) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = InvoiceLineItemType(s)
+	case string:
+		*e = InvoiceLineItemType(s)
+	default:
+		return fmt.Errorf("unsupported scan type for InvoiceLineItemType: %T", src)
+	}
+	return nil
+}
+
+type NullInvoiceLineItemType struct {
+	InvoiceLineItemType InvoiceLineItemType `json:"invoice_line_item_type"`
+	Valid               bool                `json:"valid"` // Valid is true if InvoiceLineItemType is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullInvoiceLineItemType) Scan(value interface{}) error {
+	if value == nil {
+		ns.InvoiceLineItemType, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.InvoiceLineItemType.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullInvoiceLineItemType) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.InvoiceLineItemType), nil
+}
+
+type OutboundEventStatus string
+
+const (
+	OutboundEventStatusPending   OutboundEventStatus = "pending"
+	OutboundEventStatusDelivered OutboundEventStatus = "delivered"
+	OutboundEventStatusFailed    OutboundEventStatus = "failed"
+)
+
+func (e *OutboundEventStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = OutboundEventStatus(s)
+	case string:
+		*e = OutboundEventStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for OutboundEventStatus: %T", src)
+	}
+	return nil
+}
+
+type NullOutboundEventStatus struct {
+	OutboundEventStatus OutboundEventStatus `json:"outbound_event_status"`
+	Valid               bool                `json:"valid"` // Valid is true if OutboundEventStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullOutboundEventStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.OutboundEventStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.OutboundEventStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullOutboundEventStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.OutboundEventStatus), nil
+}
+
+type PlanChangeStatus string
+
+const (
+	PlanChangeStatusPending   PlanChangeStatus = "pending"
+	PlanChangeStatusApplied   PlanChangeStatus = "applied"
+	PlanChangeStatusCancelled PlanChangeStatus = "cancelled"
+)
+
+func (e *PlanChangeStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = PlanChangeStatus(s)
+	case string:
+		*e = PlanChangeStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for PlanChangeStatus: %T", src)
+	}
+	return nil
+}
+
+type NullPlanChangeStatus struct {
+	PlanChangeStatus PlanChangeStatus `json:"plan_change_status"`
+	Valid            bool             `json:"valid"` // Valid is true if PlanChangeStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullPlanChangeStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.PlanChangeStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.PlanChangeStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullPlanChangeStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.PlanChangeStatus), nil
+}
+
+type PlanType string
+
+const (
+	PlanTypeFree    PlanType = "free"
+	PlanTypeStarter PlanType = "starter"
+	PlanTypePro     PlanType = "pro"
+)
+
+func (e *PlanType) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = PlanType(s)
+	case string:
+		*e = PlanType(s)
+	default:
+		return fmt.Errorf("unsupported scan type for PlanType: %T", src)
+	}
+	return nil
+}
+
+type NullPlanType struct {
+	PlanType PlanType `json:"plan_type"`
+	Valid    bool     `json:"valid"` // Valid is true if PlanType is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullPlanType) Scan(value interface{}) error {
+	if value == nil {
+		ns.PlanType, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.PlanType.Scan(value)
 }
 
 // Value implements the driver Valuer interface.
-func (ns NullBillingStatus) Value() (driver.Value, error) {
+func (ns NullPlanType) Value() (driver.Value, error) {
 	if !ns.Valid {
 		return nil, nil
 	}
-	return string(ns.BillingStatus), nil
+	return string(ns.PlanType), nil
 }
 
-type PlanType string
+type QuotaPolicy string
 
 const (
-	PlanTypeFree    PlanType = "free"
-	PlanTypeStarter PlanType = "starter"
-	PlanTypePro     PlanType = "pro"
+	QuotaPolicyBlock   QuotaPolicy = "block"
+	QuotaPolicyOverage QuotaPolicy = "overage"
+	QuotaPolicyNotify  QuotaPolicy = "notify"
 )
 
-func (e *PlanType) Scan(src interface{}) error {
+func (e *QuotaPolicy) Scan(src interface{}) error {
 	switch s := src.(type) {
 	case []byte:
-		*e = PlanType(s)
+		*e = QuotaPolicy(s)
 	case string:
-		*e = PlanType(s)
+		*e = QuotaPolicy(s)
 	default:
-		return fmt.Errorf("unsupported scan type for PlanType: %T", src)
+		return fmt.Errorf("unsupported scan type for QuotaPolicy: %T", src)
 	}
 	return nil
 }
 
-type NullPlanType struct {
-	PlanType PlanType `json:"plan_type"`
-	Valid    bool     `json:"valid"` // Valid is true if PlanType is not NULL
+type NullQuotaPolicy struct {
+	QuotaPolicy QuotaPolicy `json:"quota_policy"`
+	Valid       bool        `json:"valid"` // Valid is true if QuotaPolicy is not NULL
 }
 
 // Scan implements the Scanner interface.
-func (ns *NullPlanType) Scan(value interface{}) error {
+func (ns *NullQuotaPolicy) Scan(value interface{}) error {
 	if value == nil {
-		ns.PlanType, ns.Valid = "", false
+		ns.QuotaPolicy, ns.Valid = "", false
 		return nil
 	}
 	ns.Valid = true
-	return ns.PlanType.Scan(value)
+	return ns.QuotaPolicy.Scan(value)
 }
 
 // Value implements the driver Valuer interface.
-func (ns NullPlanType) Value() (driver.Value, error) {
+func (ns NullQuotaPolicy) Value() (driver.Value, error) {
 	if !ns.Valid {
 		return nil, nil
 	}
-	return string(ns.PlanType), nil
+	return string(ns.QuotaPolicy), nil
 }
 
 type TokenType string
@@ -141,6 +655,262 @@ func (ns NullTokenType) Value() (driver.Value, error) {
 	return string(ns.TokenType), nil
 }
 
+type TrialStatus string
+
+const (
+	TrialStatusActive    TrialStatus = "active"
+	TrialStatusConverted TrialStatus = "converted"
+	TrialStatusExpired   TrialStatus = "expired"
+)
+
+func (e *TrialStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = TrialStatus(s)
+	case string:
+		*e = TrialStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for TrialStatus: %T", src)
+	}
+	return nil
+}
+
+type NullTrialStatus struct {
+	TrialStatus TrialStatus `json:"trial_status"`
+	Valid       bool        `json:"valid"` // Valid is true if TrialStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullTrialStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.TrialStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.TrialStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullTrialStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.TrialStatus), nil
+}
+
+type UsageAlertDirection string
+
+const (
+	UsageAlertDirectionSpike UsageAlertDirection = "spike"
+	UsageAlertDirectionDrop  UsageAlertDirection = "drop"
+)
+
+func (e *UsageAlertDirection) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = UsageAlertDirection(s)
+	case string:
+		*e = UsageAlertDirection(s)
+	default:
+		return fmt.Errorf("unsupported scan type for UsageAlertDirection: %T", src)
+	}
+	return nil
+}
+
+type NullUsageAlertDirection struct {
+	UsageAlertDirection UsageAlertDirection `json:"usage_alert_direction"`
+	Valid               bool                `json:"valid"` // Valid is true if UsageAlertDirection is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullUsageAlertDirection) Scan(value interface{}) error {
+	if value == nil {
+		ns.UsageAlertDirection, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.UsageAlertDirection.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullUsageAlertDirection) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.UsageAlertDirection), nil
+}
+
+type UsageAlertMetric string
+
+const (
+	UsageAlertMetricRequestVolume UsageAlertMetric = "request_volume"
+	UsageAlertMetricErrorRate     UsageAlertMetric = "error_rate"
+)
+
+func (e *UsageAlertMetric) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = UsageAlertMetric(s)
+	case string:
+		*e = UsageAlertMetric(s)
+	default:
+		return fmt.Errorf("unsupported scan type for UsageAlertMetric: %T", src)
+	}
+	return nil
+}
+
+type NullUsageAlertMetric struct {
+	UsageAlertMetric UsageAlertMetric `json:"usage_alert_metric"`
+	Valid            bool             `json:"valid"` // Valid is true if UsageAlertMetric is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullUsageAlertMetric) Scan(value interface{}) error {
+	if value == nil {
+		ns.UsageAlertMetric, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.UsageAlertMetric.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullUsageAlertMetric) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.UsageAlertMetric), nil
+}
+
+type UsageAlertStatus string
+
+const (
+	UsageAlertStatusOpen         UsageAlertStatus = "open"
+	UsageAlertStatusAcknowledged UsageAlertStatus = "acknowledged"
+)
+
+func (e *UsageAlertStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = UsageAlertStatus(s)
+	case string:
+		*e = UsageAlertStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for UsageAlertStatus: %T", src)
+	}
+	return nil
+}
+
+type NullUsageAlertStatus struct {
+	UsageAlertStatus UsageAlertStatus `json:"usage_alert_status"`
+	Valid            bool             `json:"valid"` // Valid is true if UsageAlertStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullUsageAlertStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.UsageAlertStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.UsageAlertStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullUsageAlertStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.UsageAlertStatus), nil
+}
+
+type UsageExportFormat string
+
+const (
+	UsageExportFormatCsv    UsageExportFormat = "csv"
+	UsageExportFormatNdjson UsageExportFormat = "ndjson"
+)
+
+func (e *UsageExportFormat) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = UsageExportFormat(s)
+	case string:
+		*e = UsageExportFormat(s)
+	default:
+		return fmt.Errorf("unsupported scan type for UsageExportFormat: %T", src)
+	}
+	return nil
+}
+
+type NullUsageExportFormat struct {
+	UsageExportFormat UsageExportFormat `json:"usage_export_format"`
+	Valid             bool              `json:"valid"` // Valid is true if UsageExportFormat is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullUsageExportFormat) Scan(value interface{}) error {
+	if value == nil {
+		ns.UsageExportFormat, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.UsageExportFormat.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullUsageExportFormat) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.UsageExportFormat), nil
+}
+
+type UsageExportStatus string
+
+const (
+	UsageExportStatusPending   UsageExportStatus = "pending"
+	UsageExportStatusRunning   UsageExportStatus = "running"
+	UsageExportStatusCompleted UsageExportStatus = "completed"
+	UsageExportStatusFailed    UsageExportStatus = "failed"
+	UsageExportStatusExpired   UsageExportStatus = "expired"
+)
+
+func (e *UsageExportStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = UsageExportStatus(s)
+	case string:
+		*e = UsageExportStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for UsageExportStatus: %T", src)
+	}
+	return nil
+}
+
+type NullUsageExportStatus struct {
+	UsageExportStatus UsageExportStatus `json:"usage_export_status"`
+	Valid             bool              `json:"valid"` // Valid is true if UsageExportStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullUsageExportStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.UsageExportStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.UsageExportStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullUsageExportStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.UsageExportStatus), nil
+}
+
 type UserRole string
 
 const (
@@ -192,6 +962,8 @@ type ApiKey struct {
 	IsActive       bool             `json:"is_active"`
 	CreatedAt      pgtype.Timestamp `json:"created_at"`
 	LastUsedAt     pgtype.Timestamp `json:"last_used_at"`
+	Essential      bool             `json:"essential"`
+	SuspendedAt    pgtype.Timestamp `json:"suspended_at"`
 }
 
 type AuthToken struct {
@@ -205,23 +977,244 @@ type AuthToken struct {
 }
 
 type BillingCycle struct {
+	ID               uuid.UUID        `json:"id"`
+	OrganizationID   uuid.UUID        `json:"organization_id"`
+	PeriodStart      pgtype.Timestamp `json:"period_start"`
+	PeriodEnd        pgtype.Timestamp `json:"period_end"`
+	TotalRequests    int32            `json:"total_requests"`
+	TotalAmount      pgtype.Numeric   `json:"total_amount"`
+	Status           BillingStatus    `json:"status"`
+	CreatedAt        pgtype.Timestamp `json:"created_at"`
+	PriceBookVersion *string          `json:"price_book_version"`
+	Quote            []byte           `json:"quote"`
+	PaidAt           pgtype.Timestamp `json:"paid_at"`
+	InvoiceNumber    string           `json:"invoice_number"`
+	Currency         string           `json:"currency"`
+	FxRate           pgtype.Numeric   `json:"fx_rate"`
+	FxRateAt         pgtype.Timestamp `json:"fx_rate_at"`
+	Kind             BillingCycleKind `json:"kind"`
+}
+
+type Coupon struct {
+	ID             uuid.UUID          `json:"id"`
+	Code           string             `json:"code"`
+	Name           string             `json:"name"`
+	DiscountType   CouponDiscountType `json:"discount_type"`
+	PercentOff     pgtype.Numeric     `json:"percent_off"`
+	AmountOff      pgtype.Numeric     `json:"amount_off"`
+	Currency       pgtype.Text        `json:"currency"`
+	Duration       CouponDuration     `json:"duration"`
+	DurationMonths pgtype.Int4        `json:"duration_months"`
+	Plans          []string           `json:"plans"`
+	MaxRedemptions pgtype.Int4        `json:"max_redemptions"`
+	TimesRedeemed  int32              `json:"times_redeemed"`
+	RedeemBy       pgtype.Timestamp   `json:"redeem_by"`
+	Active         bool               `json:"active"`
+	CreatedAt      pgtype.Timestamp   `json:"created_at"`
+	UpdatedAt      pgtype.Timestamp   `json:"updated_at"`
+}
+
+type CouponRedemption struct {
+	ID               uuid.UUID        `json:"id"`
+	CouponID         uuid.UUID        `json:"coupon_id"`
+	OrganizationID   uuid.UUID        `json:"organization_id"`
+	RedeemedBy       pgtype.UUID      `json:"redeemed_by"`
+	PeriodsRemaining pgtype.Int4      `json:"periods_remaining"`
+	RedeemedAt       pgtype.Timestamp `json:"redeemed_at"`
+	EndedAt          pgtype.Timestamp `json:"ended_at"`
+}
+
+type CreditLedgerEntry struct {
 	ID             uuid.UUID        `json:"id"`
+	TransactionID  uuid.UUID        `json:"transaction_id"`
 	OrganizationID uuid.UUID        `json:"organization_id"`
-	PeriodStart    pgtype.Timestamp `json:"period_start"`
-	PeriodEnd      pgtype.Timestamp `json:"period_end"`
-	TotalRequests  int32            `json:"total_requests"`
-	TotalAmount    pgtype.Numeric   `json:"total_amount"`
-	Status         BillingStatus    `json:"status"`
+	Account        CreditAccount    `json:"account"`
+	Direction      CreditDirection  `json:"direction"`
+	Currency       string           `json:"currency"`
+	Amount         pgtype.Numeric   `json:"amount"`
 	CreatedAt      pgtype.Timestamp `json:"created_at"`
 }
 
+type CreditTopUp struct {
+	ID             uuid.UUID         `json:"id"`
+	OrganizationID uuid.UUID         `json:"organization_id"`
+	Provider       string            `json:"provider"`
+	Currency       string            `json:"currency"`
+	Amount         pgtype.Numeric    `json:"amount"`
+	Status         CreditTopUpStatus `json:"status"`
+	CreatedBy      pgtype.UUID       `json:"created_by"`
+	CreatedAt      pgtype.Timestamp  `json:"created_at"`
+	CompletedAt    pgtype.Timestamp  `json:"completed_at"`
+}
+
+type CreditTransaction struct {
+	ID             uuid.UUID             `json:"id"`
+	OrganizationID uuid.UUID             `json:"organization_id"`
+	Type           CreditTransactionType `json:"type"`
+	Currency       string                `json:"currency"`
+	Amount         pgtype.Numeric        `json:"amount"`
+	Description    string                `json:"description"`
+	TopUpID        pgtype.UUID           `json:"top_up_id"`
+	BillingCycleID pgtype.UUID           `json:"billing_cycle_id"`
+	CreatedAt      pgtype.Timestamp      `json:"created_at"`
+}
+
+type CreditWallet struct {
+	OrganizationID       uuid.UUID        `json:"organization_id"`
+	LowBalanceThreshold  pgtype.Numeric   `json:"low_balance_threshold"`
+	BlockAtZero          bool             `json:"block_at_zero"`
+	LowBalanceNotifiedAt pgtype.Timestamp `json:"low_balance_notified_at"`
+	BlockedAt            pgtype.Timestamp `json:"blocked_at"`
+	CreatedAt            pgtype.Timestamp `json:"created_at"`
+	UpdatedAt            pgtype.Timestamp `json:"updated_at"`
+}
+
+type FxRate struct {
+	BaseCurrency  string           `json:"base_currency"`
+	QuoteCurrency string           `json:"quote_currency"`
+	Rate          pgtype.Numeric   `json:"rate"`
+	EffectiveAt   pgtype.Timestamp `json:"effective_at"`
+	Source        string           `json:"source"`
+	CreatedAt     pgtype.Timestamp `json:"created_at"`
+}
+
+type InvoiceLineItem struct {
+	ID             uuid.UUID           `json:"id"`
+	BillingCycleID uuid.UUID           `json:"billing_cycle_id"`
+	Position       int32               `json:"position"`
+	Type           InvoiceLineItemType `json:"type"`
+	Description    string              `json:"description"`
+	Quantity       int64               `json:"quantity"`
+	UnitPrice      pgtype.Numeric      `json:"unit_price"`
+	Amount         pgtype.Numeric      `json:"amount"`
+	CreatedAt      pgtype.Timestamp    `json:"created_at"`
+}
+
+type InvoiceNumberSequence struct {
+	Issuer     string `json:"issuer"`
+	Year       int32  `json:"year"`
+	LastNumber int64  `json:"last_number"`
+}
+
+type InvoicePaymentAttempt struct {
+	BillingCycleID uuid.UUID `json:"billing_cycle_id"`
+	Attempts       int32     `json:"attempts"`
+}
+
 type Organization struct {
-	ID        uuid.UUID        `json:"id"`
-	Name      string           `json:"name"`
-	Email     string           `json:"email"`
-	Plan      PlanType         `json:"plan"`
-	CreatedAt pgtype.Timestamp `json:"created_at"`
-	UpdatedAt pgtype.Timestamp `json:"updated_at"`
+	ID               uuid.UUID        `json:"id"`
+	Name             string           `json:"name"`
+	Email            string           `json:"email"`
+	Plan             PlanType         `json:"plan"`
+	CreatedAt        pgtype.Timestamp `json:"created_at"`
+	UpdatedAt        pgtype.Timestamp `json:"updated_at"`
+	QuotaPolicy      QuotaPolicy      `json:"quota_policy"`
+	BillingCurrency  string           `json:"billing_currency"`
+	TrialStatus      NullTrialStatus  `json:"trial_status"`
+	TrialPlan        NullPlanType     `json:"trial_plan"`
+	TrialStartedAt   pgtype.Timestamp `json:"trial_started_at"`
+	TrialEndsAt      pgtype.Timestamp `json:"trial_ends_at"`
+	TrialRemindedAt  pgtype.Timestamp `json:"trial_reminded_at"`
+	StripeCustomerID pgtype.Text      `json:"stripe_customer_id"`
+}
+
+type OrganizationPlanHistory struct {
+	ID               uuid.UUID        `json:"id"`
+	OrganizationID   uuid.UUID        `json:"organization_id"`
+	Plan             PlanType         `json:"plan"`
+	StartedAt        pgtype.Timestamp `json:"started_at"`
+	EndedAt          pgtype.Timestamp `json:"ended_at"`
+	PrepaidUntil     pgtype.Timestamp `json:"prepaid_until"`
+	ProrationCycleID pgtype.UUID      `json:"proration_cycle_id"`
+	ChangedBy        pgtype.UUID      `json:"changed_by"`
+	CreatedAt        pgtype.Timestamp `json:"created_at"`
+	Trial            bool             `json:"trial"`
+	BilledByStripe   bool             `json:"billed_by_stripe"`
+}
+
+type OrganizationTaxProfile struct {
+	OrganizationID  uuid.UUID        `json:"organization_id"`
+	Country         string           `json:"country"`
+	VatID           pgtype.Text      `json:"vat_id"`
+	TaxExempt       bool             `json:"tax_exempt"`
+	ExemptionReason pgtype.Text      `json:"exemption_reason"`
+	CreatedAt       pgtype.Timestamp `json:"created_at"`
+	UpdatedAt       pgtype.Timestamp `json:"updated_at"`
+}
+
+type OutboundEvent struct {
+	ID             uuid.UUID           `json:"id"`
+	OrganizationID uuid.UUID           `json:"organization_id"`
+	EventType      string              `json:"event_type"`
+	Payload        []byte              `json:"payload"`
+	Status         OutboundEventStatus `json:"status"`
+	Attempts       int32               `json:"attempts"`
+	NextAttemptAt  pgtype.Timestamp    `json:"next_attempt_at"`
+	LastError      *string             `json:"last_error"`
+	CreatedAt      pgtype.Timestamp    `json:"created_at"`
+	DeliveredAt    pgtype.Timestamp    `json:"delivered_at"`
+}
+
+type PaymentMethod struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Provider       string           `json:"provider"`
+	CustomerID     pgtype.Text      `json:"customer_id"`
+	Reference      string           `json:"reference"`
+	Email          string           `json:"email"`
+	Brand          pgtype.Text      `json:"brand"`
+	Last4          pgtype.Text      `json:"last4"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
+}
+
+type PriceBook struct {
+	Version       string           `json:"version"`
+	EffectiveFrom pgtype.Timestamp `json:"effective_from"`
+	Currency      string           `json:"currency"`
+	Plans         []byte           `json:"plans"`
+	CreatedAt     pgtype.Timestamp `json:"created_at"`
+}
+
+type ScheduledPlanChange struct {
+	ID             uuid.UUID        `json:"id"`
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	FromPlan       PlanType         `json:"from_plan"`
+	ToPlan         PlanType         `json:"to_plan"`
+	EffectiveAt    pgtype.Timestamp `json:"effective_at"`
+	Status         PlanChangeStatus `json:"status"`
+	RequestedBy    pgtype.UUID      `json:"requested_by"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	AppliedAt      pgtype.Timestamp `json:"applied_at"`
+	CancelledAt    pgtype.Timestamp `json:"cancelled_at"`
+}
+
+type StripeSubscription struct {
+	OrganizationID       uuid.UUID        `json:"organization_id"`
+	SubscriptionID       string           `json:"subscription_id"`
+	CustomerID           string           `json:"customer_id"`
+	Plan                 PlanType         `json:"plan"`
+	Status               string           `json:"status"`
+	BaseItemID           pgtype.Text      `json:"base_item_id"`
+	MeteredItemID        pgtype.Text      `json:"metered_item_id"`
+	CurrentPeriodStart   pgtype.Timestamp `json:"current_period_start"`
+	CurrentPeriodEnd     pgtype.Timestamp `json:"current_period_end"`
+	CancelAtPeriodEnd    bool             `json:"cancel_at_period_end"`
+	BillingStartedAt     pgtype.Timestamp `json:"billing_started_at"`
+	UsageReportedThrough pgtype.Timestamp `json:"usage_reported_through"`
+	EndedAt              pgtype.Timestamp `json:"ended_at"`
+	LatestInvoiceID      pgtype.Text      `json:"latest_invoice_id"`
+	LatestInvoiceStatus  pgtype.Text      `json:"latest_invoice_status"`
+	CreatedAt            pgtype.Timestamp `json:"created_at"`
+	UpdatedAt            pgtype.Timestamp `json:"updated_at"`
+}
+
+type TaxRate struct {
+	Country       string           `json:"country"`
+	EffectiveFrom pgtype.Date      `json:"effective_from"`
+	Name          string           `json:"name"`
+	Rate          pgtype.Numeric   `json:"rate"`
+	ReverseCharge bool             `json:"reverse_charge"`
+	CreatedAt     pgtype.Timestamp `json:"created_at"`
 }
 
 type TeamInvitation struct {
@@ -237,6 +1230,58 @@ type TeamInvitation struct {
 	CreatedAt      pgtype.Timestamp `json:"created_at"`
 }
 
+type UsageAlert struct {
+	ID             uuid.UUID           `json:"id"`
+	OrganizationID uuid.UUID           `json:"organization_id"`
+	Metric         UsageAlertMetric    `json:"metric"`
+	Direction      UsageAlertDirection `json:"direction"`
+	WindowStart    pgtype.Timestamp    `json:"window_start"`
+	WindowEnd      pgtype.Timestamp    `json:"window_end"`
+	Observed       float64             `json:"observed"`
+	Expected       float64             `json:"expected"`
+	Score          float64             `json:"score"`
+	Status         UsageAlertStatus    `json:"status"`
+	CreatedAt      pgtype.Timestamp    `json:"created_at"`
+	AcknowledgedAt pgtype.Timestamp    `json:"acknowledged_at"`
+	AcknowledgedBy pgtype.UUID         `json:"acknowledged_by"`
+}
+
+type UsageBudget struct {
+	OrganizationID     uuid.UUID        `json:"organization_id"`
+	Metric             BudgetMetric     `json:"metric"`
+	Amount             pgtype.Numeric   `json:"amount"`
+	Thresholds         []int32          `json:"thresholds"`
+	DisableKeysAtLimit bool             `json:"disable_keys_at_limit"`
+	CreatedAt          pgtype.Timestamp `json:"created_at"`
+	UpdatedAt          pgtype.Timestamp `json:"updated_at"`
+}
+
+type UsageBudgetNotification struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	PeriodStart    pgtype.Timestamp `json:"period_start"`
+	Threshold      int32            `json:"threshold"`
+	Usage          pgtype.Numeric   `json:"usage"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+}
+
+type UsageExport struct {
+	ID             uuid.UUID         `json:"id"`
+	OrganizationID uuid.UUID         `json:"organization_id"`
+	RequestedBy    pgtype.UUID       `json:"requested_by"`
+	Format         UsageExportFormat `json:"format"`
+	StartTime      pgtype.Timestamp  `json:"start_time"`
+	EndTime        pgtype.Timestamp  `json:"end_time"`
+	Status         UsageExportStatus `json:"status"`
+	RowCount       int64             `json:"row_count"`
+	FileSize       int64             `json:"file_size"`
+	FilePath       *string           `json:"file_path"`
+	Error          *string           `json:"error"`
+	CreatedAt      pgtype.Timestamp  `json:"created_at"`
+	StartedAt      pgtype.Timestamp  `json:"started_at"`
+	CompletedAt    pgtype.Timestamp  `json:"completed_at"`
+	ExpiresAt      pgtype.Timestamp  `json:"expires_at"`
+}
+
 type UsageRecord struct {
 	ID             uuid.UUID        `json:"id"`
 	OrganizationID uuid.UUID        `json:"organization_id"`
@@ -245,6 +1290,48 @@ type UsageRecord struct {
 	Method         string           `json:"method"`
 	StatusCode     int32            `json:"status_code"`
 	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	DurationMs     int32            `json:"duration_ms"`
+	RequestBytes   int64            `json:"request_bytes"`
+	ResponseBytes  int64            `json:"response_bytes"`
+	ClientIp       *string          `json:"client_ip"`
+	UserAgent      *string          `json:"user_agent"`
+	RequestID      *string          `json:"request_id"`
+	MessageType    *string          `json:"message_type"`
+	Units          int32            `json:"units"`
+}
+
+type UsageRollupState struct {
+	ID         int32            `json:"id"`
+	RolledUpTo pgtype.Timestamp `json:"rolled_up_to"`
+	UpdatedAt  pgtype.Timestamp `json:"updated_at"`
+}
+
+type UsageRollupsDaily struct {
+	OrganizationID uuid.UUID   `json:"organization_id"`
+	ApiKeyID       uuid.UUID   `json:"api_key_id"`
+	Endpoint       string      `json:"endpoint"`
+	StatusClass    int16       `json:"status_class"`
+	Day            pgtype.Date `json:"day"`
+	RequestCount   int64       `json:"request_count"`
+	Units          int64       `json:"units"`
+	RequestBytes   int64       `json:"request_bytes"`
+	ResponseBytes  int64       `json:"response_bytes"`
+	DurationMs     int64       `json:"duration_ms"`
+	MessageType    string      `json:"message_type"`
+}
+
+type UsageRollupsHourly struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	ApiKeyID       uuid.UUID        `json:"api_key_id"`
+	Endpoint       string           `json:"endpoint"`
+	StatusClass    int16            `json:"status_class"`
+	Bucket         pgtype.Timestamp `json:"bucket"`
+	RequestCount   int64            `json:"request_count"`
+	Units          int64            `json:"units"`
+	RequestBytes   int64            `json:"request_bytes"`
+	ResponseBytes  int64            `json:"response_bytes"`
+	DurationMs     int64            `json:"duration_ms"`
+	MessageType    string           `json:"message_type"`
 }
 
 type User struct {
@@ -257,3 +1344,11 @@ type User struct {
 	EmailVerified   bool             `json:"email_verified"`
 	EmailVerifiedAt pgtype.Timestamp `json:"email_verified_at"`
 }
+
+type WebhookEndpoint struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	Url            string           `json:"url"`
+	Secret         string           `json:"secret"`
+	CreatedAt      pgtype.Timestamp `json:"created_at"`
+	UpdatedAt      pgtype.Timestamp `json:"updated_at"`
+}
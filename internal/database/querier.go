@@ -8,13 +8,33 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 type Querier interface {
 	AcceptTeamInvitation(ctx context.Context, id uuid.UUID) (TeamInvitation, error)
+	AcknowledgeUsageAlert(ctx context.Context, arg AcknowledgeUsageAlertParams) (UsageAlert, error)
 	ActivateAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error)
+	// Only a pending change is applied, so a change cancelled meanwhile is not
+	ApplyPlanChange(ctx context.Context, id uuid.UUID) (ScheduledPlanChange, error)
 	CancelInvitation(ctx context.Context, arg CancelInvitationParams) (TeamInvitation, error)
+	CancelPendingPlanChange(ctx context.Context, organizationID uuid.UUID) (int64, error)
+	// Counts a redemption if the coupon can still be redeemed. Concurrent
+	// redemptions queue on the row, so the limit is never exceeded.
+	ClaimCoupon(ctx context.Context, id uuid.UUID) (Coupon, error)
+	ClaimUsageExport(ctx context.Context, staleBefore pgtype.Timestamp) (UsageExport, error)
+	ClearCreditWalletLowBalanceNotice(ctx context.Context, organizationID uuid.UUID) error
+	// Only a pending top-up completes, so a repeated webhook finds no row
+	CompleteCreditTopUp(ctx context.Context, id uuid.UUID) (CreditTopUp, error)
+	CompleteUsageExport(ctx context.Context, arg CompleteUsageExportParams) error
+	// Usage queries read the whole hours of the range that are rolled up from the
+	// hourly rollups, and the partial hours at either end and the hours not yet
+	// rolled up from usage_records
 	CountOrganizationUsage(ctx context.Context, arg CountOrganizationUsageParams) (int64, error)
+	CountQueuedUsageExports(ctx context.Context, organizationID uuid.UUID) (int64, error)
+	// How many upgrades to plan in the period ending at period_end had their
+	// charge declined
+	CountVoidUpgradeInvoices(ctx context.Context, arg CountVoidUpgradeInvoicesParams) (int64, error)
 	// ============================================
 	// API KEY QUERIES
 	// ============================================
@@ -23,9 +43,29 @@ type Querier interface {
 	// ============================================
 	// BILLING CYCLE QUERIES
 	// ============================================
+	// Returns no row if the organization already has a cycle of this kind for
+	// the period
 	CreateBillingCycle(ctx context.Context, arg CreateBillingCycleParams) (BillingCycle, error)
+	// ============================================
+	// COUPON QUERIES
+	// ============================================
+	CreateCoupon(ctx context.Context, arg CreateCouponParams) (Coupon, error)
+	CreateCouponRedemption(ctx context.Context, arg CreateCouponRedemptionParams) (CouponRedemption, error)
+	CreateCreditLedgerEntry(ctx context.Context, arg CreateCreditLedgerEntryParams) error
+	CreateCreditTopUp(ctx context.Context, arg CreateCreditTopUpParams) (CreditTopUp, error)
+	CreateCreditTransaction(ctx context.Context, arg CreateCreditTransactionParams) (CreditTransaction, error)
+	CreateInvoiceLineItem(ctx context.Context, arg CreateInvoiceLineItemParams) (InvoiceLineItem, error)
 	CreateOrganization(ctx context.Context, arg CreateOrganizationParams) (Organization, error)
+	CreateOutboundEvent(ctx context.Context, arg CreateOutboundEventParams) (int64, error)
+	CreatePlanHistory(ctx context.Context, arg CreatePlanHistoryParams) (OrganizationPlanHistory, error)
+	CreateScheduledPlanChange(ctx context.Context, arg CreateScheduledPlanChangeParams) (ScheduledPlanChange, error)
 	CreateTeamInvitation(ctx context.Context, arg CreateTeamInvitationParams) (TeamInvitation, error)
+	CreateUsageAlert(ctx context.Context, arg CreateUsageAlertParams) (UsageAlert, error)
+	CreateUsageBudgetNotification(ctx context.Context, arg CreateUsageBudgetNotificationParams) (int64, error)
+	// ============================================
+	// USAGE EXPORT QUERIES
+	// ============================================
+	CreateUsageExport(ctx context.Context, arg CreateUsageExportParams) (UsageExport, error)
 	// ============================================
 	// USAGE RECORD QUERIES
 	// ============================================
@@ -33,47 +73,198 @@ type Querier interface {
 	// ============================================
 	// USER QUERIES
 	// ============================================
+	CreateUsageRecords(ctx context.Context, arg []CreateUsageRecordsParams) (int64, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	// ============================================
+	// OUTBOUND EVENT QUERIES
+	// ============================================
+	CreateWebhookEndpoint(ctx context.Context, arg CreateWebhookEndpointParams) (WebhookEndpoint, error)
 	DeactivateAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error)
 	DeclineTeamInvitation(ctx context.Context, id uuid.UUID) (TeamInvitation, error)
 	DeleteAPIKey(ctx context.Context, id uuid.UUID) error
+	DeleteCoupon(ctx context.Context, id uuid.UUID) (int64, error)
 	DeleteExpiredInvitations(ctx context.Context) error
 	DeleteExpiredTokens(ctx context.Context) error
 	DeleteOrganization(ctx context.Context, id uuid.UUID) error
+	DeleteUsageBudget(ctx context.Context, organizationID uuid.UUID) error
+	DeleteUsageBudgetNotifications(ctx context.Context, arg DeleteUsageBudgetNotificationsParams) error
 	DeleteUser(ctx context.Context, id uuid.UUID) error
+	DeleteWebhookEndpoint(ctx context.Context, organizationID uuid.UUID) error
+	EndCurrentPlanHistory(ctx context.Context, arg EndCurrentPlanHistoryParams) (int64, error)
+	// Only an active trial ends, so a trial is converted or expired once
+	EndTrial(ctx context.Context, arg EndTrialParams) (Organization, error)
+	EnsureCreditWallet(ctx context.Context, organizationID uuid.UUID) error
+	FailCreditTopUp(ctx context.Context, id uuid.UUID) (int64, error)
+	FailUsageExport(ctx context.Context, arg FailUsageExportParams) error
 	GetAPIKey(ctx context.Context, id uuid.UUID) (ApiKey, error)
 	GetAPIKeyByKey(ctx context.Context, key string) (GetAPIKeyByKeyRow, error)
+	GetActiveCouponRedemption(ctx context.Context, organizationID uuid.UUID) (CouponRedemption, error)
+	GetActiveCouponRedemptionForUpdate(ctx context.Context, organizationID uuid.UUID) (CouponRedemption, error)
 	GetAuthToken(ctx context.Context, token string) (AuthToken, error)
 	GetBillingCycle(ctx context.Context, id uuid.UUID) (BillingCycle, error)
+	GetCoupon(ctx context.Context, id uuid.UUID) (Coupon, error)
+	GetCouponByCode(ctx context.Context, code string) (Coupon, error)
+	GetCreditBalance(ctx context.Context, arg GetCreditBalanceParams) (pgtype.Numeric, error)
+	// The wallet is a liability: credits add to the balance, debits spend it
+	GetCreditBalances(ctx context.Context, organizationID uuid.UUID) ([]GetCreditBalancesRow, error)
+	GetCreditTopUp(ctx context.Context, id uuid.UUID) (CreditTopUp, error)
+	// ============================================
+	// CREDIT WALLET QUERIES
+	// ============================================
+	GetCreditWallet(ctx context.Context, organizationID uuid.UUID) (CreditWallet, error)
+	GetCreditWalletForUpdate(ctx context.Context, organizationID uuid.UUID) (CreditWallet, error)
 	GetCurrentBillingCycle(ctx context.Context, organizationID uuid.UUID) (BillingCycle, error)
+	GetCurrentPlanHistoryForUpdate(ctx context.Context, organizationID uuid.UUID) (OrganizationPlanHistory, error)
+	// Whole days come from the daily rollups, the rest as in CountOrganizationUsage
 	GetDailyUsageStats(ctx context.Context, arg GetDailyUsageStatsParams) ([]GetDailyUsageStatsRow, error)
+	// ============================================
+	// USAGE ALERT QUERIES
+	// ============================================
+	GetHourlyUsageTotals(ctx context.Context, arg GetHourlyUsageTotalsParams) ([]GetHourlyUsageTotalsRow, error)
+	// ============================================
+	// ANALYTICS QUERIES
+	// ============================================
+	GetLatencyPercentiles(ctx context.Context, arg GetLatencyPercentilesParams) (GetLatencyPercentilesRow, error)
+	GetLatencyPercentilesByAPIKey(ctx context.Context, arg GetLatencyPercentilesByAPIKeyParams) ([]GetLatencyPercentilesByAPIKeyRow, error)
+	GetLatencyPercentilesByEndpoint(ctx context.Context, arg GetLatencyPercentilesByEndpointParams) ([]GetLatencyPercentilesByEndpointRow, error)
 	GetOrganization(ctx context.Context, id uuid.UUID) (Organization, error)
 	GetOrganizationByEmail(ctx context.Context, email string) (Organization, error)
+	// ============================================
+	// TAX QUERIES
+	// ============================================
+	GetOrganizationTaxProfile(ctx context.Context, organizationID uuid.UUID) (OrganizationTaxProfile, error)
 	GetOverdueBillingCycles(ctx context.Context) ([]GetOverdueBillingCyclesRow, error)
+	GetPaymentMethod(ctx context.Context, organizationID uuid.UUID) (PaymentMethod, error)
 	GetPendingBillingCycles(ctx context.Context) ([]GetPendingBillingCyclesRow, error)
 	GetPendingInvitationByEmail(ctx context.Context, arg GetPendingInvitationByEmailParams) (TeamInvitation, error)
+	GetPendingPlanChange(ctx context.Context, organizationID uuid.UUID) (ScheduledPlanChange, error)
+	// The invoice of an upgrade to plan in the period ending at period_end that
+	// is not yet paid and applied. A retried upgrade charges it again rather
+	// than issuing another.
+	GetPendingUpgradeInvoice(ctx context.Context, arg GetPendingUpgradeInvoiceParams) (BillingCycle, error)
+	GetStatusCodesByEndpoint(ctx context.Context, arg GetStatusCodesByEndpointParams) ([]GetStatusCodesByEndpointRow, error)
+	GetStripeSubscription(ctx context.Context, organizationID uuid.UUID) (StripeSubscription, error)
+	GetStripeSubscriptionByID(ctx context.Context, subscriptionID string) (StripeSubscription, error)
 	GetTeamInvitationByToken(ctx context.Context, token string) (GetTeamInvitationByTokenRow, error)
+	GetUsageBudget(ctx context.Context, organizationID uuid.UUID) (UsageBudget, error)
 	GetUsageByAPIKey(ctx context.Context, arg GetUsageByAPIKeyParams) ([]GetUsageByAPIKeyRow, error)
 	GetUsageByEndpoint(ctx context.Context, arg GetUsageByEndpointParams) ([]GetUsageByEndpointRow, error)
+	GetUsageByMessageType(ctx context.Context, arg GetUsageByMessageTypeParams) ([]GetUsageByMessageTypeRow, error)
+	GetUsageExport(ctx context.Context, id uuid.UUID) (UsageExport, error)
 	GetUsageRecord(ctx context.Context, id uuid.UUID) (UsageRecord, error)
+	// The hour up to which usage has been rolled up, read without waiting on a
+	// rollup in progress
+	GetUsageRolledUpTo(ctx context.Context) (pgtype.Timestamp, error)
+	// ============================================
+	// USAGE ROLLUP QUERIES
+	// ============================================
+	GetUsageRollupWatermark(ctx context.Context) (pgtype.Timestamp, error)
+	GetUsageTimeseries(ctx context.Context, arg GetUsageTimeseriesParams) ([]GetUsageTimeseriesRow, error)
 	GetUser(ctx context.Context, id uuid.UUID) (User, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
 	GetUserWithOrganization(ctx context.Context, id uuid.UUID) (GetUserWithOrganizationRow, error)
+	GetWebhookEndpoint(ctx context.Context, organizationID uuid.UUID) (WebhookEndpoint, error)
+	ListCoupons(ctx context.Context) ([]Coupon, error)
+	ListCreditTransactions(ctx context.Context, arg ListCreditTransactionsParams) ([]CreditTransaction, error)
+	ListCreditWallets(ctx context.Context) ([]ListCreditWalletsRow, error)
+	ListDueOutboundEvents(ctx context.Context, limit int32) ([]ListDueOutboundEventsRow, error)
+	ListDuePlanChanges(ctx context.Context, effectiveAt pgtype.Timestamp) ([]ScheduledPlanChange, error)
+	ListEndedTrials(ctx context.Context, trialEndsAt pgtype.Timestamp) ([]Organization, error)
+	ListExpiredUsageExports(ctx context.Context) ([]UsageExport, error)
+	// ============================================
+	// FX RATE QUERIES
+	// ============================================
+	ListFXRates(ctx context.Context) ([]FxRate, error)
+	// Billable units an organization used in each hour between start and end
+	ListHourlyUnits(ctx context.Context, arg ListHourlyUnitsParams) ([]ListHourlyUnitsRow, error)
+	ListInvoiceLineItems(ctx context.Context, billingCycleIds []uuid.UUID) ([]InvoiceLineItem, error)
+	// Subscriptions Stripe is billing that have a metered item to report usage on
+	ListMeteredStripeSubscriptions(ctx context.Context) ([]StripeSubscription, error)
 	ListOrganizationAPIKeys(ctx context.Context, organizationID uuid.UUID) ([]ApiKey, error)
+	ListOrganizationAdminEmails(ctx context.Context, organizationID uuid.UUID) ([]string, error)
 	ListOrganizationBillingCycles(ctx context.Context, arg ListOrganizationBillingCyclesParams) ([]BillingCycle, error)
 	ListOrganizationInvitations(ctx context.Context, organizationID uuid.UUID) ([]ListOrganizationInvitationsRow, error)
 	ListOrganizationMembers(ctx context.Context, organizationID uuid.UUID) ([]ListOrganizationMembersRow, error)
+	ListOrganizationOutboundEvents(ctx context.Context, arg ListOrganizationOutboundEventsParams) ([]OutboundEvent, error)
+	// ============================================
+	// PLAN CHANGE QUERIES
+	// ============================================
+	// The plans an organization was on at some point between start and end
+	ListOrganizationPlanHistory(ctx context.Context, arg ListOrganizationPlanHistoryParams) ([]OrganizationPlanHistory, error)
 	ListOrganizationUsage(ctx context.Context, arg ListOrganizationUsageParams) ([]UsageRecord, error)
+	ListOrganizationUsageAlerts(ctx context.Context, arg ListOrganizationUsageAlertsParams) ([]UsageAlert, error)
+	ListOrganizationUsageExports(ctx context.Context, arg ListOrganizationUsageExportsParams) ([]UsageExport, error)
 	ListOrganizationUsers(ctx context.Context, organizationID uuid.UUID) ([]User, error)
 	ListOrganizations(ctx context.Context, arg ListOrganizationsParams) ([]Organization, error)
+	// ============================================
+	// PRICE BOOK QUERIES
+	// ============================================
+	ListPriceBooks(ctx context.Context) ([]PriceBook, error)
+	ListTaxRates(ctx context.Context) ([]TaxRate, error)
+	// Active trials ending by the given time whose owners have not been reminded
+	ListTrialsToRemind(ctx context.Context, trialEndsAt pgtype.Timestamp) ([]Organization, error)
+	ListUsageBudgetNotifications(ctx context.Context, arg ListUsageBudgetNotificationsParams) ([]UsageBudgetNotification, error)
+	ListUsageBudgets(ctx context.Context) ([]ListUsageBudgetsRow, error)
+	// ============================================
+	// USAGE RETENTION QUERIES
+	// ============================================
+	ListUsageRecordPartitions(ctx context.Context) ([]ListUsageRecordPartitionsRow, error)
+	ListUsageRecordsForExport(ctx context.Context, arg ListUsageRecordsForExportParams) ([]ListUsageRecordsForExportRow, error)
+	MarkCreditWalletLowBalanceNotified(ctx context.Context, organizationID uuid.UUID) (int64, error)
 	MarkTokenAsUsed(ctx context.Context, id uuid.UUID) (AuthToken, error)
+	MarkTrialReminded(ctx context.Context, id uuid.UUID) error
+	MarkUsageExportExpired(ctx context.Context, id uuid.UUID) error
+	NextInvoiceNumber(ctx context.Context, arg NextInvoiceNumberParams) (int64, error)
+	NextPaymentAttempt(ctx context.Context, billingCycleID uuid.UUID) (int32, error)
+	RecordOutboundEventAttempt(ctx context.Context, arg RecordOutboundEventAttemptParams) error
 	RemoveTeamMember(ctx context.Context, arg RemoveTeamMemberParams) error
+	ResumeAPIKeysSuspendedBefore(ctx context.Context, suspendedAt pgtype.Timestamp) (int64, error)
+	ResumeSuspendedAPIKeys(ctx context.Context, organizationID uuid.UUID) (int64, error)
+	RollupDailyUsage(ctx context.Context, arg RollupDailyUsageParams) (int64, error)
+	RollupHourlyUsage(ctx context.Context, arg RollupHourlyUsageParams) (int64, error)
+	// Only changes, and so only counts, wallets whose blocked state flips
+	SetCreditWalletBlocked(ctx context.Context, arg SetCreditWalletBlockedParams) (int64, error)
+	SetOrganizationTaxExemption(ctx context.Context, arg SetOrganizationTaxExemptionParams) (OrganizationTaxProfile, error)
+	SetStripeCustomerID(ctx context.Context, arg SetStripeCustomerIDParams) (Organization, error)
+	SetStripeUsageReported(ctx context.Context, arg SetStripeUsageReportedParams) error
+	SetUsageRollupWatermark(ctx context.Context, rolledUpTo pgtype.Timestamp) error
+	// ============================================
+	// TRIAL QUERIES
+	// ============================================
+	// An organization on free starts at most one trial
+	StartTrial(ctx context.Context, arg StartTrialParams) (Organization, error)
+	SuspendNonEssentialAPIKeys(ctx context.Context, organizationID uuid.UUID) (int64, error)
 	UpdateAPIKeyLastUsed(ctx context.Context, id uuid.UUID) error
 	UpdateBillingCycleStatus(ctx context.Context, arg UpdateBillingCycleStatusParams) (BillingCycle, error)
 	UpdateBillingCycleTotals(ctx context.Context, arg UpdateBillingCycleTotalsParams) (BillingCycle, error)
+	// The discount itself never changes once a coupon may have been redeemed
+	UpdateCoupon(ctx context.Context, arg UpdateCouponParams) (Coupon, error)
+	UpdateOrganizationBillingCurrency(ctx context.Context, arg UpdateOrganizationBillingCurrencyParams) (Organization, error)
 	UpdateOrganizationPlan(ctx context.Context, arg UpdateOrganizationPlanParams) (Organization, error)
+	UpdateOrganizationQuotaPolicy(ctx context.Context, arg UpdateOrganizationQuotaPolicyParams) (Organization, error)
+	UpdateStripeSubscriptionInvoice(ctx context.Context, arg UpdateStripeSubscriptionInvoiceParams) (int64, error)
 	UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) (User, error)
 	UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (User, error)
+	UpdateWebhookEndpoint(ctx context.Context, arg UpdateWebhookEndpointParams) (WebhookEndpoint, error)
+	// A new threshold is checked afresh, so its notice is reset
+	UpsertCreditWalletSettings(ctx context.Context, arg UpsertCreditWalletSettingsParams) (CreditWallet, error)
+	UpsertFXRate(ctx context.Context, arg UpsertFXRateParams) (FxRate, error)
+	UpsertOrganizationTaxProfile(ctx context.Context, arg UpsertOrganizationTaxProfileParams) (OrganizationTaxProfile, error)
+	// ============================================
+	// PAYMENT METHOD QUERIES
+	// ============================================
+	UpsertPaymentMethod(ctx context.Context, arg UpsertPaymentMethodParams) (PaymentMethod, error)
+	// ============================================
+	// STRIPE SUBSCRIPTION QUERIES
+	// ============================================
+	UpsertStripeSubscription(ctx context.Context, arg UpsertStripeSubscriptionParams) (StripeSubscription, error)
+	UpsertTaxRate(ctx context.Context, arg UpsertTaxRateParams) (TaxRate, error)
+	// ============================================
+	// USAGE BUDGET QUERIES
+	// ============================================
+	UpsertUsageBudget(ctx context.Context, arg UpsertUsageBudgetParams) (UsageBudget, error)
+	// Forever redemptions have no count and never end.
+	UseCouponRedemption(ctx context.Context, id uuid.UUID) (CouponRedemption, error)
 	VerifyUserEmail(ctx context.Context, id uuid.UUID) (User, error)
 }
 
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// TestUsageQueriesPartialHours counts usage over ranges that start and end
+// part way through rolled up hours and days. It runs against
+// TEST_DATABASE_URL, on temporary tables standing in for the usage tables.
+func TestUsageQueriesPartialHours(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+	dbURL := os.Getenv("TEST_DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Temporary tables come first on the search path
+	for _, sql := range []string{
+		`CREATE TEMP TABLE usage_rollup_state (id INT PRIMARY KEY, rolled_up_to TIMESTAMP NOT NULL)`,
+		`CREATE TEMP TABLE usage_records (organization_id UUID NOT NULL, status_code INT NOT NULL, created_at TIMESTAMP NOT NULL)`,
+		`CREATE TEMP TABLE usage_rollups_hourly (organization_id UUID NOT NULL, status_class SMALLINT NOT NULL, bucket TIMESTAMP NOT NULL, request_count BIGINT NOT NULL)`,
+		`CREATE TEMP TABLE usage_rollups_daily (organization_id UUID NOT NULL, status_class SMALLINT NOT NULL, day DATE NOT NULL, request_count BIGINT NOT NULL)`,
+	} {
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+
+	org := uuid.New()
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 11, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	// Rolled up to 12:00 on the 3rd. The record at 12:15 is only raw.
+	records := []time.Time{at(2, 23, 30), at(3, 9, 10), at(3, 9, 50), at(3, 10, 30), at(3, 11, 20), at(3, 12, 15)}
+	hourly := map[time.Time]int64{at(2, 23, 0): 1, at(3, 9, 0): 2, at(3, 10, 0): 1, at(3, 11, 0): 1}
+	daily := map[time.Time]int64{at(2, 0, 0): 1, at(3, 0, 0): 4}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO usage_rollup_state VALUES (1, $1)`, at(3, 12, 0)); err != nil {
+		t.Fatalf("failed to set watermark: %v", err)
+	}
+	for _, createdAt := range records {
+		if _, err := tx.Exec(ctx, `INSERT INTO usage_records VALUES ($1, 200, $2)`, org, createdAt); err != nil {
+			t.Fatalf("failed to insert usage record: %v", err)
+		}
+	}
+	for bucket, count := range hourly {
+		if _, err := tx.Exec(ctx, `INSERT INTO usage_rollups_hourly VALUES ($1, 2, $2, $3)`, org, bucket, count); err != nil {
+			t.Fatalf("failed to insert hourly rollup: %v", err)
+		}
+	}
+	for day, count := range daily {
+		if _, err := tx.Exec(ctx, `INSERT INTO usage_rollups_daily VALUES ($1, 2, $2, $3)`, org, day, count); err != nil {
+			t.Fatalf("failed to insert daily rollup: %v", err)
+		}
+	}
+
+	db := New(tx)
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       int64
+	}{
+		{"Partial first and last hours", at(3, 9, 30), at(3, 11, 0), 2},
+		{"Partial first hour into raw records", at(3, 9, 30), at(3, 12, 30), 4},
+		{"Within one rolled up hour", at(3, 9, 0), at(3, 9, 30), 1},
+		{"Whole hours across days", at(2, 23, 0), at(3, 10, 0), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, err := db.CountOrganizationUsage(ctx, CountOrganizationUsageParams{
+				OrganizationID: org,
+				StartTime:      pgtype.Timestamp{Time: tt.start, Valid: true},
+				EndTime:        pgtype.Timestamp{Time: tt.end, Valid: true},
+			})
+			if err != nil {
+				t.Fatalf("CountOrganizationUsage() error = %v", err)
+			}
+			if count != tt.want {
+				t.Errorf("CountOrganizationUsage() = %d, want %d", count, tt.want)
+			}
+		})
+	}
+
+	// The 2nd is partial and the 3rd only rolled up to 12:00, so neither
+	// comes from the daily rollups
+	days, err := db.GetDailyUsageStats(ctx, GetDailyUsageStatsParams{
+		OrganizationID: org,
+		StartTime:      pgtype.Timestamp{Time: at(2, 23, 45), Valid: true},
+		EndTime:        pgtype.Timestamp{Time: at(3, 23, 59), Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("GetDailyUsageStats() error = %v", err)
+	}
+	if len(days) != 1 || days[0].RequestCount != 5 {
+		t.Errorf("GetDailyUsageStats() = %+v, want 5 requests on the 3rd only", days)
+	}
+}
@@ -2,11 +2,19 @@ package email
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"html/template"
+	"mime"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/shopspring/decimal"
 )
 
 type EmailService struct {
@@ -24,6 +32,14 @@ type EmailData struct {
 	Subject     string
 	TemplateKey string
 	Data        interface{}
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with an email
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     []byte
 }
 
 func NewEmailService() (*EmailService, error) {
@@ -44,6 +60,14 @@ func NewEmailService() (*EmailService, error) {
 	return service, nil
 }
 
+// templateFuncs are available to every template. money writes an amount in
+// its currency, e.g. {{money .Amount .Currency}}.
+var templateFuncs = template.FuncMap{
+	"money": func(amount float64, code string) string {
+		return currency.Format(decimal.NewFromFloat(amount), code)
+	},
+}
+
 func (s *EmailService) loadTemplates() error {
 	templateDir := "internal/email/templates"
 
@@ -55,13 +79,19 @@ func (s *EmailService) loadTemplates() error {
 		"payment_success":    "payment_success.html",
 		"team_invitation":    "team_invitation.html",
 		"overdue_payment":    "overdue_payment.html",
+		"quota_exceeded":     "quota_exceeded.html",
+		"usage_anomaly":      "usage_anomaly.html",
+		"budget_threshold":   "budget_threshold.html",
+		"low_credit_balance": "low_credit_balance.html",
+		"trial_reminder":     "trial_reminder.html",
+		"trial_ended":        "trial_ended.html",
 	}
 
 	for key, filename := range templates {
 		path := filepath.Join(templateDir, filename)
-		tmpl, err := template.ParseFiles(path)
+		tmpl, err := template.New(filename).Funcs(templateFuncs).ParseFiles(path)
 		if err != nil {
-			tmpl = template.Must(template.New(key).Parse(defaultTemplate))
+			tmpl = template.Must(template.New(key).Funcs(templateFuncs).Parse(defaultTemplate))
 		}
 		s.templates[key] = tmpl
 	}
@@ -88,6 +118,14 @@ func (s *EmailService) SendEmail(data EmailData) error {
 		"\r\n"+
 		"%s", s.fromName, s.fromEmail, data.To, data.Subject, body.String())
 
+	if len(data.Attachments) > 0 {
+		mixed, err := mixedMessage(s.fromName, s.fromEmail, data, body.Bytes())
+		if err != nil {
+			return fmt.Errorf("failed to build email: %w", err)
+		}
+		message = mixed
+	}
+
 	auth := smtp.PlainAuth("", s.smtpUsername, s.smtpPassword, s.smtpHost)
 	addr := fmt.Sprintf("%s:%s", s.smtpHost, s.smtpPort)
 
@@ -99,6 +137,61 @@ func (s *EmailService) SendEmail(data EmailData) error {
 	return nil
 }
 
+// mixedMessage builds a multipart/mixed message of the HTML body followed by
+// the attachments
+func mixedMessage(fromName, fromEmail string, data EmailData, html []byte) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/html; charset=UTF-8"},
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(html); err != nil {
+		return "", err
+	}
+
+	for _, attachment := range data.Attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(attachment.ContentType, map[string]string{"name": attachment.Filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return "", err
+		}
+		// Base64 lines are limited to 76 characters
+		encoded := base64.StdEncoding.EncodeToString(attachment.Content)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("From: %s <%s>\r\n"+
+		"To: %s\r\n"+
+		"Subject: %s\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: multipart/mixed; boundary=%s\r\n"+
+		"\r\n"+
+		"%s", fromName, fromEmail, data.To, data.Subject, writer.Boundary(), body.String()), nil
+}
+
+// pdfAttachment attaches a PDF, or nothing if there is none to attach
+func pdfAttachment(filename string, pdf []byte) []Attachment {
+	if len(pdf) == 0 {
+		return nil
+	}
+	return []Attachment{{Filename: filename, ContentType: "application/pdf", Content: pdf}}
+}
+
 type WelcomeEmailData struct {
 	Name             string
 	OrganizationName string
@@ -167,32 +260,40 @@ type BillingInvoiceData struct {
 	PeriodEnd        string
 	TotalRequests    int
 	TotalAmount      float64
-	InvoiceURL       string
-	DueDate          string
+	// Currency is the ISO code amounts are in, USD when empty
+	Currency   string
+	InvoiceURL string
+	DueDate    string
 }
 
-func (s *EmailService) SendBillingInvoice(to string, data BillingInvoiceData) error {
+// SendBillingInvoice emails a new invoice with its PDF attached
+func (s *EmailService) SendBillingInvoice(to string, data BillingInvoiceData, invoicePDF []byte) error {
 	return s.SendEmail(EmailData{
 		To:          to,
 		Subject:     fmt.Sprintf("Invoice #%s - Your monthly usage", data.InvoiceNumber),
 		TemplateKey: "billing_invoice",
 		Data:        data,
+		Attachments: pdfAttachment(fmt.Sprintf("invoice-%s.pdf", data.InvoiceNumber), invoicePDF),
 	})
 }
 
 type PaymentSuccessData struct {
 	OrganizationName string
 	Amount           float64
+	Currency         string
 	InvoiceNumber    string
 	ReceiptURL       string
 }
 
-func (s *EmailService) SendPaymentSuccess(to string, data PaymentSuccessData) error {
+// SendPaymentSuccess emails a payment confirmation with the receipt PDF
+// attached
+func (s *EmailService) SendPaymentSuccess(to string, data PaymentSuccessData, receiptPDF []byte) error {
 	return s.SendEmail(EmailData{
 		To:          to,
 		Subject:     "Payment received - Thank you!",
 		TemplateKey: "payment_success",
 		Data:        data,
+		Attachments: pdfAttachment(fmt.Sprintf("receipt-%s.pdf", data.InvoiceNumber), receiptPDF),
 	})
 }
 
@@ -217,6 +318,7 @@ type OverduePaymentData struct {
 	OrganizationName string
 	InvoiceNumber    string
 	Amount           float64
+	Currency         string
 	DaysPastDue      int
 	PaymentURL       string
 }
@@ -230,6 +332,125 @@ func (s *EmailService) SendOverduePayment(to string, data OverduePaymentData) er
 	})
 }
 
+type QuotaExceededData struct {
+	OrganizationName string
+	Plan             string
+	Limit            int64
+	Used             int64
+	ResetsAt         string
+	UpgradeURL       string
+}
+
+func (s *EmailService) SendQuotaExceeded(to string, data QuotaExceededData) error {
+	return s.SendEmail(EmailData{
+		To:          to,
+		Subject:     "You've reached your monthly request quota",
+		TemplateKey: "quota_exceeded",
+		Data:        data,
+	})
+}
+
+type UsageAnomalyData struct {
+	OrganizationName string
+	Metric           string
+	Direction        string
+	Observed         string
+	Expected         string
+	WindowStart      string
+	WindowEnd        string
+	AlertsURL        string
+}
+
+func (s *EmailService) SendUsageAnomaly(to string, data UsageAnomalyData) error {
+	return s.SendEmail(EmailData{
+		To:          to,
+		Subject:     fmt.Sprintf("Usage alert: %s %s for %s", strings.ToLower(data.Metric), data.Direction, data.OrganizationName),
+		TemplateKey: "usage_anomaly",
+		Data:        data,
+	})
+}
+
+type BudgetThresholdData struct {
+	OrganizationName string
+	Threshold        int
+	Used             string
+	Budget           string
+	PeriodEnd        string
+	KeysSuspended    int64
+	BillingURL       string
+}
+
+func (s *EmailService) SendBudgetThreshold(to string, data BudgetThresholdData) error {
+	return s.SendEmail(EmailData{
+		To:          to,
+		Subject:     fmt.Sprintf("You've used %d%% of your monthly budget", data.Threshold),
+		TemplateKey: "budget_threshold",
+		Data:        data,
+	})
+}
+
+type LowCreditBalanceData struct {
+	OrganizationName string
+	Balance          string
+	Available        string
+	Threshold        string
+	Blocked          bool
+	TopUpURL         string
+}
+
+func (s *EmailService) SendLowCreditBalance(to string, data LowCreditBalanceData) error {
+	subject := "Your prepaid credit is running low"
+	if data.Blocked {
+		subject = "Your prepaid credit has run out"
+	}
+	return s.SendEmail(EmailData{
+		To:          to,
+		Subject:     subject,
+		TemplateKey: "low_credit_balance",
+		Data:        data,
+	})
+}
+
+type TrialReminderData struct {
+	OrganizationName string
+	Plan             string
+	EndsAt           string
+	DaysLeft         int
+	HasPaymentMethod bool
+	BillingURL       string
+}
+
+func (s *EmailService) SendTrialReminder(to string, data TrialReminderData) error {
+	return s.SendEmail(EmailData{
+		To:          to,
+		Subject:     fmt.Sprintf("Your %s trial ends in %d days", data.Plan, data.DaysLeft),
+		TemplateKey: "trial_reminder",
+		Data:        data,
+	})
+}
+
+type TrialEndedData struct {
+	OrganizationName string
+	Plan             string
+	Converted        bool
+	// Amount is what was charged for the rest of the period on conversion
+	Amount     string
+	BillingURL string
+}
+
+func (s *EmailService) SendTrialEnded(to string, data TrialEndedData) error {
+	subject := fmt.Sprintf("Your %s trial has ended", data.Plan)
+	if data.Converted {
+		subject = fmt.Sprintf("Welcome to the %s plan", data.Plan)
+	}
+	return s.SendEmail(EmailData{
+		To:          to,
+		Subject:     subject,
+		TemplateKey: "trial_ended",
+		Data:        data,
+	})
+}
+
 const defaultTemplate = `
 <!DOCTYPE html>
 <html>
@@ -1,7 +1,14 @@
 package email
 
 import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -35,3 +42,50 @@ func TestEmailServiceCreation(t *testing.T) {
 		t.Errorf("Expected smtpHost 'smtp.gmail.com', got '%s'", service.smtpHost)
 	}
 }
+
+func TestMixedMessageAttachments(t *testing.T) {
+	pdf := bytes.Repeat([]byte("%PDF-1.4 receipt "), 20)
+	message, err := mixedMessage("MTS", "billing@mts.com", EmailData{
+		To:          "ap@acme.example",
+		Subject:     "Payment received",
+		Attachments: pdfAttachment("receipt-INV-2026-000123.pdf", pdf),
+	}, []byte("<p>Thanks</p>"))
+	if err != nil {
+		t.Fatalf("mixedMessage() error = %v", err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(message))
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q", msg.Header.Get("Content-Type"))
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	html, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(html); string(body) != "<p>Thanks</p>" {
+		t.Errorf("html part = %q", body)
+	}
+
+	attachment, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName() != "receipt-INV-2026-000123.pdf" {
+		t.Errorf("filename = %q", attachment.FileName())
+	}
+	encoded, _ := io.ReadAll(attachment)
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || !bytes.Equal(decoded, pdf) {
+		t.Errorf("attachment does not decode to the PDF: %v", err)
+	}
+
+	if pdfAttachment("empty.pdf", nil) != nil {
+		t.Error("pdfAttachment() without a PDF should attach nothing")
+	}
+}
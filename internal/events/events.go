@@ -0,0 +1,249 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+)
+
+// Event types sent to organizations' webhook endpoints
+const (
+	TypeBudgetThresholdCrossed = "budget.threshold_crossed"
+	TypeCreditLowBalance       = "credit.low_balance"
+	TypeCreditExhausted        = "credit.exhausted"
+)
+
+const (
+	// MaxAttempts is how many deliveries are tried before an event is marked failed
+	MaxAttempts = 10
+	// maxRetryDelay caps the exponential backoff between attempts
+	maxRetryDelay = 6 * time.Hour
+)
+
+// Publish queues an event for the organization's webhook endpoint. Call it
+// with a transaction-bound db so the event is only sent if the change it
+// describes commits. Returns false when the organization has no endpoint.
+func Publish(ctx context.Context, db *database.Queries, orgID uuid.UUID, eventType string, data interface{}) (bool, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode event payload: %w", err)
+	}
+
+	queued, err := db.CreateOutboundEvent(ctx, database.CreateOutboundEventParams{
+		EventType:      eventType,
+		Payload:        payload,
+		OrganizationID: orgID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to queue event: %w", err)
+	}
+	return queued > 0, nil
+}
+
+// Envelope is the JSON body POSTed to webhook endpoints
+type Envelope struct {
+	ID             uuid.UUID       `json:"id"`
+	Type           string          `json:"type"`
+	OrganizationID uuid.UUID       `json:"organization_id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// GenerateSecret returns a new signing secret for a webhook endpoint
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the X-Webhook-Signature header value for body: the timestamp
+// and an HMAC-SHA256 of "<timestamp>.<body>" keyed with the endpoint secret.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// VerifySignature checks a signature header produced by Sign and rejects
+// timestamps older than tolerance. Receivers can use it as a reference.
+func VerifySignature(secret, header string, body []byte, tolerance time.Duration) bool {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return false
+	}
+	if time.Since(time.Unix(timestamp, 0)) > tolerance {
+		return false
+	}
+
+	expected := Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(fmt.Sprintf("t=%d,v1=%s", timestamp, signature)))
+}
+
+// RetryDelay is the wait before the next delivery after attempts failures
+func RetryDelay(attempts int) time.Duration {
+	if attempts > 10 {
+		return maxRetryDelay
+	}
+	return min(time.Duration(1<<attempts)*time.Minute, maxRetryDelay)
+}
+
+// ErrBlockedAddress is returned for endpoints on loopback, link-local or
+// private addresses, which would let an organization reach our own network
+var ErrBlockedAddress = errors.New("endpoint address is not publicly routable")
+
+// sharedAddressSpace is the carrier-grade NAT range, private in all but name
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// blockedAddress reports whether ip must not be sent events
+func blockedAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return !ip.IsValid() || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || sharedAddressSpace.Contains(ip)
+}
+
+// ValidateURL checks an organization's webhook endpoint URL. It must be an
+// absolute https URL, or http in development, and must not name a blocked
+// address. Hostnames are checked again at every delivery, once resolved.
+func ValidateURL(raw string, development bool) error {
+	if len(raw) > 2048 {
+		return errors.New("URL is longer than 2048 characters")
+	}
+	parsed, err := parseURL(raw, development)
+	if err != nil {
+		return err
+	}
+
+	host := parsed.Hostname()
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return ErrBlockedAddress
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && blockedAddress(ip) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
+
+func parseURL(raw string, development bool) (*url.URL, error) {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return nil, errors.New("URL must be absolute")
+	}
+	if parsed.Scheme != "https" && !(development && parsed.Scheme == "http") {
+		return nil, errors.New("URL must use https")
+	}
+	return parsed, nil
+}
+
+// Deliverer POSTs signed events to webhook endpoints
+type Deliverer struct {
+	client      *http.Client
+	development bool
+}
+
+// NewDeliverer returns a Deliverer that only connects to public addresses,
+// checked after DNS resolution so a hostname cannot be pointed at our network,
+// and does not follow redirects. Outside development endpoints must use https.
+func NewDeliverer(timeout time.Duration, development bool) *Deliverer {
+	return newDeliverer(timeout, development, blockedAddress)
+}
+
+func newDeliverer(timeout time.Duration, development bool, blocked func(netip.Addr) bool) *Deliverer {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || blocked(addrPort.Addr()) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		// No proxy: it would connect on the endpoint's behalf, unchecked
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &Deliverer{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		development: development,
+	}
+}
+
+// Deliver sends one event. Any response outside 2xx counts as a failure.
+func (d *Deliverer) Deliver(ctx context.Context, endpoint, secret string, envelope Envelope) error {
+	// Endpoints saved before https was required are refused here; the
+	// address is checked when connecting
+	if _, err := parseURL(endpoint, d.development); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", envelope.ID.String())
+	req.Header.Set("X-Webhook-Event", envelope.Type)
+	req.Header.Set("X-Webhook-Signature", Sign(secret, time.Now().Unix(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"budget.threshold_crossed"}`)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		secret    string
+		header    string
+		body      []byte
+		tolerance time.Duration
+		want      bool
+	}{
+		{
+			name:      "Valid signature",
+			secret:    "whsec_test",
+			header:    Sign("whsec_test", now, body),
+			body:      body,
+			tolerance: 5 * time.Minute,
+			want:      true,
+		},
+		{
+			name:      "Wrong secret",
+			secret:    "whsec_other",
+			header:    Sign("whsec_test", now, body),
+			body:      body,
+			tolerance: 5 * time.Minute,
+			want:      false,
+		},
+		{
+			name:      "Tampered body",
+			secret:    "whsec_test",
+			header:    Sign("whsec_test", now, body),
+			body:      []byte(`{"type":"something.else"}`),
+			tolerance: 5 * time.Minute,
+			want:      false,
+		},
+		{
+			name:      "Expired timestamp",
+			secret:    "whsec_test",
+			header:    Sign("whsec_test", now-3600, body),
+			body:      body,
+			tolerance: 5 * time.Minute,
+			want:      false,
+		},
+		{
+			name:      "Malformed header",
+			secret:    "whsec_test",
+			header:    "garbage",
+			body:      body,
+			tolerance: 5 * time.Minute,
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySignature(tt.secret, tt.header, tt.body, tt.tolerance); got != tt.want {
+				t.Errorf("VerifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: time.Minute},
+		{attempts: 3, want: 8 * time.Minute},
+		{attempts: 9, want: 6 * time.Hour},
+		{attempts: 50, want: 6 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := RetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("RetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	const secret = "whsec_test"
+
+	var received Envelope
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !VerifySignature(secret, r.Header.Get("X-Webhook-Signature"), body, time.Minute) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	envelope := Envelope{
+		ID:             uuid.New(),
+		Type:           TypeBudgetThresholdCrossed,
+		OrganizationID: uuid.New(),
+		CreatedAt:      time.Now().UTC(),
+		Data:           json.RawMessage(`{"threshold":80}`),
+	}
+
+	// The test endpoint listens on loopback, so nothing is blocked here
+	deliverer := newDeliverer(5*time.Second, true, func(netip.Addr) bool { return false })
+	if err := deliverer.Deliver(context.Background(), server.URL, secret, envelope); err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+	if received.ID != envelope.ID || received.Type != envelope.Type {
+		t.Errorf("received %+v, want %+v", received, envelope)
+	}
+
+	if err := deliverer.Deliver(context.Background(), server.URL, "whsec_wrong", envelope); err == nil {
+		t.Error("Deliver() expected an error when the endpoint rejects the signature")
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		url         string
+		development bool
+		valid       bool
+	}{
+		{"https://hooks.example.com/events", false, true},
+		{"http://hooks.example.com/events", false, false},
+		{"http://hooks.example.com/events", true, true},
+		{"hooks.example.com/events", true, false},
+		{"https://localhost:8080/hook", true, false},
+		{"https://api.localhost/hook", true, false},
+		{"https://127.0.0.1/hook", true, false},
+		{"https://169.254.169.254/latest/meta-data", true, false},
+		{"https://10.0.0.5/hook", true, false},
+		{"https://192.168.1.10/hook", true, false},
+		{"https://100.64.0.1/hook", true, false},
+		{"https://[::1]/hook", true, false},
+		{"https://[::ffff:127.0.0.1]/hook", true, false},
+		{"https://[fe80::1]/hook", true, false},
+		{"https://93.184.216.34/hook", false, true},
+	}
+	for _, tt := range tests {
+		if err := ValidateURL(tt.url, tt.development); (err == nil) != tt.valid {
+			t.Errorf("ValidateURL(%q, development=%v) error = %v, want valid %v", tt.url, tt.development, err, tt.valid)
+		}
+	}
+}
+
+func TestDeliverBlocksPrivateAddresses(t *testing.T) {
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	// The endpoint is reached through a hostname resolving to loopback
+	url := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	deliverer := NewDeliverer(5*time.Second, true)
+	err := deliverer.Deliver(context.Background(), url, "whsec_test", Envelope{ID: uuid.New()})
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Deliver() to loopback error = %v, want ErrBlockedAddress", err)
+	}
+	if called {
+		t.Error("the loopback endpoint was called")
+	}
+
+	production := NewDeliverer(5*time.Second, false)
+	if err := production.Deliver(context.Background(), "http://hooks.example.com/events", "whsec_test", Envelope{ID: uuid.New()}); err == nil {
+		t.Error("Deliver() over http outside development succeeded")
+	}
+}
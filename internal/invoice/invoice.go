@@ -0,0 +1,247 @@
+// Package invoice renders billing cycles as PDF invoices and receipts
+package invoice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// Kind is which document is rendered for a billing cycle
+type Kind string
+
+const (
+	KindInvoice Kind = "invoice"
+	KindReceipt Kind = "receipt"
+)
+
+// PaymentTerms is how long after its period ends an invoice is due
+const PaymentTerms = 7 * 24 * time.Hour
+
+// DefaultIssuer prefixes invoice numbers when the seller has no issuer of
+// its own
+const DefaultIssuer = "INV"
+
+// ErrNotPaid is returned when a receipt is asked for an unpaid invoice
+var ErrNotPaid = errors.New("invoice has not been paid")
+
+// Seller is who the documents are issued by. Issuer prefixes the seller's
+// invoice numbers, which run in a sequence of their own. Country is where
+// the seller is established for tax purposes.
+type Seller struct {
+	Name    string
+	Email   string
+	Website string
+	Issuer  string
+	Country string
+	TaxID   string
+}
+
+// NumberIssuer is the issuer the seller's invoices are numbered under
+func (s Seller) NumberIssuer() string {
+	if s.Issuer == "" {
+		return DefaultIssuer
+	}
+	return s.Issuer
+}
+
+// Customer is the organization being billed. Country and VATID come from
+// its tax profile, if it has one.
+type Customer struct {
+	Name    string
+	Email   string
+	Country string
+	VATID   string
+}
+
+// Line is one itemized charge
+type Line struct {
+	Type        database.InvoiceLineItemType
+	Description string
+	Quantity    int64
+	UnitPrice   decimal.Decimal
+	Amount      decimal.Decimal
+}
+
+// Document is everything printed on an invoice or receipt
+type Document struct {
+	BillingCycleID uuid.UUID
+	OrganizationID uuid.UUID
+	Number         string
+	Seller         Seller
+	Customer       Customer
+	IssuedAt       time.Time
+	PeriodStart    time.Time
+	PeriodEnd      time.Time
+	DueAt          time.Time
+	Status         database.BillingStatus
+	// PaidAt is zero for invoices paid before payment times were recorded
+	PaidAt   time.Time
+	Currency string
+	Lines    []Line
+	Total    decimal.Decimal
+}
+
+// Subtotal is the sum of every line except tax and prepaid credit
+func (d Document) Subtotal() decimal.Decimal {
+	subtotal := decimal.Zero
+	for _, line := range d.Lines {
+		if line.Type != database.InvoiceLineItemTypeTax && line.Type != database.InvoiceLineItemTypeCredit {
+			subtotal = subtotal.Add(line.Amount)
+		}
+	}
+	return subtotal
+}
+
+// Credit is the prepaid credit spent on the invoice, as a negative amount
+func (d Document) Credit() decimal.Decimal {
+	credit := decimal.Zero
+	for _, line := range d.Lines {
+		if line.Type == database.InvoiceLineItemTypeCredit {
+			credit = credit.Add(line.Amount)
+		}
+	}
+	return credit
+}
+
+// Tax is the sum of the tax lines
+func (d Document) Tax() decimal.Decimal {
+	tax := decimal.Zero
+	for _, line := range d.Lines {
+		if line.Type == database.InvoiceLineItemTypeTax {
+			tax = tax.Add(line.Amount)
+		}
+	}
+	return tax
+}
+
+// AmountDue is what is left to pay
+func (d Document) AmountDue() decimal.Decimal {
+	if d.Status == database.BillingStatusPaid || d.Status == database.BillingStatusVoid {
+		return decimal.Zero
+	}
+	return d.Total
+}
+
+// Filename names the PDF of the document
+func (d Document) Filename(kind Kind) string {
+	return fmt.Sprintf("%s-%s.pdf", kind, d.Number)
+}
+
+// issuerPattern keeps invoice numbers usable as payment provider references
+var issuerPattern = regexp.MustCompile(`^[A-Z0-9]{1,16}$`)
+
+// ValidateIssuer checks an issuer is 1 to 16 capital letters or digits
+func ValidateIssuer(issuer string) error {
+	if !issuerPattern.MatchString(issuer) {
+		return fmt.Errorf("invoice issuer %q must be 1 to 16 capital letters or digits", issuer)
+	}
+	return nil
+}
+
+// FormatNumber writes the seq-th invoice of an issuer's year, such as
+// INV-2026-000123
+func FormatNumber(issuer string, year int, seq int64) string {
+	return fmt.Sprintf("%s-%d-%06d", issuer, year, seq)
+}
+
+// Source is the queries a document is loaded with. *database.Queries
+// satisfies it.
+type Source interface {
+	GetBillingCycle(ctx context.Context, id uuid.UUID) (database.BillingCycle, error)
+	GetOrganization(ctx context.Context, id uuid.UUID) (database.Organization, error)
+	ListInvoiceLineItems(ctx context.Context, billingCycleIds []uuid.UUID) ([]database.InvoiceLineItem, error)
+	GetOrganizationTaxProfile(ctx context.Context, organizationID uuid.UUID) (database.OrganizationTaxProfile, error)
+}
+
+// Load collects a billing cycle, its organization, the organization's tax
+// profile and the line items into a document
+func Load(ctx context.Context, src Source, cycleID uuid.UUID, seller Seller) (Document, error) {
+	cycle, err := src.GetBillingCycle(ctx, cycleID)
+	if err != nil {
+		return Document{}, err
+	}
+
+	org, err := src.GetOrganization(ctx, cycle.OrganizationID)
+	if err != nil {
+		return Document{}, fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	items, err := src.ListInvoiceLineItems(ctx, []uuid.UUID{cycle.ID})
+	if err != nil {
+		return Document{}, fmt.Errorf("failed to list line items: %w", err)
+	}
+
+	profile, err := src.GetOrganizationTaxProfile(ctx, org.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return Document{}, fmt.Errorf("failed to get tax profile: %w", err)
+	}
+
+	return newDocument(cycle, org, profile, items, seller), nil
+}
+
+func newDocument(cycle database.BillingCycle, org database.Organization, profile database.OrganizationTaxProfile, items []database.InvoiceLineItem, seller Seller) Document {
+	// Invoices are issued in the currency recorded on the cycle. The quote's
+	// currency is only a fallback for cycles that have none.
+	invoiceCurrency := cycle.Currency
+	if invoiceCurrency == "" {
+		var quote struct {
+			Currency string `json:"currency"`
+		}
+		if len(cycle.Quote) > 0 {
+			json.Unmarshal(cycle.Quote, &quote)
+		}
+		invoiceCurrency = quote.Currency
+	}
+	if invoiceCurrency == "" {
+		invoiceCurrency = currency.Default
+	}
+
+	lines := make([]Line, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, Line{
+			Type:        item.Type,
+			Description: item.Description,
+			Quantity:    item.Quantity,
+			UnitPrice:   numericToDecimal(item.UnitPrice),
+			Amount:      numericToDecimal(item.Amount),
+		})
+	}
+
+	doc := Document{
+		BillingCycleID: cycle.ID,
+		OrganizationID: org.ID,
+		Number:         cycle.InvoiceNumber,
+		Seller:         seller,
+		Customer:       Customer{Name: org.Name, Email: org.Email, Country: profile.Country, VATID: profile.VatID.String},
+		IssuedAt:       cycle.CreatedAt.Time,
+		PeriodStart:    cycle.PeriodStart.Time,
+		PeriodEnd:      cycle.PeriodEnd.Time,
+		DueAt:          cycle.PeriodEnd.Time.Add(PaymentTerms),
+		Status:         cycle.Status,
+		Currency:       invoiceCurrency,
+		Lines:          lines,
+		Total:          numericToDecimal(cycle.TotalAmount),
+	}
+	if cycle.PaidAt.Valid {
+		doc.PaidAt = cycle.PaidAt.Time
+	}
+	return doc
+}
+
+func numericToDecimal(n pgtype.Numeric) decimal.Decimal {
+	if !n.Valid || n.Int == nil {
+		return decimal.Zero
+	}
+	return decimal.NewFromBigInt(n.Int, n.Exp)
+}
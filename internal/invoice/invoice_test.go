@@ -0,0 +1,339 @@
+package invoice
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+func testDocument(lines int) Document {
+	doc := Document{
+		BillingCycleID: uuid.New(),
+		OrganizationID: uuid.New(),
+		Number:         "INV-2026-000123",
+		Seller:         Seller{Name: "MTS", Email: "billing@mts.com"},
+		Customer:       Customer{Name: "Acme (Europe) Ltd", Email: "ap@acme.example"},
+		IssuedAt:       time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		PeriodStart:    time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:      time.Date(2026, 9, 30, 23, 59, 59, 0, time.UTC),
+		DueAt:          time.Date(2026, 10, 7, 23, 59, 59, 0, time.UTC),
+		Status:         database.BillingStatusPending,
+		Currency:       "USD",
+		Total:          decimal.Zero,
+	}
+	for i := 0; i < lines; i++ {
+		amount := decimal.RequireFromString("12.50")
+		doc.Lines = append(doc.Lines, Line{
+			Type:        database.InvoiceLineItemTypeUsage,
+			Description: fmt.Sprintf("Usage line %d", i+1),
+			Quantity:    2500,
+			UnitPrice:   decimal.RequireFromString("0.005"),
+			Amount:      amount,
+		})
+		doc.Total = doc.Total.Add(amount)
+	}
+	return doc
+}
+
+// pageContents checks the cross-reference table points at every object and
+// returns the inflated content stream of every page
+func pageContents(t *testing.T, pdf []byte) []string {
+	t.Helper()
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("output is not a PDF file")
+	}
+
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(pdf[xref:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if !bytes.HasPrefix(pdf[offset:], []byte(fmt.Sprintf("%d 0 obj", i+1))) {
+			t.Fatalf("xref entry for object %d points at the wrong offset", i+1)
+		}
+	}
+
+	var pages []string
+	streams := regexp.MustCompile(`(?s)/Length (\d+) /Filter /FlateDecode >>\nstream\n`).FindAllSubmatchIndex(pdf, -1)
+	for _, s := range streams {
+		length, _ := strconv.Atoi(string(pdf[s[2]:s[3]]))
+		zr, err := zlib.NewReader(bytes.NewReader(pdf[s[1] : s[1]+length]))
+		if err != nil {
+			t.Fatalf("page %d: %v", len(pages)+1, err)
+		}
+		content, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("page %d: %v", len(pages)+1, err)
+		}
+		pages = append(pages, string(content))
+	}
+	return pages
+}
+
+func TestRenderInvoice(t *testing.T) {
+	doc := testDocument(3)
+
+	pdf, err := Render(doc, KindInvoice)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	pages := pageContents(t, pdf)
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	for _, want := range []string{
+		"(INVOICE)", "(No. INV-2026-000123)", `(Acme \(Europe\) Ltd)`, "(Usage line 3)",
+		"(2,500)", "($0.005)", "($37.50)", "(DUE)", "(Please pay by Oct 7, 2026.)", "(Page 1 of 1)",
+	} {
+		if !strings.Contains(pages[0], want) {
+			t.Errorf("invoice is missing %s", want)
+		}
+	}
+}
+
+func TestRenderReceipt(t *testing.T) {
+	doc := testDocument(1)
+
+	if _, err := Render(doc, KindReceipt); !errors.Is(err, ErrNotPaid) {
+		t.Fatalf("Render() of an unpaid receipt error = %v, want ErrNotPaid", err)
+	}
+
+	doc.Status = database.BillingStatusPaid
+	doc.PaidAt = time.Date(2026, 10, 3, 9, 30, 0, 0, time.UTC)
+	pdf, err := Render(doc, KindReceipt)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	content := pageContents(t, pdf)[0]
+	for _, want := range []string{"(RECEIPT)", "(PAID)", "(Amount paid)", "(Oct 3, 2026)"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("receipt is missing %s", want)
+		}
+	}
+}
+
+func TestRenderContinuesOnNewPages(t *testing.T) {
+	pdf, err := Render(testDocument(80), KindInvoice)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	pages := pageContents(t, pdf)
+	if len(pages) < 3 {
+		t.Fatalf("got %d pages for 80 lines, want at least 3", len(pages))
+	}
+	if !strings.Contains(pages[1], "(Invoice INV-2026-000123 \\(continued\\))") {
+		t.Error("second page has no continuation heading")
+	}
+	last := pages[len(pages)-1]
+	if !strings.Contains(last, fmt.Sprintf("(Page %d of %d)", len(pages), len(pages))) || !strings.Contains(last, "($1,000.00)") {
+		t.Error("last page is missing its page number or the total")
+	}
+}
+
+func TestTotals(t *testing.T) {
+	doc := testDocument(2)
+	doc.Lines = append(doc.Lines, Line{Type: database.InvoiceLineItemTypeTax, Description: "VAT 20%", Amount: decimal.NewFromInt(5)})
+	doc.Total = decimal.NewFromInt(30)
+
+	if !doc.Subtotal().Equal(decimal.NewFromInt(25)) || !doc.Tax().Equal(decimal.NewFromInt(5)) {
+		t.Errorf("Subtotal() = %s, Tax() = %s, want 25 and 5", doc.Subtotal(), doc.Tax())
+	}
+	if !doc.AmountDue().Equal(decimal.NewFromInt(30)) {
+		t.Errorf("AmountDue() = %s, want 30", doc.AmountDue())
+	}
+	doc.Status = database.BillingStatusPaid
+	if !doc.AmountDue().IsZero() {
+		t.Errorf("AmountDue() of a paid invoice = %s, want 0", doc.AmountDue())
+	}
+}
+
+func TestTotalsWithCredit(t *testing.T) {
+	doc := testDocument(2)
+	doc.Lines = append(doc.Lines,
+		Line{Type: database.InvoiceLineItemTypeTax, Description: "VAT 20%", Amount: decimal.NewFromInt(5)},
+		Line{Type: database.InvoiceLineItemTypeCredit, Description: "Prepaid credit applied", Amount: decimal.NewFromInt(-12)},
+	)
+	doc.Total = decimal.NewFromInt(18)
+
+	if !doc.Subtotal().Equal(decimal.NewFromInt(25)) || !doc.Credit().Equal(decimal.NewFromInt(-12)) {
+		t.Errorf("Subtotal() = %s, Credit() = %s, want 25 and -12", doc.Subtotal(), doc.Credit())
+	}
+
+	pdf, err := Render(doc, KindInvoice)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if last := pageContents(t, pdf); !strings.Contains(last[len(last)-1], "(Prepaid credit)") {
+		t.Error("invoice does not show the prepaid credit")
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{formatMoney(decimal.RequireFromString("1234567.5"), "USD"), "$1,234,567.50"},
+		{formatMoney(decimal.RequireFromString("-3"), "EUR"), "-€3.00"},
+		{formatMoney(decimal.RequireFromString("99"), "NGN"), "NGN 99.00"},
+		{formatMoney(decimal.RequireFromString("1200"), "JPY"), "¥1,200"},
+		{formatRate(decimal.RequireFromString("0.5"), "JPY"), "¥0.5"},
+		{formatRate(decimal.RequireFromString("0.0125"), "USD"), "$0.0125"},
+		{formatRate(decimal.RequireFromString("29"), "USD"), "$29.00"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+
+	if got := fit(regular, 9, 60, "A very long line item description"); !strings.HasSuffix(got, "…") || textWidth(regular, 9, got) > 60 {
+		t.Errorf("fit() = %q, want it shortened to 60pt", got)
+	}
+	if got := string(encode("Café – ₦5")); got != "Caf\xe9 \x96 ?5" {
+		t.Errorf("encode() = %q", got)
+	}
+}
+
+type fakeSource struct {
+	cycle database.BillingCycle
+	org   database.Organization
+	items []database.InvoiceLineItem
+	// profile is nil for an organization without a tax profile
+	profile *database.OrganizationTaxProfile
+}
+
+func (f fakeSource) GetBillingCycle(ctx context.Context, id uuid.UUID) (database.BillingCycle, error) {
+	return f.cycle, nil
+}
+
+func (f fakeSource) GetOrganization(ctx context.Context, id uuid.UUID) (database.Organization, error) {
+	return f.org, nil
+}
+
+func (f fakeSource) ListInvoiceLineItems(ctx context.Context, billingCycleIds []uuid.UUID) ([]database.InvoiceLineItem, error) {
+	return f.items, nil
+}
+
+func (f fakeSource) GetOrganizationTaxProfile(ctx context.Context, organizationID uuid.UUID) (database.OrganizationTaxProfile, error) {
+	if f.profile == nil {
+		return database.OrganizationTaxProfile{}, pgx.ErrNoRows
+	}
+	return *f.profile, nil
+}
+
+func numeric(s string) pgtype.Numeric {
+	var n pgtype.Numeric
+	n.Scan(s)
+	return n
+}
+
+func TestLoad(t *testing.T) {
+	orgID := uuid.New()
+	cycleID := uuid.New()
+	periodEnd := time.Date(2026, 9, 30, 23, 59, 59, 0, time.UTC)
+	src := fakeSource{
+		cycle: database.BillingCycle{
+			ID:             cycleID,
+			OrganizationID: orgID,
+			InvoiceNumber:  "INV-2026-000042",
+			PeriodEnd:      pgtype.Timestamp{Time: periodEnd, Valid: true},
+			TotalAmount:    numeric("29.00"),
+			Status:         database.BillingStatusPaid,
+			Quote:          []byte(`{"currency":"EUR"}`),
+			PaidAt:         pgtype.Timestamp{Time: periodEnd.Add(48 * time.Hour), Valid: true},
+		},
+		org: database.Organization{ID: orgID, Name: "Acme", Email: "ap@acme.example"},
+		items: []database.InvoiceLineItem{{
+			Type:        database.InvoiceLineItemTypeBaseFee,
+			Description: "Starter plan",
+			Quantity:    1,
+			UnitPrice:   numeric("29"),
+			Amount:      numeric("29.00"),
+		}},
+	}
+
+	doc, err := Load(context.Background(), src, cycleID, Seller{Name: "MTS"})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if doc.Number != "INV-2026-000042" || doc.OrganizationID != orgID || doc.Currency != "EUR" {
+		t.Errorf("doc = %+v", doc)
+	}
+	if !doc.Total.Equal(decimal.NewFromInt(29)) || len(doc.Lines) != 1 || !doc.Lines[0].UnitPrice.Equal(decimal.NewFromInt(29)) {
+		t.Errorf("totals and lines = %s, %+v", doc.Total, doc.Lines)
+	}
+	if !doc.DueAt.Equal(periodEnd.Add(PaymentTerms)) || doc.PaidAt.IsZero() {
+		t.Errorf("due %s, paid %s", doc.DueAt, doc.PaidAt)
+	}
+	if doc.Filename(KindReceipt) != "receipt-INV-2026-000042.pdf" {
+		t.Errorf("Filename() = %q", doc.Filename(KindReceipt))
+	}
+	if doc.Customer.Country != "" || doc.Customer.VATID != "" {
+		t.Errorf("customer without a tax profile = %+v", doc.Customer)
+	}
+
+	src.profile = &database.OrganizationTaxProfile{
+		OrganizationID: orgID,
+		Country:        "DE",
+		VatID:          pgtype.Text{String: "DE123456789", Valid: true},
+	}
+	doc, err = Load(context.Background(), src, cycleID, Seller{Name: "MTS"})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if doc.Customer.Country != "DE" || doc.Customer.VATID != "DE123456789" {
+		t.Errorf("customer with a tax profile = %+v", doc.Customer)
+	}
+
+	// The currency recorded on the cycle wins over the quote's
+	src.cycle.Currency = "NGN"
+	doc, err = Load(context.Background(), src, cycleID, Seller{Name: "MTS"})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if doc.Currency != "NGN" {
+		t.Errorf("Currency = %q, want NGN", doc.Currency)
+	}
+}
+
+func TestInvoiceNumbers(t *testing.T) {
+	if got := FormatNumber("INV", 2026, 123); got != "INV-2026-000123" {
+		t.Errorf("FormatNumber() = %q, want INV-2026-000123", got)
+	}
+	if got := FormatNumber("EU", 2027, 1234567); got != "EU-2027-1234567" {
+		t.Errorf("FormatNumber() past six digits = %q", got)
+	}
+
+	if got := (Seller{}).NumberIssuer(); got != DefaultIssuer {
+		t.Errorf("NumberIssuer() without an issuer = %q, want %q", got, DefaultIssuer)
+	}
+	for issuer, valid := range map[string]bool{"INV": true, "EU2": true, "": false, "inv": false, "INV-EU": false, "ABCDEFGHIJKLMNOPQ": false} {
+		if err := ValidateIssuer(issuer); (err == nil) != valid {
+			t.Errorf("ValidateIssuer(%q) error = %v, want valid %v", issuer, err, valid)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package invoice
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A4 in points
+const (
+	pageWidth  = 595.28
+	pageHeight = 841.89
+)
+
+// font is one of the standard PDF fonts every reader ships with, so nothing
+// has to be embedded
+type font int
+
+const (
+	regular font = iota
+	bold
+)
+
+var fontNames = map[font]string{
+	regular: "Helvetica",
+	bold:    "Helvetica-Bold",
+}
+
+// resourceNames are the names the fonts are registered under on every page
+var resourceNames = map[font]string{
+	regular: "F1",
+	bold:    "F2",
+}
+
+type color struct {
+	r, g, b float64
+}
+
+// hexColor parses a #rrggbb color
+func hexColor(hex string) color {
+	var r, g, b int
+	fmt.Sscanf(strings.TrimPrefix(hex, "#"), "%02x%02x%02x", &r, &g, &b)
+	return color{float64(r) / 255, float64(g) / 255, float64(b) / 255}
+}
+
+// pdfWriter lays out pages of text, lines and boxes and writes them as a
+// PDF 1.4 file. Coordinates are in points from the bottom left of the page.
+type pdfWriter struct {
+	title   string
+	author  string
+	created time.Time
+	pages   []*bytes.Buffer
+	current int
+}
+
+func newPDFWriter(title, author string, created time.Time) *pdfWriter {
+	return &pdfWriter{title: title, author: author, created: created}
+}
+
+// addPage starts a new page and draws on it from then on
+func (p *pdfWriter) addPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+	p.current = len(p.pages) - 1
+}
+
+// setPage goes back to drawing on an earlier page
+func (p *pdfWriter) setPage(i int) {
+	p.current = i
+}
+
+func (p *pdfWriter) page() *bytes.Buffer {
+	if len(p.pages) == 0 {
+		p.addPage()
+	}
+	return p.pages[p.current]
+}
+
+// text draws s with its baseline starting at x, y
+func (p *pdfWriter) text(x, y float64, f font, size float64, c color, s string) {
+	fmt.Fprintf(p.page(), "BT /%s %s Tf %s %s %s rg %s %s Td (%s) Tj ET\n",
+		resourceNames[f], num(size), num(c.r), num(c.g), num(c.b), num(x), num(y), escapeText(s))
+}
+
+// textRight draws s so that it ends at x
+func (p *pdfWriter) textRight(x, y float64, f font, size float64, c color, s string) {
+	p.text(x-textWidth(f, size, s), y, f, size, c, s)
+}
+
+// rect fills a box whose bottom left corner is at x, y
+func (p *pdfWriter) rect(x, y, w, h float64, c color) {
+	fmt.Fprintf(p.page(), "%s %s %s rg %s %s %s %s re f\n",
+		num(c.r), num(c.g), num(c.b), num(x), num(y), num(w), num(h))
+}
+
+// line strokes a straight line
+func (p *pdfWriter) line(x1, y1, x2, y2, width float64, c color) {
+	fmt.Fprintf(p.page(), "%s %s %s RG %s w %s %s m %s %s l S\n",
+		num(c.r), num(c.g), num(c.b), num(width), num(x1), num(y1), num(x2), num(y2))
+}
+
+// bytes writes the document. Page contents are deflated.
+func (p *pdfWriter) bytes() ([]byte, error) {
+	if len(p.pages) == 0 {
+		p.addPage()
+	}
+
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1-5 are the catalog, the page tree, the two fonts and the
+	// document info. Every page then takes two objects, itself and its
+	// content stream.
+	const firstPage = 6
+	kids := make([]string, len(p.pages))
+	for i := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+	object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", fontNames[regular]))
+	object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", fontNames[bold]))
+	object(fmt.Sprintf("<< /Title (%s) /Author (%s) /Producer (%s) /CreationDate (D:%s) >>",
+		escapeText(p.title), escapeText(p.author), escapeText(p.author), p.created.UTC().Format("20060102150405Z")))
+
+	for i, content := range p.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			num(pageWidth), num(pageHeight), resourceNames[regular], resourceNames[bold], firstPage+2*i+1))
+
+		var deflated bytes.Buffer
+		zw := zlib.NewWriter(&deflated)
+		if _, err := zw.Write(content.Bytes()); err != nil {
+			return nil, fmt.Errorf("failed to compress page %d: %w", i+1, err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress page %d: %w", i+1, err)
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", deflated.Len(), deflated.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes(), nil
+}
+
+// num formats a coordinate without trailing zeros
+func num(f float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.2f", f), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// winAnsi maps the characters outside Latin-1 that the standard fonts can
+// still draw to their WinAnsiEncoding byte
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '•': 0x95,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// encode converts s to WinAnsiEncoding. Characters the standard fonts cannot
+// draw become "?".
+func encode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			out = append(out, byte(r))
+		case winAnsi[r] != 0:
+			out = append(out, winAnsi[r])
+		default:
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+// escapeText encodes s as the body of a PDF string literal
+func escapeText(s string) string {
+	var b strings.Builder
+	for _, c := range encode(s) {
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// textWidth is the width of s in points
+func textWidth(f font, size float64, s string) float64 {
+	widths := helveticaWidths
+	if f == bold {
+		widths = helveticaBoldWidths
+	}
+	var units int
+	for _, c := range encode(s) {
+		if c >= 0x20 && c < 0x7f {
+			units += widths[c-0x20]
+		} else {
+			units += 556
+		}
+	}
+	return float64(units) * size / 1000
+}
+
+// Glyph widths of the printable ASCII characters, from the fonts' metrics,
+// in thousandths of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
@@ -0,0 +1,354 @@
+package invoice
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	brandColor  = hexColor("#4F46E5")
+	textColor   = hexColor("#111827")
+	mutedColor  = hexColor("#6B7280")
+	ruleColor   = hexColor("#E5E7EB")
+	headerColor = hexColor("#F3F4F6")
+	white       = color{1, 1, 1}
+)
+
+var statusLabels = map[database.BillingStatus]string{
+	database.BillingStatusPending: "DUE",
+	database.BillingStatusPaid:    "PAID",
+	database.BillingStatusOverdue: "OVERDUE",
+	database.BillingStatusVoid:    "VOID",
+}
+
+var statusColors = map[database.BillingStatus]color{
+	database.BillingStatusPending: hexColor("#D97706"),
+	database.BillingStatusPaid:    hexColor("#10B981"),
+	database.BillingStatusOverdue: hexColor("#DC2626"),
+	database.BillingStatusVoid:    mutedColor,
+}
+
+// currencySymbols are the symbols the standard fonts can draw. Other
+// currencies are written with their code.
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+}
+
+// Page layout, in points
+const (
+	marginLeft  = 50.0
+	marginRight = pageWidth - 50
+	// contentBottom is where the line items stop and the footer begins
+	contentBottom = 90.0
+	rowHeight     = 20.0
+	// Right edges of the line item columns
+	quantityRight  = 370.0
+	unitPriceRight = 460.0
+	amountRight    = marginRight - 8
+	descriptionMax = 240.0
+	// metaLeft is where the labels of the right hand column start
+	metaLeft = 330.0
+)
+
+// Render draws a document as a PDF. A receipt can only be rendered once the
+// invoice is paid.
+func Render(doc Document, kind Kind) ([]byte, error) {
+	if kind == KindReceipt && doc.Status != database.BillingStatusPaid {
+		return nil, ErrNotPaid
+	}
+
+	pdf := newPDFWriter(fmt.Sprintf("%s %s", title(kind), doc.Number), doc.Seller.Name, doc.IssuedAt)
+	pdf.addPage()
+
+	y := drawHeader(pdf, doc, kind)
+	y = drawParties(pdf, doc, kind, y)
+	y = drawLines(pdf, doc, kind, y)
+	drawTotals(pdf, doc, kind, y)
+	drawFooters(pdf, doc)
+
+	return pdf.bytes()
+}
+
+func title(kind Kind) string {
+	if kind == KindReceipt {
+		return "Receipt"
+	}
+	return "Invoice"
+}
+
+// drawHeader draws the brand band across the top of the first page and
+// returns where the content below it starts
+func drawHeader(pdf *pdfWriter, doc Document, kind Kind) float64 {
+	top := pageHeight
+	pdf.rect(0, top-100, pageWidth, 100, brandColor)
+	pdf.text(marginLeft, top-55, bold, 22, white, doc.Seller.Name)
+	contact := doc.Seller.Email
+	if doc.Seller.Website != "" {
+		if contact != "" {
+			contact += "  •  "
+		}
+		contact += doc.Seller.Website
+	}
+	if doc.Seller.TaxID != "" {
+		if contact != "" {
+			contact += "  •  "
+		}
+		contact += "VAT ID " + doc.Seller.TaxID
+	}
+	pdf.text(marginLeft, top-74, regular, 9, white, contact)
+
+	pdf.textRight(marginRight, top-55, bold, 22, white, strings.ToUpper(title(kind)))
+	pdf.textRight(marginRight, top-74, regular, 10, white, "No. "+doc.Number)
+
+	return top - 140
+}
+
+// drawParties draws who is billed on the left and the document details on
+// the right
+func drawParties(pdf *pdfWriter, doc Document, kind Kind, y float64) float64 {
+	pdf.text(marginLeft, y, bold, 8, mutedColor, "BILLED TO")
+	pdf.text(marginLeft, y-18, bold, 12, textColor, doc.Customer.Name)
+	pdf.text(marginLeft, y-34, regular, 10, textColor, doc.Customer.Email)
+	leftBottom := y - 34
+	if doc.Customer.Country != "" {
+		leftBottom -= 15
+		pdf.text(marginLeft, leftBottom, regular, 10, textColor, "Country: "+doc.Customer.Country)
+	}
+	if doc.Customer.VATID != "" {
+		leftBottom -= 15
+		pdf.text(marginLeft, leftBottom, regular, 10, textColor, "VAT ID: "+doc.Customer.VATID)
+	}
+
+	type meta struct {
+		label string
+		value string
+	}
+	rows := []meta{}
+	switch kind {
+	case KindReceipt:
+		paidOn := "—"
+		if !doc.PaidAt.IsZero() {
+			paidOn = formatDate(doc.PaidAt)
+		}
+		rows = append(rows,
+			meta{"Invoice number", doc.Number},
+			meta{"Billing period", formatPeriod(doc.PeriodStart, doc.PeriodEnd)},
+			meta{"Paid on", paidOn},
+		)
+	default:
+		rows = append(rows,
+			meta{"Invoice number", doc.Number},
+			meta{"Issued", formatDate(doc.IssuedAt)},
+			meta{"Billing period", formatPeriod(doc.PeriodStart, doc.PeriodEnd)},
+			meta{"Due date", formatDate(doc.DueAt)},
+		)
+	}
+
+	rowY := y
+	for _, row := range rows {
+		pdf.text(metaLeft, rowY, regular, 9, mutedColor, row.label)
+		pdf.textRight(marginRight, rowY, regular, 9, textColor, row.value)
+		rowY -= 15
+	}
+
+	status, ok := statusLabels[doc.Status]
+	if !ok {
+		status = strings.ToUpper(string(doc.Status))
+	}
+	statusColor, ok := statusColors[doc.Status]
+	if !ok {
+		statusColor = mutedColor
+	}
+	pdf.text(metaLeft, rowY, regular, 9, mutedColor, "Status")
+	pdf.textRight(marginRight, rowY, bold, 9, statusColor, status)
+
+	return min(leftBottom, rowY) - 35
+}
+
+// drawLines draws the line item table, continuing on new pages as needed,
+// and returns where it ends
+func drawLines(pdf *pdfWriter, doc Document, kind Kind, y float64) float64 {
+	y = drawTableHeader(pdf, y)
+
+	if len(doc.Lines) == 0 {
+		pdf.text(marginLeft+8, y, regular, 9, mutedColor, "No charges for this period")
+		return y - rowHeight
+	}
+
+	for _, line := range doc.Lines {
+		if y < contentBottom+rowHeight {
+			pdf.addPage()
+			y = pageHeight - 60
+			pdf.text(marginLeft, y, bold, 10, mutedColor, fmt.Sprintf("%s %s (continued)", title(kind), doc.Number))
+			y = drawTableHeader(pdf, y-30)
+		}
+
+		pdf.text(marginLeft+8, y, regular, 9, textColor, fit(regular, 9, descriptionMax, line.Description))
+		pdf.textRight(quantityRight, y, regular, 9, textColor, groupThousands(fmt.Sprintf("%d", line.Quantity)))
+		pdf.textRight(unitPriceRight, y, regular, 9, textColor, formatRate(line.UnitPrice, doc.Currency))
+		pdf.textRight(amountRight, y, regular, 9, textColor, formatMoney(line.Amount, doc.Currency))
+		pdf.line(marginLeft, y-7, marginRight, y-7, 0.5, ruleColor)
+		y -= rowHeight
+	}
+
+	return y
+}
+
+func drawTableHeader(pdf *pdfWriter, y float64) float64 {
+	pdf.rect(marginLeft, y-7, marginRight-marginLeft, rowHeight, headerColor)
+	pdf.text(marginLeft+8, y, bold, 8, mutedColor, "DESCRIPTION")
+	pdf.textRight(quantityRight, y, bold, 8, mutedColor, "QTY")
+	pdf.textRight(unitPriceRight, y, bold, 8, mutedColor, "UNIT PRICE")
+	pdf.textRight(amountRight, y, bold, 8, mutedColor, "AMOUNT")
+	return y - rowHeight - 2
+}
+
+// drawTotals draws the subtotal, tax, any prepaid credit and total under the
+// line items, with what is due on an invoice or what was paid on a receipt
+func drawTotals(pdf *pdfWriter, doc Document, kind Kind, y float64) {
+	if y < contentBottom+120 {
+		pdf.addPage()
+		y = pageHeight - 60
+	}
+
+	y -= 10
+	pdf.text(metaLeft, y, regular, 9, mutedColor, "Subtotal")
+	pdf.textRight(amountRight, y, regular, 9, textColor, formatMoney(doc.Subtotal(), doc.Currency))
+	y -= 16
+	pdf.text(metaLeft, y, regular, 9, mutedColor, "Tax")
+	pdf.textRight(amountRight, y, regular, 9, textColor, formatMoney(doc.Tax(), doc.Currency))
+	if credit := doc.Credit(); !credit.IsZero() {
+		y -= 16
+		pdf.text(metaLeft, y, regular, 9, mutedColor, "Prepaid credit")
+		pdf.textRight(amountRight, y, regular, 9, textColor, formatMoney(credit, doc.Currency))
+	}
+	y -= 10
+	pdf.line(metaLeft, y, marginRight, y, 0.75, ruleColor)
+	y -= 16
+	pdf.text(metaLeft, y, bold, 10, textColor, "Total")
+	pdf.textRight(amountRight, y, bold, 10, textColor, formatMoney(doc.Total, doc.Currency))
+	y -= 26
+
+	pdf.rect(metaLeft-8, y-9, marginRight-metaLeft+8, 26, headerColor)
+	var note string
+	switch kind {
+	case KindReceipt:
+		pdf.text(metaLeft, y, bold, 11, statusColors[database.BillingStatusPaid], "Amount paid")
+		pdf.textRight(amountRight, y, bold, 11, statusColors[database.BillingStatusPaid], formatMoney(doc.Total, doc.Currency))
+		note = "Paid in full. Thank you for your payment."
+		if !doc.PaidAt.IsZero() {
+			note = fmt.Sprintf("Paid in full on %s. Thank you for your payment.", formatDate(doc.PaidAt))
+		}
+	default:
+		pdf.text(metaLeft, y, bold, 11, brandColor, "Amount due")
+		pdf.textRight(amountRight, y, bold, 11, brandColor, formatMoney(doc.AmountDue(), doc.Currency))
+		switch doc.Status {
+		case database.BillingStatusPaid:
+			note = "This invoice has been paid. Thank you!"
+		case database.BillingStatusOverdue:
+			note = fmt.Sprintf("This invoice was due on %s and is overdue. Please pay it as soon as possible.", formatDate(doc.DueAt))
+		case database.BillingStatusVoid:
+			note = "This invoice has been voided. Nothing is due."
+		default:
+			note = fmt.Sprintf("Please pay by %s.", formatDate(doc.DueAt))
+		}
+	}
+
+	pdf.text(marginLeft, y-40, regular, 9, mutedColor, note)
+}
+
+// drawFooters writes the seller and page number at the bottom of every page
+func drawFooters(pdf *pdfWriter, doc Document) {
+	footer := doc.Seller.Name
+	if doc.Seller.Email != "" {
+		footer += "  •  " + doc.Seller.Email
+	}
+	if doc.Seller.TaxID != "" {
+		footer += "  •  VAT ID " + doc.Seller.TaxID
+	}
+	for i := range pdf.pages {
+		pdf.setPage(i)
+		pdf.line(marginLeft, 60, marginRight, 60, 0.5, ruleColor)
+		pdf.text(marginLeft, 45, regular, 8, mutedColor, footer)
+		pdf.textRight(marginRight, 45, regular, 8, mutedColor, fmt.Sprintf("Page %d of %d", i+1, len(pdf.pages)))
+	}
+}
+
+// fit shortens s with an ellipsis until it is no wider than width
+func fit(f font, size, width float64, s string) string {
+	if textWidth(f, size, s) <= width {
+		return s
+	}
+	for s != "" && textWidth(f, size, s+"…") > width {
+		_, n := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-n]
+	}
+	return s + "…"
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("Jan 2, 2006")
+}
+
+func formatPeriod(start, end time.Time) string {
+	return formatDate(start) + " – " + formatDate(end)
+}
+
+// formatMoney writes an amount in its currency's minor unit, e.g. $1,234.50
+// or ¥1,200
+func formatMoney(amount decimal.Decimal, code string) string {
+	return formatAmount(amount, code, currency.Exponent(code))
+}
+
+// formatRate writes a unit price with as many decimals as it needs, at
+// least the currency's minor unit and at most six
+func formatRate(rate decimal.Decimal, code string) string {
+	places := currency.Exponent(code)
+	for places < 6 && !rate.Round(places).Equal(rate) {
+		places++
+	}
+	return formatAmount(rate, code, places)
+}
+
+func formatAmount(amount decimal.Decimal, code string, places int32) string {
+	symbol, ok := currencySymbols[code]
+	if !ok {
+		symbol = code + " "
+	}
+
+	sign := ""
+	if amount.IsNegative() {
+		sign = "-"
+		amount = amount.Neg()
+	}
+
+	whole, fraction, _ := strings.Cut(amount.StringFixed(places), ".")
+	if fraction == "" {
+		return sign + symbol + groupThousands(whole)
+	}
+	return sign + symbol + groupThousands(whole) + "." + fraction
+}
+
+// groupThousands puts separators into a string of digits
+func groupThousands(digits string) string {
+	if strings.HasPrefix(digits, "-") {
+		return "-" + groupThousands(digits[1:])
+	}
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return b.String()
+}
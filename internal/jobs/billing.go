@@ -2,25 +2,51 @@ package jobs
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"time"
 
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/coupon"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/credit"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
 	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/email"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/invoice"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/plan"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/tax"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/shopspring/decimal"
 )
 
-// GenerateMonthlyBillingCycles creates billing cycles for all organizations
+// GenerateMonthlyBillingCycles creates an itemized billing cycle for every
+// organization. Each plan the organization was on is priced for its time on
+// it, with the catalog's book in effect at the start of the period. The
+// total is converted to the organization's billing currency and any
+// redeemed discount is taken off. It is taxed at the rates in effect when
+// it is issued and paid from prepaid credit where there is some. Every
+// invoice with an amount due is emailed as a PDF.
 // Runs on the 1st of every month at 00:00 UTC
-func GenerateMonthlyBillingCycles(db *database.Queries) error {
+func GenerateMonthlyBillingCycles(pool *pgxpool.Pool, catalog *pricing.Catalog, rates *currency.Rates, emailService *email.EmailService, appURL string, seller invoice.Seller) error {
 	ctx := context.Background()
+	db := database.New(pool)
 
 	now := time.Now()
 	periodStart := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
 	periodEnd := periodStart.AddDate(0, 1, 0).Add(-time.Second)
 
+	// Rates are read once per run so every invoice of a run is taxed alike
+	taxes, err := tax.Load(ctx, db)
+	if err != nil {
+		return err
+	}
+
 	log.Printf("Generating billing cycles for period: %s to %s", periodStart.Format("2006-01-02"), periodEnd.Format("2006-01-02"))
 
 	offset := int32(0)
@@ -41,11 +67,21 @@ func GenerateMonthlyBillingCycles(db *database.Queries) error {
 		}
 
 		for _, org := range orgs {
-			if err := createBillingCycleForOrg(ctx, db, org, periodStart, periodEnd); err != nil {
+			cycle, err := createBillingCycleForOrg(ctx, pool, catalog, rates, taxes, seller, org, periodStart, periodEnd)
+			if errors.Is(err, errCycleExists) {
+				log.Printf("Org %s already has an invoice for the period, skipping", org.ID)
+				continue
+			}
+			if err != nil {
 				log.Printf("Error creating billing cycle for org %s: %v", org.ID, err)
 				continue
 			}
 			totalProcessed++
+
+			// $0 invoices are paid automatically, there is nothing to ask for
+			if emailService != nil && currency.FromNumeric(cycle.TotalAmount).IsPositive() {
+				sendInvoiceEmail(ctx, db, emailService, appURL, seller, cycle)
+			}
 		}
 
 		offset += limit
@@ -55,84 +91,306 @@ func GenerateMonthlyBillingCycles(db *database.Queries) error {
 	return nil
 }
 
-func createBillingCycleForOrg(ctx context.Context, db *database.Queries, org database.Organization, periodStart, periodEnd time.Time) error {
+// errCycleExists is returned when the organization has already been invoiced
+// for the period
+var errCycleExists = errors.New("billing cycle already exists")
+
+// createBillingCycleForOrg stores the cycle, its invoice number and its line
+// items together, so an invoice is never left without its itemization and
+// a number is never used up by an invoice that was not stored. If the
+// organization was invoiced for the period meanwhile, everything is rolled
+// back, the discount and credit included, and errCycleExists returned.
+func createBillingCycleForOrg(ctx context.Context, pool *pgxpool.Pool, catalog *pricing.Catalog, rates *currency.Rates, taxes *tax.Table, seller invoice.Seller, org database.Organization, periodStart, periodEnd time.Time) (database.BillingCycle, error) {
+	db := database.New(pool)
 	startPeriodPg := pgtype.Timestamp{Time: periodStart, Valid: true}
 	endPeriodPg := pgtype.Timestamp{Time: periodEnd, Valid: true}
-	totalRequests, err := db.CountOrganizationUsage(ctx, database.CountOrganizationUsageParams{
-		OrganizationID: org.ID,
-		CreatedAt:      startPeriodPg,
-		CreatedAt_2:    endPeriodPg,
-	})
+
+	// A period spent on more than one plan is priced for its time on each
+	quote, _, totalRequests, err := plan.Quote(ctx, db, catalog, org.ID, org.Plan, periodStart, periodEnd)
 	if err != nil {
-		return fmt.Errorf("failed to count usage: %w", err)
+		return database.BillingCycle{}, err
 	}
 
-	totalAmount := calculateBillingAmount(totalRequests, org.Plan)
+	profileRow, err := db.GetOrganizationTaxProfile(ctx, org.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return database.BillingCycle{}, fmt.Errorf("failed to get tax profile: %w", err)
+	}
 
-	totalAmountPg, err := decimalToPgNumeric(totalAmount)
+	issuedAt := time.Now().UTC()
+
+	rate := billingRate(rates, quote.Currency, org, issuedAt)
+	lines := convertLines(invoiceLines(quote), rate)
+	totalAmount := sumLines(lines)
+	fxRate, fxRateAt := fxColumns(rate)
+
+	breakdown, err := json.Marshal(quote)
 	if err != nil {
-		return fmt.Errorf("failed to convert amount: %w", err)
+		return database.BillingCycle{}, fmt.Errorf("failed to encode quote: %w", err)
 	}
 
 	if totalRequests > math.MaxInt32 {
 		totalRequests = math.MaxInt32
 	}
 
-	cycle, err := db.CreateBillingCycle(ctx, database.CreateBillingCycleParams{
-		OrganizationID: org.ID,
-		PeriodStart:    startPeriodPg,
-		PeriodEnd:      endPeriodPg,
-		TotalRequests:  int32(totalRequests),
-		TotalAmount:    totalAmountPg,
-		Status:         database.BillingStatusPending,
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return database.BillingCycle{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := db.WithTx(tx)
+
+	// Discounts come off the converted subtotal, before tax
+	discount, discounted, err := redeemedDiscount(ctx, qtx, rates, org, totalAmount, rate.Quote, issuedAt)
+	if err != nil {
+		return database.BillingCycle{}, err
+	}
+	if discounted {
+		lines = append(lines, discount)
+		totalAmount = totalAmount.Add(discount.Amount)
+	}
+
+	// Tax is charged at the rate in effect when the invoice is issued
+	if charge, ok := tax.Calculate(tax.ProfileFromRow(profileRow), taxes, seller.Country, totalAmount, issuedAt); ok {
+		lines = append(lines, taxLine(charge))
+		totalAmount = totalAmount.Add(charge.Amount)
+	}
+
+	invoiceNumber, err := nextInvoiceNumber(ctx, qtx, seller, issuedAt)
+	if err != nil {
+		return database.BillingCycle{}, err
+	}
+
+	// Prepaid credit in the invoice's currency pays as much of it as it can.
+	// Locking the wallet keeps a concurrent top-up from being spent twice.
+	applied := decimal.Zero
+	if _, err := qtx.GetCreditWalletForUpdate(ctx, org.ID); err == nil {
+		balance, err := credit.Balance(ctx, qtx, org.ID, rate.Quote)
+		if err != nil {
+			return database.BillingCycle{}, err
+		}
+		applied = credit.Apply(balance, totalAmount)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return database.BillingCycle{}, fmt.Errorf("failed to lock credit wallet: %w", err)
+	}
+	if applied.IsPositive() {
+		lines = append(lines, creditLine(applied))
+		totalAmount = totalAmount.Sub(applied)
+	}
+
+	totalAmountPg, err := decimalToPgNumeric(totalAmount)
+	if err != nil {
+		return database.BillingCycle{}, fmt.Errorf("failed to convert amount: %w", err)
+	}
+
+	cycle, err := qtx.CreateBillingCycle(ctx, database.CreateBillingCycleParams{
+		OrganizationID:   org.ID,
+		PeriodStart:      startPeriodPg,
+		PeriodEnd:        endPeriodPg,
+		TotalRequests:    int32(totalRequests),
+		TotalAmount:      totalAmountPg,
+		Status:           database.BillingStatusPending,
+		PriceBookVersion: &quote.Version,
+		Quote:            breakdown,
+		InvoiceNumber:    invoiceNumber,
+		Currency:         rate.Quote,
+		FxRate:           fxRate,
+		FxRateAt:         fxRateAt,
+		Kind:             database.BillingCycleKindPeriod,
 	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return database.BillingCycle{}, errCycleExists
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create billing cycle: %w", err)
+		return database.BillingCycle{}, fmt.Errorf("failed to create billing cycle: %w", err)
+	}
+
+	if err := createLineItems(ctx, qtx, cycle.ID, lines); err != nil {
+		return database.BillingCycle{}, err
 	}
 
-	log.Printf("Created billing cycle %s for org %s: %d requests, $%s", cycle.ID, org.Name, totalRequests, totalAmount)
+	if applied.IsPositive() {
+		_, err := credit.Post(ctx, qtx, credit.Transaction{
+			OrganizationID: org.ID,
+			Type:           database.CreditTransactionTypeInvoicePayment,
+			Currency:       cycle.Currency,
+			Amount:         applied,
+			Description:    "Invoice " + cycle.InvoiceNumber,
+			BillingCycleID: cycle.ID,
+		})
+		if err != nil {
+			return database.BillingCycle{}, err
+		}
 
-	// Send invoice email (implement this based on your email service)
-	// sendInvoiceEmail(org, cycle)
+		if totalAmount.IsZero() {
+			cycle, err = qtx.UpdateBillingCycleStatus(ctx, database.UpdateBillingCycleStatusParams{
+				Status: database.BillingStatusPaid,
+				ID:     cycle.ID,
+			})
+			if err != nil {
+				return database.BillingCycle{}, fmt.Errorf("failed to mark invoice paid from credit: %w", err)
+			}
+		}
+	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return database.BillingCycle{}, fmt.Errorf("failed to commit billing cycle: %w", err)
+	}
+
+	log.Printf("Created invoice %s for org %s: %d requests, %s %s (price book %s)", cycle.InvoiceNumber, org.Name, totalRequests, totalAmount, cycle.Currency, quote.Version)
+	if discounted {
+		log.Printf("Discounted invoice %s by %s %s: %s", cycle.InvoiceNumber, discount.Amount.Neg(), cycle.Currency, discount.Description)
+	}
+	if applied.IsPositive() {
+		log.Printf("Paid %s %s of invoice %s from prepaid credit", applied, cycle.Currency, cycle.InvoiceNumber)
+	}
+
+	return cycle, nil
+}
+
+// billingRate converts prices to the organization's currency at the rate
+// published when the invoice is issued. Without a rate the invoice is
+// issued in the price book's currency rather than not at all.
+func billingRate(rates *currency.Rates, priceCurrency string, org database.Organization, issuedAt time.Time) currency.Rate {
+	billingCurrency := org.BillingCurrency
+	if billingCurrency == "" {
+		billingCurrency = currency.Default
+	}
+	rate, ok := rates.RateAt(priceCurrency, billingCurrency, issuedAt)
+	if !ok {
+		log.Printf("WARNING: No %s/%s exchange rate, invoicing org %s in %s", priceCurrency, billingCurrency, org.ID, priceCurrency)
+		rate, _ = rates.RateAt(priceCurrency, priceCurrency, issuedAt)
+	}
+	return rate
+}
+
+// fxColumns are the rate an invoice was converted at as stored, NULL when
+// it was not converted
+func fxColumns(rate currency.Rate) (pgtype.Numeric, pgtype.Timestamp) {
+	if rate.Base == rate.Quote {
+		return pgtype.Numeric{}, pgtype.Timestamp{}
+	}
+	return rate.Numeric(), pgtype.Timestamp{Time: rate.EffectiveAt, Valid: true}
+}
+
+// nextInvoiceNumber allocates the next invoice number. Invoices are numbered
+// by the year they are issued in.
+func nextInvoiceNumber(ctx context.Context, db *database.Queries, seller invoice.Seller, issuedAt time.Time) (string, error) {
+	issuer := seller.NumberIssuer()
+	seq, err := db.NextInvoiceNumber(ctx, database.NextInvoiceNumberParams{
+		Issuer: issuer,
+		Year:   int32(issuedAt.Year()),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate invoice number: %w", err)
+	}
+	return invoice.FormatNumber(issuer, issuedAt.Year(), seq), nil
+}
+
+// createLineItems stores an invoice's lines in order
+func createLineItems(ctx context.Context, db *database.Queries, cycleID uuid.UUID, lines []invoiceLine) error {
+	for i, line := range lines {
+		unitPrice, err := decimalToPgNumeric(line.UnitPrice)
+		if err != nil {
+			return fmt.Errorf("failed to convert unit price: %w", err)
+		}
+		amount, err := decimalToPgNumeric(line.Amount)
+		if err != nil {
+			return fmt.Errorf("failed to convert amount: %w", err)
+		}
+		_, err = db.CreateInvoiceLineItem(ctx, database.CreateInvoiceLineItemParams{
+			BillingCycleID: cycleID,
+			Position:       int32(i + 1),
+			Type:           line.Type,
+			Description:    line.Description,
+			Quantity:       line.Quantity,
+			UnitPrice:      unitPrice,
+			Amount:         amount,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create invoice line item: %w", err)
+		}
+	}
 	return nil
 }
 
+// redeemedDiscount itemizes what the organization's active discount takes
+// off subtotal, in code. Applying it spends one of the redemption's
+// invoices, so db must be bound to the invoice's transaction. It reports
+// false, and spends nothing, when there is nothing to take off.
+func redeemedDiscount(ctx context.Context, db *database.Queries, rates *currency.Rates, org database.Organization, subtotal decimal.Decimal, code string, at time.Time) (invoiceLine, bool, error) {
+	redemption, err := db.GetActiveCouponRedemptionForUpdate(ctx, org.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return invoiceLine{}, false, nil
+	}
+	if err != nil {
+		return invoiceLine{}, false, fmt.Errorf("failed to get redeemed coupon: %w", err)
+	}
+
+	row, err := db.GetCoupon(ctx, redemption.CouponID)
+	if err != nil {
+		return invoiceLine{}, false, fmt.Errorf("failed to get coupon: %w", err)
+	}
+	c := coupon.FromRow(row)
+
+	// An organization that moved off the coupon's plans keeps its remaining
+	// invoices for when it moves back
+	if !c.AppliesTo(org.Plan) {
+		return invoiceLine{}, false, nil
+	}
+	amount, ok := c.Discount(subtotal, code, rates, at)
+	if !ok {
+		log.Printf("WARNING: No %s/%s exchange rate, not applying coupon %s to org %s", c.Currency, code, c.Code, org.ID)
+		return invoiceLine{}, false, nil
+	}
+
+	// A zero subtotal takes nothing off, so the invoice is not spent
+	if !amount.IsPositive() {
+		return invoiceLine{}, false, nil
+	}
+	if _, err := db.UseCouponRedemption(ctx, redemption.ID); err != nil {
+		return invoiceLine{}, false, fmt.Errorf("failed to use coupon redemption: %w", err)
+	}
+	return discountLine(c, amount), true, nil
+}
+
+// sendInvoiceEmail emails a new invoice to its organization with the PDF
+// attached
+func sendInvoiceEmail(ctx context.Context, db *database.Queries, emailService *email.EmailService, appURL string, seller invoice.Seller, cycle database.BillingCycle) {
+	doc, err := invoice.Load(ctx, db, cycle.ID, seller)
+	if err != nil {
+		log.Printf("Failed to load invoice %s for email: %v", cycle.ID, err)
+		return
+	}
+
+	pdf, err := invoice.Render(doc, invoice.KindInvoice)
+	if err != nil {
+		// The email still goes out, without the attachment
+		log.Printf("Failed to render invoice %s: %v", cycle.InvoiceNumber, err)
+	}
+
+	err = emailService.SendBillingInvoice(doc.Customer.Email, email.BillingInvoiceData{
+		OrganizationName: doc.Customer.Name,
+		InvoiceNumber:    doc.Number,
+		PeriodStart:      doc.PeriodStart.Format("Jan 2, 2006"),
+		PeriodEnd:        doc.PeriodEnd.Format("Jan 2, 2006"),
+		TotalRequests:    int(cycle.TotalRequests),
+		TotalAmount:      doc.Total.InexactFloat64(),
+		Currency:         doc.Currency,
+		InvoiceURL:       appURL + "/billing",
+		DueDate:          doc.DueAt.Format("Jan 2, 2006"),
+	}, pdf)
+	if err != nil {
+		log.Printf("Failed to send invoice %s to %s: %v", cycle.InvoiceNumber, doc.Customer.Email, err)
+	}
+}
+
 func decimalToPgNumeric(d decimal.Decimal) (pgtype.Numeric, error) {
 	num := pgtype.Numeric{}
 	err := num.Scan(d.String())
 	return num, err
 }
 
-func calculateBillingAmount(requests int64, plan database.PlanType) decimal.Decimal {
-	switch plan {
-	case database.PlanTypeFree:
-		// Free plan: First 1000 requests are free, then $0.01 per request
-		if requests <= 1000 {
-			return decimal.NewFromInt(0)
-		}
-		billableRequests := requests - 1000
-		perRequestCost := decimal.NewFromFloat(0.01)
-		return decimal.NewFromInt(billableRequests).Mul(perRequestCost)
-
-	case database.PlanTypeStarter:
-		// Starter: $29 base + $0.01 per request
-		baseCost := decimal.NewFromInt(29)
-		perRequestCost := decimal.NewFromFloat(0.01)
-		usageCost := decimal.NewFromInt(requests).Mul(perRequestCost)
-		return baseCost.Add(usageCost)
-
-	case database.PlanTypePro:
-		// Pro: $99 base + $0.005 per request (50% discount)
-		baseCost := decimal.NewFromInt(99)
-		perRequestCost := decimal.NewFromFloat(0.005)
-		usageCost := decimal.NewFromInt(requests).Mul(perRequestCost)
-		return baseCost.Add(usageCost)
-	}
-
-	return decimal.NewFromInt(0)
-}
-
 // CheckAndMarkOverdueBillings marks pending invoices as overdue
 // Run daily at 02:00 UTC
 func CheckAndMarkOverdueBillings(db *database.Queries) error {
@@ -149,7 +407,7 @@ func CheckAndMarkOverdueBillings(db *database.Queries) error {
 	overdueCount := 0
 
 	for _, cycle := range pendingCycles {
-		dueDate := cycle.PeriodEnd.Time.Add(7 * 24 * time.Hour)
+		dueDate := cycle.PeriodEnd.Time.Add(invoice.PaymentTerms)
 
 		if now.After(dueDate) {
 			_, err := db.UpdateBillingCycleStatus(ctx, database.UpdateBillingCycleStatusParams{
@@ -164,8 +422,8 @@ func CheckAndMarkOverdueBillings(db *database.Queries) error {
 			overdueCount++
 			daysPastDue := int(now.Sub(dueDate).Hours() / 24)
 
-			log.Printf("Marked billing cycle %s as OVERDUE (org: %s, days past due: %d)",
-				cycle.ID, cycle.OrganizationName, daysPastDue)
+			log.Printf("Marked invoice %s as OVERDUE (org: %s, days past due: %d)",
+				cycle.InvoiceNumber, cycle.OrganizationName, daysPastDue)
 
 			// Send overdue notification
 			// sendOverdueNotification(cycle)
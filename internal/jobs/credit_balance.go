@@ -0,0 +1,171 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/credit"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/email"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/events"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// CheckCreditBalances compares each prepaid organization's available credit,
+// its balance less this period's usage so far, with its low balance
+// threshold. Falling below it is notified once until the organization tops
+// up or the balance recovers. Organizations that asked to be blocked at zero
+// have their API key traffic paused while nothing is available and resumed
+// once there is.
+func CheckCreditBalances(pool *pgxpool.Pool, catalog *pricing.Catalog, rates *currency.Rates, emailService *email.EmailService, appURL string) error {
+	ctx := context.Background()
+	db := database.New(pool)
+	now := time.Now()
+
+	wallets, err := db.ListCreditWallets(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list credit wallets: %w", err)
+	}
+
+	notified, blocked := 0, 0
+	for _, wallet := range wallets {
+		balance, err := credit.Balance(ctx, db, wallet.OrganizationID, wallet.OrgBillingCurrency)
+		if err != nil {
+			log.Printf("Error reading credit balance for org %s: %v", wallet.OrganizationID, err)
+			continue
+		}
+		accrued, err := credit.Accrued(ctx, db, catalog, rates, wallet.OrganizationID, wallet.OrgPlan, wallet.OrgBillingCurrency, now)
+		if err != nil {
+			log.Printf("Error pricing usage for org %s: %v", wallet.OrganizationID, err)
+			continue
+		}
+		available := balance.Sub(accrued)
+
+		notice, err := recordCreditBalance(ctx, pool, wallet, balance, available)
+		if err != nil {
+			log.Printf("Error recording credit balance for org %s: %v", wallet.OrganizationID, err)
+			continue
+		}
+		if notice == nil {
+			continue
+		}
+		notified++
+		if notice.Blocked {
+			blocked++
+		}
+
+		if emailService != nil {
+			notice.TopUpURL = appURL + "/billing/credits"
+			notifyLowCreditBalance(ctx, db, emailService, wallet.OrganizationID, *notice)
+		}
+	}
+
+	log.Printf("Checked %d credit wallets, %d notified, %d newly blocked", len(wallets), notified, blocked)
+	return nil
+}
+
+// lowBalance reports whether available credit is below the wallet's
+// threshold. Wallets without a threshold are never low.
+func lowBalance(wallet database.ListCreditWalletsRow, available decimal.Decimal) bool {
+	if !wallet.LowBalanceThreshold.Valid {
+		return false
+	}
+	return available.LessThan(credit.Decimal(wallet.LowBalanceThreshold))
+}
+
+// recordCreditBalance updates the wallet's notice and blocked state and
+// queues their events in one transaction. It returns the email data when
+// the organization should be told, or nil.
+func recordCreditBalance(ctx context.Context, pool *pgxpool.Pool, wallet database.ListCreditWalletsRow, balance, available decimal.Decimal) (*email.LowCreditBalanceData, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	db := database.New(pool).WithTx(tx)
+	code := wallet.OrgBillingCurrency
+	payload := map[string]interface{}{
+		"currency":  code,
+		"balance":   balance.StringFixed(currency.Exponent(code)),
+		"available": available.StringFixed(currency.Exponent(code)),
+	}
+
+	newlyLow := false
+	if lowBalance(wallet, available) {
+		marked, err := db.MarkCreditWalletLowBalanceNotified(ctx, wallet.OrganizationID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to mark low balance notice: %w", err)
+		}
+		newlyLow = marked > 0
+	} else if wallet.LowBalanceNotifiedAt.Valid {
+		if err := db.ClearCreditWalletLowBalanceNotice(ctx, wallet.OrganizationID); err != nil {
+			return nil, fmt.Errorf("failed to clear low balance notice: %w", err)
+		}
+	}
+	if newlyLow {
+		payload["threshold"] = credit.Decimal(wallet.LowBalanceThreshold).StringFixed(currency.Exponent(code))
+		if _, err := events.Publish(ctx, db, wallet.OrganizationID, events.TypeCreditLowBalance, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	newlyBlocked := false
+	if wallet.BlockAtZero {
+		exhausted := !available.IsPositive()
+		changed, err := db.SetCreditWalletBlocked(ctx, database.SetCreditWalletBlockedParams{
+			Blocked:        exhausted,
+			OrganizationID: wallet.OrganizationID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to update blocked state: %w", err)
+		}
+		if changed > 0 {
+			log.Printf("Prepaid credit of org %s: blocked = %v (available %s %s)", wallet.OrganizationID, exhausted, available, code)
+		}
+		newlyBlocked = exhausted && changed > 0
+	}
+	if newlyBlocked {
+		if _, err := events.Publish(ctx, db, wallet.OrganizationID, events.TypeCreditExhausted, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit credit balance: %w", err)
+	}
+
+	if !newlyLow && !newlyBlocked {
+		return nil, nil
+	}
+	notice := &email.LowCreditBalanceData{
+		OrganizationName: wallet.OrgName,
+		Balance:          currency.Format(balance, code),
+		Available:        currency.Format(available, code),
+		Blocked:          newlyBlocked,
+	}
+	if wallet.LowBalanceThreshold.Valid {
+		notice.Threshold = currency.Format(credit.Decimal(wallet.LowBalanceThreshold), code)
+	}
+	return notice, nil
+}
+
+func notifyLowCreditBalance(ctx context.Context, db *database.Queries, emailService *email.EmailService, orgID uuid.UUID, data email.LowCreditBalanceData) {
+	recipients, err := db.ListOrganizationAdminEmails(ctx, orgID)
+	if err != nil {
+		log.Printf("Failed to list admins for credit notice to org %s: %v", orgID, err)
+		return
+	}
+
+	for _, to := range recipients {
+		if err := emailService.SendLowCreditBalance(to, data); err != nil {
+			log.Printf("Failed to send credit notice to %s: %v", to, err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package jobs
+
+import (
+	"testing"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/credit"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/shopspring/decimal"
+)
+
+func TestLowBalance(t *testing.T) {
+	threshold := decimal.NewFromInt(5000)
+	wallet := database.ListCreditWalletsRow{LowBalanceThreshold: credit.Numeric(threshold)}
+
+	tests := []struct {
+		available string
+		want      bool
+	}{
+		{"12000", false},
+		{"5000", false},
+		{"4999.99", true},
+		{"-20", true},
+	}
+
+	for _, tt := range tests {
+		if got := lowBalance(wallet, decimal.RequireFromString(tt.available)); got != tt.want {
+			t.Errorf("lowBalance(%s) = %v, want %v", tt.available, got, tt.want)
+		}
+	}
+
+	if lowBalance(database.ListCreditWalletsRow{}, decimal.NewFromInt(-20)) {
+		t.Error("lowBalance() without a threshold = true, want false")
+	}
+}
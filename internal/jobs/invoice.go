@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/coupon"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/tax"
+	"github.com/shopspring/decimal"
+)
+
+// invoiceLine is one itemized charge before it is stored
+type invoiceLine struct {
+	Type        database.InvoiceLineItemType
+	Description string
+	Quantity    int64
+	UnitPrice   decimal.Decimal
+	Amount      decimal.Decimal
+}
+
+// messageTypeLabels names message types on invoices
+var messageTypeLabels = map[string]string{
+	"":      "Usage",
+	"sms":   "SMS",
+	"email": "Email",
+}
+
+func messageTypeLabel(messageType string) string {
+	if label, ok := messageTypeLabels[messageType]; ok {
+		return label
+	}
+	return strings.ToUpper(messageType[:1]) + messageType[1:]
+}
+
+// invoiceLines itemizes a quote: the base fee, a usage line for every message
+// type priced at its own rate and, on tiered plans, a line for every tier.
+// A period spent on more than one plan is itemized plan by plan, each line
+// dated, and time on a trial or billed by Stripe is left off. The lines add up to the quote's
+// total.
+func invoiceLines(quote pricing.Quote) []invoiceLine {
+	lines := []invoiceLine{}
+
+	if len(quote.Segments) > 0 {
+		for _, segment := range quote.Segments {
+			if segment.Trial || segment.BilledByStripe {
+				continue
+			}
+			for _, line := range invoiceLines(segment) {
+				line.Description += " " + segmentDates(*segment.From, *segment.Until)
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	if quote.BaseFee.IsPositive() {
+		lines = append(lines, invoiceLine{
+			Type:        database.InvoiceLineItemTypeBaseFee,
+			Description: planName(quote.Plan) + " plan",
+			Quantity:    1,
+			UnitPrice:   quote.BaseFee,
+			Amount:      quote.BaseFee,
+		})
+	}
+
+	for _, line := range quote.Lines {
+		if line.Tiered {
+			continue
+		}
+		description := messageTypeLabel(line.MessageType) + " units"
+		if line.IncludedUnits > 0 {
+			description += fmt.Sprintf(" (%s of %s used, %s included)",
+				formatUnits(line.BillableUnits), formatUnits(line.Units), formatUnits(line.IncludedUnits))
+		}
+		lines = append(lines, invoiceLine{
+			Type:        database.InvoiceLineItemTypeUsage,
+			Description: description,
+			Quantity:    line.BillableUnits,
+			UnitPrice:   line.UnitRate,
+			Amount:      line.Amount,
+		})
+	}
+
+	for _, tier := range quote.Tiers {
+		units := fmt.Sprintf("units %s+", formatUnits(tier.From))
+		if tier.UpTo != 0 {
+			units = fmt.Sprintf("units %s-%s", formatUnits(tier.From), formatUnits(tier.UpTo))
+		}
+		lines = append(lines, invoiceLine{
+			Type:        database.InvoiceLineItemTypeUsage,
+			Description: fmt.Sprintf("Usage tier %d, %s (%s pricing)", tier.Tier, units, quote.Model),
+			Quantity:    tier.Units,
+			UnitPrice:   tier.UnitRate,
+			Amount:      tier.Amount,
+		})
+	}
+
+	return lines
+}
+
+// planName capitalizes a plan for invoices
+func planName(plan database.PlanType) string {
+	return strings.ToUpper(string(plan[:1])) + string(plan[1:])
+}
+
+// segmentDates writes the days from up to until, e.g. "(Nov 1 to Nov 15)"
+func segmentDates(from, until time.Time) string {
+	return fmt.Sprintf("(%s to %s)", from.Format("Jan 2"), until.Add(-time.Second).Format("Jan 2"))
+}
+
+// unitPricePlaces is how many decimals a converted unit price keeps, enough
+// for per-unit rates of a fraction of a cent
+const unitPricePlaces = 6
+
+// convertLines prices lines, in the rate's base currency, in its quote
+// currency. Amounts are rounded to the currency's minor unit.
+func convertLines(lines []invoiceLine, rate currency.Rate) []invoiceLine {
+	converted := make([]invoiceLine, 0, len(lines))
+	for _, line := range lines {
+		line.UnitPrice = rate.Convert(line.UnitPrice).Round(unitPricePlaces)
+		line.Amount = currency.Round(rate.Convert(line.Amount), rate.Quote)
+		converted = append(converted, line)
+	}
+	return converted
+}
+
+// sumLines adds up the amounts of lines
+func sumLines(lines []invoiceLine) decimal.Decimal {
+	total := decimal.Zero
+	for _, line := range lines {
+		total = total.Add(line.Amount)
+	}
+	return total
+}
+
+// taxLine itemizes the tax on an invoice. Reverse charge and exempt lines
+// have no amount and state why no tax is charged.
+func taxLine(charge tax.Charge) invoiceLine {
+	return invoiceLine{
+		Type:        database.InvoiceLineItemTypeTax,
+		Description: charge.Description(),
+		Quantity:    1,
+		UnitPrice:   charge.Amount,
+		Amount:      charge.Amount,
+	}
+}
+
+// discountLine itemizes a coupon's discount. It comes before tax, which is
+// charged on the discounted subtotal.
+func discountLine(c coupon.Coupon, amount decimal.Decimal) invoiceLine {
+	return invoiceLine{
+		Type:        database.InvoiceLineItemTypeDiscount,
+		Description: c.Description(),
+		Quantity:    1,
+		UnitPrice:   amount.Neg(),
+		Amount:      amount.Neg(),
+	}
+}
+
+// creditLine itemizes the prepaid credit spent on an invoice. It comes after
+// tax: credit pays the invoice rather than discounting it.
+func creditLine(applied decimal.Decimal) invoiceLine {
+	return invoiceLine{
+		Type:        database.InvoiceLineItemTypeCredit,
+		Description: "Prepaid credit applied",
+		Quantity:    1,
+		UnitPrice:   applied.Neg(),
+		Amount:      applied.Neg(),
+	}
+}
+
+// formatUnits writes a unit count with thousands separators
+func formatUnits(n int64) string {
+	digits := fmt.Sprintf("%d", n)
+	if n < 0 {
+		return "-" + formatUnits(-n)
+	}
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return b.String()
+}
@@ -0,0 +1,226 @@
+package jobs
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/tax"
+	"github.com/shopspring/decimal"
+)
+
+func TestInvoiceLinesFlatPlan(t *testing.T) {
+	quote := pricing.Default().Quote(database.PlanTypeStarter, time.Now(), pricing.Usage{"sms": 1200, "email": 300})
+	lines := invoiceLines(quote)
+
+	if len(lines) != 3 {
+		t.Fatalf("lines = %+v, want base fee, email and sms", lines)
+	}
+	if lines[0].Type != database.InvoiceLineItemTypeBaseFee || lines[0].Description != "Starter plan" {
+		t.Errorf("first line = %+v, want the base fee", lines[0])
+	}
+	if lines[1].Description != "Email units" || lines[1].Quantity != 300 {
+		t.Errorf("email line = %+v", lines[1])
+	}
+	if !sumLines(lines).Equal(quote.Total) {
+		t.Errorf("lines add up to %s, quote total is %s", sumLines(lines), quote.Total)
+	}
+}
+
+func TestInvoiceLinesIncludedUnits(t *testing.T) {
+	quote := pricing.Default().Quote(database.PlanTypeFree, time.Now(), pricing.Usage{"sms": 1500})
+	lines := invoiceLines(quote)
+
+	if len(lines) != 1 {
+		t.Fatalf("lines = %+v, want a single usage line", lines)
+	}
+	want := "SMS units (500 of 1,500 used, 1,000 included)"
+	if lines[0].Description != want || lines[0].Quantity != 500 || !lines[0].Amount.Equal(decimal.NewFromInt(5)) {
+		t.Errorf("line = %+v, want %q for $5", lines[0], want)
+	}
+}
+
+func TestInvoiceLinesTiers(t *testing.T) {
+	book := pricing.DefaultBook()
+	book.Plans[database.PlanTypePro] = pricing.PlanPrice{
+		BaseFee: decimal.NewFromInt(99),
+		Model:   pricing.ModelGraduated,
+		Tiers: []pricing.Tier{
+			{UpTo: 10000, UnitRate: decimal.RequireFromString("0.01")},
+			{UnitRate: decimal.RequireFromString("0.005")},
+		},
+		MessageTypeRates: map[string]decimal.Decimal{"sms": decimal.RequireFromString("0.02")},
+	}
+	catalog, err := pricing.NewCatalog(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quote := catalog.Quote(database.PlanTypePro, time.Now(), pricing.Usage{"email": 25000, "sms": 100})
+	lines := invoiceLines(quote)
+
+	wantDescriptions := []string{
+		"Pro plan",
+		"SMS units",
+		"Usage tier 1, units 1-10,000 (graduated pricing)",
+		"Usage tier 2, units 10,001+ (graduated pricing)",
+	}
+	if len(lines) != len(wantDescriptions) {
+		t.Fatalf("lines = %+v", lines)
+	}
+	for i, want := range wantDescriptions {
+		if lines[i].Description != want {
+			t.Errorf("line %d = %q, want %q", i+1, lines[i].Description, want)
+		}
+	}
+	if lines[3].Quantity != 15000 {
+		t.Errorf("tier 2 quantity = %d, want 15000", lines[3].Quantity)
+	}
+	if !sumLines(lines).Equal(quote.Total) {
+		t.Errorf("lines add up to %s, quote total is %s", sumLines(lines), quote.Total)
+	}
+}
+
+func TestFormatUnits(t *testing.T) {
+	tests := map[int64]string{0: "0", 999: "999", 1000: "1,000", 1234567: "1,234,567", -4500: "-4,500"}
+	for n, want := range tests {
+		if got := formatUnits(n); got != want {
+			t.Errorf("formatUnits(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestTaxLine(t *testing.T) {
+	quote := pricing.Default().Quote(database.PlanTypeStarter, time.Now(), pricing.Usage{"": 1000})
+	table := tax.NewTable(tax.Rate{Country: "DE", Name: "VAT", Percent: decimal.NewFromInt(19), ReverseCharge: true})
+
+	charge, ok := tax.Calculate(tax.Profile{Country: "DE"}, table, "DE", quote.Total, time.Now())
+	if !ok {
+		t.Fatal("Calculate() found no tax for a German customer")
+	}
+	line := taxLine(charge)
+	if line.Type != database.InvoiceLineItemTypeTax || line.Description != "VAT 19% (DE)" || !line.Amount.Equal(decimal.RequireFromString("7.41")) {
+		t.Errorf("tax line = %+v, want VAT 19%% of $39", line)
+	}
+
+	charge, _ = tax.Calculate(tax.Profile{Country: "DE", VATID: "DE123456789"}, table, "NG", quote.Total, time.Now())
+	if line := taxLine(charge); !line.Amount.IsZero() || !strings.Contains(line.Description, "reverse charge") {
+		t.Errorf("reverse charge line = %+v", line)
+	}
+}
+
+func TestConvertLines(t *testing.T) {
+	quote := pricing.Default().Quote(database.PlanTypeStarter, time.Now(), pricing.Usage{"sms": 1200, "email": 300})
+	lines := invoiceLines(quote)
+
+	toNGN := currency.Rate{Base: "USD", Quote: "NGN", Rate: decimal.RequireFromString("1550.255")}
+	converted := convertLines(lines, toNGN)
+	if len(converted) != len(lines) {
+		t.Fatalf("converted %d lines, want %d", len(converted), len(lines))
+	}
+	if want := decimal.RequireFromString("44957.40"); !converted[0].Amount.Equal(want) {
+		t.Errorf("base fee = %s NGN, want %s", converted[0].Amount, want)
+	}
+	if lines[0].Amount.Equal(converted[0].Amount) {
+		t.Error("convertLines() changed the original lines")
+	}
+
+	toJPY := currency.Rate{Base: "USD", Quote: "JPY", Rate: decimal.RequireFromString("149.37")}
+	for _, line := range convertLines(lines, toJPY) {
+		if !line.Amount.Equal(line.Amount.Round(0)) {
+			t.Errorf("%s = %s JPY, want whole yen", line.Description, line.Amount)
+		}
+	}
+}
+
+func TestInvoiceLinesPlanSegments(t *testing.T) {
+	catalog := pricing.Default()
+	start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	mid := start.AddDate(0, 0, 15)
+	end := start.AddDate(0, 1, 0)
+	half := decimal.RequireFromString("0.5")
+
+	starter := catalog.QuoteShare(database.PlanTypeStarter, start, pricing.Usage{"sms": 100}, half, half)
+	starter.From, starter.Until = &start, &mid
+	pro := catalog.QuoteShare(database.PlanTypePro, start, pricing.Usage{"sms": 200}, half, decimal.Zero)
+	pro.From, pro.Until = &mid, &end
+	quote := pricing.Combine([]pricing.Quote{starter, pro})
+
+	lines := invoiceLines(quote)
+	wantDescriptions := []string{
+		"Starter plan (Nov 1 to Nov 15)",
+		"SMS units (Nov 1 to Nov 15)",
+		"SMS units (Nov 16 to Nov 30)",
+	}
+	if len(lines) != len(wantDescriptions) {
+		t.Fatalf("lines = %+v", lines)
+	}
+	for i, want := range wantDescriptions {
+		if lines[i].Description != want {
+			t.Errorf("line %d = %q, want %q", i+1, lines[i].Description, want)
+		}
+	}
+	if !sumLines(lines).Equal(quote.Total) {
+		t.Errorf("lines add up to %s, quote total is %s", sumLines(lines), quote.Total)
+	}
+}
+
+func TestInvoiceLinesSkipTrial(t *testing.T) {
+	catalog := pricing.Default()
+	start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	mid := start.AddDate(0, 0, 15)
+	end := start.AddDate(0, 1, 0)
+	half := decimal.RequireFromString("0.5")
+
+	trial := catalog.QuoteShare(database.PlanTypePro, start, pricing.Usage{}, half, decimal.Zero)
+	trial.From, trial.Until, trial.Trial = &start, &mid, true
+	pro := catalog.QuoteShare(database.PlanTypePro, start, pricing.Usage{"sms": 200}, half, decimal.Zero)
+	pro.From, pro.Until = &mid, &end
+	quote := pricing.Combine([]pricing.Quote{trial, pro})
+
+	lines := invoiceLines(quote)
+	if len(lines) != 1 || lines[0].Description != "SMS units (Nov 16 to Nov 30)" {
+		t.Fatalf("lines = %+v, want only the usage after the trial", lines)
+	}
+}
+
+func TestInvoiceLinesSkipStripe(t *testing.T) {
+	catalog := pricing.Default()
+	start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	mid := start.AddDate(0, 0, 15)
+	end := start.AddDate(0, 1, 0)
+	half := decimal.RequireFromString("0.5")
+
+	starter := catalog.QuoteShare(database.PlanTypeStarter, start, pricing.Usage{"sms": 200}, half, half)
+	starter.From, starter.Until = &start, &mid
+	stripe := catalog.QuoteShare(database.PlanTypeStarter, start, pricing.Usage{}, half, decimal.Zero)
+	stripe.From, stripe.Until, stripe.BilledByStripe = &mid, &end, true
+	quote := pricing.Combine([]pricing.Quote{starter, stripe})
+
+	for _, line := range invoiceLines(quote) {
+		if strings.HasSuffix(line.Description, "(Nov 16 to Nov 30)") {
+			t.Errorf("line %q is billed by Stripe", line.Description)
+		}
+	}
+	if !quote.Total.Equal(starter.Total) {
+		t.Errorf("total = %s, want only the time before the subscription (%s)", quote.Total, starter.Total)
+	}
+}
+
+func TestProrationLine(t *testing.T) {
+	from := time.Date(2026, 11, 16, 9, 30, 0, 0, time.UTC)
+	until := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+	line := prorationLine(pricing.Quote{
+		Plan:    database.PlanTypePro,
+		BaseFee: decimal.RequireFromString("49.50"),
+		From:    &from,
+		Until:   &until,
+	})
+
+	if line.Description != "Pro plan, prorated (Nov 16 to Nov 30)" || !line.Amount.Equal(decimal.RequireFromString("49.5")) {
+		t.Errorf("line = %+v", line)
+	}
+}
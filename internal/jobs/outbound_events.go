@@ -0,0 +1,63 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/events"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// outboundEventBatchSize is how many due events one run delivers
+const outboundEventBatchSize = 100
+
+// DeliverOutboundEvents sends due events to organizations' webhook endpoints.
+// Failures are retried with exponential backoff until events.MaxAttempts.
+func DeliverOutboundEvents(db *database.Queries, deliverer *events.Deliverer) error {
+	ctx := context.Background()
+
+	due, err := db.ListDueOutboundEvents(ctx, outboundEventBatchSize)
+	if err != nil {
+		return err
+	}
+
+	delivered := 0
+	for _, event := range due {
+		err := deliverer.Deliver(ctx, event.Url, event.Secret, events.Envelope{
+			ID:             event.ID,
+			Type:           event.EventType,
+			OrganizationID: event.OrganizationID,
+			CreatedAt:      event.CreatedAt.Time,
+			Data:           event.Payload,
+		})
+
+		attempt := database.RecordOutboundEventAttemptParams{
+			ID:            event.ID,
+			Status:        database.OutboundEventStatusDelivered,
+			NextAttemptAt: pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+		}
+		if err != nil {
+			lastError := err.Error()
+			attempt.LastError = &lastError
+			attempt.Status = database.OutboundEventStatusPending
+			attempt.NextAttemptAt.Time = attempt.NextAttemptAt.Time.Add(events.RetryDelay(int(event.Attempts)))
+			if int(event.Attempts)+1 >= events.MaxAttempts {
+				attempt.Status = database.OutboundEventStatusFailed
+			}
+			log.Printf("Failed to deliver event %s to org %s: %v", event.ID, event.OrganizationID, err)
+		} else {
+			delivered++
+		}
+
+		if err := db.RecordOutboundEventAttempt(ctx, attempt); err != nil {
+			log.Printf("Failed to record delivery of event %s: %v", event.ID, err)
+		}
+	}
+
+	if len(due) > 0 {
+		log.Printf("Delivered %d of %d outbound events", delivered, len(due))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/plan"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ApplyScheduledPlanChanges moves organizations onto the plans they
+// downgraded to once the period the downgrade was requested in is over. The
+// change takes effect at the time it was scheduled for, not when the job
+// gets to it, so the new period is billed on the new plan from its start.
+func ApplyScheduledPlanChanges(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+	db := database.New(pool)
+
+	due, err := db.ListDuePlanChanges(ctx, pgtype.Timestamp{Time: time.Now().UTC(), Valid: true})
+	if err != nil {
+		return fmt.Errorf("failed to list due plan changes: %w", err)
+	}
+
+	applied := 0
+	for _, change := range due {
+		if err := applyPlanChange(ctx, pool, change); err != nil {
+			log.Printf("Error applying plan change %s for org %s: %v", change.ID, change.OrganizationID, err)
+			continue
+		}
+		applied++
+	}
+
+	if applied > 0 {
+		log.Printf("Applied %d scheduled plan changes", applied)
+	}
+	return nil
+}
+
+func applyPlanChange(ctx context.Context, pool *pgxpool.Pool, change database.ScheduledPlanChange) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.New(pool).WithTx(tx)
+
+	// A change cancelled since it was listed is left alone
+	if _, err := qtx.ApplyPlanChange(ctx, change.ID); errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to mark plan change applied: %w", err)
+	}
+
+	org, err := qtx.GetOrganization(ctx, change.OrganizationID)
+	if err != nil {
+		return fmt.Errorf("failed to get organization: %w", err)
+	}
+	if _, err := qtx.GetCurrentPlanHistoryForUpdate(ctx, org.ID); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("failed to lock current plan: %w", err)
+	}
+
+	if org.Plan != change.ToPlan {
+		_, err = plan.Apply(ctx, qtx, org, plan.Change{
+			Plan:      change.ToPlan,
+			At:        change.EffectiveAt.Time,
+			ChangedBy: uuid.UUID(change.RequestedBy.Bytes),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit plan change: %w", err)
+	}
+
+	log.Printf("Moved org %s from %s to %s as scheduled", org.Name, change.FromPlan, change.ToPlan)
+	return nil
+}
@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/invoice"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/tax"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// CreateProrationInvoice invoices the charge for upgrading part way through a
+// period, worked out by plan.UpgradeCharge, to be paid at once. It is
+// converted and taxed like a period invoice; discounts and prepaid credit
+// are left for the period invoice. db must be bound to the transaction that
+// changes the plan, so an upgrade is never recorded without its invoice.
+func CreateProrationInvoice(ctx context.Context, db *database.Queries, rates *currency.Rates, taxes *tax.Table, seller invoice.Seller, org database.Organization, quote pricing.Quote, issuedAt time.Time) (database.BillingCycle, error) {
+	if quote.From == nil || quote.Until == nil {
+		return database.BillingCycle{}, fmt.Errorf("proration quote has no dates")
+	}
+
+	profileRow, err := db.GetOrganizationTaxProfile(ctx, org.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return database.BillingCycle{}, fmt.Errorf("failed to get tax profile: %w", err)
+	}
+
+	rate := billingRate(rates, quote.Currency, org, issuedAt)
+	lines := convertLines([]invoiceLine{prorationLine(quote)}, rate)
+	totalAmount := sumLines(lines)
+	fxRate, fxRateAt := fxColumns(rate)
+
+	if charge, ok := tax.Calculate(tax.ProfileFromRow(profileRow), taxes, seller.Country, totalAmount, issuedAt); ok {
+		lines = append(lines, taxLine(charge))
+		totalAmount = totalAmount.Add(charge.Amount)
+	}
+
+	breakdown, err := json.Marshal(quote)
+	if err != nil {
+		return database.BillingCycle{}, fmt.Errorf("failed to encode quote: %w", err)
+	}
+	totalAmountPg, err := decimalToPgNumeric(totalAmount)
+	if err != nil {
+		return database.BillingCycle{}, fmt.Errorf("failed to convert amount: %w", err)
+	}
+	invoiceNumber, err := nextInvoiceNumber(ctx, db, seller, issuedAt)
+	if err != nil {
+		return database.BillingCycle{}, err
+	}
+
+	cycle, err := db.CreateBillingCycle(ctx, database.CreateBillingCycleParams{
+		OrganizationID:   org.ID,
+		PeriodStart:      pgtype.Timestamp{Time: *quote.From, Valid: true},
+		PeriodEnd:        pgtype.Timestamp{Time: quote.Until.Add(-time.Second), Valid: true},
+		TotalAmount:      totalAmountPg,
+		Status:           database.BillingStatusPending,
+		PriceBookVersion: &quote.Version,
+		Quote:            breakdown,
+		InvoiceNumber:    invoiceNumber,
+		Currency:         rate.Quote,
+		FxRate:           fxRate,
+		FxRateAt:         fxRateAt,
+		Kind:             database.BillingCycleKindProration,
+	})
+	if err != nil {
+		return database.BillingCycle{}, fmt.Errorf("failed to create proration invoice: %w", err)
+	}
+
+	if err := createLineItems(ctx, db, cycle.ID, lines); err != nil {
+		return database.BillingCycle{}, err
+	}
+
+	log.Printf("Created proration invoice %s for org %s: %s %s for %s", cycle.InvoiceNumber, org.Name, totalAmount, cycle.Currency, quote.Plan)
+	return cycle, nil
+}
+
+// prorationLine itemizes the base fee charged for the rest of a period
+func prorationLine(quote pricing.Quote) invoiceLine {
+	return invoiceLine{
+		Type:        database.InvoiceLineItemTypeBaseFee,
+		Description: planName(quote.Plan) + " plan, prorated " + segmentDates(*quote.From, *quote.Until),
+		Quantity:    1,
+		UnitPrice:   quote.BaseFee,
+		Amount:      quote.BaseFee,
+	}
+}
@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ReportStripeUsage reports the hourly rollups of every organization Stripe
+// bills with a metered Price to its subscription. Each hour is reported as
+// a total that replaces the last one sent for it, so the hours the rollup
+// recomputes for late records are simply sent again.
+func ReportStripeUsage(pool *pgxpool.Pool, payments *payment.PaymentService) error {
+	ctx := context.Background()
+	db := database.New(pool)
+
+	rolledUpTo, err := db.GetUsageRolledUpTo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read rollup watermark: %w", err)
+	}
+
+	subs, err := db.ListMeteredStripeSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+
+	var reported int
+	for _, sub := range subs {
+		n, err := reportSubscriptionUsage(ctx, db, payments, sub, rolledUpTo.Time)
+		if err != nil {
+			log.Printf("ERROR: Failed to report usage of subscription %s: %v", sub.SubscriptionID, err)
+			continue
+		}
+		reported += n
+	}
+
+	log.Printf("Reported %d hours of usage to Stripe for %d subscriptions", reported, len(subs))
+	return nil
+}
+
+func reportSubscriptionUsage(ctx context.Context, db *database.Queries, payments *payment.PaymentService, sub database.StripeSubscription, rolledUpTo time.Time) (int, error) {
+	from, until := usageWindow(sub, rolledUpTo)
+	if !until.After(from) {
+		return 0, nil
+	}
+
+	rows, err := db.ListHourlyUnits(ctx, database.ListHourlyUnitsParams{
+		OrganizationID: sub.OrganizationID,
+		StartTime:      pgtype.Timestamp{Time: from, Valid: true},
+		EndTime:        pgtype.Timestamp{Time: until, Valid: true},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum usage: %w", err)
+	}
+
+	var reported int
+	for _, row := range rows {
+		at, ok := usageTimestamp(row.Bucket.Time, sub.CurrentPeriodStart.Time)
+		if !ok {
+			continue
+		}
+		if err := payments.Stripe.ReportUsage(sub.MeteredItemID.String, at, row.Units); err != nil {
+			return reported, err
+		}
+		reported++
+	}
+
+	if err := db.SetStripeUsageReported(ctx, database.SetStripeUsageReportedParams{
+		SubscriptionID:       sub.SubscriptionID,
+		UsageReportedThrough: pgtype.Timestamp{Time: until, Valid: true},
+	}); err != nil {
+		return reported, fmt.Errorf("failed to record reported usage: %w", err)
+	}
+	return reported, nil
+}
+
+// usageWindow returns the hours of a subscription's usage to report, from
+// the hour Stripe started billing it or the hours already reported that
+// may since have been rolled up again, up to the rollup watermark.
+// The window stops before the hour the current period ends in: that hour
+// belongs to the next period and is reported once it has started.
+func usageWindow(sub database.StripeSubscription, rolledUpTo time.Time) (time.Time, time.Time) {
+	start := sub.BillingStartedAt.Time.Truncate(time.Hour)
+	from := start
+	if sub.UsageReportedThrough.Valid {
+		from = sub.UsageReportedThrough.Time.Add(-rollupRecomputeWindow)
+		if from.Before(start) {
+			from = start
+		}
+	}
+	until := rolledUpTo
+	if sub.CurrentPeriodEnd.Valid {
+		if end := sub.CurrentPeriodEnd.Time.Truncate(time.Hour); end.Before(until) {
+			until = end
+		}
+	}
+	return from, until
+}
+
+// usageTimestamp places an hour of usage in the subscription's current
+// period. Stripe only takes usage for the current period, so an hour wholly
+// before it was reported with the period before; the hour the period
+// started in was held back from that period and is reported at its start.
+func usageTimestamp(bucket, periodStart time.Time) (time.Time, bool) {
+	if !bucket.Add(time.Hour).After(periodStart) {
+		return time.Time{}, false
+	}
+	if bucket.Before(periodStart) {
+		return periodStart, true
+	}
+	return bucket, true
+}
@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestUsageWindow(t *testing.T) {
+	started := time.Date(2026, 11, 3, 9, 40, 0, 0, time.UTC)
+	rolledUpTo := time.Date(2026, 11, 3, 14, 0, 0, 0, time.UTC)
+	sub := database.StripeSubscription{BillingStartedAt: pgtype.Timestamp{Time: started, Valid: true}}
+
+	// The hour billing started in is billed by Stripe, as it is left off
+	// our invoices
+	from, until := usageWindow(sub, rolledUpTo)
+	if want := time.Date(2026, 11, 3, 9, 0, 0, 0, time.UTC); !from.Equal(want) || !until.Equal(rolledUpTo) {
+		t.Errorf("first window = %s to %s, want %s to %s", from, until, want, rolledUpTo)
+	}
+
+	// The hours last reported may have been rolled up again since
+	sub.BillingStartedAt = pgtype.Timestamp{Time: started.AddDate(0, 0, -1), Valid: true}
+	sub.UsageReportedThrough = pgtype.Timestamp{Time: rolledUpTo, Valid: true}
+	if from, _ := usageWindow(sub, rolledUpTo.Add(time.Hour)); !from.Equal(rolledUpTo.Add(-rollupRecomputeWindow)) {
+		t.Errorf("next window starts at %s, want %s before the last report", from, rollupRecomputeWindow)
+	}
+
+	sub.BillingStartedAt = pgtype.Timestamp{Time: started, Valid: true}
+	sub.UsageReportedThrough = pgtype.Timestamp{Time: time.Date(2026, 11, 3, 10, 0, 0, 0, time.UTC), Valid: true}
+	if from, _ := usageWindow(sub, rolledUpTo); !from.Equal(time.Date(2026, 11, 3, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("window starts at %s, want no earlier than the hour billing started", from)
+	}
+
+	// The hour the period ends in is left for the next period
+	sub.CurrentPeriodEnd = pgtype.Timestamp{Time: time.Date(2026, 11, 3, 12, 40, 0, 0, time.UTC), Valid: true}
+	if _, until := usageWindow(sub, rolledUpTo); !until.Equal(time.Date(2026, 11, 3, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("window ends at %s, want the start of the hour the period ends in", until)
+	}
+}
+
+func TestUsageReportedOnce(t *testing.T) {
+	boundary := time.Date(2026, 12, 3, 9, 40, 0, 0, time.UTC)
+	sub := database.StripeSubscription{
+		BillingStartedAt:     pgtype.Timestamp{Time: time.Date(2026, 11, 3, 9, 40, 0, 0, time.UTC), Valid: true},
+		CurrentPeriodStart:   pgtype.Timestamp{Time: time.Date(2026, 11, 3, 9, 40, 0, 0, time.UTC), Valid: true},
+		CurrentPeriodEnd:     pgtype.Timestamp{Time: boundary, Valid: true},
+		UsageReportedThrough: pgtype.Timestamp{Time: time.Date(2026, 12, 3, 8, 0, 0, 0, time.UTC), Valid: true},
+	}
+
+	// Every hour the two periods report, keyed by bucket
+	reported := map[time.Time]int{}
+	report := func(rolledUpTo time.Time) {
+		from, until := usageWindow(sub, rolledUpTo)
+		for bucket := from; bucket.Before(until); bucket = bucket.Add(time.Hour) {
+			if _, ok := usageTimestamp(bucket, sub.CurrentPeriodStart.Time); ok {
+				reported[bucket]++
+			}
+		}
+		if until.After(from) {
+			sub.UsageReportedThrough = pgtype.Timestamp{Time: until, Valid: true}
+		}
+	}
+
+	// The hour the periods meet in is rolled up before the period rolls over
+	report(time.Date(2026, 12, 3, 10, 0, 0, 0, time.UTC))
+	sub.CurrentPeriodStart = pgtype.Timestamp{Time: boundary, Valid: true}
+	sub.CurrentPeriodEnd = pgtype.Timestamp{Time: boundary.AddDate(0, 1, 0), Valid: true}
+	report(time.Date(2026, 12, 3, 11, 0, 0, 0, time.UTC))
+
+	straddling := time.Date(2026, 12, 3, 9, 0, 0, 0, time.UTC)
+	if reported[straddling] != 1 {
+		t.Errorf("hour the periods meet in was reported %d times, want once", reported[straddling])
+	}
+}
+
+func TestUsageTimestamp(t *testing.T) {
+	periodStart := time.Date(2026, 12, 3, 9, 40, 0, 0, time.UTC)
+
+	tests := []struct {
+		bucket time.Time
+		want   time.Time
+		ok     bool
+	}{
+		{time.Date(2026, 12, 3, 8, 0, 0, 0, time.UTC), time.Time{}, false},
+		// Held back from the period before, so reported once, at the start
+		{time.Date(2026, 12, 3, 9, 0, 0, 0, time.UTC), periodStart, true},
+		{time.Date(2026, 12, 3, 10, 0, 0, 0, time.UTC), time.Date(2026, 12, 3, 10, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		got, ok := usageTimestamp(tt.bucket, periodStart)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("usageTimestamp(%s) = %s, %v, want %s, %v", tt.bucket, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/email"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/invoice"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/plan"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/tax"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TrialReminderWindow is how long before a trial ends its organization is
+// reminded
+const TrialReminderWindow = 3 * 24 * time.Hour
+
+// ProcessTrials reminds organizations whose trial is about to end and ends
+// the trials that are over. An organization with a saved payment method is
+// converted to the plan it tried: the base fee for the rest of the period is
+// invoiced and charged to the method straight away. Organizations without
+// one, or whose charge fails, go back to free. Either way the change dates
+// from the trial's end, not from when the job gets to it.
+func ProcessTrials(pool *pgxpool.Pool, catalog *pricing.Catalog, rates *currency.Rates, payments *payment.PaymentService, emailService *email.EmailService, appURL string, seller invoice.Seller) error {
+	ctx := context.Background()
+	db := database.New(pool)
+	now := time.Now().UTC()
+
+	reminding, err := db.ListTrialsToRemind(ctx, pgtype.Timestamp{Time: now.Add(TrialReminderWindow), Valid: true})
+	if err != nil {
+		return fmt.Errorf("failed to list trials to remind: %w", err)
+	}
+	for _, org := range reminding {
+		// Trials ending now are about to be ended instead
+		if !org.TrialEndsAt.Time.After(now) {
+			continue
+		}
+		if err := db.MarkTrialReminded(ctx, org.ID); err != nil {
+			log.Printf("Error marking trial reminder for org %s: %v", org.ID, err)
+			continue
+		}
+		if emailService != nil {
+			remindTrial(ctx, db, emailService, appURL, org, now)
+		}
+	}
+
+	ended, err := db.ListEndedTrials(ctx, pgtype.Timestamp{Time: now, Valid: true})
+	if err != nil {
+		return fmt.Errorf("failed to list ended trials: %w", err)
+	}
+	if len(ended) == 0 {
+		return nil
+	}
+
+	taxes, err := tax.Load(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	converted, expired := 0, 0
+	for _, org := range ended {
+		notice, err := endTrial(ctx, pool, catalog, rates, taxes, payments, seller, org, now)
+		if err != nil {
+			log.Printf("Error ending trial for org %s: %v", org.ID, err)
+			continue
+		}
+		if notice == nil {
+			continue
+		}
+		if notice.Converted {
+			converted++
+		} else {
+			expired++
+		}
+		if emailService != nil {
+			notice.BillingURL = appURL + "/billing"
+			notifyTrialEnded(ctx, db, emailService, org.ID, *notice)
+		}
+	}
+
+	log.Printf("Ended %d trials: %d converted, %d back on free", converted+expired, converted, expired)
+	return nil
+}
+
+// endTrial converts the organization if it can and returns it to free if
+// not. It returns the email data for the outcome, or nil if the trial had
+// already been ended.
+func endTrial(ctx context.Context, pool *pgxpool.Pool, catalog *pricing.Catalog, rates *currency.Rates, taxes *tax.Table, payments *payment.PaymentService, seller invoice.Seller, org database.Organization, now time.Time) (*email.TrialEndedData, error) {
+	trialPlan := org.TrialPlan.PlanType
+	notice := &email.TrialEndedData{
+		OrganizationName: org.Name,
+		Plan:             planName(trialPlan),
+	}
+
+	method, err := database.New(pool).GetPaymentMethod(ctx, org.ID)
+	if err == nil {
+		cycle, done, err := convertTrial(ctx, pool, catalog, rates, taxes, payments, seller, org, method, now)
+		if err == nil {
+			if !done {
+				return nil, nil
+			}
+			notice.Converted = true
+			if cycle != nil {
+				notice.Amount = currency.Format(currency.FromNumeric(cycle.TotalAmount), cycle.Currency)
+			}
+			log.Printf("Converted trial of org %s to %s", org.Name, trialPlan)
+			return notice, nil
+		}
+		if errors.Is(err, payment.ErrChargeConflict) {
+			// The customer may have paid already; leave it to an operator
+			return nil, err
+		}
+		log.Printf("Could not convert trial of org %s, returning it to free: %v", org.Name, err)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get payment method: %w", err)
+	}
+
+	done, err := expireTrial(ctx, pool, org)
+	if err != nil || !done {
+		return nil, err
+	}
+	log.Printf("Trial of org %s ended without a payment, back on free", org.Name)
+	return notice, nil
+}
+
+// convertTrial moves the organization onto the plan it tried, invoicing and
+// charging the base fee for the rest of the period. The charge is made
+// before the transaction commits, so a failed charge leaves the trial
+// active for expireTrial. It carries a key made from the organization and
+// the trial's end, so if the commit fails the next run finds the charge
+// already made rather than charging again. done is false if the trial had
+// already been ended; cycle is nil if nothing was left to charge.
+func convertTrial(ctx context.Context, pool *pgxpool.Pool, catalog *pricing.Catalog, rates *currency.Rates, taxes *tax.Table, payments *payment.PaymentService, seller invoice.Seller, org database.Organization, method database.PaymentMethod, now time.Time) (cycle *database.BillingCycle, done bool, err error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.New(pool).WithTx(tx)
+	org, err = qtx.EndTrial(ctx, database.EndTrialParams{
+		ID:          org.ID,
+		TrialStatus: database.NullTrialStatus{TrialStatus: database.TrialStatusConverted, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to end trial: %w", err)
+	}
+	if _, err := qtx.GetCurrentPlanHistoryForUpdate(ctx, org.ID); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, fmt.Errorf("failed to lock current plan: %w", err)
+	}
+
+	endsAt := org.TrialEndsAt.Time
+	periodStart, _ := quota.CurrentPeriod(endsAt)
+	periodEnd := periodStart.AddDate(0, 1, 0)
+
+	current, err := plan.Current(ctx, qtx, org, periodStart)
+	if err != nil {
+		return nil, false, err
+	}
+
+	change := plan.Change{
+		Plan:         org.TrialPlan.PlanType,
+		At:           endsAt,
+		PrepaidUntil: periodEnd,
+	}
+	charge := plan.UpgradeCharge(catalog, current, change.Plan, endsAt, periodStart, periodEnd)
+	if charge.Total.IsPositive() {
+		invoiced, err := CreateProrationInvoice(ctx, qtx, rates, taxes, seller, org, charge, now)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := chargeTrial(payments, method, org, invoiced); err != nil {
+			return nil, false, err
+		}
+		invoiced, err = qtx.UpdateBillingCycleStatus(ctx, database.UpdateBillingCycleStatusParams{
+			Status: database.BillingStatusPaid,
+			ID:     invoiced.ID,
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to mark invoice paid: %w", err)
+		}
+		change.ProrationCycleID = invoiced.ID
+		cycle = &invoiced
+	}
+
+	if _, err := plan.Apply(ctx, qtx, org, change); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		if cycle != nil {
+			log.Printf("Charged %s %s for the trial of org %s but could not record it; the next run will find the charge: %v",
+				currency.FromNumeric(cycle.TotalAmount), cycle.Currency, org.ID, err)
+		}
+		return nil, false, fmt.Errorf("failed to commit trial conversion: %w", err)
+	}
+	return cycle, true, nil
+}
+
+// expireTrial returns the organization to free. done is false if the trial
+// had already been ended.
+func expireTrial(ctx context.Context, pool *pgxpool.Pool, org database.Organization) (done bool, err error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.New(pool).WithTx(tx)
+	org, err = qtx.EndTrial(ctx, database.EndTrialParams{
+		ID:          org.ID,
+		TrialStatus: database.NullTrialStatus{TrialStatus: database.TrialStatusExpired, Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to end trial: %w", err)
+	}
+	if _, err := qtx.GetCurrentPlanHistoryForUpdate(ctx, org.ID); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return false, fmt.Errorf("failed to lock current plan: %w", err)
+	}
+
+	if _, err := plan.Apply(ctx, qtx, org, plan.Change{
+		Plan: database.PlanTypeFree,
+		At:   org.TrialEndsAt.Time,
+	}); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit trial expiry: %w", err)
+	}
+	return true, nil
+}
+
+// chargeTrial charges the invoice converting a trial to the organization's
+// saved payment method. The charge is keyed by the organization and the
+// trial's end, not the invoice, which is created anew each time a
+// conversion is retried; for the same reason its metadata only names the
+// trial.
+func chargeTrial(payments *payment.PaymentService, method database.PaymentMethod, org database.Organization, cycle database.BillingCycle) error {
+	return ChargePaymentMethod(payments, method, cycle, trialChargeKey(org), map[string]string{
+		"organization_id": org.ID.String(),
+		"trial_ends_at":   org.TrialEndsAt.Time.Format(time.RFC3339),
+	})
+}
+
+// ChargePaymentMethod charges an invoice's amount to a saved payment method
+// with the customer absent. key identifies the charge to the provider, as
+// Stripe's idempotency key and as the Paystack reference, so a retried
+// charge finds the first one rather than charging again.
+func ChargePaymentMethod(payments *payment.PaymentService, method database.PaymentMethod, cycle database.BillingCycle, key string, metadata map[string]string) error {
+	amountMinor, err := currency.ToMinorUnits(currency.FromNumeric(cycle.TotalAmount), cycle.Currency)
+	if err != nil {
+		return err
+	}
+
+	switch method.Provider {
+	case "stripe":
+		_, err = payments.Stripe.ChargeSaved(payment.ChargeSavedParams{
+			CustomerID:      method.CustomerID.String,
+			PaymentMethodID: method.Reference,
+			Amount:          amountMinor,
+			Currency:        strings.ToLower(cycle.Currency),
+			Metadata:        metadata,
+			IdempotencyKey:  key,
+		})
+		return err
+	case "paystack":
+		if !payments.Paystack.SupportsCurrency(cycle.Currency) {
+			return fmt.Errorf("paystack cannot charge in %s", cycle.Currency)
+		}
+		_, err = payments.Paystack.ChargeAuthorization(payment.PaystackChargeAuthorizationParams{
+			Email:             method.Email,
+			AuthorizationCode: method.Reference,
+			Amount:            amountMinor,
+			Currency:          cycle.Currency,
+			Reference:         key,
+			Metadata:          metadata,
+		})
+		if err == nil {
+			return nil
+		}
+		// Paystack refuses a reference it has seen, so a retry looks up
+		// the earlier charge instead
+		earlier, verifyErr := payments.Paystack.VerifyTransaction(key)
+		if verifyErr != nil || earlier.Data.Status != "success" {
+			return err
+		}
+		if earlier.Data.Amount != amountMinor {
+			return fmt.Errorf("charge %s was for %d, not %d: %w", key, earlier.Data.Amount, amountMinor, payment.ErrChargeConflict)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown payment provider %q", method.Provider)
+	}
+}
+
+// trialChargeKey identifies the one charge converting a trial
+func trialChargeKey(org database.Organization) string {
+	return fmt.Sprintf("trial-%s-%d", org.ID, org.TrialEndsAt.Time.Unix())
+}
+
+func remindTrial(ctx context.Context, db *database.Queries, emailService *email.EmailService, appURL string, org database.Organization, now time.Time) {
+	_, err := db.GetPaymentMethod(ctx, org.ID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Failed to get payment method for trial reminder to org %s: %v", org.ID, err)
+		return
+	}
+
+	endsAt := org.TrialEndsAt.Time
+	data := email.TrialReminderData{
+		OrganizationName: org.Name,
+		Plan:             planName(org.TrialPlan.PlanType),
+		EndsAt:           endsAt.Format("January 2, 2006"),
+		DaysLeft:         int(math.Ceil(endsAt.Sub(now).Hours() / 24)),
+		HasPaymentMethod: err == nil,
+		BillingURL:       appURL + "/billing",
+	}
+
+	recipients, err := db.ListOrganizationAdminEmails(ctx, org.ID)
+	if err != nil {
+		log.Printf("Failed to list admins for trial reminder to org %s: %v", org.ID, err)
+		return
+	}
+	for _, to := range recipients {
+		if err := emailService.SendTrialReminder(to, data); err != nil {
+			log.Printf("Failed to send trial reminder to %s: %v", to, err)
+		}
+	}
+}
+
+func notifyTrialEnded(ctx context.Context, db *database.Queries, emailService *email.EmailService, orgID uuid.UUID, data email.TrialEndedData) {
+	recipients, err := db.ListOrganizationAdminEmails(ctx, orgID)
+	if err != nil {
+		log.Printf("Failed to list admins for trial notice to org %s: %v", orgID, err)
+		return
+	}
+	for _, to := range recipients {
+		if err := emailService.SendTrialEnded(to, data); err != nil {
+			log.Printf("Failed to send trial notice to %s: %v", to, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/email"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// anomalyBaselineHours is the rolling window each hour is compared against
+	anomalyBaselineHours = 7 * 24
+	// anomalyMinActiveHours is how many baseline hours need traffic before an
+	// organization is judged at all, so new accounts don't alert on day one
+	anomalyMinActiveHours = 24
+	// anomalyThreshold is the deviation, in standard deviations, that raises an alert
+	anomalyThreshold = 4.0
+	// anomalyMinVolume ignores swings of fewer requests than this
+	anomalyMinVolume = 100
+	// anomalyMinErrorRequests is the traffic an hour needs before its error rate counts
+	anomalyMinErrorRequests = 50
+	// anomalyMinErrorIncrease is the smallest error rate rise, in percentage points, worth an alert
+	anomalyMinErrorIncrease = 10.0
+)
+
+// hourlyTotals is one hour of an organization's traffic
+type hourlyTotals struct {
+	requests int64
+	errors   int64
+}
+
+// usageAnomaly is a deviation found by detectAnomalies
+type usageAnomaly struct {
+	metric    database.UsageAlertMetric
+	direction database.UsageAlertDirection
+	observed  float64
+	expected  float64
+	score     float64
+}
+
+// DetectUsageAnomalies compares the last complete hour of every organization's
+// request volume and error rate against its previous seven days. New alerts
+// are stored and emailed to the organization's owners and admins.
+func DetectUsageAnomalies(db *database.Queries, emailService *email.EmailService, appURL string) error {
+	ctx := context.Background()
+
+	windowEnd := time.Now().UTC().Truncate(time.Hour)
+	windowStart := windowEnd.Add(-time.Hour)
+	baselineStart := windowStart.Add(-anomalyBaselineHours * time.Hour)
+
+	rows, err := db.GetHourlyUsageTotals(ctx, database.GetHourlyUsageTotalsParams{
+		StartTime: pgtype.Timestamp{Time: baselineStart, Valid: true},
+		EndTime:   pgtype.Timestamp{Time: windowEnd, Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read hourly usage: %w", err)
+	}
+
+	// Hours without traffic stay zero, which is what the baseline should see
+	series := make(map[uuid.UUID][]hourlyTotals)
+	for _, row := range rows {
+		hours, ok := series[row.OrganizationID]
+		if !ok {
+			hours = make([]hourlyTotals, anomalyBaselineHours+1)
+			series[row.OrganizationID] = hours
+		}
+		index := int(row.Bucket.Time.Sub(baselineStart) / time.Hour)
+		if index < 0 || index >= len(hours) {
+			continue
+		}
+		hours[index] = hourlyTotals{requests: row.RequestCount, errors: row.ErrorCount}
+	}
+
+	raised := 0
+	for orgID, hours := range series {
+		for _, anomaly := range detectAnomalies(hours[:anomalyBaselineHours], hours[anomalyBaselineHours]) {
+			alert, err := db.CreateUsageAlert(ctx, database.CreateUsageAlertParams{
+				OrganizationID: orgID,
+				Metric:         anomaly.metric,
+				Direction:      anomaly.direction,
+				WindowStart:    pgtype.Timestamp{Time: windowStart, Valid: true},
+				WindowEnd:      pgtype.Timestamp{Time: windowEnd, Valid: true},
+				Observed:       anomaly.observed,
+				Expected:       anomaly.expected,
+				Score:          anomaly.score,
+			})
+			if errors.Is(err, pgx.ErrNoRows) {
+				// Raised by an earlier run for the same hour
+				continue
+			}
+			if err != nil {
+				log.Printf("Error storing usage alert for org %s: %v", orgID, err)
+				continue
+			}
+			raised++
+
+			if emailService != nil {
+				notifyUsageAlert(ctx, db, emailService, alert, appURL)
+			}
+		}
+	}
+
+	log.Printf("Checked %d organizations for usage anomalies, raised %d alerts", len(series), raised)
+	return nil
+}
+
+// detectAnomalies flags the current hour when its volume or error rate is far
+// outside the baseline hours. The spread is floored at the Poisson (volume) or
+// binomial (error rate) noise, so quiet and perfectly steady orgs don't alert
+// on tiny absolute changes.
+func detectAnomalies(baseline []hourlyTotals, current hourlyTotals) []usageAnomaly {
+	var totalRequests, totalErrors int64
+	activeHours := 0
+	for _, hour := range baseline {
+		totalRequests += hour.requests
+		totalErrors += hour.errors
+		if hour.requests > 0 {
+			activeHours++
+		}
+	}
+	if activeHours < anomalyMinActiveHours {
+		return nil
+	}
+
+	anomalies := make([]usageAnomaly, 0)
+
+	mean := float64(totalRequests) / float64(len(baseline))
+	variance := 0.0
+	for _, hour := range baseline {
+		diff := float64(hour.requests) - mean
+		variance += diff * diff
+	}
+	sigma := math.Max(math.Sqrt(variance/float64(len(baseline))), math.Max(math.Sqrt(mean), 1))
+
+	observed := float64(current.requests)
+	score := (observed - mean) / sigma
+
+	switch {
+	case score >= anomalyThreshold && observed-mean >= anomalyMinVolume && observed >= 2*mean:
+		anomalies = append(anomalies, usageAnomaly{
+			metric:    database.UsageAlertMetricRequestVolume,
+			direction: database.UsageAlertDirectionSpike,
+			observed:  observed,
+			expected:  mean,
+			score:     score,
+		})
+	case score <= -anomalyThreshold && mean-observed >= anomalyMinVolume && observed <= mean/2:
+		anomalies = append(anomalies, usageAnomaly{
+			metric:    database.UsageAlertMetricRequestVolume,
+			direction: database.UsageAlertDirectionDrop,
+			observed:  observed,
+			expected:  mean,
+			score:     score,
+		})
+	}
+
+	if current.requests >= anomalyMinErrorRequests && totalRequests > 0 {
+		baselineRate := float64(totalErrors) / float64(totalRequests)
+		currentRate := float64(current.errors) / float64(current.requests)
+
+		p := math.Max(baselineRate, 0.01)
+		errorSigma := math.Sqrt(p * (1 - p) / float64(current.requests))
+		errorScore := (currentRate - baselineRate) / errorSigma
+
+		if errorScore >= anomalyThreshold &&
+			(currentRate-baselineRate)*100 >= anomalyMinErrorIncrease &&
+			currentRate >= 2*baselineRate {
+			anomalies = append(anomalies, usageAnomaly{
+				metric:    database.UsageAlertMetricErrorRate,
+				direction: database.UsageAlertDirectionSpike,
+				observed:  currentRate * 100,
+				expected:  baselineRate * 100,
+				score:     errorScore,
+			})
+		}
+	}
+
+	return anomalies
+}
+
+func notifyUsageAlert(ctx context.Context, db *database.Queries, emailService *email.EmailService, alert database.UsageAlert, appURL string) {
+	org, err := db.GetOrganization(ctx, alert.OrganizationID)
+	if err != nil {
+		log.Printf("Failed to get organization for usage alert %s: %v", alert.ID, err)
+		return
+	}
+
+	recipients, err := db.ListOrganizationAdminEmails(ctx, alert.OrganizationID)
+	if err != nil {
+		log.Printf("Failed to list admins for usage alert %s: %v", alert.ID, err)
+		return
+	}
+
+	data := email.UsageAnomalyData{
+		OrganizationName: org.Name,
+		Metric:           "Request volume",
+		Direction:        string(alert.Direction),
+		Observed:         fmt.Sprintf("%.0f requests", alert.Observed),
+		Expected:         fmt.Sprintf("%.0f requests", alert.Expected),
+		WindowStart:      alert.WindowStart.Time.Format("Jan 2, 2006 15:04 MST"),
+		WindowEnd:        alert.WindowEnd.Time.Format("15:04 MST"),
+		AlertsURL:        appURL + "/alerts",
+	}
+	if alert.Metric == database.UsageAlertMetricErrorRate {
+		data.Metric = "Error rate"
+		data.Observed = fmt.Sprintf("%.1f%%", alert.Observed)
+		data.Expected = fmt.Sprintf("%.1f%%", alert.Expected)
+	}
+
+	for _, to := range recipients {
+		if err := emailService.SendUsageAnomaly(to, data); err != nil {
+			log.Printf("Failed to send usage alert %s to %s: %v", alert.ID, to, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package jobs
+
+import (
+	"testing"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+)
+
+// steadyBaseline returns a week of hours that alternate between low and high
+// traffic, with the given error count in every hour
+func steadyBaseline(low, high, errors int64) []hourlyTotals {
+	hours := make([]hourlyTotals, anomalyBaselineHours)
+	for i := range hours {
+		requests := low
+		if i%2 == 0 {
+			requests = high
+		}
+		hours[i] = hourlyTotals{requests: requests, errors: errors}
+	}
+	return hours
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	tests := []struct {
+		name          string
+		baseline      []hourlyTotals
+		current       hourlyTotals
+		wantMetric    database.UsageAlertMetric
+		wantDirection database.UsageAlertDirection
+		wantNone      bool
+	}{
+		{
+			name:     "Normal hour",
+			baseline: steadyBaseline(900, 1100, 10),
+			current:  hourlyTotals{requests: 1050, errors: 12},
+			wantNone: true,
+		},
+		{
+			name:          "Volume spike",
+			baseline:      steadyBaseline(900, 1100, 10),
+			current:       hourlyTotals{requests: 6000, errors: 60},
+			wantMetric:    database.UsageAlertMetricRequestVolume,
+			wantDirection: database.UsageAlertDirectionSpike,
+		},
+		{
+			name:          "Volume drop",
+			baseline:      steadyBaseline(900, 1100, 10),
+			current:       hourlyTotals{requests: 50},
+			wantMetric:    database.UsageAlertMetricRequestVolume,
+			wantDirection: database.UsageAlertDirectionDrop,
+		},
+		{
+			name:          "Error rate spike",
+			baseline:      steadyBaseline(900, 1100, 10),
+			current:       hourlyTotals{requests: 1000, errors: 400},
+			wantMetric:    database.UsageAlertMetricErrorRate,
+			wantDirection: database.UsageAlertDirectionSpike,
+		},
+		{
+			name:     "Small absolute spike on a quiet org",
+			baseline: steadyBaseline(1, 3, 0),
+			current:  hourlyTotals{requests: 40},
+			wantNone: true,
+		},
+		{
+			name:     "Not enough history",
+			baseline: make([]hourlyTotals, anomalyBaselineHours),
+			current:  hourlyTotals{requests: 5000},
+			wantNone: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectAnomalies(tt.baseline, tt.current)
+			if tt.wantNone {
+				if len(got) != 0 {
+					t.Errorf("detectAnomalies() = %+v, want none", got)
+				}
+				return
+			}
+			if len(got) != 1 {
+				t.Fatalf("detectAnomalies() returned %d anomalies, want 1: %+v", len(got), got)
+			}
+			if got[0].metric != tt.wantMetric || got[0].direction != tt.wantDirection {
+				t.Errorf("detectAnomalies() = %s %s, want %s %s",
+					got[0].metric, got[0].direction, tt.wantMetric, tt.wantDirection)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/currency"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/email"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/events"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/plan"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/quota"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// budgetCurrency is the currency spend budgets are set in
+const budgetCurrency = "USD"
+
+// CheckUsageBudgets compares each organization's current-period usage with
+// its budget. Every threshold crossed is recorded once per period together
+// with its outbound event, and owners and admins are emailed about the
+// highest new one. Crossing 100% suspends non-essential API keys when the
+// budget asks for it; they are restored when the next period starts. Spend
+// budgets are measured against the period quoted as its invoice will be,
+// converted to budgetCurrency.
+func CheckUsageBudgets(pool *pgxpool.Pool, emailService *email.EmailService, appURL string, catalog *pricing.Catalog, rates *currency.Rates) error {
+	ctx := context.Background()
+	db := database.New(pool)
+	now := time.Now().UTC()
+
+	periodStart, periodEnd := quota.CurrentPeriod(now)
+	periodStartPg := pgtype.Timestamp{Time: periodStart, Valid: true}
+
+	resumed, err := db.ResumeAPIKeysSuspendedBefore(ctx, periodStartPg)
+	if err != nil {
+		return fmt.Errorf("failed to restore suspended API keys: %w", err)
+	}
+	if resumed > 0 {
+		log.Printf("Restored %d API keys suspended by last period's budgets", resumed)
+	}
+
+	budgets, err := db.ListUsageBudgets(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list usage budgets: %w", err)
+	}
+
+	notified := 0
+	for _, budget := range budgets {
+		value, err := budgetUsage(ctx, db, catalog, rates, budget, periodStart, periodEnd, now)
+		if err != nil {
+			log.Printf("Error measuring usage for org %s: %v", budget.OrganizationID, err)
+			continue
+		}
+		var spendCurrency string
+		if budget.Metric == database.BudgetMetricSpend {
+			spendCurrency = budgetCurrency
+		}
+		amount := currency.FromNumeric(budget.Amount)
+
+		crossed := crossedThresholds(value, amount, budget.Thresholds)
+		if len(crossed) == 0 {
+			continue
+		}
+
+		notice, err := recordBudgetThresholds(ctx, pool, budget, crossed, value, amount, spendCurrency, periodStart, periodEnd)
+		if err != nil {
+			log.Printf("Error recording budget thresholds for org %s: %v", budget.OrganizationID, err)
+			continue
+		}
+		if notice == nil {
+			continue
+		}
+		notified++
+
+		if emailService != nil {
+			notice.BillingURL = appURL + "/billing"
+			notifyBudgetThreshold(ctx, db, emailService, budget, *notice)
+		}
+	}
+
+	log.Printf("Checked %d usage budgets, %d crossed a new threshold", len(budgets), notified)
+	return nil
+}
+
+// budgetUsage measures the period so far against a budget: the requests
+// made, or the spend priced per plan like the period's invoice and
+// converted to budgetCurrency at the current rate
+func budgetUsage(ctx context.Context, db *database.Queries, catalog *pricing.Catalog, rates *currency.Rates, budget database.ListUsageBudgetsRow, periodStart, periodEnd, now time.Time) (decimal.Decimal, error) {
+	if budget.Metric != database.BudgetMetricSpend {
+		used, err := db.CountOrganizationUsage(ctx, database.CountOrganizationUsageParams{
+			OrganizationID: budget.OrganizationID,
+			StartTime:      pgtype.Timestamp{Time: periodStart, Valid: true},
+			EndTime:        pgtype.Timestamp{Time: periodEnd, Valid: true},
+		})
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("failed to count usage: %w", err)
+		}
+		return decimal.NewFromInt(used), nil
+	}
+
+	quote, _, _, err := plan.Quote(ctx, db, catalog, budget.OrganizationID, budget.OrgPlan, periodStart, periodEnd)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	rate, ok := rates.RateAt(quote.Currency, budgetCurrency, now)
+	if !ok {
+		return decimal.Zero, fmt.Errorf("no %s/%s exchange rate", quote.Currency, budgetCurrency)
+	}
+	return rate.Convert(quote.Total), nil
+}
+
+// crossedThresholds returns the thresholds, in ascending order, that value
+// has reached as a percentage of amount
+func crossedThresholds(value, amount decimal.Decimal, thresholds []int32) []int32 {
+	if !amount.IsPositive() {
+		return nil
+	}
+
+	percent := value.Div(amount).Mul(decimal.NewFromInt(100))
+	crossed := make([]int32, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		if percent.GreaterThanOrEqual(decimal.NewFromInt32(threshold)) {
+			crossed = append(crossed, threshold)
+		}
+	}
+	slices.Sort(crossed)
+	return crossed
+}
+
+// recordBudgetThresholds stores the crossed thresholds not yet seen this
+// period and queues their events in one transaction. It returns the email
+// data for the highest new threshold, or nil when nothing was new.
+func recordBudgetThresholds(ctx context.Context, pool *pgxpool.Pool, budget database.ListUsageBudgetsRow, crossed []int32, value, amount decimal.Decimal, spendCurrency string, periodStart, periodEnd time.Time) (*email.BudgetThresholdData, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	db := database.New(pool).WithTx(tx)
+
+	usagePg, err := decimalToPgNumeric(value.Round(2))
+	if err != nil {
+		return nil, err
+	}
+
+	newThresholds := make([]int32, 0, len(crossed))
+	for _, threshold := range crossed {
+		inserted, err := db.CreateUsageBudgetNotification(ctx, database.CreateUsageBudgetNotificationParams{
+			OrganizationID: budget.OrganizationID,
+			PeriodStart:    pgtype.Timestamp{Time: periodStart, Valid: true},
+			Threshold:      threshold,
+			Usage:          usagePg,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to record threshold %d: %w", threshold, err)
+		}
+		if inserted > 0 {
+			newThresholds = append(newThresholds, threshold)
+		}
+	}
+	if len(newThresholds) == 0 {
+		return nil, nil
+	}
+
+	var suspended int64
+	if budget.DisableKeysAtLimit && newThresholds[len(newThresholds)-1] >= 100 {
+		suspended, err = db.SuspendNonEssentialAPIKeys(ctx, budget.OrganizationID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to suspend API keys: %w", err)
+		}
+	}
+
+	for _, threshold := range newThresholds {
+		_, err := events.Publish(ctx, db, budget.OrganizationID, events.TypeBudgetThresholdCrossed, map[string]interface{}{
+			"metric":         budget.Metric,
+			"threshold":      threshold,
+			"budget":         amount.StringFixed(2),
+			"usage":          value.StringFixed(2),
+			"period_start":   periodStart,
+			"period_end":     periodEnd,
+			"keys_suspended": suspended,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit budget thresholds: %w", err)
+	}
+
+	notice := &email.BudgetThresholdData{
+		OrganizationName: budget.OrgName,
+		Threshold:        int(newThresholds[len(newThresholds)-1]),
+		Used:             value.StringFixed(0) + " requests",
+		Budget:           amount.StringFixed(0) + " requests",
+		PeriodEnd:        periodEnd.Format("January 2, 2006"),
+		KeysSuspended:    suspended,
+	}
+	if budget.Metric == database.BudgetMetricSpend {
+		notice.Used = currency.Format(value, spendCurrency)
+		notice.Budget = currency.Format(amount, spendCurrency)
+	}
+	return notice, nil
+}
+
+func notifyBudgetThreshold(ctx context.Context, db *database.Queries, emailService *email.EmailService, budget database.ListUsageBudgetsRow, data email.BudgetThresholdData) {
+	recipients, err := db.ListOrganizationAdminEmails(ctx, budget.OrganizationID)
+	if err != nil {
+		log.Printf("Failed to list admins for budget notice to org %s: %v", budget.OrganizationID, err)
+		return
+	}
+
+	for _, to := range recipients {
+		if err := emailService.SendBudgetThreshold(to, data); err != nil {
+			log.Printf("Failed to send budget notice to %s: %v", to, err)
+		}
+	}
+}
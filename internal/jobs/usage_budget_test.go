@@ -0,0 +1,56 @@
+package jobs
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCrossedThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      decimal.Decimal
+		amount     decimal.Decimal
+		thresholds []int32
+		want       []int32
+	}{
+		{
+			name:       "Below every threshold",
+			value:      decimal.NewFromInt(400),
+			amount:     decimal.NewFromInt(1000),
+			thresholds: []int32{50, 80, 100},
+			want:       []int32{},
+		},
+		{
+			name:       "Exactly on a threshold",
+			value:      decimal.NewFromInt(500),
+			amount:     decimal.NewFromInt(1000),
+			thresholds: []int32{50, 80, 100},
+			want:       []int32{50},
+		},
+		{
+			name:       "Several thresholds at once, unsorted input",
+			value:      decimal.NewFromFloat(105.5),
+			amount:     decimal.NewFromInt(100),
+			thresholds: []int32{100, 50, 80},
+			want:       []int32{50, 80, 100},
+		},
+		{
+			name:       "Zero budget",
+			value:      decimal.NewFromInt(10),
+			amount:     decimal.Zero,
+			thresholds: []int32{50},
+			want:       nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := crossedThresholds(tt.value, tt.amount, tt.thresholds)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("crossedThresholds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
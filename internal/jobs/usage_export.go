@@ -0,0 +1,267 @@
+package jobs
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// UsageExportRetention is how long a finished export can be downloaded
+	UsageExportRetention = 7 * 24 * time.Hour
+	// usageExportPageSize is how many records are read per query
+	usageExportPageSize = 5000
+	// usageExportStaleAfter is when a running export is assumed abandoned
+	usageExportStaleAfter = time.Hour
+	// usageExportsPerRun bounds how many exports one run works through
+	usageExportsPerRun = 5
+)
+
+// usageExportColumns is the CSV header and the NDJSON key order
+var usageExportColumns = []string{
+	"id", "created_at", "api_key_id", "endpoint", "method", "status_code",
+	"duration_ms", "request_bytes", "response_bytes", "client_ip",
+	"user_agent", "request_id", "message_type", "units",
+}
+
+// usageExportSource pages through raw usage. *database.Queries satisfies it.
+type usageExportSource interface {
+	ListUsageRecordsForExport(ctx context.Context, arg database.ListUsageRecordsForExportParams) ([]database.ListUsageRecordsForExportRow, error)
+}
+
+// ProcessUsageExports removes expired export files and then writes pending
+// exports to exportDir, oldest first
+func ProcessUsageExports(db *database.Queries, exportDir string) error {
+	ctx := context.Background()
+
+	expired, err := db.ListExpiredUsageExports(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list expired usage exports: %w", err)
+	}
+	for _, export := range expired {
+		if export.FilePath != nil {
+			if err := os.Remove(*export.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Failed to remove usage export %s: %v", export.ID, err)
+				continue
+			}
+		}
+		if err := db.MarkUsageExportExpired(ctx, export.ID); err != nil {
+			log.Printf("Failed to mark usage export %s expired: %v", export.ID, err)
+		}
+	}
+
+	for i := 0; i < usageExportsPerRun; i++ {
+		staleBefore := time.Now().UTC().Add(-usageExportStaleAfter)
+		export, err := db.ClaimUsageExport(ctx, pgtype.Timestamp{Time: staleBefore, Valid: true})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to claim usage export: %w", err)
+		}
+
+		path, rows, size, err := runUsageExport(ctx, db, export, exportDir)
+		if err != nil {
+			log.Printf("Usage export %s failed: %v", export.ID, err)
+			message := err.Error()
+			if err := db.FailUsageExport(ctx, database.FailUsageExportParams{
+				ID:    export.ID,
+				Error: &message,
+			}); err != nil {
+				log.Printf("Failed to record failure of usage export %s: %v", export.ID, err)
+			}
+			continue
+		}
+
+		err = db.CompleteUsageExport(ctx, database.CompleteUsageExportParams{
+			ID:        export.ID,
+			RowCount:  rows,
+			FileSize:  size,
+			FilePath:  &path,
+			ExpiresAt: pgtype.Timestamp{Time: time.Now().UTC().Add(UsageExportRetention), Valid: true},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to complete usage export %s: %w", export.ID, err)
+		}
+		log.Printf("Exported %d usage records for org %s to %s", rows, export.OrganizationID, path)
+	}
+
+	return nil
+}
+
+// runUsageExport writes the export to <exportDir>/<id>.<format>. Like the
+// partition archives, the file is written under a temporary name and only
+// renamed once complete.
+func runUsageExport(ctx context.Context, db usageExportSource, export database.UsageExport, exportDir string) (string, int64, int64, error) {
+	if err := os.MkdirAll(exportDir, 0o750); err != nil {
+		return "", 0, 0, err
+	}
+
+	path := filepath.Join(exportDir, export.ID.String()+"."+string(export.Format))
+	tmp, err := os.CreateTemp(exportDir, export.ID.String()+".*.tmp")
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	rows, err := writeUsageExport(ctx, db, export, tmp)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", 0, 0, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, 0, err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, 0, err
+	}
+
+	return path, rows, info.Size(), nil
+}
+
+// writeUsageExport pages through the export's range in (created_at, id)
+// order and writes every record to w, returning how many were written
+func writeUsageExport(ctx context.Context, db usageExportSource, export database.UsageExport, w io.Writer) (int64, error) {
+	var encode func(record database.ListUsageRecordsForExportRow) error
+	var flush func() error
+
+	switch export.Format {
+	case database.UsageExportFormatCsv:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(usageExportColumns); err != nil {
+			return 0, err
+		}
+		encode = func(record database.ListUsageRecordsForExportRow) error {
+			return writer.Write(usageExportCSVRow(record))
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	case database.UsageExportFormatNdjson:
+		encoder := json.NewEncoder(w)
+		encode = func(record database.ListUsageRecordsForExportRow) error {
+			return encoder.Encode(usageExportJSONRow(record))
+		}
+		flush = func() error { return nil }
+	default:
+		return 0, fmt.Errorf("unsupported export format %q", export.Format)
+	}
+
+	// Starting just after the nil UUID includes records at exactly start_time
+	afterCreatedAt := export.StartTime
+	afterID := uuid.Nil
+
+	var written int64
+	for {
+		page, err := db.ListUsageRecordsForExport(ctx, database.ListUsageRecordsForExportParams{
+			OrganizationID: export.OrganizationID,
+			EndTime:        export.EndTime,
+			AfterCreatedAt: afterCreatedAt,
+			AfterID:        afterID,
+			LimitCount:     usageExportPageSize,
+		})
+		if err != nil {
+			return written, fmt.Errorf("failed to read usage records: %w", err)
+		}
+
+		for _, record := range page {
+			if err := encode(record); err != nil {
+				return written, err
+			}
+			written++
+		}
+
+		if len(page) < usageExportPageSize {
+			break
+		}
+		last := page[len(page)-1]
+		afterCreatedAt, afterID = last.CreatedAt, last.ID
+	}
+
+	return written, flush()
+}
+
+func usageExportCSVRow(record database.ListUsageRecordsForExportRow) []string {
+	optional := func(value *string) string {
+		if value == nil {
+			return ""
+		}
+		return *value
+	}
+
+	return []string{
+		record.ID.String(),
+		record.CreatedAt.Time.UTC().Format(time.RFC3339Nano),
+		record.ApiKeyID.String(),
+		record.Endpoint,
+		record.Method,
+		strconv.Itoa(int(record.StatusCode)),
+		strconv.Itoa(int(record.DurationMs)),
+		strconv.FormatInt(record.RequestBytes, 10),
+		strconv.FormatInt(record.ResponseBytes, 10),
+		optional(record.ClientIp),
+		optional(record.UserAgent),
+		optional(record.RequestID),
+		optional(record.MessageType),
+		strconv.Itoa(int(record.Units)),
+	}
+}
+
+// usageExportRow is one NDJSON line. Missing optional values are null.
+type usageExportRow struct {
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	ApiKeyID      uuid.UUID `json:"api_key_id"`
+	Endpoint      string    `json:"endpoint"`
+	Method        string    `json:"method"`
+	StatusCode    int32     `json:"status_code"`
+	DurationMs    int32     `json:"duration_ms"`
+	RequestBytes  int64     `json:"request_bytes"`
+	ResponseBytes int64     `json:"response_bytes"`
+	ClientIP      *string   `json:"client_ip"`
+	UserAgent     *string   `json:"user_agent"`
+	RequestID     *string   `json:"request_id"`
+	MessageType   *string   `json:"message_type"`
+	Units         int32     `json:"units"`
+}
+
+func usageExportJSONRow(record database.ListUsageRecordsForExportRow) usageExportRow {
+	return usageExportRow{
+		ID:            record.ID,
+		CreatedAt:     record.CreatedAt.Time.UTC(),
+		ApiKeyID:      record.ApiKeyID,
+		Endpoint:      record.Endpoint,
+		Method:        record.Method,
+		StatusCode:    record.StatusCode,
+		DurationMs:    record.DurationMs,
+		RequestBytes:  record.RequestBytes,
+		ResponseBytes: record.ResponseBytes,
+		ClientIP:      record.ClientIp,
+		UserAgent:     record.UserAgent,
+		RequestID:     record.RequestID,
+		MessageType:   record.MessageType,
+		Units:         record.Units,
+	}
+}
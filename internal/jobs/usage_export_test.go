@@ -0,0 +1,179 @@
+package jobs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// fakeUsageSource serves records sorted by (created_at, id) the way the
+// keyset query does
+type fakeUsageSource struct {
+	records []database.ListUsageRecordsForExportRow
+	queries int
+}
+
+func (f *fakeUsageSource) ListUsageRecordsForExport(ctx context.Context, arg database.ListUsageRecordsForExportParams) ([]database.ListUsageRecordsForExportRow, error) {
+	f.queries++
+
+	page := []database.ListUsageRecordsForExportRow{}
+	for _, record := range f.records {
+		if !record.CreatedAt.Time.Before(arg.EndTime.Time) {
+			continue
+		}
+		after := record.CreatedAt.Time.After(arg.AfterCreatedAt.Time) ||
+			(record.CreatedAt.Time.Equal(arg.AfterCreatedAt.Time) && bytes.Compare(record.ID[:], arg.AfterID[:]) > 0)
+		if !after {
+			continue
+		}
+		page = append(page, record)
+		if len(page) == int(arg.LimitCount) {
+			break
+		}
+	}
+	return page, nil
+}
+
+func newFakeUsageSource(start time.Time, n int) *fakeUsageSource {
+	source := &fakeUsageSource{}
+	for i := 0; i < n; i++ {
+		id := uuid.UUID{}
+		id[15] = byte((i + 1) % 256)
+		id[14] = byte((i + 1) / 256)
+		source.records = append(source.records, database.ListUsageRecordsForExportRow{
+			ID: id,
+			// Several records share a timestamp so paging has to use the id
+			CreatedAt:  pgtype.Timestamp{Time: start.Add(time.Duration(i/3) * time.Millisecond), Valid: true},
+			Endpoint:   "/api/v1/messages/send",
+			Method:     "POST",
+			StatusCode: 200,
+			Units:      1,
+		})
+	}
+	return source
+}
+
+func TestWriteUsageExportPages(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	total := usageExportPageSize + 7
+	source := newFakeUsageSource(start, total)
+
+	export := database.UsageExport{
+		Format:    database.UsageExportFormatNdjson,
+		StartTime: pgtype.Timestamp{Time: start, Valid: true},
+		EndTime:   pgtype.Timestamp{Time: start.Add(time.Hour), Valid: true},
+	}
+
+	var out bytes.Buffer
+	written, err := writeUsageExport(context.Background(), source, export, &out)
+	if err != nil {
+		t.Fatalf("writeUsageExport() error = %v", err)
+	}
+	if written != int64(total) {
+		t.Errorf("written = %d, want %d", written, total)
+	}
+	if source.queries != 2 {
+		t.Errorf("queries = %d, want 2", source.queries)
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var row usageExportRow
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		if seen[row.ID] {
+			t.Fatalf("record %s exported twice", row.ID)
+		}
+		seen[row.ID] = true
+	}
+	if len(seen) != total {
+		t.Errorf("distinct records = %d, want %d", len(seen), total)
+	}
+}
+
+func TestWriteUsageExportCSV(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	source := newFakeUsageSource(start, 2)
+	userAgent := "curl/8.0, \"quoted\""
+	source.records[0].UserAgent = &userAgent
+
+	export := database.UsageExport{
+		Format:    database.UsageExportFormatCsv,
+		StartTime: pgtype.Timestamp{Time: start, Valid: true},
+		EndTime:   pgtype.Timestamp{Time: start.Add(time.Hour), Valid: true},
+	}
+
+	var out bytes.Buffer
+	if _, err := writeUsageExport(context.Background(), source, export, &out); err != nil {
+		t.Fatalf("writeUsageExport() error = %v", err)
+	}
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("rows = %d, want header and 2 records", len(rows))
+	}
+	if strings.Join(rows[0], ",") != strings.Join(usageExportColumns, ",") {
+		t.Errorf("header = %v", rows[0])
+	}
+	if rows[1][1] != "2026-10-01T00:00:00Z" {
+		t.Errorf("created_at = %q", rows[1][1])
+	}
+	if rows[1][10] != userAgent {
+		t.Errorf("user_agent = %q, want %q", rows[1][10], userAgent)
+	}
+	if rows[2][10] != "" {
+		t.Errorf("missing user_agent = %q, want empty", rows[2][10])
+	}
+}
+
+func TestRunUsageExportWritesFile(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	export := database.UsageExport{
+		ID:        uuid.New(),
+		Format:    database.UsageExportFormatCsv,
+		StartTime: pgtype.Timestamp{Time: start, Valid: true},
+		EndTime:   pgtype.Timestamp{Time: start.Add(time.Hour), Valid: true},
+	}
+
+	path, rows, size, err := runUsageExport(context.Background(), newFakeUsageSource(start, 4), export, dir)
+	if err != nil {
+		t.Fatalf("runUsageExport() error = %v", err)
+	}
+	if path != filepath.Join(dir, export.ID.String()+".csv") {
+		t.Errorf("path = %q", path)
+	}
+	if rows != 4 {
+		t.Errorf("rows = %d, want 4", rows)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("export file missing: %v", err)
+	}
+	if info.Size() != size {
+		t.Errorf("size = %d, file is %d bytes", size, info.Size())
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the export", len(entries))
+	}
+}
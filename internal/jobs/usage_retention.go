@@ -0,0 +1,275 @@
+package jobs
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// usageRetention is how long raw usage records are kept for each plan,
+// going by the plan the organization was on when the record was written.
+// Aggregated usage stays available in the rollup tables after that.
+var usageRetention = map[database.PlanType]time.Duration{
+	database.PlanTypeFree:    30 * 24 * time.Hour,
+	database.PlanTypeStarter: 90 * 24 * time.Hour,
+	database.PlanTypePro:     365 * 24 * time.Hour,
+}
+
+// futurePartitions is how many months ahead of the current one are kept ready
+const futurePartitions = 3
+
+const partitionLayout = "usage_records_2006_01"
+
+// archiveTimestamp is how the bounds of rows to archive are written in SQL
+const archiveTimestamp = "2006-01-02 15:04:05.999999"
+
+// recordPlan is the plan an organization was on when usage record ur was
+// written: the latest plan history entry started by then, preferring the
+// entry still open or ending last when several started together. Records
+// from before the history starts go by its first entry, and organizations
+// that never changed plan by their plan in o.
+const recordPlan = `COALESCE(
+	(SELECT h.plan FROM organization_plan_history h
+	 WHERE h.organization_id = ur.organization_id AND h.started_at <= ur.created_at
+	 ORDER BY h.started_at DESC, h.ended_at DESC NULLS FIRST LIMIT 1),
+	(SELECT h.plan FROM organization_plan_history h
+	 WHERE h.organization_id = ur.organization_id
+	 ORDER BY h.started_at LIMIT 1),
+	o.plan)`
+
+// usagePartition is an attached monthly partition of usage_records
+type usagePartition struct {
+	Name  string
+	Month time.Time
+}
+
+// usageRange is the part of a partition holding one plan's expired rows
+type usageRange struct {
+	Partition   string
+	From, Until time.Time
+}
+
+// MaintainUsagePartitions creates upcoming usage_records partitions, archives
+// and drops partitions older than the longest plan retention, and archives
+// then deletes the rows written on plans kept for less. Nothing is deleted
+// without being archived first.
+func MaintainUsagePartitions(pool *pgxpool.Pool, archiveDir string) error {
+	ctx := context.Background()
+	db := database.New(pool)
+	now := time.Now().UTC()
+
+	currentMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= futurePartitions; i++ {
+		if err := createUsagePartition(ctx, pool, currentMonth.AddDate(0, i, 0)); err != nil {
+			return err
+		}
+	}
+
+	partitions, err := db.ListUsageRecordPartitions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list usage partitions: %w", err)
+	}
+
+	var kept []usagePartition
+	for _, partition := range partitions {
+		month, err := time.Parse(partitionLayout, partition.Name)
+		if err != nil {
+			log.Printf("Skipping unrecognised partition %s", partition.Name)
+			continue
+		}
+		if partitionExpired(month, now) {
+			if err := archiveUsagePartition(ctx, pool, partition, archiveDir); err != nil {
+				return err
+			}
+			continue
+		}
+		if partition.Attached {
+			kept = append(kept, usagePartition{Name: partition.Name, Month: month})
+		}
+	}
+
+	for plan, retention := range usageRetention {
+		for _, expired := range expiredRanges(kept, retention, now) {
+			if err := archiveExpiredUsage(ctx, pool, plan, expired, archiveDir, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// partitionExpired reports whether every row in the partition for month is
+// past the longest plan retention, so the partition can go as a whole
+func partitionExpired(month, now time.Time) bool {
+	return !month.AddDate(0, 1, 0).After(now.Add(-maxUsageRetention()))
+}
+
+// expiredRanges returns the part of each partition holding rows older than
+// retention. Each range lies within one partition, so deleting it only
+// touches that partition.
+func expiredRanges(partitions []usagePartition, retention time.Duration, now time.Time) []usageRange {
+	cutoff := now.Add(-retention)
+	var ranges []usageRange
+	for _, partition := range partitions {
+		if !partition.Month.Before(cutoff) {
+			continue
+		}
+		until := partition.Month.AddDate(0, 1, 0)
+		if until.After(cutoff) {
+			until = cutoff
+		}
+		ranges = append(ranges, usageRange{Partition: partition.Name, From: partition.Month, Until: until})
+	}
+	return ranges
+}
+
+func createUsagePartition(ctx context.Context, pool *pgxpool.Pool, month time.Time) error {
+	name := pgx.Identifier{month.Format(partitionLayout)}.Sanitize()
+	sql := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s PARTITION OF usage_records FOR VALUES FROM ('%s') TO ('%s')",
+		name,
+		month.Format("2006-01-02"),
+		month.AddDate(0, 1, 0).Format("2006-01-02"),
+	)
+
+	if _, err := pool.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("failed to create partition %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// archiveUsagePartition detaches a partition, writes it to a gzipped CSV file
+// and then drops it. A partition left detached by an earlier failed run is
+// picked up again and archived from the start.
+func archiveUsagePartition(ctx context.Context, pool *pgxpool.Pool, partition database.ListUsageRecordPartitionsRow, archiveDir string) error {
+	name := pgx.Identifier{partition.Name}.Sanitize()
+
+	if partition.Attached {
+		if _, err := pool.Exec(ctx, "ALTER TABLE usage_records DETACH PARTITION "+name); err != nil {
+			return fmt.Errorf("failed to detach partition %s: %w", partition.Name, err)
+		}
+		log.Printf("Detached partition %s", partition.Name)
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	path, _, err := writeArchive(ctx, conn.Conn(), archiveDir, partition.Name, "COPY "+name+" TO STDOUT WITH (FORMAT csv, HEADER true)")
+	if err != nil {
+		return fmt.Errorf("failed to archive partition %s: %w", partition.Name, err)
+	}
+	if path != "" {
+		log.Printf("Archived partition %s to %s", partition.Name, path)
+	}
+
+	if _, err := pool.Exec(ctx, "DROP TABLE "+name); err != nil {
+		return fmt.Errorf("failed to drop partition %s: %w", partition.Name, err)
+	}
+	log.Printf("Dropped partition %s", partition.Name)
+
+	return nil
+}
+
+// archiveExpiredUsage deletes the rows in the range written on a plan and
+// writes the deleted rows to a gzipped CSV file in the same statement. The
+// file is in place before the delete commits, so a failed commit can only
+// leave rows archived twice, never deleted unarchived.
+func archiveExpiredUsage(ctx context.Context, pool *pgxpool.Pool, plan database.PlanType, expired usageRange, archiveDir string, now time.Time) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	sql := fmt.Sprintf(
+		"COPY (DELETE FROM %s ur USING organizations o WHERE ur.organization_id = o.id AND ur.created_at >= '%s' AND ur.created_at < '%s' AND %s = '%s' RETURNING ur.*) TO STDOUT WITH (FORMAT csv, HEADER true)",
+		pgx.Identifier{expired.Partition}.Sanitize(),
+		expired.From.Format(archiveTimestamp),
+		expired.Until.Format(archiveTimestamp),
+		recordPlan,
+		plan,
+	)
+	name := fmt.Sprintf("%s_%s_%s", expired.Partition, plan, now.Format("20060102T150405"))
+	path, deleted, err := writeArchive(ctx, conn.Conn(), archiveDir, name, sql)
+	if err != nil {
+		return fmt.Errorf("failed to archive expired %s usage in %s: %w", plan, expired.Partition, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to delete expired %s usage in %s: %w", plan, expired.Partition, err)
+	}
+	if deleted > 0 {
+		log.Printf("Archived %d usage records past %s plan retention to %s", deleted, plan, path)
+	}
+	return nil
+}
+
+// writeArchive runs a COPY TO STDOUT into <archiveDir>/<name>.csv.gz and
+// returns the file and the number of rows. The file is written under a
+// temporary name and renamed once it is complete, so a partially written
+// archive is never mistaken for a finished one. Nothing is kept if no rows
+// were copied, and the path is then empty.
+func writeArchive(ctx context.Context, conn *pgx.Conn, archiveDir, name, copySQL string) (string, int64, error) {
+	if err := os.MkdirAll(archiveDir, 0o750); err != nil {
+		return "", 0, err
+	}
+
+	path := filepath.Join(archiveDir, name+".csv.gz")
+	tmp, err := os.CreateTemp(archiveDir, name+".*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	gz := gzip.NewWriter(tmp)
+	tag, err := conn.PgConn().CopyTo(ctx, gz, copySQL)
+	if err != nil {
+		return "", 0, err
+	}
+	if tag.RowsAffected() == 0 {
+		return "", 0, nil
+	}
+	if err := gz.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+
+	return path, tag.RowsAffected(), nil
+}
+
+func maxUsageRetention() time.Duration {
+	var longest time.Duration
+	for _, retention := range usageRetention {
+		longest = max(longest, retention)
+	}
+	return longest
+}
@@ -0,0 +1,186 @@
+package jobs
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestPartitionExpired(t *testing.T) {
+	now := time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		month time.Time
+		want  bool
+	}{
+		{
+			name:  "Month ended before the longest retention",
+			month: time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC),
+			want:  true,
+		},
+		{
+			name:  "Month straddling the longest retention",
+			month: time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC),
+			want:  false,
+		},
+		{
+			name:  "Month past the free plan's retention only",
+			month: time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC),
+			want:  false,
+		},
+		{
+			name:  "Current month",
+			month: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partitionExpired(tt.month, now); got != tt.want {
+				t.Errorf("partitionExpired(%s) = %v, want %v", tt.month.Format("2006-01"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpiredRanges(t *testing.T) {
+	now := time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)
+	month := func(m time.Month) usagePartition {
+		start := time.Date(2026, m, 1, 0, 0, 0, 0, time.UTC)
+		return usagePartition{Name: start.Format(partitionLayout), Month: start}
+	}
+	partitions := []usagePartition{month(7), month(8), month(9), month(10), month(11)}
+
+	tests := []struct {
+		name      string
+		retention time.Duration
+		want      []usageRange
+	}{
+		{
+			name:      "Free plan archives whole months and part of the last",
+			retention: 30 * 24 * time.Hour,
+			want: []usageRange{
+				{Partition: "usage_records_2026_07", From: month(7).Month, Until: month(8).Month},
+				{Partition: "usage_records_2026_08", From: month(8).Month, Until: month(9).Month},
+				{Partition: "usage_records_2026_09", From: month(9).Month, Until: time.Date(2026, 9, 18, 3, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:      "Starter plan stops at its own cutoff",
+			retention: 90 * 24 * time.Hour,
+			want: []usageRange{
+				{Partition: "usage_records_2026_07", From: month(7).Month, Until: time.Date(2026, 7, 20, 3, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:      "Pro plan has nothing expired in these months",
+			retention: 365 * 24 * time.Hour,
+			want:      nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expiredRanges(partitions, tt.retention, now)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expiredRanges() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestArchiveExpiredUsageByRecordPlan deletes records by the plan they were
+// written on. It runs against TEST_DATABASE_URL, on temporary tables
+// standing in for a partition and the organization tables.
+func TestArchiveExpiredUsageByRecordPlan(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+	dbURL := os.Getenv("TEST_DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	config, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	// Temporary tables belong to one connection
+	config.MaxConns = 1
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer pool.Close()
+
+	// Temporary tables come first on the search path
+	for _, sql := range []string{
+		`CREATE TEMP TABLE organizations (id UUID PRIMARY KEY, plan plan_type NOT NULL)`,
+		`CREATE TEMP TABLE organization_plan_history (organization_id UUID NOT NULL, plan plan_type NOT NULL, started_at TIMESTAMP NOT NULL, ended_at TIMESTAMP)`,
+		`CREATE TEMP TABLE usage_records_2026_01 (organization_id UUID NOT NULL, created_at TIMESTAMP NOT NULL)`,
+	} {
+		if _, err := pool.Exec(ctx, sql); err != nil {
+			t.Fatalf("failed to create table: %v", err)
+		}
+	}
+
+	day := func(month time.Month, day int) time.Time {
+		return time.Date(2026, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	downgraded, upgraded, unchanged := uuid.New(), uuid.New(), uuid.New()
+	setup := []struct {
+		sql  string
+		args []any
+	}{
+		{`INSERT INTO organizations VALUES ($1, 'free'), ($2, 'pro'), ($3, 'free')`, []any{downgraded, upgraded, unchanged}},
+		// Pro until February, then free
+		{`INSERT INTO organization_plan_history VALUES ($1, 'pro', $2, $3), ($1, 'free', $3, NULL)`, []any{downgraded, day(1, 1), day(2, 1)}},
+		// Free until January 20th, then pro
+		{`INSERT INTO organization_plan_history VALUES ($1, 'free', $2, $3), ($1, 'pro', $3, NULL)`, []any{upgraded, day(1, 1), day(1, 20)}},
+		{`INSERT INTO usage_records_2026_01 VALUES ($1, $4), ($2, $4), ($2, $5), ($3, $4)`, []any{downgraded, upgraded, unchanged, day(1, 10), day(1, 25)}},
+	}
+	for _, step := range setup {
+		if _, err := pool.Exec(ctx, step.sql, step.args...); err != nil {
+			t.Fatalf("failed to insert test data: %v", err)
+		}
+	}
+
+	expired := usageRange{Partition: "usage_records_2026_01", From: day(1, 1), Until: day(2, 1)}
+	if err := archiveExpiredUsage(ctx, pool, database.PlanTypeFree, expired, t.TempDir(), day(3, 15)); err != nil {
+		t.Fatalf("archiveExpiredUsage() error = %v", err)
+	}
+
+	rows, err := pool.Query(ctx, `SELECT organization_id, created_at FROM usage_records_2026_01 ORDER BY created_at`)
+	if err != nil {
+		t.Fatalf("failed to read usage records: %v", err)
+	}
+	defer rows.Close()
+	kept := map[uuid.UUID][]time.Time{}
+	for rows.Next() {
+		var orgID uuid.UUID
+		var createdAt time.Time
+		if err := rows.Scan(&orgID, &createdAt); err != nil {
+			t.Fatalf("failed to scan usage record: %v", err)
+		}
+		kept[orgID] = append(kept[orgID], createdAt)
+	}
+
+	// Only the records written on free go: the downgraded organization
+	// keeps what it wrote on pro
+	want := map[uuid.UUID][]time.Time{
+		downgraded: {day(1, 10)},
+		upgraded:   {day(1, 25)},
+	}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("records kept = %v, want %v", kept, want)
+	}
+}
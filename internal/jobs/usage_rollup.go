@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/usage"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// rollupGracePeriod gives buffered usage records time to reach the database
+// before the hour they belong to is rolled up.
+const rollupGracePeriod = 5 * time.Minute
+
+// rollupRecomputeWindow is how far before the previous watermark each run
+// starts again. The usage pipeline writes no record later than
+// usage.MaxWriteDelay, and the extra hour covers a write in flight.
+const rollupRecomputeWindow = usage.MaxWriteDelay + time.Hour
+
+// RollupUsage folds completed hours of usage_records into the hourly and daily
+// rollup tables and advances the watermark readers use to switch to raw data.
+// The hours in rollupRecomputeWindow before the previous watermark are
+// recomputed to pick up late records.
+func RollupUsage(pool *pgxpool.Pool) error {
+	ctx := context.Background()
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	db := database.New(pool).WithTx(tx)
+
+	// Locks the watermark row, so overlapping runs wait instead of racing
+	watermark, err := db.GetUsageRollupWatermark(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read rollup watermark: %w", err)
+	}
+
+	rollupEnd := time.Now().UTC().Add(-rollupGracePeriod).Truncate(time.Hour)
+	if !rollupEnd.After(watermark.Time) {
+		log.Printf("Usage rollups already current up to %s", watermark.Time.Format(time.RFC3339))
+		return nil
+	}
+
+	rollupStart := watermark.Time.Add(-rollupRecomputeWindow)
+	startPg := pgtype.Timestamp{Time: rollupStart, Valid: true}
+	endPg := pgtype.Timestamp{Time: rollupEnd, Valid: true}
+
+	hourly, err := db.RollupHourlyUsage(ctx, database.RollupHourlyUsageParams{
+		StartTime: startPg,
+		EndTime:   endPg,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to roll up hourly usage: %w", err)
+	}
+
+	daily, err := db.RollupDailyUsage(ctx, database.RollupDailyUsageParams{
+		StartTime: startPg,
+		EndTime:   endPg,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to roll up daily usage: %w", err)
+	}
+
+	if err := db.SetUsageRollupWatermark(ctx, endPg); err != nil {
+		return fmt.Errorf("failed to advance rollup watermark: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit usage rollups: %w", err)
+	}
+
+	log.Printf("Rolled up usage from %s to %s (%d hourly rows, %d daily rows)",
+		rollupStart.Format(time.RFC3339), rollupEnd.Format(time.RFC3339), hourly, daily)
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 )
 
 type PaystackProvider struct {
@@ -25,8 +26,23 @@ func NewPaystackProvider(secretKey, webhookSecret string) *PaystackProvider {
 	}
 }
 
+// paystackCurrencies are the currencies Paystack can charge in
+var paystackCurrencies = map[string]bool{
+	"NGN": true,
+	"GHS": true,
+	"ZAR": true,
+	"KES": true,
+	"USD": true,
+}
+
+// SupportsCurrency reports whether Paystack can charge in an ISO currency
+func (p *PaystackProvider) SupportsCurrency(code string) bool {
+	return paystackCurrencies[code]
+}
+
 type PaystackInitializeParams struct {
-	Email       string            `json:"email"`
+	Email string `json:"email"`
+	// Amount is in the currency's subunit, e.g. kobo
 	Amount      int64             `json:"amount"`
 	Currency    string            `json:"currency,omitempty"`
 	Reference   string            `json:"reference"`
@@ -45,43 +61,118 @@ type PaystackInitializeResponse struct {
 }
 
 func (p *PaystackProvider) InitializeTransaction(params PaystackInitializeParams) (*PaystackInitializeResponse, error) {
-	url := fmt.Sprintf("%s/transaction/initialize", p.baseURL)
+	var result PaystackInitializeResponse
+	if err := p.post("/transaction/initialize", params, &result); err != nil {
+		return nil, err
+	}
+
+	if !result.Status {
+		return nil, fmt.Errorf("paystack error: %s", result.Message)
+	}
+
+	return &result, nil
+}
+
+type PaystackChargeAuthorizationParams struct {
+	Email string `json:"email"`
+	// AuthorizationCode is the reusable authorization of an earlier charge
+	AuthorizationCode string `json:"authorization_code"`
+	// Amount is in the currency's subunit, e.g. kobo
+	Amount    int64             `json:"amount"`
+	Currency  string            `json:"currency,omitempty"`
+	Reference string            `json:"reference"`
+	Metadata  map[string]string `json:"metadata"`
+}
 
+type PaystackChargeResponse struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+	Data    struct {
+		Status    string `json:"status"`
+		Reference string `json:"reference"`
+		// Amount is in the currency's subunit, e.g. kobo
+		Amount int64 `json:"amount"`
+		// GatewayResponse says why a charge did not succeed
+		GatewayResponse string `json:"gateway_response"`
+	} `json:"data"`
+}
+
+// ChargeAuthorization charges a card saved from an earlier charge with the
+// customer absent. It returns an error unless the charge succeeded.
+func (p *PaystackProvider) ChargeAuthorization(params PaystackChargeAuthorizationParams) (*PaystackChargeResponse, error) {
+	var result PaystackChargeResponse
+	if err := p.post("/transaction/charge_authorization", params, &result); err != nil {
+		return nil, err
+	}
+
+	if !result.Status {
+		return nil, fmt.Errorf("paystack error: %s", result.Message)
+	}
+	if result.Data.Status == "failed" {
+		return &result, fmt.Errorf("charge %s: %w: %s", result.Data.Reference, ErrChargeDeclined, result.Data.GatewayResponse)
+	}
+	if result.Data.Status != "success" {
+		return &result, fmt.Errorf("charge %s is %s: %s", result.Data.Reference, result.Data.Status, result.Data.GatewayResponse)
+	}
+
+	return &result, nil
+}
+
+// VerifyTransaction looks up a transaction by its reference. A charge whose
+// response was lost can be found this way before it is retried.
+func (p *PaystackProvider) VerifyTransaction(reference string) (*PaystackChargeResponse, error) {
+	req, err := http.NewRequest("GET", p.baseURL+"/transaction/verify/"+url.PathEscape(reference), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	var result PaystackChargeResponse
+	if err := p.send(req, &result); err != nil {
+		return nil, err
+	}
+	if !result.Status {
+		return nil, fmt.Errorf("paystack error: %s", result.Message)
+	}
+	return &result, nil
+}
+
+// post sends params to a Paystack endpoint and decodes the response into
+// result
+func (p *PaystackProvider) post(path string, params interface{}, result interface{}) error {
 	jsonData, err := json.Marshal(params)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal params: %w", err)
+		return fmt.Errorf("failed to marshal params: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", p.baseURL+path, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/json")
+	return p.send(req, result)
+}
 
+// send makes an authenticated request and decodes the response into result
+func (p *PaystackProvider) send(req *http.Request, result interface{}) error {
 	req.Header.Set("Authorization", "Bearer "+p.secretKey)
-	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	var result PaystackInitializeResponse
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+		return fmt.Errorf("failed to read response: %w", err)
 	}
 
-	if !result.Status {
-		return nil, fmt.Errorf("paystack error: %s", result.Message)
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	return &result, nil
+	return nil
 }
 
 func (p *PaystackProvider) VerifyWebhookSignature(payload []byte, signature string) bool {
@@ -103,3 +194,34 @@ func (p *PaystackProvider) ParseWebhookEvent(payload []byte) (*PaystackWebhookEv
 	}
 	return &event, nil
 }
+
+// PaystackAuthorization is the card a successful charge was made with
+type PaystackAuthorization struct {
+	Code     string
+	Brand    string
+	Last4    string
+	Email    string
+	Reusable bool
+}
+
+// Authorization reads the card a charge.success event was paid with. ok is
+// false if the event carries none.
+func (e *PaystackWebhookEvent) Authorization() (PaystackAuthorization, bool) {
+	data, ok := e.Data["authorization"].(map[string]interface{})
+	if !ok {
+		return PaystackAuthorization{}, false
+	}
+
+	var auth PaystackAuthorization
+	auth.Code, _ = data["authorization_code"].(string)
+	auth.Brand, _ = data["brand"].(string)
+	if auth.Brand == "" {
+		auth.Brand, _ = data["card_type"].(string)
+	}
+	auth.Last4, _ = data["last4"].(string)
+	auth.Reusable, _ = data["reusable"].(bool)
+	if cust, ok := e.Data["customer"].(map[string]interface{}); ok {
+		auth.Email, _ = cust["email"].(string)
+	}
+	return auth, auth.Code != ""
+}
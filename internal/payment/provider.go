@@ -10,9 +10,9 @@ type PaymentService struct {
 	Paystack *PaystackProvider
 }
 
-func NewPaymentService(stripeKey, stripeWebhook, paystackKey, paystackWebhook string) *PaymentService {
+func NewPaymentService(stripeKey, stripeWebhook, stripeAPIURL, paystackKey, paystackWebhook string) *PaymentService {
 	return &PaymentService{
-		Stripe:   NewStripeProvider(stripeKey, stripeWebhook),
+		Stripe:   NewStripeProvider(stripeKey, stripeWebhook, stripeAPIURL),
 		Paystack: NewPaystackProvider(paystackKey, paystackWebhook),
 	}
 }
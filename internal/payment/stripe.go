@@ -1,62 +1,103 @@
 package payment
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/stripe/stripe-go/v76"
-	"github.com/stripe/stripe-go/v76/checkout/session"
+	"github.com/stripe/stripe-go/v76/client"
 	"github.com/stripe/stripe-go/v76/webhook"
 )
 
 type StripeProvider struct {
 	secretKey     string
 	webhookSecret string
+	api           *client.API
 }
 
-func NewStripeProvider(secretKey, webhookSecret string) *StripeProvider {
-	stripe.Key = secretKey
+// NewStripeProvider returns a provider calling the Stripe API at apiURL, or
+// at Stripe itself when it is empty. Tests and local setups point it at a
+// stub of the API.
+func NewStripeProvider(secretKey, webhookSecret, apiURL string) *StripeProvider {
+	var backends *stripe.Backends
+	if apiURL != "" {
+		backends = stripe.NewBackendsWithConfig(&stripe.BackendConfig{URL: stripe.String(apiURL)})
+	}
 	return &StripeProvider{
 		secretKey:     secretKey,
 		webhookSecret: webhookSecret,
+		api:           client.New(secretKey, backends),
 	}
 }
 
 type CheckoutSessionParams struct {
 	OrganizationID string
 	BillingCycleID string
-	Amount         int64
-	Currency       string
-	SuccessURL     string
-	CancelURL      string
-	CustomerEmail  string
+	InvoiceNumber  string
+	// Amount is in the currency's minor unit: cents, or yen for zero-decimal
+	// currencies
+	Amount int64
+	// Currency is a lowercase ISO code
+	Currency      string
+	SuccessURL    string
+	CancelURL     string
+	CustomerEmail string
 }
 
 func (s *StripeProvider) CreateCheckoutSession(params CheckoutSessionParams) (*stripe.CheckoutSession, error) {
+	return s.newCheckoutSession("API Usage", fmt.Sprintf("Invoice %s", params.InvoiceNumber), params.Amount, params.Currency,
+		params.SuccessURL, params.CancelURL, params.CustomerEmail, map[string]string{
+			"organization_id":  params.OrganizationID,
+			"billing_cycle_id": params.BillingCycleID,
+			"invoice_number":   params.InvoiceNumber,
+		})
+}
+
+type TopUpSessionParams struct {
+	OrganizationID string
+	TopUpID        string
+	// Amount is in the currency's minor unit
+	Amount int64
+	// Currency is a lowercase ISO code
+	Currency      string
+	SuccessURL    string
+	CancelURL     string
+	CustomerEmail string
+}
+
+// CreateTopUpSession starts a checkout for prepaid credit. The session's
+// metadata carries the top-up ID instead of a billing cycle.
+func (s *StripeProvider) CreateTopUpSession(params TopUpSessionParams) (*stripe.CheckoutSession, error) {
+	return s.newCheckoutSession("Prepaid credit", "Credit top-up", params.Amount, params.Currency,
+		params.SuccessURL, params.CancelURL, params.CustomerEmail, map[string]string{
+			"organization_id": params.OrganizationID,
+			"top_up_id":       params.TopUpID,
+		})
+}
+
+func (s *StripeProvider) newCheckoutSession(name, description string, amount int64, currency, successURL, cancelURL, customerEmail string, metadata map[string]string) (*stripe.CheckoutSession, error) {
 	sessionParams := &stripe.CheckoutSessionParams{
 		Mode: stripe.String(string(stripe.CheckoutSessionModePayment)),
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
 				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-					Currency: stripe.String(params.Currency),
+					Currency: stripe.String(currency),
 					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-						Name:        stripe.String("API Usage"),
-						Description: stripe.String(fmt.Sprintf("Invoice #%s", params.BillingCycleID)),
+						Name:        stripe.String(name),
+						Description: stripe.String(description),
 					},
-					UnitAmount: stripe.Int64(params.Amount),
+					UnitAmount: stripe.Int64(amount),
 				},
 				Quantity: stripe.Int64(1),
 			},
 		},
-		SuccessURL:    stripe.String(params.SuccessURL),
-		CancelURL:     stripe.String(params.CancelURL),
-		CustomerEmail: stripe.String(params.CustomerEmail),
-		Metadata: map[string]string{
-			"organization_id":  params.OrganizationID,
-			"billing_cycle_id": params.BillingCycleID,
-		},
+		SuccessURL:    stripe.String(successURL),
+		CancelURL:     stripe.String(cancelURL),
+		CustomerEmail: stripe.String(customerEmail),
+		Metadata:      metadata,
 	}
 
-	sess, err := session.New(sessionParams)
+	sess, err := s.api.CheckoutSessions.New(sessionParams)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create checkout session: %w", err)
 	}
@@ -64,6 +105,131 @@ func (s *StripeProvider) CreateCheckoutSession(params CheckoutSessionParams) (*s
 	return sess, nil
 }
 
+// CreateCustomer creates the Stripe customer an organization's saved cards
+// are attached to and returns its ID
+func (s *StripeProvider) CreateCustomer(organizationID, name, email string) (string, error) {
+	cust, err := s.api.Customers.New(&stripe.CustomerParams{
+		Name:     stripe.String(name),
+		Email:    stripe.String(email),
+		Metadata: map[string]string{"organization_id": organizationID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create customer: %w", err)
+	}
+	return cust.ID, nil
+}
+
+type SetupSessionParams struct {
+	OrganizationID string
+	CustomerID     string
+	SuccessURL     string
+	CancelURL      string
+}
+
+// CreateSetupSession starts a checkout that saves a card to a customer
+// without charging it
+func (s *StripeProvider) CreateSetupSession(params SetupSessionParams) (*stripe.CheckoutSession, error) {
+	sess, err := s.api.CheckoutSessions.New(&stripe.CheckoutSessionParams{
+		Mode:               stripe.String(string(stripe.CheckoutSessionModeSetup)),
+		Customer:           stripe.String(params.CustomerID),
+		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+		SuccessURL:         stripe.String(params.SuccessURL),
+		CancelURL:          stripe.String(params.CancelURL),
+		Metadata:           map[string]string{"organization_id": params.OrganizationID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create setup session: %w", err)
+	}
+	return sess, nil
+}
+
+// SavedCard is a card saved to a customer that can be charged off-session
+type SavedCard struct {
+	CustomerID      string
+	PaymentMethodID string
+	Brand           string
+	Last4           string
+}
+
+// SetupIntentCard looks up the card a completed setup session saved
+func (s *StripeProvider) SetupIntentCard(setupIntentID string) (SavedCard, error) {
+	params := &stripe.SetupIntentParams{}
+	params.AddExpand("payment_method")
+	intent, err := s.api.SetupIntents.Get(setupIntentID, params)
+	if err != nil {
+		return SavedCard{}, fmt.Errorf("failed to get setup intent: %w", err)
+	}
+	if intent.PaymentMethod == nil || intent.Customer == nil {
+		return SavedCard{}, fmt.Errorf("setup intent %s saved no payment method", setupIntentID)
+	}
+
+	card := SavedCard{CustomerID: intent.Customer.ID, PaymentMethodID: intent.PaymentMethod.ID}
+	if intent.PaymentMethod.Card != nil {
+		card.Brand = string(intent.PaymentMethod.Card.Brand)
+		card.Last4 = intent.PaymentMethod.Card.Last4
+	}
+	return card, nil
+}
+
+type ChargeSavedParams struct {
+	CustomerID      string
+	PaymentMethodID string
+	// Amount is in the currency's minor unit
+	Amount int64
+	// Currency is a lowercase ISO code
+	Currency string
+	Metadata map[string]string
+	// IdempotencyKey makes a retried charge return the first one's outcome
+	// instead of charging again. Stripe keeps keys for 24 hours.
+	IdempotencyKey string
+}
+
+// ErrChargeConflict is returned when a charge's idempotency key was already
+// used for a different charge, so whether the customer paid is unknown
+var ErrChargeConflict = errors.New("idempotency key was used for a different charge")
+
+// ErrChargeDeclined is returned when the provider refused a charge, so the
+// customer was certainly not charged
+var ErrChargeDeclined = errors.New("charge was declined")
+
+// ChargeSaved charges a saved card with the customer absent. It returns the
+// payment intent's ID, or an error unless the charge succeeded outright.
+func (s *StripeProvider) ChargeSaved(params ChargeSavedParams) (string, error) {
+	intentParams := &stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(params.Amount),
+		Currency:      stripe.String(params.Currency),
+		Customer:      stripe.String(params.CustomerID),
+		PaymentMethod: stripe.String(params.PaymentMethodID),
+		Confirm:       stripe.Bool(true),
+		OffSession:    stripe.Bool(true),
+		Metadata:      params.Metadata,
+	}
+	if params.IdempotencyKey != "" {
+		intentParams.SetIdempotencyKey(params.IdempotencyKey)
+	}
+
+	intent, err := s.api.PaymentIntents.New(intentParams)
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeIdempotency {
+		return "", fmt.Errorf("failed to charge saved card: %w", ErrChargeConflict)
+	}
+	if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard {
+		return "", fmt.Errorf("failed to charge saved card: %w: %s", ErrChargeDeclined, stripeErr.Msg)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to charge saved card: %w", err)
+	}
+	switch intent.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+	case stripe.PaymentIntentStatusProcessing:
+		return intent.ID, fmt.Errorf("charge %s is %s", intent.ID, intent.Status)
+	default:
+		// Off-session intents that need the customer are not charged
+		return intent.ID, fmt.Errorf("charge %s is %s: %w", intent.ID, intent.Status, ErrChargeDeclined)
+	}
+	return intent.ID, nil
+}
+
 func (s *StripeProvider) VerifyWebhookSignature(payload []byte, signature string) (stripe.Event, error) {
 	event, err := webhook.ConstructEvent(payload, signature, s.webhookSecret)
 	if err != nil {
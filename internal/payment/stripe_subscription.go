@@ -0,0 +1,145 @@
+package payment
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+)
+
+type SubscriptionParams struct {
+	OrganizationID string
+	CustomerID     string
+	// PriceID is the licensed Price of the plan's base fee
+	PriceID string
+	// MeteredPriceID is the metered Price usage is reported against. It is
+	// left off the subscription when empty.
+	MeteredPriceID string
+	// PaymentMethodID is a saved card to charge; without one the first
+	// invoice is left open to be paid on its hosted page
+	PaymentMethodID string
+}
+
+// CreateSubscription subscribes a customer to a plan's Price and the metered
+// usage Price. The subscription stays incomplete until its first invoice is
+// paid.
+func (s *StripeProvider) CreateSubscription(params SubscriptionParams) (*stripe.Subscription, error) {
+	items := []*stripe.SubscriptionItemsParams{{Price: stripe.String(params.PriceID)}}
+	if params.MeteredPriceID != "" {
+		items = append(items, &stripe.SubscriptionItemsParams{Price: stripe.String(params.MeteredPriceID)})
+	}
+
+	subParams := &stripe.SubscriptionParams{
+		Customer:        stripe.String(params.CustomerID),
+		Items:           items,
+		PaymentBehavior: stripe.String("default_incomplete"),
+		Metadata:        map[string]string{"organization_id": params.OrganizationID},
+	}
+	if params.PaymentMethodID != "" {
+		subParams.DefaultPaymentMethod = stripe.String(params.PaymentMethodID)
+		subParams.PaymentBehavior = stripe.String("allow_incomplete")
+	}
+	subParams.AddExpand("latest_invoice")
+
+	sub, err := s.api.Subscriptions.New(subParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create subscription: %w", err)
+	}
+	return sub, nil
+}
+
+// GetSubscription fetches a subscription as it is now. Webhook events can
+// arrive out of order, so they are acted on from a fresh copy.
+func (s *StripeProvider) GetSubscription(subscriptionID string) (*stripe.Subscription, error) {
+	sub, err := s.api.Subscriptions.Get(subscriptionID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	return sub, nil
+}
+
+// ChangeSubscriptionPrice moves a subscription's base fee item to another
+// Price. Stripe prorates the difference onto the next invoice.
+func (s *StripeProvider) ChangeSubscriptionPrice(subscriptionID, itemID, priceID string) (*stripe.Subscription, error) {
+	sub, err := s.api.Subscriptions.Update(subscriptionID, &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{{
+			ID:    stripe.String(itemID),
+			Price: stripe.String(priceID),
+		}},
+		ProrationBehavior: stripe.String("create_prorations"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to change subscription: %w", err)
+	}
+	return sub, nil
+}
+
+// CancelSubscription cancels a subscription at the end of its current period,
+// or undoes that while the period lasts
+func (s *StripeProvider) CancelSubscription(subscriptionID string, cancel bool) (*stripe.Subscription, error) {
+	sub, err := s.api.Subscriptions.Update(subscriptionID, &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(cancel),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel subscription: %w", err)
+	}
+	return sub, nil
+}
+
+// ReportUsage records the units used in the hour starting at bucket on a
+// metered subscription item. The quantity replaces whatever was reported
+// for the hour before, so an hour can be reported again safely.
+func (s *StripeProvider) ReportUsage(itemID string, bucket time.Time, units int64) error {
+	params := &stripe.UsageRecordParams{
+		SubscriptionItem: stripe.String(itemID),
+		Action:           stripe.String("set"),
+		Quantity:         stripe.Int64(units),
+		Timestamp:        stripe.Int64(bucket.Unix()),
+	}
+	params.SetIdempotencyKey(fmt.Sprintf("usage-%s-%d-%d", itemID, bucket.Unix(), units))
+
+	if _, err := s.api.UsageRecords.New(params); err != nil {
+		return fmt.Errorf("failed to report usage: %w", err)
+	}
+	return nil
+}
+
+// SubscriptionItems are the items of a subscription to a plan
+type SubscriptionItems struct {
+	// BaseItemID and PriceID are the item of the plan's base fee and its
+	// Price
+	BaseItemID string
+	PriceID    string
+	// MeteredItemID is the item usage is reported on, empty if there is none
+	MeteredItemID string
+}
+
+// Items tells a subscription's base fee item from its metered item
+func Items(sub *stripe.Subscription) SubscriptionItems {
+	var items SubscriptionItems
+	if sub.Items == nil {
+		return items
+	}
+	for _, item := range sub.Items.Data {
+		if item.Price == nil {
+			continue
+		}
+		if item.Price.Recurring != nil && item.Price.Recurring.UsageType == stripe.PriceRecurringUsageTypeMetered {
+			items.MeteredItemID = item.ID
+			continue
+		}
+		items.BaseItemID = item.ID
+		items.PriceID = item.Price.ID
+	}
+	return items
+}
+
+// Billing reports whether Stripe invoices a subscription in this status:
+// from when its first invoice is paid until it is cancelled
+func Billing(status stripe.SubscriptionStatus) bool {
+	switch status {
+	case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing, stripe.SubscriptionStatusPastDue, stripe.SubscriptionStatusUnpaid:
+		return true
+	}
+	return false
+}
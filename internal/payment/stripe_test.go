@@ -0,0 +1,198 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/payment/stripestub"
+	"github.com/stripe/stripe-go/v76"
+)
+
+func newStubProvider(t *testing.T) (*StripeProvider, *stripestub.Server) {
+	t.Helper()
+	stub := stripestub.New("whsec_test")
+	t.Cleanup(stub.Close)
+	stub.AddPrice("price_starter", false)
+	stub.AddPrice("price_pro", false)
+	stub.AddPrice("price_units", true)
+	return NewStripeProvider("sk_test_stub", stub.WebhookSecret, stub.URL), stub
+}
+
+func TestStripeSubscriptionLifecycle(t *testing.T) {
+	provider, stub := newStubProvider(t)
+
+	customerID, err := provider.CreateCustomer("org-1", "Acme", "billing@acme.test")
+	if err != nil {
+		t.Fatalf("CreateCustomer() error = %v", err)
+	}
+
+	sub, err := provider.CreateSubscription(SubscriptionParams{
+		OrganizationID: "org-1",
+		CustomerID:     customerID,
+		PriceID:        "price_starter",
+		MeteredPriceID: "price_units",
+	})
+	if err != nil {
+		t.Fatalf("CreateSubscription() error = %v", err)
+	}
+	if sub.Status != stripe.SubscriptionStatusIncomplete || sub.LatestInvoice == nil || sub.LatestInvoice.HostedInvoiceURL == "" {
+		t.Errorf("subscription without a card = %s with invoice %+v, want incomplete with a hosted invoice", sub.Status, sub.LatestInvoice)
+	}
+	if sub.Metadata["organization_id"] != "org-1" {
+		t.Errorf("metadata = %v, want the organization ID", sub.Metadata)
+	}
+
+	items := Items(sub)
+	if items.BaseItemID == "" || items.MeteredItemID == "" || items.PriceID != "price_starter" {
+		t.Fatalf("Items() = %+v, want a starter base item and a metered item", items)
+	}
+
+	sub, err = provider.ChangeSubscriptionPrice(sub.ID, items.BaseItemID, "price_pro")
+	if err != nil {
+		t.Fatalf("ChangeSubscriptionPrice() error = %v", err)
+	}
+	if got := Items(sub); got.PriceID != "price_pro" || got.BaseItemID != items.BaseItemID {
+		t.Errorf("Items() after the change = %+v, want the same item on price_pro", got)
+	}
+
+	if got, err := provider.GetSubscription(sub.ID); err != nil || Items(got).PriceID != "price_pro" {
+		t.Errorf("GetSubscription() = %+v, %v, want the subscription on price_pro", got, err)
+	}
+
+	sub, err = provider.CancelSubscription(sub.ID, true)
+	if err != nil {
+		t.Fatalf("CancelSubscription() error = %v", err)
+	}
+	if !sub.CancelAtPeriodEnd {
+		t.Error("subscription is not cancelled at the end of the period")
+	}
+	if stored := stub.Subscription(sub.ID); stored["cancel_at_period_end"] != true {
+		t.Errorf("stub subscription = %v, want it cancelled at period end", stored)
+	}
+
+	if _, err := provider.CreateSubscription(SubscriptionParams{CustomerID: customerID, PriceID: "price_missing"}); err == nil {
+		t.Error("CreateSubscription() with an unknown price succeeded")
+	}
+}
+
+func TestStripeSubscriptionWithSavedCard(t *testing.T) {
+	provider, _ := newStubProvider(t)
+
+	customerID, err := provider.CreateCustomer("org-1", "Acme", "billing@acme.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := provider.CreateSubscription(SubscriptionParams{
+		CustomerID:      customerID,
+		PriceID:         "price_pro",
+		PaymentMethodID: "pm_card_visa",
+	})
+	if err != nil {
+		t.Fatalf("CreateSubscription() error = %v", err)
+	}
+	if sub.Status != stripe.SubscriptionStatusActive || !Billing(sub.Status) {
+		t.Errorf("status = %s, want active", sub.Status)
+	}
+	if Items(sub).MeteredItemID != "" {
+		t.Error("subscription without a metered price has a metered item")
+	}
+}
+
+func TestStripeReportUsage(t *testing.T) {
+	provider, stub := newStubProvider(t)
+
+	customerID, _ := provider.CreateCustomer("org-1", "Acme", "billing@acme.test")
+	sub, err := provider.CreateSubscription(SubscriptionParams{
+		CustomerID:     customerID,
+		PriceID:        "price_starter",
+		MeteredPriceID: "price_units",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := Items(sub)
+
+	bucket := time.Date(2026, 11, 3, 14, 0, 0, 0, time.UTC)
+	if err := provider.ReportUsage(items.MeteredItemID, bucket, 1250); err != nil {
+		t.Fatalf("ReportUsage() error = %v", err)
+	}
+	records := stub.UsageRecords()
+	want := stripestub.UsageRecord{SubscriptionItem: items.MeteredItemID, Quantity: 1250, Timestamp: bucket.Unix(), Action: "set"}
+	if len(records) != 1 || records[0] != want {
+		t.Errorf("usage records = %+v, want %+v", records, want)
+	}
+
+	if err := provider.ReportUsage(items.BaseItemID, bucket, 1); err == nil {
+		t.Error("ReportUsage() on the base fee item succeeded")
+	}
+}
+
+func TestStripeChargeSaved(t *testing.T) {
+	provider, stub := newStubProvider(t)
+
+	params := ChargeSavedParams{
+		CustomerID:      "cus_1",
+		PaymentMethodID: "pm_1",
+		Amount:          4950,
+		Currency:        "usd",
+		Metadata:        map[string]string{"billing_cycle_id": "cycle-1"},
+	}
+	if _, err := provider.ChargeSaved(params); err != nil {
+		t.Fatalf("ChargeSaved() error = %v", err)
+	}
+	if charges := stub.Charges(); len(charges) != 1 || charges[0].Amount != 4950 || charges[0].Metadata["billing_cycle_id"] != "cycle-1" {
+		t.Errorf("charges = %+v", charges)
+	}
+
+	stub.DeclineCharges = true
+	if _, err := provider.ChargeSaved(params); !errors.Is(err, ErrChargeDeclined) {
+		t.Errorf("ChargeSaved() of a declined card error = %v, want ErrChargeDeclined", err)
+	}
+}
+
+func TestStripeChargeSavedIdempotent(t *testing.T) {
+	provider, stub := newStubProvider(t)
+
+	params := ChargeSavedParams{
+		CustomerID:      "cus_1",
+		PaymentMethodID: "pm_1",
+		Amount:          4950,
+		Currency:        "usd",
+		IdempotencyKey:  "trial-org-1",
+	}
+	first, err := provider.ChargeSaved(params)
+	if err != nil {
+		t.Fatalf("ChargeSaved() error = %v", err)
+	}
+	again, err := provider.ChargeSaved(params)
+	if err != nil {
+		t.Fatalf("repeated ChargeSaved() error = %v", err)
+	}
+	if again != first || len(stub.Charges()) != 1 {
+		t.Errorf("repeated charge made %d charges, intent %s then %s", len(stub.Charges()), first, again)
+	}
+
+	params.Amount = 5000
+	if _, err := provider.ChargeSaved(params); !errors.Is(err, ErrChargeConflict) {
+		t.Errorf("ChargeSaved() with a reused key error = %v, want ErrChargeConflict", err)
+	}
+}
+
+func TestStripeWebhookFromStub(t *testing.T) {
+	provider, stub := newStubProvider(t)
+
+	payload, signature := stub.Event("customer.subscription.updated", map[string]interface{}{"id": "sub_1", "object": "subscription"})
+	event, err := provider.VerifyWebhookSignature(payload, signature)
+	if err != nil {
+		t.Fatalf("VerifyWebhookSignature() error = %v", err)
+	}
+	if event.Type != "customer.subscription.updated" {
+		t.Errorf("event type = %s", event.Type)
+	}
+
+	other := NewStripeProvider("sk_test_stub", "whsec_other", stub.URL)
+	if _, err := other.VerifyWebhookSignature(payload, signature); err == nil {
+		t.Error("event verified with the wrong secret")
+	}
+}
@@ -0,0 +1,437 @@
+// Package stripestub is a local stand-in for the parts of the Stripe API the
+// payment provider calls, so billing tests run without reaching Stripe. It
+// keeps customers, subscriptions and usage records in memory and signs
+// webhook events the way Stripe does.
+package stripestub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/webhook"
+)
+
+// UsageRecord is a usage report received for a metered subscription item
+type UsageRecord struct {
+	SubscriptionItem string
+	Quantity         int64
+	Timestamp        int64
+	Action           string
+}
+
+// Charge is an off-session payment intent received
+type Charge struct {
+	ID            string
+	Customer      string
+	PaymentMethod string
+	Amount        int64
+	Currency      string
+	Metadata      map[string]string
+}
+
+type object = map[string]interface{}
+
+type Server struct {
+	*httptest.Server
+	WebhookSecret string
+	// DeclineCharges makes payment intents fail as a declined card would
+	DeclineCharges bool
+
+	mu            sync.Mutex
+	seq           int
+	prices        map[string]object
+	customers     map[string]object
+	subscriptions map[string]object
+	setupIntents  map[string]object
+	usage         []UsageRecord
+	charges       []Charge
+	// chargeKeys are the charges made under each idempotency key
+	chargeKeys map[string]Charge
+}
+
+// New starts a stub. Close it when done.
+func New(webhookSecret string) *Server {
+	s := &Server{
+		WebhookSecret: webhookSecret,
+		prices:        map[string]object{},
+		customers:     map[string]object{},
+		subscriptions: map[string]object{},
+		setupIntents:  map[string]object{},
+		chargeKeys:    map[string]Charge{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// AddPrice registers a monthly Price subscriptions can use. Metered Prices
+// are billed on reported usage, the others per subscription.
+func (s *Server) AddPrice(id string, metered bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usageType := "licensed"
+	if metered {
+		usageType = "metered"
+	}
+	s.prices[id] = object{
+		"id":     id,
+		"object": "price",
+		"active": true,
+		"recurring": object{
+			"interval":   "month",
+			"usage_type": usageType,
+		},
+	}
+}
+
+// Subscription returns a subscription as the API would, or nil
+func (s *Server) Subscription(id string) object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subscriptions[id]
+}
+
+// SetSubscriptionStatus changes a subscription as Stripe would when its
+// invoices are paid or not
+func (s *Server) SetSubscriptionStatus(id string, status stripe.SubscriptionStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub, ok := s.subscriptions[id]; ok {
+		sub["status"] = string(status)
+	}
+}
+
+// UsageRecords returns the usage reported so far
+func (s *Server) UsageRecords() []UsageRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]UsageRecord(nil), s.usage...)
+}
+
+// Charges returns the payment intents created so far
+func (s *Server) Charges() []Charge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Charge(nil), s.charges...)
+}
+
+// Event builds a webhook event carrying obj and signs it with the webhook
+// secret. It returns the payload and its Stripe-Signature header.
+func (s *Server) Event(eventType string, obj interface{}) ([]byte, string) {
+	s.mu.Lock()
+	id := s.nextID("evt")
+	s.mu.Unlock()
+
+	payload, err := json.Marshal(object{
+		"id":          id,
+		"object":      "event",
+		"api_version": stripe.APIVersion,
+		"created":     time.Now().Unix(),
+		"type":        eventType,
+		"data":        object{"object": obj},
+	})
+	if err != nil {
+		panic(err)
+	}
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
+		Payload: payload,
+		Secret:  s.WebhookSecret,
+	})
+	return payload, signed.Header
+}
+
+var (
+	subscriptionPath = regexp.MustCompile(`^/v1/subscriptions/([^/]+)$`)
+	usagePath        = regexp.MustCompile(`^/v1/subscription_items/([^/]+)/usage_records$`)
+	setupIntentPath  = regexp.MustCompile(`^/v1/setup_intents/([^/]+)$`)
+)
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && path == "/v1/customers":
+		s.createCustomer(w, r)
+	case r.Method == http.MethodPost && path == "/v1/checkout/sessions":
+		s.createCheckoutSession(w, r)
+	case r.Method == http.MethodPost && path == "/v1/payment_intents":
+		s.createPaymentIntent(w, r)
+	case r.Method == http.MethodPost && path == "/v1/subscriptions":
+		s.createSubscription(w, r)
+	case subscriptionPath.MatchString(path):
+		s.subscription(w, r, subscriptionPath.FindStringSubmatch(path)[1])
+	case r.Method == http.MethodPost && usagePath.MatchString(path):
+		s.createUsageRecord(w, r, usagePath.FindStringSubmatch(path)[1])
+	case r.Method == http.MethodGet && setupIntentPath.MatchString(path):
+		s.getSetupIntent(w, setupIntentPath.FindStringSubmatch(path)[1])
+	default:
+		writeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "Unrecognized request URL ("+r.Method+": "+path+")")
+	}
+}
+
+func (s *Server) createCustomer(w http.ResponseWriter, r *http.Request) {
+	customer := object{
+		"id":       s.nextID("cus"),
+		"object":   "customer",
+		"name":     r.PostForm.Get("name"),
+		"email":    r.PostForm.Get("email"),
+		"metadata": formMap(r, "metadata"),
+	}
+	s.customers[customer["id"].(string)] = customer
+	writeJSON(w, customer)
+}
+
+func (s *Server) createCheckoutSession(w http.ResponseWriter, r *http.Request) {
+	id := s.nextID("cs")
+	session := object{
+		"id":       id,
+		"object":   "checkout.session",
+		"mode":     r.PostForm.Get("mode"),
+		"url":      "https://checkout.stripe.test/" + id,
+		"metadata": formMap(r, "metadata"),
+	}
+	if customer := r.PostForm.Get("customer"); customer != "" {
+		session["customer"] = customer
+	}
+	if r.PostForm.Get("mode") == string(stripe.CheckoutSessionModeSetup) {
+		intent := object{
+			"id":       s.nextID("seti"),
+			"object":   "setup_intent",
+			"status":   "succeeded",
+			"customer": r.PostForm.Get("customer"),
+			"payment_method": object{
+				"id":     s.nextID("pm"),
+				"object": "payment_method",
+				"type":   "card",
+				"card":   object{"brand": "visa", "last4": "4242"},
+			},
+		}
+		s.setupIntents[intent["id"].(string)] = intent
+		session["setup_intent"] = intent["id"]
+	}
+	writeJSON(w, session)
+}
+
+func (s *Server) getSetupIntent(w http.ResponseWriter, id string) {
+	intent, ok := s.setupIntents[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such setupintent: '"+id+"'")
+		return
+	}
+	writeJSON(w, intent)
+}
+
+func (s *Server) createPaymentIntent(w http.ResponseWriter, r *http.Request) {
+	amount, _ := strconv.ParseInt(r.PostForm.Get("amount"), 10, 64)
+	charge := Charge{
+		ID:            s.nextID("pi"),
+		Customer:      r.PostForm.Get("customer"),
+		PaymentMethod: r.PostForm.Get("payment_method"),
+		Amount:        amount,
+		Currency:      r.PostForm.Get("currency"),
+		Metadata:      formMap(r, "metadata"),
+	}
+	// A repeated idempotency key replays the first charge, or is refused if
+	// the request differs from it
+	key := r.Header.Get("Idempotency-Key")
+	if earlier, ok := s.chargeKeys[key]; ok && key != "" {
+		if earlier.Customer != charge.Customer || earlier.PaymentMethod != charge.PaymentMethod ||
+			earlier.Amount != charge.Amount || earlier.Currency != charge.Currency {
+			writeError(w, http.StatusBadRequest, "idempotency_error", "", "Keys for idempotent requests can only be used with the same parameters they were first used with.")
+			return
+		}
+		charge = earlier
+	} else {
+		if s.DeclineCharges {
+			writeError(w, http.StatusPaymentRequired, "card_error", "card_declined", "Your card was declined.")
+			return
+		}
+		s.charges = append(s.charges, charge)
+		if key != "" {
+			s.chargeKeys[key] = charge
+		}
+	}
+	writeJSON(w, object{
+		"id":       charge.ID,
+		"object":   "payment_intent",
+		"amount":   charge.Amount,
+		"currency": charge.Currency,
+		"customer": charge.Customer,
+		"status":   "succeeded",
+		"metadata": charge.Metadata,
+	})
+}
+
+func (s *Server) createSubscription(w http.ResponseWriter, r *http.Request) {
+	customer := r.PostForm.Get("customer")
+	if _, ok := s.customers[customer]; !ok {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "resource_missing", "No such customer: '"+customer+"'")
+		return
+	}
+
+	var items []interface{}
+	for i := 0; ; i++ {
+		priceID := r.PostForm.Get(fmt.Sprintf("items[%d][price]", i))
+		if priceID == "" {
+			break
+		}
+		price, ok := s.prices[priceID]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "resource_missing", "No such price: '"+priceID+"'")
+			return
+		}
+		items = append(items, object{"id": s.nextID("si"), "object": "subscription_item", "price": price})
+	}
+
+	// A subscription with a card to charge is paid for at once; otherwise
+	// its first invoice waits on its hosted page
+	status, invoiceStatus := "incomplete", "open"
+	if r.PostForm.Get("default_payment_method") != "" {
+		status, invoiceStatus = "active", "paid"
+	}
+
+	now := time.Now()
+	id := s.nextID("sub")
+	invoiceID := s.nextID("in")
+	sub := object{
+		"id":                   id,
+		"object":               "subscription",
+		"customer":             customer,
+		"status":               status,
+		"cancel_at_period_end": false,
+		"current_period_start": now.Unix(),
+		"current_period_end":   now.AddDate(0, 1, 0).Unix(),
+		"metadata":             formMap(r, "metadata"),
+		"items":                object{"object": "list", "data": items},
+		"latest_invoice": object{
+			"id":                 invoiceID,
+			"object":             "invoice",
+			"status":             invoiceStatus,
+			"subscription":       id,
+			"hosted_invoice_url": "https://invoice.stripe.test/" + invoiceID,
+		},
+	}
+	s.subscriptions[id] = sub
+	writeJSON(w, sub)
+}
+
+func (s *Server) subscription(w http.ResponseWriter, r *http.Request, id string) {
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "resource_missing", "No such subscription: '"+id+"'")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if cancel := r.PostForm.Get("cancel_at_period_end"); cancel != "" {
+			sub["cancel_at_period_end"] = cancel == "true"
+		}
+		items := sub["items"].(object)["data"].([]interface{})
+		for i := 0; ; i++ {
+			itemID := r.PostForm.Get(fmt.Sprintf("items[%d][id]", i))
+			if itemID == "" {
+				break
+			}
+			priceID := r.PostForm.Get(fmt.Sprintf("items[%d][price]", i))
+			price, ok := s.prices[priceID]
+			if !ok {
+				writeError(w, http.StatusBadRequest, "invalid_request_error", "resource_missing", "No such price: '"+priceID+"'")
+				return
+			}
+			for _, item := range items {
+				if item.(object)["id"] == itemID {
+					item.(object)["price"] = price
+				}
+			}
+		}
+	case http.MethodDelete:
+		sub["status"] = string(stripe.SubscriptionStatusCanceled)
+		sub["ended_at"] = time.Now().Unix()
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "Method not allowed")
+		return
+	}
+	writeJSON(w, sub)
+}
+
+func (s *Server) createUsageRecord(w http.ResponseWriter, r *http.Request, itemID string) {
+	if !s.meteredItem(itemID) {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "", "Usage can only be reported on metered subscription items")
+		return
+	}
+
+	record := UsageRecord{SubscriptionItem: itemID, Action: r.PostForm.Get("action")}
+	record.Quantity, _ = strconv.ParseInt(r.PostForm.Get("quantity"), 10, 64)
+	record.Timestamp, _ = strconv.ParseInt(r.PostForm.Get("timestamp"), 10, 64)
+	s.usage = append(s.usage, record)
+
+	writeJSON(w, object{
+		"id":                s.nextID("mbur"),
+		"object":            "usage_record",
+		"quantity":          record.Quantity,
+		"subscription_item": itemID,
+		"timestamp":         record.Timestamp,
+	})
+}
+
+func (s *Server) meteredItem(itemID string) bool {
+	for _, sub := range s.subscriptions {
+		for _, item := range sub["items"].(object)["data"].([]interface{}) {
+			item := item.(object)
+			if item["id"] == itemID {
+				return item["price"].(object)["recurring"].(object)["usage_type"] == "metered"
+			}
+		}
+	}
+	return false
+}
+
+func (s *Server) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s_stub%d", prefix, s.seq)
+}
+
+// formMap reads a form-encoded hash such as metadata[key]=value
+func formMap(r *http.Request, name string) map[string]string {
+	values := map[string]string{}
+	for key := range r.PostForm {
+		if strings.HasPrefix(key, name+"[") && strings.HasSuffix(key, "]") {
+			values[key[len(name)+1:len(key)-1]] = r.PostForm.Get(key)
+		}
+	}
+	return values
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, errType, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(object{
+		"error": object{
+			"type":    errType,
+			"code":    code,
+			"message": message,
+		},
+	})
+}
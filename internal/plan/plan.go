@@ -0,0 +1,366 @@
+// Package plan keeps the history of the plans organizations are on, so a
+// period spent on more than one plan is billed for its time on each, and
+// prices moving between plans part way through a period
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// Segment is a stretch of a period spent on one plan, from Start up to but
+// not including End. The base fee from Start to PrepaidUntil was invoiced
+// when the plan was upgraded to; PrepaidUntil is zero otherwise. Nothing
+// used on a Trial is invoiced, and a segment BilledByStripe is invoiced by
+// its Stripe subscription instead.
+type Segment struct {
+	Plan           database.PlanType
+	Start          time.Time
+	End            time.Time
+	PrepaidUntil   time.Time
+	Trial          bool
+	BilledByStripe bool
+}
+
+// Segments splits start to end by the plan history overlapping it, ordered
+// by when each plan started. Time before the first entry is on that entry's
+// plan, and a period without any history is on current throughout.
+func Segments(history []database.OrganizationPlanHistory, current database.PlanType, start, end time.Time) []Segment {
+	if len(history) == 0 {
+		return []Segment{{Plan: current, Start: start, End: end}}
+	}
+
+	segments := make([]Segment, 0, len(history))
+	for i, entry := range history {
+		segment := Segment{
+			Plan:           entry.Plan,
+			Start:          entry.StartedAt.Time,
+			End:            end,
+			Trial:          entry.Trial,
+			BilledByStripe: entry.BilledByStripe,
+		}
+		if i == 0 || segment.Start.Before(start) {
+			segment.Start = start
+		}
+		if i+1 < len(history) {
+			segment.End = history[i+1].StartedAt.Time
+		}
+		if segment.End.After(end) {
+			segment.End = end
+		}
+		if entry.PrepaidUntil.Valid {
+			segment.PrepaidUntil = entry.PrepaidUntil.Time
+		}
+		if segment.End.After(segment.Start) {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return []Segment{{Plan: current, Start: start, End: end}}
+	}
+	return segments
+}
+
+// Share is the part of start to end the segment covers
+func (s Segment) Share(start, end time.Time) decimal.Decimal {
+	return fraction(s.End.Sub(s.Start), end.Sub(start))
+}
+
+// BaseFeeShare is the part of start to end the segment still owes its plan's
+// base fee for: the time it covers less any the base fee was prepaid for
+func (s Segment) BaseFeeShare(start, end time.Time) decimal.Decimal {
+	owed := s.End.Sub(s.Start)
+	if s.PrepaidUntil.After(s.Start) {
+		owed -= earliest(s.PrepaidUntil, s.End).Sub(s.Start)
+	}
+	return fraction(owed, end.Sub(start))
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func fraction(part, whole time.Duration) decimal.Decimal {
+	if whole <= 0 || part <= 0 {
+		return decimal.Zero
+	}
+	return decimal.NewFromInt(int64(part)).Div(decimal.NewFromInt(int64(whole)))
+}
+
+// IsUpgrade reports whether moving from one plan to another at periodStart's
+// prices is an upgrade, which takes effect at once: a plan whose base fee is
+// no lower. Anything else is a downgrade, which waits for the next period.
+func IsUpgrade(catalog *pricing.Catalog, from, to database.PlanType, periodStart time.Time) bool {
+	book := catalog.BookAt(periodStart)
+	return book.Plans[to].BaseFee.GreaterThanOrEqual(book.Plans[from].BaseFee)
+}
+
+// UpgradeCharge prices upgrading from current to a plan at at, in the period
+// start to end: the new plan's base fee for the rest of the period less
+// what is left of current's base fee if that was prepaid. Usage is billed
+// with the period as usual.
+func UpgradeCharge(catalog *pricing.Catalog, current Segment, to database.PlanType, at, start, end time.Time) pricing.Quote {
+	book := catalog.BookAt(start)
+	fee := book.Plans[to].BaseFee.Mul(fraction(end.Sub(at), end.Sub(start)))
+	if current.PrepaidUntil.After(at) {
+		unused := earliest(current.PrepaidUntil, end).Sub(at)
+		fee = fee.Sub(book.Plans[current.Plan].BaseFee.Mul(fraction(unused, end.Sub(start))))
+	}
+	fee = decimal.Max(fee, decimal.Zero).Round(2)
+
+	return pricing.Quote{
+		Version:  book.Version,
+		Currency: book.Currency,
+		Plan:     to,
+		Model:    pricing.ModelFlat,
+		BaseFee:  fee,
+		Lines:    []pricing.QuoteLine{},
+		Total:    fee,
+		From:     &at,
+		Until:    &end,
+	}
+}
+
+// Load returns the segments of the period starting at periodStart
+func Load(ctx context.Context, db *database.Queries, orgID uuid.UUID, current database.PlanType, periodStart time.Time) ([]Segment, error) {
+	periodEnd := periodStart.AddDate(0, 1, 0)
+	history, err := db.ListOrganizationPlanHistory(ctx, database.ListOrganizationPlanHistoryParams{
+		OrganizationID: orgID,
+		StartTime:      pgtype.Timestamp{Time: periodStart, Valid: true},
+		EndTime:        pgtype.Timestamp{Time: periodEnd, Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list plan history: %w", err)
+	}
+	return Segments(history, current, periodStart, periodEnd), nil
+}
+
+// Quote prices the usage of the period starting at periodStart, up to
+// until, on the plans the organization was on. Each plan gets its share of
+// its included units and base fee, and trials are free. Usage is split
+// between plans on the hour: the hour a plan changed in is counted on the
+// new plan. It also returns the usage by message type and the request
+// count, neither of which includes trials.
+func Quote(ctx context.Context, db *database.Queries, catalog *pricing.Catalog, orgID uuid.UUID, current database.PlanType, periodStart, until time.Time) (pricing.Quote, pricing.Usage, int64, error) {
+	usage, err := LoadUsage(ctx, db, orgID, current, periodStart, until)
+	if err != nil {
+		return pricing.Quote{}, nil, 0, err
+	}
+	total, requests := usage.Total()
+	return usage.Quote(catalog, nil), total, requests, nil
+}
+
+// PeriodUsage is an organization's usage in part of a period, split by the
+// plan it was on
+type PeriodUsage struct {
+	periodStart time.Time
+	segments    []Segment
+	// usage is nil for segments that are not priced
+	usage    []pricing.Usage
+	requests int64
+}
+
+// LoadUsage reads the usage of the period starting at periodStart, up to
+// until, on each plan the organization was on. Trials and time Stripe
+// billed are not counted.
+func LoadUsage(ctx context.Context, db *database.Queries, orgID uuid.UUID, current database.PlanType, periodStart, until time.Time) (PeriodUsage, error) {
+	segments, err := Load(ctx, db, orgID, current, periodStart)
+	if err != nil {
+		return PeriodUsage{}, err
+	}
+
+	result := PeriodUsage{periodStart: periodStart}
+	for i, segment := range segments {
+		from := segment.Start.Truncate(time.Hour)
+		if i == 0 {
+			from = segment.Start
+		}
+		if from.After(until) {
+			break
+		}
+		result.segments = append(result.segments, segment)
+		if segment.Trial || segment.BilledByStripe {
+			result.usage = append(result.usage, nil)
+			continue
+		}
+		to := until
+		if i+1 < len(segments) {
+			to = earliest(until, segments[i+1].Start.Truncate(time.Hour).Add(-time.Microsecond))
+		}
+
+		rows, err := db.GetUsageByMessageType(ctx, database.GetUsageByMessageTypeParams{
+			OrganizationID: orgID,
+			StartTime:      pgtype.Timestamp{Time: from, Valid: true},
+			EndTime:        pgtype.Timestamp{Time: to, Valid: true},
+		})
+		if err != nil {
+			return PeriodUsage{}, fmt.Errorf("failed to count usage: %w", err)
+		}
+		usage, count := pricing.UsageFromRows(rows)
+		result.usage = append(result.usage, usage)
+		result.requests += count
+	}
+	return result, nil
+}
+
+// Total returns the usage by message type and the request count
+func (u PeriodUsage) Total() (pricing.Usage, int64) {
+	total := pricing.Usage{}
+	for _, usage := range u.usage {
+		for messageType, units := range usage {
+			total[messageType] += units
+		}
+	}
+	return total, u.requests
+}
+
+// Quote prices the usage like the period's invoice. extra is usage still
+// to come, priced on the plan the organization is on last.
+func (u PeriodUsage) Quote(catalog *pricing.Catalog, extra pricing.Usage) pricing.Quote {
+	periodEnd := u.periodStart.AddDate(0, 1, 0)
+	quotes := make([]pricing.Quote, 0, len(u.segments))
+	for i, segment := range u.segments {
+		if segment.Trial || segment.BilledByStripe {
+			quote := catalog.QuoteShare(segment.Plan, u.periodStart, pricing.Usage{}, segment.Share(u.periodStart, periodEnd), decimal.Zero)
+			quote.Trial = segment.Trial
+			quote.BilledByStripe = segment.BilledByStripe
+			if len(u.segments) > 1 {
+				quote.From, quote.Until = &segment.Start, &segment.End
+			}
+			quotes = append(quotes, quote)
+			continue
+		}
+
+		usage := u.usage[i]
+		if i == len(u.segments)-1 && len(extra) > 0 {
+			usage = make(pricing.Usage, len(u.usage[i])+len(extra))
+			for _, add := range []pricing.Usage{u.usage[i], extra} {
+				for messageType, units := range add {
+					usage[messageType] += units
+				}
+			}
+		}
+		quote := catalog.QuoteShare(segment.Plan, u.periodStart, usage,
+			segment.Share(u.periodStart, periodEnd), segment.BaseFeeShare(u.periodStart, periodEnd))
+		if len(u.segments) > 1 {
+			quote.From, quote.Until = &segment.Start, &segment.End
+		}
+		quotes = append(quotes, quote)
+	}
+	return pricing.Combine(quotes)
+}
+
+// Change is a move to another plan
+type Change struct {
+	Plan database.PlanType
+	At   time.Time
+	// PrepaidUntil and ProrationCycleID are set on upgrades whose base fee
+	// was invoiced up front
+	PrepaidUntil     time.Time
+	ProrationCycleID uuid.UUID
+	ChangedBy        uuid.UUID
+	// Trial is set when the plan is started as a trial
+	Trial bool
+	// BilledByStripe is set when a Stripe subscription invoices the plan
+	BilledByStripe bool
+}
+
+// Apply ends the organization's current plan at the change, records the new
+// one and switches the organization to it. db must be bound to a
+// transaction so the history and the plan never disagree.
+func Apply(ctx context.Context, db *database.Queries, org database.Organization, change Change) (database.Organization, error) {
+	at := pgtype.Timestamp{Time: change.At, Valid: true}
+	ended, err := db.EndCurrentPlanHistory(ctx, database.EndCurrentPlanHistoryParams{
+		OrganizationID: org.ID,
+		EndedAt:        at,
+	})
+	if err != nil {
+		return database.Organization{}, fmt.Errorf("failed to end current plan: %w", err)
+	}
+
+	// Organizations that have not changed plan since they were created have
+	// no history yet: their plan dates from their creation
+	if ended == 0 {
+		_, err := db.CreatePlanHistory(ctx, database.CreatePlanHistoryParams{
+			OrganizationID: org.ID,
+			Plan:           org.Plan,
+			StartedAt:      org.CreatedAt,
+		})
+		if err != nil {
+			return database.Organization{}, fmt.Errorf("failed to record current plan: %w", err)
+		}
+		if _, err := db.EndCurrentPlanHistory(ctx, database.EndCurrentPlanHistoryParams{
+			OrganizationID: org.ID,
+			EndedAt:        at,
+		}); err != nil {
+			return database.Organization{}, fmt.Errorf("failed to end current plan: %w", err)
+		}
+	}
+
+	params := database.CreatePlanHistoryParams{
+		OrganizationID: org.ID,
+		Plan:           change.Plan,
+		StartedAt:      at,
+		ChangedBy:      optionalUUID(change.ChangedBy),
+		Trial:          change.Trial,
+		BilledByStripe: change.BilledByStripe,
+	}
+	if !change.PrepaidUntil.IsZero() {
+		params.PrepaidUntil = pgtype.Timestamp{Time: change.PrepaidUntil, Valid: true}
+		params.ProrationCycleID = optionalUUID(change.ProrationCycleID)
+	}
+	if _, err := db.CreatePlanHistory(ctx, params); err != nil {
+		return database.Organization{}, fmt.Errorf("failed to record plan change: %w", err)
+	}
+
+	updated, err := db.UpdateOrganizationPlan(ctx, database.UpdateOrganizationPlanParams{
+		Plan: change.Plan,
+		ID:   org.ID,
+	})
+	if err != nil {
+		return database.Organization{}, fmt.Errorf("failed to update plan: %w", err)
+	}
+	return updated, nil
+}
+
+// Current returns the organization's current plan as a segment of the period
+// starting at periodStart. db must be bound to a transaction: the entry
+// stays locked until it ends so concurrent changes queue up.
+func Current(ctx context.Context, db *database.Queries, org database.Organization, periodStart time.Time) (Segment, error) {
+	current := Segment{Plan: org.Plan, Start: periodStart, End: periodStart.AddDate(0, 1, 0)}
+	entry, err := db.GetCurrentPlanHistoryForUpdate(ctx, org.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return current, nil
+	}
+	if err != nil {
+		return Segment{}, fmt.Errorf("failed to get current plan: %w", err)
+	}
+	if entry.StartedAt.Time.After(periodStart) {
+		current.Start = entry.StartedAt.Time
+	}
+	if entry.PrepaidUntil.Valid {
+		current.PrepaidUntil = entry.PrepaidUntil.Time
+	}
+	current.Trial = entry.Trial
+	current.BilledByStripe = entry.BilledByStripe
+	return current, nil
+}
+
+func optionalUUID(id uuid.UUID) pgtype.UUID {
+	if id == uuid.Nil {
+		return pgtype.UUID{}
+	}
+	return pgtype.UUID{Bytes: id, Valid: true}
+}
@@ -0,0 +1,141 @@
+package plan
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/pricing"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	periodStart = time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	periodEnd   = periodStart.AddDate(0, 1, 0)
+	// midPeriod is halfway through November
+	midPeriod = periodStart.AddDate(0, 0, 15)
+)
+
+func timestamp(t time.Time) pgtype.Timestamp {
+	return pgtype.Timestamp{Time: t, Valid: true}
+}
+
+func TestSegments(t *testing.T) {
+	history := []database.OrganizationPlanHistory{
+		{Plan: database.PlanTypeStarter, StartedAt: timestamp(periodStart.AddDate(0, -3, 0)), EndedAt: timestamp(midPeriod)},
+		{Plan: database.PlanTypePro, StartedAt: timestamp(midPeriod), PrepaidUntil: timestamp(periodEnd)},
+	}
+
+	segments := Segments(history, database.PlanTypePro, periodStart, periodEnd)
+	want := []Segment{
+		{Plan: database.PlanTypeStarter, Start: periodStart, End: midPeriod},
+		{Plan: database.PlanTypePro, Start: midPeriod, End: periodEnd, PrepaidUntil: periodEnd},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("Segments() = %+v, want %+v", segments, want)
+	}
+	for i := range want {
+		if segments[i] != want[i] {
+			t.Errorf("segment %d = %+v, want %+v", i, segments[i], want[i])
+		}
+	}
+
+	trial := []database.OrganizationPlanHistory{
+		{Plan: database.PlanTypeFree, StartedAt: timestamp(periodStart.AddDate(0, -3, 0)), EndedAt: timestamp(midPeriod)},
+		{Plan: database.PlanTypePro, StartedAt: timestamp(midPeriod), Trial: true},
+	}
+	if got := Segments(trial, database.PlanTypePro, periodStart, periodEnd); len(got) != 2 || got[0].Trial || !got[1].Trial {
+		t.Errorf("Segments() with a trial = %+v, want the second segment on trial", got)
+	}
+
+	stripe := []database.OrganizationPlanHistory{
+		{Plan: database.PlanTypeStarter, StartedAt: timestamp(periodStart.AddDate(0, -3, 0)), EndedAt: timestamp(midPeriod)},
+		{Plan: database.PlanTypeStarter, StartedAt: timestamp(midPeriod), BilledByStripe: true},
+	}
+	if got := Segments(stripe, database.PlanTypeStarter, periodStart, periodEnd); len(got) != 2 || got[0].BilledByStripe || !got[1].BilledByStripe {
+		t.Errorf("Segments() with a Stripe subscription = %+v, want the second segment billed by Stripe", got)
+	}
+
+	if got := Segments(nil, database.PlanTypeFree, periodStart, periodEnd); len(got) != 1 || got[0].Plan != database.PlanTypeFree {
+		t.Errorf("Segments() without history = %+v, want the whole period on free", got)
+	}
+
+	// An organization created during the period is on its first plan from
+	// the start of it
+	created := []database.OrganizationPlanHistory{{Plan: database.PlanTypeStarter, StartedAt: timestamp(midPeriod)}}
+	if got := Segments(created, database.PlanTypeStarter, periodStart, periodEnd); len(got) != 1 || !got[0].Start.Equal(periodStart) {
+		t.Errorf("Segments() of a new organization = %+v, want one from the period start", got)
+	}
+}
+
+func TestShares(t *testing.T) {
+	half := decimal.RequireFromString("0.5")
+	starter := Segment{Plan: database.PlanTypeStarter, Start: periodStart, End: midPeriod}
+	pro := Segment{Plan: database.PlanTypePro, Start: midPeriod, End: periodEnd, PrepaidUntil: periodEnd}
+
+	if got := starter.Share(periodStart, periodEnd); !got.Equal(half) {
+		t.Errorf("Share() = %s, want 0.5", got)
+	}
+	if got := starter.BaseFeeShare(periodStart, periodEnd); !got.Equal(half) {
+		t.Errorf("BaseFeeShare() = %s, want 0.5", got)
+	}
+	if got := pro.BaseFeeShare(periodStart, periodEnd); !got.IsZero() {
+		t.Errorf("BaseFeeShare() of a prepaid segment = %s, want 0", got)
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	catalog := pricing.Default()
+	if !IsUpgrade(catalog, database.PlanTypeStarter, database.PlanTypePro, periodStart) {
+		t.Error("starter to pro should be an upgrade")
+	}
+	if IsUpgrade(catalog, database.PlanTypePro, database.PlanTypeFree, periodStart) {
+		t.Error("pro to free should be a downgrade")
+	}
+}
+
+func TestUpgradeCharge(t *testing.T) {
+	catalog := pricing.Default()
+
+	// Half of pro's $99 for the second half of the month
+	starter := Segment{Plan: database.PlanTypeStarter, Start: periodStart, End: periodEnd}
+	quote := UpgradeCharge(catalog, starter, database.PlanTypePro, midPeriod, periodStart, periodEnd)
+	if !quote.Total.Equal(decimal.RequireFromString("49.5")) || quote.Plan != database.PlanTypePro {
+		t.Errorf("UpgradeCharge() = %s on %s, want 49.50 on pro", quote.Total, quote.Plan)
+	}
+
+	// Moving on from a plan prepaid to the end of the period credits what is
+	// left of it
+	prepaid := Segment{Plan: database.PlanTypeStarter, Start: midPeriod, End: periodEnd, PrepaidUntil: periodEnd}
+	quote = UpgradeCharge(catalog, prepaid, database.PlanTypePro, midPeriod, periodStart, periodEnd)
+	if !quote.Total.Equal(decimal.NewFromInt(35)) {
+		t.Errorf("UpgradeCharge() from a prepaid plan = %s, want 35", quote.Total)
+	}
+}
+
+func TestPeriodUsageQuote(t *testing.T) {
+	catalog := pricing.Default()
+	usage := PeriodUsage{
+		periodStart: periodStart,
+		segments: []Segment{
+			{Plan: database.PlanTypeStarter, Start: periodStart, End: midPeriod},
+			{Plan: database.PlanTypePro, Start: midPeriod, End: periodEnd},
+		},
+		usage:    []pricing.Usage{{"sms": 1000}, {"sms": 1000}},
+		requests: 2000,
+	}
+
+	// 1000 units at starter's $0.01 and 1000 at pro's $0.005
+	if got := usage.Quote(catalog, nil).UsageAmount(); !got.Equal(decimal.NewFromInt(15)) {
+		t.Errorf("Quote() usage charges = %s, want 15", got)
+	}
+
+	// Usage still to come is priced on pro
+	if got := usage.Quote(catalog, pricing.Usage{"sms": 2000}).UsageAmount(); !got.Equal(decimal.NewFromInt(25)) {
+		t.Errorf("Quote() with 2000 more units = %s, want 25", got)
+	}
+	if total, requests := usage.Total(); total["sms"] != 2000 || requests != 2000 {
+		t.Errorf("Total() = %v, %d, want 2000 sms units and 2000 requests", total, requests)
+	}
+}
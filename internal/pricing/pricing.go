@@ -0,0 +1,291 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/shopspring/decimal"
+)
+
+// DefaultVersion is the built-in price book. It applies to every period
+// before the first configured book takes effect.
+const DefaultVersion = "2025-01"
+
+// Pricing models for the units a plan does not price with a per-type rate
+const (
+	// ModelFlat charges every unit at UnitRate
+	ModelFlat = "flat"
+	// ModelGraduated charges the units in each tier at that tier's rate
+	ModelGraduated = "graduated"
+	// ModelVolume charges every unit at the rate of the tier the total reaches
+	ModelVolume = "volume"
+)
+
+// Tier is one band of a graduated or volume plan. UpTo is the last unit in
+// the band; the final tier leaves it at 0 and covers everything above.
+type Tier struct {
+	UpTo     int64           `json:"up_to,omitempty"`
+	UnitRate decimal.Decimal `json:"unit_rate"`
+}
+
+// PlanPrice is what a plan costs under one price book
+type PlanPrice struct {
+	BaseFee decimal.Decimal `json:"base_fee"`
+	// IncludedUnits are free each period before any unit is charged
+	IncludedUnits int64           `json:"included_units"`
+	UnitRate      decimal.Decimal `json:"unit_rate"`
+	// MessageTypeRates override UnitRate for specific message types
+	MessageTypeRates map[string]decimal.Decimal `json:"message_type_rates,omitempty"`
+	// Model is flat unless Tiers are given. Tier boundaries count billable
+	// units, after included units, of every message type without its own rate.
+	Model string `json:"pricing_model,omitempty"`
+	Tiers []Tier `json:"tiers,omitempty"`
+}
+
+// tiered reports whether units without a per-type rate go through tiers
+func (p PlanPrice) tiered() bool {
+	return p.Model == ModelGraduated || p.Model == ModelVolume
+}
+
+// Rate returns the per-unit rate for a message type. On a tiered plan this
+// is the first tier's rate, which is what a single extra unit costs at the
+// start of a period.
+func (p PlanPrice) Rate(messageType string) decimal.Decimal {
+	if rate, ok := p.MessageTypeRates[messageType]; ok {
+		return rate
+	}
+	if p.tiered() && len(p.Tiers) > 0 {
+		return p.Tiers[0].UnitRate
+	}
+	return p.UnitRate
+}
+
+func (p PlanPrice) validate() error {
+	if p.BaseFee.IsNegative() || p.UnitRate.IsNegative() || p.IncludedUnits < 0 {
+		return fmt.Errorf("negative price")
+	}
+	for messageType, rate := range p.MessageTypeRates {
+		if rate.IsNegative() {
+			return fmt.Errorf("negative %s rate", messageType)
+		}
+	}
+
+	switch p.Model {
+	case "", ModelFlat:
+		if len(p.Tiers) > 0 {
+			return fmt.Errorf("tiers need a graduated or volume pricing model")
+		}
+		return nil
+	case ModelGraduated, ModelVolume:
+	default:
+		return fmt.Errorf("unknown pricing model %q", p.Model)
+	}
+
+	if len(p.Tiers) == 0 {
+		return fmt.Errorf("%s pricing needs tiers", p.Model)
+	}
+	var previous int64
+	for i, tier := range p.Tiers {
+		if tier.UnitRate.IsNegative() {
+			return fmt.Errorf("tier %d has a negative rate", i+1)
+		}
+		last := i == len(p.Tiers)-1
+		if last && tier.UpTo != 0 {
+			return fmt.Errorf("the last tier must have no upper bound")
+		}
+		if !last && tier.UpTo <= previous {
+			return fmt.Errorf("tier %d must end after unit %d", i+1, previous)
+		}
+		previous = tier.UpTo
+	}
+	return nil
+}
+
+// PriceBook is a versioned set of plan prices that applies from
+// EffectiveFrom until the next book takes effect
+type PriceBook struct {
+	Version       string                          `json:"version"`
+	EffectiveFrom time.Time                       `json:"effective_from"`
+	Currency      string                          `json:"currency"`
+	Plans         map[database.PlanType]PlanPrice `json:"plans"`
+}
+
+// DefaultBook returns the built-in prices: the free plan includes 1000 units
+// and then charges $0.01 each, starter is $29 plus $0.01 per unit and pro is
+// $99 plus $0.005 per unit.
+func DefaultBook() PriceBook {
+	return PriceBook{
+		Version:  DefaultVersion,
+		Currency: "USD",
+		Plans: map[database.PlanType]PlanPrice{
+			database.PlanTypeFree: {
+				BaseFee:       decimal.Zero,
+				IncludedUnits: 1000,
+				UnitRate:      decimal.RequireFromString("0.01"),
+			},
+			database.PlanTypeStarter: {
+				BaseFee:  decimal.NewFromInt(29),
+				UnitRate: decimal.RequireFromString("0.01"),
+			},
+			database.PlanTypePro: {
+				BaseFee:  decimal.NewFromInt(99),
+				UnitRate: decimal.RequireFromString("0.005"),
+			},
+		},
+	}
+}
+
+func (b PriceBook) validate() error {
+	if b.Version == "" {
+		return fmt.Errorf("price book has no version")
+	}
+	if len(b.Currency) != 3 {
+		return fmt.Errorf("price book %s: currency must be a 3-letter code", b.Version)
+	}
+	for _, plan := range []database.PlanType{database.PlanTypeFree, database.PlanTypeStarter, database.PlanTypePro} {
+		price, ok := b.Plans[plan]
+		if !ok {
+			return fmt.Errorf("price book %s: no price for plan %s", b.Version, plan)
+		}
+		if err := price.validate(); err != nil {
+			return fmt.Errorf("price book %s: plan %s: %w", b.Version, plan, err)
+		}
+	}
+	return nil
+}
+
+// Catalog holds every known price book ordered by effective date
+type Catalog struct {
+	books []PriceBook
+}
+
+// NewCatalog validates the books and orders them by effective date. Books
+// are normalised to UTC so they compare with billing periods.
+func NewCatalog(books ...PriceBook) (*Catalog, error) {
+	if len(books) == 0 {
+		return nil, fmt.Errorf("no price books")
+	}
+
+	sorted := make([]PriceBook, 0, len(books))
+	versions := make(map[string]bool)
+	for _, book := range books {
+		book.Currency = strings.ToUpper(book.Currency)
+		book.EffectiveFrom = book.EffectiveFrom.UTC()
+		if err := book.validate(); err != nil {
+			return nil, err
+		}
+		if versions[book.Version] {
+			return nil, fmt.Errorf("price book %s is defined twice", book.Version)
+		}
+		versions[book.Version] = true
+		sorted = append(sorted, book)
+	}
+
+	slices.SortFunc(sorted, func(a, b PriceBook) int {
+		return a.EffectiveFrom.Compare(b.EffectiveFrom)
+	})
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].EffectiveFrom.Equal(sorted[i-1].EffectiveFrom) {
+			return nil, fmt.Errorf("price books %s and %s take effect at the same time", sorted[i-1].Version, sorted[i].Version)
+		}
+	}
+
+	return &Catalog{books: sorted}, nil
+}
+
+// Default returns a catalog holding only the built-in book
+func Default() *Catalog {
+	return &Catalog{books: []PriceBook{DefaultBook()}}
+}
+
+// BookStore reads published price books. *database.Queries satisfies it.
+type BookStore interface {
+	ListPriceBooks(ctx context.Context) ([]database.PriceBook, error)
+}
+
+// Load builds the catalog from the built-in book, the books in the JSON file
+// at path (if any) and the books published in the database. A configured
+// book with the built-in version replaces it.
+func Load(ctx context.Context, store BookStore, path string) (*Catalog, error) {
+	books := []PriceBook{}
+
+	if path != "" {
+		fromFile, err := LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, fromFile...)
+	}
+
+	if store != nil {
+		rows, err := store.ListPriceBooks(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list price books: %w", err)
+		}
+		for _, row := range rows {
+			book := PriceBook{
+				Version:       row.Version,
+				EffectiveFrom: row.EffectiveFrom.Time,
+				Currency:      row.Currency,
+			}
+			if err := json.Unmarshal(row.Plans, &book.Plans); err != nil {
+				return nil, fmt.Errorf("price book %s has invalid plans: %w", row.Version, err)
+			}
+			books = append(books, book)
+		}
+	}
+
+	if !slices.ContainsFunc(books, func(b PriceBook) bool { return b.Version == DefaultVersion }) {
+		books = append(books, DefaultBook())
+	}
+
+	return NewCatalog(books...)
+}
+
+// LoadFile reads a JSON array of price books
+func LoadFile(path string) ([]PriceBook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price book file: %w", err)
+	}
+
+	var books []PriceBook
+	if err := json.Unmarshal(data, &books); err != nil {
+		return nil, fmt.Errorf("invalid price book file %s: %w", path, err)
+	}
+	for i := range books {
+		if books[i].Currency == "" {
+			books[i].Currency = "USD"
+		}
+	}
+	return books, nil
+}
+
+// Books returns every book ordered by effective date
+func (c *Catalog) Books() []PriceBook {
+	return slices.Clone(c.books)
+}
+
+// BookAt returns the book in effect at t. Times before the first book fall
+// back to the earliest one.
+func (c *Catalog) BookAt(t time.Time) PriceBook {
+	book := c.books[0]
+	for _, candidate := range c.books[1:] {
+		if candidate.EffectiveFrom.After(t) {
+			break
+		}
+		book = candidate
+	}
+	return book
+}
+
+// UnitRate returns what one unit of a message type costs on a plan at t
+func (c *Catalog) UnitRate(plan database.PlanType, at time.Time, messageType string) decimal.Decimal {
+	return c.BookAt(at).Plans[plan].Rate(messageType)
+}
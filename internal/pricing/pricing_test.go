@@ -0,0 +1,357 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+func TestDefaultBookQuotes(t *testing.T) {
+	catalog := Default()
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		plan     database.PlanType
+		requests int64
+		want     string
+	}{
+		{"Free within included units", database.PlanTypeFree, 999, "0"},
+		{"Free at included units", database.PlanTypeFree, 1000, "0"},
+		{"Free over included units", database.PlanTypeFree, 1500, "5"},
+		{"Starter with no usage", database.PlanTypeStarter, 0, "29"},
+		{"Starter with usage", database.PlanTypeStarter, 1000, "39"},
+		{"Pro with usage", database.PlanTypePro, 1000, "104"},
+		{"Unknown plan", database.PlanType("enterprise"), 1000, "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote := catalog.Quote(tt.plan, start, Usage{"": tt.requests})
+			if !quote.Total.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("Quote().Total = %s, want %s", quote.Total, tt.want)
+			}
+			if quote.Version != DefaultVersion {
+				t.Errorf("Quote().Version = %q, want %q", quote.Version, DefaultVersion)
+			}
+		})
+	}
+}
+
+func testBook(version string, from time.Time, starterRate string) PriceBook {
+	book := DefaultBook()
+	book.Version = version
+	book.EffectiveFrom = from
+	book.Plans = map[database.PlanType]PlanPrice{
+		database.PlanTypeFree:    book.Plans[database.PlanTypeFree],
+		database.PlanTypeStarter: {BaseFee: decimal.NewFromInt(29), UnitRate: decimal.RequireFromString(starterRate)},
+		database.PlanTypePro:     book.Plans[database.PlanTypePro],
+	}
+	return book
+}
+
+func TestBookAtEffectiveDates(t *testing.T) {
+	november := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	catalog, err := NewCatalog(DefaultBook(), testBook("2026-11", november, "0.02"))
+	if err != nil {
+		t.Fatalf("NewCatalog() error = %v", err)
+	}
+
+	if got := catalog.BookAt(november.Add(-time.Second)).Version; got != DefaultVersion {
+		t.Errorf("before November = %q, want %q", got, DefaultVersion)
+	}
+	if got := catalog.BookAt(november).Version; got != "2026-11" {
+		t.Errorf("from November = %q, want 2026-11", got)
+	}
+
+	rate := catalog.UnitRate(database.PlanTypeStarter, november.AddDate(0, 1, 0), "sms")
+	if !rate.Equal(decimal.RequireFromString("0.02")) {
+		t.Errorf("UnitRate() = %s, want 0.02", rate)
+	}
+}
+
+func TestQuoteMessageTypeRates(t *testing.T) {
+	book := DefaultBook()
+	free := book.Plans[database.PlanTypeFree]
+	free.MessageTypeRates = map[string]decimal.Decimal{
+		"sms":   decimal.RequireFromString("0.02"),
+		"email": decimal.RequireFromString("0.001"),
+	}
+	book.Plans[database.PlanTypeFree] = free
+
+	catalog, err := NewCatalog(book)
+	if err != nil {
+		t.Fatalf("NewCatalog() error = %v", err)
+	}
+
+	quote := catalog.Quote(database.PlanTypeFree, time.Now(), Usage{"sms": 600, "email": 2000, "": 100})
+
+	// The 1000 included units go to sms (600), then untyped usage (100),
+	// then email (300)
+	want := map[string]struct {
+		included, billable int64
+		amount             string
+	}{
+		"":      {100, 0, "0"},
+		"email": {300, 1700, "1.7"},
+		"sms":   {600, 0, "0"},
+	}
+	if len(quote.Lines) != len(want) {
+		t.Fatalf("lines = %+v", quote.Lines)
+	}
+	for _, line := range quote.Lines {
+		w := want[line.MessageType]
+		if line.IncludedUnits != w.included || line.BillableUnits != w.billable || !line.Amount.Equal(decimal.RequireFromString(w.amount)) {
+			t.Errorf("line %q = %+v, want %+v", line.MessageType, line, w)
+		}
+	}
+	if quote.Lines[0].MessageType != "" || quote.Lines[2].MessageType != "sms" {
+		t.Errorf("lines not ordered by message type: %+v", quote.Lines)
+	}
+	if !quote.Total.Equal(decimal.RequireFromString("1.7")) {
+		t.Errorf("Total = %s, want 1.7", quote.Total)
+	}
+}
+
+func TestNewCatalogRejectsInvalidBooks(t *testing.T) {
+	start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	missingPlan := testBook("missing", start, "0.01")
+	delete(missingPlan.Plans, database.PlanTypePro)
+
+	negative := testBook("negative", start, "-0.01")
+
+	tests := []struct {
+		name  string
+		books []PriceBook
+	}{
+		{"No books", nil},
+		{"Missing plan", []PriceBook{missingPlan}},
+		{"Negative rate", []PriceBook{negative}},
+		{"Duplicate version", []PriceBook{testBook("a", start, "0.01"), testBook("a", start.AddDate(0, 1, 0), "0.01")}},
+		{"Same effective date", []PriceBook{testBook("a", start, "0.01"), testBook("b", start, "0.02")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCatalog(tt.books...); err == nil {
+				t.Error("NewCatalog() error = nil, want an error")
+			}
+		})
+	}
+}
+
+type fakeBookStore struct {
+	books []database.PriceBook
+}
+
+func (f fakeBookStore) ListPriceBooks(ctx context.Context) ([]database.PriceBook, error) {
+	return f.books, nil
+}
+
+func TestLoadMergesFileAndDatabase(t *testing.T) {
+	december := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+	january := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	path := filepath.Join(t.TempDir(), "prices.json")
+	file := `[{
+		"version": "2026-12",
+		"effective_from": "2026-12-01T00:00:00Z",
+		"plans": {
+			"free": {"base_fee": "0", "included_units": 500, "unit_rate": "0.01"},
+			"starter": {"base_fee": 29, "unit_rate": "0.01", "message_type_rates": {"email": "0.002"}},
+			"pro": {"base_fee": "99", "unit_rate": 0.005}
+		}
+	}]`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	plans, _ := json.Marshal(testBook("2027-01", january, "0.03").Plans)
+	store := fakeBookStore{books: []database.PriceBook{{
+		Version:       "2027-01",
+		EffectiveFrom: pgtype.Timestamp{Time: january, Valid: true},
+		Currency:      "usd",
+		Plans:         plans,
+	}}}
+
+	catalog, err := Load(context.Background(), store, path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	books := catalog.Books()
+	if len(books) != 3 {
+		t.Fatalf("books = %d, want the default, file and database books", len(books))
+	}
+	if books[1].Currency != "USD" || books[2].Currency != "USD" {
+		t.Errorf("currencies = %q, %q", books[1].Currency, books[2].Currency)
+	}
+
+	if got := catalog.UnitRate(database.PlanTypeStarter, december, "email"); !got.Equal(decimal.RequireFromString("0.002")) {
+		t.Errorf("December email rate = %s, want 0.002", got)
+	}
+	if got := catalog.UnitRate(database.PlanTypeStarter, january, "email"); !got.Equal(decimal.RequireFromString("0.03")) {
+		t.Errorf("January email rate = %s, want 0.03", got)
+	}
+	if got := catalog.Quote(database.PlanTypeFree, december, Usage{"": 600}).Total; !got.Equal(decimal.NewFromInt(1)) {
+		t.Errorf("December free quote = %s, want 1", got)
+	}
+}
+
+func tieredBook(model string) PriceBook {
+	book := DefaultBook()
+	book.Plans[database.PlanTypePro] = PlanPrice{
+		BaseFee: decimal.NewFromInt(99),
+		Model:   model,
+		Tiers: []Tier{
+			{UpTo: 10000, UnitRate: decimal.RequireFromString("0.01")},
+			{UpTo: 100000, UnitRate: decimal.RequireFromString("0.006")},
+			{UnitRate: decimal.RequireFromString("0.004")},
+		},
+		MessageTypeRates: map[string]decimal.Decimal{"sms": decimal.RequireFromString("0.02")},
+	}
+	return book
+}
+
+func TestQuoteGraduatedTiers(t *testing.T) {
+	catalog, err := NewCatalog(tieredBook(ModelGraduated))
+	if err != nil {
+		t.Fatalf("NewCatalog() error = %v", err)
+	}
+
+	quote := catalog.Quote(database.PlanTypePro, time.Now(), Usage{"email": 100000, "": 50000, "sms": 10})
+
+	wantTiers := []TierLine{
+		{Tier: 1, From: 1, UpTo: 10000, Units: 10000, Amount: decimal.NewFromInt(100)},
+		{Tier: 2, From: 10001, UpTo: 100000, Units: 90000, Amount: decimal.NewFromInt(540)},
+		{Tier: 3, From: 100001, UpTo: 0, Units: 50000, Amount: decimal.NewFromInt(200)},
+	}
+	if len(quote.Tiers) != len(wantTiers) {
+		t.Fatalf("tiers = %+v", quote.Tiers)
+	}
+	for i, want := range wantTiers {
+		got := quote.Tiers[i]
+		if got.Tier != want.Tier || got.From != want.From || got.UpTo != want.UpTo || got.Units != want.Units || !got.Amount.Equal(want.Amount) {
+			t.Errorf("tier %d = %+v, want %+v", i+1, got, want)
+		}
+	}
+
+	// sms has its own rate and stays out of the tiers
+	var lineTotal decimal.Decimal
+	for _, line := range quote.Lines {
+		lineTotal = lineTotal.Add(line.Amount)
+		if line.MessageType == "sms" && (line.Tiered || !line.Amount.Equal(decimal.RequireFromString("0.2"))) {
+			t.Errorf("sms line = %+v, want 0.2 at its own rate", line)
+		}
+	}
+	if !lineTotal.Equal(decimal.RequireFromString("840.2")) {
+		t.Errorf("lines add up to %s, want 840.2", lineTotal)
+	}
+	if !quote.Total.Equal(decimal.RequireFromString("939.2")) {
+		t.Errorf("Total = %s, want 939.2", quote.Total)
+	}
+	if quote.Model != ModelGraduated {
+		t.Errorf("Model = %q", quote.Model)
+	}
+}
+
+func TestQuoteVolumeTiers(t *testing.T) {
+	catalog, err := NewCatalog(tieredBook(ModelVolume))
+	if err != nil {
+		t.Fatalf("NewCatalog() error = %v", err)
+	}
+
+	tests := []struct {
+		units int64
+		tier  int
+		total string
+	}{
+		{5000, 1, "149"},
+		{10000, 1, "199"},
+		{10001, 2, "159.006"},
+		{150000, 3, "699"},
+	}
+
+	for _, tt := range tests {
+		quote := catalog.Quote(database.PlanTypePro, time.Now(), Usage{"": tt.units})
+		if len(quote.Tiers) != 1 || quote.Tiers[0].Tier != tt.tier || quote.Tiers[0].Units != tt.units {
+			t.Errorf("%d units: tiers = %+v, want all in tier %d", tt.units, quote.Tiers, tt.tier)
+		}
+		// Amounts are rounded to cents
+		want := decimal.RequireFromString(tt.total).Round(2)
+		if !quote.Total.Equal(want) {
+			t.Errorf("%d units: Total = %s, want %s", tt.units, quote.Total, want)
+		}
+	}
+}
+
+func TestTieredPlanValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		price PlanPrice
+	}{
+		{"Tiers on a flat plan", PlanPrice{Tiers: []Tier{{UnitRate: decimal.Zero}}}},
+		{"Graduated without tiers", PlanPrice{Model: ModelGraduated}},
+		{"Unknown model", PlanPrice{Model: "stairstep", Tiers: []Tier{{UnitRate: decimal.Zero}}}},
+		{"Bounded last tier", PlanPrice{Model: ModelVolume, Tiers: []Tier{{UpTo: 10, UnitRate: decimal.Zero}}}},
+		{"Tiers out of order", PlanPrice{Model: ModelGraduated, Tiers: []Tier{
+			{UpTo: 100, UnitRate: decimal.Zero},
+			{UpTo: 50, UnitRate: decimal.Zero},
+			{UnitRate: decimal.Zero},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := DefaultBook()
+			book.Plans[database.PlanTypePro] = tt.price
+			if _, err := NewCatalog(book); err == nil {
+				t.Error("NewCatalog() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestQuoteShareAndCombine(t *testing.T) {
+	catalog := Default()
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	half := decimal.RequireFromString("0.5")
+
+	// Half a month on free: half the included units
+	free := catalog.QuoteShare(database.PlanTypeFree, start, Usage{"": 800}, half, half)
+	if free.Lines[0].IncludedUnits != 500 || !free.Total.Equal(decimal.NewFromInt(3)) {
+		t.Errorf("free half: included %d, total %s, want 500, 3", free.Lines[0].IncludedUnits, free.Total)
+	}
+
+	// Half a month on pro, its base fee invoiced in advance
+	pro := catalog.QuoteShare(database.PlanTypePro, start, Usage{"": 1000}, half, decimal.Zero)
+	if !pro.BaseFee.IsZero() || !pro.Total.Equal(decimal.NewFromInt(5)) {
+		t.Errorf("pro prepaid: base fee %s, total %s, want 0, 5", pro.BaseFee, pro.Total)
+	}
+
+	third := decimal.NewFromInt(1).Div(decimal.NewFromInt(3))
+	starter := catalog.QuoteShare(database.PlanTypeStarter, start, Usage{}, third, third)
+	if !starter.BaseFee.Equal(decimal.RequireFromString("9.67")) {
+		t.Errorf("starter third: base fee %s, want 9.67", starter.BaseFee)
+	}
+
+	combined := Combine([]Quote{free, pro})
+	if combined.Plan != database.PlanTypePro || len(combined.Segments) != 2 || len(combined.Lines) != 2 {
+		t.Errorf("Combine() = plan %s, %d segments, %d lines, want pro, 2, 2", combined.Plan, len(combined.Segments), len(combined.Lines))
+	}
+	if !combined.Total.Equal(decimal.NewFromInt(8)) {
+		t.Errorf("Combine().Total = %s, want 8", combined.Total)
+	}
+
+	if single := Combine([]Quote{free}); single.Segments != nil || !single.Total.Equal(free.Total) {
+		t.Errorf("Combine() of one segment = %+v, want the segment", single)
+	}
+}
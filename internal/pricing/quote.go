@@ -0,0 +1,267 @@
+package pricing
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/shopspring/decimal"
+)
+
+// Usage is the billable units used in a period by message type. Usage with
+// no message type is recorded under "".
+type Usage map[string]int64
+
+// UsageFromRows collects units by message type from the usage query and
+// returns them with the total request count
+func UsageFromRows(rows []database.GetUsageByMessageTypeRow) (Usage, int64) {
+	usage := make(Usage, len(rows))
+	var requests int64
+	for _, row := range rows {
+		usage[row.MessageType] += row.Units
+		requests += row.RequestCount
+	}
+	return usage, requests
+}
+
+// Total returns the units across every message type
+func (u Usage) Total() int64 {
+	var total int64
+	for _, units := range u {
+		total += units
+	}
+	return total
+}
+
+// QuoteLine is the charge for one message type. Lines priced through tiers
+// carry their share of the tier charges and the average rate it works out to.
+type QuoteLine struct {
+	MessageType   string          `json:"message_type"`
+	Units         int64           `json:"units"`
+	IncludedUnits int64           `json:"included_units"`
+	BillableUnits int64           `json:"billable_units"`
+	UnitRate      decimal.Decimal `json:"unit_rate"`
+	Amount        decimal.Decimal `json:"amount"`
+	Tiered        bool            `json:"tiered,omitempty"`
+}
+
+// TierLine is how many billable units fell in one tier. UpTo is 0 for the
+// open-ended last tier.
+type TierLine struct {
+	Tier     int             `json:"tier"`
+	From     int64           `json:"from"`
+	UpTo     int64           `json:"up_to"`
+	Units    int64           `json:"units"`
+	UnitRate decimal.Decimal `json:"unit_rate"`
+	Amount   decimal.Decimal `json:"amount"`
+}
+
+// Quote is a priced period. Line and tier amounts are rounded to cents and
+// Total is the base fee plus every line. A period spent on more than one
+// plan is quoted for each plan separately: Segments holds those quotes,
+// each covering From to Until, and the quote adds them up. Trial segments
+// are free, and so are segments a Stripe subscription bills for.
+type Quote struct {
+	Version        string            `json:"price_book_version"`
+	Currency       string            `json:"currency"`
+	Plan           database.PlanType `json:"plan"`
+	Model          string            `json:"pricing_model"`
+	BaseFee        decimal.Decimal   `json:"base_fee"`
+	Lines          []QuoteLine       `json:"lines"`
+	Tiers          []TierLine        `json:"tiers,omitempty"`
+	Total          decimal.Decimal   `json:"total"`
+	From           *time.Time        `json:"from,omitempty"`
+	Until          *time.Time        `json:"until,omitempty"`
+	Trial          bool              `json:"trial,omitempty"`
+	BilledByStripe bool              `json:"billed_by_stripe,omitempty"`
+	Segments       []Quote           `json:"segments,omitempty"`
+}
+
+// UsageAmount returns the total less the base fee
+func (q Quote) UsageAmount() decimal.Decimal {
+	return q.Total.Sub(q.BaseFee)
+}
+
+// Quote prices a period's usage on a plan with the book in effect at the
+// start of the period. Included units are used up by the most expensive
+// message types first. On a tiered plan the remaining units of every message
+// type without its own rate are pooled and priced through the tiers.
+func (c *Catalog) Quote(plan database.PlanType, periodStart time.Time, usage Usage) Quote {
+	one := decimal.NewFromInt(1)
+	return c.QuoteShare(plan, periodStart, usage, one, one)
+}
+
+// QuoteShare prices usage on a plan for part of a period. The plan's
+// included units are cut to share of the period, rounded down, and its base
+// fee to baseFeeShare, which leaves out any part of the base fee already
+// invoiced.
+func (c *Catalog) QuoteShare(plan database.PlanType, periodStart time.Time, usage Usage, share, baseFeeShare decimal.Decimal) Quote {
+	book := c.BookAt(periodStart)
+	price := book.Plans[plan]
+	price.IncludedUnits = decimal.NewFromInt(price.IncludedUnits).Mul(share).Floor().IntPart()
+	price.BaseFee = price.BaseFee.Mul(baseFeeShare).Round(2)
+
+	lines := make([]QuoteLine, 0, len(usage))
+	for messageType, units := range usage {
+		if units <= 0 {
+			continue
+		}
+		_, ownRate := price.MessageTypeRates[messageType]
+		lines = append(lines, QuoteLine{
+			MessageType: messageType,
+			Units:       units,
+			UnitRate:    price.Rate(messageType),
+			Tiered:      price.tiered() && !ownRate,
+		})
+	}
+
+	slices.SortFunc(lines, func(a, b QuoteLine) int {
+		if cmp := b.UnitRate.Cmp(a.UnitRate); cmp != 0 {
+			return cmp
+		}
+		return strings.Compare(a.MessageType, b.MessageType)
+	})
+
+	remaining := price.IncludedUnits
+	total := price.BaseFee
+	var pooled int64
+	for i := range lines {
+		line := &lines[i]
+		line.IncludedUnits = min(line.Units, remaining)
+		remaining -= line.IncludedUnits
+		line.BillableUnits = line.Units - line.IncludedUnits
+		if line.Tiered {
+			pooled += line.BillableUnits
+			continue
+		}
+		line.Amount = decimal.NewFromInt(line.BillableUnits).Mul(line.UnitRate).Round(2)
+		total = total.Add(line.Amount)
+	}
+
+	slices.SortFunc(lines, func(a, b QuoteLine) int {
+		return strings.Compare(a.MessageType, b.MessageType)
+	})
+
+	model := ModelFlat
+	var tiers []TierLine
+	if price.tiered() {
+		model = price.Model
+		tiers = priceTiers(price, pooled)
+		pooledAmount := decimal.Zero
+		for _, tier := range tiers {
+			pooledAmount = pooledAmount.Add(tier.Amount)
+		}
+		shareTierCharges(lines, pooled, pooledAmount)
+		total = total.Add(pooledAmount)
+	}
+
+	return Quote{
+		Version:  book.Version,
+		Currency: book.Currency,
+		Plan:     plan,
+		Model:    model,
+		BaseFee:  price.BaseFee,
+		Lines:    lines,
+		Tiers:    tiers,
+		Total:    total,
+	}
+}
+
+// Combine adds up the quotes of the segments of a period, in order. A
+// single segment is the period's quote as it is.
+func Combine(segments []Quote) Quote {
+	if len(segments) == 1 {
+		return segments[0]
+	}
+
+	last := segments[len(segments)-1]
+	combined := Quote{
+		Version:  last.Version,
+		Currency: last.Currency,
+		Plan:     last.Plan,
+		Model:    last.Model,
+		Lines:    []QuoteLine{},
+		Segments: segments,
+	}
+	for _, segment := range segments {
+		combined.BaseFee = combined.BaseFee.Add(segment.BaseFee)
+		combined.Lines = append(combined.Lines, segment.Lines...)
+		combined.Tiers = append(combined.Tiers, segment.Tiers...)
+		combined.Total = combined.Total.Add(segment.Total)
+	}
+	return combined
+}
+
+// priceTiers works out which of the pooled units fall in which tier
+func priceTiers(price PlanPrice, units int64) []TierLine {
+	if units <= 0 {
+		return nil
+	}
+
+	var tiers []TierLine
+	from := int64(1)
+	for i, tier := range price.Tiers {
+		upTo := tier.UpTo
+		inTier := units - from + 1
+		if upTo != 0 {
+			inTier = min(inTier, upTo-from+1)
+		}
+
+		switch price.Model {
+		case ModelVolume:
+			// Every unit is charged at the rate of the tier the total lands in
+			if upTo == 0 || units <= upTo {
+				return []TierLine{{
+					Tier:     i + 1,
+					From:     from,
+					UpTo:     upTo,
+					Units:    units,
+					UnitRate: tier.UnitRate,
+					Amount:   decimal.NewFromInt(units).Mul(tier.UnitRate).Round(2),
+				}}
+			}
+		case ModelGraduated:
+			tiers = append(tiers, TierLine{
+				Tier:     i + 1,
+				From:     from,
+				UpTo:     upTo,
+				Units:    inTier,
+				UnitRate: tier.UnitRate,
+				Amount:   decimal.NewFromInt(inTier).Mul(tier.UnitRate).Round(2),
+			})
+			if upTo == 0 || units <= upTo {
+				return tiers
+			}
+		}
+		from = upTo + 1
+	}
+	return tiers
+}
+
+// shareTierCharges splits the tier charges across the tiered lines in
+// proportion to their billable units. The last line takes the rounding
+// difference so the lines add up to the tier charges exactly.
+func shareTierCharges(lines []QuoteLine, pooled int64, amount decimal.Decimal) {
+	last := -1
+	allocated := decimal.Zero
+	for i := range lines {
+		line := &lines[i]
+		if !line.Tiered || line.BillableUnits == 0 {
+			continue
+		}
+		line.Amount = amount.Mul(decimal.NewFromInt(line.BillableUnits)).Div(decimal.NewFromInt(pooled)).Round(2)
+		allocated = allocated.Add(line.Amount)
+		last = i
+	}
+	if last >= 0 {
+		lines[last].Amount = lines[last].Amount.Add(amount.Sub(allocated))
+	}
+
+	for i := range lines {
+		line := &lines[i]
+		if line.Tiered && line.BillableUnits > 0 {
+			line.UnitRate = line.Amount.Div(decimal.NewFromInt(line.BillableUnits))
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/redis/go-redis/v9"
+)
+
+// monthlyLimits is the number of API requests each plan may make per calendar
+// month. The free limit matches the 1000 units the default price book
+// includes on the free plan.
+var monthlyLimits = map[database.PlanType]int64{
+	database.PlanTypeFree:    1000,
+	database.PlanTypeStarter: 100000,
+	database.PlanTypePro:     1000000,
+}
+
+// MonthlyLimit returns the monthly request quota for a plan
+func MonthlyLimit(plan database.PlanType) int64 {
+	return monthlyLimits[plan]
+}
+
+// CurrentPeriod returns the first and last second of the UTC calendar month containing t
+func CurrentPeriod(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	periodStart := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	periodEnd := periodStart.AddDate(0, 1, 0).Add(-time.Second)
+	return periodStart, periodEnd
+}
+
+// raiseCounter sets the counter to ARGV[1] only if that is higher than its
+// current value, so reconciling never hides requests Redis has already seen.
+var raiseCounter = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local reconciled = tonumber(ARGV[1])
+if reconciled > current then
+	redis.call('SET', KEYS[1], reconciled)
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return math.max(current, reconciled)
+`)
+
+// Tracker keeps per-organization monthly request counters in Redis and
+// periodically reconciles them against usage_records.
+type Tracker struct {
+	redis         *redis.Client
+	db            *database.Queries
+	syncInterval  time.Duration
+	counterMargin time.Duration
+}
+
+func NewTracker(redisClient *redis.Client, db *database.Queries) *Tracker {
+	return &Tracker{
+		redis:         redisClient,
+		db:            db,
+		syncInterval:  5 * time.Minute,
+		counterMargin: 24 * time.Hour,
+	}
+}
+
+// Increment counts one request against the organization's current period and
+// returns the period usage including that request.
+func (t *Tracker) Increment(ctx context.Context, orgID uuid.UUID) (int64, error) {
+	periodStart, periodEnd := CurrentPeriod(time.Now())
+
+	if err := t.reconcile(ctx, orgID, periodStart, periodEnd); err != nil {
+		return 0, err
+	}
+
+	key := counterKey(orgID, periodStart)
+	count, err := t.redis.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to increment quota counter: %w", err)
+	}
+
+	if count == 1 {
+		t.redis.ExpireAt(ctx, key, periodEnd.Add(t.counterMargin))
+	}
+
+	return count, nil
+}
+
+// Decrement gives back a request that was counted but not served
+func (t *Tracker) Decrement(ctx context.Context, orgID uuid.UUID) error {
+	periodStart, _ := CurrentPeriod(time.Now())
+	return t.redis.Decr(ctx, counterKey(orgID, periodStart)).Err()
+}
+
+// Usage returns the organization's request count for the current period
+func (t *Tracker) Usage(ctx context.Context, orgID uuid.UUID) (int64, error) {
+	periodStart, periodEnd := CurrentPeriod(time.Now())
+
+	if err := t.reconcile(ctx, orgID, periodStart, periodEnd); err != nil {
+		return 0, err
+	}
+
+	count, err := t.redis.Get(ctx, counterKey(orgID, periodStart)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read quota counter: %w", err)
+	}
+
+	return count, nil
+}
+
+// MarkNotified reports whether this is the first time the organization has
+// been flagged as over quota in the current period.
+func (t *Tracker) MarkNotified(ctx context.Context, orgID uuid.UUID) (bool, error) {
+	periodStart, periodEnd := CurrentPeriod(time.Now())
+	key := fmt.Sprintf("quota_notified:%s:%s", orgID.String(), periodStart.Format("2006-01"))
+
+	first, err := t.redis.SetNX(ctx, key, 1, time.Until(periodEnd.Add(t.counterMargin))).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark quota notification: %w", err)
+	}
+
+	return first, nil
+}
+
+// reconcile raises the Redis counter to the recorded usage at most once per
+// sync interval. This seeds new counters and corrects drift after Redis restarts.
+func (t *Tracker) reconcile(ctx context.Context, orgID uuid.UUID, periodStart, periodEnd time.Time) error {
+	syncKey := fmt.Sprintf("quota_synced:%s:%s", orgID.String(), periodStart.Format("2006-01"))
+
+	due, err := t.redis.SetNX(ctx, syncKey, 1, t.syncInterval).Result()
+	if err != nil {
+		return fmt.Errorf("failed to check quota sync: %w", err)
+	}
+	if !due {
+		return nil
+	}
+
+	recorded, err := t.db.CountOrganizationUsage(ctx, database.CountOrganizationUsageParams{
+		OrganizationID: orgID,
+		StartTime:      pgtype.Timestamp{Time: periodStart, Valid: true},
+		EndTime:        pgtype.Timestamp{Time: periodEnd, Valid: true},
+	})
+	if err != nil {
+		t.redis.Del(ctx, syncKey)
+		return fmt.Errorf("failed to count recorded usage: %w", err)
+	}
+
+	ttl := time.Until(periodEnd.Add(t.counterMargin)).Milliseconds()
+	if err := raiseCounter.Run(ctx, t.redis, []string{counterKey(orgID, periodStart)}, recorded, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to reconcile quota counter: %w", err)
+	}
+
+	return nil
+}
+
+func counterKey(orgID uuid.UUID, periodStart time.Time) string {
+	return fmt.Sprintf("quota:%s:%s", orgID.String(), periodStart.Format("2006-01"))
+}
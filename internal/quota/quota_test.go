@@ -0,0 +1,87 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+)
+
+func TestMonthlyLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		plan database.PlanType
+		want int64
+	}{
+		{
+			name: "Free plan",
+			plan: database.PlanTypeFree,
+			want: 1000,
+		},
+		{
+			name: "Starter plan",
+			plan: database.PlanTypeStarter,
+			want: 100000,
+		},
+		{
+			name: "Pro plan",
+			plan: database.PlanTypePro,
+			want: 1000000,
+		},
+		{
+			name: "Unknown plan",
+			plan: database.PlanType("enterprise"),
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MonthlyLimit(tt.plan); got != tt.want {
+				t.Errorf("MonthlyLimit() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrentPeriod(t *testing.T) {
+	lagos := time.FixedZone("WAT", 60*60)
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "Middle of month",
+			now:       time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC),
+		},
+		{
+			name:      "February in a leap year",
+			now:       time.Date(2028, 2, 10, 0, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2028, 2, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2028, 2, 29, 23, 59, 59, 0, time.UTC),
+		},
+		{
+			name:      "Local time already in next UTC month",
+			now:       time.Date(2026, 5, 1, 0, 30, 0, 0, lagos),
+			wantStart: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 4, 30, 23, 59, 59, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := CurrentPeriod(tt.now)
+			if !start.Equal(tt.wantStart) {
+				t.Errorf("CurrentPeriod() start = %v, want %v", start, tt.wantStart)
+			}
+			if !end.Equal(tt.wantEnd) {
+				t.Errorf("CurrentPeriod() end = %v, want %v", end, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+// Package tax works out the VAT or sales tax charged on an invoice
+package tax
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// Profile is where an organization is taxed
+type Profile struct {
+	Country string
+	// VATID is the organization's VAT or tax identification number. Having
+	// one makes the organization a business customer.
+	VATID           string
+	Exempt          bool
+	ExemptionReason string
+}
+
+// ProfileFromRow converts a stored tax profile
+func ProfileFromRow(row database.OrganizationTaxProfile) Profile {
+	return Profile{
+		Country:         row.Country,
+		VATID:           row.VatID.String,
+		Exempt:          row.TaxExempt,
+		ExemptionReason: row.ExemptionReason.String,
+	}
+}
+
+// Rate is a jurisdiction's standard rate from EffectiveFrom on
+type Rate struct {
+	Country       string
+	EffectiveFrom time.Time
+	Name          string
+	// Percent is the rate in percent, e.g. 19 for 19%
+	Percent decimal.Decimal
+	// ReverseCharge is set where business customers buying from abroad
+	// account for the tax themselves
+	ReverseCharge bool
+}
+
+// RateFromRow converts a stored tax rate
+func RateFromRow(row database.TaxRate) Rate {
+	percent := decimal.Zero
+	if row.Rate.Valid && row.Rate.Int != nil {
+		percent = decimal.NewFromBigInt(row.Rate.Int, row.Rate.Exp)
+	}
+	return Rate{
+		Country:       row.Country,
+		EffectiveFrom: row.EffectiveFrom.Time,
+		Name:          row.Name,
+		Percent:       percent,
+		ReverseCharge: row.ReverseCharge,
+	}
+}
+
+// Validate checks a rate can be stored
+func (r Rate) Validate() error {
+	if err := ValidateCountry(r.Country); err != nil {
+		return err
+	}
+	if r.Name == "" {
+		return fmt.Errorf("tax rate has no name")
+	}
+	if r.Percent.IsNegative() || r.Percent.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+		return fmt.Errorf("tax rate must be at least 0 and below 100 percent")
+	}
+	return nil
+}
+
+// Numeric is the rate's percent as stored
+func (r Rate) Numeric() pgtype.Numeric {
+	return pgtype.Numeric{Int: r.Percent.Coefficient(), Exp: r.Percent.Exponent(), Valid: true}
+}
+
+// Date is the rate's effective date as stored
+func (r Rate) Date() pgtype.Date {
+	return pgtype.Date{Time: r.EffectiveFrom, Valid: true}
+}
+
+// Table holds every known rate by jurisdiction and effective date
+type Table struct {
+	rates map[string][]Rate
+}
+
+// NewTable orders the rates of each jurisdiction by effective date
+func NewTable(rates ...Rate) *Table {
+	t := &Table{rates: map[string][]Rate{}}
+	for _, rate := range rates {
+		t.rates[rate.Country] = append(t.rates[rate.Country], rate)
+	}
+	for _, list := range t.rates {
+		slices.SortFunc(list, func(a, b Rate) int { return a.EffectiveFrom.Compare(b.EffectiveFrom) })
+	}
+	return t
+}
+
+// RateStore reads the configured rates. *database.Queries satisfies it.
+type RateStore interface {
+	ListTaxRates(ctx context.Context) ([]database.TaxRate, error)
+}
+
+// Load builds the table from the rates in the database
+func Load(ctx context.Context, store RateStore) (*Table, error) {
+	rows, err := store.ListTaxRates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tax rates: %w", err)
+	}
+	rates := make([]Rate, 0, len(rows))
+	for _, row := range rows {
+		rates = append(rates, RateFromRow(row))
+	}
+	return NewTable(rates...), nil
+}
+
+// RateAt returns the rate a jurisdiction charged at t. A jurisdiction without
+// a rate in effect is not taxed.
+func (t *Table) RateAt(country string, at time.Time) (Rate, bool) {
+	list := t.rates[country]
+	for i := len(list) - 1; i >= 0; i-- {
+		if !list[i].EffectiveFrom.After(at) {
+			return list[i], true
+		}
+	}
+	return Rate{}, false
+}
+
+// Charge is the tax on one invoice
+type Charge struct {
+	Country string
+	Name    string
+	Percent decimal.Decimal
+	Amount  decimal.Decimal
+	// ReverseCharge and Exempt charges have no amount and are printed as a
+	// note on the invoice
+	ReverseCharge   bool
+	Exempt          bool
+	ExemptionReason string
+}
+
+// Description is how the charge reads as an invoice line
+func (c Charge) Description() string {
+	switch {
+	case c.Exempt && c.ExemptionReason != "":
+		return "Tax exempt: " + c.ExemptionReason
+	case c.Exempt:
+		return "Tax exempt"
+	case c.ReverseCharge:
+		return fmt.Sprintf("%s reverse charge: customer to account for %s (Art. 196 Directive 2006/112/EC)", c.Name, c.Name)
+	default:
+		return fmt.Sprintf("%s %s%% (%s)", c.Name, c.Percent.String(), c.Country)
+	}
+}
+
+// Treatment is how a customer billed at at by a seller established in
+// sellerCountry is taxed, without an amount. Exempt customers pay nothing.
+// Business customers with a VAT ID in a reverse charge jurisdiction other
+// than the seller's account for the tax themselves. Everyone else pays
+// their own jurisdiction's rate. It reports false when the customer has no
+// country or their country has no rate.
+func Treatment(profile Profile, table *Table, sellerCountry string, at time.Time) (Charge, bool) {
+	if profile.Country == "" {
+		return Charge{}, false
+	}
+	if profile.Exempt {
+		return Charge{Country: profile.Country, Amount: decimal.Zero, Exempt: true, ExemptionReason: profile.ExemptionReason}, true
+	}
+
+	rate, ok := table.RateAt(profile.Country, at)
+	if !ok {
+		return Charge{}, false
+	}
+
+	charge := Charge{Country: rate.Country, Name: rate.Name, Percent: rate.Percent, Amount: decimal.Zero}
+	if rate.ReverseCharge && profile.VATID != "" && profile.Country != sellerCountry {
+		charge.ReverseCharge = true
+	}
+	return charge, true
+}
+
+// Calculate works out the tax on subtotal. It reports false when there is
+// no tax to print: no treatment applies or there is nothing to tax.
+func Calculate(profile Profile, table *Table, sellerCountry string, subtotal decimal.Decimal, at time.Time) (Charge, bool) {
+	if !subtotal.IsPositive() {
+		return Charge{}, false
+	}
+	charge, ok := Treatment(profile, table, sellerCountry, at)
+	if !ok {
+		return Charge{}, false
+	}
+	if !charge.Exempt && !charge.ReverseCharge {
+		charge.Amount = subtotal.Mul(charge.Percent).Div(decimal.NewFromInt(100)).Round(2)
+	}
+	return charge, true
+}
+
+var countryPattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// ValidateCountry checks a country is an ISO 3166-1 alpha-2 code
+func ValidateCountry(country string) error {
+	if !countryPattern.MatchString(country) {
+		return fmt.Errorf("country must be a two-letter ISO 3166-1 code")
+	}
+	return nil
+}
+
+// vatIDPatterns are the formats of the VAT and tax identification numbers of
+// each supported jurisdiction, as written with their prefix. They check the
+// shape of a number only; whether it is registered is not checked.
+var vatIDPatterns = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^ATU\d{8}$`),
+	"BE": regexp.MustCompile(`^BE[01]\d{9}$`),
+	"BG": regexp.MustCompile(`^BG\d{9,10}$`),
+	"CY": regexp.MustCompile(`^CY\d{8}[A-Z]$`),
+	"CZ": regexp.MustCompile(`^CZ\d{8,10}$`),
+	"DE": regexp.MustCompile(`^DE\d{9}$`),
+	"DK": regexp.MustCompile(`^DK\d{8}$`),
+	"EE": regexp.MustCompile(`^EE\d{9}$`),
+	"ES": regexp.MustCompile(`^ES[A-Z0-9]\d{7}[A-Z0-9]$`),
+	"FI": regexp.MustCompile(`^FI\d{8}$`),
+	"FR": regexp.MustCompile(`^FR[A-HJ-NP-Z0-9]{2}\d{9}$`),
+	"GR": regexp.MustCompile(`^EL\d{9}$`),
+	"HR": regexp.MustCompile(`^HR\d{11}$`),
+	"HU": regexp.MustCompile(`^HU\d{8}$`),
+	"IE": regexp.MustCompile(`^IE(\d{7}[A-W][A-IW]?|\d[A-Z+*]\d{5}[A-W])$`),
+	"IT": regexp.MustCompile(`^IT\d{11}$`),
+	"LT": regexp.MustCompile(`^LT(\d{9}|\d{12})$`),
+	"LU": regexp.MustCompile(`^LU\d{8}$`),
+	"LV": regexp.MustCompile(`^LV\d{11}$`),
+	"MT": regexp.MustCompile(`^MT\d{8}$`),
+	"NL": regexp.MustCompile(`^NL\d{9}B\d{2}$`),
+	"PL": regexp.MustCompile(`^PL\d{10}$`),
+	"PT": regexp.MustCompile(`^PT\d{9}$`),
+	"RO": regexp.MustCompile(`^RO\d{2,10}$`),
+	"SE": regexp.MustCompile(`^SE\d{12}$`),
+	"SI": regexp.MustCompile(`^SI\d{8}$`),
+	"SK": regexp.MustCompile(`^SK\d{10}$`),
+	// Nigerian TINs are 8 digits and a 4 digit suffix, or 10 digits since
+	// the Joint Tax Board numbering
+	"NG": regexp.MustCompile(`^(\d{8}-\d{4}|\d{10})$`),
+}
+
+// vatPrefixes are the prefixes that differ from the country code
+var vatPrefixes = map[string]string{
+	"GR": "EL",
+	"NG": "",
+}
+
+// NormalizeVATID writes a VAT ID the way it is stored: in capitals, without
+// spaces or dots, and with the jurisdiction's prefix
+func NormalizeVATID(country, vatID string) string {
+	vatID = strings.ToUpper(strings.NewReplacer(" ", "", ".", "").Replace(vatID))
+	prefix, ok := vatPrefixes[country]
+	if !ok {
+		prefix = country
+	}
+	if prefix != "" && !strings.HasPrefix(vatID, prefix) {
+		vatID = prefix + vatID
+	}
+	return vatID
+}
+
+// ValidateVATID checks a normalized VAT ID has the format used in country
+func ValidateVATID(country, vatID string) error {
+	pattern, ok := vatIDPatterns[country]
+	if !ok {
+		return fmt.Errorf("VAT IDs from %s are not supported", country)
+	}
+	if !pattern.MatchString(vatID) {
+		return fmt.Errorf("%s is not a valid VAT ID for %s", vatID, country)
+	}
+	return nil
+}
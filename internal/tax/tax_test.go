@@ -0,0 +1,152 @@
+package tax
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func testTable() *Table {
+	return NewTable(
+		Rate{Country: "DE", EffectiveFrom: date(2025, 1, 1), Name: "VAT", Percent: decimal.NewFromInt(19), ReverseCharge: true},
+		Rate{Country: "EE", EffectiveFrom: date(2025, 7, 1), Name: "VAT", Percent: decimal.NewFromInt(24), ReverseCharge: true},
+		Rate{Country: "EE", EffectiveFrom: date(2025, 1, 1), Name: "VAT", Percent: decimal.NewFromInt(22), ReverseCharge: true},
+		Rate{Country: "NG", EffectiveFrom: date(2025, 1, 1), Name: "VAT", Percent: decimal.RequireFromString("7.5")},
+	)
+}
+
+func TestRateAtEffectiveDates(t *testing.T) {
+	table := testTable()
+
+	tests := []struct {
+		name    string
+		country string
+		at      time.Time
+		want    string
+		ok      bool
+	}{
+		{"Before first rate", "EE", date(2024, 12, 31), "", false},
+		{"First rate", "EE", date(2025, 3, 1), "22", true},
+		{"On change date", "EE", date(2025, 7, 1), "24", true},
+		{"After change", "EE", date(2026, 10, 1), "24", true},
+		{"Unknown country", "US", date(2026, 10, 1), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, ok := table.RateAt(tt.country, tt.at)
+			if ok != tt.ok {
+				t.Fatalf("RateAt() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !rate.Percent.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("RateAt() = %s, want %s", rate.Percent, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculate(t *testing.T) {
+	table := testTable()
+	at := date(2026, 10, 1)
+	subtotal := decimal.RequireFromString("104.00")
+
+	tests := []struct {
+		name        string
+		profile     Profile
+		seller      string
+		subtotal    decimal.Decimal
+		ok          bool
+		amount      string
+		reverse     bool
+		exempt      bool
+		description string
+	}{
+		{"No country", Profile{}, "DE", subtotal, false, "", false, false, ""},
+		{"Country without a rate", Profile{Country: "US"}, "DE", subtotal, false, "", false, false, ""},
+		{"Nothing to tax", Profile{Country: "DE"}, "DE", decimal.Zero, false, "", false, false, ""},
+		{"Consumer", Profile{Country: "DE"}, "IE", subtotal, true, "19.76", false, false, "VAT 19% (DE)"},
+		{"Business in the seller's country", Profile{Country: "DE", VATID: "DE123456789"}, "DE", subtotal, true, "19.76", false, false, "VAT 19% (DE)"},
+		{"Business abroad", Profile{Country: "DE", VATID: "DE123456789"}, "IE", subtotal, true, "0", true, false, "VAT reverse charge: customer to account for VAT (Art. 196 Directive 2006/112/EC)"},
+		{"Business without reverse charge", Profile{Country: "NG", VATID: "12345678-0001"}, "DE", subtotal, true, "7.8", false, false, "VAT 7.5% (NG)"},
+		{"Exempt", Profile{Country: "DE", Exempt: true, ExemptionReason: "Charity"}, "DE", subtotal, true, "0", false, true, "Tax exempt: Charity"},
+		{"Rounds to cents", Profile{Country: "DE"}, "DE", decimal.RequireFromString("0.99"), true, "0.19", false, false, "VAT 19% (DE)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			charge, ok := Calculate(tt.profile, table, tt.seller, tt.subtotal, at)
+			if ok != tt.ok {
+				t.Fatalf("Calculate() ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if !charge.Amount.Equal(decimal.RequireFromString(tt.amount)) {
+				t.Errorf("Amount = %s, want %s", charge.Amount, tt.amount)
+			}
+			if charge.ReverseCharge != tt.reverse || charge.Exempt != tt.exempt {
+				t.Errorf("ReverseCharge, Exempt = %v, %v, want %v, %v", charge.ReverseCharge, charge.Exempt, tt.reverse, tt.exempt)
+			}
+			if got := charge.Description(); got != tt.description {
+				t.Errorf("Description() = %q, want %q", got, tt.description)
+			}
+		})
+	}
+}
+
+func TestValidateVATID(t *testing.T) {
+	tests := []struct {
+		country string
+		input   string
+		want    string
+		valid   bool
+	}{
+		{"DE", "DE123456789", "DE123456789", true},
+		{"DE", "de 123 456 789", "DE123456789", true},
+		{"DE", "123456789", "DE123456789", true},
+		{"DE", "DE12345678", "DE12345678", false},
+		{"AT", "ATU12345678", "ATU12345678", true},
+		{"GR", "123456789", "EL123456789", true},
+		{"NL", "NL123456789B01", "NL123456789B01", true},
+		{"NL", "NL123456789", "NL123456789", false},
+		{"FR", "FRXX123456789", "FRXX123456789", true},
+		{"IE", "IE1234567WA", "IE1234567WA", true},
+		{"NG", "12345678-0001", "12345678-0001", true},
+		{"NG", "1234567890", "1234567890", true},
+		{"NG", "1234-5678", "1234-5678", false},
+		{"US", "123456789", "US123456789", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.country+" "+tt.input, func(t *testing.T) {
+			got := NormalizeVATID(tt.country, tt.input)
+			if got != tt.want {
+				t.Errorf("NormalizeVATID() = %q, want %q", got, tt.want)
+			}
+			err := ValidateVATID(tt.country, got)
+			if (err == nil) != tt.valid {
+				t.Errorf("ValidateVATID(%q) = %v, want valid %v", got, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestRateFromRow(t *testing.T) {
+	rate := RateFromRow(database.TaxRate{
+		Country:       "FI",
+		EffectiveFrom: pgtype.Date{Time: date(2025, 1, 1), Valid: true},
+		Name:          "VAT",
+		Rate:          Rate{Percent: decimal.RequireFromString("25.500")}.Numeric(),
+		ReverseCharge: true,
+	})
+	if got := (Charge{Country: rate.Country, Name: rate.Name, Percent: rate.Percent}).Description(); got != "VAT 25.5% (FI)" {
+		t.Errorf("Description() = %q, want %q", got, "VAT 25.5% (FI)")
+	}
+}
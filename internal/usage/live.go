@@ -0,0 +1,191 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// maxLiveMessages caps the message status changes sent per organization in
+// one update. Counts are always complete.
+const maxLiveMessages = 50
+
+// Publisher sends a message on a pub/sub channel. *redis.Client satisfies it.
+type Publisher interface {
+	Publish(ctx context.Context, channel string, message interface{}) *redis.IntCmd
+}
+
+// LiveChannel is the pub/sub channel carrying an organization's live usage
+func LiveChannel(orgID uuid.UUID) string {
+	return "usage:live:" + orgID.String()
+}
+
+// LiveCount is the number of requests that completed in one second
+type LiveCount struct {
+	Second   time.Time `json:"second"`
+	Requests int64     `json:"requests"`
+	Errors   int64     `json:"errors"`
+}
+
+// MessageStatus is the outcome of a message send request. Clients can match
+// it to the send response through the X-Request-ID header.
+type MessageStatus struct {
+	RequestID  string    `json:"request_id,omitempty"`
+	Type       string    `json:"type"`
+	Status     string    `json:"status"`
+	StatusCode int32     `json:"status_code"`
+	Units      int32     `json:"units"`
+	At         time.Time `json:"at"`
+}
+
+// LiveUpdate is one published message on an organization's live channel
+type LiveUpdate struct {
+	Counts   []LiveCount     `json:"counts"`
+	Messages []MessageStatus `json:"messages,omitempty"`
+}
+
+// LiveFeed counts requests per organization and second as they complete and
+// publishes what it has seen once per interval. Each API instance publishes
+// its own share, so subscribers add up the counts they receive.
+type LiveFeed struct {
+	publisher Publisher
+	interval  time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	pending map[uuid.UUID]*LiveUpdate
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewLiveFeed starts a feed that publishes every interval
+func NewLiveFeed(publisher Publisher, interval time.Duration) *LiveFeed {
+	f := &LiveFeed{
+		publisher: publisher,
+		interval:  interval,
+		now:       time.Now,
+		pending:   make(map[uuid.UUID]*LiveUpdate),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	go f.run()
+
+	return f
+}
+
+// Add counts a completed request. Message sends are also reported as a
+// status change.
+func (f *LiveFeed) Add(record database.CreateUsageRecordsParams) {
+	second := f.now().UTC().Truncate(time.Second)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	update, ok := f.pending[record.OrganizationID]
+	if !ok {
+		update = &LiveUpdate{}
+		f.pending[record.OrganizationID] = update
+	}
+
+	// Records arrive roughly in completion order, so only the last entry can
+	// be for the current second
+	last := len(update.Counts) - 1
+	if last < 0 || !update.Counts[last].Second.Equal(second) {
+		update.Counts = append(update.Counts, LiveCount{Second: second})
+		last++
+	}
+	update.Counts[last].Requests++
+	if record.StatusCode >= 400 {
+		update.Counts[last].Errors++
+	}
+
+	if record.MessageType != nil && len(update.Messages) < maxLiveMessages {
+		status := MessageStatus{
+			Type:       *record.MessageType,
+			Status:     messageStatus(record.StatusCode),
+			StatusCode: record.StatusCode,
+			Units:      record.Units,
+			At:         record.CreatedAt.Time,
+		}
+		if record.RequestID != nil {
+			status.RequestID = *record.RequestID
+		}
+		update.Messages = append(update.Messages, status)
+	}
+}
+
+// Close stops the feed after publishing anything still pending
+func (f *LiveFeed) Close() {
+	f.stopOnce.Do(func() { close(f.stop) })
+	<-f.done
+}
+
+// messageStatus maps a send response to the status the dashboard shows
+func messageStatus(statusCode int32) string {
+	switch {
+	case statusCode < 400:
+		return "queued"
+	case statusCode < 500:
+		return "rejected"
+	default:
+		return "failed"
+	}
+}
+
+func (f *LiveFeed) run() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.flush()
+		case <-f.stop:
+			f.flush()
+			return
+		}
+	}
+}
+
+func (f *LiveFeed) flush() {
+	f.mu.Lock()
+	pending := f.pending
+	f.pending = make(map[uuid.UUID]*LiveUpdate, len(pending))
+	f.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.interval)
+	defer cancel()
+
+	// Nobody may be listening, and a lost update only affects the live view,
+	// so failures are not retried. They are logged once per flush.
+	var failed int
+	var lastErr error
+	for orgID, update := range pending {
+		payload, err := json.Marshal(update)
+		if err == nil {
+			err = f.publisher.Publish(ctx, LiveChannel(orgID), payload).Err()
+		}
+		if err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+	if failed > 0 {
+		log.Printf("Failed to publish live usage for %d organizations: %v", failed, lastErr)
+	}
+}
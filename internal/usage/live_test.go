@@ -0,0 +1,119 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/redis/go-redis/v9"
+)
+
+type fakePublisher struct {
+	mu       sync.Mutex
+	messages map[string][]LiveUpdate
+}
+
+func (f *fakePublisher) Publish(ctx context.Context, channel string, message interface{}) *redis.IntCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var update LiveUpdate
+	json.Unmarshal(message.([]byte), &update)
+	f.messages[channel] = append(f.messages[channel], update)
+	return redis.NewIntCmd(ctx)
+}
+
+func TestLiveFeed(t *testing.T) {
+	publisher := &fakePublisher{messages: make(map[string][]LiveUpdate)}
+	feed := NewLiveFeed(publisher, time.Hour)
+
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	clock := start
+	feed.now = func() time.Time { return clock }
+
+	orgA, orgB := uuid.New(), uuid.New()
+	sms := "sms"
+	requestID := "req_1"
+
+	feed.Add(database.CreateUsageRecordsParams{OrganizationID: orgA, StatusCode: 200})
+	feed.Add(database.CreateUsageRecordsParams{OrganizationID: orgA, StatusCode: 500})
+	clock = start.Add(1500 * time.Millisecond)
+	feed.Add(database.CreateUsageRecordsParams{
+		OrganizationID: orgA,
+		StatusCode:     429,
+		MessageType:    &sms,
+		RequestID:      &requestID,
+		Units:          2,
+		CreatedAt:      pgtype.Timestamp{Time: start, Valid: true},
+	})
+	feed.Add(database.CreateUsageRecordsParams{OrganizationID: orgB, StatusCode: 201})
+
+	feed.Close()
+
+	gotA := publisher.messages[LiveChannel(orgA)]
+	if len(gotA) != 1 {
+		t.Fatalf("org A updates = %d, want 1", len(gotA))
+	}
+	wantCounts := []LiveCount{
+		{Second: start, Requests: 2, Errors: 1},
+		{Second: start.Add(time.Second), Requests: 1, Errors: 1},
+	}
+	if len(gotA[0].Counts) != len(wantCounts) {
+		t.Fatalf("counts = %+v, want %+v", gotA[0].Counts, wantCounts)
+	}
+	for i, want := range wantCounts {
+		got := gotA[0].Counts[i]
+		if !got.Second.Equal(want.Second) || got.Requests != want.Requests || got.Errors != want.Errors {
+			t.Errorf("counts[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+	if len(gotA[0].Messages) != 1 {
+		t.Fatalf("messages = %+v, want 1", gotA[0].Messages)
+	}
+	message := gotA[0].Messages[0]
+	if message.Status != "rejected" || message.Type != "sms" || message.RequestID != "req_1" || message.Units != 2 {
+		t.Errorf("message = %+v", message)
+	}
+
+	gotB := publisher.messages[LiveChannel(orgB)]
+	if len(gotB) != 1 || len(gotB[0].Counts) != 1 || gotB[0].Counts[0].Requests != 1 {
+		t.Errorf("org B updates = %+v", gotB)
+	}
+	if len(gotB[0].Messages) != 0 {
+		t.Errorf("org B messages = %+v, want none", gotB[0].Messages)
+	}
+}
+
+func TestPipelineFeedsLiveUsage(t *testing.T) {
+	publisher := &fakePublisher{messages: make(map[string][]LiveUpdate)}
+	feed := NewLiveFeed(publisher, time.Hour)
+
+	writer := &fakeWriter{}
+	p := NewPipeline(writer, 10, 10, time.Hour)
+	p.SetLiveFeed(feed)
+
+	orgID := uuid.New()
+	for i := 0; i < 3; i++ {
+		p.Enqueue(database.CreateUsageRecordsParams{OrganizationID: orgID, StatusCode: 200})
+	}
+
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	feed.Close()
+
+	var requests int64
+	for _, update := range publisher.messages[LiveChannel(orgID)] {
+		for _, count := range update.Counts {
+			requests += count.Requests
+		}
+	}
+	if requests != 3 {
+		t.Errorf("live requests = %d, want 3", requests)
+	}
+}
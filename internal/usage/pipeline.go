@@ -0,0 +1,254 @@
+package usage
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+)
+
+// Writer bulk-inserts usage records. *database.Queries satisfies it through
+// the CreateUsageRecords copyfrom query.
+type Writer interface {
+	CreateUsageRecords(ctx context.Context, arg []database.CreateUsageRecordsParams) (int64, error)
+}
+
+// Stats are cumulative counters describing the pipeline since it started
+type Stats struct {
+	Queued   int64 `json:"queued"`
+	Buffered int64 `json:"buffered"`
+	Dropped  int64 `json:"dropped"`
+	Written  int64 `json:"written"`
+	Failed   int64 `json:"failed"`
+	Retried  int64 `json:"retried"`
+	Batches  int64 `json:"batches"`
+}
+
+// writeAttempts is how many times a batch is written before it is dropped
+const writeAttempts = 3
+
+// MaxWriteDelay bounds how long after a request its record can reach the
+// database, give or take one write. Records still unwritten by then are
+// dropped, since the rollups only recompute that far back.
+const MaxWriteDelay = 3 * time.Hour
+
+const (
+	defaultFlushInterval = time.Second
+	defaultRetryBackoff  = 500 * time.Millisecond
+)
+
+// Pipeline buffers usage records in memory and writes them to the database in
+// batches. Enqueue never blocks the request path: when the buffer is full the
+// record is dropped and counted instead.
+type Pipeline struct {
+	writer        Writer
+	records       chan database.CreateUsageRecordsParams
+	batchSize     int
+	flushInterval time.Duration
+	writeTimeout  time.Duration
+	retryBackoff  time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+	live   *LiveFeed
+
+	queued  atomic.Int64
+	dropped atomic.Int64
+	written atomic.Int64
+	failed  atomic.Int64
+	retried atomic.Int64
+	batches atomic.Int64
+}
+
+// NewPipeline starts a pipeline that holds up to bufferSize records and writes
+// whenever batchSize records are waiting or flushInterval has passed. A batch
+// that fails to write is retried with backoff before it is dropped.
+func NewPipeline(writer Writer, bufferSize, batchSize int, flushInterval time.Duration) *Pipeline {
+	return newPipeline(writer, bufferSize, batchSize, flushInterval, defaultRetryBackoff)
+}
+
+func newPipeline(writer Writer, bufferSize, batchSize int, flushInterval, retryBackoff time.Duration) *Pipeline {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+
+	p := &Pipeline{
+		writer:        writer,
+		records:       make(chan database.CreateUsageRecordsParams, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		writeTimeout:  10 * time.Second,
+		retryBackoff:  retryBackoff,
+		done:          make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p
+}
+
+// SetLiveFeed makes the pipeline report every record to feed, including
+// records dropped because the buffer is full
+func (p *Pipeline) SetLiveFeed(feed *LiveFeed) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.live = feed
+}
+
+// Enqueue adds a record to the buffer and reports whether it was accepted
+func (p *Pipeline) Enqueue(record database.CreateUsageRecordsParams) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		p.dropped.Add(1)
+		return false
+	}
+
+	if p.live != nil {
+		p.live.Add(record)
+	}
+
+	select {
+	case p.records <- record:
+		p.queued.Add(1)
+		return true
+	default:
+		p.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting records and waits for the buffer to be written out.
+// If ctx expires first, whatever is still buffered is lost.
+func (p *Pipeline) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.records)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the pipeline counters
+func (p *Pipeline) Stats() Stats {
+	return Stats{
+		Queued:   p.queued.Load(),
+		Buffered: int64(len(p.records)),
+		Dropped:  p.dropped.Load(),
+		Written:  p.written.Load(),
+		Failed:   p.failed.Load(),
+		Retried:  p.retried.Load(),
+		Batches:  p.batches.Load(),
+	}
+}
+
+// Publish exposes the pipeline counters under name on expvar's /debug/vars
+func (p *Pipeline) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return p.Stats()
+	}))
+}
+
+func (p *Pipeline) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]database.CreateUsageRecordsParams, 0, p.batchSize)
+
+	for {
+		select {
+		case record, ok := <-p.records:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= p.batchSize {
+				p.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			p.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush writes a batch, retrying with doubling backoff. COPY inserts a batch
+// entirely or not at all, so a retry cannot write records twice. New records
+// wait in the buffer meanwhile, and are dropped if it fills up.
+func (p *Pipeline) flush(batch []database.CreateUsageRecordsParams) {
+	if len(batch) == 0 {
+		return
+	}
+	p.batches.Add(1)
+
+	backoff := p.retryBackoff
+	for attempt := 1; ; attempt++ {
+		batch = p.dropStale(batch)
+		if len(batch) == 0 {
+			return
+		}
+		n, err := p.write(batch)
+		if err == nil {
+			p.written.Add(n)
+			return
+		}
+		if attempt == writeAttempts {
+			p.failed.Add(int64(len(batch)))
+			log.Printf("Failed to write %d usage records after %d attempts, dropping them: %v", len(batch), attempt, err)
+			return
+		}
+
+		p.retried.Add(1)
+		log.Printf("Failed to write %d usage records, retrying in %s: %v", len(batch), backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// dropStale removes the records older than MaxWriteDelay from a batch and
+// counts them as failed
+func (p *Pipeline) dropStale(batch []database.CreateUsageRecordsParams) []database.CreateUsageRecordsParams {
+	cutoff := time.Now().Add(-MaxWriteDelay)
+	fresh := batch[:0]
+	for _, record := range batch {
+		if !record.CreatedAt.Valid || record.CreatedAt.Time.After(cutoff) {
+			fresh = append(fresh, record)
+		}
+	}
+	if stale := len(batch) - len(fresh); stale > 0 {
+		p.failed.Add(int64(stale))
+		log.Printf("Dropping %d usage records older than %s, past the rollup window", stale, MaxWriteDelay)
+	}
+	return fresh
+}
+
+func (p *Pipeline) write(batch []database.CreateUsageRecordsParams) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.writeTimeout)
+	defer cancel()
+
+	return p.writer.CreateUsageRecords(ctx, batch)
+}
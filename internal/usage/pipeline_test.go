@@ -0,0 +1,201 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Mekazstan/multi-tenant-saas-api/internal/database"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type fakeWriter struct {
+	mu      sync.Mutex
+	batches [][]database.CreateUsageRecordsParams
+	err     error
+	// failures is how many writes fail with err before they succeed; when
+	// it is zero every write fails
+	failures int
+	calls    int
+	block    chan struct{}
+}
+
+func (f *fakeWriter) CreateUsageRecords(ctx context.Context, arg []database.CreateUsageRecordsParams) (int64, error) {
+	if f.block != nil {
+		<-f.block
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.err != nil && (f.failures == 0 || f.calls <= f.failures) {
+		return 0, f.err
+	}
+
+	batch := make([]database.CreateUsageRecordsParams, len(arg))
+	copy(batch, arg)
+	f.batches = append(f.batches, batch)
+	return int64(len(arg)), nil
+}
+
+func (f *fakeWriter) batchSizes() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sizes := make([]int, len(f.batches))
+	for i, b := range f.batches {
+		sizes[i] = len(b)
+	}
+	return sizes
+}
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name        string
+		bufferSize  int
+		batchSize   int
+		records     int
+		writerErr   error
+		failures    int
+		wantBatches []int
+		wantStats   Stats
+	}{
+		{
+			name:        "Flushes full batches and drains the remainder on close",
+			bufferSize:  100,
+			batchSize:   4,
+			records:     10,
+			wantBatches: []int{4, 4, 2},
+			wantStats:   Stats{Queued: 10, Written: 10, Batches: 3},
+		},
+		{
+			name:        "Retries a failed write",
+			bufferSize:  100,
+			batchSize:   5,
+			records:     5,
+			writerErr:   errors.New("connection refused"),
+			failures:    2,
+			wantBatches: []int{5},
+			wantStats:   Stats{Queued: 5, Written: 5, Retried: 2, Batches: 1},
+		},
+		{
+			name:        "Counts writes that keep failing",
+			bufferSize:  100,
+			batchSize:   5,
+			records:     5,
+			writerErr:   errors.New("connection refused"),
+			wantBatches: []int{},
+			wantStats:   Stats{Queued: 5, Failed: 5, Retried: 2, Batches: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &fakeWriter{err: tt.writerErr, failures: tt.failures}
+			p := newPipeline(writer, tt.bufferSize, tt.batchSize, time.Hour, time.Millisecond)
+
+			for i := 0; i < tt.records; i++ {
+				if !p.Enqueue(database.CreateUsageRecordsParams{Endpoint: "/api/v1/messages/send"}) {
+					t.Fatalf("Enqueue() rejected record %d", i)
+				}
+			}
+
+			if err := p.Close(context.Background()); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			gotBatches := writer.batchSizes()
+			if len(gotBatches) != len(tt.wantBatches) {
+				t.Fatalf("batches = %v, want %v", gotBatches, tt.wantBatches)
+			}
+			for i := range gotBatches {
+				if gotBatches[i] != tt.wantBatches[i] {
+					t.Errorf("batches = %v, want %v", gotBatches, tt.wantBatches)
+					break
+				}
+			}
+
+			if got := p.Stats(); got != tt.wantStats {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestPipelineDefaultFlushInterval(t *testing.T) {
+	writer := &fakeWriter{}
+	p := NewPipeline(writer, 10, 100, 0)
+	defer p.Close(context.Background())
+
+	p.Enqueue(database.CreateUsageRecordsParams{})
+	deadline := time.Now().Add(3 * defaultFlushInterval)
+	for p.Stats().Written == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if written := p.Stats().Written; written != 1 {
+		t.Errorf("Written = %d after the default flush interval, want 1", written)
+	}
+}
+
+func TestPipelineDropsWhenFull(t *testing.T) {
+	writer := &fakeWriter{block: make(chan struct{})}
+	p := NewPipeline(writer, 2, 1, time.Hour)
+
+	// The worker takes the first record and blocks writing it, leaving room
+	// for exactly two more in the buffer.
+	p.Enqueue(database.CreateUsageRecordsParams{})
+	deadline := time.Now().Add(time.Second)
+	for len(p.records) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	accepted := 0
+	for i := 0; i < 5; i++ {
+		if p.Enqueue(database.CreateUsageRecordsParams{}) {
+			accepted++
+		}
+	}
+	if accepted != 2 {
+		t.Errorf("accepted = %d, want 2", accepted)
+	}
+
+	close(writer.block)
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	stats := p.Stats()
+	if stats.Dropped != 3 {
+		t.Errorf("Dropped = %d, want 3", stats.Dropped)
+	}
+	if stats.Written != 3 {
+		t.Errorf("Written = %d, want 3", stats.Written)
+	}
+
+	if p.Enqueue(database.CreateUsageRecordsParams{}) {
+		t.Error("Enqueue() accepted a record after Close()")
+	}
+}
+
+func TestPipelineDropsStaleRecords(t *testing.T) {
+	writer := &fakeWriter{}
+	p := newPipeline(writer, 10, 10, time.Hour, time.Millisecond)
+
+	now := time.Now().UTC()
+	for _, createdAt := range []time.Time{now, now.Add(-MaxWriteDelay - time.Minute), now.Add(-time.Minute)} {
+		p.Enqueue(database.CreateUsageRecordsParams{CreatedAt: pgtype.Timestamp{Time: createdAt, Valid: true}})
+	}
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if got := writer.batchSizes(); len(got) != 1 || got[0] != 2 {
+		t.Errorf("batches = %v, want one batch of 2", got)
+	}
+	if got, want := p.Stats(), (Stats{Queued: 3, Written: 2, Failed: 1, Batches: 1}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
@@ -17,6 +17,18 @@ SET plan = $1, updated_at = NOW()
 WHERE id = $2
 RETURNING *;
 
+-- name: UpdateOrganizationQuotaPolicy :one
+UPDATE organizations
+SET quota_policy = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: UpdateOrganizationBillingCurrency :one
+UPDATE organizations
+SET billing_currency = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
 -- name: ListOrganizations :many
 SELECT * FROM organizations
 ORDER BY created_at DESC
@@ -26,6 +38,171 @@ LIMIT $1 OFFSET $2;
 DELETE FROM organizations
 WHERE id = $1;
 
+-- ============================================
+-- TRIAL QUERIES
+-- ============================================
+
+-- An organization on free starts at most one trial
+-- name: StartTrial :one
+UPDATE organizations
+SET
+    trial_status = 'active',
+    trial_plan = $2,
+    trial_started_at = $3,
+    trial_ends_at = $4,
+    updated_at = NOW()
+WHERE id = $1 AND plan = 'free' AND trial_status IS NULL
+RETURNING *;
+
+-- Active trials ending by the given time whose owners have not been reminded
+-- name: ListTrialsToRemind :many
+SELECT * FROM organizations
+WHERE trial_status = 'active'
+    AND trial_reminded_at IS NULL
+    AND trial_ends_at <= $1
+ORDER BY trial_ends_at;
+
+-- name: MarkTrialReminded :exec
+UPDATE organizations
+SET trial_reminded_at = NOW()
+WHERE id = $1;
+
+-- name: ListEndedTrials :many
+SELECT * FROM organizations
+WHERE trial_status = 'active' AND trial_ends_at <= $1
+ORDER BY trial_ends_at;
+
+-- Only an active trial ends, so a trial is converted or expired once
+-- name: EndTrial :one
+UPDATE organizations
+SET trial_status = $2, updated_at = NOW()
+WHERE id = $1 AND trial_status = 'active'
+RETURNING *;
+
+-- name: SetStripeCustomerID :one
+UPDATE organizations
+SET stripe_customer_id = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- ============================================
+-- PAYMENT METHOD QUERIES
+-- ============================================
+
+-- name: UpsertPaymentMethod :one
+INSERT INTO payment_methods (organization_id, provider, customer_id, reference, email, brand, last4)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (organization_id) DO UPDATE SET
+    provider = EXCLUDED.provider,
+    customer_id = EXCLUDED.customer_id,
+    reference = EXCLUDED.reference,
+    email = EXCLUDED.email,
+    brand = EXCLUDED.brand,
+    last4 = EXCLUDED.last4,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: GetPaymentMethod :one
+SELECT * FROM payment_methods
+WHERE organization_id = $1;
+
+-- ============================================
+-- STRIPE SUBSCRIPTION QUERIES
+-- ============================================
+
+-- name: UpsertStripeSubscription :one
+INSERT INTO stripe_subscriptions (
+    organization_id,
+    subscription_id,
+    customer_id,
+    plan,
+    status,
+    base_item_id,
+    metered_item_id,
+    current_period_start,
+    current_period_end,
+    cancel_at_period_end,
+    ended_at,
+    billing_started_at
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+ON CONFLICT (organization_id) DO UPDATE SET
+    subscription_id = EXCLUDED.subscription_id,
+    customer_id = EXCLUDED.customer_id,
+    plan = EXCLUDED.plan,
+    status = EXCLUDED.status,
+    base_item_id = EXCLUDED.base_item_id,
+    metered_item_id = EXCLUDED.metered_item_id,
+    current_period_start = EXCLUDED.current_period_start,
+    current_period_end = EXCLUDED.current_period_end,
+    cancel_at_period_end = EXCLUDED.cancel_at_period_end,
+    ended_at = EXCLUDED.ended_at,
+    -- A new subscription starts billing and reporting usage afresh
+    billing_started_at = CASE
+        WHEN stripe_subscriptions.subscription_id = EXCLUDED.subscription_id
+            THEN COALESCE(stripe_subscriptions.billing_started_at, EXCLUDED.billing_started_at)
+        ELSE EXCLUDED.billing_started_at
+    END,
+    usage_reported_through = CASE
+        WHEN stripe_subscriptions.subscription_id = EXCLUDED.subscription_id
+            THEN stripe_subscriptions.usage_reported_through
+    END,
+    latest_invoice_id = CASE
+        WHEN stripe_subscriptions.subscription_id = EXCLUDED.subscription_id
+            THEN stripe_subscriptions.latest_invoice_id
+    END,
+    latest_invoice_status = CASE
+        WHEN stripe_subscriptions.subscription_id = EXCLUDED.subscription_id
+            THEN stripe_subscriptions.latest_invoice_status
+    END,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: GetStripeSubscription :one
+SELECT * FROM stripe_subscriptions
+WHERE organization_id = $1;
+
+-- name: GetStripeSubscriptionByID :one
+SELECT * FROM stripe_subscriptions
+WHERE subscription_id = $1;
+
+-- name: UpdateStripeSubscriptionInvoice :execrows
+UPDATE stripe_subscriptions
+SET latest_invoice_id = $2,
+    latest_invoice_status = $3,
+    updated_at = NOW()
+WHERE subscription_id = $1;
+
+-- Subscriptions Stripe is billing that have a metered item to report usage on
+-- name: ListMeteredStripeSubscriptions :many
+SELECT * FROM stripe_subscriptions
+WHERE metered_item_id IS NOT NULL
+    AND billing_started_at IS NOT NULL
+    AND status IN ('active', 'trialing', 'past_due', 'unpaid')
+ORDER BY organization_id;
+
+-- name: SetStripeUsageReported :exec
+UPDATE stripe_subscriptions
+SET usage_reported_through = $2,
+    updated_at = NOW()
+WHERE subscription_id = $1;
+
+-- The hour up to which usage has been rolled up, read without waiting on a
+-- rollup in progress
+-- name: GetUsageRolledUpTo :one
+SELECT rolled_up_to FROM usage_rollup_state
+WHERE id = 1;
+
+-- Billable units an organization used in each hour between start and end
+-- name: ListHourlyUnits :many
+SELECT bucket, SUM(units)::BIGINT AS units
+FROM usage_rollups_hourly
+WHERE organization_id = @organization_id
+    AND bucket >= @start_time
+    AND bucket < @end_time
+GROUP BY bucket
+ORDER BY bucket;
+
 -- ============================================
 -- USER QUERIES
 -- ============================================
@@ -66,8 +243,8 @@ WHERE id = $1;
 -- ============================================
 
 -- name: CreateAPIKey :one
-INSERT INTO api_keys (organization_id, key, name, is_active)
-VALUES ($1, $2, $3, $4)
+INSERT INTO api_keys (organization_id, key, name, is_active, essential)
+VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
 -- name: GetAPIKey :one
@@ -79,9 +256,12 @@ SELECT
     ak.*,
     o.id as org_id,
     o.name as org_name,
-    o.plan as org_plan
+    o.plan as org_plan,
+    o.quota_policy as org_quota_policy,
+    (cw.blocked_at IS NOT NULL)::boolean as org_credit_blocked
 FROM api_keys ak
 JOIN organizations o ON ak.organization_id = o.id
+LEFT JOIN credit_wallets cw ON cw.organization_id = o.id
 WHERE ak.key = $1 AND ak.is_active = true;
 
 -- name: ListOrganizationAPIKeys :many
@@ -96,7 +276,7 @@ WHERE id = $1;
 
 -- name: DeactivateAPIKey :one
 UPDATE api_keys
-SET is_active = false
+SET is_active = false, suspended_at = NULL
 WHERE id = $1
 RETURNING *;
 
@@ -110,6 +290,21 @@ RETURNING *;
 DELETE FROM api_keys
 WHERE id = $1;
 
+-- name: SuspendNonEssentialAPIKeys :execrows
+UPDATE api_keys
+SET is_active = false, suspended_at = NOW()
+WHERE organization_id = $1 AND is_active = true AND essential = false;
+
+-- name: ResumeSuspendedAPIKeys :execrows
+UPDATE api_keys
+SET is_active = true, suspended_at = NULL
+WHERE organization_id = $1 AND suspended_at IS NOT NULL;
+
+-- name: ResumeAPIKeysSuspendedBefore :execrows
+UPDATE api_keys
+SET is_active = true, suspended_at = NULL
+WHERE suspended_at < $1;
+
 -- ============================================
 -- USAGE RECORD QUERIES
 -- ============================================
@@ -119,6 +314,13 @@ INSERT INTO usage_records (organization_id, api_key_id, endpoint, method, status
 VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
+-- name: CreateUsageRecords :copyfrom
+INSERT INTO usage_records (
+    organization_id, api_key_id, endpoint, method, status_code, created_at,
+    duration_ms, request_bytes, response_bytes, client_ip, user_agent, request_id, message_type, units
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);
+
 -- name: GetUsageRecord :one
 SELECT * FROM usage_records
 WHERE id = $1;
@@ -131,56 +333,967 @@ WHERE organization_id = $1
 ORDER BY created_at DESC
 LIMIT $4 OFFSET $5;
 
+-- Usage queries read the whole hours of the range that are rolled up from the
+-- hourly rollups, and the partial hours at either end and the hours not yet
+-- rolled up from usage_records
 -- name: CountOrganizationUsage :one
-SELECT COUNT(*) FROM usage_records
-WHERE organization_id = $1
-    AND created_at >= $2
-    AND created_at <= $3;
+WITH bounds AS (
+    SELECT
+        date_trunc('hour', sqlc.arg(start_time)::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', sqlc.arg(end_time)::timestamp + interval '1 microsecond'), rolled_up_to) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), raw_ranges AS (
+    SELECT sqlc.arg(start_time)::timestamp AS raw_from,
+        LEAST(hours_from, sqlc.arg(end_time)::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), sqlc.arg(end_time)::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT h.request_count
+    FROM usage_rollups_hourly h, bounds b
+    WHERE h.organization_id = sqlc.arg(organization_id)
+        AND h.bucket >= b.hours_from
+        AND h.bucket < b.hours_until
+    UNION ALL
+    SELECT 1::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = sqlc.arg(organization_id)
+)
+SELECT COALESCE(SUM(request_count), 0)::bigint AS count FROM usage;
 
 -- name: GetUsageByEndpoint :many
-SELECT 
+WITH bounds AS (
+    SELECT
+        date_trunc('hour', sqlc.arg(start_time)::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', sqlc.arg(end_time)::timestamp + interval '1 microsecond'), rolled_up_to) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), raw_ranges AS (
+    SELECT sqlc.arg(start_time)::timestamp AS raw_from,
+        LEAST(hours_from, sqlc.arg(end_time)::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), sqlc.arg(end_time)::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT h.endpoint, h.status_class, h.request_count
+    FROM usage_rollups_hourly h, bounds b
+    WHERE h.organization_id = sqlc.arg(organization_id)
+        AND h.bucket >= b.hours_from
+        AND h.bucket < b.hours_until
+    UNION ALL
+    SELECT ur.endpoint, (ur.status_code / 100)::smallint, 1::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = sqlc.arg(organization_id)
+)
+SELECT
     endpoint,
-    COUNT(*) as request_count,
-    COUNT(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 END) as success_count,
-    COUNT(CASE WHEN status_code >= 400 THEN 1 END) as error_count
-FROM usage_records
-WHERE organization_id = $1
-    AND created_at >= $2
-    AND created_at <= $3
+    SUM(request_count)::bigint as request_count,
+    COALESCE(SUM(request_count) FILTER (WHERE status_class = 2), 0)::bigint as success_count,
+    COALESCE(SUM(request_count) FILTER (WHERE status_class >= 4), 0)::bigint as error_count
+FROM usage
 GROUP BY endpoint
 ORDER BY request_count DESC;
 
 -- name: GetUsageByAPIKey :many
-SELECT 
+WITH bounds AS (
+    SELECT
+        date_trunc('hour', sqlc.arg(start_time)::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', sqlc.arg(end_time)::timestamp + interval '1 microsecond'), rolled_up_to) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), raw_ranges AS (
+    SELECT sqlc.arg(start_time)::timestamp AS raw_from,
+        LEAST(hours_from, sqlc.arg(end_time)::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), sqlc.arg(end_time)::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT h.api_key_id, h.request_count
+    FROM usage_rollups_hourly h, bounds b
+    WHERE h.organization_id = sqlc.arg(organization_id)
+        AND h.bucket >= b.hours_from
+        AND h.bucket < b.hours_until
+    UNION ALL
+    SELECT ur.api_key_id, 1::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = sqlc.arg(organization_id)
+)
+SELECT
     ak.id,
     ak.name,
     ak.key,
-    COUNT(ur.id) as request_count
+    COALESCE(SUM(u.request_count), 0)::bigint as request_count
 FROM api_keys ak
-LEFT JOIN usage_records ur ON ak.id = ur.api_key_id
-    AND ur.created_at >= $2
-    AND ur.created_at <= $3
-WHERE ak.organization_id = $1
+LEFT JOIN usage u ON ak.id = u.api_key_id
+WHERE ak.organization_id = sqlc.arg(organization_id)
 GROUP BY ak.id, ak.name, ak.key
 ORDER BY request_count DESC;
 
+-- Whole days come from the daily rollups, the rest as in CountOrganizationUsage
 -- name: GetDailyUsageStats :many
-SELECT 
-    DATE(created_at) as date,
+WITH bounds AS (
+    SELECT
+        date_trunc('day', sqlc.arg(start_time)::timestamp + interval '1 day' - interval '1 microsecond') AS days_from,
+        LEAST(date_trunc('day', sqlc.arg(end_time)::timestamp + interval '1 microsecond'), date_trunc('day', rolled_up_to)) AS days_until,
+        date_trunc('hour', sqlc.arg(start_time)::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', sqlc.arg(end_time)::timestamp + interval '1 microsecond'), rolled_up_to) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), hour_ranges AS (
+    SELECT hours_from, LEAST(days_from, hours_until) AS hours_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, days_from, days_until), hours_until
+    FROM bounds
+), raw_ranges AS (
+    SELECT sqlc.arg(start_time)::timestamp AS raw_from,
+        LEAST(hours_from, sqlc.arg(end_time)::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), sqlc.arg(end_time)::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT d.day, d.status_class, d.request_count
+    FROM usage_rollups_daily d, bounds b
+    WHERE d.organization_id = sqlc.arg(organization_id)
+        AND d.day >= b.days_from
+        AND d.day < b.days_until
+    UNION ALL
+    SELECT DATE(h.bucket), h.status_class, h.request_count
+    FROM usage_rollups_hourly h
+    JOIN hour_ranges hr ON h.bucket >= hr.hours_from AND h.bucket < hr.hours_until
+    WHERE h.organization_id = sqlc.arg(organization_id)
+    UNION ALL
+    SELECT DATE(ur.created_at), (ur.status_code / 100)::smallint, 1::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = sqlc.arg(organization_id)
+)
+SELECT
+    day as date,
+    SUM(request_count)::bigint as request_count,
+    COALESCE(SUM(request_count) FILTER (WHERE status_class = 2), 0)::bigint as success_count,
+    COALESCE(SUM(request_count) FILTER (WHERE status_class >= 4), 0)::bigint as error_count
+FROM usage
+GROUP BY day
+ORDER BY date DESC;
+
+-- name: GetUsageByMessageType :many
+WITH bounds AS (
+    SELECT
+        date_trunc('hour', sqlc.arg(start_time)::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', sqlc.arg(end_time)::timestamp + interval '1 microsecond'), rolled_up_to) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), raw_ranges AS (
+    SELECT sqlc.arg(start_time)::timestamp AS raw_from,
+        LEAST(hours_from, sqlc.arg(end_time)::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), sqlc.arg(end_time)::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT h.message_type, h.request_count, h.units
+    FROM usage_rollups_hourly h, bounds b
+    WHERE h.organization_id = sqlc.arg(organization_id)
+        AND h.bucket >= b.hours_from
+        AND h.bucket < b.hours_until
+    UNION ALL
+    SELECT COALESCE(ur.message_type, ''), 1::bigint, ur.units::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = sqlc.arg(organization_id)
+)
+SELECT
+    message_type::text as message_type,
+    SUM(request_count)::bigint as request_count,
+    SUM(units)::bigint as units
+FROM usage
+GROUP BY message_type
+ORDER BY message_type;
+
+-- ============================================
+-- USAGE ROLLUP QUERIES
+-- ============================================
+
+-- name: GetUsageRollupWatermark :one
+SELECT rolled_up_to FROM usage_rollup_state
+WHERE id = 1
+FOR UPDATE;
+
+-- name: RollupHourlyUsage :execrows
+INSERT INTO usage_rollups_hourly (
+    organization_id, api_key_id, endpoint, status_class, message_type, bucket,
+    request_count, units, request_bytes, response_bytes, duration_ms
+)
+SELECT
+    organization_id,
+    api_key_id,
+    endpoint,
+    (status_code / 100)::smallint,
+    COALESCE(message_type, ''),
+    date_trunc('hour', created_at),
+    COUNT(*),
+    SUM(units)::bigint,
+    SUM(request_bytes)::bigint,
+    SUM(response_bytes)::bigint,
+    SUM(duration_ms)::bigint
+FROM usage_records
+WHERE created_at >= sqlc.arg(start_time)::timestamp
+    AND created_at < sqlc.arg(end_time)::timestamp
+GROUP BY 1, 2, 3, 4, 5, 6
+ON CONFLICT (organization_id, bucket, api_key_id, endpoint, status_class, message_type)
+DO UPDATE SET
+    request_count = EXCLUDED.request_count,
+    units = EXCLUDED.units,
+    request_bytes = EXCLUDED.request_bytes,
+    response_bytes = EXCLUDED.response_bytes,
+    duration_ms = EXCLUDED.duration_ms;
+
+-- name: RollupDailyUsage :execrows
+INSERT INTO usage_rollups_daily (
+    organization_id, api_key_id, endpoint, status_class, message_type, day,
+    request_count, units, request_bytes, response_bytes, duration_ms
+)
+SELECT
+    organization_id,
+    api_key_id,
+    endpoint,
+    status_class,
+    message_type,
+    DATE(bucket),
+    SUM(request_count)::bigint,
+    SUM(units)::bigint,
+    SUM(request_bytes)::bigint,
+    SUM(response_bytes)::bigint,
+    SUM(duration_ms)::bigint
+FROM usage_rollups_hourly
+WHERE bucket >= date_trunc('day', sqlc.arg(start_time)::timestamp)
+    AND bucket < sqlc.arg(end_time)::timestamp
+GROUP BY 1, 2, 3, 4, 5, 6
+ON CONFLICT (organization_id, day, api_key_id, endpoint, status_class, message_type)
+DO UPDATE SET
+    request_count = EXCLUDED.request_count,
+    units = EXCLUDED.units,
+    request_bytes = EXCLUDED.request_bytes,
+    response_bytes = EXCLUDED.response_bytes,
+    duration_ms = EXCLUDED.duration_ms;
+
+-- name: SetUsageRollupWatermark :exec
+UPDATE usage_rollup_state
+SET rolled_up_to = $1, updated_at = NOW()
+WHERE id = 1;
+
+-- ============================================
+-- USAGE RETENTION QUERIES
+-- ============================================
+
+-- name: ListUsageRecordPartitions :many
+SELECT
+    c.relname::text as name,
+    c.relispartition as attached
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = current_schema()
+    AND c.relkind = 'r'
+    AND c.relname ~ '^usage_records_[0-9]{4}_[0-9]{2}$'
+ORDER BY c.relname;
+
+-- ============================================
+-- ANALYTICS QUERIES
+-- ============================================
+
+-- name: GetLatencyPercentiles :one
+SELECT
+    COUNT(*) as request_count,
+    COALESCE(AVG(duration_ms), 0)::float8 as avg_ms,
+    COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p50_ms,
+    COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p95_ms,
+    COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p99_ms,
+    COALESCE(MAX(duration_ms), 0)::integer as max_ms
+FROM usage_records
+WHERE organization_id = sqlc.arg(organization_id)
+    AND created_at >= sqlc.arg(start_time)::timestamp
+    AND created_at <= sqlc.arg(end_time)::timestamp;
+
+-- name: GetLatencyPercentilesByEndpoint :many
+SELECT
+    endpoint,
     COUNT(*) as request_count,
-    COUNT(CASE WHEN status_code >= 200 AND status_code < 300 THEN 1 END) as success_count,
-    COUNT(CASE WHEN status_code >= 400 THEN 1 END) as error_count
+    COALESCE(AVG(duration_ms), 0)::float8 as avg_ms,
+    COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p50_ms,
+    COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p95_ms,
+    COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY duration_ms), 0)::float8 as p99_ms,
+    COALESCE(MAX(duration_ms), 0)::integer as max_ms
+FROM usage_records
+WHERE organization_id = sqlc.arg(organization_id)
+    AND created_at >= sqlc.arg(start_time)::timestamp
+    AND created_at <= sqlc.arg(end_time)::timestamp
+GROUP BY endpoint
+ORDER BY request_count DESC;
+
+-- name: GetLatencyPercentilesByAPIKey :many
+SELECT
+    ak.id,
+    ak.name,
+    COUNT(*) as request_count,
+    COALESCE(AVG(ur.duration_ms), 0)::float8 as avg_ms,
+    COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY ur.duration_ms), 0)::float8 as p50_ms,
+    COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY ur.duration_ms), 0)::float8 as p95_ms,
+    COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY ur.duration_ms), 0)::float8 as p99_ms,
+    COALESCE(MAX(ur.duration_ms), 0)::integer as max_ms
+FROM usage_records ur
+JOIN api_keys ak ON ak.id = ur.api_key_id
+WHERE ur.organization_id = sqlc.arg(organization_id)
+    AND ur.created_at >= sqlc.arg(start_time)::timestamp
+    AND ur.created_at <= sqlc.arg(end_time)::timestamp
+GROUP BY ak.id, ak.name
+ORDER BY request_count DESC;
+
+-- name: GetStatusCodesByEndpoint :many
+SELECT
+    endpoint,
+    status_code,
+    COUNT(*) as request_count
+FROM usage_records
+WHERE organization_id = sqlc.arg(organization_id)
+    AND created_at >= sqlc.arg(start_time)::timestamp
+    AND created_at <= sqlc.arg(end_time)::timestamp
+GROUP BY endpoint, status_code
+ORDER BY endpoint, status_code;
+
+-- name: GetUsageTimeseries :many
+WITH bounds AS (
+    SELECT
+        date_trunc('hour', sqlc.arg(start_time)::timestamp + interval '1 hour' - interval '1 microsecond') AS hours_from,
+        LEAST(date_trunc('hour', sqlc.arg(end_time)::timestamp + interval '1 microsecond'), rolled_up_to, sqlc.arg(raw_since)::timestamp) AS hours_until
+    FROM usage_rollup_state
+    WHERE id = 1
+), raw_ranges AS (
+    SELECT sqlc.arg(start_time)::timestamp AS raw_from,
+        LEAST(hours_from, sqlc.arg(end_time)::timestamp + interval '1 microsecond') AS raw_until
+    FROM bounds
+    UNION ALL
+    SELECT GREATEST(hours_from, hours_until), sqlc.arg(end_time)::timestamp + interval '1 microsecond'
+    FROM bounds
+), usage AS (
+    SELECT h.bucket, h.api_key_id, h.endpoint, h.status_class, h.request_count, h.units
+    FROM usage_rollups_hourly h, bounds b
+    WHERE h.organization_id = sqlc.arg(organization_id)
+        AND h.bucket >= b.hours_from
+        AND h.bucket < b.hours_until
+    UNION ALL
+    SELECT date_trunc('minute', ur.created_at), ur.api_key_id, ur.endpoint, (ur.status_code / 100)::smallint, 1::bigint, ur.units::bigint
+    FROM usage_records ur
+    JOIN raw_ranges r ON ur.created_at >= r.raw_from AND ur.created_at < r.raw_until
+    WHERE ur.organization_id = sqlc.arg(organization_id)
+)
+SELECT
+    date_trunc(sqlc.arg(granularity)::text, (bucket AT TIME ZONE 'UTC') AT TIME ZONE sqlc.arg(tz)::text)::timestamp as bucket,
+    (CASE sqlc.arg(group_by)::text
+        WHEN 'endpoint' THEN endpoint
+        WHEN 'api_key' THEN api_key_id::text
+        WHEN 'status_class' THEN status_class || 'xx'
+        ELSE ''
+    END)::text as group_key,
+    SUM(request_count)::bigint as request_count,
+    COALESCE(SUM(request_count) FILTER (WHERE status_class >= 4), 0)::bigint as error_count,
+    SUM(units)::bigint as units
+FROM usage
+WHERE (sqlc.narg(endpoint)::text IS NULL OR endpoint = sqlc.narg(endpoint)::text)
+    AND (sqlc.narg(api_key_id)::uuid IS NULL OR api_key_id = sqlc.narg(api_key_id)::uuid)
+    AND (sqlc.narg(status_class)::smallint IS NULL OR status_class = sqlc.narg(status_class)::smallint)
+GROUP BY 1, 2
+ORDER BY 1, 2;
+
+-- ============================================
+-- USAGE ALERT QUERIES
+-- ============================================
+
+-- name: GetHourlyUsageTotals :many
+WITH mark AS (
+    SELECT rolled_up_to FROM usage_rollup_state WHERE id = 1
+), usage AS (
+    SELECT h.organization_id, h.bucket, h.request_count,
+        CASE WHEN h.status_class >= 4 THEN h.request_count ELSE 0 END as error_count
+    FROM usage_rollups_hourly h, mark
+    WHERE h.bucket >= sqlc.arg(start_time)::timestamp
+        AND h.bucket < sqlc.arg(end_time)::timestamp
+        AND h.bucket < mark.rolled_up_to
+    UNION ALL
+    SELECT ur.organization_id, date_trunc('hour', ur.created_at), 1,
+        CASE WHEN ur.status_code >= 400 THEN 1 ELSE 0 END
+    FROM usage_records ur, mark
+    WHERE ur.created_at >= GREATEST(sqlc.arg(start_time)::timestamp, mark.rolled_up_to)
+        AND ur.created_at < sqlc.arg(end_time)::timestamp
+)
+SELECT
+    organization_id,
+    bucket::timestamp as bucket,
+    SUM(request_count)::bigint as request_count,
+    SUM(error_count)::bigint as error_count
+FROM usage
+GROUP BY organization_id, bucket
+ORDER BY organization_id, bucket;
+
+-- name: CreateUsageAlert :one
+INSERT INTO usage_alerts (organization_id, metric, direction, window_start, window_end, observed, expected, score)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (organization_id, metric, window_start) DO NOTHING
+RETURNING *;
+
+-- name: ListOrganizationUsageAlerts :many
+SELECT * FROM usage_alerts
+WHERE organization_id = sqlc.arg(organization_id)
+    AND (sqlc.narg(status)::usage_alert_status IS NULL OR status = sqlc.narg(status)::usage_alert_status)
+ORDER BY created_at DESC
+LIMIT sqlc.arg(limit_count);
+
+-- name: AcknowledgeUsageAlert :one
+UPDATE usage_alerts
+SET status = 'acknowledged', acknowledged_at = NOW(), acknowledged_by = $3
+WHERE id = $1 AND organization_id = $2
+RETURNING *;
+
+-- name: ListOrganizationAdminEmails :many
+SELECT email FROM users
+WHERE organization_id = $1 AND role IN ('owner', 'admin')
+ORDER BY created_at;
+
+-- ============================================
+-- USAGE BUDGET QUERIES
+-- ============================================
+
+-- name: UpsertUsageBudget :one
+INSERT INTO usage_budgets (organization_id, metric, amount, thresholds, disable_keys_at_limit)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (organization_id) DO UPDATE
+SET metric = EXCLUDED.metric,
+    amount = EXCLUDED.amount,
+    thresholds = EXCLUDED.thresholds,
+    disable_keys_at_limit = EXCLUDED.disable_keys_at_limit,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: GetUsageBudget :one
+SELECT * FROM usage_budgets
+WHERE organization_id = $1;
+
+-- name: DeleteUsageBudget :exec
+DELETE FROM usage_budgets
+WHERE organization_id = $1;
+
+-- name: ListUsageBudgets :many
+SELECT
+    b.organization_id,
+    b.metric,
+    b.amount,
+    b.thresholds,
+    b.disable_keys_at_limit,
+    o.name as org_name,
+    o.plan as org_plan
+FROM usage_budgets b
+JOIN organizations o ON b.organization_id = o.id
+ORDER BY b.organization_id;
+
+-- name: CreateUsageBudgetNotification :execrows
+INSERT INTO usage_budget_notifications (organization_id, period_start, threshold, usage)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (organization_id, period_start, threshold) DO NOTHING;
+
+-- name: ListUsageBudgetNotifications :many
+SELECT * FROM usage_budget_notifications
+WHERE organization_id = $1 AND period_start = $2
+ORDER BY threshold;
+
+-- name: DeleteUsageBudgetNotifications :exec
+DELETE FROM usage_budget_notifications
+WHERE organization_id = $1 AND period_start = $2;
+
+-- ============================================
+-- OUTBOUND EVENT QUERIES
+-- ============================================
+
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (organization_id, url, secret)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints
+WHERE organization_id = $1;
+
+-- name: UpdateWebhookEndpoint :one
+UPDATE webhook_endpoints
+SET url = $2, secret = $3, updated_at = NOW()
+WHERE organization_id = $1
+RETURNING *;
+
+-- name: DeleteWebhookEndpoint :exec
+DELETE FROM webhook_endpoints
+WHERE organization_id = $1;
+
+-- name: CreateOutboundEvent :execrows
+INSERT INTO outbound_events (organization_id, event_type, payload)
+SELECT organization_id, sqlc.arg(event_type)::varchar, sqlc.arg(payload)::jsonb
+FROM webhook_endpoints
+WHERE organization_id = sqlc.arg(organization_id);
+
+-- name: ListDueOutboundEvents :many
+SELECT
+    e.id,
+    e.organization_id,
+    e.event_type,
+    e.payload,
+    e.attempts,
+    e.created_at,
+    w.url,
+    w.secret
+FROM outbound_events e
+JOIN webhook_endpoints w ON e.organization_id = w.organization_id
+WHERE e.status = 'pending' AND e.next_attempt_at <= NOW()
+ORDER BY e.next_attempt_at
+LIMIT $1;
+
+-- name: RecordOutboundEventAttempt :exec
+UPDATE outbound_events
+SET status = $2,
+    attempts = attempts + 1,
+    next_attempt_at = $3,
+    last_error = $4,
+    delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() ELSE delivered_at END
+WHERE id = $1;
+
+-- name: ListOrganizationOutboundEvents :many
+SELECT * FROM outbound_events
+WHERE organization_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- ============================================
+-- USAGE EXPORT QUERIES
+-- ============================================
+
+-- name: CreateUsageExport :one
+INSERT INTO usage_exports (organization_id, requested_by, format, start_time, end_time)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetUsageExport :one
+SELECT * FROM usage_exports
+WHERE id = $1;
+
+-- name: ListOrganizationUsageExports :many
+SELECT * FROM usage_exports
+WHERE organization_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: CountQueuedUsageExports :one
+SELECT COUNT(*) FROM usage_exports
+WHERE organization_id = $1 AND status IN ('pending', 'running');
+
+-- name: ClaimUsageExport :one
+UPDATE usage_exports
+SET status = 'running',
+    started_at = NOW()
+WHERE id = (
+    SELECT id FROM usage_exports
+    WHERE status = 'pending'
+       OR (status = 'running' AND started_at < sqlc.arg(stale_before))
+    ORDER BY created_at
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CompleteUsageExport :exec
+UPDATE usage_exports
+SET status = 'completed',
+    row_count = $2,
+    file_size = $3,
+    file_path = $4,
+    expires_at = $5,
+    completed_at = NOW()
+WHERE id = $1;
+
+-- name: FailUsageExport :exec
+UPDATE usage_exports
+SET status = 'failed',
+    error = $2,
+    completed_at = NOW()
+WHERE id = $1;
+
+-- name: ListExpiredUsageExports :many
+SELECT * FROM usage_exports
+WHERE status = 'completed' AND expires_at <= NOW();
+
+-- name: MarkUsageExportExpired :exec
+UPDATE usage_exports
+SET status = 'expired',
+    file_path = NULL
+WHERE id = $1;
+
+-- name: ListUsageRecordsForExport :many
+SELECT
+    id,
+    api_key_id,
+    endpoint,
+    method,
+    status_code,
+    created_at,
+    duration_ms,
+    request_bytes,
+    response_bytes,
+    client_ip,
+    user_agent,
+    request_id,
+    message_type,
+    units
 FROM usage_records
+WHERE organization_id = sqlc.arg(organization_id)
+  AND created_at < sqlc.arg(end_time)
+  AND (created_at, id) > (sqlc.arg(after_created_at)::timestamp, sqlc.arg(after_id)::uuid)
+ORDER BY created_at, id
+LIMIT sqlc.arg(limit_count);
+
+-- ============================================
+-- PRICE BOOK QUERIES
+-- ============================================
+
+-- name: ListPriceBooks :many
+SELECT * FROM price_books
+ORDER BY effective_from;
+
+-- ============================================
+-- TAX QUERIES
+-- ============================================
+
+-- name: GetOrganizationTaxProfile :one
+SELECT * FROM organization_tax_profiles
+WHERE organization_id = $1;
+
+-- name: UpsertOrganizationTaxProfile :one
+INSERT INTO organization_tax_profiles (organization_id, country, vat_id)
+VALUES ($1, $2, $3)
+ON CONFLICT (organization_id) DO UPDATE
+SET country = EXCLUDED.country,
+    vat_id = EXCLUDED.vat_id,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: SetOrganizationTaxExemption :one
+UPDATE organization_tax_profiles
+SET tax_exempt = $2,
+    exemption_reason = $3,
+    updated_at = NOW()
 WHERE organization_id = $1
-    AND created_at >= $2
-    AND created_at <= $3
-GROUP BY DATE(created_at)
-ORDER BY date DESC;
+RETURNING *;
+
+-- name: ListTaxRates :many
+SELECT * FROM tax_rates
+ORDER BY country, effective_from;
+
+-- name: UpsertTaxRate :one
+INSERT INTO tax_rates (country, effective_from, name, rate, reverse_charge)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (country, effective_from) DO UPDATE
+SET name = EXCLUDED.name,
+    rate = EXCLUDED.rate,
+    reverse_charge = EXCLUDED.reverse_charge
+RETURNING *;
+
+-- ============================================
+-- FX RATE QUERIES
+-- ============================================
+
+-- name: ListFXRates :many
+SELECT * FROM fx_rates
+ORDER BY base_currency, quote_currency, effective_at;
+
+-- name: UpsertFXRate :one
+INSERT INTO fx_rates (base_currency, quote_currency, rate, effective_at, source)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (base_currency, quote_currency, effective_at) DO UPDATE
+SET rate = EXCLUDED.rate,
+    source = EXCLUDED.source
+RETURNING *;
+
+-- ============================================
+-- CREDIT WALLET QUERIES
+-- ============================================
+
+-- name: GetCreditWallet :one
+SELECT * FROM credit_wallets
+WHERE organization_id = $1;
+
+-- name: GetCreditWalletForUpdate :one
+SELECT * FROM credit_wallets
+WHERE organization_id = $1
+FOR UPDATE;
+
+-- name: EnsureCreditWallet :exec
+INSERT INTO credit_wallets (organization_id)
+VALUES ($1)
+ON CONFLICT (organization_id) DO NOTHING;
+
+-- A new threshold is checked afresh, so its notice is reset
+-- name: UpsertCreditWalletSettings :one
+INSERT INTO credit_wallets (organization_id, low_balance_threshold, block_at_zero)
+VALUES ($1, $2, $3)
+ON CONFLICT (organization_id) DO UPDATE
+SET low_balance_threshold = EXCLUDED.low_balance_threshold,
+    block_at_zero = EXCLUDED.block_at_zero,
+    low_balance_notified_at = NULL,
+    blocked_at = CASE WHEN EXCLUDED.block_at_zero THEN credit_wallets.blocked_at END,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: ListCreditWallets :many
+SELECT
+    cw.*,
+    o.name as org_name,
+    o.plan as org_plan,
+    o.billing_currency as org_billing_currency
+FROM credit_wallets cw
+JOIN organizations o ON cw.organization_id = o.id
+ORDER BY cw.organization_id;
+
+-- name: MarkCreditWalletLowBalanceNotified :execrows
+UPDATE credit_wallets
+SET low_balance_notified_at = NOW()
+WHERE organization_id = $1 AND low_balance_notified_at IS NULL;
+
+-- name: ClearCreditWalletLowBalanceNotice :exec
+UPDATE credit_wallets
+SET low_balance_notified_at = NULL
+WHERE organization_id = $1 AND low_balance_notified_at IS NOT NULL;
+
+-- Only changes, and so only counts, wallets whose blocked state flips
+-- name: SetCreditWalletBlocked :execrows
+UPDATE credit_wallets
+SET blocked_at = CASE WHEN @blocked::boolean THEN NOW() END
+WHERE organization_id = @organization_id AND (blocked_at IS NOT NULL) <> @blocked::boolean;
+
+-- name: CreateCreditTopUp :one
+INSERT INTO credit_top_ups (organization_id, provider, currency, amount, created_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetCreditTopUp :one
+SELECT * FROM credit_top_ups
+WHERE id = $1;
+
+-- Only a pending top-up completes, so a repeated webhook finds no row
+-- name: CompleteCreditTopUp :one
+UPDATE credit_top_ups
+SET status = 'completed', completed_at = NOW()
+WHERE id = $1 AND status = 'pending'
+RETURNING *;
+
+-- name: FailCreditTopUp :execrows
+UPDATE credit_top_ups
+SET status = 'failed', completed_at = NOW()
+WHERE id = $1 AND status = 'pending';
+
+-- name: CreateCreditTransaction :one
+INSERT INTO credit_transactions (
+    organization_id,
+    type,
+    currency,
+    amount,
+    description,
+    top_up_id,
+    billing_cycle_id
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: CreateCreditLedgerEntry :exec
+INSERT INTO credit_ledger_entries (transaction_id, organization_id, account, direction, currency, amount)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: ListCreditTransactions :many
+SELECT * FROM credit_transactions
+WHERE organization_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- The wallet is a liability: credits add to the balance, debits spend it
+-- name: GetCreditBalances :many
+SELECT
+    currency,
+    SUM(CASE direction WHEN 'credit' THEN amount ELSE -amount END)::DECIMAL(16, 2) as balance
+FROM credit_ledger_entries
+WHERE organization_id = $1 AND account = 'wallet'
+GROUP BY currency
+ORDER BY currency;
+
+-- name: GetCreditBalance :one
+SELECT COALESCE(SUM(CASE direction WHEN 'credit' THEN amount ELSE -amount END), 0)::DECIMAL(16, 2) as balance
+FROM credit_ledger_entries
+WHERE organization_id = $1 AND account = 'wallet' AND currency = $2;
+
+-- ============================================
+-- COUPON QUERIES
+-- ============================================
+
+-- name: CreateCoupon :one
+INSERT INTO coupons (
+    code,
+    name,
+    discount_type,
+    percent_off,
+    amount_off,
+    currency,
+    duration,
+    duration_months,
+    plans,
+    max_redemptions,
+    redeem_by
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+RETURNING *;
+
+-- name: GetCoupon :one
+SELECT * FROM coupons
+WHERE id = $1;
+
+-- name: GetCouponByCode :one
+SELECT * FROM coupons
+WHERE code = $1;
+
+-- name: ListCoupons :many
+SELECT * FROM coupons
+ORDER BY created_at DESC;
+
+-- The discount itself never changes once a coupon may have been redeemed
+-- name: UpdateCoupon :one
+UPDATE coupons
+SET name = $2,
+    plans = $3,
+    max_redemptions = $4,
+    redeem_by = $5,
+    active = $6,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- Redeemed coupons are kept for their redemptions; deactivate them instead
+-- name: DeleteCoupon :execrows
+DELETE FROM coupons
+WHERE id = $1 AND times_redeemed = 0;
+
+-- Counts a redemption if the coupon can still be redeemed. Concurrent
+-- redemptions queue on the row, so the limit is never exceeded.
+-- name: ClaimCoupon :one
+UPDATE coupons
+SET times_redeemed = times_redeemed + 1,
+    updated_at = NOW()
+WHERE id = $1
+  AND active
+  AND (redeem_by IS NULL OR redeem_by > NOW())
+  AND (max_redemptions IS NULL OR times_redeemed < max_redemptions)
+RETURNING *;
+
+-- name: CreateCouponRedemption :one
+INSERT INTO coupon_redemptions (coupon_id, organization_id, redeemed_by, periods_remaining)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetActiveCouponRedemption :one
+SELECT * FROM coupon_redemptions
+WHERE organization_id = $1 AND ended_at IS NULL;
+
+-- name: GetActiveCouponRedemptionForUpdate :one
+SELECT * FROM coupon_redemptions
+WHERE organization_id = $1 AND ended_at IS NULL
+FOR UPDATE;
+
+-- Spends one discounted invoice; the redemption ends with its last one.
+-- Forever redemptions have no count and never end.
+-- name: UseCouponRedemption :one
+UPDATE coupon_redemptions
+SET periods_remaining = periods_remaining - 1,
+    ended_at = CASE WHEN periods_remaining = 1 THEN NOW() END
+WHERE id = $1
+RETURNING *;
+
+-- ============================================
+-- PLAN CHANGE QUERIES
+-- ============================================
+
+-- The plans an organization was on at some point between start and end
+-- name: ListOrganizationPlanHistory :many
+SELECT * FROM organization_plan_history
+WHERE organization_id = @organization_id
+    AND started_at < @end_time
+    AND (ended_at IS NULL OR ended_at > @start_time)
+ORDER BY started_at;
+
+-- name: GetCurrentPlanHistoryForUpdate :one
+SELECT * FROM organization_plan_history
+WHERE organization_id = $1 AND ended_at IS NULL
+FOR UPDATE;
+
+-- name: EndCurrentPlanHistory :execrows
+UPDATE organization_plan_history
+SET ended_at = $2
+WHERE organization_id = $1 AND ended_at IS NULL;
+
+-- name: CreatePlanHistory :one
+INSERT INTO organization_plan_history (
+    organization_id,
+    plan,
+    started_at,
+    prepaid_until,
+    proration_cycle_id,
+    changed_by,
+    trial,
+    billed_by_stripe
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING *;
+
+-- name: CreateScheduledPlanChange :one
+INSERT INTO scheduled_plan_changes (organization_id, from_plan, to_plan, effective_at, requested_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetPendingPlanChange :one
+SELECT * FROM scheduled_plan_changes
+WHERE organization_id = $1 AND status = 'pending';
+
+-- name: CancelPendingPlanChange :execrows
+UPDATE scheduled_plan_changes
+SET status = 'cancelled', cancelled_at = NOW()
+WHERE organization_id = $1 AND status = 'pending';
+
+-- name: ListDuePlanChanges :many
+SELECT * FROM scheduled_plan_changes
+WHERE status = 'pending' AND effective_at <= $1
+ORDER BY effective_at;
+
+-- Only a pending change is applied, so a change cancelled meanwhile is not
+-- name: ApplyPlanChange :one
+UPDATE scheduled_plan_changes
+SET status = 'applied', applied_at = NOW()
+WHERE id = $1 AND status = 'pending'
+RETURNING *;
 
 -- ============================================
 -- BILLING CYCLE QUERIES
 -- ============================================
 
+-- Returns no row if the organization already has a cycle of this kind for
+-- the period
 -- name: CreateBillingCycle :one
 INSERT INTO billing_cycles (
     organization_id,
@@ -188,18 +1301,55 @@ INSERT INTO billing_cycles (
     period_end,
     total_requests,
     total_amount,
-    status
+    status,
+    price_book_version,
+    quote,
+    invoice_number,
+    currency,
+    fx_rate,
+    fx_rate_at,
+    kind
 )
-VALUES ($1, $2, $3, $4, $5, $6)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT (organization_id, period_start, kind) DO NOTHING
 RETURNING *;
 
 -- name: GetBillingCycle :one
 SELECT * FROM billing_cycles
 WHERE id = $1;
 
+-- The invoice of an upgrade to plan in the period ending at period_end that
+-- is not yet paid and applied. A retried upgrade charges it again rather
+-- than issuing another.
+-- name: GetPendingUpgradeInvoice :one
+SELECT * FROM billing_cycles bc
+WHERE bc.organization_id = @organization_id
+    AND bc.kind = 'proration'
+    AND bc.status = 'pending'
+    AND bc.quote->>'plan' = @plan::text
+    AND bc.period_end = @period_end
+    AND NOT EXISTS (
+        SELECT 1 FROM organization_plan_history h
+        WHERE h.proration_cycle_id = bc.id
+    )
+ORDER BY bc.created_at DESC
+LIMIT 1;
+
+-- How many upgrades to plan in the period ending at period_end had their
+-- charge declined
+-- name: CountVoidUpgradeInvoices :one
+SELECT COUNT(*) FROM billing_cycles
+WHERE organization_id = @organization_id
+    AND kind = 'proration'
+    AND status = 'void'
+    AND quote->>'plan' = @plan::text
+    AND period_end = @period_end;
+
+-- Proration invoices also fall within the period; only its own invoice counts
 -- name: GetCurrentBillingCycle :one
 SELECT * FROM billing_cycles
 WHERE organization_id = $1
+    AND kind = 'period'
     AND period_start <= NOW()
     AND period_end >= NOW()
 ORDER BY period_start DESC
@@ -211,9 +1361,43 @@ WHERE organization_id = $1
 ORDER BY period_start DESC
 LIMIT $2 OFFSET $3;
 
+-- name: NextInvoiceNumber :one
+INSERT INTO invoice_number_sequences (issuer, year, last_number)
+VALUES ($1, $2, 1)
+ON CONFLICT (issuer, year) DO UPDATE
+SET last_number = invoice_number_sequences.last_number + 1
+RETURNING last_number;
+
+-- name: NextPaymentAttempt :one
+INSERT INTO invoice_payment_attempts (billing_cycle_id, attempts)
+VALUES ($1, 1)
+ON CONFLICT (billing_cycle_id) DO UPDATE
+SET attempts = invoice_payment_attempts.attempts + 1
+RETURNING attempts;
+
+-- name: CreateInvoiceLineItem :one
+INSERT INTO invoice_line_items (
+    billing_cycle_id,
+    position,
+    type,
+    description,
+    quantity,
+    unit_price,
+    amount
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: ListInvoiceLineItems :many
+SELECT * FROM invoice_line_items
+WHERE billing_cycle_id = ANY(sqlc.arg(billing_cycle_ids)::uuid[])
+ORDER BY billing_cycle_id, position;
+
 -- name: UpdateBillingCycleStatus :one
 UPDATE billing_cycles
-SET status = $1
+SET
+    status = $1,
+    paid_at = CASE WHEN $1 = 'paid' THEN COALESCE(paid_at, NOW()) ELSE paid_at END
 WHERE id = $2
 RETURNING *;
 
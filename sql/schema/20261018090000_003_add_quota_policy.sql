@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Create quota policy enum
+CREATE TYPE quota_policy AS ENUM ('block', 'overage', 'notify');
+
+-- What happens once an organization uses up its monthly request quota
+ALTER TABLE organizations ADD COLUMN quota_policy quota_policy NOT NULL DEFAULT 'block';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE organizations DROP COLUMN IF EXISTS quota_policy;
+DROP TYPE IF EXISTS quota_policy;
+
+-- +goose StatementEnd
@@ -0,0 +1,57 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Hourly request counts per API key, endpoint and status class (2 = 2xx, 4 = 4xx, ...)
+CREATE TABLE usage_rollups_hourly (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    endpoint VARCHAR(255) NOT NULL,
+    status_class SMALLINT NOT NULL,
+    bucket TIMESTAMP NOT NULL,
+    request_count BIGINT NOT NULL,
+    PRIMARY KEY (organization_id, bucket, api_key_id, endpoint, status_class)
+);
+
+-- Daily totals derived from the hourly rollups
+CREATE TABLE usage_rollups_daily (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    endpoint VARCHAR(255) NOT NULL,
+    status_class SMALLINT NOT NULL,
+    day DATE NOT NULL,
+    request_count BIGINT NOT NULL,
+    PRIMARY KEY (organization_id, day, api_key_id, endpoint, status_class)
+);
+
+-- Everything in usage_records before rolled_up_to is reflected in the rollups.
+-- Readers use the rollups up to this point and raw records after it.
+CREATE TABLE usage_rollup_state (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    rolled_up_to TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Backfill completed hours so the rollups are usable straight away
+INSERT INTO usage_rollups_hourly (organization_id, api_key_id, endpoint, status_class, bucket, request_count)
+SELECT organization_id, api_key_id, endpoint, (status_code / 100)::smallint, date_trunc('hour', created_at), COUNT(*)
+FROM usage_records
+WHERE created_at < date_trunc('hour', NOW()::timestamp)
+GROUP BY organization_id, api_key_id, endpoint, (status_code / 100)::smallint, date_trunc('hour', created_at);
+
+INSERT INTO usage_rollups_daily (organization_id, api_key_id, endpoint, status_class, day, request_count)
+SELECT organization_id, api_key_id, endpoint, status_class, DATE(bucket), SUM(request_count)
+FROM usage_rollups_hourly
+GROUP BY organization_id, api_key_id, endpoint, status_class, DATE(bucket);
+
+INSERT INTO usage_rollup_state (id, rolled_up_to) VALUES (1, date_trunc('hour', NOW()::timestamp));
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS usage_rollup_state;
+DROP TABLE IF EXISTS usage_rollups_daily;
+DROP TABLE IF EXISTS usage_rollups_hourly;
+
+-- +goose StatementEnd
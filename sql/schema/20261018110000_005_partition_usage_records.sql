@@ -0,0 +1,88 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Move the existing table aside so its names can be reused
+ALTER TABLE usage_records RENAME TO usage_records_unpartitioned;
+ALTER TABLE usage_records_unpartitioned RENAME CONSTRAINT usage_records_pkey TO usage_records_unpartitioned_pkey;
+DROP INDEX IF EXISTS idx_usage_records_organization_id;
+DROP INDEX IF EXISTS idx_usage_records_api_key_id;
+DROP INDEX IF EXISTS idx_usage_records_created_at;
+DROP INDEX IF EXISTS idx_usage_records_org_created;
+
+-- Usage Records table, partitioned by month. The partition key has to be part
+-- of the primary key.
+CREATE TABLE usage_records (
+    id UUID NOT NULL DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    endpoint VARCHAR(255) NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    status_code INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+-- One partition per month from the oldest record to three months ahead.
+-- After this the scheduler keeps future partitions in place.
+DO $$
+DECLARE
+    month_start DATE := date_trunc('month', COALESCE((SELECT MIN(created_at) FROM usage_records_unpartitioned), NOW()))::date;
+    last_month DATE := (date_trunc('month', NOW()) + INTERVAL '3 months')::date;
+BEGIN
+    WHILE month_start <= last_month LOOP
+        EXECUTE format(
+            'CREATE TABLE %I PARTITION OF usage_records FOR VALUES FROM (%L) TO (%L)',
+            'usage_records_' || to_char(month_start, 'YYYY_MM'),
+            month_start,
+            (month_start + INTERVAL '1 month')::date
+        );
+        month_start := (month_start + INTERVAL '1 month')::date;
+    END LOOP;
+END $$;
+
+INSERT INTO usage_records (id, organization_id, api_key_id, endpoint, method, status_code, created_at)
+SELECT id, organization_id, api_key_id, endpoint, method, status_code, created_at
+FROM usage_records_unpartitioned;
+
+DROP TABLE usage_records_unpartitioned;
+
+-- Indexes are created per partition, so each one stays a month in size.
+-- organization_id alone is covered by the composite index.
+CREATE INDEX idx_usage_records_org_created ON usage_records(organization_id, created_at);
+CREATE INDEX idx_usage_records_api_key_id ON usage_records(api_key_id);
+CREATE INDEX idx_usage_records_created_at ON usage_records(created_at);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE usage_records RENAME TO usage_records_partitioned;
+ALTER TABLE usage_records_partitioned RENAME CONSTRAINT usage_records_pkey TO usage_records_partitioned_pkey;
+DROP INDEX IF EXISTS idx_usage_records_org_created;
+DROP INDEX IF EXISTS idx_usage_records_api_key_id;
+DROP INDEX IF EXISTS idx_usage_records_created_at;
+
+CREATE TABLE usage_records (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    endpoint VARCHAR(255) NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    status_code INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO usage_records (id, organization_id, api_key_id, endpoint, method, status_code, created_at)
+SELECT id, organization_id, api_key_id, endpoint, method, status_code, created_at
+FROM usage_records_partitioned;
+
+-- Dropping the parent drops every partition with it
+DROP TABLE usage_records_partitioned;
+
+CREATE INDEX idx_usage_records_organization_id ON usage_records(organization_id);
+CREATE INDEX idx_usage_records_api_key_id ON usage_records(api_key_id);
+CREATE INDEX idx_usage_records_created_at ON usage_records(created_at);
+CREATE INDEX idx_usage_records_org_created ON usage_records(organization_id, created_at);
+
+-- +goose StatementEnd
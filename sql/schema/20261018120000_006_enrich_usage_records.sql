@@ -0,0 +1,60 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Request metadata captured by the usage tracking middleware. Added to the
+-- partitioned parent, so every partition picks the columns up.
+ALTER TABLE usage_records
+    ADD COLUMN duration_ms INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN request_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN response_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN client_ip VARCHAR(45),
+    ADD COLUMN user_agent VARCHAR(512),
+    ADD COLUMN request_id VARCHAR(64),
+    ADD COLUMN message_type VARCHAR(32),
+    ADD COLUMN units INTEGER NOT NULL DEFAULT 1;
+
+-- Totals carried into the rollups so units and bandwidth survive retention
+ALTER TABLE usage_rollups_hourly
+    ADD COLUMN units BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN request_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN response_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN duration_ms BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE usage_rollups_daily
+    ADD COLUMN units BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN request_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN response_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN duration_ms BIGINT NOT NULL DEFAULT 0;
+
+-- Every request recorded so far counts as one unit
+UPDATE usage_rollups_hourly SET units = request_count;
+UPDATE usage_rollups_daily SET units = request_count;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE usage_rollups_daily
+    DROP COLUMN IF EXISTS units,
+    DROP COLUMN IF EXISTS request_bytes,
+    DROP COLUMN IF EXISTS response_bytes,
+    DROP COLUMN IF EXISTS duration_ms;
+
+ALTER TABLE usage_rollups_hourly
+    DROP COLUMN IF EXISTS units,
+    DROP COLUMN IF EXISTS request_bytes,
+    DROP COLUMN IF EXISTS response_bytes,
+    DROP COLUMN IF EXISTS duration_ms;
+
+ALTER TABLE usage_records
+    DROP COLUMN IF EXISTS duration_ms,
+    DROP COLUMN IF EXISTS request_bytes,
+    DROP COLUMN IF EXISTS response_bytes,
+    DROP COLUMN IF EXISTS client_ip,
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS request_id,
+    DROP COLUMN IF EXISTS message_type,
+    DROP COLUMN IF EXISTS units;
+
+-- +goose StatementEnd
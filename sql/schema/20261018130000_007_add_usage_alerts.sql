@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TYPE usage_alert_metric AS ENUM ('request_volume', 'error_rate');
+CREATE TYPE usage_alert_direction AS ENUM ('spike', 'drop');
+CREATE TYPE usage_alert_status AS ENUM ('open', 'acknowledged');
+
+-- Hours where an organization's traffic deviated from its own baseline.
+-- observed and expected are request counts for request_volume and
+-- percentages for error_rate.
+CREATE TABLE usage_alerts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    metric usage_alert_metric NOT NULL,
+    direction usage_alert_direction NOT NULL,
+    window_start TIMESTAMP NOT NULL,
+    window_end TIMESTAMP NOT NULL,
+    observed DOUBLE PRECISION NOT NULL,
+    expected DOUBLE PRECISION NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    status usage_alert_status NOT NULL DEFAULT 'open',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    acknowledged_at TIMESTAMP,
+    acknowledged_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    -- A re-run of the detection job must not raise the same alert twice
+    UNIQUE (organization_id, metric, window_start)
+);
+
+CREATE INDEX idx_usage_alerts_organization_created ON usage_alerts(organization_id, created_at DESC);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS usage_alerts;
+DROP TYPE IF EXISTS usage_alert_status;
+DROP TYPE IF EXISTS usage_alert_direction;
+DROP TYPE IF EXISTS usage_alert_metric;
+
+-- +goose StatementEnd
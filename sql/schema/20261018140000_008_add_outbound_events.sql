@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TYPE outbound_event_status AS ENUM ('pending', 'delivered', 'failed');
+
+-- Where an organization wants its events delivered. The secret signs every
+-- delivery so receivers can verify it came from us.
+CREATE TABLE webhook_endpoints (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(128) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Outbox of events for organizations' webhook endpoints. Rows are written in
+-- the same transaction as the change they describe and delivered by the
+-- scheduler with retries.
+CREATE TABLE outbound_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSONB NOT NULL,
+    status outbound_event_status NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX idx_outbound_events_due ON outbound_events(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_outbound_events_organization_created ON outbound_events(organization_id, created_at DESC);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS outbound_events;
+DROP TABLE IF EXISTS webhook_endpoints;
+DROP TYPE IF EXISTS outbound_event_status;
+
+-- +goose StatementEnd
@@ -0,0 +1,47 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TYPE budget_metric AS ENUM ('requests', 'spend');
+
+-- A monthly budget per organization, as a request count or a USD amount.
+-- thresholds are percentages of amount that trigger a notification.
+CREATE TABLE usage_budgets (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    metric budget_metric NOT NULL DEFAULT 'requests',
+    amount NUMERIC(14, 2) NOT NULL CHECK (amount > 0),
+    thresholds INTEGER[] NOT NULL DEFAULT '{50,80,100}',
+    disable_keys_at_limit BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- One row per threshold crossed in a period, so each is notified exactly once
+CREATE TABLE usage_budget_notifications (
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    period_start TIMESTAMP NOT NULL,
+    threshold INTEGER NOT NULL,
+    usage NUMERIC(14, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, period_start, threshold)
+);
+
+-- Essential keys keep working when a budget disables the rest.
+-- suspended_at marks keys disabled by a budget, so they can be restored.
+ALTER TABLE api_keys
+    ADD COLUMN essential BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN suspended_at TIMESTAMP;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE api_keys
+    DROP COLUMN IF EXISTS essential,
+    DROP COLUMN IF EXISTS suspended_at;
+
+DROP TABLE IF EXISTS usage_budget_notifications;
+DROP TABLE IF EXISTS usage_budgets;
+DROP TYPE IF EXISTS budget_metric;
+
+-- +goose StatementEnd
@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TYPE usage_export_format AS ENUM ('csv', 'ndjson');
+CREATE TYPE usage_export_status AS ENUM ('pending', 'running', 'completed', 'failed', 'expired');
+
+-- Raw usage exports requested through the API. The scheduler writes the file
+-- and the API serves it through a signed, time-limited download URL.
+CREATE TABLE usage_exports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    requested_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    format usage_export_format NOT NULL,
+    start_time TIMESTAMP NOT NULL,
+    end_time TIMESTAMP NOT NULL,
+    status usage_export_status NOT NULL DEFAULT 'pending',
+    row_count BIGINT NOT NULL DEFAULT 0,
+    file_size BIGINT NOT NULL DEFAULT 0,
+    file_path TEXT,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX idx_usage_exports_organization_created ON usage_exports(organization_id, created_at DESC);
+CREATE INDEX idx_usage_exports_queued ON usage_exports(created_at) WHERE status IN ('pending', 'running');
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS usage_exports;
+DROP TYPE IF EXISTS usage_export_status;
+DROP TYPE IF EXISTS usage_export_format;
+
+-- +goose StatementEnd
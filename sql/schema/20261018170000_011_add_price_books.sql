@@ -0,0 +1,85 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Published price books. A book applies from effective_from until the next
+-- book takes effect; plans holds the per-plan prices as JSON in the same
+-- shape as the PRICE_BOOK_FILE entries.
+CREATE TABLE price_books (
+    version VARCHAR(64) PRIMARY KEY,
+    effective_from TIMESTAMP NOT NULL UNIQUE,
+    currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+    plans JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- The book an invoice was priced with
+ALTER TABLE billing_cycles
+    ADD COLUMN price_book_version VARCHAR(64);
+
+-- Message types carried into the rollups so usage can be priced by type.
+-- Rows rolled up before this migration have no type ('') and are priced at
+-- the plan's default unit rate.
+ALTER TABLE usage_rollups_hourly
+    ADD COLUMN message_type VARCHAR(32) NOT NULL DEFAULT '';
+
+ALTER TABLE usage_rollups_hourly
+    DROP CONSTRAINT usage_rollups_hourly_pkey,
+    ADD PRIMARY KEY (organization_id, bucket, api_key_id, endpoint, status_class, message_type);
+
+ALTER TABLE usage_rollups_daily
+    ADD COLUMN message_type VARCHAR(32) NOT NULL DEFAULT '';
+
+ALTER TABLE usage_rollups_daily
+    DROP CONSTRAINT usage_rollups_daily_pkey,
+    ADD PRIMARY KEY (organization_id, day, api_key_id, endpoint, status_class, message_type);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+-- Fold typed rows back together before restoring the narrower keys
+CREATE TEMP TABLE rollups_hourly_merged AS
+SELECT organization_id, api_key_id, endpoint, status_class, bucket,
+    SUM(request_count)::bigint AS request_count, SUM(units)::bigint AS units,
+    SUM(request_bytes)::bigint AS request_bytes, SUM(response_bytes)::bigint AS response_bytes,
+    SUM(duration_ms)::bigint AS duration_ms
+FROM usage_rollups_hourly
+GROUP BY organization_id, api_key_id, endpoint, status_class, bucket;
+
+CREATE TEMP TABLE rollups_daily_merged AS
+SELECT organization_id, api_key_id, endpoint, status_class, day,
+    SUM(request_count)::bigint AS request_count, SUM(units)::bigint AS units,
+    SUM(request_bytes)::bigint AS request_bytes, SUM(response_bytes)::bigint AS response_bytes,
+    SUM(duration_ms)::bigint AS duration_ms
+FROM usage_rollups_daily
+GROUP BY organization_id, api_key_id, endpoint, status_class, day;
+
+DELETE FROM usage_rollups_hourly;
+DELETE FROM usage_rollups_daily;
+
+ALTER TABLE usage_rollups_hourly
+    DROP CONSTRAINT usage_rollups_hourly_pkey,
+    DROP COLUMN message_type,
+    ADD PRIMARY KEY (organization_id, bucket, api_key_id, endpoint, status_class);
+
+ALTER TABLE usage_rollups_daily
+    DROP CONSTRAINT usage_rollups_daily_pkey,
+    DROP COLUMN message_type,
+    ADD PRIMARY KEY (organization_id, day, api_key_id, endpoint, status_class);
+
+INSERT INTO usage_rollups_hourly (organization_id, api_key_id, endpoint, status_class, bucket, request_count, units, request_bytes, response_bytes, duration_ms)
+SELECT * FROM rollups_hourly_merged;
+
+INSERT INTO usage_rollups_daily (organization_id, api_key_id, endpoint, status_class, day, request_count, units, request_bytes, response_bytes, duration_ms)
+SELECT * FROM rollups_daily_merged;
+
+DROP TABLE rollups_hourly_merged;
+DROP TABLE rollups_daily_merged;
+
+ALTER TABLE billing_cycles
+    DROP COLUMN IF EXISTS price_book_version;
+
+DROP TABLE IF EXISTS price_books;
+
+-- +goose StatementEnd
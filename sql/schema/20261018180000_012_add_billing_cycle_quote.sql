@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- The priced breakdown an invoice was generated from: base fee, usage by
+-- message type and, on tiered plans, how many units fell in each tier
+ALTER TABLE billing_cycles
+    ADD COLUMN quote JSONB;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE billing_cycles
+    DROP COLUMN IF EXISTS quote;
+
+-- +goose StatementEnd
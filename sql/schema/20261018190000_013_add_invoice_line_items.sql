@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TYPE invoice_line_item_type AS ENUM ('base_fee', 'usage', 'credit', 'tax', 'discount');
+
+-- The itemized charges behind an invoice's total_amount. Credits and
+-- discounts have negative amounts; the items of an invoice add up to its
+-- total.
+CREATE TABLE invoice_line_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    billing_cycle_id UUID NOT NULL REFERENCES billing_cycles(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    type invoice_line_item_type NOT NULL,
+    description TEXT NOT NULL,
+    quantity BIGINT NOT NULL DEFAULT 1,
+    unit_price DECIMAL(14, 6) NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (billing_cycle_id, position)
+);
+
+-- Invoices generated before itemization get a single line for their total
+INSERT INTO invoice_line_items (billing_cycle_id, position, type, description, quantity, unit_price, amount)
+SELECT id, 1, 'usage', 'Usage (not itemized)', 1, total_amount, total_amount
+FROM billing_cycles;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS invoice_line_items;
+DROP TYPE IF EXISTS invoice_line_item_type;
+
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- When an invoice was paid, shown on receipts. Invoices paid before this
+-- was recorded keep a NULL.
+ALTER TABLE billing_cycles
+    ADD COLUMN paid_at TIMESTAMP;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE billing_cycles
+    DROP COLUMN IF EXISTS paid_at;
+
+-- +goose StatementEnd
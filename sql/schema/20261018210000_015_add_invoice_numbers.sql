@@ -0,0 +1,51 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- One gap-free sequence of invoice numbers per issuer and year. The row is
+-- locked while a number is taken, so numbers are only used up by invoices
+-- that commit.
+CREATE TABLE invoice_number_sequences (
+    issuer VARCHAR(16) NOT NULL,
+    year INTEGER NOT NULL,
+    last_number BIGINT NOT NULL,
+    PRIMARY KEY (issuer, year)
+);
+
+ALTER TABLE billing_cycles
+    ADD COLUMN invoice_number VARCHAR(32);
+
+-- Number existing invoices in the order they were issued. The issuer is the
+-- default, INV: a migration cannot read INVOICE_ISSUER, so deployments with
+-- invoices from before this must keep it at INV.
+WITH numbered AS (
+    SELECT
+        id,
+        EXTRACT(YEAR FROM created_at)::INTEGER AS year,
+        ROW_NUMBER() OVER (PARTITION BY EXTRACT(YEAR FROM created_at) ORDER BY created_at, id) AS n
+    FROM billing_cycles
+)
+UPDATE billing_cycles bc
+SET invoice_number = 'INV-' || numbered.year || '-' || LPAD(numbered.n::TEXT, GREATEST(LENGTH(numbered.n::TEXT), 6), '0')
+FROM numbered
+WHERE bc.id = numbered.id;
+
+INSERT INTO invoice_number_sequences (issuer, year, last_number)
+SELECT 'INV', EXTRACT(YEAR FROM created_at)::INTEGER, COUNT(*)
+FROM billing_cycles
+GROUP BY EXTRACT(YEAR FROM created_at);
+
+ALTER TABLE billing_cycles
+    ALTER COLUMN invoice_number SET NOT NULL,
+    ADD CONSTRAINT billing_cycles_invoice_number_key UNIQUE (invoice_number);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE billing_cycles
+    DROP COLUMN IF EXISTS invoice_number;
+
+DROP TABLE IF EXISTS invoice_number_sequences;
+
+-- +goose StatementEnd
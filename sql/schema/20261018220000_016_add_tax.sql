@@ -0,0 +1,69 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Where an organization is taxed. Exemptions are granted by operators, not
+-- by the organization itself.
+CREATE TABLE organization_tax_profiles (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    country VARCHAR(2) NOT NULL,
+    vat_id VARCHAR(32),
+    tax_exempt BOOLEAN NOT NULL DEFAULT false,
+    exemption_reason TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Standard rate of each jurisdiction from a date on. reverse_charge marks
+-- jurisdictions where business customers with a VAT ID account for the tax
+-- themselves when buying from abroad.
+CREATE TABLE tax_rates (
+    country VARCHAR(2) NOT NULL,
+    effective_from DATE NOT NULL,
+    name VARCHAR(32) NOT NULL,
+    rate DECIMAL(6, 3) NOT NULL CHECK (rate >= 0 AND rate < 100),
+    reverse_charge BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (country, effective_from)
+);
+
+INSERT INTO tax_rates (country, effective_from, name, rate, reverse_charge) VALUES
+    ('AT', '2025-01-01', 'VAT', 20, true),
+    ('BE', '2025-01-01', 'VAT', 21, true),
+    ('BG', '2025-01-01', 'VAT', 20, true),
+    ('CY', '2025-01-01', 'VAT', 19, true),
+    ('CZ', '2025-01-01', 'VAT', 21, true),
+    ('DE', '2025-01-01', 'VAT', 19, true),
+    ('DK', '2025-01-01', 'VAT', 25, true),
+    ('EE', '2025-01-01', 'VAT', 22, true),
+    ('EE', '2025-07-01', 'VAT', 24, true),
+    ('ES', '2025-01-01', 'VAT', 21, true),
+    ('FI', '2025-01-01', 'VAT', 25.5, true),
+    ('FR', '2025-01-01', 'VAT', 20, true),
+    ('GR', '2025-01-01', 'VAT', 24, true),
+    ('HR', '2025-01-01', 'VAT', 25, true),
+    ('HU', '2025-01-01', 'VAT', 27, true),
+    ('IE', '2025-01-01', 'VAT', 23, true),
+    ('IT', '2025-01-01', 'VAT', 22, true),
+    ('LT', '2025-01-01', 'VAT', 21, true),
+    ('LU', '2025-01-01', 'VAT', 17, true),
+    ('LV', '2025-01-01', 'VAT', 21, true),
+    ('MT', '2025-01-01', 'VAT', 18, true),
+    ('NL', '2025-01-01', 'VAT', 21, true),
+    ('PL', '2025-01-01', 'VAT', 23, true),
+    ('PT', '2025-01-01', 'VAT', 23, true),
+    ('RO', '2025-01-01', 'VAT', 19, true),
+    ('RO', '2025-08-01', 'VAT', 21, true),
+    ('SE', '2025-01-01', 'VAT', 25, true),
+    ('SI', '2025-01-01', 'VAT', 22, true),
+    ('SK', '2025-01-01', 'VAT', 23, true),
+    ('NG', '2025-01-01', 'VAT', 7.5, false);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS tax_rates;
+DROP TABLE IF EXISTS organization_tax_profiles;
+
+-- +goose StatementEnd
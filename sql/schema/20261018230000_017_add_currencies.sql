@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- The currency an organization's invoices are issued in
+ALTER TABLE organizations ADD COLUMN billing_currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+
+-- Exchange rates published from a point in time on. rate is how many units
+-- of quote_currency one unit of base_currency buys.
+CREATE TABLE fx_rates (
+    base_currency VARCHAR(3) NOT NULL,
+    quote_currency VARCHAR(3) NOT NULL,
+    rate DECIMAL(20, 10) NOT NULL CHECK (rate > 0),
+    effective_at TIMESTAMP NOT NULL,
+    source VARCHAR(32) NOT NULL DEFAULT 'admin',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (base_currency, quote_currency, effective_at),
+    CHECK (base_currency <> quote_currency)
+);
+
+-- Invoices record the currency they were issued in and, when it differs from
+-- the price book's, the rate their prices were converted at
+ALTER TABLE billing_cycles
+    ADD COLUMN currency VARCHAR(3) NOT NULL DEFAULT 'USD',
+    ADD COLUMN fx_rate DECIMAL(20, 10),
+    ADD COLUMN fx_rate_at TIMESTAMP;
+
+UPDATE billing_cycles
+SET currency = COALESCE(NULLIF(quote->>'currency', ''), 'USD');
+
+-- Amounts in currencies such as NGN run several digits longer than in USD
+ALTER TABLE billing_cycles ALTER COLUMN total_amount TYPE DECIMAL(16, 2);
+ALTER TABLE invoice_line_items ALTER COLUMN amount TYPE DECIMAL(16, 2);
+ALTER TABLE invoice_line_items ALTER COLUMN unit_price TYPE DECIMAL(20, 6);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+-- Amounts are left wide, narrowing them could fail on stored invoices
+ALTER TABLE billing_cycles
+    DROP COLUMN IF EXISTS fx_rate_at,
+    DROP COLUMN IF EXISTS fx_rate,
+    DROP COLUMN IF EXISTS currency;
+DROP TABLE IF EXISTS fx_rates;
+ALTER TABLE organizations DROP COLUMN IF EXISTS billing_currency;
+
+-- +goose StatementEnd
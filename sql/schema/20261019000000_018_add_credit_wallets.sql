@@ -0,0 +1,105 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Accounts of the prepaid credit ledger. wallet is what an organization has
+-- prepaid and not yet spent, payments the money received for top-ups and
+-- receivables the invoices credit was spent on.
+CREATE TYPE credit_account AS ENUM ('wallet', 'payments', 'receivables');
+CREATE TYPE credit_direction AS ENUM ('debit', 'credit');
+CREATE TYPE credit_transaction_type AS ENUM ('top_up', 'invoice_payment');
+CREATE TYPE credit_top_up_status AS ENUM ('pending', 'completed', 'failed');
+
+-- An organization's prepaid credit settings. low_balance_threshold is in
+-- its billing currency.
+CREATE TABLE credit_wallets (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    low_balance_threshold DECIMAL(16, 2) CHECK (low_balance_threshold >= 0),
+    block_at_zero BOOLEAN NOT NULL DEFAULT FALSE,
+    low_balance_notified_at TIMESTAMP,
+    blocked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Top-ups started through a payment provider. Credit is only posted once
+-- the provider confirms the payment.
+CREATE TABLE credit_top_ups (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    provider VARCHAR(20) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    amount DECIMAL(16, 2) NOT NULL CHECK (amount > 0),
+    status credit_top_up_status NOT NULL DEFAULT 'pending',
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX idx_credit_top_ups_org ON credit_top_ups(organization_id, created_at DESC);
+
+-- One movement of credit. A top-up or invoice is posted at most once.
+CREATE TABLE credit_transactions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    type credit_transaction_type NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    amount DECIMAL(16, 2) NOT NULL CHECK (amount > 0),
+    description TEXT NOT NULL,
+    top_up_id UUID UNIQUE REFERENCES credit_top_ups(id),
+    billing_cycle_id UUID UNIQUE REFERENCES billing_cycles(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_credit_transactions_org ON credit_transactions(organization_id, created_at DESC);
+
+-- The double-entry postings of each transaction. Entries are never updated
+-- or deleted; balances are the sum of an account's entries.
+CREATE TABLE credit_ledger_entries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    transaction_id UUID NOT NULL REFERENCES credit_transactions(id) ON DELETE CASCADE,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    account credit_account NOT NULL,
+    direction credit_direction NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    amount DECIMAL(16, 2) NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_credit_ledger_entries_account ON credit_ledger_entries(organization_id, account, currency);
+
+-- Debits and credits of a transaction must add up by the time it commits
+CREATE FUNCTION check_credit_transaction_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (
+        SELECT COALESCE(SUM(CASE direction WHEN 'debit' THEN amount ELSE -amount END), 0)
+        FROM credit_ledger_entries
+        WHERE transaction_id = NEW.transaction_id
+    ) <> 0 THEN
+        RAISE EXCEPTION 'credit transaction % does not balance', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER credit_ledger_entries_balanced
+    AFTER INSERT ON credit_ledger_entries
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_credit_transaction_balanced();
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TRIGGER IF EXISTS credit_ledger_entries_balanced ON credit_ledger_entries;
+DROP FUNCTION IF EXISTS check_credit_transaction_balanced();
+DROP TABLE IF EXISTS credit_ledger_entries;
+DROP TABLE IF EXISTS credit_transactions;
+DROP TABLE IF EXISTS credit_top_ups;
+DROP TABLE IF EXISTS credit_wallets;
+DROP TYPE IF EXISTS credit_top_up_status;
+DROP TYPE IF EXISTS credit_transaction_type;
+DROP TYPE IF EXISTS credit_direction;
+DROP TYPE IF EXISTS credit_account;
+
+-- +goose StatementEnd
@@ -0,0 +1,62 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TYPE coupon_discount_type AS ENUM ('percentage', 'fixed');
+CREATE TYPE coupon_duration AS ENUM ('once', 'repeating', 'forever');
+
+-- Discounts organizations can redeem by code. Fixed amounts are in currency
+-- and converted to the invoice's currency; repeating coupons last
+-- duration_months invoices. An empty plans list applies to every plan.
+CREATE TABLE coupons (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code VARCHAR(50) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    discount_type coupon_discount_type NOT NULL,
+    percent_off DECIMAL(5, 2) CHECK (percent_off > 0 AND percent_off <= 100),
+    amount_off DECIMAL(16, 2) CHECK (amount_off > 0),
+    currency VARCHAR(3),
+    duration coupon_duration NOT NULL,
+    duration_months INTEGER CHECK (duration_months > 0),
+    plans TEXT[] NOT NULL DEFAULT '{}',
+    max_redemptions INTEGER CHECK (max_redemptions > 0),
+    times_redeemed INTEGER NOT NULL DEFAULT 0,
+    redeem_by TIMESTAMP,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (
+        (discount_type = 'percentage' AND percent_off IS NOT NULL AND amount_off IS NULL AND currency IS NULL)
+        OR (discount_type = 'fixed' AND amount_off IS NOT NULL AND currency IS NOT NULL AND percent_off IS NULL)
+    ),
+    CHECK ((duration = 'repeating') = (duration_months IS NOT NULL)),
+    CHECK (max_redemptions IS NULL OR times_redeemed <= max_redemptions)
+);
+
+-- A coupon redeemed by an organization. periods_remaining counts the
+-- invoices still discounted, NULL for forever; the redemption ends when it
+-- runs out. An organization has one discount at a time and redeems a coupon
+-- at most once.
+CREATE TABLE coupon_redemptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    coupon_id UUID NOT NULL REFERENCES coupons(id),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    redeemed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    periods_remaining INTEGER CHECK (periods_remaining >= 0),
+    redeemed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMP,
+    UNIQUE (coupon_id, organization_id)
+);
+
+CREATE UNIQUE INDEX idx_coupon_redemptions_active ON coupon_redemptions(organization_id) WHERE ended_at IS NULL;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS coupons;
+DROP TYPE IF EXISTS coupon_duration;
+DROP TYPE IF EXISTS coupon_discount_type;
+
+-- +goose StatementEnd
@@ -0,0 +1,64 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Period invoices are generated after each month. Proration invoices charge
+-- the base fee of a plan upgraded to during a period, when it is upgraded.
+CREATE TYPE billing_cycle_kind AS ENUM ('period', 'proration');
+
+ALTER TABLE billing_cycles
+    ADD COLUMN kind billing_cycle_kind NOT NULL DEFAULT 'period';
+
+-- The plans an organization has been on. The entry without ended_at is its
+-- current plan. The base fee from started_at to prepaid_until was invoiced
+-- in advance by the proration invoice of the upgrade.
+CREATE TABLE organization_plan_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    plan plan_type NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP,
+    prepaid_until TIMESTAMP,
+    proration_cycle_id UUID REFERENCES billing_cycles(id) ON DELETE SET NULL,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (ended_at IS NULL OR ended_at >= started_at)
+);
+
+CREATE UNIQUE INDEX idx_organization_plan_history_current ON organization_plan_history(organization_id) WHERE ended_at IS NULL;
+CREATE INDEX idx_organization_plan_history_org ON organization_plan_history(organization_id, started_at);
+
+INSERT INTO organization_plan_history (organization_id, plan, started_at)
+SELECT id, plan, created_at FROM organizations;
+
+-- Downgrades wait for the end of the period. An organization has at most
+-- one pending change.
+CREATE TYPE plan_change_status AS ENUM ('pending', 'applied', 'cancelled');
+
+CREATE TABLE scheduled_plan_changes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    from_plan plan_type NOT NULL,
+    to_plan plan_type NOT NULL,
+    effective_at TIMESTAMP NOT NULL,
+    status plan_change_status NOT NULL DEFAULT 'pending',
+    requested_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    applied_at TIMESTAMP,
+    cancelled_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_scheduled_plan_changes_pending ON scheduled_plan_changes(organization_id) WHERE status = 'pending';
+CREATE INDEX idx_scheduled_plan_changes_due ON scheduled_plan_changes(effective_at) WHERE status = 'pending';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS scheduled_plan_changes;
+DROP TYPE IF EXISTS plan_change_status;
+DROP TABLE IF EXISTS organization_plan_history;
+ALTER TABLE billing_cycles DROP COLUMN IF EXISTS kind;
+DROP TYPE IF EXISTS billing_cycle_kind;
+
+-- +goose StatementEnd
@@ -0,0 +1,64 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- An organization can try a paid plan once. While the trial is active it is
+-- on the trial plan; when the trial ends it is converted to the paid plan or
+-- returned to free.
+CREATE TYPE trial_status AS ENUM ('active', 'converted', 'expired');
+
+ALTER TABLE organizations
+    ADD COLUMN trial_status trial_status,
+    ADD COLUMN trial_plan plan_type,
+    ADD COLUMN trial_started_at TIMESTAMP,
+    ADD COLUMN trial_ends_at TIMESTAMP,
+    ADD COLUMN trial_reminded_at TIMESTAMP,
+    ADD CONSTRAINT organizations_trial_check CHECK (
+        trial_status IS NULL
+        OR (trial_plan IS NOT NULL AND trial_started_at IS NOT NULL AND trial_ends_at > trial_started_at)
+    );
+
+CREATE INDEX idx_organizations_trial_ends_at ON organizations(trial_ends_at) WHERE trial_status = 'active';
+
+-- Time spent on a plan as a trial is not invoiced
+ALTER TABLE organization_plan_history
+    ADD COLUMN trial BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- The card an organization saved for charges made without it present: a
+-- Stripe payment method of a Stripe customer, or a reusable Paystack
+-- authorization
+CREATE TABLE payment_methods (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    provider VARCHAR(20) NOT NULL,
+    customer_id TEXT,
+    reference TEXT NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    brand VARCHAR(50),
+    last4 VARCHAR(4),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (provider IN ('stripe', 'paystack'))
+);
+
+-- Stripe customers are kept once created so saving a new card reuses them
+ALTER TABLE organizations
+    ADD COLUMN stripe_customer_id TEXT;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE organizations DROP COLUMN IF EXISTS stripe_customer_id;
+DROP TABLE IF EXISTS payment_methods;
+ALTER TABLE organization_plan_history DROP COLUMN IF EXISTS trial;
+DROP INDEX IF EXISTS idx_organizations_trial_ends_at;
+ALTER TABLE organizations
+    DROP CONSTRAINT IF EXISTS organizations_trial_check,
+    DROP COLUMN IF EXISTS trial_reminded_at,
+    DROP COLUMN IF EXISTS trial_ends_at,
+    DROP COLUMN IF EXISTS trial_started_at,
+    DROP COLUMN IF EXISTS trial_plan,
+    DROP COLUMN IF EXISTS trial_status;
+DROP TYPE IF EXISTS trial_status;
+
+-- +goose StatementEnd
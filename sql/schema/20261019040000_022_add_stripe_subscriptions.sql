@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- An organization's Stripe subscription to its plan. Stripe invoices the
+-- plan's base fee and the usage reported on the metered item, so time on a
+-- plan billed this way is left off our own invoices.
+CREATE TABLE stripe_subscriptions (
+    organization_id UUID PRIMARY KEY REFERENCES organizations(id) ON DELETE CASCADE,
+    subscription_id TEXT NOT NULL UNIQUE,
+    customer_id TEXT NOT NULL,
+    plan plan_type NOT NULL,
+    status VARCHAR(30) NOT NULL,
+    base_item_id TEXT,
+    metered_item_id TEXT,
+    current_period_start TIMESTAMP,
+    current_period_end TIMESTAMP,
+    cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE,
+    -- When Stripe started billing the organization, and usage up to which
+    -- hour has been reported
+    billing_started_at TIMESTAMP,
+    usage_reported_through TIMESTAMP,
+    ended_at TIMESTAMP,
+    latest_invoice_id TEXT,
+    latest_invoice_status VARCHAR(30),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE organization_plan_history
+    ADD COLUMN billed_by_stripe BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE organization_plan_history DROP COLUMN IF EXISTS billed_by_stripe;
+DROP TABLE IF EXISTS stripe_subscriptions;
+
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- How many payments have been opened for each invoice. Paystack refuses a
+-- transaction reference it has seen before, so each attempt to pay an
+-- invoice is referenced by its invoice number and attempt.
+CREATE TABLE invoice_payment_attempts (
+    billing_cycle_id UUID PRIMARY KEY REFERENCES billing_cycles(id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS invoice_payment_attempts;
+
+-- +goose StatementEnd
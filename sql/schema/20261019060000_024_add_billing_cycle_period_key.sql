@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- An organization is invoiced once per period and kind. Two billing runs
+-- overlapping would otherwise both issue the month's invoice, each spending
+-- the same credit and coupon.
+--
+-- Billing runs before this could already have issued an invoice twice.
+-- Either copy may have been paid, credited or discounted, so they are not
+-- merged here: the migration stops and lists them to be settled by hand.
+DO $$
+DECLARE
+    periods INTEGER;
+    example TEXT;
+BEGIN
+    SELECT COUNT(*), MIN(invoices) INTO periods, example
+    FROM (
+        SELECT string_agg(invoice_number || ' (' || status || ')', ', ' ORDER BY created_at) AS invoices
+        FROM billing_cycles
+        GROUP BY organization_id, period_start, kind
+        HAVING COUNT(*) > 1
+    ) duplicates;
+
+    IF periods > 0 THEN
+        RAISE EXCEPTION '% billing periods were invoiced more than once, e.g. %', periods, example
+            USING HINT = 'Keep one invoice per organization, period and kind, deleting or crediting the others, then rerun the migration.';
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX billing_cycles_org_period_kind_key
+    ON billing_cycles(organization_id, period_start, kind);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS billing_cycles_org_period_kind_key;
+
+-- +goose StatementEnd
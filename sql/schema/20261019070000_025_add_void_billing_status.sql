@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- A void invoice is kept, so invoice numbers stay gap-free, but nothing is
+-- due on it. Upgrade invoices whose charge is declined are voided.
+ALTER TYPE billing_status ADD VALUE IF NOT EXISTS 'void';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+-- Postgres cannot drop a value from an enum, so void invoices are reopened
+-- and the value is left in place
+UPDATE billing_cycles SET status = 'pending' WHERE status = 'void';
+
+-- +goose StatementEnd